@@ -19,13 +19,18 @@
 package benchclient
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/nymtech/nym-mixnet/client"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
 const (
@@ -38,6 +43,45 @@ type timestampedMessage struct {
 	timestamp time.Time
 }
 
+// BenchResult summarises the results of the benchmark, so that they could be compared between the runs.
+type BenchResult struct {
+	// NumMessages is the number of messages the benchmark attempted to send.
+	NumMessages int `json:"num_messages"`
+	// Concurrency is the number of the senders the messages were split between.
+	Concurrency int `json:"concurrency"`
+	// SentMessages is the number of messages handed over for sending, while Errors is the number of those
+	// which failed to be encoded or sent. The results are biased if there were any errors.
+	SentMessages int `json:"sent_messages"`
+	Errors       int `json:"errors"`
+	// SetupDuration is the time it took to start the client, including the registration at its provider
+	// and pre-generating the packet, if requested.
+	SetupDuration time.Duration `json:"setup_duration_ns"`
+	// SendDuration is the time it took to send all the messages.
+	SendDuration time.Duration `json:"send_duration_ns"`
+	// Throughput is the number of sent messages per second.
+	Throughput float64 `json:"throughput"`
+}
+
+// Print writes the result to w, either in a human readable form or as JSON, so that it could be easily
+// processed by scripts.
+func (r BenchResult) Print(w io.Writer, jsonOutput bool) error {
+	if jsonOutput {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	_, err := fmt.Fprintf(w, "Messages: %v\nConcurrency: %v\nSent: %v\nErrors: %v\nSetup duration: %v\n"+
+		"Send duration: %v\nThroughput: %.2f msg/s\n",
+		r.NumMessages,
+		r.Concurrency,
+		r.SentMessages,
+		r.Errors,
+		r.SetupDuration,
+		r.SendDuration,
+		r.Throughput,
+	)
+	return err
+}
+
 type BenchClient struct {
 	*client.NetClient
 
@@ -46,46 +90,112 @@ type BenchClient struct {
 	interval           time.Duration
 	sentMessages       []timestampedMessage
 	pregen             bool
-	pregeneratedPacket []byte
+	pregeneratedPacket client.OutgoingPacket
+	// summaryFile is the file the timestamps of the sent messages are written to.
+	summaryFile string
+	// concurrency is the number of the senders sending the messages in parallel.
+	concurrency int
+	// mu guards sentMessages, as they are appended to by all the senders.
+	mu sync.Mutex
 }
 
-func (bc *BenchClient) sendMessages(n int, interval time.Duration) {
-	fmt.Printf("Going to try sending %v messages every %v\n", n, interval)
+// sendMessages sends n messages and returns the number of messages which could not be sent.
+// The messages are split between the concurrent senders, each sending its share every interval.
+// The failed messages are not retried, so that the timings of the benchmark would not be skewed by the retries.
+func (bc *BenchClient) sendMessages(n int, interval time.Duration) int {
+	fmt.Printf("Going to try sending %v messages every %v by %v senders\n", n, interval, bc.concurrency)
 	if bc.pregen {
-		fmt.Println("Going to be sending the pre-generated packet")
-		for i := 0; i < n; i++ {
-			bc.OutQueue() <- bc.pregeneratedPacket
-			bc.sentMessages[i] = timestampedMessage{
-				content:   payloadPrefix,
-				timestamp: time.Now(),
-			}
-			time.Sleep(interval)
+		// the bench provider accepts the replayed packet, but the mixes only do with their replay protection disabled
+		fmt.Println("Going to be sending the pre-generated packet, make sure the replay protection of the mixes is disabled")
+	}
+	if bc.concurrency == 1 {
+		return bc.sendShare(0, n, interval, bc.queuePacket)
+	}
+
+	// the senders send the packets on their own connections, as the outgoing queue would serialise them
+	failures := make(chan int, bc.concurrency)
+	var wg sync.WaitGroup
+	first := 0
+	for i := 0; i < bc.concurrency; i++ {
+		share := n / bc.concurrency
+		if i < n%bc.concurrency {
+			share++
 		}
-	} else {
-		for i := 0; i < n; i++ {
-			msg := fmt.Sprintf("%v%v", payloadPrefix, i)
+		wg.Add(1)
+		go func(first, share int) {
+			defer wg.Done()
+			sender := bc.NewSender()
+			defer sender.Close()
+			failures <- bc.sendShare(first, share, interval, sender.Send)
+		}(first, share)
+		first += share
+	}
+	wg.Wait()
+	close(failures)
+
+	failed := 0
+	for f := range failures {
+		failed += f
+	}
+	return failed
+}
+
+// queuePacket hands the packet over to the outgoing queue of the client.
+func (bc *BenchClient) queuePacket(packet client.OutgoingPacket) error {
+	bc.OutQueue() <- packet
+	return nil
+}
+
+// sendShare sends the messages numbered from first, count of them, every interval, with the given send function.
+// It returns the number of messages which could not be sent.
+func (bc *BenchClient) sendShare(first int,
+	count int,
+	interval time.Duration,
+	send func(client.OutgoingPacket) error,
+) int {
+	failed := 0
+	for i := first; i < first+count; i++ {
+		msg := payloadPrefix
+		packet := bc.pregeneratedPacket
+		if !bc.pregen {
+			msg = fmt.Sprintf("%v%v", payloadPrefix, i)
 			fmt.Println("Sending", msg)
-			if err := bc.SendMessage([]byte(msg), bc.recipient); err != nil {
-				// if there was error while sending message, we need to panic as otherwise the result might be biased
-				panic(err)
+			var err error
+			packet, err = bc.encodeMessage(msg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to encode %v: %v\n", msg, err)
+				failed++
+				continue
 			}
-			bc.sentMessages[i] = timestampedMessage{
-				content:   msg,
-				timestamp: time.Now(),
-			}
-
-			time.Sleep(interval)
 		}
+		if err := send(packet); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send %v: %v\n", msg, err)
+			failed++
+			continue
+		}
+		bc.mu.Lock()
+		bc.sentMessages = append(bc.sentMessages, timestampedMessage{
+			content:   msg,
+			timestamp: time.Now(),
+		})
+		bc.mu.Unlock()
+
+		time.Sleep(interval)
 	}
+	return failed
 }
 
 func (bc *BenchClient) createSummaryDoc() error {
 	fmt.Println("Creating summary doc")
-	f, err := os.Create(summaryFileName)
+	f, err := os.Create(bc.summaryFile)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	fmt.Fprintf(f, "Timestamp\tContent\n")
+	if len(bc.sentMessages) == 0 {
+		return nil
+	}
 	earliestMessageTimestamp := bc.sentMessages[0].timestamp
 	latestMessageTimestamp := bc.sentMessages[0].timestamp
 
@@ -109,46 +219,80 @@ func (bc *BenchClient) createSummaryDoc() error {
 	return nil
 }
 
-func (bc *BenchClient) RunBench() error {
+// RunBench starts the client and sends the benchmark messages, returning the timings of each phase.
+// The failures to send any of the messages do not stop the benchmark, but are counted in the result.
+// An error is returned if the client could not be started or the summary could not be written.
+func (bc *BenchClient) RunBench() (BenchResult, error) {
 	defer bc.Shutdown()
 	fmt.Println("starting bench client")
+	result := BenchResult{NumMessages: bc.numberMessages, Concurrency: bc.concurrency}
 
+	setupStart := time.Now()
 	if err := bc.NetClient.Start(); err != nil {
-		return err
+		return result, err
 	}
 	if bc.pregen {
-		if err := bc.pregeneratePacket(payloadPrefix, bc.recipient); err != nil {
-			return err
+		if err := bc.pregeneratePacket(payloadPrefix); err != nil {
+			return result, err
 		}
 	}
+	result.SetupDuration = time.Since(setupStart)
 
-	bc.sendMessages(bc.numberMessages, bc.interval)
+	sendStart := time.Now()
+	result.Errors = bc.sendMessages(bc.numberMessages, bc.interval)
+	result.SendDuration = time.Since(sendStart)
+	result.SentMessages = len(bc.sentMessages)
+	if result.SendDuration > 0 {
+		result.Throughput = float64(result.SentMessages) / result.SendDuration.Seconds()
+	}
 
 	if err := bc.createSummaryDoc(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (bc *BenchClient) pregeneratePacket(message string) error {
+	packet, err := bc.encodeMessage(message)
+	if err != nil {
 		return err
 	}
+	bc.pregeneratedPacket = packet
 	return nil
 }
 
-func (bc *BenchClient) pregeneratePacket(message string, recipient config.ClientConfig) error {
-	sphinxPacket, err := bc.EncodeMessage([]byte(message), recipient)
+// encodeMessage encodes the message for the benchmark recipient into a packet ready to be sent.
+func (bc *BenchClient) encodeMessage(message string) (client.OutgoingPacket, error) {
+	sphinxPacket, ingress, err := bc.EncodeMessage([]byte(message), bc.recipient)
 	if err != nil {
-		return err
+		return client.OutgoingPacket{}, err
 	}
 
 	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
 	if err != nil {
-		return err
+		return client.OutgoingPacket{}, err
 	}
-
-	bc.pregeneratedPacket = packetBytes
-	return nil
+	return client.OutgoingPacket{Data: packetBytes, Ingress: ingress}, nil
 }
 
-func NewBenchClient(nc *client.NetClient, numMsgs int, interval time.Duration, pregen bool) (*BenchClient, error) {
+// NewBenchClient creates a client sending numMsgs benchmark messages, split between the given number
+// of concurrent senders. If concurrency is not positive, all the messages are sent by a single sender.
+func NewBenchClient(nc *client.NetClient,
+	numMsgs int,
+	interval time.Duration,
+	pregen bool,
+	concurrency int,
+) (*BenchClient, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	_, benchmarkProviderKey, err := sphinx.GenerateDeterministicKeyPair([]byte(constants.BenchmarkProviderKeySeed))
+	if err != nil {
+		return nil, err
+	}
 	bc := &BenchClient{
 		NetClient:    nc,
-		sentMessages: make([]timestampedMessage, numMsgs),
+		sentMessages: make([]timestampedMessage, 0, numMsgs),
 		recipient: config.ClientConfig{
 			Id:   "BenchmarkClientRecipient",
 			Host: "localhost",
@@ -156,17 +300,18 @@ func NewBenchClient(nc *client.NetClient, numMsgs int, interval time.Duration, p
 			PubKey: []byte{21, 103, 130, 37, 105, 58, 162, 113, 91, 198, 76, 156, 194, 36, 45,
 				219, 121, 158, 255, 247, 44, 159, 243, 155, 215, 90, 67, 103, 64, 242, 95, 45},
 			Provider: &config.MixConfig{
-				Id:   "BenchmarkProvider",
-				Host: "localhost",
-				Port: "11000",
-				PubKey: []byte{17, 170, 15, 150, 155, 75, 240, 66, 54, 100, 131, 127, 193, 10,
-					133, 32, 62, 155, 9, 46, 200, 55, 60, 125, 223, 76, 170, 167, 100, 34, 176, 117},
+				Id:     "BenchmarkProvider",
+				Host:   "localhost",
+				Port:   "11000",
+				PubKey: benchmarkProviderKey.Bytes(),
 			},
 		},
 		numberMessages:     numMsgs,
 		interval:           interval,
+		concurrency:        concurrency,
 		pregen:             pregen,
-		pregeneratedPacket: nil,
+		pregeneratedPacket: client.OutgoingPacket{},
+		summaryFile:        summaryFileName,
 	}
 	return bc, nil
 }
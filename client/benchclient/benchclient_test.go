@@ -0,0 +1,275 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/client"
+	clientConfig "github.com/nymtech/nym-mixnet/client/config"
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider registers every client and counts the sphinx packets it receives,
+// as well as the streams they were sent over.
+type fakeProvider struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received int
+	streams  int
+}
+
+func startFakeProvider(t *testing.T) *fakeProvider {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *fakeProvider) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	isStream, err := config.IsFrameStream(r)
+	if err != nil {
+		return
+	}
+	if isStream {
+		p.mu.Lock()
+		p.streams++
+		p.mu.Unlock()
+		p.handleStream(r)
+		return
+	}
+	buff := make([]byte, 4096)
+	n, err := r.Read(buff)
+	if err != nil {
+		return
+	}
+	packet, err := config.UnwrapPacket(buff[:n])
+	if err != nil {
+		return
+	}
+	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+	case flags.AssignFlag:
+		token, err := config.WrapWithFlag(flags.TokenFlag, []byte("token"))
+		if err != nil {
+			return
+		}
+		w := bufio.NewWriter(conn)
+		if err := config.WriteFrame(w, token); err == nil {
+			w.Flush()
+		}
+	case flags.CommFlag:
+		p.mu.Lock()
+		p.received++
+		p.mu.Unlock()
+	}
+}
+
+// handleStream counts the sphinx packets streamed in frames, as sent by the client.
+func (p *fakeProvider) handleStream(r io.Reader) {
+	frames := config.NewFrameReader(r)
+	for {
+		frame, err := frames.Next()
+		if err != nil {
+			return
+		}
+		packet, err := config.UnwrapPacket(frame)
+		if err != nil {
+			return
+		}
+		if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.CommFlag {
+			p.mu.Lock()
+			p.received++
+			p.mu.Unlock()
+		}
+	}
+}
+
+// startDirectory serves the topology with a mix on each layer and the given provider with a registered client.
+func startDirectory(t *testing.T, provider *fakeProvider, providerKey *sphinx.PublicKey) *httptest.Server {
+	var topology models.Topology
+	for layer := uint(1); layer <= clientcore.DefaultPathLength; layer++ {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology.MixNodes = append(topology.MixNodes, models.MixNodePresence{
+			MixHostInfo: models.MixHostInfo{
+				HostInfo: models.HostInfo{Host: "127.0.0.1:1", PubKey: base64.URLEncoding.EncodeToString(pub.Bytes())},
+				Layer:    layer,
+			},
+		})
+	}
+	_, clientKey, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology.MixProviderNodes = []models.MixProviderPresence{{
+		MixProviderHostInfo: models.MixProviderHostInfo{
+			HostInfo: models.HostInfo{
+				Host:   provider.listener.Addr().String(),
+				PubKey: base64.URLEncoding.EncodeToString(providerKey.Bytes()),
+			},
+			RegisteredClients: []models.RegisteredClient{
+				{PubKey: base64.URLEncoding.EncodeToString(clientKey.Bytes())},
+			},
+		},
+	}}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(topology)
+	}))
+}
+
+// createBenchClient creates a bench client sending the messages through the fake provider,
+// along with the function cleaning up after it.
+func createBenchClient(t *testing.T, numMessages int, concurrency int) (*BenchClient, *fakeProvider, func()) {
+	provider := startFakeProvider(t)
+	_, providerKey, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := startDirectory(t, provider, providerKey)
+
+	cfg, err := clientConfig.DefaultConfig("BenchClientTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Logging.Disable = true
+	cfg.Debug.LoopCoverTrafficRate = 0.0
+	cfg.Debug.FetchMessageRate = 0.0
+	cfg.Debug.MessageSendingRate = 10000000.0
+	cfg.Debug.RateCompliantCoverMessagesDisabled = true
+	cfg.Client.DirectoryServerTopologyEndpoint = directory.URL
+	cfg.Client.ProviderID = base64.URLEncoding.EncodeToString(providerKey.Bytes())
+
+	prvKey, pubKey, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	netClient, err := client.NewTestClient(cfg, prvKey, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc, err := NewBenchClient(netClient, numMessages, 0, false, concurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "benchclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.summaryFile = filepath.Join(dir, summaryFileName)
+	return bc, provider, func() {
+		os.RemoveAll(dir)
+		directory.Close()
+		provider.listener.Close()
+	}
+}
+
+// assertReceived checks whether the fake provider eventually received exactly the given number of packets.
+func assertReceived(t *testing.T, provider *fakeProvider, numMessages int) {
+	assert.Eventually(t, func() bool {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		return provider.received == numMessages
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBenchClient_RunBench(t *testing.T) {
+	numMessages := 3
+	bc, provider, cleanup := createBenchClient(t, numMessages, 1)
+	defer cleanup()
+
+	result, err := bc.RunBench()
+	assert.Nil(t, err)
+	assert.Equal(t, numMessages, result.NumMessages)
+	assert.Equal(t, 1, result.Concurrency)
+	assert.Equal(t, numMessages, result.SentMessages)
+	assert.Zero(t, result.Errors)
+	assert.True(t, result.SetupDuration > 0)
+	assert.True(t, result.SendDuration > 0)
+	assert.True(t, result.Throughput > 0)
+	assert.FileExists(t, bc.summaryFile)
+	assertReceived(t, provider, numMessages)
+
+	var out bytes.Buffer
+	assert.Nil(t, result.Print(&out, true))
+	var decoded BenchResult
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, result, decoded)
+}
+
+func TestBenchClient_RunBench_Concurrent(t *testing.T) {
+	// the messages do not split evenly between the senders
+	numMessages := 10
+	bc, provider, cleanup := createBenchClient(t, numMessages, 4)
+	defer cleanup()
+
+	result, err := bc.RunBench()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, result.Concurrency)
+	assert.Equal(t, numMessages, result.SentMessages)
+	assert.Zero(t, result.Errors)
+	assertReceived(t, provider, numMessages)
+	// each sender streamed its packets over its own connection
+	provider.mu.Lock()
+	assert.Equal(t, 4, provider.streams)
+	provider.mu.Unlock()
+
+	// each message was sent exactly once
+	contents := make(map[string]bool)
+	for _, msg := range bc.sentMessages {
+		contents[msg.content] = true
+	}
+	assert.Len(t, contents, numMessages)
+}
+
+func TestNewBenchClient_Concurrency(t *testing.T) {
+	bc, _, cleanup := createBenchClient(t, 1, 0)
+	defer cleanup()
+	assert.Equal(t, 1, bc.concurrency)
+}
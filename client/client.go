@@ -18,10 +18,11 @@
 package client
 
 import (
+	"bufio"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math"
 	"net"
 	"os"
@@ -43,10 +44,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	loopLoad = "LoopCoverMessage"
-)
-
 // TODO: what is the point of this interface currently?
 // Client is the client networking interface
 type Client interface {
@@ -69,12 +66,18 @@ type NetClient struct {
 	// TODO: somehow rename or completely remove config.ClientConfig because it's waaaay too confusing right now
 	cfg              *clientConfig.Config
 	config           config.ClientConfig
-	token            []byte // TODO: combine with the 'Provider' field considering it's provider specific
-	outQueue         chan []byte
+	outQueue         chan OutgoingPacket
 	haltedCh         chan struct{}
 	haltOnce         sync.Once
 	log              *logrus.Logger
 	receivedMessages ReceivedMessages
+	// conns are the connections to the ingress providers the packets are streamed over.
+	conns *nodeConns
+	// networkState tracks whether the network was found not ready, so that only the changes get logged.
+	networkState struct {
+		sync.Mutex
+		notReady bool
+	}
 }
 
 func (c *NetClient) GetReceivedMessages() [][]byte {
@@ -91,9 +94,15 @@ func (c *NetClient) addNewMessage(msg []byte) {
 	c.receivedMessages.messages = append(c.receivedMessages.messages, msg)
 }
 
+// OutgoingPacket is a packet awaiting to be sent together with the ingress provider it has to be sent to.
+type OutgoingPacket struct {
+	Data    []byte
+	Ingress config.MixConfig
+}
+
 // OutQueue returns a reference to the client's outQueue. It's a queue
 // which holds outgoing packets while their order is randomised.
-func (c *NetClient) OutQueue() chan<- []byte {
+func (c *NetClient) OutQueue() chan<- OutgoingPacket {
 	return c.outQueue
 }
 
@@ -113,7 +122,7 @@ func getProvider(presences []models.MixProviderPresence, pubKey string) (models.
 // signalling whenever any operation was unsuccessful.
 func (c *NetClient) Start() error {
 
-	c.outQueue = make(chan []byte)
+	c.outQueue = make(chan OutgoingPacket)
 
 	initialTopology, err := topology.GetNetworkTopology(c.cfg.Client.DirectoryServerTopologyEndpoint)
 	if err != nil {
@@ -127,15 +136,13 @@ func (c *NetClient) Start() error {
 	if providerPresence, err = getProvider(initialTopology.MixProviderNodes, c.cfg.Client.ProviderID); err != nil {
 		return fmt.Errorf("specified provider does not seem to be online: %v", c.cfg.Client.ProviderID)
 	}
-	provider, err := topology.ProviderPresenceToConfig(providerPresence)
+	provider, err := topology.ProviderConfigFromModel(providerPresence)
 	// provider, err := providerFromTopology(initialTopology)
 	if err != nil {
 		return err
 	}
-	c.Provider = provider
-
 	for {
-		if err := c.sendRegisterMessageToProvider(); err != nil {
+		if err := c.Register(provider); err != nil {
 			c.log.Errorf("Error during registration to provider: %v", err)
 			time.Sleep(5 * time.Second)
 		} else {
@@ -183,6 +190,7 @@ func (c *NetClient) halt() {
 	// close any listeners, free resources, etc
 
 	close(c.haltedCh)
+	c.conns.close()
 }
 
 func (c *NetClient) UpdateNetworkView() error {
@@ -241,74 +249,116 @@ func (c *NetClient) SendMessage(message []byte, recipient config.ClientConfig) e
 	return nil
 }
 
+// Sender sends the packets straight away, bypassing the outgoing queue of the client and hence not respecting
+// the sending rate, over its own connections to the ingress providers. It is meant for the benchmarks, where many
+// packets have to be sent concurrently, as sending through the queue, or over the connections shared with it,
+// serialises them.
+type Sender struct {
+	client *NetClient
+	conns  *nodeConns
+}
+
+// NewSender creates a sender, which has to be closed once it is no longer needed.
+func (c *NetClient) NewSender() *Sender {
+	return &Sender{client: c, conns: newNodeConns()}
+}
+
+// Send sends the packet to its ingress provider.
+func (s *Sender) Send(packet OutgoingPacket) error {
+	return s.client.sendPacketOver(s.conns, packet)
+}
+
+// Close closes the connections of the sender.
+func (s *Sender) Close() {
+	s.conns.close()
+}
+
+// sendPacket streams the packet to its ingress provider over the connection kept to the provider,
+// which is only established if there is none yet or the previous one broke.
+func (c *NetClient) sendPacket(packet OutgoingPacket) error {
+	return c.sendPacketOver(c.conns, packet)
+}
+
+// sendPacketOver streams the packet to its ingress provider over the given connections.
+// If the packet could not be sent, the failure of the provider is reported, so that it is avoided for a while.
+func (c *NetClient) sendPacketOver(conns *nodeConns, packet OutgoingPacket) error {
+	if err := conns.send(net.JoinHostPort(packet.Ingress.Host, packet.Ingress.Port), packet.Data); err != nil {
+		c.log.Errorf("Error in sendPacket - failed to send to %v: %v", packet.Ingress.Id, err)
+		c.ReportNodeFailure(packet.Ingress)
+		return err
+	}
+	return nil
+}
+
 // encodeMessage encapsulates the given message into a sphinx packet destinated for recipient
 // and wraps with the flag pointing that it is the communication packet
-func (c *NetClient) encodeMessage(message []byte, recipient config.ClientConfig) ([]byte, error) {
-	sphinxPacket, err := c.EncodeMessage(message, recipient)
+func (c *NetClient) encodeMessage(message []byte, recipient config.ClientConfig) (OutgoingPacket, error) {
+	sphinxPacket, ingress, err := c.EncodeMessage(message, recipient)
 	if err != nil {
 		c.log.Errorf("Error in sending message - create sphinx packet returned an error: %v", err)
-		return nil, err
+		return OutgoingPacket{}, err
 	}
 
 	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
 	if err != nil {
 		c.log.Errorf("Error in sending message - wrap with flag returned an error: %v", err)
-		return nil, err
+		return OutgoingPacket{}, err
 	}
-	return packetBytes, nil
+	return OutgoingPacket{Data: packetBytes, Ingress: ingress}, nil
 }
 
-// Send opens a connection with selected network address
+// Send opens a connection with the given node
 // and send the passed packet. If connection failed or
-// the packet could not be send, an error is returned
-// Otherwise it returns the response sent by server
-func (c *NetClient) send(packet []byte, host string, port string) (config.ProviderResponse, error) {
+// the packet could not be send, an error is returned and the failure of the node is reported.
+// Otherwise each packet sent back by the server is passed to handlePacket
+// as soon as its frame is received. handlePacket can be nil if no response is expected.
+// If the server responds with an error, it is returned as *config.ProviderError.
+func (c *NetClient) send(packet []byte, node config.MixConfig, handlePacket func(config.GeneralPacket)) error {
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	conn, err := net.Dial("tcp", net.JoinHostPort(node.Host, node.Port))
 
 	if err != nil {
 		c.log.Errorf("Error in send - dial returned an error: %v", err)
-		return config.ProviderResponse{}, err
+		c.ReportNodeFailure(node)
+		return err
 	}
 	defer conn.Close()
 
 	if _, err := conn.Write(packet); err != nil {
 		c.log.Errorf("Failed to write to connection: %v", err)
-		return config.ProviderResponse{}, err
-	}
-
-	buff, err := ioutil.ReadAll(conn)
-	if err != nil {
-		c.log.Errorf("Failed to read response: %v", err)
-		return config.ProviderResponse{}, err
-	}
-
-	var resPacket config.ProviderResponse
-	if err = proto.Unmarshal(buff, &resPacket); err != nil {
-		c.log.Errorf("Error while unmarshalling received packet: %v", err)
-		return config.ProviderResponse{}, err
+		c.ReportNodeFailure(node)
+		return err
 	}
 
-	return resPacket, nil
-}
-
-// RegisterToken stores the authentication token received from the provider
-func (c *NetClient) registerToken(token []byte) {
-	c.token = token
-	c.log.Debugf("Registered token %s", c.token)
-}
-
-// ProcessPacket processes the received sphinx packet and returns the
-// encapsulated message or error in case the processing
-// was unsuccessful.
-func (c *NetClient) processPacket(packet []byte) ([]byte, error) {
+	frames := config.NewFrameReader(bufio.NewReader(conn))
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			c.log.Errorf("Failed to read response: %v", err)
+			return err
+		}
 
-	// c.log.Debugf(" Processing packet")
-	// c.log.Tracef("Removing first 37 bytes of the message")
-	if len(packet) > 38 {
-		return packet[38:], nil
+		resPacket, err := config.UnwrapPacket(frame)
+		if err != nil {
+			c.log.Errorf("Error while unmarshalling received packet: %v", err)
+			return err
+		}
+		if flags.PacketTypeFlagFromBytes(resPacket.Flag) == flags.ErrorFlag {
+			providerErr, err := config.UnwrapError(resPacket.Data)
+			if err != nil {
+				c.log.Errorf("Error while unmarshalling error response: %v", err)
+				return err
+			}
+			c.log.Errorf("The request was rejected: %v", providerErr)
+			return providerErr
+		}
+		if handlePacket != nil {
+			handlePacket(resPacket)
+		}
 	}
-	return packet, nil
 }
 
 func (c *NetClient) startTraffic() {
@@ -330,82 +380,104 @@ func (c *NetClient) startTraffic() {
 	}
 }
 
-// SendRegisterMessageToProvider allows the client to register with the selected provider.
-// The client sends a special assignment packet, with its public information, to the provider
-// or returns an error.
-func (c *NetClient) sendRegisterMessageToProvider() error {
-	c.log.Debugf("Sending request to provider to register")
-
-	confBytes, err := proto.Marshal(&c.config)
-	if err != nil {
-		c.log.Errorf("Error in register provider - marshal of provider config returned an error: %v", err)
-		return err
-	}
-
-	pktBytes, err := config.WrapWithFlag(flags.AssignFlag, confBytes)
-	if err != nil {
-		c.log.Errorf("Error in register provider - wrap with flag returned an error: %v", err)
-		return err
-	}
-
-	response, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port)
-	if err != nil {
-		c.log.Errorf("Error in register provider - send registration packet returned an error: %v", err)
-		return err
-	}
-
-	packets, err := config.UnmarshalProviderResponse(response)
-	if err != nil || len(packets) != 1 {
-		c.log.Errorf("error in register provider - failed to unmarshal response: %v", err)
-	}
-
-	c.registerToken(packets[0].Data)
-
-	return nil
-}
-
 // GetMessagesFromProvider allows to fetch messages from the inbox stored by the
 // provider. The client sends a pull packet to the provider, along with
-// the authentication token. An error is returned if occurred.
-func (c *NetClient) getMessagesFromProvider() error {
-	pullRqs := config.PullRequest{ClientPublicKey: c.GetPublicKey().Bytes(), Token: c.token}
+// the authentication token. The status of the inbox reported by the provider
+// at the end of its response is returned, along with an error if occurred.
+func (c *NetClient) getMessagesFromProvider() (config.InboxStatus, error) {
+	pullRqs := config.PullRequest{ClientPublicKey: c.GetPublicKey().Bytes(), Token: c.Token()}
 	pullRqsBytes, err := proto.Marshal(&pullRqs)
 	if err != nil {
 		c.log.Errorf("Error in register provider - marshal of pull request returned an error: %v", err)
-		return err
+		return config.InboxStatusUnknown, err
 	}
 
 	pktBytes, err := config.WrapWithFlag(flags.PullFlag, pullRqsBytes)
 	if err != nil {
 		c.log.Errorf("Error in register provider - marshal of provider config returned an error: %v", err)
-		return err
+		return config.InboxStatusUnknown, err
 	}
 
-	response, err := c.send(pktBytes, c.Provider.Host, c.Provider.Port)
-	if err != nil {
-		return err
+	// each message is processed as soon as it is received rather than after the entire inbox was sent
+	status := config.InboxStatusUnknown
+	handlePacket := func(packet config.GeneralPacket) {
+		if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.InboxStatusFlag {
+			status = c.handleInboxStatus(packet)
+			return
+		}
+		c.handleReceivedMessage(packet)
 	}
+	if err := c.send(pktBytes, c.Provider, handlePacket); err != nil {
+		return config.InboxStatusUnknown, err
+	}
+	return status, nil
+}
 
-	packets, err := config.UnmarshalProviderResponse(response)
+// handleInboxStatus interprets the status of the inbox concluding the response to a pull request.
+// InboxStatusUnknown is returned if the status could not be read.
+func (c *NetClient) handleInboxStatus(packet config.GeneralPacket) config.InboxStatus {
+	status, err := config.UnwrapInboxStatus(packet.Data)
 	if err != nil {
-		c.log.Errorf("error in register provider - failed to unmarshal response: %v", err)
+		c.log.Errorf("Error while unmarshalling the inbox status: %v", err)
+		return config.InboxStatusUnknown
+	}
+	switch status {
+	case config.InboxStatusNoInbox:
+		c.log.Warnf("The provider does not hold an inbox for the client")
+	case config.InboxStatusNotRegistered:
+		c.log.Warnf("The client is not registered with the provider")
+	case config.InboxStatusEmpty:
+		c.log.Debugf("The inbox is empty")
+	case config.InboxStatusDelivered:
+		c.log.Debugf("The messages stored in the inbox were delivered")
+	default:
+		c.log.Warnf("Received unknown inbox status %v", status)
+	}
+	return status
+}
+
+// handleReceivedMessage processes a single message sent by the provider in response to a pull request.
+// Loop cover messages and the dummy messages padding the response are discarded, while the metadata
+// attached by the sender is stripped from the others, so that only their bodies are stored.
+func (c *NetClient) handleReceivedMessage(packet config.GeneralPacket) {
+	if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.DummyFlag {
+		c.log.Debugf("Received dummy message")
+		return
+	}
+	// the provider stores the message as it was unwrapped from the sphinx packet at its last hop
+	packetData := packet.Data
+	packetDataStr := string(packetData)
+	if clientcore.IsLoopCoverMessage(packetData) {
+		c.log.Debugf("Received loop cover message %v", packetDataStr)
+		c.LoopReturned(packetData)
+		return
+	}
+	message, err := c.DecodeMessage(packetData)
+	if err != nil {
+		c.log.Warnf("Failed to decode the received message: %v", err)
+		return
 	}
-	for _, packet := range packets {
-		packetData, err := c.processPacket(packet.Data)
-		if err != nil {
-			c.log.Errorf("Error in processing received packet: %v", err)
-		}
-		packetDataStr := string(packetData)
-		switch packetDataStr {
-		case loopLoad:
-			c.log.Debugf("Received loop cover message %v", packetDataStr)
-		default:
-			c.log.Infof("Received new message: %v", packetDataStr)
-			c.addNewMessage(packetData)
-		}
+	c.log.Infof("Received new message: %v", string(message.Payload))
+	c.addNewMessage(message.Payload)
+}
+
+// enableLoopWatchdog starts tracking the loop cover messages, unless it is disabled in the config.
+func (c *NetClient) enableLoopWatchdog() error {
+	if c.cfg.Debug.LoopAlertThreshold < 0 {
+		return nil
 	}
+	return c.SetLoopWatchdog(c.cfg.Debug.LoopReturnWindowDuration(), c.cfg.Debug.LoopAlertThreshold, c.loopAlert)
+}
 
-	return nil
+// loopAlert is raised when too many loop cover messages failed to return.
+func (c *NetClient) loopAlert(stats clientcore.LoopStats) {
+	c.log.Errorf("%.0f%% of the recent loop cover messages did not return (%v sent, %v returned, %v missing). "+
+		"Possible security threat: the client may be under an (n-1) attack or cut off from the network.",
+		stats.MissingFraction*100,
+		stats.Sent,
+		stats.Returned,
+		stats.Missing,
+	)
 }
 
 // controlOutQueue controls the outgoing queue of the client.
@@ -419,24 +491,23 @@ func (c *NetClient) controlOutQueue() error {
 			c.log.Infof("Halting controlOutQueue")
 			return nil
 		case realPacket := <-c.outQueue:
-			response, err := c.send(realPacket, c.Provider.Host, c.Provider.Port)
-			if err != nil {
+			if err := c.sendPacket(realPacket); err != nil {
 				c.log.Errorf("Could not send real packet: %v", err)
 			}
 			c.log.Debugf("Real packet was sent")
-			c.log.Debugf("Received response: %v", response)
 		default:
 			if !c.cfg.Debug.RateCompliantCoverMessagesDisabled {
 				dummyPacket, err := c.createLoopCoverMessage()
+				if c.networkNotReady(err) {
+					break
+				}
 				if err != nil {
 					return err
 				}
-				response, err := c.send(dummyPacket, c.Provider.Host, c.Provider.Port)
-				if err != nil {
+				if err := c.sendPacket(dummyPacket); err != nil {
 					c.log.Errorf("Could not send dummy packet: %v", err)
 				}
 				c.log.Debugf("Dummy packet was sent")
-				c.log.Debugf("Received response: %v", response)
 			}
 		}
 		err := delayBeforeContinue(c.cfg.Debug.MessageSendingRate)
@@ -455,12 +526,21 @@ func (c *NetClient) controlMessagingFetching() {
 			c.log.Infof("Stopping controlMessagingFetching")
 			return
 		default:
-			if err := c.getMessagesFromProvider(); err != nil {
+			status, err := c.getMessagesFromProvider()
+			if err != nil {
 				c.log.Errorf("Could not get message from provider: %v", err)
 				continue
 			}
+			// the provider lost the registration of the client, for example after a restart
+			if status == config.InboxStatusNoInbox || status == config.InboxStatusNotRegistered {
+				if err := c.Register(c.Provider); err != nil {
+					c.log.Errorf("Could not register again at the provider: %v", err)
+				}
+			}
+			// all the loops which have returned by now have just been pulled
+			c.CheckLoops()
 			// c.log.Infof("Sent request to provider to fetch messages")
-			err := delayBeforeContinue(c.cfg.Debug.FetchMessageRate)
+			err = delayBeforeContinue(c.cfg.Debug.FetchMessageRate)
 			if err != nil {
 				c.log.Errorf("Error in ControlMessagingFetching - generating random exp. value failed: %v", err)
 			}
@@ -470,17 +550,51 @@ func (c *NetClient) controlMessagingFetching() {
 
 // createLoopCoverMessage packs a dummy loop message into
 // a sphinx packet. The loop message is destinated back to the sender
-// createLoopCoverMessage returns a byte representation of the encapsulated packet and an error
-func (c *NetClient) createLoopCoverMessage() ([]byte, error) {
-	sphinxPacket, err := c.EncodeMessage([]byte(loopLoad), c.config)
+// createLoopCoverMessage returns the encapsulated packet, along with its ingress provider, and an error
+func (c *NetClient) createLoopCoverMessage() (OutgoingPacket, error) {
+	sphinxPacket, ingress, err := c.EncodeLoopCoverMessage(c.config)
 	if err != nil {
-		return nil, err
+		return OutgoingPacket{}, err
 	}
 	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
 	if err != nil {
-		return nil, err
+		return OutgoingPacket{}, err
+	}
+	return OutgoingPacket{Data: packetBytes, Ingress: ingress}, nil
+}
+
+// networkNotReady checks whether the packet could not be created as the topology lacks the mixes for its path,
+// or a provider other than the one of the recipient for it to enter the network through, in which case
+// no packets can be sent at all. Rather than failing, the client then waits for the network
+// to become ready, refreshing the topology whenever it is due. As it is checked on every tick of the cover
+// traffic, only the changes of the state of the network are logged.
+func (c *NetClient) networkNotReady(err error) bool {
+	notReady := clientcore.IsInsufficientMixes(err) || err == clientcore.ErrNoDistinctProvider
+	changed := c.setNetworkNotReady(notReady)
+	if !notReady {
+		if changed && err == nil {
+			c.log.Infof("Network is ready again")
+		}
+		return false
+	}
+	if changed {
+		c.log.Warnf("Network is not ready, no packets will be sent until it is: %v", err)
+	} else {
+		c.log.Debugf("Network is still not ready, no packet was sent: %v", err)
 	}
-	return packetBytes, nil
+	if err := c.checkTopology(); err != nil {
+		c.log.Errorf("error in updating topology: %v", err)
+	}
+	return true
+}
+
+// setNetworkNotReady records whether the network is ready and returns whether that has changed.
+func (c *NetClient) setNetworkNotReady(notReady bool) bool {
+	c.networkState.Lock()
+	defer c.networkState.Unlock()
+	changed := c.networkState.notReady != notReady
+	c.networkState.notReady = notReady
+	return changed
 }
 
 // runLoopCoverTrafficStream manages the stream of loop cover traffic.
@@ -495,16 +609,16 @@ func (c *NetClient) runLoopCoverTrafficStream() error {
 			return nil
 		default:
 			loopPacket, err := c.createLoopCoverMessage()
-			if err != nil {
+			if !c.networkNotReady(err) && err != nil {
 				return err
 			}
-			response, err := c.send(loopPacket, c.Provider.Host, c.Provider.Port)
-			if err != nil {
-				c.log.Errorf("Could not send loop cover traffic message: %v", err)
-				return err
+			if err == nil {
+				if err := c.sendPacket(loopPacket); err != nil {
+					c.log.Errorf("Could not send loop cover traffic message: %v", err)
+					return err
+				}
+				c.log.Debugf("Loop message sent")
 			}
-			c.log.Debugf("Loop message sent")
-			c.log.Debugf("Received response: %v", response)
 
 			if err := delayBeforeContinue(c.cfg.Debug.LoopCoverTrafficRate); err != nil {
 				return err
@@ -544,13 +658,18 @@ func (c *NetClient) ReadInNetworkFromTopology(topologyData *models.Topology) err
 		c.log.Errorf("error while reading mixes from PKI: %v", err)
 		return err
 	}
+	providers, err := topology.GetProvidersPKI(topologyData.MixProviderNodes)
+	if err != nil {
+		c.log.Errorf("error while reading providers from PKI: %v", err)
+		return err
+	}
 	clients, err := topology.GetClientPKI(topologyData.MixProviderNodes)
 	if err != nil {
 		c.log.Errorf("error while reading clients from PKI: %v", err)
 		return err
 	}
 
-	c.Network.UpdateNetwork(mixes, clients)
+	c.Network.UpdateNetwork(mixes, providers, clients)
 
 	return nil
 }
@@ -564,7 +683,7 @@ func providerFromTopology(initialTopology *models.Topology) (config.MixConfig, e
 
 	for _, v := range initialTopology.MixProviderNodes {
 		// get the first entry
-		return topology.ProviderPresenceToConfig(v)
+		return topology.ProviderConfigFromModel(v)
 	}
 	return config.MixConfig{}, errors.New("unknown state")
 }
@@ -594,6 +713,23 @@ func NewClient(cfg *clientConfig.Config) (*NetClient, error) {
 		clientcore.NetworkPKI{},
 		baseLogger.GetLogger("cryptoClient "+cfg.Client.ID),
 	)
+	core.SetMaxDelay(cfg.Debug.MaxDelay)
+	if err := core.SetMaxPathLength(cfg.Debug.MaxPathLength); err != nil {
+		return nil, err
+	}
+	if err := core.SetPathLength(cfg.Debug.PathLength); err != nil {
+		return nil, err
+	}
+	delays, err := cfg.Debug.Delays()
+	if err != nil {
+		return nil, err
+	}
+	core.SetDelayDistribution(delays)
+	codec, err := cfg.Debug.Codec()
+	if err != nil {
+		return nil, err
+	}
+	core.SetPacketCodec(codec)
 
 	log := baseLogger.GetLogger(cfg.Client.ID)
 
@@ -601,10 +737,14 @@ func NewClient(cfg *clientConfig.Config) (*NetClient, error) {
 		cfg:      cfg,
 		haltedCh: make(chan struct{}),
 		log:      log,
+		conns:    newNodeConns(),
 		receivedMessages: ReceivedMessages{
 			messages: make([][]byte, 0, 20),
 		},
 	}
+	if err := c.enableLoopWatchdog(); err != nil {
+		return nil, err
+	}
 
 	c.log.Infof("Logging level set to %v", c.cfg.Logging.Level)
 
@@ -650,6 +790,10 @@ func NewTestClient(cfg *clientConfig.Config, prvKey *sphinx.PrivateKey, pubKey *
 		cfg:      cfg,
 		haltedCh: make(chan struct{}),
 		log:      disabledLog,
+		conns:    newNodeConns(),
+	}
+	if err := c.enableLoopWatchdog(); err != nil {
+		return nil, err
 	}
 
 	b64Key := base64.URLEncoding.EncodeToString(c.GetPublicKey().Bytes())
@@ -13,3 +13,243 @@
 // limitations under the License.
 
 package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	clientConfig "github.com/nymtech/nym-mixnet/client/config"
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestClient(t *testing.T) *NetClient {
+	cfg, err := clientConfig.DefaultConfig("TestClient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Logging.Disable = true
+	prvKey, pubKey, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTestClient(cfg, prvKey, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNetClient_HandleReceivedMessage_DiscardsDummies(t *testing.T) {
+	c := createTestClient(t)
+
+	// a padded response to a pull request of an inbox with 3 real messages
+	var response []config.GeneralPacket
+	var expected [][]byte
+	for i := 0; i < 3; i++ {
+		content := []byte(fmt.Sprintf("Hello world %v", i))
+		expected = append(expected, content)
+		response = append(response, config.GeneralPacket{Flag: flags.CommFlag.Bytes(), Data: content})
+	}
+	dummy := make([]byte, 64)
+	if _, err := rand.Read(dummy); err != nil {
+		t.Fatal(err)
+	}
+	response = append(response, config.GeneralPacket{Flag: flags.DummyFlag.Bytes(), Data: dummy})
+	response = append(response, config.GeneralPacket{Flag: flags.CommFlag.Bytes(),
+		Data: []byte(clientcore.LoopCoverPayload),
+	})
+
+	for _, packet := range response {
+		c.handleReceivedMessage(packet)
+	}
+	assert.Equal(t, expected, c.GetReceivedMessages())
+}
+
+func TestNetClient_HandleReceivedMessage_StripsMetadata(t *testing.T) {
+	c := createTestClient(t)
+
+	magic := []byte{0x00, 'M', 'D', 0x01}
+	// a message carrying the content-type=text metadata
+	withMetadata := append(append([]byte{}, magic...), 1, 12)
+	withMetadata = append(withMetadata, "content-type"...)
+	withMetadata = append(withMetadata, 4)
+	withMetadata = append(withMetadata, "text"...)
+	withMetadata = append(withMetadata, "Hello world"...)
+	// a plain message that merely starts with the magic is enveloped without any metadata
+	plain := append(append([]byte{}, magic...), "Hello world"...)
+	escaped := append(append(append([]byte{}, magic...), 0), plain...)
+	// the metadata is truncated
+	malformed := append(append([]byte{}, magic...), 1, 12, 'c')
+
+	for _, data := range [][]byte{withMetadata, escaped, malformed} {
+		c.handleReceivedMessage(config.GeneralPacket{Flag: flags.CommFlag.Bytes(), Data: data})
+	}
+	assert.Equal(t, [][]byte{[]byte("Hello world"), plain}, c.GetReceivedMessages())
+}
+
+// serveOnePull starts a fake provider answering a single request with the given frames.
+func serveOnePull(t *testing.T, frames ...[]byte) config.MixConfig {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request := make([]byte, 1024)
+		if _, err := conn.Read(request); err != nil {
+			return
+		}
+		for _, frame := range frames {
+			if err := config.WriteFrame(conn, frame); err != nil {
+				return
+			}
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.MixConfig{Host: host, Port: port}
+}
+
+func TestNetClient_GetMessagesFromProvider_InboxStatus(t *testing.T) {
+	message, err := config.WrapWithFlag(flags.CommFlag, []byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []config.InboxStatus{
+		config.InboxStatusDelivered,
+		config.InboxStatusEmpty,
+		config.InboxStatusNoInbox,
+		config.InboxStatusNotRegistered,
+	} {
+		statusBytes, err := config.WrapInboxStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := [][]byte{statusBytes}
+		if status == config.InboxStatusDelivered {
+			frames = [][]byte{message, statusBytes}
+		}
+
+		c := createTestClient(t)
+		c.Provider = serveOnePull(t, frames...)
+		received, err := c.getMessagesFromProvider()
+		assert.Nil(t, err)
+		assert.Equal(t, status, received)
+		if status == config.InboxStatusDelivered {
+			// the status itself is not mistaken for a message
+			assert.Equal(t, [][]byte{[]byte("Hello world")}, c.GetReceivedMessages())
+		} else {
+			assert.Empty(t, c.GetReceivedMessages())
+		}
+	}
+
+	// an old provider does not report the status at all
+	c := createTestClient(t)
+	c.Provider = serveOnePull(t, message)
+	received, err := c.getMessagesFromProvider()
+	assert.Nil(t, err)
+	assert.Equal(t, config.InboxStatusUnknown, received)
+}
+
+func TestNetClient_NetworkNotReady(t *testing.T) {
+	c := createTestClient(t)
+	newProvider := func(id string) config.MixConfig {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config.NewMixConfig(id, "localhost", "9999", pub.Bytes(), 0)
+	}
+	c.Provider = newProvider("Provider")
+	// the topology has providers, but no mixes at all, and is not due to be refreshed
+	c.Network.UpdateNetwork(nil, []config.MixConfig{c.Provider, newProvider("OtherProvider")}, nil)
+
+	_, err := c.createLoopCoverMessage()
+	assert.True(t, clientcore.IsInsufficientMixes(err))
+	assert.True(t, c.networkNotReady(err))
+
+	assert.False(t, c.networkNotReady(nil))
+	assert.False(t, c.networkNotReady(clientcore.ErrIncompatibleProvider))
+
+	// as is the topology with a single provider, which can't be both the ingress and the egress one
+	c.Network.UpdateNetwork(nil, []config.MixConfig{c.Provider}, nil)
+	_, err = c.createLoopCoverMessage()
+	assert.Equal(t, clientcore.ErrNoDistinctProvider, err)
+	assert.True(t, c.networkNotReady(err))
+}
+
+func TestNetClient_NetworkNotReady_LogsChanges(t *testing.T) {
+	c := createTestClient(t)
+	var logged bytes.Buffer
+	c.log.SetOutput(&logged)
+	c.log.SetLevel(logrus.InfoLevel)
+	notReady := &clientcore.InsufficientMixesError{Available: 0, Required: 3}
+
+	// the repeated checks of the network which is still not ready are not logged
+	for i := 0; i < 3; i++ {
+		assert.True(t, c.networkNotReady(notReady))
+	}
+	assert.Equal(t, 1, strings.Count(logged.String(), "Network is not ready"))
+
+	assert.False(t, c.networkNotReady(nil))
+	assert.False(t, c.networkNotReady(nil))
+	assert.Equal(t, 1, strings.Count(logged.String(), "Network is ready again"))
+}
+
+// unreachableNode returns a node nothing is listening on.
+func unreachableNode(t *testing.T, id string) config.MixConfig {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.NewMixConfig(id, host, port, pub.Bytes(), 0)
+}
+
+func TestNetClient_ReportsNodeFailures(t *testing.T) {
+	c := createTestClient(t)
+
+	ingress := unreachableNode(t, "Ingress")
+	assert.NotNil(t, c.sendPacket(OutgoingPacket{Data: []byte("foo"), Ingress: ingress}))
+	assert.True(t, c.RecentlyFailed(ingress))
+
+	c.Provider = unreachableNode(t, "Provider")
+	_, err := c.getMessagesFromProvider()
+	assert.NotNil(t, err)
+	assert.True(t, c.RecentlyFailed(c.Provider))
+
+	// a successful pull does not count as a failure
+	statusBytes, err := config.WrapInboxStatus(config.InboxStatusEmpty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Provider = serveOnePull(t, statusBytes)
+	_, err = c.getMessagesFromProvider()
+	assert.Nil(t, err)
+	assert.False(t, c.RecentlyFailed(c.Provider))
+}
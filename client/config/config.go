@@ -19,8 +19,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/nymtech/nym-mixnet/clientcore"
 	mainConfig "github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,6 +43,13 @@ const (
 	defaultLoopCoverTrafficRate = 10.0
 	defaultFetchMessageRate     = 10.0
 	defaultMessageSendingRate   = 10.0
+	defaultMaxDelay             = sphinx.DefaultMaxDelay
+	defaultPathLength           = clientcore.DefaultPathLength
+	defaultMaxPathLength        = clientcore.MaxPathLength
+	defaultDelayDistribution    = helpers.ExponentialDistribution
+	defaultPacketCodec          = "protobuf"
+	defaultLoopReturnWindow     = 60.0
+	defaultLoopAlertThreshold   = clientcore.DefaultLoopAlertThreshold
 
 	defaultDirectoryServerTopologyEndpoint      = mainConfig.DirectoryServerTopology
 	DefaultLocalDirectoryServerTopologyEndpoint = mainConfig.LocalDirectoryServerTopology
@@ -156,6 +167,8 @@ func (cfg *Client) validateAndApplyDefaults() error {
 	// for the rest, if left unspecified, use defaults
 	if len(cfg.DirectoryServerTopologyEndpoint) == 0 {
 		cfg.DirectoryServerTopologyEndpoint = defaultDirectoryServerTopologyEndpoint
+	} else if err := helpers.ValidateDirectoryURL(cfg.DirectoryServerTopologyEndpoint); err != nil {
+		return fmt.Errorf("config: %v: %v", err, cfg.DirectoryServerTopologyEndpoint)
 	}
 
 	// we're not checking for existence of the key files as if they do not exist, they're going to be generated
@@ -226,6 +239,52 @@ type Debug struct {
 	// waiting to be sent the actual sending rate is going be lower than the desired value
 	// thus decreasing the anonymity.
 	RateCompliantCoverMessagesDisabled bool `toml:"rate_compliant_cover_messages_disabled"`
+
+	// MaxDelay defines the maximum delay, in seconds, the client is going to request from any single hop.
+	// Any larger delays drawn from the exponential distribution are clamped to this value.
+	MaxDelay float64 `toml:"max_delay"`
+
+	// PathLength defines the number of mixes, excluding the providers, each packet is going to traverse.
+	// It can't exceed MaxPathLength.
+	PathLength int `toml:"path_length"`
+
+	// MaxPathLength defines the maximum number of mixes, excluding the providers, any path chosen by the client
+	// can consist of, however many layers the topology has. It can't exceed clientcore.MaxPathLength,
+	// as the sphinx header could not fit longer paths.
+	MaxPathLength int `toml:"max_path_length"`
+
+	// DelayDistribution defines the distribution the delays requested from each hop are drawn from.
+	// It is one of "exponential", "uniform", "pareto" or "constant". Loopix is designed for the exponential one,
+	// the others are meant for experiments only.
+	DelayDistribution string `toml:"delay_distribution"`
+
+	// DelayParameters defines the parameters of DelayDistribution: the rate of the exponential distribution,
+	// the minimum and maximum of the uniform one, the scale and shape of the Pareto one,
+	// or the delay of the constant one. All the delays are in seconds.
+	DelayParameters []float64 `toml:"delay_parameters"`
+
+	// PacketCodec defines the wire format of the sphinx packets, either "protobuf" or "compact".
+	// It has to match the one used by the nodes of the network.
+	PacketCodec string `toml:"packet_codec"`
+
+	// LoopReturnWindow defines for how long, in seconds, the client awaits each of its loop cover messages
+	// to return before considering it missing.
+	LoopReturnWindow float64 `toml:"loop_return_window"`
+
+	// LoopAlertThreshold defines the fraction of the recent loop cover messages which have to go missing
+	// for the client to raise an alert, as it may be subject to an (n-1) attack or cut off from the network.
+	// If set to a negative value, the loop cover messages will not be tracked.
+	LoopAlertThreshold float64 `toml:"loop_alert_threshold"`
+}
+
+// Delays returns the distribution the delays requested from each hop are drawn from.
+func (dCfg *Debug) Delays() (helpers.DelayDistribution, error) {
+	return helpers.NewDelayDistribution(dCfg.DelayDistribution, dCfg.DelayParameters...)
+}
+
+// Codec returns the wire format of the sphinx packets.
+func (dCfg *Debug) Codec() (sphinx.Codec, error) {
+	return sphinx.ParseCodec(dCfg.PacketCodec)
 }
 
 func (dCfg *Debug) applyDefaults() {
@@ -238,6 +297,57 @@ func (dCfg *Debug) applyDefaults() {
 	if dCfg.MessageSendingRate == 0.0 {
 		dCfg.MessageSendingRate = defaultMessageSendingRate
 	}
+	if dCfg.MaxDelay <= 0.0 {
+		dCfg.MaxDelay = defaultMaxDelay
+	}
+	if dCfg.PathLength == 0 {
+		dCfg.PathLength = defaultPathLength
+	}
+	if dCfg.MaxPathLength == 0 {
+		dCfg.MaxPathLength = defaultMaxPathLength
+	}
+	if dCfg.DelayDistribution == "" {
+		dCfg.DelayDistribution = defaultDelayDistribution
+		if len(dCfg.DelayParameters) == 0 {
+			dCfg.DelayParameters = []float64{clientcore.DefaultDelayRate}
+		}
+	}
+	if dCfg.PacketCodec == "" {
+		dCfg.PacketCodec = defaultPacketCodec
+	}
+	if dCfg.LoopReturnWindow <= 0.0 {
+		dCfg.LoopReturnWindow = defaultLoopReturnWindow
+	}
+	if dCfg.LoopAlertThreshold == 0.0 {
+		dCfg.LoopAlertThreshold = defaultLoopAlertThreshold
+	}
+}
+
+// LoopReturnWindowDuration returns LoopReturnWindow as a time.Duration.
+func (dCfg *Debug) LoopReturnWindowDuration() time.Duration {
+	return time.Duration(dCfg.LoopReturnWindow * float64(time.Second))
+}
+
+func (dCfg *Debug) validate() error {
+	if dCfg.MaxPathLength < 0 || dCfg.MaxPathLength > clientcore.MaxPathLength {
+		return fmt.Errorf("config: invalid maximum path length: %v (maximum is %v)",
+			dCfg.MaxPathLength,
+			clientcore.MaxPathLength,
+		)
+	}
+	if dCfg.PathLength < 0 || dCfg.PathLength > dCfg.MaxPathLength {
+		return fmt.Errorf("config: invalid path length: %v (maximum is %v)", dCfg.PathLength, dCfg.MaxPathLength)
+	}
+	if _, err := dCfg.Delays(); err != nil {
+		return fmt.Errorf("config: invalid delay distribution %q %v: %v", dCfg.DelayDistribution, dCfg.DelayParameters, err)
+	}
+	if _, err := dCfg.Codec(); err != nil {
+		return fmt.Errorf("config: invalid packet codec %q: %v", dCfg.PacketCodec, err)
+	}
+	if dCfg.LoopAlertThreshold > 1.0 {
+		return fmt.Errorf("config: invalid loop alert threshold: %v (maximum is 1)", dCfg.LoopAlertThreshold)
+	}
+	return nil
 }
 
 // DefaultDebugConfig returns default debug configuration.
@@ -247,6 +357,14 @@ func DefaultDebugConfig() *Debug {
 		FetchMessageRate:                   defaultFetchMessageRate,
 		MessageSendingRate:                 defaultMessageSendingRate,
 		RateCompliantCoverMessagesDisabled: false,
+		MaxDelay:                           defaultMaxDelay,
+		PathLength:                         defaultPathLength,
+		MaxPathLength:                      defaultMaxPathLength,
+		DelayDistribution:                  defaultDelayDistribution,
+		DelayParameters:                    []float64{clientcore.DefaultDelayRate},
+		PacketCodec:                        defaultPacketCodec,
+		LoopReturnWindow:                   defaultLoopReturnWindow,
+		LoopAlertThreshold:                 defaultLoopAlertThreshold,
 	}
 }
 
@@ -284,6 +402,10 @@ func (cfg *Config) validateAndApplyDefaults() error {
 	}
 	cfg.Debug.applyDefaults()
 
+	if err := cfg.Debug.validate(); err != nil {
+		return err
+	}
+
 	if cfg.Logging == nil {
 		cfg.Logging = DefaultLoggingConfig(cfg.Client.ID)
 	}
@@ -20,6 +20,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/nymtech/nym-mixnet/clientcore"
+	"github.com/nymtech/nym-mixnet/helpers"
 	"github.com/pelletier/go-toml"
 	"github.com/stretchr/testify/assert"
 )
@@ -129,6 +131,84 @@ func TestValidateClientBlock(t *testing.T) {
 
 	fullCfg.Client.ID = ""
 	assert.Error(t, fullCfg.validateAndApplyDefaults())
+
+	// Setting directory server endpoint that is not a valid URL
+	for _, endpoint := range []string{"localhost:8080", "ftp://localhost:8080", "http://", "foomp"} {
+		fullCfg, err = DefaultConfig(someID)
+		assert.NotNil(t, fullCfg)
+		assert.Nil(t, err)
+
+		fullCfg.Client.DirectoryServerTopologyEndpoint = endpoint
+		assert.Error(t, fullCfg.validateAndApplyDefaults(), "Endpoint %q should have been rejected", endpoint)
+	}
+}
+
+func TestValidateDebug(t *testing.T) {
+	someID := "foo"
+	for _, invalidPathLength := range []int{-1, clientcore.MaxPathLength + 1} {
+		fullCfg, err := DefaultConfig(someID)
+		assert.NotNil(t, fullCfg)
+		assert.Nil(t, err)
+
+		fullCfg.Debug.PathLength = invalidPathLength
+		assert.Error(t, fullCfg.validateAndApplyDefaults())
+	}
+	for _, invalidMaxPathLength := range []int{-1, clientcore.MaxPathLength + 1} {
+		fullCfg, err := DefaultConfig(someID)
+		assert.NotNil(t, fullCfg)
+		assert.Nil(t, err)
+
+		fullCfg.Debug.MaxPathLength = invalidMaxPathLength
+		assert.Error(t, fullCfg.validateAndApplyDefaults())
+	}
+	// the path can't be longer than the configured maximum
+	fullCfg, err := DefaultConfig(someID)
+	assert.NotNil(t, fullCfg)
+	assert.Nil(t, err)
+	fullCfg.Debug.MaxPathLength = 2
+	fullCfg.Debug.PathLength = 3
+	assert.Error(t, fullCfg.validateAndApplyDefaults())
+	fullCfg.Debug.PathLength = 2
+	assert.Nil(t, fullCfg.validateAndApplyDefaults())
+
+	invalidDelays := []struct {
+		distribution string
+		params       []float64
+	}{
+		{"exponential", []float64{0.0}},
+		{"exponential", nil},
+		{"uniform", []float64{2.0, 1.0}},
+		{"pareto", []float64{1.0}},
+		{"gaussian", []float64{1.0, 1.0}},
+	}
+	for _, delays := range invalidDelays {
+		fullCfg, err := DefaultConfig(someID)
+		assert.NotNil(t, fullCfg)
+		assert.Nil(t, err)
+
+		fullCfg.Debug.DelayDistribution = delays.distribution
+		fullCfg.Debug.DelayParameters = delays.params
+		assert.Error(t, fullCfg.validateAndApplyDefaults(), "Delays %v %v should have been rejected", delays.distribution, delays.params)
+	}
+
+	fullCfg, err = DefaultConfig(someID)
+	assert.Nil(t, err)
+	fullCfg.Debug.DelayDistribution = "constant"
+	fullCfg.Debug.DelayParameters = []float64{0.5}
+	assert.Nil(t, fullCfg.validateAndApplyDefaults())
+	delays, err := fullCfg.Debug.Delays()
+	assert.Nil(t, err)
+	assert.Equal(t, helpers.ConstantDelay{Delay: 0.5}, delays)
+
+	fullCfg.Debug.PacketCodec = "foomp"
+	assert.Error(t, fullCfg.validateAndApplyDefaults())
+
+	fullCfg, err = DefaultConfig(someID)
+	assert.Nil(t, err)
+	fullCfg.Debug.LoopAlertThreshold = 1.5
+	assert.Error(t, fullCfg.validateAndApplyDefaults())
+	fullCfg.Debug.LoopAlertThreshold = -1
+	assert.Nil(t, fullCfg.validateAndApplyDefaults())
 }
 
 func TestValidateLogging(t *testing.T) {
@@ -246,13 +326,16 @@ func TestWriteConfig(t *testing.T) {
 	outFilePath := filepath.Join(tmpDir, outFile)
 
 	fullCfg.Client.HomeDirectory = "/foomp/.nym"
-	fullCfg.Client.DirectoryServerTopologyEndpoint = "localhost:8080"
+	fullCfg.Client.DirectoryServerTopologyEndpoint = "http://localhost:8080/api/presence/topology"
 
 	// set some nondefault values
 	fullCfg.Logging.Disable = true
 	fullCfg.Logging.Level = "panic"
 
 	fullCfg.Debug.FetchMessageRate = 42.0
+	fullCfg.Debug.DelayDistribution = "uniform"
+	fullCfg.Debug.DelayParameters = []float64{0.001, 2}
+	fullCfg.Debug.PacketCodec = "compact"
 
 	assert.Nil(t, WriteConfigFile(outFilePath, fullCfg))
 
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/BurntSushi/toml"
@@ -29,12 +31,26 @@ var configTemplate *template.Template
 func init() {
 	var err error
 	if configTemplate, err = template.New("configFileTemplate").Funcs(template.FuncMap{
-		"FormatFloats": func(f float64) string { return fmt.Sprintf("%.2f", f) },
+		"FormatFloats":    func(f float64) string { return fmt.Sprintf("%.2f", f) },
+		"FormatFloatList": formatFloatList,
 	}).Parse(defaultConfigTemplate); err != nil {
 		panic(err)
 	}
 }
 
+// formatFloatList formats the floats as the elements of a TOML array without losing their precision.
+func formatFloatList(floats []float64) string {
+	formatted := make([]string, len(floats))
+	for i, f := range floats {
+		formatted[i] = strconv.FormatFloat(f, 'f', -1, 64)
+		// otherwise the value would be parsed as an integer
+		if !strings.Contains(formatted[i], ".") {
+			formatted[i] += ".0"
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
 // LoadBinary loads, parses and validates the provided buffer b (as a config)
 // and returns the Config.
 func LoadBinary(b []byte) (*Config, error) {
@@ -156,5 +172,39 @@ message_sending_rate = {{FormatFloats .Debug.MessageSendingRate }}
 # thus decreasing the anonymity.
 rate_compliant_cover_messages_disabled = {{ .Debug.RateCompliantCoverMessagesDisabled }}
 
+# The maximum delay, in seconds, the client is going to request from any single hop.
+# Any larger delays drawn from the exponential distribution are clamped to this value.
+max_delay = {{FormatFloats .Debug.MaxDelay }}
+
+# The number of mixes, excluding the providers, each packet is going to traverse.
+# Longer paths increase anonymity at the cost of latency.
+path_length = {{ .Debug.PathLength }}
+
+# The maximum number of mixes, excluding the providers, any path chosen by the client can consist of.
+# It can't exceed the number of hops the sphinx header can fit, minus the providers.
+max_path_length = {{ .Debug.MaxPathLength }}
+
+# The distribution the delays requested from each hop are drawn from: exponential, uniform, pareto or constant.
+# Loopix is designed for the exponential distribution, the other ones are meant for experiments only.
+delay_distribution = "{{ .Debug.DelayDistribution }}"
+
+# The parameters of the delay distribution: the rate of the exponential distribution,
+# the minimum and maximum of the uniform one, the scale and shape of the Pareto one,
+# or the delay of the constant one. All the delays are in seconds.
+delay_parameters = [{{FormatFloatList .Debug.DelayParameters }}]
+
+# The wire format of the sphinx packets, either protobuf or compact.
+# It has to match the one used by the nodes of the network.
+packet_codec = "{{ .Debug.PacketCodec }}"
+
+# For how long, in seconds, the client awaits each of its loop cover messages to return
+# before considering it missing.
+loop_return_window = {{FormatFloats .Debug.LoopReturnWindow }}
+
+# The fraction of the recent loop cover messages which have to go missing for the client to raise an alert,
+# as it may be subject to an (n-1) attack or cut off from the network.
+# If set to a negative value, the loop cover messages will not be tracked.
+loop_alert_threshold = {{FormatFloats .Debug.LoopAlertThreshold }}
+
 
 `
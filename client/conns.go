@@ -0,0 +1,118 @@
+// Copyright 2018-2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// connDialTimeout is how long establishing a connection to a node may take.
+const connDialTimeout = 10 * time.Second
+
+// nodeConns keeps a single connection to each of the nodes the client sends its packets to, over which the packets
+// are streamed in frames, so that rapid sends, including the cover traffic, would not establish a new connection
+// for each packet. The connections are re-established once writing to them fails.
+type nodeConns struct {
+	mu    sync.Mutex
+	conns map[string]*nodeConn
+	dial  func(address string) (net.Conn, error)
+}
+
+// nodeConn is the connection to a single node, which is nil until established or after it broke.
+// Its lock serialises the writes, so that the frames would never interleave.
+type nodeConn struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+func newNodeConns() *nodeConns {
+	return &nodeConns{conns: make(map[string]*nodeConn),
+		dial: func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, connDialTimeout)
+		},
+	}
+}
+
+func (nc *nodeConns) get(address string) *nodeConn {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	c, ok := nc.conns[address]
+	if !ok {
+		c = &nodeConn{}
+		nc.conns[address] = c
+	}
+	return c
+}
+
+// send writes the packet in a single frame to the node at the given address, reusing the connection
+// established by the previous sends. If writing to the reused connection fails, e.g. because the node
+// has closed it, it is replaced by a fresh one and the packet is written again. No response is read,
+// as the nodes never reply to a stream of packets.
+func (nc *nodeConns) send(address string, packet []byte) error {
+	var frame bytes.Buffer
+	if err := config.WriteFrame(&frame, packet); err != nil {
+		return err
+	}
+
+	c := nc.get(address)
+	c.Lock()
+	defer c.Unlock()
+	reused := c.conn != nil
+	err := c.write(nc.dial, address, frame.Bytes())
+	if err != nil && reused {
+		err = c.write(nc.dial, address, frame.Bytes())
+	}
+	return err
+}
+
+// write writes the frame to the connection, which is established first if needed. The connection is reset
+// if the write fails.
+func (c *nodeConn) write(dial func(address string) (net.Conn, error), address string, frame []byte) error {
+	if c.conn == nil {
+		conn, err := dial(address)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.reset()
+		return err
+	}
+	return nil
+}
+
+func (c *nodeConn) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// close closes all the connections. They are re-established by any subsequent sends.
+func (nc *nodeConns) close() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	for _, c := range nc.conns {
+		c.Lock()
+		c.reset()
+		c.Unlock()
+	}
+}
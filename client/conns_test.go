@@ -0,0 +1,165 @@
+// Copyright 2018-2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamNode accepts the connections of the client and records the frames streamed over them.
+type streamNode struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	frames   []string
+}
+
+func startStreamNode(t *testing.T) *streamNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &streamNode{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			n.conns = append(n.conns, conn)
+			n.mu.Unlock()
+			go n.handle(conn)
+		}
+	}()
+	return n
+}
+
+func (n *streamNode) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	if isStream, err := config.IsFrameStream(r); err != nil || !isStream {
+		return
+	}
+	frames := config.NewFrameReader(r)
+	for {
+		frame, err := frames.Next()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		n.frames = append(n.frames, string(frame))
+		n.mu.Unlock()
+	}
+}
+
+func (n *streamNode) address() string {
+	return n.listener.Addr().String()
+}
+
+// closeConns closes all the connections accepted so far, as a node restarting would.
+func (n *streamNode) closeConns() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+}
+
+func (n *streamNode) assertReceived(t *testing.T, conns int, frames ...string) {
+	assert.Eventually(t, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return len(n.conns) == conns && assert.ObjectsAreEqual(frames, n.frames)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNodeConns_Reuse(t *testing.T) {
+	node := startStreamNode(t)
+	defer node.listener.Close()
+	conns := newNodeConns()
+	defer conns.close()
+
+	packets := []string{"foo", "bar", "baz", "foomp"}
+	for _, packet := range packets {
+		assert.Nil(t, conns.send(node.address(), []byte(packet)))
+	}
+	node.assertReceived(t, 1, packets...)
+}
+
+func TestNodeConns_Reconnect(t *testing.T) {
+	node := startStreamNode(t)
+	defer node.listener.Close()
+	conns := newNodeConns()
+	defer conns.close()
+
+	assert.Nil(t, conns.send(node.address(), []byte("foo")))
+	assert.Nil(t, conns.send(node.address(), []byte("bar")))
+	node.assertReceived(t, 1, "foo", "bar")
+
+	// the broken connection is only noticed once writing to it fails, after which it is replaced
+	node.closeConns()
+	reconnected := func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return len(node.conns) == 2
+	}
+	for i := 0; i < 100 && !reconnected(); i++ {
+		assert.Nil(t, conns.send(node.address(), []byte("baz")))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, reconnected())
+	assert.Nil(t, conns.send(node.address(), []byte("foomp")))
+	assert.Eventually(t, func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return len(node.frames) > 2 && node.frames[len(node.frames)-1] == "foomp"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNodeConns_PerNode(t *testing.T) {
+	first, second := startStreamNode(t), startStreamNode(t)
+	defer first.listener.Close()
+	defer second.listener.Close()
+	conns := newNodeConns()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, conns.send(first.address(), []byte("foo")))
+		assert.Nil(t, conns.send(second.address(), []byte("bar")))
+	}
+	first.assertReceived(t, 1, "foo", "foo", "foo")
+	second.assertReceived(t, 1, "bar", "bar", "bar")
+
+	// closed connections are re-established by the subsequent sends
+	conns.close()
+	assert.Nil(t, conns.send(first.address(), []byte("baz")))
+	first.assertReceived(t, 2, "foo", "foo", "foo", "baz")
+	conns.close()
+}
+
+func TestNodeConns_Unreachable(t *testing.T) {
+	node := startStreamNode(t)
+	address := node.address()
+	node.listener.Close()
+
+	conns := newNodeConns()
+	assert.NotNil(t, conns.send(address, []byte("foo")))
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"errors"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// ErrUnsatisfiablePathConstraints is returned when no path can satisfy the path constraints of the client.
+var ErrUnsatisfiablePathConstraints = errors.New("path constraints can't be satisfied")
+
+// PathConstraints restrict the mixes chosen for the paths built by the client. The mixes are identified
+// by their ids, as advertised in the topology.
+type PathConstraints struct {
+	// Exclude lists the mixes never chosen, e.g. those known to misbehave.
+	Exclude []string
+	// Require lists the mixes every path goes through, e.g. the trusted ones. As each path has a single mix
+	// on each of its layers, at most one of them can be on any layer.
+	Require []string
+}
+
+// pathConstraints holds the PathConstraints as sets.
+type pathConstraints struct {
+	exclude map[string]struct{}
+	require map[string]struct{}
+}
+
+func newPathConstraints(constraints PathConstraints) (pathConstraints, error) {
+	c := pathConstraints{exclude: make(map[string]struct{}, len(constraints.Exclude)),
+		require: make(map[string]struct{}, len(constraints.Require)),
+	}
+	for _, id := range constraints.Exclude {
+		c.exclude[id] = struct{}{}
+	}
+	for _, id := range constraints.Require {
+		if _, ok := c.exclude[id]; ok {
+			return pathConstraints{}, ErrUnsatisfiablePathConstraints
+		}
+		c.require[id] = struct{}{}
+	}
+	return c, nil
+}
+
+// apply returns the mixes of a single layer which can be chosen for it: the required one if the layer
+// has any, otherwise all the mixes but the excluded ones. It returns ErrUnsatisfiablePathConstraints
+// if more than a single mix is required on the layer. The required mixes are added to placed.
+func (c pathConstraints) apply(layerMixes []config.MixConfig, placed map[string]struct{}) ([]config.MixConfig, error) {
+	allowed := make([]config.MixConfig, 0, len(layerMixes))
+	var required []config.MixConfig
+	for _, mix := range layerMixes {
+		if _, ok := c.require[mix.Id]; ok {
+			required = append(required, mix)
+		} else if _, ok := c.exclude[mix.Id]; !ok {
+			allowed = append(allowed, mix)
+		}
+	}
+	if len(required) > 1 {
+		return nil, ErrUnsatisfiablePathConstraints
+	}
+	if len(required) == 1 {
+		placed[required[0].Id] = struct{}{}
+		return required, nil
+	}
+	return allowed, nil
+}
+
+// satisfied checks whether all the required mixes have been placed on the path.
+func (c pathConstraints) satisfied(placed map[string]struct{}) bool {
+	for id := range c.require {
+		if _, ok := placed[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPathConstraints sets the constraints the mixes of the subsequently built paths have to satisfy.
+// The required mixes are always chosen, even if they have recently failed, as long as they are on one of
+// the layers of the path, advertise compatible sphinx parameters and are not one of the providers of the path.
+// Otherwise building the path fails with ErrUnsatisfiablePathConstraints, which is also returned
+// straight away if any mix is both excluded and required.
+func (c *CryptoClient) SetPathConstraints(constraints PathConstraints) error {
+	pc, err := newPathConstraints(constraints)
+	if err != nil {
+		return err
+	}
+	c.constraints = pc
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCryptoClient_PathConstraints_Exclude(t *testing.T) {
+	c, mixes, _ := createFailureTestClient(t, 3)
+	badMix := mixes[2][0]
+	assert.Nil(t, c.SetPathConstraints(PathConstraints{Exclude: []string{badMix.Id}}))
+
+	assert.Equal(t, 0, countSelections(t, c, mixes, badMix))
+	assert.Equal(t, sequenceSamples, countSelections(t, c, mixes, mixes[2][1]))
+}
+
+func TestCryptoClient_PathConstraints_Require(t *testing.T) {
+	c, mixes, _ := createFailureTestClient(t, 3)
+	trustedMix := mixes[3][1]
+	assert.Nil(t, c.SetPathConstraints(PathConstraints{Require: []string{trustedMix.Id}}))
+
+	assert.Equal(t, sequenceSamples, countSelections(t, c, mixes, trustedMix))
+
+	// even if it has recently failed
+	c.ReportNodeFailure(trustedMix)
+	assert.Equal(t, sequenceSamples, countSelections(t, c, mixes, trustedMix))
+}
+
+func TestCryptoClient_PathConstraints_Unsatisfiable(t *testing.T) {
+	c, mixes, _ := createFailureTestClient(t, 4)
+
+	assert.Equal(t, ErrUnsatisfiablePathConstraints, c.SetPathConstraints(PathConstraints{
+		Exclude: []string{mixes[1][0].Id},
+		Require: []string{mixes[1][0].Id},
+	}))
+
+	impossible := []PathConstraints{
+		// two mixes on the same layer
+		{Require: []string{mixes[1][0].Id, mixes[1][1].Id}},
+		// a mix on a layer beyond the path
+		{Require: []string{mixes[4][0].Id}},
+		// a mix which is not in the topology at all
+		{Require: []string{"Unknown"}},
+		// all the mixes of a layer
+		{Exclude: []string{mixes[2][0].Id, mixes[2][1].Id}},
+	}
+	for _, constraints := range impossible {
+		assert.Nil(t, c.SetPathConstraints(constraints))
+		_, err := c.getRandomMixSequence(mixes, 3)
+		assert.Error(t, err, "Constraints %+v should not have been satisfied", constraints)
+	}
+
+	// the required mix can't be one of the providers of the path either
+	assert.Nil(t, c.SetPathConstraints(PathConstraints{Require: []string{mixes[1][0].Id}}))
+	_, err := c.getRandomMixSequence(mixes, 3, mixes[1][0])
+	assert.Equal(t, ErrUnsatisfiablePathConstraints, err)
+}
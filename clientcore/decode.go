@@ -0,0 +1,87 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// ErrUnexpectedMessageFlag is returned when a pulled message is marked as neither a message nor a dummy.
+var ErrUnexpectedMessageFlag = errors.New("pulled message has unexpected flag")
+
+// DecodedMessage is a message pulled from the provider.
+type DecodedMessage struct {
+	// Index is the position of the message in the batch decoded by DecodeBatch.
+	Index int
+	// Payload is the body of the message, as sent by its sender.
+	Payload []byte
+	// Metadata is the metadata the sender attached to the message with EncodeMessageWithMetadata, if any.
+	Metadata map[string]string
+}
+
+// DecodeError is the error of decoding a single message of a batch.
+type DecodeError struct {
+	// Index is the position of the message in the decoded batch.
+	Index int
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("message %v: %v", e.Index, e.Err)
+}
+
+// DecodeBatch decodes the messages pulled from the provider, each given as the frame it was received in.
+// The messages are decoded independently, so that a corrupt one does not prevent the others from being read:
+// the decoded messages are returned in their order within the batch, along with a DecodeError for each of those
+// which could not be decoded. The dummy messages padding the response and the loop cover messages are discarded.
+func (c *CryptoClient) DecodeBatch(packets [][]byte) ([]DecodedMessage, []error) {
+	var messages []DecodedMessage
+	var errs []error
+	for i, packetBytes := range packets {
+		packet, err := config.UnwrapPacket(packetBytes)
+		if err != nil {
+			errs = append(errs, &DecodeError{Index: i, Err: err})
+			continue
+		}
+		switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+		case flags.DummyFlag:
+			continue
+		case flags.CommFlag:
+		default:
+			errs = append(errs, &DecodeError{Index: i, Err: ErrUnexpectedMessageFlag})
+			continue
+		}
+		// the provider stores the message as it was unwrapped from the sphinx packet at its last hop
+		if IsLoopCoverMessage(packet.Data) {
+			c.LoopReturned(packet.Data)
+			continue
+		}
+		message, err := c.DecodeMessage(packet.Data)
+		if err != nil {
+			errs = append(errs, &DecodeError{Index: i, Err: err})
+			continue
+		}
+		message.Index = i
+		messages = append(messages, message)
+	}
+	if len(errs) > 0 {
+		c.log.Warnf("Failed to decode %v out of %v pulled messages", len(errs), len(packets))
+	}
+	return messages, errs
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func wrapPulledMessage(t *testing.T, flag flags.PacketTypeFlag, data string) []byte {
+	packet, err := config.WrapWithFlag(flag, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestCryptoClient_DecodeBatch(t *testing.T) {
+	c, _, _ := createFailureTestClient(t, 1)
+
+	valid := wrapPulledMessage(t, flags.CommFlag, "Hello world")
+	batch := [][]byte{
+		valid,
+		valid[:3],
+		wrapPulledMessage(t, flags.DummyFlag, "padding"),
+		wrapPulledMessage(t, flags.CommFlag, LoopCoverPayload),
+		wrapPulledMessage(t, flags.TokenFlag, "token"),
+		wrapPulledMessage(t, flags.CommFlag, "Hello again"),
+	}
+
+	messages, errs := c.DecodeBatch(batch)
+	assert.Equal(t, []DecodedMessage{
+		{Index: 0, Payload: []byte("Hello world")},
+		{Index: 5, Payload: []byte("Hello again")},
+	}, messages)
+	assert.Equal(t, []error{
+		&DecodeError{Index: 1, Err: config.ErrInvalidPacketMagic},
+		&DecodeError{Index: 4, Err: ErrUnexpectedMessageFlag},
+	}, errs)
+
+	messages, errs = c.DecodeBatch(nil)
+	assert.Empty(t, messages)
+	assert.Empty(t, errs)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// DefaultFailureCooldown defines for how long a node is avoided after a send through it failed.
+const DefaultFailureCooldown = time.Minute
+
+// nodeFailures keeps track of the nodes through which sending has recently failed.
+// The nodes are identified by their public keys, as, unlike the ids, those are always unique.
+type nodeFailures struct {
+	sync.Mutex
+	cooldown time.Duration
+	failedAt map[string]time.Time
+	// now is used instead of time.Now so that the tests could control the passage of time
+	now func() time.Time
+}
+
+func newNodeFailures(cooldown time.Duration) *nodeFailures {
+	return &nodeFailures{
+		cooldown: cooldown,
+		failedAt: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (f *nodeFailures) report(node config.MixConfig) {
+	f.Lock()
+	defer f.Unlock()
+	f.failedAt[string(node.PubKey)] = f.now()
+}
+
+// isCoolingDown checks whether the node has failed within the cooldown period.
+// Nodes whose cooldown has passed are forgotten.
+func (f *nodeFailures) isCoolingDown(node config.MixConfig) bool {
+	f.Lock()
+	defer f.Unlock()
+	failedAt, ok := f.failedAt[string(node.PubKey)]
+	if !ok {
+		return false
+	}
+	if f.now().Sub(failedAt) >= f.cooldown {
+		delete(f.failedAt, string(node.PubKey))
+		return false
+	}
+	return true
+}
+
+// filterAvailable returns the mixes which are not cooling down. If all of them are,
+// all mixes are returned instead, as a possibly dead mix is better than not sending at all.
+func (f *nodeFailures) filterAvailable(mixes []config.MixConfig) []config.MixConfig {
+	available := make([]config.MixConfig, 0, len(mixes))
+	for _, mix := range mixes {
+		if !f.isCoolingDown(mix) {
+			available = append(available, mix)
+		}
+	}
+	if len(available) == 0 {
+		return mixes
+	}
+	return available
+}
+
+// ReportNodeFailure informs the client that sending through the given node has failed, for example
+// because it could not be connected to. The node is then excluded from the generated paths
+// for the duration of the cooldown period, unless there are no other nodes available on its layer.
+func (c *CryptoClient) ReportNodeFailure(node config.MixConfig) {
+	c.failures.report(node)
+}
+
+// RecentlyFailed checks whether a failure of the given node was reported within the cooldown period.
+func (c *CryptoClient) RecentlyFailed(node config.MixConfig) bool {
+	return c.failures.isCoolingDown(node)
+}
+
+// SetFailureCooldown sets for how long the nodes are avoided after a reported failure.
+func (c *CryptoClient) SetFailureCooldown(cooldown time.Duration) {
+	c.failures.Lock()
+	defer c.failures.Unlock()
+	c.failures.cooldown = cooldown
+}
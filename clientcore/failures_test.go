@@ -0,0 +1,115 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+const sequenceSamples = 100
+
+// createFailureTestClient creates a client with a controllable clock and a network with two mixes on each layer.
+func createFailureTestClient(t *testing.T, layers uint) (*CryptoClient, topology.LayeredMixes, *time.Time) {
+	baseDisabledLogger, err := logger.New("", "panic", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCryptoClient(priv, pub, config.MixConfig{}, NetworkPKI{}, baseDisabledLogger.GetLogger("test"))
+
+	now := time.Now()
+	c.failures.now = func() time.Time { return now }
+
+	mixes := make(topology.LayeredMixes)
+	for layer := uint(1); layer <= layers; layer++ {
+		for i := 0; i < 2; i++ {
+			_, mixPub, err := sphinx.GenerateKeyPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mix := config.NewMixConfig(fmt.Sprintf("Mix%d-%d", layer, i), "localhost", "1789", mixPub.Bytes(), layer)
+			mixes[layer] = append(mixes[layer], mix)
+		}
+	}
+	return c, mixes, &now
+}
+
+// countSelections returns how many times the mix was chosen in sequenceSamples generated sequences.
+func countSelections(t *testing.T, c *CryptoClient, mixes topology.LayeredMixes, mix config.MixConfig) int {
+	selected := 0
+	for i := 0; i < sequenceSamples; i++ {
+		sequence, err := c.getRandomMixSequence(mixes, len(mixes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequence[mix.Layer-1].Id == mix.Id {
+			selected++
+		}
+	}
+	return selected
+}
+
+func TestCryptoClient_ReportNodeFailure_AvoidedDuringCooldown(t *testing.T) {
+	c, mixes, now := createFailureTestClient(t, 3)
+	deadMix := mixes[2][0]
+
+	c.ReportNodeFailure(deadMix)
+	assert.True(t, c.RecentlyFailed(deadMix))
+	assert.Equal(t, 0, countSelections(t, c, mixes, deadMix))
+
+	*now = now.Add(DefaultFailureCooldown / 2)
+	assert.Equal(t, 0, countSelections(t, c, mixes, deadMix))
+
+	// once the cooldown has passed, the mix is used again
+	*now = now.Add(DefaultFailureCooldown)
+	assert.False(t, c.RecentlyFailed(deadMix))
+	assert.True(t, countSelections(t, c, mixes, deadMix) > 0)
+}
+
+func TestCryptoClient_ReportNodeFailure_CustomCooldown(t *testing.T) {
+	c, mixes, now := createFailureTestClient(t, 3)
+	deadMix := mixes[1][1]
+
+	c.SetFailureCooldown(time.Second)
+	c.ReportNodeFailure(deadMix)
+	assert.Equal(t, 0, countSelections(t, c, mixes, deadMix))
+
+	*now = now.Add(time.Second)
+	assert.True(t, countSelections(t, c, mixes, deadMix) > 0)
+}
+
+func TestCryptoClient_ReportNodeFailure_WholeLayerFailed(t *testing.T) {
+	c, mixes, _ := createFailureTestClient(t, 3)
+	for _, mix := range mixes[3] {
+		c.ReportNodeFailure(mix)
+	}
+
+	// rather than failing to create a path, the failed mixes are used anyway
+	sequence, err := c.getRandomMixSequence(mixes, 3)
+	assert.Nil(t, err)
+	assert.Len(t, sequence, 3)
+	assert.Equal(t, uint64(3), sequence[2].Layer)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// ErrUnsupportedFeature is returned when the provider does not support a packet type flag the client requires.
+var ErrUnsupportedFeature = errors.New("feature not supported by the provider")
+
+// ClientCapabilities returns what the client advertises in the hello exchange: the requests it sends
+// and the responses to them it handles.
+func ClientCapabilities() config.Capabilities {
+	return config.Capabilities{Version: config.ProtocolVersion,
+		Flags: []flags.PacketTypeFlag{flags.AssignFlag,
+			flags.CommFlag,
+			flags.PullFlag,
+			flags.RotateTokenFlag,
+			flags.HelloFlag,
+			flags.StatusFlag,
+			flags.TokenFlag,
+			flags.DummyFlag,
+			flags.ErrorFlag,
+			flags.InboxStatusFlag,
+		},
+	}
+}
+
+// Hello exchanges the capabilities with the given provider, requiring it to support the given flags.
+// The capabilities advertised by the provider are returned and stored, so that they could later be checked
+// with ProviderCapabilities. If the provider lacks any of the required flags, the error either is
+// a *config.ProviderError with config.ErrorCodeUnsupportedFeature or wraps ErrUnsupportedFeature.
+func (c *CryptoClient) Hello(provider config.MixConfig, required ...flags.PacketTypeFlag) (config.Capabilities, error) {
+	packetBytes, err := config.WrapHello(ClientCapabilities(), required)
+	if err != nil {
+		return config.Capabilities{}, err
+	}
+
+	data, err := request(provider, packetBytes, flags.HelloFlag)
+	if err != nil {
+		c.log.Errorf("Error in Hello - failed to exchange the capabilities: %v", err)
+		return config.Capabilities{}, err
+	}
+	capabilities, _, err := config.UnwrapHello(data)
+	if err != nil {
+		return config.Capabilities{}, err
+	}
+	c.providerCapabilities = capabilities
+
+	// the provider should have refused the hello itself, unless it does not understand the requirements
+	if missing := capabilities.Missing(required); len(missing) > 0 {
+		return capabilities, fmt.Errorf("%w: flags %#x", ErrUnsupportedFeature, missing)
+	}
+	c.log.Debugf("Provider speaks protocol version %v", capabilities.Version)
+	return capabilities, nil
+}
+
+// ProviderCapabilities returns the capabilities advertised by the provider in the last hello exchange,
+// which are empty if there was none.
+func (c *CryptoClient) ProviderCapabilities() config.Capabilities {
+	return c.providerCapabilities
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCryptoClient_Hello(t *testing.T) {
+	c, _, _ := createFailureTestClient(t, 1)
+	providerCapabilities := config.Capabilities{Version: config.ProtocolVersion,
+		Flags: []flags.PacketTypeFlag{flags.AssignFlag, flags.PullFlag, flags.HelloFlag},
+	}
+	response, err := config.WrapHello(providerCapabilities, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, requestCh := startFakeProviderFor(t, flags.HelloFlag, response)
+	capabilities, err := c.Hello(provider, flags.PullFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, providerCapabilities, capabilities)
+	assert.Equal(t, providerCapabilities, c.ProviderCapabilities())
+
+	clientCapabilities, required, err := config.UnwrapHello(<-requestCh)
+	assert.Nil(t, err)
+	assert.Equal(t, ClientCapabilities(), clientCapabilities)
+	assert.Equal(t, []flags.PacketTypeFlag{flags.PullFlag}, required)
+
+	// a provider not checking the requirements itself still has its capabilities checked by the client
+	provider, _ = startFakeProviderFor(t, flags.HelloFlag, response)
+	_, err = c.Hello(provider, flags.RotateTokenFlag)
+	assert.True(t, errors.Is(err, ErrUnsupportedFeature), "Unexpected error %v", err)
+
+	errorResponse, err := config.WrapError(config.ErrorCodeUnsupportedFeature, "required feature is not supported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, _ = startFakeProviderFor(t, flags.HelloFlag, errorResponse)
+	_, err = c.Hello(provider, flags.RotateTokenFlag)
+	assert.True(t, config.IsProviderError(err, config.ErrorCodeUnsupportedFeature), "Unexpected error %v", err)
+}
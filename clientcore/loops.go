@@ -0,0 +1,216 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLoopReturnWindow defines for how long a loop cover message is awaited before it is considered missing.
+	DefaultLoopReturnWindow = time.Minute
+	// DefaultLoopAlertThreshold defines the fraction of the recent loop cover messages which have to go missing
+	// for the watchdog to raise an alert.
+	DefaultLoopAlertThreshold = 0.5
+	// LoopWatchdogWindow defines the number of the most recently concluded loops the missing fraction is computed over.
+	LoopWatchdogWindow = 50
+	// minConcludedLoops is the number of concluded loops below which no alert is raised,
+	// so that a single lost loop right after starting does not trigger it.
+	minConcludedLoops = 10
+
+	loopIDLength = 8
+)
+
+// ErrInvalidLoopAlertThreshold is returned when the alert threshold is not a fraction in (0, 1].
+var ErrInvalidLoopAlertThreshold = errors.New("invalid loop alert threshold")
+
+// LoopStats summarises the loop cover messages tracked by the watchdog.
+type LoopStats struct {
+	// Sent, Returned and Missing are the numbers of the loops sent, returned within the return window
+	// and concluded missing since the watchdog was enabled.
+	Sent     uint64
+	Returned uint64
+	Missing  uint64
+	// Pending is the number of the loops still awaited.
+	Pending int
+	// MissingFraction is the fraction of the missing loops among the most recently concluded ones.
+	MissingFraction float64
+	// Alerting tells whether MissingFraction is at or above the alert threshold.
+	Alerting bool
+}
+
+// loopWatchdog keeps track of the loop cover messages awaited to return. A client which stops
+// receiving its own loops may be subject to an (n-1) attack or cut off from the network,
+// as the loops are indistinguishable from the real traffic to the mixes.
+type loopWatchdog struct {
+	sync.Mutex
+	returnWindow time.Duration
+	threshold    float64
+	alert        func(LoopStats)
+	// pending maps the ids of the awaited loops to when they were sent.
+	pending map[string]time.Time
+	// outcomes holds whether each of the most recently concluded loops went missing, as a ring buffer.
+	outcomes []bool
+	next     int
+	stats    LoopStats
+	// now is used instead of time.Now so that the tests could control the passage of time
+	now func() time.Time
+}
+
+func newLoopWatchdog(returnWindow time.Duration, threshold float64, alert func(LoopStats)) *loopWatchdog {
+	return &loopWatchdog{
+		returnWindow: returnWindow,
+		threshold:    threshold,
+		alert:        alert,
+		pending:      make(map[string]time.Time),
+		outcomes:     make([]bool, 0, LoopWatchdogWindow),
+		now:          time.Now,
+	}
+}
+
+func (w *loopWatchdog) sent(id string) {
+	w.Lock()
+	defer w.Unlock()
+	w.pending[id] = w.now()
+	w.stats.Sent++
+}
+
+// returned records the return of the loop. Loops returning after they were concluded missing are ignored.
+func (w *loopWatchdog) returned(id string) {
+	w.Lock()
+	if _, ok := w.pending[id]; !ok {
+		w.Unlock()
+		return
+	}
+	delete(w.pending, id)
+	w.stats.Returned++
+	w.conclude(false)
+	w.evaluate()
+}
+
+// check concludes the loops which have not returned within the return window as missing.
+func (w *loopWatchdog) check() {
+	w.Lock()
+	now := w.now()
+	for id, sentAt := range w.pending {
+		if now.Sub(sentAt) >= w.returnWindow {
+			delete(w.pending, id)
+			w.stats.Missing++
+			w.conclude(true)
+		}
+	}
+	w.evaluate()
+}
+
+func (w *loopWatchdog) conclude(missing bool) {
+	if len(w.outcomes) < LoopWatchdogWindow {
+		w.outcomes = append(w.outcomes, missing)
+		return
+	}
+	w.outcomes[w.next] = missing
+	w.next = (w.next + 1) % LoopWatchdogWindow
+}
+
+// evaluate recomputes the missing fraction and raises the alert if it has just reached the threshold.
+// It has to be called with the lock held, which it releases, so that the alert could call back the client.
+func (w *loopWatchdog) evaluate() {
+	var missing int
+	for _, m := range w.outcomes {
+		if m {
+			missing++
+		}
+	}
+	wasAlerting := w.stats.Alerting
+	if len(w.outcomes) > 0 {
+		w.stats.MissingFraction = float64(missing) / float64(len(w.outcomes))
+	}
+	w.stats.Alerting = len(w.outcomes) >= minConcludedLoops && w.stats.MissingFraction >= w.threshold
+	stats := w.snapshot()
+	w.Unlock()
+
+	if stats.Alerting && !wasAlerting && w.alert != nil {
+		w.alert(stats)
+	}
+}
+
+func (w *loopWatchdog) snapshot() LoopStats {
+	stats := w.stats
+	stats.Pending = len(w.pending)
+	return stats
+}
+
+// newLoopPayload returns the payload of a new loop cover message, i.e. LoopCoverPayload followed by a random id,
+// along with the id.
+func newLoopPayload() ([]byte, string, error) {
+	idBytes := make([]byte, loopIDLength)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	return []byte(LoopCoverPayload + id), id, nil
+}
+
+// IsLoopCoverMessage checks whether the message, as pulled from the provider, is a loop cover message.
+func IsLoopCoverMessage(message []byte) bool {
+	return bytes.HasPrefix(message, []byte(LoopCoverPayload))
+}
+
+// SetLoopWatchdog enables the watchdog tracking the loop cover messages encoded by EncodeLoopCoverMessage.
+// Each loop not returning within the return window is considered missing, and once the given fraction
+// of the recently concluded loops went missing, the alert is called with the current statistics.
+// It is called again only after the missing fraction dropped below the threshold in the meantime.
+// It returns ErrInvalidLoopAlertThreshold if the threshold is not in (0, 1].
+func (c *CryptoClient) SetLoopWatchdog(returnWindow time.Duration, threshold float64, alert func(LoopStats)) error {
+	if threshold <= 0 || threshold > 1 {
+		return ErrInvalidLoopAlertThreshold
+	}
+	c.loops = newLoopWatchdog(returnWindow, threshold, alert)
+	return nil
+}
+
+// LoopReturned records the return of the loop cover message pulled from the provider.
+// It is a no-op if the watchdog is not enabled.
+func (c *CryptoClient) LoopReturned(message []byte) {
+	if c.loops == nil || !IsLoopCoverMessage(message) {
+		return
+	}
+	c.loops.returned(string(message[len(LoopCoverPayload):]))
+}
+
+// CheckLoops concludes the loops which have not returned within the return window as missing,
+// raising the alert if needed. It should be called periodically, e.g. after pulling the messages.
+// It is a no-op if the watchdog is not enabled.
+func (c *CryptoClient) CheckLoops() {
+	if c.loops == nil {
+		return
+	}
+	c.loops.check()
+}
+
+// LoopStats returns the statistics of the loop cover messages tracked by the watchdog,
+// which are empty if it is not enabled.
+func (c *CryptoClient) LoopStats() LoopStats {
+	if c.loops == nil {
+		return LoopStats{}
+	}
+	c.loops.Lock()
+	defer c.loops.Unlock()
+	return c.loops.snapshot()
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+const testLoopReturnWindow = 10 * time.Second
+
+// createLoopTestClient creates a client with the loop watchdog enabled, with a controllable clock,
+// and returns it along with the statistics passed to each of the raised alerts.
+func createLoopTestClient(t *testing.T, threshold float64) (*CryptoClient, *time.Time, *[]LoopStats) {
+	baseDisabledLogger, err := logger.New("", "panic", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCryptoClient(priv, pub, config.MixConfig{}, NetworkPKI{}, baseDisabledLogger.GetLogger("test"))
+
+	var alerts []LoopStats
+	err = c.SetLoopWatchdog(testLoopReturnWindow, threshold, func(stats LoopStats) {
+		alerts = append(alerts, stats)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.loops.now = func() time.Time { return now }
+	return c, &now, &alerts
+}
+
+// sendLoops simulates sending the given number of loops, of which only every returnEvery-th one returns,
+// and waiting for the rest of them for the entire return window.
+func sendLoops(t *testing.T, c *CryptoClient, now *time.Time, count int, returnEvery int) {
+	var payloads [][]byte
+	for i := 0; i < count; i++ {
+		payload, id, err := newLoopPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.loops.sent(id)
+		payloads = append(payloads, payload)
+	}
+	*now = now.Add(testLoopReturnWindow / 2)
+	for i, payload := range payloads {
+		if returnEvery > 0 && i%returnEvery == 0 {
+			c.LoopReturned(payload)
+		}
+	}
+	*now = now.Add(testLoopReturnWindow)
+	c.CheckLoops()
+}
+
+func TestCryptoClient_LoopWatchdog_NormalReturnRate(t *testing.T) {
+	c, now, alerts := createLoopTestClient(t, 0.5)
+
+	// all loops return
+	sendLoops(t, c, now, 20, 1)
+	assert.Empty(t, *alerts)
+	assert.Equal(t, LoopStats{Sent: 20, Returned: 20}, c.LoopStats())
+
+	// some loops are lost even in a healthy network
+	sendLoops(t, c, now, 20, 2)
+	assert.Empty(t, *alerts)
+	stats := c.LoopStats()
+	assert.Equal(t, uint64(10), stats.Missing)
+	assert.False(t, stats.Alerting)
+}
+
+func TestCryptoClient_LoopWatchdog_MissingLoops(t *testing.T) {
+	c, now, alerts := createLoopTestClient(t, 0.5)
+
+	sendLoops(t, c, now, 10, 1)
+	assert.Empty(t, *alerts)
+
+	// most of the loops are dropped
+	sendLoops(t, c, now, 40, 4)
+	assert.Len(t, *alerts, 1)
+	stats := c.LoopStats()
+	assert.True(t, stats.Alerting)
+	assert.Equal(t, uint64(50), stats.Sent)
+	assert.Equal(t, uint64(20), stats.Returned)
+	assert.Equal(t, uint64(30), stats.Missing)
+	assert.Equal(t, 0.6, stats.MissingFraction)
+	assert.Equal(t, stats, (*alerts)[0])
+
+	// the alert is not raised again while the loops keep missing
+	sendLoops(t, c, now, 10, 0)
+	assert.Len(t, *alerts, 1)
+
+	// but it is once they went missing again after recovering
+	sendLoops(t, c, now, LoopWatchdogWindow, 1)
+	assert.False(t, c.LoopStats().Alerting)
+	sendLoops(t, c, now, LoopWatchdogWindow, 0)
+	assert.Len(t, *alerts, 2)
+}
+
+func TestCryptoClient_LoopWatchdog_TooFewLoops(t *testing.T) {
+	c, now, alerts := createLoopTestClient(t, 0.5)
+
+	sendLoops(t, c, now, minConcludedLoops-1, 0)
+	assert.Empty(t, *alerts)
+	assert.Equal(t, 1.0, c.LoopStats().MissingFraction)
+
+	sendLoops(t, c, now, 1, 0)
+	assert.Len(t, *alerts, 1)
+}
+
+func TestCryptoClient_LoopWatchdog_Pending(t *testing.T) {
+	c, now, alerts := createLoopTestClient(t, 0.5)
+
+	payload, id, err := newLoopPayload()
+	assert.Nil(t, err)
+	assert.True(t, IsLoopCoverMessage(payload))
+	c.loops.sent(id)
+
+	// the loop is still awaited within the return window
+	*now = now.Add(testLoopReturnWindow - time.Second)
+	c.CheckLoops()
+	assert.Equal(t, LoopStats{Sent: 1, Pending: 1}, c.LoopStats())
+
+	*now = now.Add(time.Second)
+	c.CheckLoops()
+	assert.Equal(t, LoopStats{Sent: 1, Missing: 1, MissingFraction: 1}, c.LoopStats())
+
+	// returning too late, or twice, does not count
+	c.LoopReturned(payload)
+	c.LoopReturned([]byte(LoopCoverPayload + "unknown"))
+	c.LoopReturned([]byte("Hello world"))
+	assert.Equal(t, LoopStats{Sent: 1, Missing: 1, MissingFraction: 1}, c.LoopStats())
+	assert.Empty(t, *alerts)
+}
+
+func TestCryptoClient_SetLoopWatchdog_InvalidThreshold(t *testing.T) {
+	c := NewCryptoClient(nil, nil, config.MixConfig{}, NetworkPKI{}, nil)
+	for _, threshold := range []float64{-0.5, 0, 1.5} {
+		assert.Equal(t, ErrInvalidLoopAlertThreshold, c.SetLoopWatchdog(time.Minute, threshold, nil),
+			fmt.Sprintf("threshold %v", threshold),
+		)
+	}
+	// the watchdog is disabled by default
+	c.CheckLoops()
+	c.LoopReturned([]byte(LoopCoverPayload))
+	assert.Equal(t, LoopStats{}, c.LoopStats())
+}
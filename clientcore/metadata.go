@@ -0,0 +1,119 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// ErrMalformedMetadata is returned when a message carrying metadata could not be decoded.
+var ErrMalformedMetadata = errors.New("malformed message metadata")
+
+// envelopeMagic starts the messages carrying metadata. Plain messages are sent as they are, unless
+// they happen to start with it as well, in which case they are enveloped with no metadata.
+//nolint: gochecknoglobals
+var envelopeMagic = []byte{0x00, 'M', 'D', 0x01}
+
+// encodeEnvelope prepends the metadata to the body of the message. The metadata is encoded as the number
+// of its entries followed by the length-prefixed keys and values, in the order of the keys.
+func encodeEnvelope(body []byte, metadata map[string]string) []byte {
+	if len(metadata) == 0 && !bytes.HasPrefix(body, envelopeMagic) {
+		return body
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	envelope := append([]byte{}, envelopeMagic...)
+	envelope = appendUvarint(envelope, uint64(len(keys)))
+	for _, key := range keys {
+		envelope = appendUvarint(envelope, uint64(len(key)))
+		envelope = append(envelope, key...)
+		envelope = appendUvarint(envelope, uint64(len(metadata[key])))
+		envelope = append(envelope, metadata[key]...)
+	}
+	return append(envelope, body...)
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// decodeEnvelope splits the message into its body and metadata, which is nil if the message carries none.
+// It returns ErrMalformedMetadata if the metadata could not be decoded.
+func decodeEnvelope(message []byte) ([]byte, map[string]string, error) {
+	if !bytes.HasPrefix(message, envelopeMagic) {
+		return message, nil, nil
+	}
+	r := bytes.NewReader(message[len(envelopeMagic):])
+	count, err := binary.ReadUvarint(r)
+	// each entry takes at least two bytes for the lengths of its key and value
+	if err != nil || count > uint64(r.Len())/2 {
+		return nil, nil, ErrMalformedMetadata
+	}
+	metadata := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := readLengthPrefixed(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := readLengthPrefixed(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata[key] = value
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	return message[len(message)-r.Len():], metadata, nil
+}
+
+func readLengthPrefixed(r *bytes.Reader) (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil || length > uint64(r.Len()) {
+		return "", ErrMalformedMetadata
+	}
+	b := make([]byte, length)
+	if _, err := r.Read(b); err != nil && length > 0 {
+		return "", ErrMalformedMetadata
+	}
+	return string(b), nil
+}
+
+// EncodeMessageWithMetadata works like EncodeMessage, but the message carries the given metadata,
+// e.g. its content type or the conversation it belongs to, which is returned by DecodeMessage at the recipient.
+// The metadata is encrypted along with the message, so it is only readable by the recipient and its provider,
+// and as it counts against the payload length, the message with its metadata has to fit in a single packet.
+func (c *CryptoClient) EncodeMessageWithMetadata(message []byte,
+	metadata map[string]string,
+	recipient config.ClientConfig,
+) ([]byte, config.MixConfig, error) {
+	packet, ingress, err := c.createSphinxPacket(encodeEnvelope(message, metadata), recipient, time.Time{})
+	if err != nil {
+		c.log.Errorf("Error in EncodeMessageWithMetadata - the pack procedure failed: %v", err)
+		return nil, config.MixConfig{}, err
+	}
+	return packet, ingress, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeEnvelope_RoundTrip(t *testing.T) {
+	metadata := map[string]string{"content-type": "text/plain", "conversation": "42", "empty": ""}
+	body := []byte("Hello world")
+
+	envelope := encodeEnvelope(body, metadata)
+	decodedBody, decodedMetadata, err := decodeEnvelope(envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decodedBody)
+	assert.Equal(t, metadata, decodedMetadata)
+	// the encoding does not depend on the order of the map
+	assert.Equal(t, envelope, encodeEnvelope(body, metadata))
+
+	// the plain messages are sent as they are
+	assert.Equal(t, body, encodeEnvelope(body, nil))
+	decodedBody, decodedMetadata, err = decodeEnvelope(body)
+	assert.Nil(t, err)
+	assert.Equal(t, body, decodedBody)
+	assert.Nil(t, decodedMetadata)
+
+	// unless they could be mistaken for the messages carrying metadata
+	ambiguous := append(append([]byte{}, envelopeMagic...), body...)
+	assert.NotEqual(t, ambiguous, encodeEnvelope(ambiguous, nil))
+	decodedBody, decodedMetadata, err = decodeEnvelope(encodeEnvelope(ambiguous, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, ambiguous, decodedBody)
+	assert.Nil(t, decodedMetadata)
+}
+
+func TestDecodeEnvelope_Malformed(t *testing.T) {
+	envelope := encodeEnvelope([]byte("Hello world"), map[string]string{"content-type": "text/plain"})
+	// truncated within the metadata
+	for _, length := range []int{len(envelopeMagic), len(envelopeMagic) + 2, len(envelopeMagic) + 10} {
+		_, _, err := decodeEnvelope(envelope[:length])
+		assert.Equal(t, ErrMalformedMetadata, err, "Length %v should have been rejected", length)
+	}
+	// claiming more entries than there are bytes left
+	_, _, err := decodeEnvelope(append(append([]byte{}, envelopeMagic...), 0xff, 0xff, 0x03))
+	assert.Equal(t, ErrMalformedMetadata, err)
+}
+
+func TestCryptoClient_EncodeMessageWithMetadata(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+	ingress, nodes := setupKeyedNetwork(t, DefaultPathLength)
+	recipient := createRecipient(t, nodes)
+
+	metadata := map[string]string{"content-type": "text/plain", "conversation": "42"}
+	packet, _, err := client.EncodeMessageWithMetadata([]byte("Hello world"), metadata, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the metadata is encrypted along with the message
+	assert.False(t, bytes.Contains(packet, []byte("conversation")))
+
+	_, payload, _ := unwrapAllLayers(t, packet, ingress, nodes)
+	decoded, err := client.DecodeMessage(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, DecodedMessage{Payload: []byte("Hello world"), Metadata: metadata}, decoded)
+
+	// the metadata counts against the length of the payload
+	body := make([]byte, sphinx.PayloadSize()-100)
+	_, _, err = client.EncodeMessage(body, recipient)
+	assert.Nil(t, err)
+	_, _, err = client.EncodeMessageWithMetadata(body, map[string]string{"padding": string(make([]byte, 100))}, recipient)
+	assert.Equal(t, ErrMessageTooLong, err)
+}
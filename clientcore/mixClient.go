@@ -20,11 +20,12 @@
 package clientcore
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/helpers"
 	"github.com/nymtech/nym-mixnet/helpers/topology"
@@ -37,20 +38,64 @@ const (
 )
 
 var (
-	// ErrInvalidMixes defines an error when either the mix map is nil or contains insufficient number of entries
-	ErrInvalidMixes = errors.New("insufficient number of mixes provided")
+	// ErrInvalidPathLength defines an error when the path length is either non-positive or exceeds MaxPathLength
+	ErrInvalidPathLength = errors.New("invalid path length")
+	// ErrNoDistinctProvider defines an error when there is no provider other than the recipient's one
+	// which could be used as the ingress provider
+	ErrNoDistinctProvider = errors.New("no ingress provider distinct from the egress provider available")
+	// ErrIncompatibleProvider defines an error when the recipient's provider advertises sphinx parameters
+	// incompatible with the packets of the client
+	ErrIncompatibleProvider = errors.New("egress provider advertises incompatible sphinx parameters")
+	// ErrInvalidDelaySequenceLength defines an error when the requested number of delays is either non-positive
+	// or exceeds the length of the longest possible path
+	ErrInvalidDelaySequenceLength = errors.New("invalid length of the delay sequence")
+	// ErrDelaySequencePathMismatch defines an error when the requested number of delays does not match
+	// the length of the path the delays are generated for
+	ErrDelaySequencePathMismatch = errors.New("length of the delay sequence does not match the path")
+	// ErrMessageTooLong defines an error when the encoded message, including its metadata,
+	// is longer than sphinx.PayloadSize and hence does not fit in a single packet
+	ErrMessageTooLong = errors.New("message does not fit in a single packet")
 )
 
+// InsufficientMixesError is returned when the known topology lacks the mixes to build a path of the required length,
+// i.e. some of the layers of the path have no mix which could be chosen. It means the network is not ready
+// to carry the packets of the client yet, rather than that the packet is malformed.
+type InsufficientMixesError struct {
+	// Available is the number of the layers of the path with at least one mix which could be chosen,
+	// out of the Required ones, i.e. the length of the path.
+	Available int
+	Required  int
+}
+
+func (e *InsufficientMixesError) Error() string {
+	return fmt.Sprintf("insufficient mixes: only %v of the %v layers of the path have usable mixes", e.Available, e.Required)
+}
+
+// IsInsufficientMixes checks whether the given error, possibly wrapped in a SendError,
+// is an InsufficientMixesError.
+func IsInsufficientMixes(err error) bool {
+	if sendErr, ok := err.(*SendError); ok {
+		err = sendErr.Err
+	}
+	_, ok := err.(*InsufficientMixesError)
+	return ok
+}
+
 // NetworkPKI holds PKI data about the current network topology.
 // This allows public-key encryption to happen.
 type NetworkPKI struct {
 	lastUpdated time.Time
 	Mixes       topology.LayeredMixes
+	Providers   []config.MixConfig
 	Clients     []config.ClientConfig
 }
 
-func (n *NetworkPKI) UpdateNetwork(newMixes topology.LayeredMixes, newClients []config.ClientConfig) {
+func (n *NetworkPKI) UpdateNetwork(newMixes topology.LayeredMixes,
+	newProviders []config.MixConfig,
+	newClients []config.ClientConfig,
+) {
 	n.Mixes = newMixes
+	n.Providers = newProviders
 	n.Clients = newClients
 	n.lastUpdated = time.Now()
 }
@@ -59,6 +104,18 @@ func (n *NetworkPKI) ShouldUpdate() bool {
 	return n.lastUpdated.Add(maximumTopologyAge).Before(time.Now())
 }
 
+// MixesByLayer returns all known mixes grouped by the Layer specified in their own configuration,
+// regardless of under which layer they were put in the Mixes map.
+func (n *NetworkPKI) MixesByLayer() topology.LayeredMixes {
+	mixesByLayer := make(topology.LayeredMixes)
+	for _, layerMixes := range n.Mixes {
+		for _, mix := range layerMixes {
+			mixesByLayer[uint(mix.Layer)] = append(mixesByLayer[uint(mix.Layer)], mix)
+		}
+	}
+	return mixesByLayer
+}
+
 // MixClient does sphinx packet encoding and decoding.
 type MixClient interface {
 	EncodeIntoSphinxPacket(message string, recipient config.ClientConfig) ([]byte, error)
@@ -68,125 +125,421 @@ type MixClient interface {
 
 // CryptoClient contains a public/private keypair and an elliptic curve for a given provider and network.
 type CryptoClient struct {
-	pubKey   *sphinx.PublicKey
-	prvKey   *sphinx.PrivateKey
-	Provider config.MixConfig
-	Network  NetworkPKI
-	log      *logrus.Logger
+	pubKey     *sphinx.PublicKey
+	prvKey     *sphinx.PrivateKey
+	Provider   config.MixConfig
+	Network    NetworkPKI
+	token      []byte
+	maxDelay   float64
+	delays     helpers.DelayDistribution
+	pathLength int
+	// maxPathLength bounds the length of the paths chosen by the client.
+	maxPathLength int
+	failures      *nodeFailures
+	// rand is the source of randomness the mixes on the paths and the delays are drawn from.
+	rand *helpers.Rand
+	// loops tracks the loop cover messages awaited to return, if the watchdog is enabled.
+	loops *loopWatchdog
+	// constraints restrict the mixes chosen for the paths.
+	constraints pathConstraints
+	// providerCapabilities are what the provider advertised in the last hello exchange.
+	providerCapabilities config.Capabilities
+	codec                sphinx.Codec
+	log                  *logrus.Logger
 }
 
 const (
-	desiredRateParameter = 5
-	pathLength           = 3
+	// DefaultDelayRate defines the rate parameter of the exponential distribution the delays are drawn from by default,
+	// i.e. the reciprocal of the mean delay in seconds.
+	DefaultDelayRate = 5
+	// DefaultPathLength defines the default number of mixes, excluding the providers, each packet traverses.
+	DefaultPathLength = 3
+	// MaxPathLength defines the maximum number of mixes each packet can traverse,
+	// considering the packet has to go through both providers as well.
+	MaxPathLength = sphinx.MaxHops - 2
+	// maxDelaySequenceLength defines the maximum number of delays generated for a single path,
+	// which, as reported by E2EPath.Len, counts 3 elements besides the mixes.
+	maxDelaySequenceLength = MaxPathLength + 3
+
+	// LoopCoverPayload starts the content of the loop cover messages the clients send back to themselves.
+	// It is followed by the id of the loop.
+	LoopCoverPayload = "LoopCoverMessage"
 )
 
 // CreateSphinxPacket responsible for sending a real message. Takes as input the message string
 // and the public information about the destination.
-// The function generates a random path and a set of random values from exponential distribution.
+// The function generates a random path and a set of random values from the delay distribution of the client.
 // Given those values it triggers the encode function, which packs the message into the
 // sphinx cryptographic packet format. Next, the encoded packet is combined with a
 // flag signalling that this is a usual network packet, and passed to be send.
-// The function returns an error if any issues occurred.
-func (c *CryptoClient) createSphinxPacket(message []byte, recipient config.ClientConfig) ([]byte, error) {
+// If expiry is non-zero, the packet is dropped by any node processing it after that time.
+// The function returns the packet together with the ingress provider it has to be sent to,
+// or an error if any issues occurred.
+func (c *CryptoClient) createSphinxPacket(message []byte,
+	recipient config.ClientConfig,
+	expiry time.Time,
+) ([]byte, config.MixConfig, error) {
+	if len(message) > sphinx.PayloadSize() {
+		return nil, config.MixConfig{}, ErrMessageTooLong
+	}
 
 	path, err := c.buildPath(recipient)
 	if err != nil {
 		c.log.Errorf("error in CreateSphinxPacket - generating random path failed: %v", err)
-		return nil, err
+		return nil, config.MixConfig{}, err
 	}
 
-	delays, err := c.generateDelaySequence(desiredRateParameter, path.Len())
+	delays, err := c.generateDelaySequence(c.delays, path.Len(), path)
 	if err != nil {
 		c.log.Errorf("error in CreateSphinxPacket - generating sequence of delays failed: %v", err)
-		return nil, err
+		return nil, config.MixConfig{}, err
 	}
 
-	sphinxPacket, err := sphinx.PackForwardMessage(path, delays, message)
+	sphinxPacket, err := sphinx.PackForwardMessageWithExpiry(path, delays, message, c.maxDelay, expiry)
 	if err != nil {
 		c.log.Errorf("error in CreateSphinxPacket - the pack procedure failed: %v", err)
-		return nil, err
+		return nil, config.MixConfig{}, err
 	}
 
-	return proto.Marshal(&sphinxPacket)
+	packet, err := c.codec.Marshal(&sphinxPacket)
+	if err != nil {
+		return nil, config.MixConfig{}, err
+	}
+	return packet, path.IngressProvider, nil
 }
 
-// buildPath builds a path containing the sender's provider,
+// buildPath builds a path containing an ingress provider distinct from the recipient's provider,
 // a sequence (of length pre-defined in a config file) of randomly
-// selected mixes and the recipient's provider
+// selected mixes and the recipient's provider. Neither the providers on the path nor the client's own provider
+// are ever chosen as the mixes, so that no node sees the same packet twice.
 func (c *CryptoClient) buildPath(recipient config.ClientConfig) (config.E2EPath, error) {
-	mixSeq, err := c.getRandomMixSequence(c.Network.Mixes, pathLength)
-	if err != nil {
-		c.log.Errorf("error in buildPath - generating random mix path failed: %v", err)
-		return config.E2EPath{}, err
-	}
-
 	if recipient.Provider == nil || len(recipient.Provider.PubKey) == 0 {
 		err := fmt.Errorf("error in buildPath - could not create path to the recipient," +
 			" the EgressProvider has invalid configuration")
 		c.log.Error(err.Error())
 		return config.E2EPath{}, err
 	}
-	path := config.E2EPath{IngressProvider: c.Provider,
+	if !sphinx.ParamsCompatible(recipient.Provider.Params, c.pathLength+2) {
+		c.log.Errorf("error in buildPath - %v", ErrIncompatibleProvider)
+		return config.E2EPath{}, ErrIncompatibleProvider
+	}
+	ingress, err := c.selectIngressProvider(*recipient.Provider)
+	if err != nil {
+		c.log.Errorf("error in buildPath - %v", err)
+		return config.E2EPath{}, err
+	}
+	mixSeq, err := c.getRandomMixSequence(c.Network.MixesByLayer(),
+		c.pathLength,
+		c.Provider,
+		ingress,
+		*recipient.Provider,
+	)
+	if err != nil {
+		c.log.Errorf("error in buildPath - generating random mix path failed: %v", err)
+		return config.E2EPath{}, err
+	}
+	path := config.E2EPath{IngressProvider: ingress,
 		Mixes:          mixSeq,
 		EgressProvider: *recipient.Provider,
 		Recipient:      recipient,
 	}
+	if err := validatePathKeys(path); err != nil {
+		c.log.Errorf("error in buildPath - %v", err)
+		return config.E2EPath{}, err
+	}
 	return path, nil
 }
 
+// selectIngressProvider chooses the provider through which a packet destined to the given egress provider
+// enters the network. Using the same provider on both ends of the path would let it link the sender
+// with the recipient, hence the ingress provider always differs from the egress one. It is chosen at random
+// from all the other known providers, the client's own one included, avoiding those with recently reported failures,
+// so that the loop cover messages, which end at the client's own provider, enter the network the same way
+// as the real messages. Only the providers advertising compatible sphinx parameters are considered.
+// ErrNoDistinctProvider is returned if there is none.
+func (c *CryptoClient) selectIngressProvider(egress config.MixConfig) (config.MixConfig, error) {
+	hops := c.pathLength + 2
+	candidates := make([]config.MixConfig, 0, len(c.Network.Providers)+1)
+	if !containsNode(c.Network.Providers, c.Provider) {
+		candidates = append(candidates, c.Provider)
+	}
+	candidates = append(candidates, c.Network.Providers...)
+
+	distinct := candidates[:0]
+	for _, provider := range candidates {
+		if !bytes.Equal(provider.PubKey, egress.PubKey) && sphinx.ParamsCompatible(provider.Params, hops) {
+			distinct = append(distinct, provider)
+		}
+	}
+	if len(distinct) == 0 {
+		return config.MixConfig{}, ErrNoDistinctProvider
+	}
+	return c.rand.Mix(c.failures.filterAvailable(distinct)), nil
+}
+
+// validatePathKeys checks whether all the nodes on the path have public keys of the correct size,
+// so that bad PKI data is reported before entering the sphinx cryptography.
+func validatePathKeys(path config.E2EPath) error {
+	nodes := append(append([]config.MixConfig{path.IngressProvider}, path.Mixes...), path.EgressProvider)
+	for _, node := range nodes {
+		if len(node.PubKey) != sphinx.PublicKeySize {
+			return fmt.Errorf("invalid public key of node %q at %v: expected %v bytes, got %v",
+				node.Id,
+				net.JoinHostPort(node.Host, node.Port),
+				sphinx.PublicKeySize,
+				len(node.PubKey),
+			)
+		}
+	}
+	return nil
+}
+
 // getRandomMixSequence generates a random sequence of given length from all possible mixes.
-// If the list of all active mixes is empty or the given length is larger than the set of active mixes,
-// an error is returned.
-func (c *CryptoClient) getRandomMixSequence(mixes topology.LayeredMixes, length int) ([]config.MixConfig, error) {
-	if mixes == nil || len(mixes) < length {
-		return nil, ErrInvalidMixes
+// The mixes with recently reported failures are avoided, unless no other mixes are available on their layer,
+// while the mixes advertising sphinx parameters incompatible with the resulting path are never chosen.
+// Only the mixes of the layers from 1 to length are used, however many layers the topology has.
+// The excluded nodes, matched by their public keys, are never chosen. The mixes are further restricted
+// by the path constraints of the client, and ErrUnsatisfiablePathConstraints is returned if they can't be satisfied.
+// If any of the layers has no such mix, an InsufficientMixesError is returned rather than a shorter path.
+// ErrInvalidPathLength is returned if the length is not positive or it exceeds the maximum path length
+// of the client, which is never more than MaxPathLength, as the sphinx header could not fit the resulting path.
+func (c *CryptoClient) getRandomMixSequence(mixes topology.LayeredMixes,
+	length int,
+	excluded ...config.MixConfig,
+) ([]config.MixConfig, error) {
+	if length <= 0 || length > c.maxPathLength {
+		return nil, ErrInvalidPathLength
+	}
+
+	usable := make([][]config.MixConfig, length)
+	available := 0
+	for i := range usable {
+		usable[i] = excludeNodes(compatibleMixes(mixes[uint(i+1)], length+2), excluded)
+		if len(usable[i]) > 0 {
+			available++
+		}
+	}
+	if available < length {
+		return nil, &InsufficientMixesError{Available: available, Required: length}
 	}
 
 	mixSequence := make([]config.MixConfig, length)
-	for i := 1; i <= length; i++ {
-		if layerMixes, ok := mixes[uint(i)]; ok {
-			mixSequence[i-1] = helpers.RandomMix(layerMixes)
-		} else {
-			return nil, fmt.Errorf("no valid mixes for layer: %v", i)
+	placed := make(map[string]struct{})
+	for i, layerMixes := range usable {
+		layerMixes, err := c.constraints.apply(layerMixes, placed)
+		if err != nil {
+			return nil, err
 		}
+		// the layer has usable mixes, so only the constraints could have excluded all of them
+		if len(layerMixes) == 0 {
+			return nil, ErrUnsatisfiablePathConstraints
+		}
+		mixSequence[i] = c.rand.Mix(c.failures.filterAvailable(layerMixes))
+	}
+	if !c.constraints.satisfied(placed) {
+		return nil, ErrUnsatisfiablePathConstraints
 	}
 
 	return mixSequence, nil
 }
 
-// generateDelaySequence generates a given length sequence of float64 values. Values are generated
-// following the exponential distribution. generateDelaySequence returnes a sequence or an error
-// if any of the values could not be generate.
-func (c *CryptoClient) generateDelaySequence(desiredRateParameter float64, length int) ([]float64, error) {
-	var delays []float64
-	for i := 0; i < length; i++ {
-		d, err := helpers.RandomExponential(desiredRateParameter)
-		if err != nil {
-			c.log.Errorf("Error in generateDelaySequence - generating random exponential sample failed: %v", err)
-			return nil, err
+// compatibleMixes returns the mixes advertising sphinx parameters compatible with a path of the given number of hops.
+func compatibleMixes(mixes []config.MixConfig, hops int) []config.MixConfig {
+	compatible := make([]config.MixConfig, 0, len(mixes))
+	for _, mix := range mixes {
+		if sphinx.ParamsCompatible(mix.Params, hops) {
+			compatible = append(compatible, mix)
 		}
-		delays = append(delays, d)
+	}
+	return compatible
+}
+
+// excludeNodes returns the mixes whose public keys differ from those of all the excluded nodes.
+func excludeNodes(mixes []config.MixConfig, excluded []config.MixConfig) []config.MixConfig {
+	remaining := make([]config.MixConfig, 0, len(mixes))
+	for _, mix := range mixes {
+		if !containsNode(excluded, mix) {
+			remaining = append(remaining, mix)
+		}
+	}
+	return remaining
+}
+
+func containsNode(nodes []config.MixConfig, node config.MixConfig) bool {
+	for _, other := range nodes {
+		if len(other.PubKey) > 0 && bytes.Equal(other.PubKey, node.PubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateDelaySequence generates a given length sequence of float64 values for the given path. Values are drawn
+// from the given distribution. The length has to match the length of the path, which can't exceed
+// the length of the longest possible path.
+// generateDelaySequence returnes a sequence or an error if the length is invalid
+// or any of the values could not be generate.
+func (c *CryptoClient) generateDelaySequence(dist helpers.DelayDistribution,
+	length int,
+	path config.E2EPath,
+) ([]float64, error) {
+	if length <= 0 || length > maxDelaySequenceLength {
+		c.log.Errorf("Error in generateDelaySequence - requested %v delays, while at most %v are allowed",
+			length,
+			maxDelaySequenceLength,
+		)
+		return nil, ErrInvalidDelaySequenceLength
+	}
+	if length != path.Len() {
+		c.log.Errorf("Error in generateDelaySequence - requested %v delays for a path of length %v", length, path.Len())
+		return nil, ErrDelaySequencePathMismatch
+	}
+
+	delays, err := helpers.DelaySequence(c.rand, dist, length)
+	if err != nil {
+		c.log.Errorf("Error in generateDelaySequence - drawing a random delay failed: %v", err)
+		return nil, err
 	}
 	return delays, nil
 }
 
 // EncodeMessage encodes given message into the Sphinx packet format. EncodeMessage takes as inputs
 // the message and the recipient's public configuration.
-// EncodeMessage returns the byte representation of the packet or an error if the packet could not be created.
-func (c *CryptoClient) EncodeMessage(message []byte, recipient config.ClientConfig) ([]byte, error) {
+// EncodeMessage returns the byte representation of the packet together with the ingress provider
+// it has to be sent to, or an error if the packet could not be created.
+func (c *CryptoClient) EncodeMessage(message []byte, recipient config.ClientConfig) ([]byte, config.MixConfig, error) {
 
-	packet, err := c.createSphinxPacket(message, recipient)
+	packet, ingress, err := c.createSphinxPacket(encodeEnvelope(message, nil), recipient, time.Time{})
 	if err != nil {
 		c.log.Errorf("Error in EncodeMessage - the pack procedure failed: %v", err)
-		return nil, err
+		return nil, config.MixConfig{}, err
+	}
+	return packet, ingress, err
+}
+
+// EncodeMessageWithExpiry works like EncodeMessage, but the resulting packet is dropped by any node
+// which processes it after the given expiry, so that it would not be delivered past that deadline.
+func (c *CryptoClient) EncodeMessageWithExpiry(message []byte,
+	recipient config.ClientConfig,
+	expiry time.Time,
+) ([]byte, config.MixConfig, error) {
+	packet, ingress, err := c.createSphinxPacket(encodeEnvelope(message, nil), recipient, expiry)
+	if err != nil {
+		c.log.Errorf("Error in EncodeMessageWithExpiry - the pack procedure failed: %v", err)
+		return nil, config.MixConfig{}, err
+	}
+	return packet, ingress, nil
+}
+
+// EncodeLoopCoverMessage encodes a loop cover message destined back to the sender itself.
+// The packet is created by exactly the same procedure as the real messages, i.e. the path goes through
+// the ingress provider, a mix from each of the layers and the sender's provider, and the delays
+// are drawn from the same distribution, so that its routing and header are indistinguishable
+// from those of the real traffic. Only the encrypted payload differs.
+// As the egress provider is the sender's own, the packet enters the network through another provider.
+// If the loop watchdog is enabled, the loop is awaited to return from the moment it is encoded.
+func (c *CryptoClient) EncodeLoopCoverMessage(self config.ClientConfig) ([]byte, config.MixConfig, error) {
+	payload, id, err := newLoopPayload()
+	if err != nil {
+		return nil, config.MixConfig{}, err
+	}
+	packet, ingress, err := c.createSphinxPacket(payload, self, time.Time{})
+	if err != nil {
+		c.log.Errorf("Error in EncodeLoopCoverMessage - the pack procedure failed: %v", err)
+		return nil, config.MixConfig{}, err
+	}
+	if c.loops != nil {
+		c.loops.sent(id)
+	}
+	return packet, ingress, nil
+}
+
+// PackMulticast encodes the same message into a separate Sphinx packet for each of the given recipients.
+// Every packet is built over its own freshly chosen path, with its own delays and shared secrets,
+// so that no routing information is shared between the recipients; only the plaintext is reused.
+// PackMulticast returns the byte representations of the packets and the ingress providers they have to be
+// sent to in the same order as the recipients, or an error if any of the packets could not be created.
+func (c *CryptoClient) PackMulticast(message []byte,
+	recipients []config.ClientConfig,
+) ([][]byte, []config.MixConfig, error) {
+	message = encodeEnvelope(message, nil)
+	packets := make([][]byte, len(recipients))
+	ingresses := make([]config.MixConfig, len(recipients))
+	for i := range recipients {
+		packet, ingress, err := c.createSphinxPacket(message, recipients[i], time.Time{})
+		if err != nil {
+			c.log.Errorf("Error in PackMulticast - the pack procedure for recipient %v failed: %v", recipients[i].Id, err)
+			return nil, nil, err
+		}
+		packets[i] = packet
+		ingresses[i] = ingress
+	}
+	return packets, ingresses, nil
+}
+
+// DecodeMessage decodes the message received from the provider, as it was unwrapped from the sphinx packet
+// at its last hop, into its body and the metadata attached by its sender, if any.
+// It returns ErrMalformedMetadata if the metadata could not be decoded.
+func (c *CryptoClient) DecodeMessage(message []byte) (DecodedMessage, error) {
+	body, metadata, err := decodeEnvelope(message)
+	if err != nil {
+		return DecodedMessage{}, err
+	}
+	return DecodedMessage{Payload: body, Metadata: metadata}, nil
+}
+
+// SetMaxDelay sets the maximum delay (in seconds) that can be requested from any single hop.
+// Any larger generated delays are clamped to this value.
+func (c *CryptoClient) SetMaxDelay(maxDelay float64) {
+	c.maxDelay = maxDelay
+}
+
+// SetDelayDistribution sets the distribution the delays requested from each hop are drawn from.
+// By default the delays follow the exponential distribution with DefaultDelayRate.
+func (c *CryptoClient) SetDelayDistribution(dist helpers.DelayDistribution) {
+	c.delays = dist
+}
+
+// SetRand sets the source of randomness the mixes on the paths and the delays are drawn from,
+// which is crypto-grade by default. It is meant for the tests, which can make the paths reproducible
+// with helpers.NewSeededRand.
+func (c *CryptoClient) SetRand(r *helpers.Rand) {
+	c.rand = r
+}
+
+// SetPacketCodec sets the wire format of the subsequently encoded packets, which has to be the one
+// used by the nodes of the network. By default it is sphinx.ProtobufCodec.
+func (c *CryptoClient) SetPacketCodec(codec sphinx.Codec) {
+	c.codec = codec
+}
+
+// SetPathLength sets the number of mixes, excluding the providers, each subsequently encoded packet traverses.
+// Longer paths increase anonymity at the cost of latency. SetPathLength returns an error if the length
+// exceeds the maximum path length. Note that the network needs to have mixes on each of the layers from 1 to length,
+// otherwise encoding of the messages is going to fail.
+func (c *CryptoClient) SetPathLength(length int) error {
+	if length <= 0 || length > c.maxPathLength {
+		return ErrInvalidPathLength
+	}
+	c.pathLength = length
+	return nil
+}
+
+// SetMaxPathLength sets the maximum number of mixes, excluding the providers, any path chosen by the client
+// can consist of, which is MaxPathLength by default. It returns ErrInvalidPathLength if the length is not positive
+// or it exceeds MaxPathLength, as the sphinx header could not fit longer paths. It should be called
+// before SetPathLength.
+func (c *CryptoClient) SetMaxPathLength(length int) error {
+	if length <= 0 || length > MaxPathLength {
+		return ErrInvalidPathLength
 	}
-	return packet, err
+	c.maxPathLength = length
+	return nil
 }
 
-// DecodeMessage decodes the received sphinx packet.
-// TODO: this function is finished yet.
-func (c *CryptoClient) DecodeMessage(packet sphinx.SphinxPacket) (sphinx.SphinxPacket, error) {
-	return packet, nil
+// PathLength returns the number of mixes, excluding the providers, each encoded packet traverses.
+func (c *CryptoClient) PathLength() int {
+	return c.pathLength
 }
 
 // GetPublicKey returns the public key for this CryptoClient
@@ -203,9 +556,15 @@ func NewCryptoClient(privKey *sphinx.PrivateKey,
 	log *logrus.Logger,
 ) *CryptoClient {
 	return &CryptoClient{prvKey: privKey,
-		pubKey:   pubKey,
-		Provider: provider,
-		Network:  network,
-		log:      log,
+		pubKey:        pubKey,
+		Provider:      provider,
+		Network:       network,
+		maxDelay:      sphinx.DefaultMaxDelay,
+		delays:        helpers.ExponentialDelay{Rate: DefaultDelayRate},
+		pathLength:    DefaultPathLength,
+		maxPathLength: MaxPathLength,
+		failures:      newNodeFailures(DefaultFailureCooldown),
+		rand:          helpers.NewRand(),
+		log:           log,
 	}
 }
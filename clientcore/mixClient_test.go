@@ -15,14 +15,19 @@
 package clientcore
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/helpers"
 	"github.com/nymtech/nym-mixnet/helpers/topology"
 	"github.com/nymtech/nym-mixnet/logger"
 	sphinx "github.com/nymtech/nym-mixnet/sphinx"
@@ -114,6 +119,12 @@ func TestCryptoClient_EncodeMessage(t *testing.T) {
 	}
 	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3331", PubKey: pubP.Bytes()}
 
+	_, pubE, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	egress := config.MixConfig{Id: "EgressProvider", Host: "localhost", Port: "3332", PubKey: pubE.Bytes()}
+
 	_, pubD, err := sphinx.GenerateKeyPair()
 	if err != nil {
 		t.Fatal(err)
@@ -122,32 +133,40 @@ func TestCryptoClient_EncodeMessage(t *testing.T) {
 		Host:     "localhost",
 		Port:     "9999",
 		PubKey:   pubD.Bytes(),
-		Provider: &provider,
+		Provider: &egress,
 	}
 	client.Provider = provider
 
-	encoded, err := client.EncodeMessage([]byte("Hello world"), recipient)
+	encoded, ingress, err := client.EncodeMessage([]byte("Hello world"), recipient)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	assert.Equal(t, reflect.TypeOf([]byte{}), reflect.TypeOf(encoded))
+	assert.Equal(t, provider, ingress)
 
+	// the messages are limited exactly to the payload size of a single packet
+	_, _, err = client.EncodeMessage(make([]byte, sphinx.PayloadSize()), recipient)
+	assert.Nil(t, err)
+	_, _, err = client.EncodeMessage(make([]byte, sphinx.PayloadSize()+1), recipient)
+	assert.Equal(t, ErrMessageTooLong, err)
 }
 
 func TestCryptoClient_DecodeMessage(t *testing.T) {
-	packet := sphinx.SphinxPacket{Hdr: &sphinx.Header{}, Pld: []byte("Message")}
-
-	decoded, err := client.DecodeMessage(packet)
+	decoded, err := client.DecodeMessage([]byte("Message"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := packet
-	assert.Equal(t, expected, decoded)
+	assert.Equal(t, DecodedMessage{Payload: []byte("Message")}, decoded)
+}
+
+// pathOfLength creates a path of the given length, as reported by its Len method.
+func pathOfLength(length int) config.E2EPath {
+	return config.E2EPath{Mixes: make([]config.MixConfig, length-3)}
 }
 
 func TestCryptoClient_GenerateDelaySequence_Pass(t *testing.T) {
-	delays, err := client.generateDelaySequence(100, 5)
+	delays, err := client.generateDelaySequence(helpers.ExponentialDelay{Rate: 100}, 5, pathOfLength(5))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -156,11 +175,41 @@ func TestCryptoClient_GenerateDelaySequence_Pass(t *testing.T) {
 }
 
 func TestCryptoClient_GenerateDelaySequence_Fail(t *testing.T) {
-	_, err := client.generateDelaySequence(0, 5)
+	_, err := client.generateDelaySequence(helpers.ExponentialDelay{Rate: 0}, 5, pathOfLength(5))
 	// TODO: make the error string a constant
 	assert.EqualError(t, errors.New("the parameter of exponential distribution has to be larger than zero"), err.Error())
 }
 
+func TestCryptoClient_GenerateDelaySequence_Distribution(t *testing.T) {
+	delays, err := client.generateDelaySequence(helpers.ConstantDelay{Delay: 2.0}, 3, pathOfLength(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{2.0, 2.0, 2.0}, delays)
+
+	_, err = client.generateDelaySequence(helpers.UniformDelay{Min: 2.0, Max: 1.0}, 3, pathOfLength(3))
+	assert.Equal(t, helpers.ErrUniformDistributionParams, err)
+}
+
+func TestCryptoClient_GenerateDelaySequence_PathMismatch(t *testing.T) {
+	dist := helpers.ConstantDelay{Delay: 1.0}
+	_, err := client.generateDelaySequence(dist, 4, pathOfLength(5))
+	assert.Equal(t, ErrDelaySequencePathMismatch, err)
+	_, err = client.generateDelaySequence(dist, 5, pathOfLength(4))
+	assert.Equal(t, ErrDelaySequencePathMismatch, err)
+}
+
+func TestCryptoClient_GenerateDelaySequence_InvalidLength(t *testing.T) {
+	dist := helpers.ConstantDelay{Delay: 1.0}
+	for _, length := range []int{-1, 0, MaxPathLength + 4, 1 << 30} {
+		_, err := client.generateDelaySequence(dist, length, config.E2EPath{})
+		assert.Equal(t, ErrInvalidDelaySequenceLength, err, "Length %v should have been rejected", length)
+	}
+
+	// while the longest possible path is accepted
+	delays, err := client.generateDelaySequence(dist, MaxPathLength+3, pathOfLength(MaxPathLength+3))
+	assert.Nil(t, err)
+	assert.Len(t, delays, MaxPathLength+3)
+}
+
 func Test_GetRandomMixSequence_TooFewMixes(t *testing.T) {
 	_, err := client.getRandomMixSequence(mixes, 20)
 	assert.Error(t, err)
@@ -191,12 +240,615 @@ func Test_GetRandomMixSequence_MoreMixes(t *testing.T) {
 
 }
 
+func Test_GetRandomMixSequence_MaxPathLength(t *testing.T) {
+	// the topology has more layers than any path can traverse
+	layered := make(topology.LayeredMixes)
+	for layer := uint(1); layer <= MaxPathLength+2; layer++ {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mix := config.NewMixConfig(fmt.Sprintf("Mix%d", layer), "localhost", "3330", pub.Bytes(), layer)
+		layered[layer] = []config.MixConfig{mix}
+	}
+
+	sequence, err := client.getRandomMixSequence(layered, MaxPathLength)
+	assert.Nil(t, err)
+	if assert.Len(t, sequence, MaxPathLength) {
+		for i, mix := range sequence {
+			assert.Equal(t, uint64(i+1), mix.Layer)
+		}
+	}
+
+	for _, length := range []int{-1, 0, MaxPathLength + 1, MaxPathLength + 2} {
+		_, err := client.getRandomMixSequence(layered, length)
+		assert.Equal(t, ErrInvalidPathLength, err, "Length %v should have been rejected", length)
+	}
+}
+
+func TestCryptoClient_SetMaxPathLength(t *testing.T) {
+	layered := make(topology.LayeredMixes)
+	for layer := uint(1); layer <= MaxPathLength; layer++ {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mix := config.NewMixConfig(fmt.Sprintf("Mix%d", layer), "localhost", "3330", pub.Bytes(), layer)
+		layered[layer] = []config.MixConfig{mix}
+	}
+	defer client.SetMaxPathLength(MaxPathLength) //nolint: errcheck
+
+	for _, length := range []int{-1, 0, MaxPathLength + 1} {
+		assert.Equal(t, ErrInvalidPathLength, client.SetMaxPathLength(length), "Length %v should have been rejected", length)
+	}
+
+	assert.Nil(t, client.SetMaxPathLength(2))
+	sequence, err := client.getRandomMixSequence(layered, 2)
+	assert.Nil(t, err)
+	assert.Len(t, sequence, 2)
+	_, err = client.getRandomMixSequence(layered, 3)
+	assert.Equal(t, ErrInvalidPathLength, err)
+	assert.Equal(t, ErrInvalidPathLength, client.SetPathLength(3))
+}
+
 func Test_GetRandomMixSequence_FailEmptyList(t *testing.T) {
-	_, err := client.getRandomMixSequence(topology.LayeredMixes{}, 6)
-	assert.EqualError(t, ErrInvalidMixes, err.Error(), "")
+	_, err := client.getRandomMixSequence(topology.LayeredMixes{}, 3)
+	assert.Equal(t, &InsufficientMixesError{Available: 0, Required: 3}, err)
 }
 
 func Test_GetRandomMixSequence_FailNonList(t *testing.T) {
-	_, err := client.getRandomMixSequence(nil, 6)
-	assert.EqualError(t, ErrInvalidMixes, err.Error(), "")
+	_, err := client.getRandomMixSequence(nil, 3)
+	assert.Equal(t, &InsufficientMixesError{Available: 0, Required: 3}, err)
+}
+
+func Test_GetRandomMixSequence_InsufficientMixes(t *testing.T) {
+	layered := make(topology.LayeredMixes)
+	_, err := client.getRandomMixSequence(layered, 3)
+	assert.Equal(t, &InsufficientMixesError{Available: 0, Required: 3}, err)
+	assert.True(t, IsInsufficientMixes(err))
+
+	newMix := func(layer uint) config.MixConfig {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config.NewMixConfig(fmt.Sprintf("Mix%d", layer), "localhost", "3330", pub.Bytes(), layer)
+	}
+
+	// a single mix only suffices for a path of length 1
+	layered[1] = []config.MixConfig{newMix(1)}
+	_, err = client.getRandomMixSequence(layered, 3)
+	assert.Equal(t, &InsufficientMixesError{Available: 1, Required: 3}, err)
+	sequence, err := client.getRandomMixSequence(layered, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, layered[1], sequence)
+
+	// just enough mixes, one on each layer
+	layered[2] = []config.MixConfig{newMix(2)}
+	layered[3] = []config.MixConfig{newMix(3)}
+	sequence, err = client.getRandomMixSequence(layered, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []config.MixConfig{layered[1][0], layered[2][0], layered[3][0]}, sequence)
+
+	// the excluded mixes are not counted as available, so the path is never shortened
+	_, err = client.getRandomMixSequence(layered, 3, layered[2][0])
+	assert.Equal(t, &InsufficientMixesError{Available: 2, Required: 3}, err)
+}
+
+func TestNetworkPKI_MixesByLayer(t *testing.T) {
+	m1 := config.NewMixConfig("Mix1", "localhost", "3330", []byte("key1"), 1)
+	m2 := config.NewMixConfig("Mix2", "localhost", "3331", []byte("key2"), 2)
+	m3 := config.NewMixConfig("Mix3", "localhost", "3332", []byte("key3"), 2)
+	m4 := config.NewMixConfig("Mix4", "localhost", "3333", []byte("key4"), 3)
+	m5 := config.NewMixConfig("Mix5", "localhost", "3334", []byte("key5"), 2)
+
+	network := NetworkPKI{Mixes: topology.LayeredMixes{
+		1: []config.MixConfig{m1},
+		2: []config.MixConfig{m2, m3},
+		// misplaced mix should be put in the layer from its own configuration
+		3: []config.MixConfig{m4, m5},
+	}}
+
+	mixesByLayer := network.MixesByLayer()
+	assert.Len(t, mixesByLayer, 3)
+	assert.Equal(t, []config.MixConfig{m1}, mixesByLayer[1])
+	assert.ElementsMatch(t, []config.MixConfig{m2, m3, m5}, mixesByLayer[2])
+	assert.Equal(t, []config.MixConfig{m4}, mixesByLayer[3])
+
+	assert.Empty(t, (&NetworkPKI{}).MixesByLayer())
+}
+
+func TestCryptoClient_BuildPath_OneMixPerLayer(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	recipient := createRecipient(t, nil)
+
+	path, err := client.buildPath(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, path.Mixes, client.PathLength())
+	for i, mix := range path.Mixes {
+		assert.Equal(t, uint64(i+1), mix.Layer, "Mixes should be chosen one per layer in ascending order")
+		assert.Contains(t, client.Network.MixesByLayer()[uint(i+1)], mix)
+	}
+}
+
+func TestCryptoClient_BuildPath_DistinctProviders(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	ingress, _ := setupKeyedNetwork(t, 3)
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recipient uses the same provider as the client
+	recipient := config.NewClientConfig("Recipient", "localhost", "9999", pub.Bytes(), ingress.cfg)
+
+	// no other provider is known
+	_, err = client.buildPath(recipient)
+	assert.Equal(t, ErrNoDistinctProvider, err)
+
+	other := createRecipient(t, nil)
+	for i := 0; i < 10; i++ {
+		path, err := client.buildPath(recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, ingress.cfg, path.EgressProvider)
+		assert.Equal(t, *other.Provider, path.IngressProvider)
+	}
+
+	// with more providers, the ingress one is chosen at random among all but the recipient's one
+	third := createRecipient(t, nil)
+	chosen := make(map[string]struct{})
+	for i := 0; i < 50; i++ {
+		path, err := client.buildPath(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEqual(t, path.IngressProvider.PubKey, path.EgressProvider.PubKey)
+		chosen[path.IngressProvider.Id] = struct{}{}
+	}
+	assert.Equal(t, map[string]struct{}{ingress.cfg.Id: {}, third.Provider.Id: {}}, chosen)
+}
+
+func TestCryptoClient_EncodeMessage_MalformedNodeKey(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	recipient := createRecipient(t, nil)
+
+	// all the mixes on the second layer have truncated keys, so any chosen path contains one of them
+	for i, mix := range client.Network.Mixes[2] {
+		mix.PubKey = mix.PubKey[:sphinx.PublicKeySize-1]
+		client.Network.Mixes[2][i] = mix
+	}
+	_, _, err := client.EncodeMessage([]byte("foomp"), recipient)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid public key of node")
+		assert.Contains(t, err.Error(), fmt.Sprintf("expected %v bytes, got %v", sphinx.PublicKeySize, sphinx.PublicKeySize-1))
+		named := false
+		for _, mix := range client.Network.Mixes[2] {
+			named = named || strings.Contains(err.Error(), mix.Id)
+		}
+		assert.True(t, named, "The error should name the offending node")
+	}
+
+	// the same applies to the providers
+	setupKeyedNetwork(t, 3)
+	client.Provider.PubKey = nil
+	client.Network.Providers[0] = client.Provider
+	_, _, err = client.EncodeMessage([]byte("foomp"), recipient)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), fmt.Sprintf("invalid public key of node %q", client.Provider.Id))
+	}
+}
+
+type keyedNode struct {
+	cfg    config.MixConfig
+	prvKey *sphinx.PrivateKey
+}
+
+func createKeyedNode(layer uint) (keyedNode, error) {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		return keyedNode{}, err
+	}
+	// like in the actual network, the id is derived from the public key, so that all ids have the same length
+	id := base64.URLEncoding.EncodeToString(pub.Bytes())
+	return keyedNode{cfg: config.NewMixConfig(id, "localhost", "1789", pub.Bytes(), layer), prvKey: priv}, nil
+}
+
+// setupKeyedNetwork replaces the network of the test client with the given number of layers of freshly keyed mixes
+// and a freshly keyed ingress provider. It returns the ingress provider and all the created nodes.
+func setupKeyedNetwork(t *testing.T, layers uint) (keyedNode, map[string]keyedNode) {
+	nodes := make(map[string]keyedNode)
+	client.Network = NetworkPKI{Mixes: make(topology.LayeredMixes)}
+	for layer := uint(1); layer <= layers; layer++ {
+		for i := 0; i < 2; i++ {
+			mix, err := createKeyedNode(layer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes[mix.cfg.Id] = mix
+			client.Network.Mixes[layer] = append(client.Network.Mixes[layer], mix.cfg)
+		}
+	}
+
+	ingress, err := createKeyedNode(config.ProviderLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[ingress.cfg.Id] = ingress
+	client.Provider = ingress.cfg
+	client.Network.Providers = []config.MixConfig{ingress.cfg}
+	return ingress, nodes
+}
+
+// createRecipient creates a freshly keyed recipient at a new egress provider, which is added to the providers
+// known to the test client, as well as to the nodes, unless they are nil.
+func createRecipient(t *testing.T, nodes map[string]keyedNode) config.ClientConfig {
+	egress, err := createKeyedNode(config.ProviderLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes != nil {
+		nodes[egress.cfg.Id] = egress
+	}
+	client.Network.Providers = append(client.Network.Providers, egress.cfg)
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.NewClientConfig(base64.URLEncoding.EncodeToString(pub.Bytes()), "localhost", "9999", pub.Bytes(), egress.cfg)
+}
+
+// unwrapAllLayers processes the packet at each hop, using the private key of the node it is destined to,
+// until it reaches its final hop. It returns the final hop, the fully unwrapped payload
+// and the number of nodes that processed the packet.
+func unwrapAllLayers(t *testing.T, packet []byte, firstHop keyedNode, nodes map[string]keyedNode) (sphinx.Hop, []byte, int) {
+	current := firstHop
+	for hops := 1; ; hops++ {
+		hop, commands, newPacket, err := sphinx.ProcessSphinxPacket(packet, current.prvKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags.SphinxFlagFromBytes(commands.Flag) == flags.LastHopFlag {
+			return hop, newPacket, hops
+		}
+		next, ok := nodes[hop.Id]
+		if !ok {
+			t.Fatalf("unknown next hop %v", hop.Id)
+		}
+		packet = newPacket
+		current = next
+	}
+}
+
+func TestCryptoClient_PackMulticast(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	ingress, nodes := setupKeyedNetwork(t, 3)
+
+	recipients := make([]config.ClientConfig, 3)
+	for i := range recipients {
+		egress, err := createKeyedNode(config.ProviderLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[egress.cfg.Id] = egress
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients[i] = config.NewClientConfig(fmt.Sprintf("Recipient%d", i), "localhost", "9999", pub.Bytes(), egress.cfg)
+	}
+
+	message := []byte("Hello world")
+	packets, ingresses, err := client.PackMulticast(message, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, packets, len(recipients))
+	assert.Len(t, ingresses, len(recipients))
+
+	alphas := make(map[string]struct{})
+	for i, packet := range packets {
+		var sphinxPacket sphinx.SphinxPacket
+		if err := proto.Unmarshal(packet, &sphinxPacket); err != nil {
+			t.Fatal(err)
+		}
+		// each packet has to be built with its own fresh secrets
+		alphas[string(sphinxPacket.Hdr.Alpha)] = struct{}{}
+
+		assert.Equal(t, ingress.cfg, ingresses[i])
+		finalHop, payload, _ := unwrapAllLayers(t, packet, ingress, nodes)
+		assert.Equal(t, recipients[i].Id, finalHop.Id)
+		assert.Equal(t, message, payload)
+	}
+	assert.Len(t, alphas, len(packets))
+}
+
+func TestCryptoClient_PackMulticast_InvalidRecipient(t *testing.T) {
+	_, _, err := client.PackMulticast([]byte("Hello world"), []config.ClientConfig{{Id: "NoProvider"}})
+	assert.Error(t, err)
+}
+
+func TestCryptoClient_EncodeLoopCoverMessage(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	ingress, nodes := setupKeyedNetwork(t, 3)
+
+	egress, err := createKeyedNode(config.ProviderLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[egress.cfg.Id] = egress
+	client.Network.Providers = append(client.Network.Providers, egress.cfg)
+	_, recipientKey, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := config.NewClientConfig(base64.URLEncoding.EncodeToString(recipientKey.Bytes()),
+		"localhost",
+		"9999",
+		recipientKey.Bytes(),
+		egress.cfg,
+	)
+	self := config.NewClientConfig(base64.URLEncoding.EncodeToString(client.GetPublicKey().Bytes()),
+		"localhost",
+		"9998",
+		client.GetPublicKey().Bytes(),
+		ingress.cfg,
+	)
+
+	realPacket, realIngress, err := client.EncodeMessage([]byte("Hello world"), recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverPacket, coverIngress, err := client.EncodeLoopCoverMessage(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ingress.cfg, realIngress)
+	// the loop ends at our own provider, so it has to enter the network through the other one
+	assert.Equal(t, egress.cfg, coverIngress)
+
+	var realSphinxPacket, coverSphinxPacket sphinx.SphinxPacket
+	if err := proto.Unmarshal(realPacket, &realSphinxPacket); err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(coverPacket, &coverSphinxPacket); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, coverSphinxPacket.Hdr.Alpha, len(realSphinxPacket.Hdr.Alpha))
+	assert.Len(t, coverSphinxPacket.Hdr.Beta, len(realSphinxPacket.Hdr.Beta))
+	assert.Len(t, coverSphinxPacket.Hdr.Mac, len(realSphinxPacket.Hdr.Mac))
+
+	realFinalHop, _, realHops := unwrapAllLayers(t, realPacket, ingress, nodes)
+	coverFinalHop, coverPayload, coverHops := unwrapAllLayers(t, coverPacket, egress, nodes)
+	assert.Equal(t, realHops, coverHops)
+	assert.Equal(t, recipient.Id, realFinalHop.Id)
+	assert.Equal(t, self.Id, coverFinalHop.Id)
+	assert.True(t, IsLoopCoverMessage(coverPayload))
+}
+
+func TestCryptoClient_SetPathLength(t *testing.T) {
+	defer func() {
+		assert.Nil(t, client.SetPathLength(DefaultPathLength))
+	}()
+
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	ingress, nodes := setupKeyedNetwork(t, 5)
+	recipient := createRecipient(t, nodes)
+
+	for _, pathLength := range []int{1, 3, 5} {
+		assert.Nil(t, client.SetPathLength(pathLength))
+		packet, _, err := client.EncodeMessage([]byte("Hello world"), recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, hops := unwrapAllLayers(t, packet, ingress, nodes)
+		// the packet also goes through both providers
+		assert.Equal(t, pathLength+2, hops)
+	}
+}
+
+func TestCryptoClient_SetPathLength_Invalid(t *testing.T) {
+	for _, pathLength := range []int{-1, 0, MaxPathLength + 1} {
+		assert.Equal(t, ErrInvalidPathLength, client.SetPathLength(pathLength))
+	}
+	assert.Equal(t, DefaultPathLength, client.PathLength())
+}
+
+func TestCryptoClient_SetPathLength_InsufficientTopology(t *testing.T) {
+	defer func() {
+		assert.Nil(t, client.SetPathLength(DefaultPathLength))
+	}()
+
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	recipient := createRecipient(t, nil)
+
+	assert.Nil(t, client.SetPathLength(4))
+	_, _, err := client.EncodeMessage([]byte("Hello world"), recipient)
+	assert.Equal(t, &InsufficientMixesError{Available: 3, Required: 4}, err)
+
+	// the client can tell the network is not ready from the error of sending the message
+	_, err = client.SendMessage(recipient, "Hello world")
+	assert.True(t, IsInsufficientMixes(err))
+	assert.False(t, IsNetworkSendError(err))
+	assert.False(t, IsInsufficientMixes(&SendError{Err: ErrIncompatibleProvider}))
+}
+
+func TestCryptoClient_BuildPath_IncompatibleParams(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	recipient := createRecipient(t, nil)
+	hops := client.PathLength() + 2
+	incompatible := []*config.SphinxParams{
+		{K: sphinx.K, MaxHops: uint32(hops - 1)},
+		{K: 2 * sphinx.K, MaxHops: sphinx.MaxHops},
+	}
+
+	for _, params := range incompatible {
+		// one of the mixes on each layer advertises parameters incompatible with the path
+		excluded := make(map[string]struct{})
+		for layer, layerMixes := range client.Network.Mixes {
+			layerMixes[0].Params = params
+			excluded[layerMixes[0].Id] = struct{}{}
+			client.Network.Mixes[layer] = layerMixes
+		}
+		for i := 0; i < 20; i++ {
+			path, err := client.buildPath(recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mix := range path.Mixes {
+				assert.NotContains(t, excluded, mix.Id, "Incompatible mix should not have been chosen")
+			}
+		}
+
+		// the layer without any compatible mixes can't be used at all
+		for i := range client.Network.Mixes[2] {
+			client.Network.Mixes[2][i].Params = params
+		}
+		_, err := client.buildPath(recipient)
+		assert.Error(t, err)
+		setupKeyedNetwork(t, 3)
+		recipient = createRecipient(t, nil)
+	}
+
+	// an incompatible own provider is never used as the ingress one
+	compatible := &config.SphinxParams{K: sphinx.K, MaxHops: uint32(hops)}
+	other := createRecipient(t, nil)
+	client.Provider.Params = incompatible[0]
+	client.Network.Providers[0].Params = incompatible[0]
+	for i := 0; i < 10; i++ {
+		path, err := client.buildPath(recipient)
+		assert.Nil(t, err)
+		assert.Equal(t, *other.Provider, path.IngressProvider)
+	}
+	// so the path is impossible if there is no other compatible provider
+	client.Network.Providers = client.Network.Providers[:2]
+	_, err := client.buildPath(recipient)
+	assert.Equal(t, ErrNoDistinctProvider, err)
+
+	// while an incompatible provider of the recipient makes the path impossible
+	client.Provider.Params = compatible
+	recipient.Provider.Params = incompatible[0]
+	_, err = client.buildPath(recipient)
+	assert.Equal(t, ErrIncompatibleProvider, err)
+}
+
+func TestCryptoClient_BuildPath_ExcludesProvidersFromMixes(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	recipient := createRecipient(t, nil)
+
+	// both providers are also announced as mixes on every layer
+	providers := map[string]config.MixConfig{"sender's": client.Provider, "recipient's": *recipient.Provider}
+	for layer := range client.Network.Mixes {
+		for _, provider := range providers {
+			provider.Layer = uint64(layer)
+			client.Network.Mixes[layer] = append(client.Network.Mixes[layer], provider)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		path, err := client.buildPath(recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mix := range path.Mixes {
+			for name, provider := range providers {
+				assert.NotEqual(t, provider.PubKey, mix.PubKey, "The %v provider should not have been chosen as a mix", name)
+			}
+		}
+	}
+
+	// the layer whose only mixes are the providers can't be used at all
+	client.Network.Mixes[2] = []config.MixConfig{client.Provider, *recipient.Provider}
+	_, err := client.buildPath(recipient)
+	assert.Error(t, err)
+}
+
+func TestCryptoClient_SetRand_ReproduciblePaths(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+		client.SetRand(helpers.NewRand())
+	}()
+
+	ingress, _ := setupKeyedNetwork(t, 3)
+	for i := 0; i < 3; i++ {
+		createRecipient(t, nil)
+	}
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recipient uses the same provider as the client, so that the ingress provider is chosen at random as well
+	recipient := config.NewClientConfig("Recipient", "localhost", "9999", pub.Bytes(), ingress.cfg)
+
+	selectPaths := func(seed int64) ([]config.E2EPath, [][]float64) {
+		client.SetRand(helpers.NewSeededRand(seed))
+		var paths []config.E2EPath
+		var delays [][]float64
+		for i := 0; i < 20; i++ {
+			path, err := client.buildPath(recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pathDelays, err := client.generateDelaySequence(helpers.ExponentialDelay{Rate: 5}, path.Len(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, path)
+			delays = append(delays, pathDelays)
+		}
+		return paths, delays
+	}
+
+	paths, delays := selectPaths(42)
+	repeatedPaths, repeatedDelays := selectPaths(42)
+	assert.Equal(t, paths, repeatedPaths)
+	assert.Equal(t, delays, repeatedDelays)
+
+	otherPaths, otherDelays := selectPaths(1789)
+	assert.NotEqual(t, paths, otherPaths)
+	assert.NotEqual(t, delays, otherDelays)
 }
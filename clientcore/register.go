@@ -0,0 +1,180 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+const (
+	registrationTimeout = 10 * time.Second
+)
+
+var (
+	// ErrInvalidProviderResponse defines an error when the provider did not respond with exactly one token,
+	// or one packet of whatever type the request expects.
+	ErrInvalidProviderResponse = errors.New("invalid provider response")
+)
+
+// Register registers the client at the given provider by sending it the client's public configuration
+// in a packet with the AssignFlag. The authentication token sent back by the provider is stored,
+// so that it could be used in the subsequent pull requests, and the provider becomes the client's provider.
+// Register returns an error if the provider could not be reached or if its response was not a single token.
+// If the provider rejected the registration, the error is a *config.ProviderError.
+func (c *CryptoClient) Register(provider config.MixConfig) error {
+	clientConfig := config.ClientConfig{Id: base64.URLEncoding.EncodeToString(c.pubKey.Bytes()),
+		PubKey:   c.pubKey.Bytes(),
+		Provider: &provider,
+	}
+	confBytes, err := proto.Marshal(&clientConfig)
+	if err != nil {
+		c.log.Errorf("Error in Register - marshal of client config returned an error: %v", err)
+		return err
+	}
+	packetBytes, err := config.WrapWithFlag(flags.AssignFlag, confBytes)
+	if err != nil {
+		c.log.Errorf("Error in Register - wrap with flag returned an error: %v", err)
+		return err
+	}
+
+	token, err := requestToken(provider, packetBytes)
+	if err != nil {
+		c.log.Errorf("Error in Register - failed to obtain the token: %v", err)
+		return err
+	}
+
+	c.Provider = provider
+	c.token = token
+	c.log.Debugf("Registered token %s", c.token)
+	return nil
+}
+
+// RotateToken asks the provider of the client to replace its current token, e.g. if it might have leaked,
+// with a fresh one, which is used in the subsequent pull requests. The previous token is no longer accepted
+// by the provider afterwards. If the provider rejected the request, the error is a *config.ProviderError
+// and the current token is kept.
+func (c *CryptoClient) RotateToken() error {
+	rqsBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: c.pubKey.Bytes(), Token: c.token})
+	if err != nil {
+		return err
+	}
+	packetBytes, err := config.WrapWithFlag(flags.RotateTokenFlag, rqsBytes)
+	if err != nil {
+		return err
+	}
+
+	token, err := requestToken(c.Provider, packetBytes)
+	if err != nil {
+		c.log.Errorf("Error in RotateToken - failed to obtain the token: %v", err)
+		return err
+	}
+	c.token = token
+	c.log.Debugf("Rotated token %s", c.token)
+	return nil
+}
+
+// Status asks the provider of the client whether the client is registered, when its current token was issued
+// and how many messages await it, e.g. to tell a lost registration apart from a delivery problem.
+// The status of a client which is not registered is reported regardless of its token. If the provider
+// rejected the request, e.g. as the token of the registered client is not valid, the error is a *config.ProviderError.
+func (c *CryptoClient) Status() (config.ClientStatus, error) {
+	rqsBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: c.pubKey.Bytes(), Token: c.token})
+	if err != nil {
+		return config.ClientStatus{}, err
+	}
+	packetBytes, err := config.WrapWithFlag(flags.StatusFlag, rqsBytes)
+	if err != nil {
+		return config.ClientStatus{}, err
+	}
+
+	data, err := request(c.Provider, packetBytes, flags.StatusFlag)
+	if err != nil {
+		c.log.Errorf("Error in Status - failed to obtain the status: %v", err)
+		return config.ClientStatus{}, err
+	}
+	return config.UnwrapClientStatus(data)
+}
+
+// requestToken sends the packet to the provider and reads the token it responds with.
+func requestToken(provider config.MixConfig, packetBytes []byte) ([]byte, error) {
+	return request(provider, packetBytes, flags.TokenFlag)
+}
+
+// request sends the packet to the provider and reads the data of its response, which is expected
+// to be a single packet with the given flag.
+func request(provider config.MixConfig, packetBytes []byte, flag flags.PacketTypeFlag) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(provider.Host, provider.Port), registrationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(registrationTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(packetBytes); err != nil {
+		return nil, err
+	}
+	return readResponse(config.NewFrameReader(bufio.NewReader(conn)), flag)
+}
+
+// readResponse reads the response of the provider to a request other than a pull, e.g. the registration
+// or token rotation request, which is expected to consist of a single packet with the given flag.
+// If the provider responded with an error instead, it is returned as *config.ProviderError.
+func readResponse(frames *config.FrameReader, flag flags.PacketTypeFlag) ([]byte, error) {
+	frame, err := frames.Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidProviderResponse
+		}
+		return nil, err
+	}
+
+	packet, err := config.UnwrapPacket(frame)
+	if err != nil {
+		return nil, err
+	}
+	if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.ErrorFlag {
+		providerErr, err := config.UnwrapError(packet.Data)
+		if err != nil {
+			return nil, err
+		}
+		return nil, providerErr
+	}
+	if flags.PacketTypeFlagFromBytes(packet.Flag) != flag || len(packet.Data) == 0 {
+		return nil, ErrInvalidProviderResponse
+	}
+
+	// there should be nothing else in the response
+	if _, err := frames.Next(); err != io.EOF {
+		return nil, ErrInvalidProviderResponse
+	}
+	return packet.Data, nil
+}
+
+// Token returns the authentication token obtained from the provider during the registration,
+// or the last time it was rotated.
+func (c *CryptoClient) Token() []byte {
+	return c.token
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeProvider starts a provider that replies to a single registration request with the given frames.
+// The received client configuration is sent on the returned channel.
+func startFakeProvider(t *testing.T, responseFrames ...[]byte) (config.MixConfig, <-chan config.ClientConfig) {
+	provider, requestCh := startFakeProviderFor(t, flags.AssignFlag, responseFrames...)
+	receivedCh := make(chan config.ClientConfig, 1)
+	go func() {
+		var clientConfig config.ClientConfig
+		if err := proto.Unmarshal(<-requestCh, &clientConfig); err != nil {
+			return
+		}
+		receivedCh <- clientConfig
+	}()
+	return provider, receivedCh
+}
+
+// startFakeProviderFor starts a provider that replies to a single request with the given flag with the given frames.
+// The data of the received request is sent on the returned channel.
+func startFakeProviderFor(t *testing.T,
+	flag flags.PacketTypeFlag,
+	responseFrames ...[]byte,
+) (config.MixConfig, <-chan []byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receivedCh := make(chan []byte, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buff := make([]byte, 2048)
+		reqLen, err := conn.Read(buff)
+		if err != nil {
+			return
+		}
+		packet, err := config.UnwrapPacket(buff[:reqLen])
+		if err != nil || flags.PacketTypeFlagFromBytes(packet.Flag) != flag {
+			return
+		}
+		receivedCh <- packet.Data
+
+		for _, frame := range responseFrames {
+			if err := config.WriteFrame(conn, frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	return config.MixConfig{Id: "FakeProvider", Host: host, Port: port, Layer: config.ProviderLayer}, receivedCh
+}
+
+func wrapTestPacket(t *testing.T, flag flags.PacketTypeFlag, data []byte) []byte {
+	packetBytes, err := config.WrapWithFlag(flag, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packetBytes
+}
+
+func TestCryptoClient_Register(t *testing.T) {
+	oldProvider := client.Provider
+	defer func() {
+		client.Provider = oldProvider
+	}()
+
+	token := []byte("AuthenticationToken")
+	provider, receivedCh := startFakeProvider(t, wrapTestPacket(t, flags.TokenFlag, token))
+
+	assert.Nil(t, client.Register(provider))
+	assert.Equal(t, token, client.Token())
+	assert.True(t, proto.Equal(&provider, &client.Provider))
+
+	receivedConfig := <-receivedCh
+	assert.Equal(t, client.GetPublicKey().Bytes(), receivedConfig.PubKey)
+	assert.Equal(t, provider.Id, receivedConfig.Provider.Id)
+}
+
+func TestCryptoClient_Register_ConnectionError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nobody is going to be listening there anymore
+	listener.Close()
+
+	assert.Error(t, client.Register(config.MixConfig{Host: host, Port: port}))
+}
+
+func TestCryptoClient_Register_MalformedResponse(t *testing.T) {
+	oldProvider := client.Provider
+	defer func() {
+		client.Provider = oldProvider
+	}()
+
+	malformedResponses := [][][]byte{
+		// no response at all
+		{},
+		// response with a different flag
+		{wrapTestPacket(t, flags.CommFlag, []byte("AuthenticationToken"))},
+		// empty token
+		{wrapTestPacket(t, flags.TokenFlag, nil)},
+		// more than a single token
+		{wrapTestPacket(t, flags.TokenFlag, []byte("Token1")), wrapTestPacket(t, flags.TokenFlag, []byte("Token2"))},
+		// garbage
+		{[]byte("garbage")},
+	}
+
+	for _, response := range malformedResponses {
+		oldToken := client.Token()
+		provider, _ := startFakeProvider(t, response...)
+		assert.Error(t, client.Register(provider))
+		assert.Equal(t, oldToken, client.Token(), "Token should not have been changed")
+	}
+}
+
+func TestCryptoClient_Register_ErrorResponse(t *testing.T) {
+	oldProvider := client.Provider
+	defer func() {
+		client.Provider = oldProvider
+	}()
+
+	errorResponse, err := config.WrapError(config.ErrorCodeMalformedRequest, "malformed request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := client.Token()
+	provider, _ := startFakeProvider(t, errorResponse)
+
+	err = client.Register(provider)
+	assert.True(t, config.IsProviderError(err, config.ErrorCodeMalformedRequest), "Unexpected error %v", err)
+	assert.Equal(t, oldToken, client.Token(), "Token should not have been changed")
+}
+
+func TestCryptoClient_RotateToken(t *testing.T) {
+	oldProvider := client.Provider
+	oldToken := client.Token()
+	defer func() {
+		client.Provider = oldProvider
+		client.token = oldToken
+	}()
+
+	token := []byte("RotatedToken")
+	provider, requestCh := startFakeProviderFor(t, flags.RotateTokenFlag, wrapTestPacket(t, flags.TokenFlag, token))
+	client.Provider = provider
+	client.token = []byte("LeakedToken")
+
+	assert.Nil(t, client.RotateToken())
+	assert.Equal(t, token, client.Token())
+
+	var request config.PullRequest
+	assert.Nil(t, proto.Unmarshal(<-requestCh, &request))
+	assert.Equal(t, client.GetPublicKey().Bytes(), request.ClientPublicKey)
+	assert.Equal(t, []byte("LeakedToken"), request.Token)
+
+	// the token is kept if the provider rejects the rotation
+	errorResponse, err := config.WrapError(config.ErrorCodeAuthenticationFailed, "authentication failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Provider, _ = startFakeProviderFor(t, flags.RotateTokenFlag, errorResponse)
+	err = client.RotateToken()
+	assert.True(t, config.IsProviderError(err, config.ErrorCodeAuthenticationFailed), "Unexpected error %v", err)
+	assert.Equal(t, token, client.Token())
+}
+
+func TestCryptoClient_Status(t *testing.T) {
+	oldProvider := client.Provider
+	defer func() {
+		client.Provider = oldProvider
+	}()
+
+	status := config.ClientStatus{Registration: config.RegistrationStatusRegistered,
+		TokenIssuedAt:    time.Unix(1562581200, 0),
+		BufferedMessages: 3,
+	}
+	statusBytes, err := config.WrapClientStatus(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, requestCh := startFakeProviderFor(t, flags.StatusFlag, statusBytes)
+	client.Provider = provider
+
+	received, err := client.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, status, received)
+
+	var request config.PullRequest
+	assert.Nil(t, proto.Unmarshal(<-requestCh, &request))
+	assert.Equal(t, client.GetPublicKey().Bytes(), request.ClientPublicKey)
+	assert.Equal(t, client.Token(), request.Token)
+
+	errorResponse, err := config.WrapError(config.ErrorCodeAuthenticationFailed, "authentication failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Provider, _ = startFakeProviderFor(t, flags.StatusFlag, errorResponse)
+	_, err = client.Status()
+	assert.True(t, config.IsProviderError(err, config.ErrorCodeAuthenticationFailed), "Unexpected error %v", err)
+}
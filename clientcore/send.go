@@ -0,0 +1,105 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+const (
+	sendTimeout     = 10 * time.Second
+	messageIDLength = 16
+)
+
+// SendError describes a failure of SendMessage.
+type SendError struct {
+	// Network is set if the packet was created, but could not be delivered to its ingress provider.
+	// Otherwise the packet could not be created, e.g. as no valid path to the recipient could be built.
+	Network bool
+	Err     error
+}
+
+func (e *SendError) Error() string {
+	if e.Network {
+		return fmt.Sprintf("failed to deliver the packet: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to create the packet: %v", e.Err)
+}
+
+// IsNetworkSendError checks whether the given error is a SendError caused by the network
+// rather than by the construction of the packet.
+func IsNetworkSendError(err error) bool {
+	sendErr, ok := err.(*SendError)
+	return ok && sendErr.Network
+}
+
+// SendMessage encodes the message into a sphinx packet over a path built from the current NetworkPKI,
+// wraps it with the CommFlag and sends it to the ingress provider of the path.
+// SendMessage returns the id the client can refer to the message with; the id is never sent over the network.
+// Any error returned is a SendError, telling apart failures of creating the packet and of delivering it.
+func (c *CryptoClient) SendMessage(recipient config.ClientConfig, message string) (string, error) {
+	sphinxPacket, ingress, err := c.EncodeMessage([]byte(message), recipient)
+	if err != nil {
+		return "", &SendError{Err: err}
+	}
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
+	if err != nil {
+		c.log.Errorf("Error in SendMessage - wrap with flag returned an error: %v", err)
+		return "", &SendError{Err: err}
+	}
+	id, err := newMessageID()
+	if err != nil {
+		return "", &SendError{Err: err}
+	}
+
+	if err := sendPacket(packetBytes, ingress); err != nil {
+		c.log.Errorf("Error in SendMessage - sending to %v failed: %v", ingress.Id, err)
+		c.ReportNodeFailure(ingress)
+		return "", &SendError{Network: true, Err: err}
+	}
+	c.log.Debugf("Sent message %v to %v through %v", id, recipient.Id, ingress.Id)
+	return id, nil
+}
+
+// sendPacket writes the packet to the given node, without waiting for any response.
+func sendPacket(packet []byte, node config.MixConfig) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(node.Host, node.Port), sendTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(sendTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(packet)
+	return err
+}
+
+// newMessageID generates a random id of a message.
+func newMessageID() (string, error) {
+	id := make([]byte, messageIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientcore
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeIngressProvider makes the ingress provider of the test client listen on a random port
+// for a single packet, whose data is sent on the returned channel.
+func startFakeIngressProvider(t *testing.T, ingress *keyedNode) <-chan []byte {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress.cfg.Host, ingress.cfg.Port = host, port
+	client.Provider = ingress.cfg
+	client.Network.Providers = []config.MixConfig{ingress.cfg}
+
+	receivedCh := make(chan []byte, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// the packet is sent on its own, hence it lasts until the client closes the connection
+		packetBytes, err := ioutil.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		packet, err := config.UnwrapPacket(packetBytes)
+		if err != nil || flags.PacketTypeFlagFromBytes(packet.Flag) != flags.CommFlag {
+			return
+		}
+		receivedCh <- packet.Data
+	}()
+	return receivedCh
+}
+
+func TestCryptoClient_SendMessage(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	ingress, nodes := setupKeyedNetwork(t, 3)
+	receivedCh := startFakeIngressProvider(t, &ingress)
+	recipient := createRecipient(t, nodes)
+
+	id, err := client.SendMessage(recipient, "Hello world")
+	assert.Nil(t, err)
+	assert.Len(t, id, 2*messageIDLength)
+
+	hop, payload, _ := unwrapAllLayers(t, <-receivedCh, ingress, nodes)
+	assert.Equal(t, recipient.Id, hop.Id)
+	assert.Equal(t, []byte("Hello world"), payload)
+
+	// each message gets its own id
+	receivedCh = startFakeIngressProvider(t, &ingress)
+	otherID, err := client.SendMessage(recipient, "Hello world")
+	assert.Nil(t, err)
+	assert.NotEqual(t, id, otherID)
+	<-receivedCh
+}
+
+func TestCryptoClient_SendMessage_PathError(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	setupKeyedNetwork(t, 3)
+	// the recipient's provider does not support paths as long as the ones of the client
+	recipient := createRecipient(t, nil)
+	recipient.Provider.Params = &config.SphinxParams{K: sphinx.K, MaxHops: uint32(client.PathLength())}
+
+	_, err := client.SendMessage(recipient, "Hello world")
+	if assert.IsType(t, &SendError{}, err) {
+		assert.False(t, IsNetworkSendError(err))
+		assert.Equal(t, ErrIncompatibleProvider, err.(*SendError).Err)
+	}
+}
+
+func TestCryptoClient_SendMessage_NetworkError(t *testing.T) {
+	oldNetwork, oldProvider := client.Network, client.Provider
+	defer func() {
+		client.Network, client.Provider = oldNetwork, oldProvider
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nobody is going to be listening there anymore
+	listener.Close()
+
+	setupKeyedNetwork(t, 3)
+	client.Provider.Host, client.Provider.Port = host, port
+	recipient := createRecipient(t, nil)
+
+	_, err = client.SendMessage(recipient, "Hello world")
+	assert.Error(t, err)
+	assert.True(t, IsNetworkSendError(err))
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+/*
+	Package clock abstracts the passage of time, so that the time-dependent behaviour of the nodes,
+	such as the expiry of packets and tokens or the periodic tasks, could be tested deterministically.
+*/
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the current time and notifies about the passage of time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel which receives the current time once the given duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker which sends the current time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a clock at regular intervals.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker. No more ticks are sent afterwards.
+	Stop()
+}
+
+// Sleep pauses the current goroutine for at least the duration d measured by the given clock.
+func Sleep(c Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-c.After(d)
+}
+
+type realClock struct{}
+
+// New returns a Clock backed by the system time.
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMock_After(t *testing.T) {
+	start := time.Now()
+	clk := NewMock(start)
+	ch := clk.After(time.Second)
+
+	clk.Advance(999 * time.Millisecond)
+	assert.Len(t, ch, 0, "The timer should not have fired yet")
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-ch)
+
+	// non-positive durations fire straight away
+	assert.Equal(t, start.Add(time.Second), <-clk.After(0))
+}
+
+func TestMock_Ticker(t *testing.T) {
+	start := time.Now()
+	clk := NewMock(start)
+	ticker := clk.NewTicker(time.Minute)
+
+	clk.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), <-ticker.C())
+	clk.Advance(30 * time.Second)
+	assert.Len(t, ticker.C(), 0)
+	clk.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(2*time.Minute), <-ticker.C())
+
+	// the ticks the receiver did not keep up with are dropped
+	clk.Advance(10 * time.Minute)
+	assert.Len(t, ticker.C(), 1)
+	<-ticker.C()
+
+	ticker.Stop()
+	clk.Advance(time.Hour)
+	assert.Len(t, ticker.C(), 0, "Stopped ticker should not tick")
+}
+
+func TestMock_BlockUntil(t *testing.T) {
+	clk := NewMock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		Sleep(clk, time.Second)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-done
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Mock is a Clock whose time only moves when it is advanced explicitly.
+// All the timers and tickers created by it fire synchronously from within Advance.
+type Mock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters map[*mockWaiter]struct{}
+}
+
+// mockWaiter is a pending After call or an active ticker of the mock clock.
+type mockWaiter struct {
+	deadline time.Time
+	// period is 0 for the waiters created by After, which fire only once.
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewMock returns a mock clock set to the given time.
+func NewMock(now time.Time) *Mock {
+	m := &Mock{now: now, waiters: make(map[*mockWaiter]struct{})}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// Now returns the current time of the mock clock.
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// After returns a channel which receives the time of the mock clock once it was advanced by at least d.
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- m.now
+		return ch
+	}
+	m.addWaiter(&mockWaiter{deadline: m.now.Add(d), ch: ch})
+	return ch
+}
+
+// NewTicker returns a ticker which ticks every time the mock clock is advanced past its next tick.
+// Like with time.Ticker, the ticks are dropped if the receiver does not keep up with them.
+// It panics if d is not positive.
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.Mock.NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := &mockWaiter{deadline: m.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	m.addWaiter(w)
+	return &mockTicker{mock: m, waiter: w}
+}
+
+func (m *Mock) addWaiter(w *mockWaiter) {
+	m.waiters[w] = struct{}{}
+	m.cond.Broadcast()
+}
+
+// Advance moves the time of the mock clock forward by d, firing all the timers and tickers due in the meantime.
+func (m *Mock) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+	for w := range m.waiters {
+		if w.deadline.After(m.now) {
+			continue
+		}
+		select {
+		case w.ch <- m.now:
+		default:
+		}
+		if w.period == 0 {
+			delete(m.waiters, w)
+			continue
+		}
+		for !w.deadline.After(m.now) {
+			w.deadline = w.deadline.Add(w.period)
+		}
+	}
+}
+
+// BlockUntil blocks until there are at least n pending timers and active tickers created by the mock clock.
+// It allows tests to wait for a goroutine to start waiting on the clock before advancing it.
+func (m *Mock) BlockUntil(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.waiters) < n {
+		m.cond.Wait()
+	}
+}
+
+type mockTicker struct {
+	mock   *Mock
+	waiter *mockWaiter
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *mockTicker) Stop() {
+	t.mock.mu.Lock()
+	defer t.mock.mu.Unlock()
+	delete(t.mock.waiters, t.waiter)
+}
@@ -15,12 +15,14 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
 
 	"github.com/nymtech/nym-mixnet/client"
 	"github.com/nymtech/nym-mixnet/client/benchclient"
 	clientConfig "github.com/nymtech/nym-mixnet/client/config"
+	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/helpers/topology"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -28,7 +30,6 @@ import (
 
 const (
 	defaultBenchmarkClientID = "BenchmarkClient"
-	benchmarkProviderID      = "EaoPlptL8EI2ZIN_wQqFID6bCS7INzx930yqp2QisHU="
 )
 
 // I think here we need to sacrifice the linter error of too long lines for the formatting as it would hideous
@@ -38,7 +39,9 @@ func cmdRun(args []string, usage string) {
 	opts := newOpts("run [OPTIONS]", usage)
 	numMessages := opts.Flags("--num").Label("NUMMESSAGES").Int("Number of benchmark messages to send", 0)
 	interval := opts.Flags("--interval").Label("INTERVAL").Duration("Minimum interval between messages to be sent", 0)
-	preGenerate := opts.Flags("--pregenerate").Label("PREGENERATE").Bool("Whether to pregenerate single packet to send it over and over again")
+	preGenerate := opts.Flags("--pregenerate").Label("PREGENERATE").Bool("Whether to pregenerate single packet to send it over and over again. The mixes on its path have to run with --unsafe-disable-replay-protection, as they drop the replayed packet otherwise")
+	jsonOutput := opts.Flags("--json").Bool("Print the benchmark results as JSON")
+	concurrency := opts.Flags("--concurrency").Label("SENDERS").Int("Number of concurrent senders the messages are split between", 1)
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
@@ -64,6 +67,12 @@ func cmdRun(args []string, usage string) {
 	cfg.Debug.RateCompliantCoverMessagesDisabled = true
 	cfg.Client.DirectoryServerTopologyEndpoint = clientConfig.DefaultLocalDirectoryServerTopologyEndpoint
 
+	_, benchmarkProviderKey, err := sphinx.GenerateDeterministicKeyPair([]byte(constants.BenchmarkProviderKeySeed))
+	if err != nil {
+		panic(err)
+	}
+	benchmarkProviderID := base64.URLEncoding.EncodeToString(benchmarkProviderKey.Bytes())
+
 	// get an Ingress provider that IS NOT the benchmark provider
 	initialTopology, err := topology.GetNetworkTopology(cfg.Client.DirectoryServerTopologyEndpoint)
 	if err != nil || len(initialTopology.MixProviderNodes) == 0 {
@@ -83,15 +92,20 @@ func cmdRun(args []string, usage string) {
 		panic(err)
 	}
 
-	benchClient, err := benchclient.NewBenchClient(client, *numMessages, *interval, *preGenerate)
+	benchClient, err := benchclient.NewBenchClient(client, *numMessages, *interval, *preGenerate, *concurrency)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := benchClient.RunBench(); err != nil {
+	result, err := benchClient.RunBench()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to spawn client instance: %v\n", err)
 		os.Exit(-1)
 	}
+	if err := result.Print(os.Stdout, *jsonOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print the benchmark results: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func newOpts(command string, usage string) *optparse.Parser {
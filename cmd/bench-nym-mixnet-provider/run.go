@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/server/provider"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -35,6 +36,7 @@ func cmdRun(args []string, usage string) {
 	opts := newOpts("run [OPTIONS]", usage)
 	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnet-provider listens", defaultBenchmarkProviderPort)
 	numMessages := opts.Flags("--num").Label("NUMMESSAGES").Int("Number of benchmark messages to send", 0)
+	jsonOutput := opts.Flags("--json").Bool("Print the final benchmark statistics as JSON")
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
@@ -48,16 +50,17 @@ func cmdRun(args []string, usage string) {
 	}
 
 	// have constant keys to simplify the procedure so that pki/database would not need to be reset every run
-	privP := sphinx.BytesToPrivateKey([]byte{191, 43, 90, 175, 50, 224, 156, 22, 204, 173, 87, 255, 64, 152, 17,
-		30, 48, 162, 36, 95, 57, 34, 187, 183, 203, 215, 25, 172, 55, 199, 211, 59})
-	pubP := sphinx.BytesToPublicKey([]byte{17, 170, 15, 150, 155, 75, 240, 66, 54, 100, 131, 127, 193, 10,
-		133, 32, 62, 155, 9, 46, 200, 55, 60, 125, 223, 76, 170, 167, 100, 34, 176, 117})
+	privP, pubP, err := sphinx.GenerateDeterministicKeyPair([]byte(constants.BenchmarkProviderKeySeed))
+	if err != nil {
+		panic(err)
+	}
 
 	baseProviderServer, err := provider.NewProviderServer(defaultBenchmarkProviderID,
 		defaultBenchmarkProviderHost,
 		*port,
 		privP,
 		pubP,
+		"",
 	)
 	if err != nil {
 		panic(err)
@@ -66,7 +69,7 @@ func cmdRun(args []string, usage string) {
 	b64Key := base64.URLEncoding.EncodeToString(pubP.Bytes())
 	fmt.Println(b64Key)
 
-	benchmarkProviderServer, err := provider.NewBenchProvider(baseProviderServer, *numMessages)
+	benchmarkProviderServer, err := provider.NewBenchProvider(baseProviderServer, *numMessages, *jsonOutput)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/nymtech/nym-mixnet/server/provider"
+)
+
+func cmdInit(args []string, usage string) {
+	opts := newOpts("init [OPTIONS]", usage)
+	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnet-provider we want to initialize", defaultID)
+	defaults := provider.DefaultConfig()
+	home := opts.Flags("--home").Label("DIR").String(
+		fmt.Sprintf("Home directory the keys and the config template are written to (default %v, or $%v)",
+			provider.DefaultHomeDir("<ID>"),
+			provider.EnvHome,
+		),
+		"",
+	)
+	host := opts.Flags("--host").Label("HOST").String(
+		fmt.Sprintf("The host on which the nym-mixnet-provider is going to run (or $%v). "+
+			"If empty, the local IP address is detected when it starts", provider.EnvHost),
+		defaultHost,
+	)
+	port := opts.Flags("--port").Label("PORT").String(
+		fmt.Sprintf("Port on which nym-mixnet-provider is going to listen (default %v, or $%v)", defaults.Port, provider.EnvPort),
+		"",
+	)
+	inboxesDir := opts.Flags("--inboxes").Label("DIR").String(
+		fmt.Sprintf("Directory of the client inboxes, relative to the home directory (default %v, or $%v)",
+			defaults.InboxesDir,
+			provider.EnvInboxRoot,
+		),
+		"",
+	)
+	directoryURL := opts.Flags("--directory").Label("URL").String(
+		fmt.Sprintf("URL of the directory server (or $%v). "+
+			"By default the public one is used, or the local one if running on a loopback address", provider.EnvDirectoryURL),
+		"",
+	)
+	force := opts.Flags("--force").Bool("Overwrite the existing keys and config template of the provider")
+
+	params := opts.Parse(args)
+	if len(params) != 0 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	cfg := defaults.
+		Overlay(provider.Config{HomeDir: provider.DefaultHomeDir(*id)}).
+		Overlay(provider.ConfigFromEnv(os.LookupEnv)).
+		Overlay(provider.Config{HomeDir: *home,
+			Host:         *host,
+			Port:         *port,
+			InboxesDir:   *inboxesDir,
+			DirectoryURL: *directoryURL,
+		})
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	pubP, err := provider.InitHome(cfg, *force)
+	if err == provider.ErrAlreadyInitialized {
+		fmt.Fprintf(os.Stderr, "%v has already been initialized, use --force to overwrite its keys\n", cfg.HomeDir)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize the provider: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", cfg.PrivateKeyPath())
+	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", cfg.PublicKeyPath())
+	fmt.Fprintf(os.Stdout, "Saved config template to %v, edit it before running the provider\n", cfg.EnvFilePath())
+	fmt.Fprintf(os.Stdout, "Public key of the provider: %v\n", base64.URLEncoding.EncodeToString(pubP.Bytes()))
+}
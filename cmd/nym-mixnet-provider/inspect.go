@@ -0,0 +1,71 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nymtech/nym-mixnet/constants"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+func cmdInspect(args []string, usage string) {
+	opts := newOpts("inspect [OPTIONS] FILE", usage)
+	keyFile := opts.Flags("--key").Label("FILE").String(
+		"Private key of the node the packet was captured at, used to verify its MAC and decode its next hop",
+		"",
+	)
+	codecName := opts.Flags("--packet-codec").Label("CODEC").String(
+		"Wire format of the packet: protobuf or compact",
+		"protobuf",
+	)
+
+	params := opts.Parse(args)
+	if len(params) != 1 {
+		opts.PrintUsage()
+		os.Exit(1)
+	}
+
+	packetBytes, err := ioutil.ReadFile(params[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read the packet: %v\n", err)
+		os.Exit(1)
+	}
+
+	codec, err := sphinx.ParseCodec(*codecName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid packet codec %q: %v\n", *codecName, err)
+		os.Exit(1)
+	}
+
+	var privKey *sphinx.PrivateKey
+	if len(*keyFile) > 0 {
+		privKey = new(sphinx.PrivateKey)
+		if err := helpers.FromPEMFile(privKey, *keyFile, constants.PrivateKeyPEMType); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load the private key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	info, err := sphinx.InspectPacket(packetBytes, codec, privKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse the packet: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprint(os.Stdout, info)
+}
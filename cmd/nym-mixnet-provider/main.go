@@ -28,10 +28,14 @@ func main() {
 (mixnet-provider)
 `
 	cmds := map[string]func([]string, string){
-		"run": cmdRun,
+		"init":    cmdInit,
+		"run":     cmdRun,
+		"inspect": cmdInspect,
 	}
 	info := map[string]string{
-		"run": "Run a Nym mixnet provider for offline storage",
+		"init":    "Generate the keys and the config template of a new Nym mixnet provider",
+		"run":     "Run a Nym mixnet provider for offline storage",
+		"inspect": "Describe the structure of a captured sphinx packet",
 	}
 	optparse.Commands("nym-provider", "0.4.0", cmds, info, logo)
 }
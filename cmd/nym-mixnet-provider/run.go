@@ -17,39 +17,38 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/nymtech/nym-mixnet/constants"
 	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/node"
 	"github.com/nymtech/nym-mixnet/server/provider"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
 )
 
 const (
-	defaultHost           = ""
-	defaultID             = "Provider"
-	defaultPort           = "1789"
-	defaultPrivateKeyFile = "privateKey.key"
-	defaultPublicKeyFile  = "publicKey.key"
+	defaultHost = ""
+	defaultID   = "Provider"
 )
 
-func loadKeys() (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
+func loadKeys(privateKeyFile, publicKeyFile string) (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
 	prvKey := new(sphinx.PrivateKey)
 	pubKey := new(sphinx.PublicKey)
 
-	if _, err := os.Stat(defaultPrivateKeyFile); os.IsNotExist(err) {
-		return nil, nil, err
+	// a missing key is only ever replaced together with the other one, as otherwise they would not match
+	_, privateErr := os.Stat(privateKeyFile)
+	_, publicErr := os.Stat(publicKeyFile)
+	if os.IsNotExist(privateErr) && os.IsNotExist(publicErr) {
+		return nil, nil, privateErr
 	}
 
-	if _, err := os.Stat(defaultPublicKeyFile); os.IsNotExist(err) {
-		return nil, nil, err
-	}
-
-	if err := helpers.FromPEMFile(prvKey, defaultPrivateKeyFile, constants.PrivateKeyPEMType); err != nil {
+	if err := helpers.FromPEMFile(prvKey, privateKeyFile, constants.PrivateKeyPEMType); err != nil {
 		return nil, nil, fmt.Errorf("Failed to load the private key: %v", err)
 	}
 
-	if err := helpers.FromPEMFile(pubKey, defaultPublicKeyFile, constants.PublicKeyPEMType); err != nil {
+	if err := helpers.FromPEMFile(pubKey, publicKeyFile, constants.PublicKeyPEMType); err != nil {
 		return nil, nil, fmt.Errorf("Failed to load the public key: %v", err)
 	}
 
@@ -57,25 +56,317 @@ func loadKeys() (*sphinx.PrivateKey, *sphinx.PublicKey, error) {
 	return prvKey, pubKey, nil
 }
 
-func saveKeys(privP *sphinx.PrivateKey, pubP *sphinx.PublicKey) {
-	if err := helpers.ToPEMFile(privP, defaultPrivateKeyFile, constants.PrivateKeyPEMType); err != nil {
+func saveKeys(privP *sphinx.PrivateKey, pubP *sphinx.PublicKey, privateKeyFile, publicKeyFile string) {
+	if err := helpers.ToPEMFile(privP, privateKeyFile, constants.PrivateKeyPEMType); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to save private key: %v", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", defaultPrivateKeyFile)
+	fmt.Fprintf(os.Stdout, "Saved generated private key to %v\n", privateKeyFile)
 
-	if err := helpers.ToPEMFile(pubP, defaultPublicKeyFile, constants.PublicKeyPEMType); err != nil {
+	if err := helpers.ToPEMFile(pubP, publicKeyFile, constants.PublicKeyPEMType); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to save public key: %v", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", defaultPublicKeyFile)
+	fmt.Fprintf(os.Stdout, "Saved generated public key to %v\n", publicKeyFile)
+}
+
+// loadTokenMasterKey loads the token master key from the given file,
+// or if the file does not exist, generates a fresh key and saves it there.
+func loadTokenMasterKey(keyFile string) (*provider.TokenMasterKey, error) {
+	masterKey := new(provider.TokenMasterKey)
+	if _, err := os.Stat(keyFile); err == nil {
+		if err := helpers.FromPEMFile(masterKey, keyFile, constants.TokenMasterKeyPEMType); err != nil {
+			return nil, fmt.Errorf("Failed to load the token master key: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "Loaded existing token master key\n")
+		return masterKey, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	masterKey, err := provider.GenerateTokenMasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate the token master key: %v", err)
+	}
+	if err := helpers.ToPEMFile(masterKey, keyFile, constants.TokenMasterKeyPEMType); err != nil {
+		return nil, fmt.Errorf("Failed to save the token master key: %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "Saved generated token master key to %v\n", keyFile)
+	return masterKey, nil
+}
+
+// splitList splits the comma-separated list, ignoring any surrounding whitespace and empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitIntList splits the comma-separated list of integers, ignoring any surrounding whitespace and empty items.
+func splitIntList(list string) ([]int, error) {
+	var ints []int
+	for _, item := range splitList(list) {
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// joinIntList formats the integers as a comma-separated list, as parsed by splitIntList.
+func joinIntList(ints []int) string {
+	items := make([]string, len(ints))
+	for i, item := range ints {
+		items[i] = strconv.Itoa(item)
+	}
+	return strings.Join(items, ",")
 }
 
 func cmdRun(args []string, usage string) {
 	opts := newOpts("run [OPTIONS]", usage)
 	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnet-provider we want to run", defaultID)
-	host := opts.Flags("--host").Label("HOST").String("The host on which the nym-mixnet-provider is running", defaultHost)
-	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnet-provider listens", defaultPort)
+	defaults := provider.DefaultConfig()
+	host := opts.Flags("--host").Label("HOST").String(
+		"The host on which the nym-mixnet-provider is running, as advertised in its presence unless --advertise-address is set "+
+			fmt.Sprintf("(default the detected local IP address, or $%v)", provider.EnvHost),
+		defaultHost,
+	)
+	home := opts.Flags("--home").Label("DIR").String(
+		fmt.Sprintf("Home directory, under which all the state of the provider is kept (default %v, or $%v)",
+			provider.DefaultHomeDir("<ID>"),
+			provider.EnvHome,
+		),
+		"",
+	)
+	port := opts.Flags("--port").Label("PORT").String(
+		fmt.Sprintf("Port on which nym-mixnet-provider listens (default %v, or $%v)", defaults.Port, provider.EnvPort),
+		"",
+	)
+	bindAddress := opts.Flags("--bind-address").Label("ADDRESS").String(
+		fmt.Sprintf("Address (host:port) nym-mixnet-provider listens on, e.g. :%v to listen on all interfaces "+
+			"(default the host and port, or $%v)", defaults.Port, provider.EnvBindAddress),
+		"",
+	)
+	advertiseAddress := opts.Flags("--advertise-address").Label("ADDRESS").String(
+		fmt.Sprintf("Public address (host:port) advertised in place of the host and port, e.g. of the NAT "+
+			"the nym-mixnet-provider is behind (or $%v)", provider.EnvAdvertiseAddress),
+		"",
+	)
+	inboxesDir := opts.Flags("--inboxes").Label("DIR").String(
+		fmt.Sprintf("Directory of the client inboxes, relative to the home directory (default %v, or $%v)",
+			defaults.InboxesDir,
+			provider.EnvInboxRoot,
+		),
+		"",
+	)
+	logLevel := opts.Flags("--log-level").Label("LEVEL").String(
+		fmt.Sprintf("Level of the logs (default %v, or $%v)", defaults.LogLevel, provider.EnvLogLevel),
+		"",
+	)
+	logFile := opts.Flags("--log-file").Label("FILE").String(
+		"File the logs are written to, relative to the home directory. If omitted, the logs are written to stdout",
+		"",
+	)
+	adminAddress := opts.Flags("--admin-address").Label("ADDRESS").String(
+		fmt.Sprintf("Address of the admin API, which is disabled unless set (or $%v). "+
+			"Its token has to be set in $%v. An empty host, as in :8080, stands for %v",
+			provider.EnvAdminAddress,
+			provider.EnvAdminToken,
+			provider.DefaultAdminHost,
+		),
+		"",
+	)
+	adminExpose := opts.Flags("--admin-expose").Bool(
+		fmt.Sprintf("Allow the admin API to listen beyond the loopback interface, "+
+			"with an empty host standing for all the interfaces (or $%v)", provider.EnvAdminExpose),
+	)
+	requireDirectory := opts.Flags("--require-directory").Bool(
+		fmt.Sprintf("Fail to start if the presence can't be registered at the directory server, "+
+			"instead of retrying it while running (or $%v)", provider.EnvRequireDirectory),
+	)
+	directoryURL := opts.Flags("--directory").Label("URL").String(
+		fmt.Sprintf("URL of the directory server (or $%v). "+
+			"By default the public one is used, or the local one if running on a loopback address", provider.EnvDirectoryURL),
+		"",
+	)
+	deliveryWebhook := opts.Flags("--delivery-webhook").Label("URL").String(
+		fmt.Sprintf("URL the delivered messages are posted to instead of being stored in the inboxes (or $%v). "+
+			"The messages the webhook fails to accept are still stored", provider.EnvDeliveryWebhook),
+		"",
+	)
+	auditLog := opts.Flags("--audit-log").Label("FILE").String(
+		fmt.Sprintf("File, relative to the home directory, each stored message is recorded in, without its content, "+
+			"or %q to send the records to syslog. Disabled unless set (or $%v)", provider.AuditLogSyslog, provider.EnvAuditLog),
+		"",
+	)
+	storageBackend := opts.Flags("--storage").Label("BACKEND").String(
+		fmt.Sprintf("Storage backend of the client inboxes (default %v)", defaults.StorageBackend),
+		"",
+	)
+	inboxSharding := opts.Flags("--inbox-sharding").Label("N").Int(
+		fmt.Sprintf("Shard the inboxes into subdirectories by the first N characters of the message ids, at most %v. "+
+			"Disabled if 0",
+			provider.MaxInboxShardPrefixLength,
+		),
+		0,
+	)
+	replayTagLength := opts.Flags("--replay-tag-length").Label("BYTES").Int(
+		fmt.Sprintf("Length of the tags the processed packets are remembered by to detect replays (default %v, min %v)",
+			defaults.ReplayTagLength,
+			sphinx.MinReplayTagLength,
+		),
+		0,
+	)
+	replayCacheCapacity := opts.Flags("--replay-cache-capacity").Label("TAGS").Int(
+		"Maximum number of the tags of the processed packets remembered at once, beyond which the oldest ones are forgotten",
+		node.DefaultReplayCacheCapacity,
+	)
+	tokenLength := opts.Flags("--token-length").Label("BYTES").Int(
+		fmt.Sprintf("Length of the random authentication tokens issued to the clients (min %v, max %v). "+
+			"Ignored if the tokens are stateless",
+			provider.MinTokenLength,
+			provider.MaxTokenLength,
+		),
+		provider.DefaultTokenLength,
+	)
+	clockSkew := opts.Flags("--clock-skew").Label("DURATION").Duration(
+		"For how long after their expiry the packets are still accepted",
+		node.DefaultClockSkewTolerance,
+	)
+	maxRate := opts.Flags("--max-rate").Label("RATE").Float(
+		"Maximum number of packets processed per second, any excess packets are dropped. Unlimited if 0",
+		0,
+	)
+	burst := opts.Flags("--burst").Label("N").Int("Number of packets which can be processed at once above --max-rate", 1)
+	dialTimeout := opts.Flags("--dial-timeout").Label("DURATION").Duration(
+		"For how long connecting to the next hop is attempted before the packet is dropped. Unlimited if 0",
+		node.DefaultDialTimeout,
+	)
+	writeTimeout := opts.Flags("--write-timeout").Label("DURATION").Duration(
+		"For how long writing a packet to the next hop may take before it is dropped. Unlimited if 0",
+		node.DefaultWriteTimeout,
+	)
+	maxPendingForwards := opts.Flags("--max-pending-forwards").Label("N").Int(
+		"Maximum number of packets held for their delays at once, any excess packets are dropped",
+		node.DefaultMaxPendingForwards,
+	)
+	maxPendingPerSource := opts.Flags("--max-pending-forwards-per-source").Label("N").Int(
+		"Maximum number of packets received from a single host held for their delays at once, "+
+			"any excess packets of the host are dropped. Only --max-pending-forwards applies if 0",
+		0,
+	)
+	createInboxes := opts.Flags("--create-inboxes").Bool(
+		"Create the inboxes of unregistered recipients on demand rather than dropping their messages",
+	)
+	requireRegistration := opts.Flags("--require-registration").Bool(
+		"Only store the messages for the clients registered with the provider, even if their inbox exists",
+	)
+	consultRegistry := opts.Flags("--consult-registry").Bool(
+		"Recreate the missing inboxes of the registered clients and tell the unregistered ones to register, rather than " +
+			"answering their pulls with the no-inbox status. Only for networks whose clients all understand the new status",
+	)
+	messageOrder := opts.Flags("--message-order").Label("ORDER").String(
+		"Order the pulled messages are sent to the clients in: oldest, newest or storage",
+		"oldest",
+	)
+	unknownFlags := opts.Flags("--unknown-flags").Label("POLICY").String(
+		"How to react to packets with unrecognised flags: log, count, disconnect or ban",
+		"log",
+	)
+	unknownFlagBanThreshold := opts.Flags("--unknown-flag-ban-threshold").Label("N").Int(
+		"Number of packets with unrecognised flags a peer is banned after, with --unknown-flags ban",
+		provider.DefaultUnknownFlagBanThreshold,
+	)
+	unknownFlagBanDuration := opts.Flags("--unknown-flag-ban-duration").Label("DURATION").Duration(
+		"For how long the peers are banned, with --unknown-flags ban",
+		provider.DefaultUnknownFlagBanDuration,
+	)
+	pullPadding := opts.Flags("--pad-pulls").Label("N").Int(
+		"Pad the number of messages in each pull response to a multiple of N with dummy messages. "+
+			"The dummies are not hidden from the observers able to read the responses. Disabled if 0",
+		0,
+	)
+	maxPullMessages := opts.Flags("--max-pull-messages").Label("N").Int(
+		"Maximum number of messages returned in a single pull, the rest is left for the subsequent pulls. Unlimited if 0",
+		provider.DefaultMaxPullMessages,
+	)
+	maxPullBytes := opts.Flags("--max-pull-bytes").Label("BYTES").Int(
+		"Maximum size of a single pull response, the rest is left for the subsequent pulls. Unlimited if 0",
+		provider.DefaultMaxPullBytes,
+	)
+	messageSizeBuckets := opts.Flags("--message-size-buckets").Label("SIZES").String(
+		fmt.Sprintf("Comma-separated upper bounds (in bytes) of the buckets the sizes of the stored messages "+
+			"are counted in, as reported by the metrics (default %v)", joinIntList(provider.DefaultMessageSizeBuckets)),
+		"",
+	)
+	maxConcurrentPulls := opts.Flags("--max-concurrent-pulls").Label("N").Int(
+		"Maximum number of pulls each client may have in progress at once. Unlimited if 0",
+		provider.DefaultMaxConcurrentPulls,
+	)
+	cleanupInterval := opts.Flags("--cleanup-interval").Label("DURATION").Duration(
+		"How often the stale inboxes of the unregistered clients are looked for",
+		provider.DefaultInboxCleanupInterval,
+	)
+	sweepConcurrency := opts.Flags("--sweep-concurrency").Label("N").Int(
+		"Number of inboxes swept at once when looking for the stale inboxes",
+		provider.DefaultSweepConcurrency,
+	)
+	packetCodec := opts.Flags("--packet-codec").Label("CODEC").String(
+		"Wire format of the sphinx packets, which has to match the rest of the network: protobuf or compact",
+		"protobuf",
+	)
+	maxClients := opts.Flags("--max-clients").Label("N").Int(
+		"Maximum number of clients that may be registered with the provider. Unlimited if 0",
+		0,
+	)
+	reachability := opts.Flags("--client-reachability").Label("POLICY").String(
+		"Whether the addresses advertised by the registering clients are checked: off, "+
+			"lenient (the unreachable addresses are not stored) or strict (such clients are rejected)",
+		"off",
+	)
+	reachabilityTimeout := opts.Flags("--client-reachability-timeout").Label("DURATION").Duration(
+		"Timeout of connecting to the address advertised by a registering client",
+		provider.DefaultReachabilityTimeout,
+	)
+	listenAttempts := opts.Flags("--listen-attempts").Label("N").Int(
+		"Number of times binding to the port is attempted on start if it is in use",
+		provider.DefaultListenAttempts,
+	)
+	listenBackoff := opts.Flags("--listen-backoff").Label("DURATION").Duration(
+		"Initial wait between the attempts to bind to the port, doubled after each failed attempt",
+		provider.DefaultListenBackoff,
+	)
+	presenceWarmUp := opts.Flags("--presence-warm-up").Label("DURATION").Duration(
+		"How long to wait, once the provider accepts connections, before registering its presence",
+		0,
+	)
+	connRate := opts.Flags("--conn-rate").Label("RATE").Float(
+		"Maximum number of connections each source host may open per second, any excess ones are refused. Unlimited if 0",
+		0,
+	)
+	connBurst := opts.Flags("--conn-burst").Label("N").Int(
+		"Number of connections each source host may open at once above --conn-rate",
+		1,
+	)
+	maxConnsPerSource := opts.Flags("--max-conns-per-source").Label("N").Int(
+		"Maximum number of connections each source host may have open at once. Unlimited if 0",
+		0,
+	)
+	connLimitAllowlist := opts.Flags("--conn-limit-allow").Label("HOSTS").String(
+		"Comma-separated list of the source hosts exempt from --conn-rate and --max-conns-per-source",
+		"",
+	)
+	tokenKeyFile := opts.Flags("--token-key").Label("FILE").String(
+		"File with the master key for issuing stateless tokens, relative to the home directory. "+
+			"If omitted, tokens are stored per client instead",
+		"",
+	)
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
@@ -83,38 +374,219 @@ func cmdRun(args []string, usage string) {
 		os.Exit(1)
 	}
 
-	ip, err := helpers.GetLocalIP()
+	// explicitly given flags take precedence over the environment, which in turn overrides the defaults
+	cfg := defaults.
+		Overlay(provider.Config{HomeDir: provider.DefaultHomeDir(*id)}).
+		Overlay(provider.ConfigFromEnv(os.LookupEnv)).
+		Overlay(provider.Config{HomeDir: *home,
+			Host:                   *host,
+			Port:                   *port,
+			InboxesDir:             *inboxesDir,
+			LogLevel:               *logLevel,
+			StorageBackend:         *storageBackend,
+			AdminAddress:           *adminAddress,
+			AdminExpose:            *adminExpose,
+			ReplayTagLength:        *replayTagLength,
+			DirectoryURL:           *directoryURL,
+			RequireDirectory:       *requireDirectory,
+			LogFile:                *logFile,
+			BindAddress:            *bindAddress,
+			AdvertiseAddress:       *advertiseAddress,
+			DeliveryWebhook:        *deliveryWebhook,
+			InboxShardPrefixLength: *inboxSharding,
+			AuditLog:               *auditLog,
+		})
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	// the detected local address is only used if no host was given explicitly
+	nodeHost, err := helpers.ResolveHost(cfg.Host, helpers.GetLocalIP)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "failed to detect the local IP address, set it with --host: %v\n", err)
+		os.Exit(1)
 	}
-
-	if host == nil || len(*host) < 7 {
-		host = &ip
+	if err := os.MkdirAll(cfg.HomeDir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create home directory: %v\n", err)
+		os.Exit(1)
 	}
 
-	privP, pubP, err := loadKeys()
+	// the keys used to be kept in the working directory
+	movedKeys, err := provider.MigrateLegacyKeys(cfg, ".")
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate the keys to %v: %v\n", cfg.HomeDir, err)
+		os.Exit(1)
+	}
+	if movedKeys {
+		fmt.Fprintf(os.Stdout, "Moved the keys from the working directory to %v\n", cfg.HomeDir)
+	}
+
+	privP, pubP, err := loadKeys(cfg.PrivateKeyPath(), cfg.PublicKeyPath())
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	} else if err != nil {
 		privP, pubP, err = sphinx.GenerateKeyPair()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to generate new keypair: %v", err)
 			os.Exit(1)
 		}
 
-		saveKeys(privP, pubP)
+		saveKeys(privP, pubP, cfg.PrivateKeyPath(), cfg.PublicKeyPath())
 	}
 
-	providerServer, err := provider.NewProviderServer(*id, *host, *port, privP, pubP)
+	advertisedHost, advertisedPort := cfg.AdvertisedHostPort(nodeHost)
+	providerServer, err := provider.NewProviderServer(*id, advertisedHost, advertisedPort, privP, pubP, cfg.DirectoryURL)
 	if err != nil {
 		panic(err)
 	}
 
-	err = providerServer.Start()
+	providerServer.SetInboxesDirectory(cfg.InboxesPath())
+	// the inboxes used to be named by the base64 encoded public keys of their clients
+	migrated, err := providerServer.MigrateLegacyInboxes()
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate the inboxes: %v\n", err)
+		os.Exit(1)
+	}
+	if migrated > 0 {
+		fmt.Fprintf(os.Stdout, "Migrated %v inboxes to the new naming\n", migrated)
+	}
+	if err := providerServer.SetInboxSharding(cfg.InboxShardPrefixLength); err != nil {
 		panic(err)
 	}
+	if err := providerServer.SetLogLevel(cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log level %q: %v\n", cfg.LogLevel, err)
+		os.Exit(1)
+	}
+	if cfg.LogFile != "" {
+		if err := providerServer.SetLogFile(cfg.LogFilePath()); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := providerServer.SetReplayTagLength(cfg.ReplayTagLength); err != nil {
+		panic(err)
+	}
+	providerServer.SetReplayCacheCapacity(*replayCacheCapacity)
+	if err := providerServer.SetTokenLength(*tokenLength); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid token length %v: %v\n", *tokenLength, err)
+		os.Exit(1)
+	}
+	providerServer.SetClockSkewTolerance(*clockSkew)
+	providerServer.SetMaxProcessingRate(*maxRate, *burst)
+	providerServer.SetMaxPendingForwards(*maxPendingForwards)
+	providerServer.SetMaxPendingForwardsPerSource(*maxPendingPerSource)
+	providerServer.SetForwardTimeouts(*dialTimeout, *writeTimeout)
+	providerServer.SetPullPadding(*pullPadding)
+	providerServer.SetPullLimits(*maxPullMessages, *maxPullBytes)
+	providerServer.SetMaxConcurrentPulls(*maxConcurrentPulls)
+	if *messageSizeBuckets != "" {
+		buckets, err := splitIntList(*messageSizeBuckets)
+		if err == nil {
+			err = providerServer.SetMessageSizeBuckets(buckets)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid message size buckets %q: %v\n", *messageSizeBuckets, err)
+			os.Exit(1)
+		}
+	}
+	providerServer.SetMaxRegisteredClients(*maxClients)
+	reachabilityPolicy, err := provider.ParseReachabilityPolicy(*reachability)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid client reachability policy %q: %v\n", *reachability, err)
+		os.Exit(1)
+	}
+	providerServer.SetReachabilityCheck(reachabilityPolicy, *reachabilityTimeout)
+	if err := providerServer.SetInboxCleanup(*cleanupInterval, *sweepConcurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid inbox cleanup: %v\n", err)
+		os.Exit(1)
+	}
+	providerServer.SetListenRetry(*listenAttempts, *listenBackoff)
+	providerServer.SetPresenceWarmUp(*presenceWarmUp)
+	providerServer.SetRequireInitialPresence(cfg.RequireDirectory)
+	if err := providerServer.SetBindAddress(cfg.ListenAddress(nodeHost)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid bind address %q: %v\n", cfg.ListenAddress(nodeHost), err)
+		os.Exit(1)
+	}
+	if *createInboxes && *requireRegistration {
+		fmt.Fprintf(os.Stderr, "--create-inboxes and --require-registration are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if *createInboxes {
+		providerServer.SetUnknownRecipientPolicy(provider.CreateInboxOnDemand)
+	}
+	if *requireRegistration {
+		providerServer.SetUnknownRecipientPolicy(provider.RequireRegistration)
+	}
+	if *consultRegistry {
+		providerServer.SetMissingInboxPolicy(provider.ConsultRegistry)
+	}
+	order, err := provider.ParseMessageOrder(*messageOrder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid message order %q: %v\n", *messageOrder, err)
+		os.Exit(1)
+	}
+	providerServer.SetMessageOrder(order)
+	codec, err := sphinx.ParseCodec(*packetCodec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid packet codec %q: %v\n", *packetCodec, err)
+		os.Exit(1)
+	}
+	providerServer.SetPacketCodec(codec)
+	unknownFlagPolicy, err := provider.ParseUnknownFlagPolicy(*unknownFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid policy %q for unrecognised flags: %v\n", *unknownFlags, err)
+		os.Exit(1)
+	}
+	providerServer.SetUnknownFlagPolicy(unknownFlagPolicy)
+	providerServer.SetUnknownFlagBan(*unknownFlagBanThreshold, *unknownFlagBanDuration)
+	providerServer.SetConnectionLimits(*connRate, *connBurst, *maxConnsPerSource, splitList(*connLimitAllowlist))
+
+	if cfg.DeliveryWebhook != "" {
+		sink, err := provider.NewWebhookSink(cfg.DeliveryWebhook, provider.DefaultWebhookTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid delivery webhook %q: %v\n", cfg.DeliveryWebhook, err)
+			os.Exit(1)
+		}
+		providerServer.SetDeliverySink(sink)
+	}
+	if target := cfg.AuditLogTarget(); target != "" {
+		auditLog, err := provider.OpenAuditLog(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the audit log %q: %v\n", target, err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		providerServer.SetAuditLog(auditLog)
+	}
 
-	wait := make(chan struct{})
-	<-wait
+	if len(*tokenKeyFile) > 0 {
+		masterKey, err := loadTokenMasterKey(cfg.ResolvePath(*tokenKeyFile))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		providerServer.EnableStatelessTokens(masterKey, provider.DefaultTokenValidity)
+	}
+
+	if cfg.AdminAddress != "" {
+		if err := providerServer.StartAdminServer(cfg.AdminListenAddress(), cfg.AdminToken); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start the admin API: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Start returns once the provider is shut down, so the process exits cleanly
+	helpers.HandleShutdownSignals(providerServer.Shutdown, func() {
+		fmt.Fprintf(os.Stderr, "forced exit before the shutdown completed\n")
+		os.Exit(1)
+	})
+
+	if err := providerServer.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to run the provider: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func newOpts(command string, usage string) *optparse.Parser {
@@ -15,9 +15,11 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/node"
 	"github.com/nymtech/nym-mixnet/server/mixnode"
 	"github.com/nymtech/nym-mixnet/sphinx"
 	"github.com/tav/golly/optparse"
@@ -35,23 +37,79 @@ const (
 func cmdRun(args []string, usage string) {
 	opts := newOpts("run [OPTIONS]", usage)
 	id := opts.Flags("--id").Label("ID").String("Id of the nym-mixnode we want to run", defaultID)
-	host := opts.Flags("--host").Label("HOST").String("The host on which the nym-mixnode is running", defaultHost)
+	host := opts.Flags("--host").Label("HOST").String(
+		"The host on which the nym-mixnode is running (default the detected local IP address)",
+		defaultHost,
+	)
 	port := opts.Flags("--port").Label("PORT").String("Port on which nym-mixnode listens", defaultPort)
 	layer := opts.Flags("--layer").Label("Layer").Int("Mixnet layer of this particular node", defaultLayer)
+	clockSkew := opts.Flags("--clock-skew").Label("DURATION").Duration(
+		"For how long after their expiry the packets are still forwarded",
+		node.DefaultClockSkewTolerance,
+	)
+	maxRate := opts.Flags("--max-rate").Label("RATE").Float(
+		"Maximum number of packets processed per second, any excess packets are dropped. Unlimited if 0",
+		0,
+	)
+	burst := opts.Flags("--burst").Label("N").Int("Number of packets which can be processed at once above --max-rate", 1)
+	maxPendingForwards := opts.Flags("--max-pending-forwards").Label("N").Int(
+		"Maximum number of packets held for their delays at once, any excess packets are dropped",
+		node.DefaultMaxPendingForwards,
+	)
+	maxPendingPerSource := opts.Flags("--max-pending-forwards-per-source").Label("N").Int(
+		"Maximum number of packets received from a single host held for their delays at once, "+
+			"any excess packets of the host are dropped. Only --max-pending-forwards applies if 0",
+		0,
+	)
+	dialTimeout := opts.Flags("--dial-timeout").Label("DURATION").Duration(
+		"For how long connecting to the next hop is attempted before the packet is dropped. Unlimited if 0",
+		node.DefaultDialTimeout,
+	)
+	writeTimeout := opts.Flags("--write-timeout").Label("DURATION").Duration(
+		"For how long writing a packet to the next hop may take before it is dropped. Unlimited if 0",
+		node.DefaultWriteTimeout,
+	)
+	packetCodec := opts.Flags("--packet-codec").Label("CODEC").String(
+		"Wire format of the sphinx packets, which has to match the rest of the network: protobuf or compact",
+		"protobuf",
+	)
+	replayTagLength := opts.Flags("--replay-tag-length").Label("BYTES").Int(
+		"Length of the tags the processed packets are remembered by to detect replays",
+		sphinx.DefaultReplayTagLength,
+	)
+	replayCacheCapacity := opts.Flags("--replay-cache-capacity").Label("TAGS").Int(
+		"Maximum number of the tags of the processed packets remembered at once, beyond which the oldest ones are forgotten",
+		node.DefaultReplayCacheCapacity,
+	)
+	disableReplayProtection := opts.Flags("--unsafe-disable-replay-protection").Bool(
+		"Process the replayed packets rather than dropping them. UNSAFE, as it lets the packets be traced through " +
+			"the mix; only meant for the benchmarks resending a pregenerated packet",
+	)
 
 	params := opts.Parse(args)
 	if len(params) != 0 {
 		opts.PrintUsage()
 		os.Exit(1)
 	}
-
-	ip, err := helpers.GetLocalIP()
+	if err := sphinx.ValidateReplayTagLength(*replayTagLength); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid replay tag length %v: must be between %v and %v bytes\n",
+			*replayTagLength,
+			sphinx.MinReplayTagLength,
+			sphinx.MaxReplayTagLength,
+		)
+		os.Exit(1)
+	}
+	codec, err := sphinx.ParseCodec(*packetCodec)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "invalid packet codec %q: %v\n", *packetCodec, err)
+		os.Exit(1)
 	}
 
-	if host == nil || len(*host) < 7 {
-		host = &ip
+	// the detected local address is only used if no host was given explicitly
+	nodeHost, err := helpers.ResolveHost(*host, helpers.GetLocalIP)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to detect the local IP address, set it with --host: %v\n", err)
+		os.Exit(1)
 	}
 
 	pubM, privM, err := sphinx.GenerateKeyPair()
@@ -59,11 +117,26 @@ func cmdRun(args []string, usage string) {
 		panic(err)
 	}
 
-	mixServer, err := mixnode.NewMixServer(*id, *host, *port, pubM, privM, *layer)
+	mixServer, err := mixnode.NewMixServer(*id, nodeHost, *port, pubM, privM, *layer)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := mixServer.SetReplayTagLength(*replayTagLength); err != nil {
+		panic(err)
+	}
+	mixServer.SetReplayCacheCapacity(*replayCacheCapacity)
+	if *disableReplayProtection {
+		fmt.Fprintf(os.Stderr, "WARNING: the replay protection is disabled, never run the mix like this in production\n")
+		mixServer.DisableReplayProtection()
+	}
+	mixServer.SetClockSkewTolerance(*clockSkew)
+	mixServer.SetMaxProcessingRate(*maxRate, *burst)
+	mixServer.SetMaxPendingForwards(*maxPendingForwards)
+	mixServer.SetMaxPendingForwardsPerSource(*maxPendingPerSource)
+	mixServer.SetForwardTimeouts(*dialTimeout, *writeTimeout)
+	mixServer.SetPacketCodec(codec)
+
 	if err := mixServer.Start(); err != nil {
 		panic(err)
 	}
@@ -20,6 +20,9 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/flags"
 )
@@ -37,11 +40,38 @@ const (
 	LocalDirectoryServerMixProviderPresenceURL = "http://localhost:8080/api/presence/mixproviders"
 	LocalDirectoryServerTopology               = "http://localhost:8080/api/presence/topology"
 
+	// MixProviderPresencePath is the path of the provider presence endpoint relative to the directory server URL.
+	MixProviderPresencePath = "/api/presence/mixproviders"
+
 	// TODO: somehow split mixConfig to distinguish providers and mixnodes?
 	// But then we would have to deal with nasty interfaces and protobuf issues...
 	ProviderLayer = 1000000
 
 	DefaultRemotePort = "1789"
+
+	// PublicKeySize is the length (in bytes) of the public keys of the nodes. It has to match sphinx.PublicKeySize,
+	// which can't be referred to directly, as the sphinx package depends on this one.
+	PublicKeySize = 32
+
+	// PacketMagic is the byte sequence prepended to every marshalled GeneralPacket sent over the wire.
+	PacketMagic = "NYM"
+	// PacketVersion is the current version of the GeneralPacket wire format.
+	PacketVersion byte = 1
+	// packetHeaderLength is the length of the envelope preceding the marshalled GeneralPacket.
+	packetHeaderLength = len(PacketMagic) + 1
+)
+
+var (
+	// ErrInvalidPacketMagic is returned when the received bytes do not start with the PacketMagic sequence,
+	// i.e. they are either garbage or were sent by a peer predating the versioned wire format.
+	ErrInvalidPacketMagic = errors.New("invalid packet magic")
+	// ErrUnsupportedPacketVersion is returned when the received packet uses a different wire format version.
+	ErrUnsupportedPacketVersion = errors.New("unsupported packet version")
+	// ErrMalformedPacket is returned when the received packet has a valid envelope,
+	// but its content is either truncated or is not a valid GeneralPacket.
+	ErrMalformedPacket = errors.New("malformed packet")
+	// ErrInvalidPublicKey is returned when the public key of a node does not have PublicKeySize bytes.
+	ErrInvalidPublicKey = errors.New("invalid public key length")
 )
 
 // NewMixConfig constructor
@@ -49,6 +79,16 @@ func NewMixConfig(mixID, host, port string, pubKey []byte, layer uint) MixConfig
 	return MixConfig{Id: mixID, Host: host, Port: port, PubKey: pubKey, Layer: uint64(layer)}
 }
 
+// NewValidatedMixConfig works like NewMixConfig, but returns ErrInvalidPublicKey if the public key
+// does not have PublicKeySize bytes, so that malformed PKI data is rejected as soon as it is loaded
+// rather than once a packet is being built over the node.
+func NewValidatedMixConfig(mixID, host, port string, pubKey []byte, layer uint) (MixConfig, error) {
+	if len(pubKey) != PublicKeySize {
+		return MixConfig{}, ErrInvalidPublicKey
+	}
+	return NewMixConfig(mixID, host, port, pubKey, layer), nil
+}
+
 // NewClientConfig constructor
 func NewClientConfig(clientID, host, port string, pubKey []byte, providerInfo MixConfig) ClientConfig {
 	client := ClientConfig{Id: clientID, Host: host, Port: port, PubKey: pubKey, Provider: &providerInfo}
@@ -56,14 +96,38 @@ func NewClientConfig(clientID, host, port string, pubKey []byte, providerInfo Mi
 }
 
 // WrapWithFlag packs the given byte information together with a specified flag into the
-// packet.
+// packet. The marshalled packet is preceded by the PacketMagic and PacketVersion.
 func WrapWithFlag(flag flags.PacketTypeFlag, data []byte) ([]byte, error) {
 	m := GeneralPacket{Flag: flag.Bytes(), Data: data}
 	mBytes, err := proto.Marshal(&m)
 	if err != nil {
 		return nil, err
 	}
-	return mBytes, nil
+	packetBytes := make([]byte, 0, packetHeaderLength+len(mBytes))
+	packetBytes = append(packetBytes, PacketMagic...)
+	packetBytes = append(packetBytes, PacketVersion)
+	return append(packetBytes, mBytes...), nil
+}
+
+// UnwrapPacket checks the envelope of the received bytes and unmarshals the GeneralPacket it contains.
+// It returns an error if the magic sequence is missing, if the packet uses a different wire format version
+// or if the GeneralPacket could not be unmarshalled or does not contain a single byte flag.
+// Note that the flag itself is not validated, so that unknown flags could be told apart from malformed input.
+func UnwrapPacket(b []byte) (GeneralPacket, error) {
+	if len(b) < packetHeaderLength || !bytes.Equal(b[:len(PacketMagic)], []byte(PacketMagic)) {
+		return GeneralPacket{}, ErrInvalidPacketMagic
+	}
+	if b[len(PacketMagic)] != PacketVersion {
+		return GeneralPacket{}, ErrUnsupportedPacketVersion
+	}
+	var packet GeneralPacket
+	if err := proto.Unmarshal(b[packetHeaderLength:], &packet); err != nil {
+		return GeneralPacket{}, ErrMalformedPacket
+	}
+	if len(packet.Flag) != 1 {
+		return GeneralPacket{}, ErrMalformedPacket
+	}
+	return packet, nil
 }
 
 // E2EPath holds end to end path data for an entire route, prior to Sphinx header encryption
@@ -78,13 +142,3 @@ type E2EPath struct {
 func (p *E2EPath) Len() int {
 	return 3 + len(p.Mixes)
 }
-
-func UnmarshalProviderResponse(resp ProviderResponse) ([]GeneralPacket, error) {
-	packets := make([]GeneralPacket, resp.NumberOfPackets)
-	for i, packet := range resp.Packets {
-		if err := proto.Unmarshal(packet, &packets[i]); err != nil {
-			return nil, err
-		}
-	}
-	return packets, nil
-}
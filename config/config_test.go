@@ -0,0 +1,325 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnwrapPacket_MatchingVersion(t *testing.T) {
+	data := []byte("foomp")
+	packetBytes, err := WrapWithFlag(flags.CommFlag, data)
+	assert.Nil(t, err)
+
+	packet, err := UnwrapPacket(packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.CommFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+	assert.Equal(t, data, packet.Data)
+}
+
+func TestUnwrapPacket_MismatchedVersion(t *testing.T) {
+	packetBytes, err := WrapWithFlag(flags.CommFlag, []byte("foomp"))
+	assert.Nil(t, err)
+
+	packetBytes[len(PacketMagic)] = PacketVersion + 1
+	_, err = UnwrapPacket(packetBytes)
+	assert.Equal(t, ErrUnsupportedPacketVersion, err)
+}
+
+func TestUnwrapPacket_NoEnvelope(t *testing.T) {
+	// this is what a peer predating the versioned wire format would have sent
+	packetBytes, err := proto.Marshal(&GeneralPacket{Flag: flags.CommFlag.Bytes(), Data: []byte("foomp")})
+	assert.Nil(t, err)
+
+	_, err = UnwrapPacket(packetBytes)
+	assert.Equal(t, ErrInvalidPacketMagic, err)
+}
+
+func TestUnwrapPacket_TooShort(t *testing.T) {
+	_, err := UnwrapPacket([]byte(PacketMagic))
+	assert.Equal(t, ErrInvalidPacketMagic, err)
+
+	_, err = UnwrapPacket(nil)
+	assert.Equal(t, ErrInvalidPacketMagic, err)
+}
+
+func TestFrame_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	frames := [][]byte{[]byte("foo"), {}, bytes.Repeat([]byte{42}, MaxFrameSize)}
+	for _, frame := range frames {
+		written := buf.Len()
+		assert.Nil(t, WriteFrame(&buf, frame))
+		assert.Equal(t, FrameSize(frame), buf.Len()-written)
+	}
+
+	for _, frame := range frames {
+		readFrame, err := ReadFrame(&buf)
+		assert.Nil(t, err)
+		assert.Equal(t, frame, readFrame)
+	}
+
+	_, err := ReadFrame(&buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrame_TooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Equal(t, ErrFrameTooLarge, WriteFrame(&buf, make([]byte, MaxFrameSize+1)))
+
+	_, err := ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.Equal(t, ErrFrameTooLarge, err)
+}
+
+func TestFrame_Truncated(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, WriteFrame(&buf, []byte("foomp")))
+
+	_, err := ReadFrame(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = ReadFrame(bytes.NewReader(buf.Bytes()[:2]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestFrameReader(t *testing.T) {
+	var buf bytes.Buffer
+	frames := [][]byte{[]byte("foo"), {}, bytes.Repeat([]byte{42}, MaxFrameSize)}
+	for _, frame := range frames {
+		assert.Nil(t, WriteFrame(&buf, frame))
+	}
+	complete := buf.Len()
+	assert.Nil(t, WriteFrame(&buf, []byte("foomp")))
+
+	// the whole stream ends cleanly
+	r := NewFrameReader(bytes.NewReader(buf.Bytes()))
+	for _, frame := range append(frames, []byte("foomp")) {
+		readFrame, err := r.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, frame, readFrame)
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// while the truncated final frame is reported after all the complete ones
+	for _, truncated := range []int{complete + 2, buf.Len() - 1} {
+		r := NewFrameReader(bytes.NewReader(buf.Bytes()[:truncated]))
+		for _, frame := range frames {
+			readFrame, err := r.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, frame, readFrame)
+		}
+		for i := 0; i < 2; i++ {
+			_, err := r.Next()
+			assert.Equal(t, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestFrameReader_TooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, WriteFrame(&buf, []byte("foo")))
+	buf.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	assert.Nil(t, WriteFrame(&buf, []byte("bar")))
+
+	r := NewFrameReader(&buf)
+	frame, err := r.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("foo"), frame)
+	// the frames following the oversized one can't be found
+	for i := 0; i < 2; i++ {
+		_, err = r.Next()
+		assert.Equal(t, ErrFrameTooLarge, err)
+	}
+}
+
+func TestIsFrameStream(t *testing.T) {
+	packetBytes, err := WrapWithFlag(flags.CommFlag, []byte("foomp"))
+	assert.Nil(t, err)
+
+	r := bufio.NewReader(bytes.NewReader(packetBytes))
+	isStream, err := IsFrameStream(r)
+	assert.Nil(t, err)
+	assert.False(t, isStream)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteFrame(&buf, packetBytes))
+	assert.Nil(t, WriteFrame(&buf, packetBytes))
+	r = bufio.NewReader(&buf)
+	isStream, err = IsFrameStream(r)
+	assert.Nil(t, err)
+	assert.True(t, isStream)
+
+	// nothing was consumed
+	for i := 0; i < 2; i++ {
+		frame, err := ReadFrame(r)
+		assert.Nil(t, err)
+		assert.Equal(t, packetBytes, frame)
+	}
+
+	_, err = IsFrameStream(bufio.NewReader(bytes.NewReader(nil)))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestUnwrapPacket_Malformed(t *testing.T) {
+	packetBytes, err := WrapWithFlag(flags.CommFlag, []byte("foomp"))
+	assert.Nil(t, err)
+
+	// cut in the middle of the data field
+	_, err = UnwrapPacket(packetBytes[:len(packetBytes)-2])
+	assert.Equal(t, ErrMalformedPacket, err)
+
+	garbage := append([]byte(PacketMagic), PacketVersion, 0xff, 0xff, 0xff, 0xff)
+	_, err = UnwrapPacket(garbage)
+	assert.Equal(t, ErrMalformedPacket, err)
+
+	// well-formed envelope with nothing inside it
+	_, err = UnwrapPacket(append([]byte(PacketMagic), PacketVersion))
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestUnwrapPacket_UnknownFlag(t *testing.T) {
+	packetBytes, err := WrapWithFlag(flags.PacketTypeFlag(0x42), []byte("foomp"))
+	assert.Nil(t, err)
+
+	// unknown flags are not the concern of UnwrapPacket
+	packet, err := UnwrapPacket(packetBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.InvalidPacketTypeFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+}
+
+func TestWrapError_RoundTrip(t *testing.T) {
+	packetBytes, err := WrapError(ErrorCodeRateLimited, "too many concurrent pulls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := UnwrapPacket(packetBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, flags.ErrorFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+
+	providerErr, err := UnwrapError(packet.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &ProviderError{Code: ErrorCodeRateLimited, Message: "too many concurrent pulls"}, providerErr)
+	assert.True(t, IsProviderError(providerErr, ErrorCodeRateLimited))
+	assert.False(t, IsProviderError(providerErr, ErrorCodeAuthenticationFailed))
+	assert.False(t, IsProviderError(ErrMalformedPacket, ErrorCodeRateLimited))
+
+	_, err = UnwrapError([]byte{0xff, 0xff})
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestWrapInboxStatus_RoundTrip(t *testing.T) {
+	for _, status := range []InboxStatus{InboxStatusDelivered, InboxStatusEmpty, InboxStatusNoInbox, InboxStatusNotRegistered} {
+		packetBytes, err := WrapInboxStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := UnwrapPacket(packetBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, flags.InboxStatusFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+
+		unwrapped, err := UnwrapInboxStatus(packet.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, status, unwrapped)
+	}
+
+	_, err := UnwrapInboxStatus([]byte{0xff, 0xff})
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestWrapClientStatus_RoundTrip(t *testing.T) {
+	for _, status := range []ClientStatus{
+		{Registration: RegistrationStatusRegistered, TokenIssuedAt: time.Unix(1562581200, 0), BufferedMessages: 42},
+		{Registration: RegistrationStatusRegistered, TokenIssuedAt: time.Unix(1562581200, 0)},
+		{Registration: RegistrationStatusNotRegistered},
+	} {
+		packetBytes, err := WrapClientStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := UnwrapPacket(packetBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, flags.StatusFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+
+		unwrapped, err := UnwrapClientStatus(packet.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, status, unwrapped)
+	}
+
+	_, err := UnwrapClientStatus([]byte{0xff, 0xff})
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestNewValidatedMixConfig(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{0x42}, PublicKeySize)
+	mix, err := NewValidatedMixConfig("Mix", "1.2.3.4", "1789", pubKey, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, NewMixConfig("Mix", "1.2.3.4", "1789", pubKey, 1), mix)
+
+	for _, invalid := range [][]byte{nil, {}, pubKey[:PublicKeySize-1], append(pubKey, 0x42)} {
+		_, err := NewValidatedMixConfig("Mix", "1.2.3.4", "1789", invalid, 1)
+		assert.Equal(t, ErrInvalidPublicKey, err, "Key of length %v should have been rejected", len(invalid))
+	}
+}
+
+func TestWrapHello_RoundTrip(t *testing.T) {
+	capabilities := Capabilities{Version: ProtocolVersion, Flags: []flags.PacketTypeFlag{flags.PullFlag, flags.HelloFlag}}
+	packetBytes, err := WrapHello(capabilities, []flags.PacketTypeFlag{flags.PullFlag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := UnwrapPacket(packetBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, flags.HelloFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+
+	unwrapped, required, err := UnwrapHello(packet.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, capabilities, unwrapped)
+	assert.Equal(t, []flags.PacketTypeFlag{flags.PullFlag}, required)
+	assert.True(t, unwrapped.Supports(flags.PullFlag))
+	assert.False(t, unwrapped.Supports(flags.AssignFlag))
+	assert.Equal(t, []flags.PacketTypeFlag{flags.AssignFlag}, unwrapped.Missing([]flags.PacketTypeFlag{flags.HelloFlag, flags.AssignFlag}))
+
+	// the flags unknown to the receiver are never supported
+	helloBytes, err := proto.Marshal(&Hello{Version: ProtocolVersion + 1, Flags: []byte{0x01}, Required: []byte{0x01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, required, err = UnwrapHello(helloBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(ProtocolVersion+1), unwrapped.Version)
+	assert.Equal(t, []flags.PacketTypeFlag{flags.InvalidPacketTypeFlag}, required)
+	assert.Equal(t, required, capabilities.Missing(required))
+
+	_, _, err = UnwrapHello([]byte{0xff, 0xff})
+	assert.Equal(t, ErrMalformedPacket, err)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// ErrorCode classifies why the provider could not handle a request of the client.
+type ErrorCode uint32
+
+const (
+	// ErrorCodeInternal means the request could not be handled for a reason unrelated to the request itself.
+	ErrorCodeInternal ErrorCode = iota
+	// ErrorCodeAuthenticationFailed means the token of the client was not accepted.
+	ErrorCodeAuthenticationFailed
+	// ErrorCodeRateLimited means the client sent more requests than the provider is willing to handle at once.
+	ErrorCodeRateLimited
+	// ErrorCodeMalformedRequest means the request could not be parsed or its type was not recognised.
+	ErrorCodeMalformedRequest
+	// ErrorCodeUnsupportedFeature means the client requires a feature the provider does not support.
+	ErrorCodeUnsupportedFeature
+	// ErrorCodeUnreachableClient means the provider could not connect to the address advertised by the client.
+	// It is no longer sent, as it let the clients probe the network through the provider, but its value is kept
+	// so that the codes following it would not change.
+	ErrorCodeUnreachableClient
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorCodeAuthenticationFailed:
+		return "authentication_failed"
+	case ErrorCodeRateLimited:
+		return "rate_limited"
+	case ErrorCodeMalformedRequest:
+		return "malformed_request"
+	case ErrorCodeUnsupportedFeature:
+		return "unsupported_feature"
+	case ErrorCodeUnreachableClient:
+		return "unreachable_client"
+	default:
+		return "internal"
+	}
+}
+
+// ProviderError is an error response sent by the provider in a packet with the ErrorFlag.
+type ProviderError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider error (%v): %v", e.Code, e.Message)
+}
+
+// IsProviderError reports whether err is an error response of the provider with the given code.
+func IsProviderError(err error, code ErrorCode) bool {
+	providerErr, ok := err.(*ProviderError)
+	return ok && providerErr.Code == code
+}
+
+// WrapError marshals the error response with the given code and message and wraps it with the ErrorFlag.
+func WrapError(code ErrorCode, message string) ([]byte, error) {
+	responseBytes, err := proto.Marshal(&ErrorResponse{Code: uint32(code), Message: message})
+	if err != nil {
+		return nil, err
+	}
+	return WrapWithFlag(flags.ErrorFlag, responseBytes)
+}
+
+// UnwrapError parses the data of a packet with the ErrorFlag into the error response it carries.
+// It returns ErrMalformedPacket if the data is not a valid error response.
+func UnwrapError(data []byte) (*ProviderError, error) {
+	var response ErrorResponse
+	if err := proto.Unmarshal(data, &response); err != nil {
+		return nil, ErrMalformedPacket
+	}
+	return &ProviderError{Code: ErrorCode(response.Code), Message: response.Message}, nil
+}
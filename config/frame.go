@@ -0,0 +1,114 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// MaxFrameSize is the maximum length of the data that can be sent in a single frame.
+	MaxFrameSize = 64 * 1024
+	// frameHeaderLength is the length of the big-endian length prefix preceding the data of each frame.
+	frameHeaderLength = 4
+)
+
+var (
+	// ErrFrameTooLarge is returned when the frame to be written or read exceeds MaxFrameSize.
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// WriteFrame writes the data to w preceded by its length, so that the reader could tell
+// where the data ends without relying on the connection being closed.
+func WriteFrame(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	var header [frameHeaderLength]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// FrameSize returns the number of bytes WriteFrame writes for the data.
+func FrameSize(data []byte) int {
+	return frameHeaderLength + len(data)
+}
+
+// ReadFrame reads a single frame written by WriteFrame from r.
+// It returns io.EOF if there are no more frames to be read and io.ErrUnexpectedEOF
+// if the stream ended in the middle of a frame.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [frameHeaderLength]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	frameLength := binary.BigEndian.Uint32(header[:])
+	if frameLength > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, frameLength)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// IsFrameStream checks, without consuming any data, whether r carries a stream of framed packets
+// rather than a single raw packet. Every raw packet starts with the PacketMagic, while the length prefix
+// of any frame not larger than MaxFrameSize starts with a zero byte, hence the two can be told apart
+// by the first byte.
+func IsFrameStream(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] == 0, nil
+}
+
+// FrameReader reads the successive frames written by WriteFrame from the underlying reader.
+type FrameReader struct {
+	r   io.Reader
+	err error
+}
+
+// NewFrameReader returns a FrameReader reading the frames from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r}
+}
+
+// Next returns the data of the next frame. It returns io.EOF if the stream ended after the last complete frame,
+// io.ErrUnexpectedEOF if it ended in the middle of a frame and ErrFrameTooLarge if the frame exceeds MaxFrameSize.
+// As the start of the next frame can't be found after any error, all the subsequent calls return the same error.
+func (f *FrameReader) Next() ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	data, err := ReadFrame(f.r)
+	if err != nil {
+		f.err = err
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// ProtocolVersion is the version of the protocol between the clients and the providers,
+// advertised in the hello exchange.
+const ProtocolVersion = 1
+
+// Capabilities describe what a client or a provider supports, as advertised in the hello exchange.
+type Capabilities struct {
+	// Version is the version of the protocol spoken by the peer.
+	Version uint32
+	// Flags are the packet type flags the peer handles.
+	Flags []flags.PacketTypeFlag
+}
+
+// Supports reports whether the packet type flag is among the advertised ones.
+func (c Capabilities) Supports(flag flags.PacketTypeFlag) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Missing returns the flags the capabilities do not support, in the given order.
+func (c Capabilities) Missing(required []flags.PacketTypeFlag) []flags.PacketTypeFlag {
+	var missing []flags.PacketTypeFlag
+	for _, flag := range required {
+		if !c.Supports(flag) {
+			missing = append(missing, flag)
+		}
+	}
+	return missing
+}
+
+// WrapHello marshals the capabilities, along with the flags the sender requires the receiver to support,
+// and wraps them with the HelloFlag.
+func WrapHello(capabilities Capabilities, required []flags.PacketTypeFlag) ([]byte, error) {
+	helloBytes, err := proto.Marshal(&Hello{Version: capabilities.Version,
+		Flags:    flagBytes(capabilities.Flags),
+		Required: flagBytes(required),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return WrapWithFlag(flags.HelloFlag, helloBytes)
+}
+
+// UnwrapHello parses the data of a packet with the HelloFlag into the capabilities of its sender and the flags
+// it requires the receiver to support. Any flags unknown to the receiver are reported as InvalidPacketTypeFlag,
+// so that they would never be supported. It returns ErrMalformedPacket if the data is not a valid hello.
+func UnwrapHello(data []byte) (Capabilities, []flags.PacketTypeFlag, error) {
+	var hello Hello
+	if err := proto.Unmarshal(data, &hello); err != nil {
+		return Capabilities{}, nil, ErrMalformedPacket
+	}
+	return Capabilities{Version: hello.Version, Flags: packetTypeFlags(hello.Flags)}, packetTypeFlags(hello.Required), nil
+}
+
+func flagBytes(packetFlags []flags.PacketTypeFlag) []byte {
+	b := make([]byte, len(packetFlags))
+	for i, flag := range packetFlags {
+		b[i] = byte(flag)
+	}
+	return b
+}
+
+func packetTypeFlags(b []byte) []flags.PacketTypeFlag {
+	if len(b) == 0 {
+		return nil
+	}
+	packetFlags := make([]flags.PacketTypeFlag, len(b))
+	for i := range b {
+		packetFlags[i] = flags.PacketTypeFlagFromByte(b[i])
+	}
+	return packetFlags
+}
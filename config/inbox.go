@@ -0,0 +1,72 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// InboxStatus tells the client what the provider found in its inbox when handling a pull request.
+type InboxStatus uint32
+
+const (
+	// InboxStatusUnknown means the provider did not report the status of the inbox.
+	InboxStatusUnknown InboxStatus = iota
+	// InboxStatusDelivered means the messages from the inbox were sent to the client.
+	InboxStatusDelivered
+	// InboxStatusEmpty means the inbox exists, but there were no messages in it.
+	InboxStatusEmpty
+	// InboxStatusNoInbox means the inbox does not exist, hence the client should register with the provider again.
+	// It is only reported by the providers not telling the missing inboxes of the registered clients
+	// apart from the unregistered clients, which otherwise get InboxStatusNotRegistered.
+	InboxStatusNoInbox
+	// InboxStatusNotRegistered means the client is not registered with the provider, and it has no inbox,
+	// hence it has to register before any messages could be stored for it.
+	InboxStatusNotRegistered
+)
+
+func (s InboxStatus) String() string {
+	switch s {
+	case InboxStatusDelivered:
+		return "delivered"
+	case InboxStatusEmpty:
+		return "empty"
+	case InboxStatusNoInbox:
+		return "no_inbox"
+	case InboxStatusNotRegistered:
+		return "not_registered"
+	default:
+		return "unknown"
+	}
+}
+
+// WrapInboxStatus marshals the status of the inbox and wraps it with the InboxStatusFlag.
+func WrapInboxStatus(status InboxStatus) ([]byte, error) {
+	responseBytes, err := proto.Marshal(&InboxStatusResponse{Status: uint32(status)})
+	if err != nil {
+		return nil, err
+	}
+	return WrapWithFlag(flags.InboxStatusFlag, responseBytes)
+}
+
+// UnwrapInboxStatus parses the data of a packet with the InboxStatusFlag into the status of the inbox it carries.
+// It returns ErrMalformedPacket if the data is not a valid status.
+func UnwrapInboxStatus(data []byte) (InboxStatus, error) {
+	var response InboxStatusResponse
+	if err := proto.Unmarshal(data, &response); err != nil {
+		return InboxStatusUnknown, ErrMalformedPacket
+	}
+	return InboxStatus(response.Status), nil
+}
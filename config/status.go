@@ -0,0 +1,87 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// RegistrationStatus tells the client whether the provider has it registered, in response to a status request.
+type RegistrationStatus uint32
+
+const (
+	// RegistrationStatusUnknown means the provider did not report whether the client is registered.
+	RegistrationStatusUnknown RegistrationStatus = iota
+	// RegistrationStatusRegistered means the client is registered with the provider.
+	RegistrationStatusRegistered
+	// RegistrationStatusNotRegistered means the client is not registered with the provider,
+	// hence it should register again to obtain a fresh token.
+	RegistrationStatusNotRegistered
+)
+
+func (s RegistrationStatus) String() string {
+	switch s {
+	case RegistrationStatusRegistered:
+		return "registered"
+	case RegistrationStatusNotRegistered:
+		return "not_registered"
+	default:
+		return "unknown"
+	}
+}
+
+// ClientStatus describes the state of the client at its provider, as reported in response to a status request.
+// The token of the client is never included.
+type ClientStatus struct {
+	Registration RegistrationStatus
+	// TokenIssuedAt is when the current token of the client was issued, with the precision of a second.
+	// It is zero if the client is not registered.
+	TokenIssuedAt time.Time
+	// BufferedMessages is the number of the messages awaiting the client in its inbox.
+	BufferedMessages uint64
+}
+
+// WrapClientStatus marshals the status of the client and wraps it with the StatusFlag.
+func WrapClientStatus(status ClientStatus) ([]byte, error) {
+	response := ClientStatusResponse{Registration: uint32(status.Registration),
+		BufferedMessages: status.BufferedMessages,
+	}
+	if !status.TokenIssuedAt.IsZero() {
+		response.TokenIssuedAt = status.TokenIssuedAt.Unix()
+	}
+	responseBytes, err := proto.Marshal(&response)
+	if err != nil {
+		return nil, err
+	}
+	return WrapWithFlag(flags.StatusFlag, responseBytes)
+}
+
+// UnwrapClientStatus parses the data of a packet with the StatusFlag into the status of the client it carries.
+// It returns ErrMalformedPacket if the data is not a valid status.
+func UnwrapClientStatus(data []byte) (ClientStatus, error) {
+	var response ClientStatusResponse
+	if err := proto.Unmarshal(data, &response); err != nil {
+		return ClientStatus{}, ErrMalformedPacket
+	}
+	status := ClientStatus{Registration: RegistrationStatus(response.Registration),
+		BufferedMessages: response.BufferedMessages,
+	}
+	if response.TokenIssuedAt != 0 {
+		status.TokenIssuedAt = time.Unix(response.TokenIssuedAt, 0)
+	}
+	return status, nil
+}
@@ -21,14 +21,15 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type MixConfig struct {
-	Id                   string   `protobuf:"bytes,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
-	Host                 string   `protobuf:"bytes,2,opt,name=Host,json=host,proto3" json:"Host,omitempty"`
-	Port                 string   `protobuf:"bytes,3,opt,name=Port,json=port,proto3" json:"Port,omitempty"`
-	PubKey               []byte   `protobuf:"bytes,4,opt,name=PubKey,json=pubKey,proto3" json:"PubKey,omitempty"`
-	Layer                uint64   `protobuf:"varint,5,opt,name=Layer,json=layer,proto3" json:"Layer,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Id                   string        `protobuf:"bytes,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	Host                 string        `protobuf:"bytes,2,opt,name=Host,json=host,proto3" json:"Host,omitempty"`
+	Port                 string        `protobuf:"bytes,3,opt,name=Port,json=port,proto3" json:"Port,omitempty"`
+	PubKey               []byte        `protobuf:"bytes,4,opt,name=PubKey,json=pubKey,proto3" json:"PubKey,omitempty"`
+	Layer                uint64        `protobuf:"varint,5,opt,name=Layer,json=layer,proto3" json:"Layer,omitempty"`
+	Params               *SphinxParams `protobuf:"bytes,6,opt,name=Params,json=params,proto3" json:"Params,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
 }
 
 func (m *MixConfig) Reset()         { *m = MixConfig{} }
@@ -91,6 +92,13 @@ func (m *MixConfig) GetLayer() uint64 {
 	return 0
 }
 
+func (m *MixConfig) GetParams() *SphinxParams {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
 type ClientConfig struct {
 	Id                   string     `protobuf:"bytes,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
 	Host                 string     `protobuf:"bytes,2,opt,name=Host,json=host,proto3" json:"Host,omitempty"`
@@ -209,130 +217,343 @@ func (m *GeneralPacket) GetData() []byte {
 	return nil
 }
 
-type ProviderResponse struct {
-	NumberOfPackets      uint64   `protobuf:"varint,1,opt,name=NumberOfPackets,json=numberOfPackets,proto3" json:"NumberOfPackets,omitempty"`
-	Packets              [][]byte `protobuf:"bytes,2,rep,name=Packets,json=packets,proto3" json:"Packets,omitempty"`
+type PullRequest struct {
+	Token                []byte   `protobuf:"bytes,1,opt,name=Token,json=token,proto3" json:"Token,omitempty"`
+	ClientPublicKey      []byte   `protobuf:"bytes,2,opt,name=ClientPublicKey,json=clientPublicKey,proto3" json:"ClientPublicKey,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *ProviderResponse) Reset()         { *m = ProviderResponse{} }
-func (m *ProviderResponse) String() string { return proto.CompactTextString(m) }
-func (*ProviderResponse) ProtoMessage()    {}
-func (*ProviderResponse) Descriptor() ([]byte, []int) {
+func (m *PullRequest) Reset()         { *m = PullRequest{} }
+func (m *PullRequest) String() string { return proto.CompactTextString(m) }
+func (*PullRequest) ProtoMessage()    {}
+func (*PullRequest) Descriptor() ([]byte, []int) {
 	return fileDescriptor_f9a12e0597d01ddf, []int{3}
 }
 
-func (m *ProviderResponse) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ProviderResponse.Unmarshal(m, b)
+func (m *PullRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PullRequest.Unmarshal(m, b)
 }
-func (m *ProviderResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ProviderResponse.Marshal(b, m, deterministic)
+func (m *PullRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PullRequest.Marshal(b, m, deterministic)
 }
-func (m *ProviderResponse) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ProviderResponse.Merge(m, src)
+func (m *PullRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PullRequest.Merge(m, src)
 }
-func (m *ProviderResponse) XXX_Size() int {
-	return xxx_messageInfo_ProviderResponse.Size(m)
+func (m *PullRequest) XXX_Size() int {
+	return xxx_messageInfo_PullRequest.Size(m)
 }
-func (m *ProviderResponse) XXX_DiscardUnknown() {
-	xxx_messageInfo_ProviderResponse.DiscardUnknown(m)
+func (m *PullRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PullRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_ProviderResponse proto.InternalMessageInfo
+var xxx_messageInfo_PullRequest proto.InternalMessageInfo
 
-func (m *ProviderResponse) GetNumberOfPackets() uint64 {
+func (m *PullRequest) GetToken() []byte {
 	if m != nil {
-		return m.NumberOfPackets
+		return m.Token
 	}
-	return 0
+	return nil
 }
 
-func (m *ProviderResponse) GetPackets() [][]byte {
+func (m *PullRequest) GetClientPublicKey() []byte {
 	if m != nil {
-		return m.Packets
+		return m.ClientPublicKey
 	}
 	return nil
 }
 
-type PullRequest struct {
-	Token                []byte   `protobuf:"bytes,1,opt,name=Token,json=token,proto3" json:"Token,omitempty"`
-	ClientPublicKey      []byte   `protobuf:"bytes,2,opt,name=ClientPublicKey,json=clientPublicKey,proto3" json:"ClientPublicKey,omitempty"`
+type SphinxParams struct {
+	K                    uint32   `protobuf:"varint,1,opt,name=K,json=k,proto3" json:"K,omitempty"`
+	MaxHops              uint32   `protobuf:"varint,2,opt,name=MaxHops,json=maxHops,proto3" json:"MaxHops,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *PullRequest) Reset()         { *m = PullRequest{} }
-func (m *PullRequest) String() string { return proto.CompactTextString(m) }
-func (*PullRequest) ProtoMessage()    {}
-func (*PullRequest) Descriptor() ([]byte, []int) {
+func (m *SphinxParams) Reset()         { *m = SphinxParams{} }
+func (m *SphinxParams) String() string { return proto.CompactTextString(m) }
+func (*SphinxParams) ProtoMessage()    {}
+func (*SphinxParams) Descriptor() ([]byte, []int) {
 	return fileDescriptor_f9a12e0597d01ddf, []int{4}
 }
 
-func (m *PullRequest) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_PullRequest.Unmarshal(m, b)
+func (m *SphinxParams) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SphinxParams.Unmarshal(m, b)
 }
-func (m *PullRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_PullRequest.Marshal(b, m, deterministic)
+func (m *SphinxParams) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SphinxParams.Marshal(b, m, deterministic)
 }
-func (m *PullRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_PullRequest.Merge(m, src)
+func (m *SphinxParams) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SphinxParams.Merge(m, src)
 }
-func (m *PullRequest) XXX_Size() int {
-	return xxx_messageInfo_PullRequest.Size(m)
+func (m *SphinxParams) XXX_Size() int {
+	return xxx_messageInfo_SphinxParams.Size(m)
 }
-func (m *PullRequest) XXX_DiscardUnknown() {
-	xxx_messageInfo_PullRequest.DiscardUnknown(m)
+func (m *SphinxParams) XXX_DiscardUnknown() {
+	xxx_messageInfo_SphinxParams.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_PullRequest proto.InternalMessageInfo
+var xxx_messageInfo_SphinxParams proto.InternalMessageInfo
 
-func (m *PullRequest) GetToken() []byte {
+func (m *SphinxParams) GetK() uint32 {
 	if m != nil {
-		return m.Token
+		return m.K
+	}
+	return 0
+}
+
+func (m *SphinxParams) GetMaxHops() uint32 {
+	if m != nil {
+		return m.MaxHops
+	}
+	return 0
+}
+
+type ErrorResponse struct {
+	Code                 uint32   `protobuf:"varint,1,opt,name=Code,json=code,proto3" json:"Code,omitempty"`
+	Message              string   `protobuf:"bytes,2,opt,name=Message,json=message,proto3" json:"Message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ErrorResponse) Reset()         { *m = ErrorResponse{} }
+func (m *ErrorResponse) String() string { return proto.CompactTextString(m) }
+func (*ErrorResponse) ProtoMessage()    {}
+func (*ErrorResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f9a12e0597d01ddf, []int{5}
+}
+
+func (m *ErrorResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ErrorResponse.Unmarshal(m, b)
+}
+func (m *ErrorResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ErrorResponse.Marshal(b, m, deterministic)
+}
+func (m *ErrorResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ErrorResponse.Merge(m, src)
+}
+func (m *ErrorResponse) XXX_Size() int {
+	return xxx_messageInfo_ErrorResponse.Size(m)
+}
+func (m *ErrorResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ErrorResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ErrorResponse proto.InternalMessageInfo
+
+func (m *ErrorResponse) GetCode() uint32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+func (m *ErrorResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type InboxStatusResponse struct {
+	Status               uint32   `protobuf:"varint,1,opt,name=Status,json=status,proto3" json:"Status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InboxStatusResponse) Reset()         { *m = InboxStatusResponse{} }
+func (m *InboxStatusResponse) String() string { return proto.CompactTextString(m) }
+func (*InboxStatusResponse) ProtoMessage()    {}
+func (*InboxStatusResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f9a12e0597d01ddf, []int{6}
+}
+
+func (m *InboxStatusResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InboxStatusResponse.Unmarshal(m, b)
+}
+func (m *InboxStatusResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InboxStatusResponse.Marshal(b, m, deterministic)
+}
+func (m *InboxStatusResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InboxStatusResponse.Merge(m, src)
+}
+func (m *InboxStatusResponse) XXX_Size() int {
+	return xxx_messageInfo_InboxStatusResponse.Size(m)
+}
+func (m *InboxStatusResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_InboxStatusResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InboxStatusResponse proto.InternalMessageInfo
+
+func (m *InboxStatusResponse) GetStatus() uint32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+type Hello struct {
+	Version              uint32   `protobuf:"varint,1,opt,name=Version,json=version,proto3" json:"Version,omitempty"`
+	Flags                []byte   `protobuf:"bytes,2,opt,name=Flags,json=flags,proto3" json:"Flags,omitempty"`
+	Required             []byte   `protobuf:"bytes,3,opt,name=Required,json=required,proto3" json:"Required,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Hello) Reset()         { *m = Hello{} }
+func (m *Hello) String() string { return proto.CompactTextString(m) }
+func (*Hello) ProtoMessage()    {}
+func (*Hello) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f9a12e0597d01ddf, []int{7}
+}
+
+func (m *Hello) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Hello.Unmarshal(m, b)
+}
+func (m *Hello) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Hello.Marshal(b, m, deterministic)
+}
+func (m *Hello) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Hello.Merge(m, src)
+}
+func (m *Hello) XXX_Size() int {
+	return xxx_messageInfo_Hello.Size(m)
+}
+func (m *Hello) XXX_DiscardUnknown() {
+	xxx_messageInfo_Hello.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Hello proto.InternalMessageInfo
+
+func (m *Hello) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Hello) GetFlags() []byte {
+	if m != nil {
+		return m.Flags
 	}
 	return nil
 }
 
-func (m *PullRequest) GetClientPublicKey() []byte {
+func (m *Hello) GetRequired() []byte {
 	if m != nil {
-		return m.ClientPublicKey
+		return m.Required
 	}
 	return nil
 }
 
+type ClientStatusResponse struct {
+	Registration         uint32   `protobuf:"varint,1,opt,name=Registration,json=registration,proto3" json:"Registration,omitempty"`
+	TokenIssuedAt        int64    `protobuf:"varint,2,opt,name=TokenIssuedAt,json=tokenIssuedAt,proto3" json:"TokenIssuedAt,omitempty"`
+	BufferedMessages     uint64   `protobuf:"varint,3,opt,name=BufferedMessages,json=bufferedMessages,proto3" json:"BufferedMessages,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ClientStatusResponse) Reset()         { *m = ClientStatusResponse{} }
+func (m *ClientStatusResponse) String() string { return proto.CompactTextString(m) }
+func (*ClientStatusResponse) ProtoMessage()    {}
+func (*ClientStatusResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f9a12e0597d01ddf, []int{8}
+}
+
+func (m *ClientStatusResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ClientStatusResponse.Unmarshal(m, b)
+}
+func (m *ClientStatusResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ClientStatusResponse.Marshal(b, m, deterministic)
+}
+func (m *ClientStatusResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ClientStatusResponse.Merge(m, src)
+}
+func (m *ClientStatusResponse) XXX_Size() int {
+	return xxx_messageInfo_ClientStatusResponse.Size(m)
+}
+func (m *ClientStatusResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ClientStatusResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ClientStatusResponse proto.InternalMessageInfo
+
+func (m *ClientStatusResponse) GetRegistration() uint32 {
+	if m != nil {
+		return m.Registration
+	}
+	return 0
+}
+
+func (m *ClientStatusResponse) GetTokenIssuedAt() int64 {
+	if m != nil {
+		return m.TokenIssuedAt
+	}
+	return 0
+}
+
+func (m *ClientStatusResponse) GetBufferedMessages() uint64 {
+	if m != nil {
+		return m.BufferedMessages
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*MixConfig)(nil), "config.MixConfig")
 	proto.RegisterType((*ClientConfig)(nil), "config.ClientConfig")
 	proto.RegisterType((*GeneralPacket)(nil), "config.GeneralPacket")
-	proto.RegisterType((*ProviderResponse)(nil), "config.ProviderResponse")
 	proto.RegisterType((*PullRequest)(nil), "config.PullRequest")
+	proto.RegisterType((*SphinxParams)(nil), "config.SphinxParams")
+	proto.RegisterType((*ErrorResponse)(nil), "config.ErrorResponse")
+	proto.RegisterType((*InboxStatusResponse)(nil), "config.InboxStatusResponse")
+	proto.RegisterType((*Hello)(nil), "config.Hello")
+	proto.RegisterType((*ClientStatusResponse)(nil), "config.ClientStatusResponse")
 }
 
 func init() { proto.RegisterFile("config/structs.proto", fileDescriptor_f9a12e0597d01ddf) }
 
 var fileDescriptor_f9a12e0597d01ddf = []byte{
-	// 317 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x91, 0xcb, 0x4e, 0xf3, 0x30,
-	0x10, 0x85, 0x95, 0xd4, 0x49, 0xdb, 0x69, 0xfe, 0xbf, 0x60, 0x55, 0x28, 0xcb, 0x28, 0xab, 0x6c,
-	0x28, 0x12, 0x2c, 0x78, 0x80, 0x22, 0x2e, 0x82, 0x42, 0x64, 0x21, 0xf6, 0x4e, 0xe2, 0x96, 0xa8,
-	0x26, 0x4e, 0x7d, 0x41, 0xf4, 0x21, 0x78, 0x67, 0x64, 0xbb, 0x45, 0xea, 0x03, 0xb0, 0x3c, 0x9f,
-	0x46, 0x73, 0xce, 0x9c, 0x81, 0x59, 0x2d, 0xba, 0x55, 0xbb, 0xbe, 0x50, 0x5a, 0x9a, 0x5a, 0xab,
-	0x79, 0x2f, 0x85, 0x16, 0x38, 0xf6, 0x34, 0xdf, 0xc2, 0x78, 0xd9, 0x7e, 0x2d, 0x9c, 0xc0, 0xff,
-	0x21, 0x7c, 0x68, 0xd2, 0x20, 0x0b, 0x8a, 0x31, 0x09, 0xdb, 0x06, 0x63, 0x40, 0xf7, 0x42, 0xe9,
-	0x34, 0x74, 0x04, 0xbd, 0x0b, 0xa5, 0x2d, 0x2b, 0x85, 0xd4, 0xe9, 0xc0, 0xb3, 0x5e, 0x48, 0x8d,
-	0xcf, 0x20, 0x2e, 0x4d, 0xf5, 0xc8, 0x76, 0x29, 0xca, 0x82, 0x22, 0x21, 0x71, 0xef, 0x14, 0x9e,
-	0x41, 0xf4, 0x44, 0x77, 0x4c, 0xa6, 0x51, 0x16, 0x14, 0x88, 0x44, 0xdc, 0x8a, 0xfc, 0x3b, 0x80,
-	0x64, 0xc1, 0x5b, 0xd6, 0xe9, 0x3f, 0xb2, 0x3d, 0x87, 0x51, 0x29, 0xc5, 0x67, 0xdb, 0xec, 0x9d,
-	0x27, 0x97, 0xa7, 0x73, 0x7f, 0xee, 0xfc, 0xf7, 0x56, 0x32, 0xea, 0xf7, 0x23, 0xf9, 0x35, 0xfc,
-	0xbb, 0x63, 0x1d, 0x93, 0x94, 0x97, 0xb4, 0xde, 0x30, 0xe7, 0x75, 0xcb, 0xe9, 0xda, 0x25, 0x4a,
-	0x08, 0x5a, 0x71, 0xba, 0xb6, 0xec, 0x86, 0x6a, 0xea, 0x32, 0x25, 0x04, 0x35, 0x54, 0xd3, 0xfc,
-	0x0d, 0x4e, 0x0e, 0x3e, 0x84, 0xa9, 0x5e, 0x74, 0x8a, 0xe1, 0x02, 0xa6, 0xcf, 0xe6, 0xa3, 0x62,
-	0xf2, 0x65, 0xe5, 0xb7, 0x29, 0xb7, 0x06, 0x91, 0x69, 0x77, 0x8c, 0x71, 0x0a, 0xc3, 0xc3, 0x44,
-	0x98, 0x0d, 0x8a, 0x84, 0x0c, 0x7b, 0x2f, 0xf3, 0x25, 0x4c, 0x4a, 0xc3, 0x39, 0x61, 0x5b, 0xc3,
-	0x94, 0xb6, 0x2d, 0xbe, 0x8a, 0x0d, 0xeb, 0xf6, 0x79, 0x22, 0x6d, 0x85, 0x35, 0xf2, 0x25, 0x96,
-	0xa6, 0xe2, 0x6d, 0x6d, 0x5b, 0xf0, 0xd9, 0xa6, 0xf5, 0x31, 0xae, 0x62, 0xf7, 0xf1, 0xab, 0x9f,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0x17, 0xe5, 0x0a, 0x17, 0x09, 0x02, 0x00, 0x00,
+	// 524 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x93, 0x5f, 0x6f, 0xd3, 0x3c,
+	0x18, 0xc5, 0x95, 0x2d, 0x49, 0xbb, 0x67, 0xc9, 0xbb, 0xbd, 0xa6, 0x9a, 0x22, 0xae, 0xaa, 0x68,
+	0x42, 0x15, 0x62, 0x9d, 0x34, 0x24, 0xb8, 0xe2, 0x82, 0x8d, 0x3f, 0x9b, 0xc6, 0xa4, 0xc8, 0x45,
+	0x5c, 0x70, 0xe7, 0x24, 0x4f, 0x53, 0xab, 0x49, 0x1c, 0x6c, 0x67, 0x6a, 0xbf, 0x03, 0x7c, 0x06,
+	0xbe, 0x2a, 0xb2, 0x1d, 0x46, 0xd9, 0x3d, 0x57, 0xd6, 0x39, 0xf5, 0x73, 0x7c, 0xfc, 0xab, 0x03,
+	0x93, 0x42, 0xb4, 0x4b, 0x5e, 0x9d, 0x2b, 0x2d, 0xfb, 0x42, 0xab, 0x79, 0x27, 0x85, 0x16, 0x24,
+	0x74, 0x6e, 0xfa, 0xd3, 0x83, 0x83, 0x3b, 0xbe, 0xb9, 0xb2, 0x8a, 0xfc, 0x07, 0x7b, 0x37, 0x65,
+	0xe2, 0x4d, 0xbd, 0xd9, 0x01, 0xdd, 0xe3, 0x25, 0x21, 0xe0, 0x5f, 0x0b, 0xa5, 0x93, 0x3d, 0xeb,
+	0xf8, 0x2b, 0xa1, 0xb4, 0xf1, 0x32, 0x21, 0x75, 0xb2, 0xef, 0xbc, 0x4e, 0x48, 0x4d, 0x4e, 0x20,
+	0xcc, 0xfa, 0xfc, 0x16, 0xb7, 0x89, 0x3f, 0xf5, 0x66, 0x11, 0x0d, 0x3b, 0xab, 0xc8, 0x04, 0x82,
+	0x4f, 0x6c, 0x8b, 0x32, 0x09, 0xa6, 0xde, 0xcc, 0xa7, 0x41, 0x6d, 0x04, 0x79, 0x01, 0x61, 0xc6,
+	0x24, 0x6b, 0x54, 0x12, 0x4e, 0xbd, 0xd9, 0xe1, 0xc5, 0x64, 0xee, 0xca, 0xcc, 0x17, 0xdd, 0x8a,
+	0xb7, 0x1b, 0xf7, 0x1b, 0x0d, 0x3b, 0xbb, 0xa6, 0x3f, 0x3c, 0x88, 0xae, 0x6a, 0x8e, 0xad, 0xfe,
+	0x47, 0x25, 0xcf, 0x60, 0x9c, 0x49, 0x71, 0xcf, 0xcb, 0xa1, 0xe7, 0xe1, 0xc5, 0xff, 0xbf, 0x0b,
+	0x3d, 0x90, 0xa1, 0xe3, 0x6e, 0xd8, 0x92, 0xbe, 0x86, 0xf8, 0x23, 0xb6, 0x28, 0x59, 0x9d, 0xb1,
+	0x62, 0x8d, 0xf6, 0xac, 0x0f, 0x35, 0xab, 0x6c, 0xa3, 0x88, 0xfa, 0xcb, 0x9a, 0x55, 0xc6, 0x7b,
+	0xc7, 0x34, 0xb3, 0x9d, 0x22, 0xea, 0x97, 0x4c, 0xb3, 0xf4, 0x0e, 0x0e, 0xb3, 0xbe, 0xae, 0x29,
+	0x7e, 0xeb, 0x51, 0x69, 0xc3, 0xe6, 0xb3, 0x58, 0x63, 0x3b, 0xcc, 0x05, 0xda, 0x08, 0x32, 0x83,
+	0x23, 0x77, 0xd9, 0xac, 0xcf, 0x6b, 0x5e, 0x98, 0xb6, 0x2e, 0xe3, 0xa8, 0xf8, 0xdb, 0x4e, 0x5f,
+	0x41, 0xb4, 0xcb, 0x8b, 0x44, 0xe0, 0xdd, 0xda, 0xac, 0x98, 0x7a, 0x6b, 0x92, 0xc0, 0xe8, 0x8e,
+	0x6d, 0xae, 0x45, 0xa7, 0xec, 0x7c, 0x4c, 0x47, 0x8d, 0x93, 0xe9, 0x1b, 0x88, 0xdf, 0x4b, 0x29,
+	0x24, 0x45, 0xd5, 0x89, 0x56, 0xa1, 0xe9, 0x7a, 0x25, 0x4a, 0x1c, 0x66, 0xfd, 0x42, 0x94, 0x68,
+	0xc7, 0x51, 0x29, 0x56, 0xe1, 0x80, 0x75, 0xd4, 0x38, 0x99, 0x9e, 0xc1, 0x93, 0x9b, 0x36, 0x17,
+	0x9b, 0x85, 0x66, 0xba, 0x57, 0x0f, 0x21, 0x27, 0x10, 0x3a, 0x67, 0x88, 0x09, 0x95, 0x55, 0xe9,
+	0x02, 0x82, 0x6b, 0xac, 0x6b, 0x61, 0x12, 0xbf, 0xa0, 0x54, 0x5c, 0xb4, 0xc3, 0x8e, 0xd1, 0xbd,
+	0x93, 0x06, 0x84, 0xe1, 0xa7, 0x86, 0x8b, 0x06, 0x06, 0xa0, 0x22, 0x4f, 0x61, 0x6c, 0x48, 0x71,
+	0x89, 0xa5, 0xfd, 0x17, 0x23, 0x3a, 0x96, 0x83, 0x4e, 0xbf, 0x7b, 0x30, 0x71, 0x94, 0x1e, 0xb5,
+	0x48, 0x21, 0xa2, 0x58, 0x71, 0xa5, 0x25, 0xd3, 0x7f, 0x4e, 0x8a, 0xe4, 0x8e, 0x47, 0x4e, 0x21,
+	0xb6, 0xdc, 0x6f, 0x94, 0xea, 0xb1, 0x7c, 0xeb, 0xde, 0xcd, 0x3e, 0x8d, 0xf5, 0xae, 0x49, 0x9e,
+	0xc3, 0xf1, 0x65, 0xbf, 0x5c, 0xa2, 0xc4, 0x72, 0x00, 0xa1, 0x6c, 0x0d, 0x9f, 0x1e, 0xe7, 0x8f,
+	0xfc, 0xcb, 0x67, 0x5f, 0x4f, 0x2b, 0xae, 0x57, 0x7d, 0x3e, 0x2f, 0x44, 0x73, 0xde, 0x6e, 0x1b,
+	0x8d, 0xc5, 0xca, 0xac, 0x67, 0x0d, 0xdf, 0xb4, 0xa8, 0xcf, 0xdd, 0x6b, 0xca, 0x43, 0xfb, 0xe9,
+	0xbd, 0xfc, 0x35, 0x00, 0x0b, 0xb7, 0xbe, 0x57, 0x92, 0x03, 0x00, 0x00,
 }
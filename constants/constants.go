@@ -21,4 +21,11 @@ const (
 
 	// PublicKeyPEMType defines PEM Type for Sphinx Public Key on Curve25519.
 	PublicKeyPEMType = "SPHINX CURVE25519 PUBLIC KEY"
+
+	// TokenMasterKeyPEMType defines PEM Type for the master key used by providers to issue stateless tokens.
+	TokenMasterKeyPEMType = "NYM PROVIDER TOKEN MASTER KEY"
+
+	// BenchmarkProviderKeySeed defines seed used to derive keys of the benchmark provider,
+	// so that the pki/database would not need to be reset every run.
+	BenchmarkProviderKeySeed = "BenchmarkProvider"
 )
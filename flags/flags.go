@@ -69,6 +69,24 @@ const (
 	TokenFlag PacketTypeFlag = '\xa9'
 	// PullFlag is used to indicate client request to obtain all its messages stored at a particular provider.
 	PullFlag PacketTypeFlag = '\xff'
+	// DummyFlag is used to indicate a dummy message padding a pull response, which should be discarded by the client.
+	// The flag is sent in the clear, hence it does not hide the dummies from an observer of the response.
+	DummyFlag PacketTypeFlag = '\xd1'
+	// ErrorFlag is used to indicate that the packet contains an error response from provider
+	// explaining why the request of the client could not be handled.
+	ErrorFlag PacketTypeFlag = '\xe5'
+	// InboxStatusFlag is used to indicate that the packet contains the status of the inbox of the client,
+	// which concludes the response to a pull request.
+	InboxStatusFlag PacketTypeFlag = '\xb5'
+	// RotateTokenFlag is used to indicate client request to replace its authentication token with a fresh one,
+	// which is sent back in a packet with the TokenFlag.
+	RotateTokenFlag PacketTypeFlag = '\xa4'
+	// HelloFlag is used to indicate the exchange of the protocol version and the supported packet type flags
+	// between the client and the provider, so that neither would send the flags the other one does not handle.
+	HelloFlag PacketTypeFlag = '\xa7'
+	// StatusFlag is used to indicate client request to obtain its registration status at the provider,
+	// which is sent back in a packet with the same flag.
+	StatusFlag PacketTypeFlag = '\xb7'
 	// InvalidFlag is used to indicate an invalid packet type flag.
 	InvalidPacketTypeFlag PacketTypeFlag = '\x00'
 )
@@ -87,6 +105,18 @@ func PacketTypeFlagFromByte(b byte) PacketTypeFlag {
 		return TokenFlag
 	case byte(PullFlag):
 		return PullFlag
+	case byte(DummyFlag):
+		return DummyFlag
+	case byte(ErrorFlag):
+		return ErrorFlag
+	case byte(InboxStatusFlag):
+		return InboxStatusFlag
+	case byte(RotateTokenFlag):
+		return RotateTokenFlag
+	case byte(HelloFlag):
+		return HelloFlag
+	case byte(StatusFlag):
+		return StatusFlag
 	default:
 		return InvalidPacketTypeFlag
 	}
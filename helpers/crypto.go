@@ -20,9 +20,8 @@ package helpers
 
 import (
 	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
-	"math/rand"
-	"time"
 
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/sphinx"
@@ -34,16 +33,10 @@ var (
 	ErrExponentialDistributionParam = errors.New("the parameter of exponential distribution has to be larger than zero")
 )
 
-func init() {
-	// TODO: replace math/rand with crypto/rand to get rid of needing to seed it?
-	// + it will be more 'secure'
-	// However, we would need to implement 'Perm' ourselves
-	rand.Seed(time.Now().UTC().UnixNano())
-}
-
-// RandomMix returns a single pseudorandomly chosen mix from given slices of mixes.
+// RandomMix returns a single randomly chosen mix from given slices of mixes, drawn from the default
+// crypto-grade source of randomness.
 func RandomMix(mixes []config.MixConfig) config.MixConfig {
-	return mixes[rand.Intn(len(mixes))]
+	return defaultRand.Mix(mixes)
 }
 
 // a very dummy implementation of getting "random" string of given length
@@ -52,16 +45,22 @@ func RandomString(length int) string {
 	letterRunes := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
 	b := make([]rune, length)
 	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+		b[i] = letterRunes[defaultRand.Intn(len(letterRunes))]
 	}
 	return string(b)
 }
 
+// RandomExponential returns a value drawn from the exponential distribution with the given rate parameter,
+// using the default crypto-grade source of randomness.
 func RandomExponential(expParam float64) (float64, error) {
-	if expParam <= 0.0 {
-		return 0.0, ErrExponentialDistributionParam
-	}
-	return rand.ExpFloat64() / expParam, nil
+	return defaultRand.Exponential(expParam)
+}
+
+// RandomDelaySequence generates a sequence of the given length of delays drawn independently
+// from the exponential distribution with the given rate parameter. It returns ErrExponentialDistributionParam
+// if the parameter is non-positive.
+func RandomDelaySequence(rateParam float64, length int) ([]float64, error) {
+	return DelaySequence(defaultRand, ExponentialDelay{Rate: rateParam}, length)
 }
 
 // SHA256 computes the hash value of a given argument using SHA256 algorithm.
@@ -73,12 +72,11 @@ func SHA256(arg []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+// IsZeroElement checks in constant time whether all bytes of the given element are zero.
 func IsZeroElement(el sphinx.CryptoElement) bool {
-	bytes := el.Bytes()
-	for _, b := range bytes {
-		if b != 0 {
-			return false
-		}
+	var acc byte
+	for _, b := range el.Bytes() {
+		acc |= b
 	}
-	return true
+	return subtle.ConstantTimeByteEq(acc, 0) == 1
 }
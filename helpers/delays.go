@@ -0,0 +1,155 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// ExponentialDistribution is the name of the exponential delay distribution, parametrised by its rate.
+	ExponentialDistribution = "exponential"
+	// UniformDistribution is the name of the uniform delay distribution, parametrised by its minimum and maximum.
+	UniformDistribution = "uniform"
+	// ParetoDistribution is the name of the Pareto delay distribution, parametrised by its scale and shape.
+	ParetoDistribution = "pareto"
+	// ConstantDistribution is the name of the degenerate delay distribution always yielding the same delay.
+	ConstantDistribution = "constant"
+)
+
+var (
+	ErrUniformDistributionParams  = errors.New("the bounds of uniform distribution have to satisfy 0 <= min <= max")
+	ErrParetoDistributionParams   = errors.New("the scale and shape of Pareto distribution have to be larger than zero")
+	ErrConstantDistributionParam  = errors.New("the constant delay can't be negative")
+	ErrUnknownDelayDistribution   = errors.New("unknown delay distribution")
+	ErrDelayDistributionParamsLen = errors.New("invalid number of parameters of the delay distribution")
+)
+
+// DelayDistribution is a distribution the delays (in seconds) the packets are held for at each hop are drawn from.
+type DelayDistribution interface {
+	// Sample draws a single delay from the distribution using the given source of randomness.
+	// It returns an error if the distribution has invalid parameters.
+	Sample(r *Rand) (float64, error)
+}
+
+// ExponentialDelay is the exponential distribution with the given rate parameter,
+// i.e. the reciprocal of the mean delay. It is the distribution Loopix is designed for.
+type ExponentialDelay struct {
+	Rate float64
+}
+
+// Sample draws a delay from the distribution. It returns ErrExponentialDistributionParam if the rate is non-positive.
+func (d ExponentialDelay) Sample(r *Rand) (float64, error) {
+	return r.Exponential(d.Rate)
+}
+
+// UniformDelay is the uniform distribution over [Min, Max).
+type UniformDelay struct {
+	Min float64
+	Max float64
+}
+
+// Sample draws a delay from the distribution. It returns ErrUniformDistributionParams if the bounds are invalid.
+func (d UniformDelay) Sample(r *Rand) (float64, error) {
+	if !(d.Min >= 0 && d.Min <= d.Max) {
+		return 0.0, ErrUniformDistributionParams
+	}
+	return d.Min + r.Float64()*(d.Max-d.Min), nil
+}
+
+// ParetoDelay is the Pareto distribution with the given scale, i.e. the minimum delay, and shape.
+// Its tail becomes heavier as the shape decreases.
+type ParetoDelay struct {
+	Scale float64
+	Shape float64
+}
+
+// Sample draws a delay from the distribution. It returns ErrParetoDistributionParams if the parameters are non-positive.
+func (d ParetoDelay) Sample(r *Rand) (float64, error) {
+	if !(d.Scale > 0 && d.Shape > 0) {
+		return 0.0, ErrParetoDistributionParams
+	}
+	// inverse transform sampling, the uniform sample is taken from (0, 1] to avoid division by zero
+	return d.Scale / math.Pow(1-r.Float64(), 1/d.Shape), nil
+}
+
+// ConstantDelay always yields the same delay.
+type ConstantDelay struct {
+	Delay float64
+}
+
+// Sample returns the constant delay. It returns ErrConstantDistributionParam if the delay is negative.
+func (d ConstantDelay) Sample(*Rand) (float64, error) {
+	if !(d.Delay >= 0) {
+		return 0.0, ErrConstantDistributionParam
+	}
+	return d.Delay, nil
+}
+
+// NewDelayDistribution returns the delay distribution with the given name and parameters,
+// which are, in order: the rate of the exponential distribution; the minimum and maximum of the uniform one;
+// the scale and shape of the Pareto one; and the delay of the constant one. It returns an error
+// if the distribution is not known or its parameters are invalid.
+func NewDelayDistribution(name string, params ...float64) (DelayDistribution, error) {
+	var dist DelayDistribution
+	var numParams int
+	switch name {
+	case ExponentialDistribution:
+		numParams = 1
+		if len(params) == numParams {
+			dist = ExponentialDelay{Rate: params[0]}
+		}
+	case UniformDistribution:
+		numParams = 2
+		if len(params) == numParams {
+			dist = UniformDelay{Min: params[0], Max: params[1]}
+		}
+	case ParetoDistribution:
+		numParams = 2
+		if len(params) == numParams {
+			dist = ParetoDelay{Scale: params[0], Shape: params[1]}
+		}
+	case ConstantDistribution:
+		numParams = 1
+		if len(params) == numParams {
+			dist = ConstantDelay{Delay: params[0]}
+		}
+	default:
+		return nil, ErrUnknownDelayDistribution
+	}
+	if len(params) != numParams {
+		return nil, ErrDelayDistributionParamsLen
+	}
+	// drawing a single sample validates the parameters
+	if _, err := dist.Sample(defaultRand); err != nil {
+		return nil, err
+	}
+	return dist, nil
+}
+
+// DelaySequence generates a sequence of the given length of delays drawn independently from the given distribution
+// using the given source of randomness. It returns an error if the distribution has invalid parameters.
+func DelaySequence(r *Rand, dist DelayDistribution, length int) ([]float64, error) {
+	delays := make([]float64, 0, length)
+	for i := 0; i < length; i++ {
+		d, err := dist.Sample(r)
+		if err != nil {
+			return nil, err
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const numDelaySamples = 20000
+
+// sampleMean draws numDelaySamples delays from the distribution and returns their mean and minimum and maximum.
+func sampleMean(t *testing.T, dist DelayDistribution) (float64, float64, float64) {
+	sum, min, max := 0.0, math.Inf(1), math.Inf(-1)
+	for i := 0; i < numDelaySamples; i++ {
+		d, err := dist.Sample(defaultRand)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += d
+		min = math.Min(min, d)
+		max = math.Max(max, d)
+	}
+	return sum / numDelaySamples, min, max
+}
+
+func TestExponentialDelay(t *testing.T) {
+	mean, min, _ := sampleMean(t, ExponentialDelay{Rate: 5.0})
+	assert.InDelta(t, 1/5.0, mean, 0.01)
+	assert.True(t, min >= 0)
+
+	for _, rate := range []float64{0.0, -1.0} {
+		_, err := ExponentialDelay{Rate: rate}.Sample(defaultRand)
+		assert.Equal(t, ErrExponentialDistributionParam, err)
+	}
+}
+
+func TestUniformDelay(t *testing.T) {
+	mean, min, max := sampleMean(t, UniformDelay{Min: 1.0, Max: 3.0})
+	assert.InDelta(t, 2.0, mean, 0.05)
+	assert.True(t, min >= 1.0)
+	assert.True(t, max < 3.0)
+
+	for _, invalid := range []UniformDelay{{Min: -1.0, Max: 1.0}, {Min: 2.0, Max: 1.0}, {Min: math.NaN(), Max: 1.0}} {
+		_, err := invalid.Sample(defaultRand)
+		assert.Equal(t, ErrUniformDistributionParams, err)
+	}
+}
+
+func TestParetoDelay(t *testing.T) {
+	// the mean of the Pareto distribution is shape * scale / (shape - 1)
+	mean, min, _ := sampleMean(t, ParetoDelay{Scale: 1.0, Shape: 3.0})
+	assert.InDelta(t, 1.5, mean, 0.1)
+	assert.True(t, min >= 1.0, "Delays should never be lower than the scale")
+
+	for _, invalid := range []ParetoDelay{{Scale: 0.0, Shape: 1.0}, {Scale: 1.0, Shape: 0.0}, {Scale: -1.0, Shape: 1.0}} {
+		_, err := invalid.Sample(defaultRand)
+		assert.Equal(t, ErrParetoDistributionParams, err)
+	}
+}
+
+func TestConstantDelay(t *testing.T) {
+	mean, min, max := sampleMean(t, ConstantDelay{Delay: 0.5})
+	assert.Equal(t, 0.5, mean)
+	assert.Equal(t, min, max)
+
+	_, err := ConstantDelay{Delay: -0.5}.Sample(defaultRand)
+	assert.Equal(t, ErrConstantDistributionParam, err)
+}
+
+func TestNewDelayDistribution(t *testing.T) {
+	dist, err := NewDelayDistribution(ExponentialDistribution, 5.0)
+	assert.Nil(t, err)
+	assert.Equal(t, ExponentialDelay{Rate: 5.0}, dist)
+
+	dist, err = NewDelayDistribution(UniformDistribution, 1.0, 2.0)
+	assert.Nil(t, err)
+	assert.Equal(t, UniformDelay{Min: 1.0, Max: 2.0}, dist)
+
+	dist, err = NewDelayDistribution(ParetoDistribution, 1.0, 2.0)
+	assert.Nil(t, err)
+	assert.Equal(t, ParetoDelay{Scale: 1.0, Shape: 2.0}, dist)
+
+	dist, err = NewDelayDistribution(ConstantDistribution, 1.0)
+	assert.Nil(t, err)
+	assert.Equal(t, ConstantDelay{Delay: 1.0}, dist)
+
+	_, err = NewDelayDistribution("gaussian", 1.0, 1.0)
+	assert.Equal(t, ErrUnknownDelayDistribution, err)
+	_, err = NewDelayDistribution(UniformDistribution, 1.0)
+	assert.Equal(t, ErrDelayDistributionParamsLen, err)
+	_, err = NewDelayDistribution(ExponentialDistribution, 0.0)
+	assert.Equal(t, ErrExponentialDistributionParam, err)
+}
+
+func TestDelaySequence(t *testing.T) {
+	delays, err := DelaySequence(defaultRand, ConstantDelay{Delay: 1.0}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1.0, 1.0, 1.0, 1.0}, delays)
+
+	delays, err = DelaySequence(defaultRand, ParetoDelay{}, 4)
+	assert.Equal(t, ErrParetoDistributionParams, err)
+	assert.Nil(t, delays)
+}
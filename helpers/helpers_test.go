@@ -127,3 +127,24 @@ func TestRandomExponential_Fail_NegativeParam(t *testing.T) {
 		" RandomExponential should return an error if the given parameter is non-positive",
 	)
 }
+
+func TestRandomDelaySequence(t *testing.T) {
+	for _, length := range []int{0, 1, 5} {
+		delays, err := RandomDelaySequence(5.0, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, delays, length)
+		for _, d := range delays {
+			assert.True(t, d >= 0, "Delays drawn from the exponential distribution should be non-negative")
+		}
+	}
+}
+
+func TestRandomDelaySequence_Fail_NonPositiveParam(t *testing.T) {
+	for _, param := range []float64{0.0, -1.0} {
+		delays, err := RandomDelaySequence(param, 5)
+		assert.Equal(t, ErrExponentialDistributionParam, err)
+		assert.Nil(t, delays)
+	}
+}
@@ -23,34 +23,68 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/clock"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
 var (
 	ErrInvalidLocalIP = errors.New("couldn't find a valid IP for your machine, check your internet connection")
+	// ErrInvalidDirectoryURL is returned when the URL of the directory server is not an absolute http(s) URL.
+	ErrInvalidDirectoryURL = errors.New("invalid directory server URL")
 )
 
 // ResolveTCPAddress returns an address of TCP end point given a host and port.
 func ResolveTCPAddress(host, port string) (*net.TCPAddr, error) {
-	addr, err := net.ResolveTCPAddr("tcp", host+":"+port)
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
 	if err != nil {
 		return nil, err
 	}
 	return addr, nil
 }
 
+// IsTransientListenError checks whether the failure to listen on an address might go away when retried,
+// i.e. whether the address is momentarily in use, for example by a previous instance of the node still shutting down.
+func IsTransientListenError(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// ListenWithRetry listens on the given TCP address. If the address is in use, listening is retried, making
+// at most the given number of attempts in total. It waits for backoff before the first retry and twice as long
+// before each subsequent one, as measured by the given clock. Any other failures, such as lacking the permission to bind to the port,
+// are returned straight away.
+func ListenWithRetry(clk clock.Clock, address string, attempts int, backoff time.Duration) (net.Listener, error) {
+	for attempt := 1; ; attempt++ {
+		listener, err := net.Listen("tcp", address)
+		if err == nil {
+			return listener, nil
+		}
+		if !IsTransientListenError(err) || attempt >= attempts {
+			return nil, err
+		}
+		clock.Sleep(clk, backoff)
+		backoff *= 2
+	}
+}
+
 // GetLocalIP attempts to figure out a valid IP address for this machine.
+// IPv4 addresses are preferred, but if there are none, a global IPv6 address is returned instead.
 func GetLocalIP() (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return "", err
 	}
 
+	var ipv6 net.IP
 	for _, iface := range ifaces {
 		addrs, err := iface.Addrs()
 		if err != nil {
@@ -67,21 +101,58 @@ func GetLocalIP() (string, error) {
 			if ip == nil || ip.IsLoopback() {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil {
-				continue // not an ipv4 address
+			if ip.To4() == nil {
+				if ipv6 == nil && ip.IsGlobalUnicast() {
+					ipv6 = ip
+				}
+				continue
 			}
-			return ip.String(), nil
+			return ip.To4().String(), nil
 		}
 	}
 
+	if ipv6 != nil {
+		return ipv6.String(), nil
+	}
 	return "", ErrInvalidLocalIP
 }
 
+// ResolveHost returns the host a node runs on: the explicitly given host if there is one,
+// or otherwise the local IP address found by localIP, such as GetLocalIP. The local address
+// is only looked up if needed, so that a node with an explicit host can start even without one.
+func ResolveHost(host string, localIP func() (string, error)) (string, error) {
+	if host != "" {
+		return host, nil
+	}
+	return localIP()
+}
+
+// IsLoopbackHost checks whether the host, given with or without the port, refers to the local machine.
+func IsLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	// the IPv6 hosts might also be bracketed without the port
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return host == "localhost" || net.ParseIP(host).IsLoopback()
+}
+
+// PeerHost returns the host part of the address of the peer, or the entire address if it has no port.
+func PeerHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
 // RegisterMixNodePresence registers server presence at the directory server.
 func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...string) error {
 	b64Key := base64.URLEncoding.EncodeToString(publicKey.Bytes())
-	values := map[string]interface{}{"pubKey": b64Key, "layer": layer}
+	values := map[string]interface{}{"pubKey": b64Key, "layer": layer, "sphinxParams": sphinx.Params()}
 	if len(host) == 1 {
 		values["host"] = host[0]
 	}
@@ -91,14 +162,8 @@ func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...str
 	}
 
 	endpoint := config.DirectoryServerMixPresenceURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixPresenceURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixPresenceURL
-		}
+	if len(host) == 1 && IsLoopbackHost(host[0]) {
+		endpoint = config.LocalDirectoryServerMixPresenceURL
 	}
 
 	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
@@ -118,23 +183,22 @@ func RegisterMixNodePresence(publicKey *sphinx.PublicKey, layer int, host ...str
 	return nil
 }
 
-// SendMixMetrics sends the mixnode related packet metrics to the directory server.
-func SendMixMetrics(metric models.MixMetric, host ...string) error {
-	values := map[string]interface{}{"sent": metric.Sent, "pubKey": metric.PubKey, "received": metric.Received}
+// SendMixMetrics sends the mixnode related packet metrics, together with the numbers of dropped packets
+// by the reason they were dropped for, to the directory server.
+func SendMixMetrics(metric models.MixMetric, dropped map[string]uint, host ...string) error {
+	values := map[string]interface{}{"sent": metric.Sent,
+		"pubKey":   metric.PubKey,
+		"received": metric.Received,
+		"dropped":  dropped,
+	}
 	jsonValue, err := json.Marshal(values)
 	if err != nil {
 		return err
 	}
 
 	endpoint := config.DirectoryServerMetricsURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMetricsURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMetricsURL
-		}
+	if len(host) == 1 && IsLoopbackHost(host[0]) {
+		endpoint = config.LocalDirectoryServerMetricsURL
 	}
 
 	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
@@ -154,36 +218,89 @@ func SendMixMetrics(metric models.MixMetric, host ...string) error {
 	return nil
 }
 
-// RegisterMixProviderPresence registers server presence at the directory server.
-func RegisterMixProviderPresence(publicKey *sphinx.PublicKey, clients []models.RegisteredClient, host ...string) error {
+// PresenceError describes a failed registration of presence at the directory server.
+type PresenceError struct {
+	// Transient is set if the registration might succeed when retried, i.e. the directory server
+	// could not be reached or failed on its side. Otherwise the presence itself was rejected.
+	Transient bool
+	Err       error
+}
+
+func (e *PresenceError) Error() string {
+	if e.Transient {
+		return fmt.Sprintf("transient presence registration failure: %v", e.Err)
+	}
+	return fmt.Sprintf("presence rejected: %v", e.Err)
+}
+
+// IsTransientPresenceError checks whether the given error is a PresenceError which is worth retrying.
+func IsTransientPresenceError(err error) bool {
+	presenceErr, ok := err.(*PresenceError)
+	return ok && presenceErr.Transient
+}
+
+// ValidateDirectoryURL checks whether the given URL can be used to reach the directory server.
+func ValidateDirectoryURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidDirectoryURL
+	}
+	return nil
+}
+
+// RegisterMixProviderPresence registers server presence at the directory server with the given URL.
+// If the URL is empty, the public directory server is used, unless the host is a loopback address,
+// in which case the local one is used instead.
+// Any failure is returned as a PresenceError classifying whether it is transient.
+func RegisterMixProviderPresence(directoryURL string,
+	publicKey *sphinx.PublicKey,
+	clients []models.RegisteredClient,
+	host ...string,
+) error {
+	if directoryURL != "" {
+		endpoint := strings.TrimSuffix(directoryURL, "/") + config.MixProviderPresencePath
+		return registerMixProviderPresence(endpoint, publicKey, clients, host...)
+	}
+
+	endpoint := config.DirectoryServerMixProviderPresenceURL
+	if len(host) == 1 && IsLoopbackHost(host[0]) {
+		endpoint = config.LocalDirectoryServerMixProviderPresenceURL
+	}
+	return registerMixProviderPresence(endpoint, publicKey, clients, host...)
+}
+
+func registerMixProviderPresence(endpoint string,
+	publicKey *sphinx.PublicKey,
+	clients []models.RegisteredClient,
+	host ...string,
+) error {
 	b64Key := base64.URLEncoding.EncodeToString(publicKey.Bytes())
-	values := map[string]interface{}{"pubKey": b64Key, "registeredClients": clients}
+	values := map[string]interface{}{"pubKey": b64Key,
+		"registeredClients": clients,
+		"sphinxParams":      sphinx.Params(),
+	}
 	if len(host) == 1 {
 		values["host"] = host[0]
 	}
 	jsonValue, err := json.Marshal(values)
 	if err != nil {
-		return err
-	}
-
-	endpoint := config.DirectoryServerMixProviderPresenceURL
-	if len(host) == 1 && len(host[0]) > 0 {
-		ip, _, err := net.SplitHostPort(host[0])
-		if err == nil && (ip == "localhost" || net.ParseIP(ip).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixProviderPresenceURL
-		} else if err != nil && err.Error() == "missing port in address" &&
-			(host[0] == "localhost" || net.ParseIP(host[0]).IsLoopback()) {
-			endpoint = config.LocalDirectoryServerMixProviderPresenceURL
-		}
+		return &PresenceError{Transient: false, Err: err}
 	}
 
 	resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(jsonValue))
 	if err != nil {
-		return err
+		return &PresenceError{Transient: true, Err: err}
 	}
 	defer resp.Body.Close()
-	_ = resp
-	// TODO: properly parse it, etc.
 
-	return nil
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests:
+		return &PresenceError{Transient: true, Err: fmt.Errorf("directory server responded with %v", resp.Status)}
+	default:
+		return &PresenceError{Transient: false, Err: fmt.Errorf("directory server responded with %v", resp.Status)}
+	}
 }
@@ -0,0 +1,207 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/clock"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMixProviderPresence_ErrorClassification(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := []models.RegisteredClient{{PubKey: "foomp"}}
+
+	tests := []struct {
+		status    int
+		expectErr bool
+		transient bool
+	}{
+		{http.StatusCreated, false, false},
+		{http.StatusOK, false, false},
+		{http.StatusBadRequest, true, false},
+		{http.StatusForbidden, true, false},
+		{http.StatusRequestTimeout, true, true},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusInternalServerError, true, true},
+		{http.StatusServiceUnavailable, true, true},
+	}
+	for _, test := range tests {
+		directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		err := registerMixProviderPresence(directory.URL, pub, clients, "localhost:1789")
+		directory.Close()
+
+		if !test.expectErr {
+			assert.Nil(t, err, "Status %v should have been accepted", test.status)
+			continue
+		}
+		if assert.IsType(t, &PresenceError{}, err) {
+			assert.Equal(t, test.transient, IsTransientPresenceError(err), "Wrong classification of status %v", test.status)
+		}
+	}
+
+	// the directory server can't be reached at all
+	directory := httptest.NewServer(http.NotFoundHandler())
+	directory.Close()
+	err = registerMixProviderPresence(directory.URL, pub, clients)
+	assert.IsType(t, &PresenceError{}, err)
+	assert.True(t, IsTransientPresenceError(err))
+
+	assert.False(t, IsTransientPresenceError(errors.New("foomp")))
+}
+
+func TestRegisterMixProviderPresence_ConfiguredURL(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []*http.Request
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer directory.Close()
+
+	// the trailing slash is not doubled
+	for _, directoryURL := range []string{directory.URL, directory.URL + "/"} {
+		assert.Nil(t, RegisterMixProviderPresence(directoryURL, pub, nil, "1.2.3.4:1789"))
+	}
+	if assert.Len(t, requests, 2) {
+		for _, r := range requests {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, config.MixProviderPresencePath, r.URL.Path)
+		}
+	}
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	for _, loopback := range []string{"localhost", "localhost:8080", "127.0.0.1", "127.0.0.1:1", "::1", "[::1]", "[::1]:80"} {
+		assert.True(t, IsLoopbackHost(loopback), "host %q should be considered loopback", loopback)
+	}
+	for _, remote := range []string{"", "1.2.3.4", "1.2.3.4:80", "2001:db8::1", "[2001:db8::1]:80", "example.com"} {
+		assert.False(t, IsLoopbackHost(remote), "host %q should not be considered loopback", remote)
+	}
+}
+
+func TestPeerHost(t *testing.T) {
+	assert.Equal(t, "1.2.3.4", PeerHost(&net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}))
+	assert.Equal(t, "::1", PeerHost(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}))
+	// the addresses without the port, such as those of the in-memory connections, are kept as they are
+	assert.Equal(t, "pipe", PeerHost(&net.UnixAddr{Name: "pipe", Net: "unix"}))
+	assert.Equal(t, "", PeerHost(nil))
+}
+
+func TestResolveHost(t *testing.T) {
+	detected := func() (string, error) { return "10.0.0.5", nil }
+	failing := func() (string, error) { return "", ErrInvalidLocalIP }
+
+	// the explicit host takes precedence over the detected one
+	host, err := ResolveHost("203.0.113.7", detected)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7", host)
+	// and is used even if the local address can't be detected
+	host, err = ResolveHost("203.0.113.7", failing)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7", host)
+
+	host, err = ResolveHost("", detected)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.5", host)
+	_, err = ResolveHost("", failing)
+	assert.Equal(t, ErrInvalidLocalIP, err)
+}
+
+func TestResolveTCPAddress_IPv6(t *testing.T) {
+	addr, err := ResolveTCPAddress("::1", "1789")
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:1789", addr.String())
+}
+
+func TestValidateDirectoryURL(t *testing.T) {
+	for _, valid := range []string{"http://localhost:8080", "https://directory.nymtech.net", "http://1.2.3.4/"} {
+		assert.Nil(t, ValidateDirectoryURL(valid), "URL %q should have been accepted", valid)
+	}
+	for _, invalid := range []string{"", "localhost:8080", "ftp://localhost", "http://", "http://%zz", "foomp"} {
+		assert.Equal(t, ErrInvalidDirectoryURL, ValidateDirectoryURL(invalid), "URL %q should have been rejected", invalid)
+	}
+}
+
+func TestListenWithRetry_AddressFreedLater(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := occupied.Addr().String()
+
+	_, err = net.Listen("tcp", address)
+	assert.True(t, IsTransientListenError(err))
+
+	// the address is freed while the first retry is waited for
+	clk := clock.NewMock(time.Now())
+	go func() {
+		clk.BlockUntil(1)
+		occupied.Close()
+		clk.Advance(20 * time.Millisecond)
+	}()
+
+	listener, err := ListenWithRetry(clk, address, 10, 20*time.Millisecond)
+	if assert.Nil(t, err) {
+		assert.Equal(t, address, listener.Addr().String())
+		listener.Close()
+	}
+}
+
+func TestListenWithRetry_GivesUp(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+
+	_, err = ListenWithRetry(clock.New(), occupied.Addr().String(), 3, time.Millisecond)
+	assert.True(t, IsTransientListenError(err))
+}
+
+func TestListenWithRetry_PermanentErrors(t *testing.T) {
+	// a long backoff would make the test time out if the failures were retried
+	const backoff = time.Hour
+
+	_, err := ListenWithRetry(clock.New(), "127.0.0.1:99999", 5, backoff)
+	assert.NotNil(t, err)
+	assert.False(t, IsTransientListenError(err))
+
+	if os.Geteuid() == 0 {
+		t.Skip("binding to privileged ports is allowed as root")
+	}
+	_, err = ListenWithRetry(clock.New(), "127.0.0.1:1", 5, backoff)
+	assert.NotNil(t, err)
+	assert.False(t, IsTransientListenError(err))
+}
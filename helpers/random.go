@@ -0,0 +1,100 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// nolint: gochecknoglobals
+var defaultRand = NewRand()
+
+// Rand is a source of randomness safe for concurrent use, from which the mixes on the paths and the delays
+// are drawn. It is injectable, so that the tests could replace the default crypto-grade source
+// with a deterministic one and reproduce their failures.
+type Rand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRand returns the crypto-grade source of randomness, backed by crypto/rand.
+func NewRand() *Rand {
+	return &Rand{r: rand.New(cryptoSource{})}
+}
+
+// NewSeededRand returns the deterministic source of randomness with the given seed, which always yields
+// the same sequence of values. It is only meant for the tests and must never be used otherwise,
+// as anyone knowing the seed could predict the paths and the delays.
+func NewSeededRand(seed int64) *Rand {
+	return &Rand{r: rand.New(rand.NewSource(seed))}
+}
+
+// Intn returns a random int in [0, n). It panics if n <= 0.
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// Float64 returns a random float64 in [0.0, 1.0).
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// ExpFloat64 returns a random float64 drawn from the exponential distribution with the rate parameter of 1.
+func (r *Rand) ExpFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.ExpFloat64()
+}
+
+// Mix returns a single randomly chosen mix from the given ones, which must not be empty.
+func (r *Rand) Mix(mixes []config.MixConfig) config.MixConfig {
+	return mixes[r.Intn(len(mixes))]
+}
+
+// Exponential returns a value drawn from the exponential distribution with the given rate parameter.
+// It returns ErrExponentialDistributionParam if the parameter is non-positive.
+func (r *Rand) Exponential(expParam float64) (float64, error) {
+	if expParam <= 0.0 {
+		return 0.0, ErrExponentialDistributionParam
+	}
+	return r.ExpFloat64() / expParam, nil
+}
+
+// cryptoSource is a math/rand source reading each value from crypto/rand, hence it can't be seeded.
+type cryptoSource struct{}
+
+func (s cryptoSource) Int63() int64 {
+	return int64(s.Uint64() &^ (1 << 63))
+}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		// there is no sensible way to carry on without the randomness
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (cryptoSource) Seed(int64) {}
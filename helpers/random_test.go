@@ -0,0 +1,72 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func drawValues(r *Rand) []float64 {
+	values := make([]float64, 0, 30)
+	for i := 0; i < 10; i++ {
+		values = append(values, float64(r.Intn(1000)), r.Float64(), r.ExpFloat64())
+	}
+	return values
+}
+
+func TestNewSeededRand(t *testing.T) {
+	assert.Equal(t, drawValues(NewSeededRand(42)), drawValues(NewSeededRand(42)))
+	assert.NotEqual(t, drawValues(NewSeededRand(42)), drawValues(NewSeededRand(1789)))
+
+	dist := UniformDelay{Min: 1.0, Max: 2.0}
+	delays, err := DelaySequence(NewSeededRand(42), dist, 5)
+	assert.Nil(t, err)
+	repeated, err := DelaySequence(NewSeededRand(42), dist, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, delays, repeated)
+}
+
+func TestNewRand(t *testing.T) {
+	// the crypto-grade source can't be seeded, hence two of them should never agree
+	assert.NotEqual(t, drawValues(NewRand()), drawValues(NewRand()))
+	for i := 0; i < 1000; i++ {
+		v := NewRand().Float64()
+		assert.True(t, v >= 0 && v < 1)
+	}
+}
+
+func TestRand_Mix(t *testing.T) {
+	mixes := []config.MixConfig{{Id: "Mix1"}, {Id: "Mix2"}, {Id: "Mix3"}}
+	r := NewRand()
+	chosen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		chosen[r.Mix(mixes).Id] = true
+	}
+	assert.Len(t, chosen, len(mixes))
+}
+
+func TestRand_Exponential(t *testing.T) {
+	r := NewSeededRand(42)
+	for _, param := range []float64{0.0, -1.0} {
+		_, err := r.Exponential(param)
+		assert.Equal(t, ErrExponentialDistributionParam, err)
+	}
+	v, err := r.Exponential(5.0)
+	assert.Nil(t, err)
+	assert.True(t, v >= 0)
+}
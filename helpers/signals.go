@@ -0,0 +1,55 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package helpers
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleShutdownSignals calls shutdown once the process receives SIGINT or SIGTERM. If another signal
+// is received before shutdown returns, forceExit is called straight away instead of waiting for it.
+// It returns a function, which stops the handling of the signals.
+func HandleShutdownSignals(shutdown func(), forceExit func()) (stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go handleShutdownSignals(signals, done, shutdown, forceExit)
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func handleShutdownSignals(signals <-chan os.Signal, done <-chan struct{}, shutdown func(), forceExit func()) {
+	select {
+	case <-signals:
+	case <-done:
+		return
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		shutdown()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-signals:
+		forceExit()
+	case <-done:
+	}
+}
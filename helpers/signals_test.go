@@ -0,0 +1,67 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package helpers
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleShutdownSignals(t *testing.T) {
+	shutdownCh := make(chan struct{})
+	stop := HandleShutdownSignals(func() { close(shutdownCh) }, func() { t.Error("exit should not have been forced") })
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-shutdownCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown was not called on the signal")
+	}
+}
+
+func TestHandleShutdownSignals_ForcedExit(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	defer close(done)
+
+	shutdownCh := make(chan struct{})
+	blockShutdown := make(chan struct{})
+	defer close(blockShutdown)
+	exitCh := make(chan struct{})
+	go handleShutdownSignals(signals, done, func() {
+		close(shutdownCh)
+		<-blockShutdown
+	}, func() { close(exitCh) })
+
+	signals <- syscall.SIGINT
+	<-shutdownCh
+	select {
+	case <-exitCh:
+		t.Fatal("exit was forced before the second signal")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the shutdown is stuck, so the second signal does not wait for it
+	signals <- syscall.SIGINT
+	select {
+	case <-exitCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit was not forced on the second signal")
+	}
+}
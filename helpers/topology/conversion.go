@@ -0,0 +1,119 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+var (
+	// ErrInvalidMixPresence defines an error when the mix presence has invalid public key or host
+	ErrInvalidMixPresence = errors.New("invalid mix presence")
+	// ErrInvalidProviderPresence defines an error when the provider presence has invalid public key or host
+	ErrInvalidProviderPresence = errors.New("invalid provider presence")
+	// ErrInvalidClientInformation defines an error when the registered client has invalid public key
+	ErrInvalidClientInformation = errors.New("invalid client information")
+)
+
+// MixConfigFromModel converts the presence of a mix node, as returned by the directory server,
+// into its config. The base64 encoded public key is used as the id of the mix.
+func MixConfigFromModel(presence models.MixNodePresence) (config.MixConfig, error) {
+	b, err := base64.URLEncoding.DecodeString(presence.PubKey)
+	if err != nil {
+		return config.MixConfig{}, ErrInvalidMixPresence
+	}
+	host, port, err := net.SplitHostPort(presence.Host)
+	if err != nil {
+		return config.MixConfig{}, ErrInvalidMixPresence
+	}
+
+	mix, err := config.NewValidatedMixConfig(presence.PubKey, host, port, b, presence.Layer)
+	if err != nil {
+		return config.MixConfig{}, ErrInvalidMixPresence
+	}
+	return mix, nil
+}
+
+// MixConfigToModel converts the config of a mix node into the host information
+// it announces to the directory server.
+func MixConfigToModel(mix config.MixConfig) models.MixHostInfo {
+	return models.MixHostInfo{
+		HostInfo: models.HostInfo{
+			Host:   net.JoinHostPort(mix.Host, mix.Port),
+			PubKey: base64.URLEncoding.EncodeToString(mix.PubKey),
+		},
+		Layer: uint(mix.Layer),
+	}
+}
+
+// ProviderConfigFromModel converts the presence of a provider, as returned by the directory server,
+// into its config. The announced host is used as the id of the provider.
+func ProviderConfigFromModel(presence models.MixProviderPresence) (config.MixConfig, error) {
+	b, err := base64.URLEncoding.DecodeString(presence.PubKey)
+	if err != nil {
+		return config.MixConfig{}, ErrInvalidProviderPresence
+	}
+	host, port, err := net.SplitHostPort(presence.Host) // TODO: do we want to split them?
+	if err != nil {
+		return config.MixConfig{}, err
+	}
+
+	provider, err := config.NewValidatedMixConfig(presence.Host, host, port, b, config.ProviderLayer)
+	if err != nil {
+		return config.MixConfig{}, ErrInvalidProviderPresence
+	}
+	return provider, nil
+}
+
+// ProviderConfigToModel converts the config of a provider, together with its registered clients,
+// into the host information it announces to the directory server.
+func ProviderConfigToModel(provider config.MixConfig, clients []models.RegisteredClient) models.MixProviderHostInfo {
+	return models.MixProviderHostInfo{
+		HostInfo: models.HostInfo{
+			Host:   net.JoinHostPort(provider.Host, provider.Port),
+			PubKey: base64.URLEncoding.EncodeToString(provider.PubKey),
+		},
+		RegisteredClients: clients,
+	}
+}
+
+// ClientConfigFromModel converts the client registered at a provider, as returned by the directory server,
+// into its config. As the directory server does not know the addresses of the clients, the default ones are used.
+func ClientConfigFromModel(client models.RegisteredClient) (config.ClientConfig, error) {
+	b, err := base64.URLEncoding.DecodeString(client.PubKey)
+	if err != nil {
+		return config.ClientConfig{}, ErrInvalidClientInformation
+	}
+
+	return config.ClientConfig{
+		Id:     client.PubKey,
+		Host:   DefaultClientHost,
+		Port:   DefaultClientPort,
+		PubKey: b,
+	}, nil
+}
+
+// ClientConfigToModel converts the config of a client into the form in which
+// its provider announces it to the directory server.
+func ClientConfigToModel(client config.ClientConfig) models.RegisteredClient {
+	return models.RegisteredClient{
+		PubKey: base64.URLEncoding.EncodeToString(client.PubKey),
+	}
+}
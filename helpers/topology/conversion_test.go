@@ -0,0 +1,139 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topology
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func generatePubKey(t *testing.T) []byte {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub.Bytes()
+}
+
+func TestMixConfig_RoundTrip(t *testing.T) {
+	pubKey := generatePubKey(t)
+	mix := config.NewMixConfig(base64.URLEncoding.EncodeToString(pubKey), "1.2.3.4", "1789", pubKey, 2)
+
+	model := MixConfigToModel(mix)
+	assert.Equal(t, "1.2.3.4:1789", model.Host)
+	assert.Equal(t, uint(2), model.Layer)
+
+	converted, err := MixConfigFromModel(models.MixNodePresence{MixHostInfo: model})
+	assert.Nil(t, err)
+	assert.True(t, proto.Equal(&mix, &converted), "expected %v, got %v", mix, converted)
+}
+
+func TestProviderConfig_RoundTrip(t *testing.T) {
+	pubKey := generatePubKey(t)
+	provider := config.NewMixConfig("1.2.3.4:1789", "1.2.3.4", "1789", pubKey, config.ProviderLayer)
+	clients := []models.RegisteredClient{{PubKey: "foo"}, {PubKey: "bar"}}
+
+	model := ProviderConfigToModel(provider, clients)
+	assert.Equal(t, clients, model.RegisteredClients)
+
+	converted, err := ProviderConfigFromModel(models.MixProviderPresence{MixProviderHostInfo: model})
+	assert.Nil(t, err)
+	assert.True(t, proto.Equal(&provider, &converted), "expected %v, got %v", provider, converted)
+}
+
+func TestClientConfig_RoundTrip(t *testing.T) {
+	pubKey := generatePubKey(t)
+	client := config.ClientConfig{Id: base64.URLEncoding.EncodeToString(pubKey),
+		Host:   DefaultClientHost,
+		Port:   DefaultClientPort,
+		PubKey: pubKey,
+	}
+
+	converted, err := ClientConfigFromModel(ClientConfigToModel(client))
+	assert.Nil(t, err)
+	assert.True(t, proto.Equal(&client, &converted), "expected %v, got %v", client, converted)
+}
+
+func TestConversion_InvalidModels(t *testing.T) {
+	validKey := base64.URLEncoding.EncodeToString(generatePubKey(t))
+
+	_, err := MixConfigFromModel(models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: "not base64!"},
+	}})
+	assert.Equal(t, ErrInvalidMixPresence, err)
+	_, err = MixConfigFromModel(models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4", PubKey: validKey},
+	}})
+	assert.Equal(t, ErrInvalidMixPresence, err)
+	shortKey := base64.URLEncoding.EncodeToString([]byte("foomp"))
+	_, err = MixConfigFromModel(models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: shortKey},
+	}})
+	assert.Equal(t, ErrInvalidMixPresence, err)
+
+	_, err = ProviderConfigFromModel(models.MixProviderPresence{MixProviderHostInfo: models.MixProviderHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: shortKey},
+	}})
+	assert.Equal(t, ErrInvalidProviderPresence, err)
+	_, err = ProviderConfigFromModel(models.MixProviderPresence{MixProviderHostInfo: models.MixProviderHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: "not base64!"},
+	}})
+	assert.Equal(t, ErrInvalidProviderPresence, err)
+
+	_, err = ClientConfigFromModel(models.RegisteredClient{PubKey: "not base64!"})
+	assert.Equal(t, ErrInvalidClientInformation, err)
+}
+
+func TestGetMixesPKI(t *testing.T) {
+	presences := MixPresence{}
+	for _, layer := range []uint{1, 1, 2} {
+		mix := config.NewMixConfig("", "1.2.3.4", "1789", generatePubKey(t), layer)
+		presences = append(presences, models.MixNodePresence{MixHostInfo: MixConfigToModel(mix)})
+	}
+	// invalid entries are skipped
+	presences = append(presences, models.MixNodePresence{MixHostInfo: models.MixHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: "not base64!"},
+		Layer:    1,
+	}})
+
+	mixes, err := GetMixesPKI(presences)
+	assert.Nil(t, err)
+	assert.Len(t, mixes[1], 2)
+	assert.Len(t, mixes[2], 1)
+	assert.Equal(t, presences[2].PubKey, mixes[2][0].Id)
+}
+
+func TestGetProvidersPKI(t *testing.T) {
+	presences := ProviderPresence{}
+	for _, host := range []string{"1.2.3.4", "5.6.7.8"} {
+		provider := config.NewMixConfig("", host, "1789", generatePubKey(t), config.ProviderLayer)
+		presences = append(presences, models.MixProviderPresence{MixProviderHostInfo: ProviderConfigToModel(provider, nil)})
+	}
+	// invalid entries are skipped
+	presences = append(presences, models.MixProviderPresence{MixProviderHostInfo: models.MixProviderHostInfo{
+		HostInfo: models.HostInfo{Host: "1.2.3.4:1789", PubKey: "not base64!"},
+	}})
+
+	providers, err := GetProvidersPKI(presences)
+	assert.Nil(t, err)
+	assert.Len(t, providers, 2)
+	assert.Equal(t, "5.6.7.8:1789", providers[1].Id)
+}
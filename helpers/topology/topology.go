@@ -20,11 +20,8 @@
 package topology
 
 import (
-	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"io/ioutil"
-	"net"
 	"net/http"
 
 	"github.com/nymtech/nym-directory/models"
@@ -67,57 +64,27 @@ func GetNetworkTopology(endpoint string) (*models.Topology, error) {
 // GetMixesPKI returns PKI data for mix nodes, grouped by layer
 func GetMixesPKI(mixPresence MixPresence) (LayeredMixes, error) {
 	mixes := make(LayeredMixes)
-	for k, v := range mixPresence {
-		b, err := base64.URLEncoding.DecodeString(v.PubKey)
+	for _, v := range mixPresence {
+		newMixEntry, err := MixConfigFromModel(v)
 		if err != nil {
 			continue
 		}
-		host, port, err := net.SplitHostPort(v.Host) // TODO: do we want to split them?
-		if err != nil {
-			continue
-		}
-		newMixEntry := config.MixConfig{
-			Id:     mixPresence[k].PubKey,
-			Host:   host,
-			Port:   port,
-			PubKey: b,
-			Layer:  uint64(v.Layer),
-		}
-		if layerMixes, ok := mixes[v.Layer]; ok {
-			extendedLayer := append(layerMixes, newMixEntry)
-			mixes[v.Layer] = extendedLayer
-		} else {
-			mixes[v.Layer] = []config.MixConfig{newMixEntry}
-		}
+		mixes[v.Layer] = append(mixes[v.Layer], newMixEntry)
 	}
 	return mixes, nil
 }
 
-func ProviderPresenceToConfig(presence models.MixProviderPresence) (config.MixConfig, error) {
-	b, err := base64.URLEncoding.DecodeString(presence.PubKey)
-	if err != nil {
-		return config.MixConfig{}, errors.New("invalid provider presence")
-	}
-	host, port, err := net.SplitHostPort(presence.Host) // TODO: do we want to split them?
-	if err != nil {
-		return config.MixConfig{}, err
-	}
-
-	return config.NewMixConfig(presence.Host, host, port, b, config.ProviderLayer), nil
-}
-
-func RegisteredClientToConfig(client models.RegisteredClient) (config.ClientConfig, error) {
-	b, err := base64.URLEncoding.DecodeString(client.PubKey)
-	if err != nil {
-		return config.ClientConfig{}, errors.New("invalid client information")
+// GetProvidersPKI returns PKI data for providers
+func GetProvidersPKI(providerPresence ProviderPresence) ([]config.MixConfig, error) {
+	providers := make([]config.MixConfig, 0, len(providerPresence))
+	for _, v := range providerPresence {
+		newProviderEntry, err := ProviderConfigFromModel(v)
+		if err != nil {
+			continue
+		}
+		providers = append(providers, newProviderEntry)
 	}
-
-	return config.ClientConfig{
-		Id:     client.PubKey,
-		Host:   DefaultClientHost,
-		Port:   DefaultClientPort,
-		PubKey: b,
-	}, nil
+	return providers, nil
 }
 
 // GetClientPKI returns a map of the current client PKI from the PKI database
@@ -129,12 +96,12 @@ func GetClientPKI(providerPresence ProviderPresence) ([]config.ClientConfig, err
 
 	clients := make([]config.ClientConfig, 0, clientsNum)
 	for _, provider := range providerPresence {
-		providerCfg, err := ProviderPresenceToConfig(provider)
+		providerCfg, err := ProviderConfigFromModel(provider)
 		if err != nil {
 			continue
 		}
 		for _, client := range provider.RegisteredClients {
-			clientCfg, err := RegisteredClientToConfig(client)
+			clientCfg, err := ClientConfigFromModel(client)
 			if err != nil {
 				continue
 			}
@@ -0,0 +1,127 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"sync"
+
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// DropReason classifies why a received packet was dropped.
+type DropReason string
+
+const (
+	// DropMalformed means the packet could not be parsed.
+	DropMalformed DropReason = "malformed"
+	// DropInvalidMAC means the MAC of the sphinx header did not match, e.g. because the packet was tampered with.
+	DropInvalidMAC DropReason = "invalid_mac"
+	// DropInvalidPayload means the payload did not match the header of the packet, e.g. because it was swapped.
+	DropInvalidPayload DropReason = "invalid_payload"
+	// DropUnsupportedSuite means the packet used a crypto suite the node does not support.
+	DropUnsupportedSuite DropReason = "unsupported_suite"
+	// DropUnknownFlag means either the packet type or the sphinx flag was not one the node can handle.
+	DropUnknownFlag DropReason = "unknown_flag"
+	// DropExpired means the packet was processed after the expiry set by its sender.
+	DropExpired DropReason = "expired"
+	// DropProcessingError means the sphinx processing of the packet failed for any other reason.
+	DropProcessingError DropReason = "processing_error"
+	// DropForwardError means the packet could not be forwarded to the next hop.
+	DropForwardError DropReason = "forward_error"
+	// DropBadNextHop means the address of the next hop was malformed or could not be resolved.
+	DropBadNextHop DropReason = "bad_next_hop"
+	// DropStoreError means the packet could not be stored in the inbox of its recipient.
+	DropStoreError DropReason = "store_error"
+	// DropUnknownRecipient means the recipient of the packet did not have an inbox.
+	DropUnknownRecipient DropReason = "unknown_recipient"
+	// DropReplayed means the packet had already been processed by the node before.
+	DropReplayed DropReason = "replayed"
+	// DropRateLimited means the packet was shed as the node exceeded its maximum processing rate.
+	DropRateLimited DropReason = "rate_limited"
+	// DropDelayQueueFull means the packet was shed as the node already held the maximum number of delayed packets.
+	DropDelayQueueFull DropReason = "delay_queue_full"
+	// DropSourceDelayQueueFull means the packet was shed as the node already held the maximum number
+	// of delayed packets received from the same source.
+	DropSourceDelayQueueFull DropReason = "source_delay_queue_full"
+)
+
+// ProcessingDropReason classifies the error returned by ProcessPacket.
+func ProcessingDropReason(err error) DropReason {
+	switch err {
+	case sphinx.ErrMalformedPacket, sphinx.ErrNegativeDelay, sphinx.ErrInvalidPayloadLength:
+		return DropMalformed
+	case sphinx.ErrInvalidMAC:
+		return DropInvalidMAC
+	case sphinx.ErrInvalidPayload:
+		return DropInvalidPayload
+	case sphinx.ErrUnsupportedSuite:
+		return DropUnsupportedSuite
+	case sphinx.ErrPacketExpired:
+		return DropExpired
+	case ErrRateLimited:
+		return DropRateLimited
+	case ErrDelayQueueFull:
+		return DropDelayQueueFull
+	case ErrSourceDelayQueueFull:
+		return DropSourceDelayQueueFull
+	case ErrReplayedPacket:
+		return DropReplayed
+	default:
+		return DropProcessingError
+	}
+}
+
+// ForwardDropReason classifies the error of forwarding a packet to its next hop.
+func ForwardDropReason(err error) DropReason {
+	if IsNextHopError(err) {
+		return DropBadNextHop
+	}
+	return DropForwardError
+}
+
+// DropCounter counts the dropped packets by the reason they were dropped for.
+// It is safe for concurrent use and its zero value is ready to use.
+type DropCounter struct {
+	sync.Mutex
+	counts map[DropReason]uint
+}
+
+// Record increments the counter of the given reason.
+func (d *DropCounter) Record(reason DropReason) {
+	d.Lock()
+	defer d.Unlock()
+	if d.counts == nil {
+		d.counts = make(map[DropReason]uint)
+	}
+	d.counts[reason]++
+}
+
+// Count returns the number of packets dropped for the given reason.
+func (d *DropCounter) Count(reason DropReason) uint {
+	d.Lock()
+	defer d.Unlock()
+	return d.counts[reason]
+}
+
+// Snapshot returns a copy of all the counters.
+func (d *DropCounter) Snapshot() map[DropReason]uint {
+	d.Lock()
+	defer d.Unlock()
+	snapshot := make(map[DropReason]uint, len(d.counts))
+	for reason, count := range d.counts {
+		snapshot[reason] = count
+	}
+	return snapshot
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessingDropReason(t *testing.T) {
+	assert.Equal(t, DropMalformed, ProcessingDropReason(sphinx.ErrMalformedPacket))
+	assert.Equal(t, DropMalformed, ProcessingDropReason(sphinx.ErrInvalidPayloadLength))
+	assert.Equal(t, DropMalformed, ProcessingDropReason(sphinx.ErrNegativeDelay))
+	assert.Equal(t, DropInvalidMAC, ProcessingDropReason(sphinx.ErrInvalidMAC))
+	assert.Equal(t, DropUnsupportedSuite, ProcessingDropReason(sphinx.ErrUnsupportedSuite))
+	assert.Equal(t, DropInvalidPayload, ProcessingDropReason(sphinx.ErrInvalidPayload))
+	assert.Equal(t, DropExpired, ProcessingDropReason(sphinx.ErrPacketExpired))
+	assert.Equal(t, DropReplayed, ProcessingDropReason(ErrReplayedPacket))
+	assert.Equal(t, DropProcessingError, ProcessingDropReason(errors.New("foomp")))
+}
+
+func TestDropCounter(t *testing.T) {
+	var counter DropCounter
+	assert.Equal(t, uint(0), counter.Count(DropExpired))
+	assert.Empty(t, counter.Snapshot())
+
+	const numGoroutines = 10
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.Record(DropExpired)
+		}()
+	}
+	wg.Wait()
+	counter.Record(DropInvalidMAC)
+
+	assert.Equal(t, uint(numGoroutines), counter.Count(DropExpired))
+	snapshot := counter.Snapshot()
+	assert.Equal(t, map[DropReason]uint{DropExpired: numGoroutines, DropInvalidMAC: 1}, snapshot)
+
+	// the snapshot is a copy
+	snapshot[DropExpired] = 0
+	assert.Equal(t, uint(numGoroutines), counter.Count(DropExpired))
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/clock"
+)
+
+// ErrRateLimited is returned when a packet is shed because the node exceeded its maximum processing rate.
+var ErrRateLimited = errors.New("maximum packet processing rate exceeded")
+
+// rateLimiter is a token bucket, which allows up to burst packets at once
+// and refills at the given rate of packets per second.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  clock.Clock
+}
+
+func newRateLimiter(rate float64, burst int, clk clock.Clock) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clk.Now(),
+		clock:  clk,
+	}
+}
+
+// allow takes a token from the bucket if there is any available.
+func (l *rateLimiter) allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	now := l.clock.Now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	limiter := newRateLimiter(10, 3, clk)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow(), "The burst should have been allowed")
+	}
+	assert.False(t, limiter.allow(), "Packets above the burst should have been shed")
+
+	// a single token is refilled after 1/rate seconds
+	clk.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.allow())
+	assert.False(t, limiter.allow())
+
+	// the refill never exceeds the burst
+	clk.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow())
+	}
+	assert.False(t, limiter.allow())
+}
+
+func TestMixProcessPacket_RateLimited(t *testing.T) {
+	mix, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the limit is low enough for no tokens to be refilled while the test runs
+	const burst = 5
+	mix.SetMaxProcessingRate(0.001, burst)
+
+	const numPackets = 20
+	shed := 0
+	for i := 0; i < numPackets; i++ {
+		if _, err := mix.ProcessPacket([]byte("foomp")); err == ErrRateLimited {
+			shed++
+		}
+	}
+	assert.Equal(t, numPackets-burst, shed)
+	assert.Equal(t, DropRateLimited, ProcessingDropReason(ErrRateLimited))
+
+	// without the limit nothing is shed
+	mix.SetMaxProcessingRate(0, 0)
+	_, err = mix.ProcessPacket([]byte("foomp"))
+	assert.NotEqual(t, ErrRateLimited, err)
+}
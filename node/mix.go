@@ -17,24 +17,92 @@
 package node
 
 import (
+	"math"
+	"sync"
 	"time"
 
+	"github.com/nymtech/nym-mixnet/clock"
 	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/sphinx"
 )
 
+// DefaultClockSkewTolerance defines the default period after the expiry of a packet during which it is still processed,
+// to account for the clocks of the sender and the mix not being perfectly synchronised.
+const DefaultClockSkewTolerance = 30 * time.Second
+
 type Mix struct {
-	pubKey *sphinx.PublicKey
-	prvKey *sphinx.PrivateKey
+	pubKey             *sphinx.PublicKey
+	prvKey             *sphinx.PrivateKey
+	maxDelay           float64
+	clockSkewTolerance time.Duration
+	clock              clock.Clock
+	// limiter is shared by all the connections of the node. If nil, the processing rate is unlimited.
+	// It is guarded by limiterMu, so that it could be replaced while the node is running.
+	limiter   *rateLimiter
+	limiterMu sync.RWMutex
+
+	replayTagLength int
+	replays         *replayCache
+	// replayCheckDisabled turns off the replay protection. It is only ever meant to be set by the benchmarks.
+	replayCheckDisabled bool
+	// codec is the wire format of the received and forwarded packets.
+	codec sphinx.Codec
+	// scheduler holds the packets processed with ScheduleProcessing until their delays elapse.
+	scheduler *DelayScheduler
+	// maxPendingPerSource is the number of delayed packets held for a single source. If 0, it is unlimited.
+	maxPendingPerSource int
+	// dialTimeout and writeTimeout bound forwarding the packets to their next hops.
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+// PacketKind classifies what the node should do with a successfully processed packet.
+type PacketKind int
+
+const (
+	// DropPacket means the packet should not be handled any further, as its commands were not recognised.
+	DropPacket PacketKind = iota
+	// RelayPacket means the packet should be forwarded to the next hop.
+	RelayPacket
+	// StorePacket means the packet has reached its last hop and should be stored for the recipient.
+	StorePacket
+)
+
+func (k PacketKind) String() string {
+	switch k {
+	case RelayPacket:
+		return "relay"
+	case StorePacket:
+		return "store"
+	default:
+		return "drop"
+	}
+}
+
+// PacketKindFromFlag derives the classification of the packet from the sphinx flag of its decoded commands.
+func PacketKindFromFlag(flag flags.SphinxFlag) PacketKind {
+	switch flag {
+	case flags.RelayFlag:
+		return RelayPacket
+	case flags.LastHopFlag:
+		return StorePacket
+	default:
+		return DropPacket
+	}
 }
 
+// PacketProcessingResult holds the outcome of successfully processing a packet.
+// The processing errors are returned separately by ProcessPacket and ScheduleProcessing.
 type PacketProcessingResult struct {
 	packetData []byte
 	nextHop    sphinx.Hop
 	flag       flags.SphinxFlag
-	err        error
+	kind       PacketKind
+	auxData    []byte
 }
 
+// PacketData returns the packet to forward to the next hop or, if the packet has reached its last hop,
+// the message to store for the recipient.
 func (p *PacketProcessingResult) PacketData() []byte {
 	return p.packetData
 }
@@ -47,27 +115,246 @@ func (p *PacketProcessingResult) Flag() flags.SphinxFlag {
 	return p.flag
 }
 
-func (p *PacketProcessingResult) Err() error {
-	return p.err
+// AuxData returns the auxiliary data the sender of the packet passed to this node, if any.
+func (p *PacketProcessingResult) AuxData() []byte {
+	return p.auxData
 }
 
-// ProcessPacket performs the processing operation on the received packet, including cryptographic operations and
-// extraction of the meta information.
-func (m *Mix) ProcessPacket(packet []byte) *PacketProcessingResult {
-	res := new(PacketProcessingResult)
+// Kind returns the classification of the packet, which determines how it should be handled.
+func (p *PacketProcessingResult) Kind() PacketKind {
+	return p.kind
+}
 
-	nextHop, commands, newPacket, err := sphinx.ProcessSphinxPacket(packet, m.prvKey)
-	res.err = err
+// ProcessPacket performs the processing operation on the received packet, including cryptographic operations and
+// extraction of the meta information. It blocks for the delay requested by the sender of the packet.
+// If the processing fails, the error is returned, classified by ProcessingDropReason, along with a nil result.
+func (m *Mix) ProcessPacket(packet []byte) (*PacketProcessingResult, error) {
+	unwrapped, err := m.unwrapPacket(packet)
+	if err != nil {
+		return nil, err
+	}
 
 	// rather than sleeping in new gouroutine and waiting for channel data that is sent from it
 	// just sleep in the main goroutine and avoid extra communication overhead
-	time.Sleep(time.Second * time.Duration(commands.Delay))
+	clock.Sleep(m.clock, unwrapped.delay)
+	return m.completePacket(unwrapped)
+}
+
+// ScheduleProcessing performs the same processing as ProcessPacket, but rather than blocking for the delay
+// requested by the sender of the packet, it calls handle with its outcome in a new goroutine once the delay elapses.
+// The cryptographic operations are performed before returning and if they fail, handle is called immediately.
+// If the node already holds the maximum number of delayed packets, the packet is shed with ErrDelayQueueFull.
+// As with ProcessPacket, the result passed to handle is nil whenever the error is not.
+func (m *Mix) ScheduleProcessing(packet []byte, handle func(*PacketProcessingResult, error)) {
+	m.ScheduleProcessingFrom("", packet, handle)
+}
 
-	res.packetData = newPacket
-	res.nextHop = nextHop
-	res.flag = flags.SphinxFlagFromBytes(commands.Flag)
+// ScheduleProcessingFrom works like ScheduleProcessing, but the delayed packet is accounted to the given source,
+// e.g. the host of the peer it was received from. If the node already holds the maximum number of delayed packets
+// of the source, the packet is shed with ErrSourceDelayQueueFull.
+func (m *Mix) ScheduleProcessingFrom(source string, packet []byte, handle func(*PacketProcessingResult, error)) {
+	unwrapped, err := m.unwrapPacket(packet)
+	if err == nil {
+		err = m.scheduler.ScheduleFrom(source, unwrapped.delay, func() {
+			res, err := m.completePacket(unwrapped)
+			go handle(res, err)
+		})
+		if err == nil {
+			return
+		}
+	}
+	handle(nil, err)
+}
+
+// unwrappedPacket holds the outcome of the cryptographic processing of a packet until its delay elapses.
+type unwrappedPacket struct {
+	data     []byte
+	nextHop  sphinx.Hop
+	commands sphinx.Commands
+	delay    time.Duration
+}
+
+// unwrapPacket performs all the processing of the packet which does not depend on its delay.
+func (m *Mix) unwrapPacket(packet []byte) (*unwrappedPacket, error) {
+	// shed the load before doing any expensive cryptographic operations
+	if limiter := m.currentLimiter(); limiter != nil && !limiter.allow() {
+		return nil, ErrRateLimited
+	}
+
+	var (
+		nextHop   sphinx.Hop
+		commands  sphinx.Commands
+		newPacket []byte
+		tag       []byte
+		err       error
+	)
+	if m.replayCheckDisabled {
+		nextHop, commands, newPacket, err = sphinx.ProcessSphinxPacketWithCodec(packet, m.prvKey, m.codec)
+	} else {
+		nextHop, commands, newPacket, tag, err = sphinx.ProcessSphinxPacketWithReplayTag(packet,
+			m.prvKey,
+			m.codec,
+			m.replayTagLength,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the tag is only recorded once the MAC has been verified, so that forged packets could not fill the cache
+	if !m.replayCheckDisabled && !m.replays.add(tag, m.replayDeadline(commands), m.clock.Now()) {
+		return nil, ErrReplayedPacket
+	}
+
+	// the client might have not respected the delay limits so we need to enforce them ourselves
+	if !(commands.Delay >= 0) {
+		return nil, sphinx.ErrNegativeDelay
+	}
+	delay := math.Min(commands.Delay, m.maxDelay)
+
+	return &unwrappedPacket{data: newPacket,
+		nextHop:  nextHop,
+		commands: commands,
+		delay:    time.Duration(delay * float64(time.Second)),
+	}, nil
+}
+
+// replayDeadline returns until when the tag of the packet with the given commands has to be remembered,
+// i.e. until the packet is dropped as expired, or zero if it never expires.
+func (m *Mix) replayDeadline(commands sphinx.Commands) time.Time {
+	if commands.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(commands.Expiry, 0).Add(m.clockSkewTolerance)
+}
+
+// completePacket produces the result of the processing once the delay of the packet has elapsed.
+func (m *Mix) completePacket(unwrapped *unwrappedPacket) (*PacketProcessingResult, error) {
+	// the expiry is checked after the delay, as the packet could have expired in the meantime
+	if unwrapped.commands.Expired(m.clock.Now(), m.clockSkewTolerance) {
+		return nil, sphinx.ErrPacketExpired
+	}
+
+	flag := flags.SphinxFlagFromBytes(unwrapped.commands.Flag)
+	return &PacketProcessingResult{packetData: unwrapped.data,
+		nextHop: unwrapped.nextHop,
+		flag:    flag,
+		kind:    PacketKindFromFlag(flag),
+		auxData: unwrapped.commands.AuxData,
+	}, nil
+}
+
+// SetMaxDelay sets the maximum delay (in seconds) the mix is willing to hold any packet for.
+// Packets requesting longer delays are forwarded after maxDelay instead.
+func (m *Mix) SetMaxDelay(maxDelay float64) {
+	m.maxDelay = maxDelay
+}
+
+// SetClockSkewTolerance sets for how long after their expiry the packets are still processed.
+func (m *Mix) SetClockSkewTolerance(tolerance time.Duration) {
+	m.clockSkewTolerance = tolerance
+}
+
+// SetClock sets the clock used for delaying the packets, checking their expiry and limiting the processing rate.
+// It should be called before the node starts receiving packets.
+func (m *Mix) SetClock(c clock.Clock) {
+	m.clock = c
+	m.scheduler = NewDelayScheduler(m.scheduler.capacity, c)
+	m.scheduler.SetSourceCapacity(m.maxPendingPerSource)
+	if limiter := m.currentLimiter(); limiter != nil {
+		limiter.clock = c
+		limiter.last = c.Now()
+	}
+}
+
+func (m *Mix) currentLimiter() *rateLimiter {
+	m.limiterMu.RLock()
+	defer m.limiterMu.RUnlock()
+	return m.limiter
+}
+
+// SetMaxProcessingRate limits the number of packets the node processes per second, allowing bursts of up to
+// the given number of packets. Any packets above the limit are rejected with ErrRateLimited rather than queued.
+// A non-positive rate removes the limit. It can be called while the node is receiving packets,
+// in which case the bucket of the new limit starts full.
+func (m *Mix) SetMaxProcessingRate(rate float64, burst int) {
+	var limiter *rateLimiter
+	if rate > 0 {
+		limiter = newRateLimiter(rate, burst, m.clock)
+	}
+	m.limiterMu.Lock()
+	m.limiter = limiter
+	m.limiterMu.Unlock()
+}
+
+// MaxProcessingRate returns the number of packets the node processes per second and the size of the allowed bursts.
+// A zero rate means the processing rate is unlimited.
+func (m *Mix) MaxProcessingRate() (float64, int) {
+	limiter := m.currentLimiter()
+	if limiter == nil {
+		return 0, 0
+	}
+	return limiter.rate, int(limiter.burst)
+}
+
+// SetMaxPendingForwards sets the maximum number of delayed packets the node holds at once.
+// Any packets received while the node is at the limit are shed with ErrDelayQueueFull,
+// so that the memory used by the delayed packets is bounded. It should be called before the node starts receiving packets.
+func (m *Mix) SetMaxPendingForwards(n int) {
+	m.scheduler = NewDelayScheduler(n, m.clock)
+	m.scheduler.SetSourceCapacity(m.maxPendingPerSource)
+}
+
+// SetMaxPendingForwardsPerSource sets the maximum number of delayed packets the node holds at once for a single
+// source, as passed to ScheduleProcessingFrom. Any further packets of the source are shed with ErrSourceDelayQueueFull,
+// so that a single sender could not take up all the capacity set by SetMaxPendingForwards. A limit of 0 means
+// the sources are not limited separately. It should be called before the node starts receiving packets.
+func (m *Mix) SetMaxPendingForwardsPerSource(n int) {
+	m.maxPendingPerSource = n
+	m.scheduler.SetSourceCapacity(n)
+}
+
+// SetReplayTagLength sets the length (in bytes) of the tags the node remembers the processed packets by.
+// Shorter tags use less memory, at the cost of a higher chance of distinct packets colliding,
+// in which case the latter one is wrongly dropped as a replay. It returns sphinx.ErrInvalidReplayTagLength
+// if the length is outside of the allowed range. It should be called before the node starts receiving packets.
+func (m *Mix) SetReplayTagLength(length int) error {
+	if err := sphinx.ValidateReplayTagLength(length); err != nil {
+		return err
+	}
+	m.replayTagLength = length
+	return nil
+}
+
+// SetReplayCacheCapacity sets the maximum number of the tags of the processed packets the node remembers at once.
+// Once it is reached, the oldest tags are forgotten, so that the packets they belong to could be replayed,
+// unless they have expired in the meantime. A non-positive capacity restores DefaultReplayCacheCapacity.
+// It should be called before the node starts receiving packets.
+func (m *Mix) SetReplayCacheCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultReplayCacheCapacity
+	}
+	m.replays = newReplayCache(capacity)
+}
+
+// DisableReplayProtection makes the node process the same packet any number of times, without remembering it.
+// It is UNSAFE for production use, as it lets an adversary trace the packets through the node by replaying them.
+// It only exists so that the throughput benchmarks, which resend identical packets, are not skewed by the replay cache.
+// It should be called before the node starts receiving packets.
+func (m *Mix) DisableReplayProtection() {
+	m.replayCheckDisabled = true
+}
+
+// ReplayProtectionEnabled returns whether the node rejects the packets it has already processed.
+func (m *Mix) ReplayProtectionEnabled() bool {
+	return !m.replayCheckDisabled
+}
 
-	return res
+// SetPacketCodec sets the wire format of the packets the node receives and forwards. All the nodes in the network,
+// and the clients, have to use the same codec. By default it is sphinx.ProtobufCodec.
+// It should be called before the node starts receiving packets.
+func (m *Mix) SetPacketCodec(codec sphinx.Codec) {
+	m.codec = codec
 }
 
 // GetPublicKey returns the public key of the mixnode.
@@ -77,7 +364,16 @@ func (m *Mix) GetPublicKey() *sphinx.PublicKey {
 
 // NewMix creates a new instance of Mix struct with given public and private key
 func NewMix(prvKey *sphinx.PrivateKey, pubKey *sphinx.PublicKey) *Mix {
+	clk := clock.New()
 	return &Mix{prvKey: prvKey,
-		pubKey: pubKey,
+		pubKey:             pubKey,
+		maxDelay:           sphinx.DefaultMaxDelay,
+		clockSkewTolerance: DefaultClockSkewTolerance,
+		clock:              clk,
+		replayTagLength:    sphinx.DefaultReplayTagLength,
+		replays:            newReplayCache(DefaultReplayCacheCapacity),
+		scheduler:          NewDelayScheduler(DefaultMaxPendingForwards, clk),
+		dialTimeout:        DefaultDialTimeout,
+		writeTimeout:       DefaultWriteTimeout,
 	}
 }
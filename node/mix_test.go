@@ -18,8 +18,10 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/clock"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/sphinx"
@@ -113,13 +115,13 @@ func TestMixProcessPacket(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	res := providerWorker.ProcessPacket(testPacketBytes)
+	res, err := providerWorker.ProcessPacket(testPacketBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
 	dePacket := res.PacketData()
 	nextHop := res.NextHop()
 	flag := res.Flag()
-	if err := res.Err(); err != nil {
-		t.Fatal(err)
-	}
 
 	assert.Equal(t, sphinx.Hop{Id: "Mix1",
 		Address: "localhost:3330",
@@ -127,4 +129,360 @@ func TestMixProcessPacket(t *testing.T) {
 	}, nextHop, "Next hop does not match")
 	assert.Equal(t, reflect.TypeOf([]byte{}), reflect.TypeOf(dePacket))
 	assert.Equal(t, flags.RelayFlag, flag, reflect.TypeOf(dePacket))
+	assert.Equal(t, RelayPacket, res.Kind())
+}
+
+func TestMixProcessPacket_Malformed(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingHeader, err := proto.Marshal(&sphinx.SphinxPacket{Pld: []byte("foomp")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, packet := range [][]byte{nil, []byte("foomp"), missingHeader} {
+		res, err := providerWorker.ProcessPacket(packet)
+		assert.Equal(t, sphinx.ErrMalformedPacket, err, "Packet %v should have been rejected", packet)
+		assert.Equal(t, DropMalformed, ProcessingDropReason(err))
+		assert.Nil(t, res, "No result should be returned along with the error")
+
+		called := false
+		providerWorker.ScheduleProcessing(packet, func(res *PacketProcessingResult, err error) {
+			called = true
+			assert.Equal(t, sphinx.ErrMalformedPacket, err)
+			assert.Nil(t, res)
+		})
+		assert.True(t, called, "The handler should have been called straight away")
+	}
+}
+
+func TestMixProcessPacket_CompactCodec(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.SetPacketCodec(sphinx.CompactCodec)
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: providerWorker.pubKey.Bytes()}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacket, err := createTestPacket(mixes, provider, config.ClientConfig{Id: "Destination"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the packets of the other codec are rejected
+	protobufBytes, err := proto.Marshal(testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = providerWorker.ProcessPacket(protobufBytes)
+	assert.Equal(t, sphinx.ErrMalformedPacket, err)
+
+	compactBytes, err := sphinx.CompactCodec.Marshal(testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := providerWorker.ProcessPacket(compactBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, RelayPacket, res.Kind())
+	_, err = sphinx.CompactCodec.Unmarshal(res.PacketData())
+	assert.Nil(t, err, "The forwarded packet should have been encoded with the same codec")
+}
+
+func TestPacketKindFromFlag(t *testing.T) {
+	expected := map[flags.SphinxFlag]PacketKind{
+		flags.RelayFlag:         RelayPacket,
+		flags.LastHopFlag:       StorePacket,
+		flags.InvalidSphinxFlag: DropPacket,
+		flags.SphinxFlag(0x42):  DropPacket,
+	}
+	for flag, kind := range expected {
+		assert.Equal(t, kind, PacketKindFromFlag(flag), "Unexpected kind for flag %v", flag)
+	}
+}
+
+func TestMixProcessPacket_LastHopKind(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	// the same node acts as both providers, so it can unwrap both of the layers
+	path := config.E2EPath{IngressProvider: provider,
+		EgressProvider: provider,
+		Recipient:      config.ClientConfig{Id: "Recipient"},
+	}
+	testPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0}, []byte("Test Message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketBytes, err := proto.Marshal(&testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := providerWorker.ProcessPacket(testPacketBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, RelayPacket, res.Kind())
+
+	res, err = providerWorker.ProcessPacket(res.PacketData())
+	assert.Nil(t, err)
+	assert.Equal(t, StorePacket, res.Kind())
+	assert.Equal(t, "Recipient", res.NextHop().Id)
+}
+
+func TestMixProcessPacket_AuxData(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	path := config.E2EPath{IngressProvider: provider,
+		EgressProvider: provider,
+		Recipient:      config.ClientConfig{Id: "Recipient"},
+	}
+	testPacket, err := sphinx.PackForwardMessageWithAuxData(path,
+		[]float64{0.0, 0.0},
+		[]byte("Test Message"),
+		sphinx.DefaultMaxDelay,
+		time.Time{},
+		[][]byte{[]byte("ingress"), nil},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketBytes, err := proto.Marshal(&testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := providerWorker.ProcessPacket(testPacketBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ingress"), res.AuxData())
+
+	res, err = providerWorker.ProcessPacket(res.PacketData())
+	assert.Nil(t, err)
+	assert.Empty(t, res.AuxData())
+}
+
+func TestMixProcessPacket_ClampedDelay(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.SetMaxDelay(0.01)
+
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	// the client allows for much longer delays than the mix
+	testPacket, err := sphinx.PackForwardMessageWithMaxDelay(path,
+		[]float64{100.0, 100.0, 100.0, 100.0, 100.0},
+		[]byte("Test Message"),
+		1000.0,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketBytes, err := proto.Marshal(&testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	res, err := providerWorker.ProcessPacket(testPacketBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.RelayFlag, res.Flag())
+	assert.True(t, time.Since(start) < time.Second, "The delay should have been clamped by the mix")
+}
+
+func TestMixProcessPacket_ExpiresDuringDelay(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the expiry is rounded up to the end of the epoch, which the clock is set to reach in 3 seconds
+	clk := clock.NewMock(time.Now().Truncate(sphinx.ExpiryEpoch).Add(-3 * time.Second))
+	providerWorker.SetClock(clk)
+	providerWorker.SetClockSkewTolerance(0)
+
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	// the packet is still valid when it arrives, but not after the delay requested by the client
+	testPacket, err := sphinx.PackForwardMessageWithExpiry(path,
+		[]float64{5.0, 0.0, 0.0, 0.0, 0.0},
+		[]byte("Test Message"),
+		sphinx.DefaultMaxDelay,
+		clk.Now().Add(2*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPacketBytes, err := proto.Marshal(&testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := providerWorker.ProcessPacket(testPacketBytes)
+		errCh <- err
+	}()
+
+	clk.BlockUntil(1)
+	select {
+	case <-errCh:
+		t.Fatal("The packet should have been delayed until the clock advanced")
+	default:
+	}
+	clk.Advance(5 * time.Second)
+	assert.Equal(t, sphinx.ErrPacketExpired, <-errCh)
+}
+
+func TestMixProcessPacket_Expiry(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+
+	createPacket := func(expiry time.Time) []byte {
+		testPacket, err := sphinx.PackForwardMessageWithExpiry(path,
+			[]float64{0.0, 0.0, 0.0, 0.0, 0.0},
+			[]byte("Test Message"),
+			sphinx.DefaultMaxDelay,
+			expiry,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPacketBytes, err := proto.Marshal(&testPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testPacketBytes
+	}
+
+	res, err := providerWorker.ProcessPacket(createPacket(time.Now().Add(time.Minute)))
+	assert.Nil(t, err)
+	assert.Equal(t, flags.RelayFlag, res.Flag())
+
+	// the expiry is rounded up to the end of its epoch, hence it has to lie more than an epoch in the past
+	res, err = providerWorker.ProcessPacket(createPacket(time.Now().Add(-2 * time.Minute)))
+	assert.Equal(t, sphinx.ErrPacketExpired, err)
+	assert.Nil(t, res)
+
+	// unless the mix tolerates larger clock skew
+	providerWorker.SetClockSkewTolerance(3 * time.Minute)
+	res, err = providerWorker.ProcessPacket(createPacket(time.Now().Add(-2 * time.Minute)))
+	assert.Nil(t, err)
+	assert.Equal(t, flags.RelayFlag, res.Flag())
+}
+
+func TestMixProcessPacket_Replay(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: providerWorker.pubKey.Bytes()}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	createPacket := func() []byte {
+		testPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0, 0.0, 0.0}, []byte("Test Message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPacketBytes, err := proto.Marshal(&testPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testPacketBytes
+	}
+
+	assert.Equal(t, sphinx.ErrInvalidReplayTagLength, providerWorker.SetReplayTagLength(sphinx.MinReplayTagLength-1))
+	assert.Nil(t, providerWorker.SetReplayTagLength(sphinx.MinReplayTagLength))
+
+	packet := createPacket()
+	res, err := providerWorker.ProcessPacket(packet)
+	assert.Nil(t, err)
+	res, err = providerWorker.ProcessPacket(packet)
+	assert.Equal(t, ErrReplayedPacket, err)
+	assert.Nil(t, res)
+
+	// distinct packets are still processed
+	res, err = providerWorker.ProcessPacket(createPacket())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, providerWorker.replays.len())
+	for tag := range providerWorker.replays.seen {
+		assert.Len(t, tag, sphinx.MinReplayTagLength)
+	}
+}
+
+func TestMixProcessPacket_ReplayProtectionDisabled(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, providerWorker.ReplayProtectionEnabled())
+
+	provider := config.MixConfig{Id: "Provider", Host: "localhost", Port: "3333", PubKey: providerWorker.pubKey.Bytes()}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	testPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0, 0.0, 0.0}, []byte("Test Message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := proto.Marshal(&testPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerWorker.DisableReplayProtection()
+	assert.False(t, providerWorker.ReplayProtectionEnabled())
+	for i := 0; i < 3; i++ {
+		res, err := providerWorker.ProcessPacket(packet)
+		assert.Nil(t, err)
+		assert.NotNil(t, res.PacketData())
+	}
+	assert.Equal(t, 0, providerWorker.replays.len())
 }
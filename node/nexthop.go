@@ -0,0 +1,113 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+const (
+	// DefaultNextHopResolveTimeout defines for how long the host of the next hop is resolved
+	// before the packet is dropped.
+	DefaultNextHopResolveTimeout = 5 * time.Second
+	// DefaultDialTimeout defines for how long connecting to the next hop is attempted.
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultWriteTimeout defines for how long writing the packet to the next hop may take.
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+var (
+	// ErrMalformedNextHop is the cause of NextHopError when the address of the next hop is not a valid host and port.
+	ErrMalformedNextHop = errors.New("malformed next hop address")
+	// ErrUnresolvableNextHop is the cause of NextHopError when the host of the next hop could not be resolved.
+	ErrUnresolvableNextHop = errors.New("unresolvable next hop address")
+)
+
+// NextHopError describes the address of the next hop a packet could not be forwarded to
+// before even attempting to connect to it. As the address comes from the packet, such errors mean
+// the packet was crafted badly rather than that the network failed.
+type NextHopError struct {
+	Address string
+	// Err is either ErrMalformedNextHop or ErrUnresolvableNextHop.
+	Err error
+}
+
+func (e *NextHopError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Address)
+}
+
+// IsNextHopError checks whether the given error is a NextHopError.
+func IsNextHopError(err error) bool {
+	_, ok := err.(*NextHopError)
+	return ok
+}
+
+// ResolveNextHop validates the address of the next hop, which has to consist of a host and a port,
+// and resolves its host within the given timeout. It returns the address with the resolved IP,
+// which can be dialled directly, or a NextHopError.
+func ResolveNextHop(address string, timeout time.Duration) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return "", &NextHopError{Address: address, Err: ErrMalformedNextHop}
+	}
+	if portNumber, err := strconv.ParseUint(port, 10, 16); err != nil || portNumber == 0 {
+		return "", &NextHopError{Address: address, Err: ErrMalformedNextHop}
+	}
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return "", &NextHopError{Address: address, Err: ErrUnresolvableNextHop}
+	}
+	return net.JoinHostPort(addrs[0], port), nil
+}
+
+// SetForwardTimeouts sets for how long connecting to the next hop and writing the packet to it may take,
+// so that a dead next hop fails the forwarding fast rather than after the timeout of the operating system.
+// Either timeout is disabled if 0. It should be called before the node starts receiving packets.
+func (m *Mix) SetForwardTimeouts(dialTimeout, writeTimeout time.Duration) {
+	m.dialTimeout = dialTimeout
+	m.writeTimeout = writeTimeout
+}
+
+// SendPacket opens a connection to the given address and writes the packet to it as a single frame,
+// within the forwarding timeouts of the node. The packet is framed, as the packets grow past the size
+// the next hop reads at once for the unframed ones.
+func (m *Mix) SendPacket(packet []byte, address string) error {
+	dialer := net.Dialer{Timeout: m.dialTimeout}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.writeTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(m.writeTimeout)); err != nil {
+			return err
+		}
+	}
+	return config.WriteFrame(conn, packet)
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveNextHop(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:1789", "[::1]:1789"} {
+		resolved, err := ResolveNextHop(address, DefaultNextHopResolveTimeout)
+		assert.Nil(t, err)
+		assert.Equal(t, address, resolved)
+	}
+
+	resolved, err := ResolveNextHop("localhost:1789", DefaultNextHopResolveTimeout)
+	if assert.Nil(t, err) {
+		assert.Contains(t, []string{"127.0.0.1:1789", "[::1]:1789"}, resolved)
+	}
+}
+
+func TestResolveNextHop_Malformed(t *testing.T) {
+	for _, address := range []string{"", "foomp", "localhost", ":1789", "localhost:0", "localhost:99999", "localhost:foomp"} {
+		_, err := ResolveNextHop(address, DefaultNextHopResolveTimeout)
+		if assert.IsType(t, &NextHopError{}, err, "Address %q should have been rejected", address) {
+			assert.Equal(t, ErrMalformedNextHop, err.(*NextHopError).Err)
+			assert.Equal(t, address, err.(*NextHopError).Address)
+		}
+	}
+}
+
+func TestResolveNextHop_Unresolvable(t *testing.T) {
+	// the .invalid top level domain is guaranteed to never resolve
+	_, err := ResolveNextHop("foomp.invalid:1789", DefaultNextHopResolveTimeout)
+	if assert.IsType(t, &NextHopError{}, err) {
+		assert.Equal(t, ErrUnresolvableNextHop, err.(*NextHopError).Err)
+	}
+}
+
+func TestForwardDropReason(t *testing.T) {
+	assert.Equal(t, DropBadNextHop, ForwardDropReason(&NextHopError{Address: "foomp", Err: ErrMalformedNextHop}))
+	assert.Equal(t, DropForwardError, ForwardDropReason(errors.New("foomp")))
+}
+
+func createForwardingTestMix(t *testing.T, dialTimeout, writeTimeout time.Duration) *Mix {
+	prv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMix(prv, pub)
+	m.SetForwardTimeouts(dialTimeout, writeTimeout)
+	return m
+}
+
+func TestMix_SendPacket_DialTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the timeout elapses before even a local connection could be established
+	m := createForwardingTestMix(t, time.Nanosecond, DefaultWriteTimeout)
+	err = m.SendPacket([]byte("foomp"), listener.Addr().String())
+	if netErr, ok := err.(net.Error); assert.True(t, ok, "Expected a timeout, got %v", err) {
+		assert.True(t, netErr.Timeout())
+	}
+
+	// while without the timeout the connection succeeds
+	m.SetForwardTimeouts(0, 0)
+	assert.Nil(t, m.SendPacket([]byte("foomp"), listener.Addr().String()))
+}
+
+func TestMix_SendPacket_WriteTimeout(t *testing.T) {
+	// the next hop accepts the connection, but never reads from it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// the timeout elapses before the packet, which is at most a single frame, could even be written
+	m := createForwardingTestMix(t, DefaultDialTimeout, time.Nanosecond)
+	err = m.SendPacket(bytes.Repeat([]byte{42}, config.MaxFrameSize), listener.Addr().String())
+	if netErr, ok := err.(net.Error); assert.True(t, ok, "Expected a timeout, got %v", err) {
+		assert.True(t, netErr.Timeout())
+	}
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+	}
+}
+
+func TestMix_SendPacket(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, _ := config.ReadFrame(conn)
+		received <- frame
+	}()
+
+	m := createForwardingTestMix(t, DefaultDialTimeout, DefaultWriteTimeout)
+	assert.Nil(t, m.SendPacket([]byte("foomp"), listener.Addr().String()))
+	select {
+	case data := <-received:
+		assert.Equal(t, []byte("foomp"), data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet was not received")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultReplayCacheCapacity defines the default number of the tags of the processed packets a node remembers at once.
+const DefaultReplayCacheCapacity = 1 << 20
+
+// ErrReplayedPacket is returned when the node has already processed the same packet before.
+var ErrReplayedPacket = errors.New("packet has already been processed")
+
+// replayCache remembers the tags of the processed packets, so that the replayed packets could be recognised.
+// A tag is only needed until its packet expires, as the expired packets are dropped anyway, hence the tags
+// are forgotten once their deadlines have passed. The packets without expiry could be replayed at any time,
+// as the node keys are never rotated, so their tags have no deadline. However, the cache holds at most capacity
+// tags, evicting the oldest ones when it is full, so that no sender could exhaust the memory of the node.
+type replayCache struct {
+	sync.Mutex
+	capacity int
+	// seen maps the tags to their deadlines, which are zero for the packets without expiry.
+	seen map[string]time.Time
+	// order holds the tags of seen, from head onwards, in the order they were added in.
+	order []string
+	head  int
+}
+
+func newReplayCache(capacity int) *replayCache {
+	return &replayCache{capacity: capacity, seen: make(map[string]time.Time)}
+}
+
+// add records the given tag, which is remembered until the deadline has passed, or indefinitely if it is zero.
+// It returns false if the tag has already been seen.
+func (r *replayCache) add(tag []byte, deadline time.Time, now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.evictExpired(now)
+	if _, ok := r.seen[string(tag)]; ok {
+		return false
+	}
+	if len(r.seen) >= r.capacity {
+		r.evictOldest()
+	}
+	r.seen[string(tag)] = deadline
+	r.order = append(r.order, string(tag))
+	return true
+}
+
+// evictExpired forgets the oldest tags for as long as their deadlines have passed. The expired tags added
+// after a tag which is still needed are only forgotten once that one is.
+func (r *replayCache) evictExpired(now time.Time) {
+	for r.head < len(r.order) {
+		deadline := r.seen[r.order[r.head]]
+		if deadline.IsZero() || !now.After(deadline) {
+			return
+		}
+		r.evictOldest()
+	}
+}
+
+func (r *replayCache) evictOldest() {
+	delete(r.seen, r.order[r.head])
+	r.order[r.head] = ""
+	r.head++
+	// the evicted tags are dropped from the order once they make up its larger part
+	if r.head > len(r.order)/2 {
+		r.order = append([]string(nil), r.order[r.head:]...)
+		r.head = 0
+	}
+}
+
+func (r *replayCache) len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.seen)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayCache_EvictsExpiredTags(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := newReplayCache(10)
+
+	assert.True(t, cache.add([]byte("expiring"), now.Add(time.Minute), now))
+	assert.False(t, cache.add([]byte("expiring"), now.Add(time.Minute), now.Add(time.Minute)))
+
+	// once the packet has expired, its tag is no longer needed
+	assert.True(t, cache.add([]byte("other"), now.Add(2*time.Minute), now.Add(time.Minute+time.Second)))
+	assert.Equal(t, 1, cache.len())
+
+	// while the tags of the packets without expiry are kept
+	assert.True(t, cache.add([]byte("forever"), time.Time{}, now))
+	assert.False(t, cache.add([]byte("forever"), time.Time{}, now.Add(24*time.Hour)))
+}
+
+func TestReplayCache_Capacity(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := newReplayCache(3)
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, cache.add([]byte(fmt.Sprintf("tag%v", i)), time.Time{}, now))
+		assert.True(t, cache.len() <= 3)
+		assert.True(t, len(cache.order)-cache.head <= 3)
+	}
+	// only the oldest tags are forgotten
+	for i := 97; i < 100; i++ {
+		assert.False(t, cache.add([]byte(fmt.Sprintf("tag%v", i)), time.Time{}, now))
+	}
+	assert.True(t, cache.add([]byte("tag0"), time.Time{}, now))
+	assert.True(t, len(cache.order) <= 6, "The evicted tags should have been dropped from the order")
+}
@@ -0,0 +1,207 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/clock"
+)
+
+// DefaultMaxPendingForwards defines the default number of delayed packets a node holds at once.
+const DefaultMaxPendingForwards = 10000
+
+// ErrDelayQueueFull is returned when a packet is shed because the node already holds
+// the maximum number of delayed packets.
+var ErrDelayQueueFull = errors.New("maximum number of pending delayed forwards reached")
+
+// ErrSourceDelayQueueFull is returned when a packet is shed because the node already holds
+// the maximum number of delayed packets received from the same source.
+var ErrSourceDelayQueueFull = errors.New("maximum number of pending delayed forwards of the source reached")
+
+// DelayScheduler runs the scheduled functions once their delays elapse, in the order of their deadlines.
+// It holds at most capacity functions at once, so that the memory used by the delayed packets is bounded
+// regardless of the delays requested by their senders. The functions scheduled on behalf of a single source
+// can further be limited, so that the source could not fill the scheduler on its own. All the functions
+// are waited for by a single goroutine, which is only running while there is anything pending.
+type DelayScheduler struct {
+	sync.Mutex
+	capacity  int
+	clock     clock.Clock
+	pending   delayQueue
+	seq       uint64
+	running   bool
+	overflows uint
+	// sourceCapacity is the number of pending functions of a single source. If 0, the sources are unlimited.
+	sourceCapacity int
+	// perSource holds the number of pending functions of each source having any.
+	perSource map[string]int
+	// wakeCh notifies the running goroutine that a function with an earlier deadline was scheduled.
+	wakeCh chan struct{}
+}
+
+// NewDelayScheduler creates a scheduler holding at most capacity pending functions,
+// whose delays are measured by the given clock. The capacity is at least 1.
+func NewDelayScheduler(capacity int, clk clock.Clock) *DelayScheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &DelayScheduler{capacity: capacity,
+		clock:  clk,
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+// Schedule runs fn once the delay elapses. If the scheduler is already full, fn is not run
+// and ErrDelayQueueFull is returned instead. fn is run on the goroutine of the scheduler,
+// so it should hand off any blocking work rather than delaying the following functions.
+func (s *DelayScheduler) Schedule(delay time.Duration, fn func()) error {
+	return s.ScheduleFrom("", delay, fn)
+}
+
+// ScheduleFrom works like Schedule, but fn is accounted to the given source. If the source already has
+// the maximum number of pending functions, fn is not run and ErrSourceDelayQueueFull is returned instead.
+// The functions of an empty source are only limited by the capacity of the scheduler.
+func (s *DelayScheduler) ScheduleFrom(source string, delay time.Duration, fn func()) error {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.pending) >= s.capacity {
+		s.overflows++
+		return ErrDelayQueueFull
+	}
+	if source != "" && s.sourceCapacity > 0 && s.perSource[source] >= s.sourceCapacity {
+		return ErrSourceDelayQueueFull
+	}
+	s.seq++
+	item := &delayedFunc{deadline: s.clock.Now().Add(delay), seq: s.seq, source: source, fn: fn}
+	heap.Push(&s.pending, item)
+	if source != "" {
+		if s.perSource == nil {
+			s.perSource = make(map[string]int)
+		}
+		s.perSource[source]++
+	}
+
+	if !s.running {
+		s.running = true
+		go s.run()
+	} else if s.pending[0] == item {
+		select {
+		case s.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of functions waiting for their delays to elapse.
+func (s *DelayScheduler) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.pending)
+}
+
+// PendingFrom returns the number of functions of the given source waiting for their delays to elapse.
+func (s *DelayScheduler) PendingFrom(source string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.perSource[source]
+}
+
+// SetSourceCapacity sets the maximum number of pending functions of a single source.
+// A capacity of 0 means the sources are only limited by the capacity of the scheduler.
+func (s *DelayScheduler) SetSourceCapacity(capacity int) {
+	s.Lock()
+	defer s.Unlock()
+	if capacity < 0 {
+		capacity = 0
+	}
+	s.sourceCapacity = capacity
+}
+
+// Overflows returns the number of functions which were not scheduled as the scheduler was full.
+func (s *DelayScheduler) Overflows() uint {
+	s.Lock()
+	defer s.Unlock()
+	return s.overflows
+}
+
+func (s *DelayScheduler) run() {
+	for {
+		s.Lock()
+		if len(s.pending) == 0 {
+			s.running = false
+			s.Unlock()
+			return
+		}
+		next := s.pending[0]
+		wait := next.deadline.Sub(s.clock.Now())
+		if wait > 0 {
+			s.Unlock()
+			select {
+			case <-s.clock.After(wait):
+			case <-s.wakeCh:
+			}
+			continue
+		}
+		heap.Pop(&s.pending)
+		if next.source != "" {
+			s.perSource[next.source]--
+			if s.perSource[next.source] == 0 {
+				delete(s.perSource, next.source)
+			}
+		}
+		s.Unlock()
+		next.fn()
+	}
+}
+
+type delayedFunc struct {
+	deadline time.Time
+	// seq preserves the scheduling order of the functions with equal deadlines.
+	seq    uint64
+	source string
+	fn     func()
+}
+
+// delayQueue is a min-heap of the delayed functions ordered by their deadlines.
+type delayQueue []*delayedFunc
+
+func (q delayQueue) Len() int { return len(q) }
+
+func (q delayQueue) Less(i, j int) bool {
+	if q[i].deadline.Equal(q[j].deadline) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].deadline.Before(q[j].deadline)
+}
+
+func (q delayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x interface{}) {
+	*q = append(*q, x.(*delayedFunc))
+}
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/clock"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayScheduler_Overflow(t *testing.T) {
+	const capacity = 4
+	scheduler := NewDelayScheduler(capacity, clock.New())
+
+	fired := make(chan struct{}, capacity)
+	for i := 0; i < capacity; i++ {
+		assert.Nil(t, scheduler.Schedule(50*time.Millisecond, func() { fired <- struct{}{} }))
+	}
+	assert.Equal(t, capacity, scheduler.Pending())
+
+	assert.Equal(t, ErrDelayQueueFull, scheduler.Schedule(0, func() { t.Error("The overflowing function should not run") }))
+	assert.Equal(t, ErrDelayQueueFull, scheduler.Schedule(0, func() { t.Error("The overflowing function should not run") }))
+	assert.Equal(t, uint(2), scheduler.Overflows())
+	assert.Equal(t, capacity, scheduler.Pending())
+
+	for i := 0; i < capacity; i++ {
+		select {
+		case <-fired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The pending functions should have run once their delays elapsed")
+		}
+	}
+
+	// the capacity is freed once the functions have run
+	assert.Equal(t, 0, scheduler.Pending())
+	done := make(chan struct{})
+	assert.Nil(t, scheduler.Schedule(0, func() { close(done) }))
+	<-done
+	assert.Equal(t, uint(2), scheduler.Overflows())
+}
+
+func TestDelayScheduler_SourceCapacity(t *testing.T) {
+	scheduler := NewDelayScheduler(DefaultMaxPendingForwards, clock.New())
+	scheduler.SetSourceCapacity(2)
+
+	fired := make(chan string, 5)
+	schedule := func(source string) error {
+		return scheduler.ScheduleFrom(source, 50*time.Millisecond, func() { fired <- source })
+	}
+	assert.Nil(t, schedule("10.0.0.1"))
+	assert.Nil(t, schedule("10.0.0.1"))
+	// the excess functions of the source are shed
+	assert.Equal(t, ErrSourceDelayQueueFull, schedule("10.0.0.1"))
+	assert.Equal(t, 2, scheduler.PendingFrom("10.0.0.1"))
+	// while the other sources, and the functions of no source, proceed
+	assert.Nil(t, schedule("10.0.0.2"))
+	assert.Nil(t, scheduler.Schedule(50*time.Millisecond, func() { fired <- "" }))
+	assert.Equal(t, 4, scheduler.Pending())
+	assert.Zero(t, scheduler.Overflows())
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-fired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The pending functions should have run once their delays elapsed")
+		}
+	}
+
+	// the source may schedule again once its functions have run
+	assert.Zero(t, scheduler.PendingFrom("10.0.0.1"))
+	assert.Nil(t, schedule("10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", <-fired)
+}
+
+func TestDelayScheduler_DelayOrder(t *testing.T) {
+	scheduler := NewDelayScheduler(DefaultMaxPendingForwards, clock.New())
+
+	delays := []time.Duration{60, 20, 40, 0, 20}
+	expected := []int{3, 1, 4, 2, 0}
+
+	order := make(chan int, len(delays))
+	for i, delay := range delays {
+		i := i
+		assert.Nil(t, scheduler.Schedule(delay*time.Millisecond, func() { order <- i }))
+	}
+	for _, i := range expected {
+		select {
+		case fired := <-order:
+			assert.Equal(t, i, fired)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The pending functions should have run once their delays elapsed")
+		}
+	}
+}
+
+// processingOutcome is what ScheduleProcessing passed to the handler of a packet.
+type processingOutcome struct {
+	res *PacketProcessingResult
+	err error
+}
+
+func TestMixScheduleProcessing_Overflow(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.SetMaxPendingForwards(1)
+
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	createPacket := func() []byte {
+		testPacket, err := sphinx.PackForwardMessage(path, []float64{0.05, 0.0, 0.0, 0.0, 0.0}, []byte("Test Message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := proto.Marshal(&testPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	resCh := make(chan processingOutcome, 2)
+	handle := func(res *PacketProcessingResult, err error) { resCh <- processingOutcome{res, err} }
+	providerWorker.ScheduleProcessing(createPacket(), handle)
+
+	// the second packet is shed straight away, while the first one is still delayed
+	providerWorker.ScheduleProcessing(createPacket(), handle)
+	outcome := <-resCh
+	assert.Equal(t, ErrDelayQueueFull, outcome.err)
+	assert.Equal(t, DropDelayQueueFull, ProcessingDropReason(outcome.err))
+	assert.Nil(t, outcome.res)
+
+	outcome = <-resCh
+	assert.Nil(t, outcome.err)
+	assert.Equal(t, RelayPacket, outcome.res.Kind())
+	assert.Equal(t, "localhost:3330", outcome.res.NextHop().Address)
+}
+
+func TestMixScheduleProcessing_SourceOverflow(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.SetMaxPendingForwardsPerSource(1)
+
+	provider := config.MixConfig{Id: "Provider",
+		Host: "localhost",
+		Port: "3333", PubKey: providerWorker.pubKey.Bytes(),
+	}
+	mixes, err := createTestMixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: provider, Mixes: mixes, EgressProvider: provider}
+	createPacket := func() []byte {
+		testPacket, err := sphinx.PackForwardMessage(path, []float64{0.05, 0.0, 0.0, 0.0, 0.0}, []byte("Test Message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := proto.Marshal(&testPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	resCh := make(chan processingOutcome, 3)
+	handle := func(res *PacketProcessingResult, err error) { resCh <- processingOutcome{res, err} }
+	providerWorker.ScheduleProcessingFrom("10.0.0.1", createPacket(), handle)
+
+	// the second packet of the source is shed straight away, while the first one is still delayed
+	providerWorker.ScheduleProcessingFrom("10.0.0.1", createPacket(), handle)
+	outcome := <-resCh
+	assert.Equal(t, ErrSourceDelayQueueFull, outcome.err)
+	assert.Equal(t, DropSourceDelayQueueFull, ProcessingDropReason(outcome.err))
+
+	// while the packet of another source is delayed as usual
+	providerWorker.ScheduleProcessingFrom("10.0.0.2", createPacket(), handle)
+	for i := 0; i < 2; i++ {
+		outcome = <-resCh
+		assert.Nil(t, outcome.err)
+		assert.Equal(t, RelayPacket, outcome.res.Kind())
+	}
+}
+
+func TestMixSetClock_KeepsSourceCapacity(t *testing.T) {
+	providerWorker, err := createProviderWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerWorker.SetMaxPendingForwardsPerSource(1)
+	providerWorker.SetClock(clock.NewMock(time.Now()))
+
+	assert.Nil(t, providerWorker.scheduler.ScheduleFrom("10.0.0.1", time.Hour, func() {}))
+	assert.Equal(t, ErrSourceDelayQueueFull, providerWorker.scheduler.ScheduleFrom("10.0.0.1", time.Hour, func() {}))
+	assert.Nil(t, providerWorker.scheduler.ScheduleFrom("10.0.0.2", time.Hour, func() {}))
+}
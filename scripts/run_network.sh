@@ -40,6 +40,10 @@ do
     sleep 1
 done
 
+sleep 1
+# Clients never send their packets through the provider of the recipient, so at least two providers are needed
+# for the clients to be able to send messages to other clients at the same provider and their loop cover messages.
+$PWD/build/nym-mixnet-provider run --id Provider2 --host "localhost" --port 9996 &
 sleep 1
 $PWD/build/nym-mixnet-provider run --id Provider --host "localhost" --port 9997
 
@@ -51,6 +55,7 @@ function ctrl_c() {
         do
             kill_port $((9980+$j))
         done
+        kill_port 9996
 }
 
 function kill_port() {
@@ -16,12 +16,14 @@
 package mixnode
 
 import (
+	"bufio"
 	"encoding/base64"
+	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-directory/models"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
@@ -74,6 +76,9 @@ type metrics struct {
 	b64Key           string
 	receivedMessages uint
 	sentMessages     map[string]uint
+	// drops counts the dropped packets since the mixnode started. Unlike the other metrics,
+	// the counters are not reset after being reported.
+	drops node.DropCounter
 
 	log *logrus.Logger
 }
@@ -110,9 +115,13 @@ func (m *metrics) sendToDirectoryServer() {
 		sentCopy[k] = v
 	}
 	receivedCopy := m.receivedMessages
+	droppedCopy := make(map[string]uint)
+	for reason, count := range m.drops.Snapshot() {
+		droppedCopy[string(reason)] = count
+	}
 
 	go func(metricsCopy models.MixMetric) {
-		if err := helpers.SendMixMetrics(metricsCopy, m.host); err != nil {
+		if err := helpers.SendMixMetrics(metricsCopy, droppedCopy, m.host); err != nil {
 			m.log.Errorf("Failed to send metrics: %v", err)
 		}
 	}(models.MixMetric{
@@ -163,40 +172,67 @@ func (m *MixServer) GetConfig() config.MixConfig {
 	return m.config
 }
 
-func (m *MixServer) receivedPacket(packet []byte) error {
+func (m *MixServer) receivedPacket(peer string, packet []byte) error {
 	m.log.Infof("%s: Received new sphinx packet", m.id)
 	m.metrics.incrementReceived()
 
-	// process in goroutine so we wouldn't block while executing the required delay
-	go func(packet []byte) {
-		res := m.ProcessPacket(packet)
-		dePacket := res.PacketData()
-		nextHop := res.NextHop()
-		flag := res.Flag()
-		if err := res.Err(); err != nil {
-			m.log.Errorf("error while processing packet: %v", err)
+	// rather than blocking for the required delay, the packet is held by the bounded scheduler of the node
+	m.ScheduleProcessingFrom(peer, packet, m.handleProcessedPacket)
+
+	return nil
+}
+
+// handleProcessedPacket forwards the processed packet, or drops it if its processing failed with the given error.
+// It is called in a goroutine of its own once the delay of the packet has elapsed, so any panic caused
+// by the packet is recovered from, as otherwise it would crash the entire mixnode.
+func (m *MixServer) handleProcessedPacket(res *node.PacketProcessingResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.log.Errorf("Recovered from panic while handling processed sphinx packet: %v", r)
 		}
+	}()
+	if err != nil {
+		m.dropPacket(node.ProcessingDropReason(err), err)
+		return
+	}
+	dePacket := res.PacketData()
+	nextHop := res.NextHop()
 
-		if flag == flags.RelayFlag {
-			if err := m.forwardPacket(dePacket, nextHop.Address); err != nil {
-				m.log.Errorf("error while forwarding packet: %v", err)
-			}
-			// add it only if we didn't return an error
-			m.metrics.addMessage(nextHop.Address)
-		} else {
-			m.log.Info("Packet has non-forward flag. Packet dropped")
+	if res.Kind() == node.RelayPacket {
+		if err := m.forwardPacket(dePacket, nextHop.Address); err != nil {
+			m.dropPacket(node.ForwardDropReason(err), err)
+			return
 		}
-	}(packet)
+		m.metrics.addMessage(nextHop.Address)
+	} else {
+		m.dropPacket(node.DropUnknownFlag, fmt.Errorf("non-forward sphinx flag %v", res.Flag()))
+	}
+}
 
-	return nil
+// dropPacket records that a packet was dropped for the given reason.
+func (m *MixServer) dropPacket(reason node.DropReason, err error) {
+	m.metrics.drops.Record(reason)
+	m.log.Warnf("Dropped packet (%v): %v", reason, err)
 }
 
+// DroppedPackets returns the number of packets dropped since the mixnode started,
+// by the reason they were dropped for.
+func (m *MixServer) DroppedPackets() map[node.DropReason]uint {
+	return m.metrics.drops.Snapshot()
+}
+
+// forwardPacket sends the sphinx packet to the next hop. The address of the next hop comes from the packet,
+// hence it is validated and resolved before dialling, and a node.NextHopError is returned if it is bad.
 func (m *MixServer) forwardPacket(sphinxPacket []byte, address string) error {
+	resolvedAddress, err := node.ResolveNextHop(address, node.DefaultNextHopResolveTimeout)
+	if err != nil {
+		return err
+	}
 	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
 	if err != nil {
 		return err
 	}
-	if err := m.send(packetBytes, address); err != nil {
+	if err := m.send(packetBytes, resolvedAddress); err != nil {
 		return err
 	}
 
@@ -204,16 +240,7 @@ func (m *MixServer) forwardPacket(sphinxPacket []byte, address string) error {
 }
 
 func (m *MixServer) send(packet []byte, address string) error {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	if _, err := conn.Write(packet); err != nil {
-		return err
-	}
-	return nil
+	return m.SendPacket(packet, address)
 }
 
 func (m *MixServer) run() {
@@ -223,7 +250,7 @@ func (m *MixServer) run() {
 	go m.startSendingPresence()
 
 	go func() {
-		m.log.Infof("Listening on %s", m.host+":"+m.port)
+		m.log.Infof("Listening on %s", net.JoinHostPort(m.host, m.port))
 		m.listenForIncomingConnections()
 	}()
 
@@ -277,28 +304,70 @@ func (m *MixServer) listenForIncomingConnections() {
 	}
 }
 
+// handleConnection handles either a single raw packet or a stream of framed packets, the same transport
+// the provider accepts. Being a pure relay, the mix server only accepts the sphinx packets, while
+// the requests of the clients, such as the assign and pull requests, are dropped.
 func (m *MixServer) handleConnection(conn net.Conn) error {
 	defer conn.Close()
 
-	buff := make([]byte, 2048)
-	reqLen, err := conn.Read(buff)
+	r := bufio.NewReader(conn)
+	isStream, err := config.IsFrameStream(r)
 	if err != nil {
 		return err
 	}
+	if isStream {
+		return m.handleStream(conn, r)
+	}
 
-	var packet config.GeneralPacket
-	if err := proto.Unmarshal(buff[:reqLen], &packet); err != nil {
+	buff := make([]byte, 2048)
+	reqLen, err := r.Read(buff)
+	if err != nil {
 		return err
 	}
+	return m.handlePacket(conn, buff[:reqLen])
+}
+
+// handleStream handles a stream of framed packets sent over a single connection, so that the sender would not need
+// to establish a new connection for each of them.
+func (m *MixServer) handleStream(conn net.Conn, r io.Reader) error {
+	frames := config.NewFrameReader(r)
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			m.dropPacket(node.DropMalformed, fmt.Errorf("stream from %v: %v", conn.RemoteAddr(), err))
+			return nil
+		}
+		if err := m.handlePacket(conn, frame); err != nil {
+			return err
+		}
+	}
+}
+
+// handlePacket handles a single packet received on the connection.
+func (m *MixServer) handlePacket(conn net.Conn, packetBytes []byte) error {
+	// truncated or garbage input is not something a well-behaved peer would ever send
+	packet, err := config.UnwrapPacket(packetBytes)
+	if err != nil {
+		m.dropPacket(node.DropMalformed, fmt.Errorf("packet from %v: %v", conn.RemoteAddr(), err))
+		return nil
+	}
 
 	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
 	case flags.CommFlag:
-		if err := m.receivedPacket(packet.Data); err != nil {
+		if err := m.receivedPacket(helpers.PeerHost(conn.RemoteAddr()), packet.Data); err != nil {
 			return err
 		}
+	case flags.AssignFlag, flags.PullFlag:
+		m.dropPacket(node.DropUnknownFlag,
+			fmt.Errorf("client request with flag %#x from %v sent to a mix node", packet.Flag, conn.RemoteAddr()),
+		)
 	default:
-		m.log.Infof("Packet flag %s not recognised. Packet dropped", packet.Flag)
-		return nil
+		m.dropPacket(node.DropUnknownFlag,
+			fmt.Errorf("packet flag %#x from %v not recognised", packet.Flag, conn.RemoteAddr()),
+		)
 	}
 	return nil
 }
@@ -366,6 +435,7 @@ func CreateTestMixnode() (*MixServer, error) {
 
 	node := node.NewMix(priv, pub)
 	mix := MixServer{host: "localhost", port: "9995", Mix: node, log: disabledLog}
+	mix.metrics = newMetrics(disabledLog, pub, net.JoinHostPort(mix.host, mix.port))
 	mix.config = config.MixConfig{Id: mix.id,
 		Host:   mix.host,
 		Port:   mix.port,
@@ -13,3 +13,483 @@
 // limitations under the License.
 
 package mixnode
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/logger"
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+//nolint:gochecknoglobals
+var mixServer *MixServer
+
+func TestMain(m *testing.M) {
+	// the mixnode is created without a listener, so that it would not clash
+	// with the test mixnode created by the provider tests
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		fmt.Println(err)
+		panic(m)
+	}
+	disabledLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, true)
+	if err != nil {
+		fmt.Println(err)
+		panic(m)
+	}
+	disabledLog := disabledLogger.GetLogger("test")
+	mixServer = &MixServer{host: "localhost",
+		port:    "9996",
+		Mix:     node.NewMix(priv, pub),
+		metrics: newMetrics(disabledLog, pub, "localhost:9996"),
+		log:     disabledLog,
+	}
+
+	os.Exit(m.Run())
+}
+
+// sendToHandler passes the given bytes to handleConnection and returns the error it returned.
+func sendToHandler(t *testing.T, data []byte) error {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- mixServer.handleConnection(serverConn)
+	}()
+
+	if _, err := clientConn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return <-errCh
+}
+
+// sendStreamToHandler passes the given packets to handleConnection, each in its own frame,
+// and returns the error it returned.
+func sendStreamToHandler(t *testing.T, packets ...[]byte) error {
+	serverConn, clientConn := net.Pipe()
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- mixServer.handleConnection(serverConn)
+	}()
+
+	for _, packet := range packets {
+		if err := config.WriteFrame(clientConn, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientConn.Close()
+	return <-errCh
+}
+
+func TestMixServer_HandleConnection_RejectsMalformedPackets(t *testing.T) {
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	malformedPackets := [][]byte{
+		packetBytes[:len(packetBytes)-2],
+		append([]byte(config.PacketMagic), config.PacketVersion, 0xff, 0xff, 0xff),
+		[]byte("garbage"),
+	}
+
+	mixServer.metrics.Lock()
+	malformedBefore, unknownBefore := mixServer.metrics.drops.Count(node.DropMalformed), mixServer.metrics.drops.Count(node.DropUnknownFlag)
+	mixServer.metrics.Unlock()
+
+	for _, packet := range malformedPackets {
+		assert.Nil(t, sendToHandler(t, packet))
+	}
+
+	mixServer.metrics.Lock()
+	defer mixServer.metrics.Unlock()
+	assert.Equal(t, malformedBefore+uint(len(malformedPackets)), mixServer.metrics.drops.Count(node.DropMalformed))
+	assert.Equal(t, unknownBefore, mixServer.metrics.drops.Count(node.DropUnknownFlag))
+}
+
+func TestMixServer_HandleConnection_RejectsUnknownFlag(t *testing.T) {
+	packetBytes, err := config.WrapWithFlag(flags.PacketTypeFlag(0x42), []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mixServer.metrics.Lock()
+	malformedBefore, unknownBefore := mixServer.metrics.drops.Count(node.DropMalformed), mixServer.metrics.drops.Count(node.DropUnknownFlag)
+	mixServer.metrics.Unlock()
+
+	assert.Nil(t, sendToHandler(t, packetBytes))
+
+	mixServer.metrics.Lock()
+	defer mixServer.metrics.Unlock()
+	assert.Equal(t, malformedBefore, mixServer.metrics.drops.Count(node.DropMalformed))
+	assert.Equal(t, unknownBefore+1, mixServer.metrics.drops.Count(node.DropUnknownFlag))
+}
+
+func TestMixServer_ProcessPacket_MissingHeader(t *testing.T) {
+	// a sphinx packet without the header must be rejected rather than dereferenced
+	packetBytes, err := proto.Marshal(&sphinx.SphinxPacket{Pld: []byte("foomp")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := mixServer.ProcessPacket(packetBytes)
+	assert.Equal(t, sphinx.ErrMalformedPacket, err)
+	assert.Nil(t, res)
+}
+
+func TestMixServer_HandleProcessedPacket_RecoversFromPanic(t *testing.T) {
+	// the handler runs in a goroutine of its own, so a panic would crash the entire mixnode
+	assert.NotPanics(t, func() { mixServer.handleProcessedPacket(nil, nil) })
+}
+
+// createExpiringPacket creates a packet, wrapped with CommFlag, which the mixServer should relay to the given node.
+func createExpiringPacket(t *testing.T, next config.MixConfig, expiry time.Time) []byte {
+	self := config.MixConfig{Id: "Mix", Host: "localhost", Port: "9996", PubKey: mixServer.GetPublicKey().Bytes()}
+	path := config.E2EPath{IngressProvider: self, EgressProvider: next}
+	sphinxPacket, err := sphinx.PackForwardMessageWithExpiry(path,
+		[]float64{0.0, 0.0},
+		[]byte("foomp"),
+		sphinx.DefaultMaxDelay,
+		expiry,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sphinxBytes, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packetBytes
+}
+
+func TestMixServer_ReceivedPacket_Expiry(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := config.MixConfig{Id: "Next", Host: host, Port: port, PubKey: pub.Bytes()}
+
+	forwarded := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			forwarded <- struct{}{}
+		}
+	}()
+
+	expiredBefore := mixServer.metrics.drops.Count(node.DropExpired)
+
+	// the unexpired packet is forwarded to the next hop
+	assert.Nil(t, sendToHandler(t, createExpiringPacket(t, next, time.Now().Add(time.Minute))))
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unexpired packet was not forwarded")
+	}
+
+	// while the expired one is dropped
+	assert.Nil(t, sendToHandler(t, createExpiringPacket(t, next, time.Now().Add(-time.Hour))))
+	assert.Eventually(t, func() bool {
+		return mixServer.metrics.drops.Count(node.DropExpired) == expiredBefore+1
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case <-forwarded:
+		t.Fatal("expired packet was forwarded")
+	default:
+	}
+}
+
+func TestMixServer_ReceivedPacket_DropReasons(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens on the port of the closed listener, so the packet can't be forwarded
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	next := config.MixConfig{Id: "Next", Host: host, Port: port, PubKey: pub.Bytes()}
+
+	validPacket := createExpiringPacket(t, next, time.Time{})
+	packet, err := config.UnwrapPacket(validPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sphinxPacket sphinx.SphinxPacket
+	if err := proto.Unmarshal(packet.Data, &sphinxPacket); err != nil {
+		t.Fatal(err)
+	}
+	sphinxPacket.Hdr.Mac[0] ^= 0xff
+	tamperedBytes, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedPacket, err := config.WrapWithFlag(flags.CommFlag, tamperedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		packet []byte
+		reason node.DropReason
+	}{
+		{tamperedPacket, node.DropInvalidMAC},
+		{validPacket, node.DropForwardError},
+	}
+	for _, test := range tests {
+		before := mixServer.DroppedPackets()[test.reason]
+		assert.Nil(t, sendToHandler(t, test.packet))
+		assert.Eventually(t, func() bool {
+			return mixServer.DroppedPackets()[test.reason] == before+1
+		}, 5*time.Second, 10*time.Millisecond, "Packet should have been dropped as %v", test.reason)
+	}
+}
+
+func TestMixServer_HandleConnection_ShedsExcessPackets(t *testing.T) {
+	const burst = 3
+	mixServer.SetMaxProcessingRate(0.001, burst)
+	defer mixServer.SetMaxProcessingRate(0, 0)
+
+	next := config.MixConfig{Id: "Next", Host: "localhost", Port: "1", PubKey: mixServer.GetPublicKey().Bytes()}
+	packet := createExpiringPacket(t, next, time.Time{})
+	shedBefore := mixServer.DroppedPackets()[node.DropRateLimited]
+
+	const numPackets = 10
+	for i := 0; i < numPackets; i++ {
+		assert.Nil(t, sendToHandler(t, packet))
+	}
+	assert.Eventually(t, func() bool {
+		return mixServer.DroppedPackets()[node.DropRateLimited] == shedBefore+numPackets-burst
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMixServer_HandleConnection_RejectsClientRequests(t *testing.T) {
+	for _, flag := range []flags.PacketTypeFlag{flags.AssignFlag, flags.PullFlag} {
+		packetBytes, err := config.WrapWithFlag(flag, []byte("foomp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		before := mixServer.DroppedPackets()[node.DropUnknownFlag]
+		assert.Nil(t, sendToHandler(t, packetBytes))
+		assert.Equal(t, before+1, mixServer.DroppedPackets()[node.DropUnknownFlag], "Flag %v should have been rejected", flag)
+
+		before = mixServer.DroppedPackets()[node.DropUnknownFlag]
+		assert.Nil(t, sendStreamToHandler(t, packetBytes))
+		assert.Equal(t, before+1, mixServer.DroppedPackets()[node.DropUnknownFlag], "Flag %v should have been rejected", flag)
+	}
+}
+
+func TestMixServer_HandleConnection_RelaysStream(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := config.MixConfig{Id: "Next", Host: host, Port: port, PubKey: pub.Bytes()}
+
+	const numPackets = 3
+	forwarded := make(chan []byte, numPackets)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, err := config.ReadFrame(conn)
+			conn.Close()
+			if err == nil {
+				forwarded <- data
+			}
+		}
+	}()
+
+	var packets [][]byte
+	for i := 0; i < numPackets; i++ {
+		packets = append(packets, createExpiringPacket(t, next, time.Time{}))
+	}
+	assert.Nil(t, sendStreamToHandler(t, packets...))
+
+	for i := 0; i < numPackets; i++ {
+		select {
+		case data := <-forwarded:
+			packet, err := config.UnwrapPacket(data)
+			if assert.Nil(t, err) {
+				assert.Equal(t, flags.CommFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("packet from the stream was not forwarded")
+		}
+	}
+}
+
+func TestMixServer_ForwardPacket_BadNextHop(t *testing.T) {
+	err := mixServer.forwardPacket([]byte("foomp"), "foomp")
+	if assert.IsType(t, &node.NextHopError{}, err) {
+		assert.Equal(t, node.ErrMalformedNextHop, err.(*node.NextHopError).Err)
+	}
+
+	// the packet with an unresolvable next hop is dropped rather than failing to be forwarded
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := config.MixConfig{Id: "Next", Host: "foomp.invalid", Port: "1789", PubKey: pub.Bytes()}
+	before := mixServer.DroppedPackets()[node.DropBadNextHop]
+	assert.Nil(t, sendToHandler(t, createExpiringPacket(t, next, time.Time{})))
+	assert.Eventually(t, func() bool {
+		return mixServer.DroppedPackets()[node.DropBadNextHop] == before+1
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+// startRelayingTestMix starts a mix server relaying the packets it receives on a fresh local listener.
+func startRelayingTestMix(t *testing.T) (*MixServer, config.MixConfig) {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix := &MixServer{host: host,
+		port:     port,
+		Mix:      node.NewMix(priv, pub),
+		metrics:  mixServer.metrics,
+		log:      mixServer.log,
+		listener: listener,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go mix.handleConnection(conn) //nolint: errcheck
+		}
+	}()
+	return mix, config.MixConfig{Id: "Mix" + port, Host: host, Port: port, PubKey: pub.Bytes()}
+}
+
+func TestMixServer_RelaysLargePacketsOverMultipleHops(t *testing.T) {
+	var mixes []config.MixConfig
+	for i := 0; i < 3; i++ {
+		mix, mixConfig := startRelayingTestMix(t)
+		defer mix.listener.Close()
+		mixes = append(mixes, mixConfig)
+	}
+
+	// the final hop stands in for the egress provider
+	providerPriv, providerPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := config.MixConfig{Id: "Provider", Host: host, Port: port, PubKey: providerPub.Bytes()}
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, err := config.ReadFrame(conn)
+		if err == nil {
+			received <- frame
+		}
+	}()
+
+	// the packet is way larger than what is read at once for the unframed packets
+	message := make([]byte, 3000)
+	if _, err := rand.Read(message); err != nil {
+		t.Fatal(err)
+	}
+	path := config.E2EPath{IngressProvider: mixes[0], Mixes: mixes[1:], EgressProvider: provider}
+	sphinxPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0, 0.0, 0.0}, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sphinxBytes, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(mixes[0].Host, mixes[0].Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, config.WriteFrame(conn, packetBytes))
+	conn.Close()
+
+	select {
+	case data := <-received:
+		packet, err := config.UnwrapPacket(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, commands, payload, err := sphinx.ProcessSphinxPacket(packet.Data, providerPriv)
+		assert.Nil(t, err)
+		assert.Equal(t, flags.LastHopFlag, flags.SphinxFlagFromBytes(commands.Flag))
+		assert.Equal(t, message, payload)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the packet was not relayed to the provider")
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nymtech/nym-mixnet/helpers"
+)
+
+const (
+	adminInboxesPath = "/inboxes/"
+	adminConfigPath  = "/config"
+	adminMetricsPath = "/metrics"
+	adminClientsPath = "/clients/"
+	adminTokenSuffix = "/token"
+)
+
+// InboxInfo describes a single inbox kept by the provider.
+type InboxInfo struct {
+	ID       string `json:"id"`
+	Messages int    `json:"messages"`
+}
+
+// Inboxes lists all the inboxes kept by the provider together with the number of messages in each of them.
+func (p *ProviderServer) Inboxes() ([]InboxInfo, error) {
+	entries, err := ioutil.ReadDir(p.inboxesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []InboxInfo{}, nil
+		}
+		return nil, err
+	}
+	inboxes := make([]InboxInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		count, err := p.InboxMessageCount(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, InboxInfo{ID: entry.Name(), Messages: count})
+	}
+	return inboxes, nil
+}
+
+// InboxMessageCount returns the number of messages stored in the given inbox.
+// It returns ErrUnknownRecipient if the inbox does not exist.
+func (p *ProviderServer) InboxMessageCount(inboxID string) (int, error) {
+	unlock := p.inboxLocks.lock(inboxID)
+	defer unlock()
+	path, err := p.existingInboxPath(inboxID)
+	if err != nil {
+		return 0, err
+	}
+	messages, err := inboxMessages(path)
+	if err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// PurgeInbox removes all the messages stored in the given inbox. The inbox itself is kept,
+// so that the client could still receive new messages. It returns ErrUnknownRecipient if the inbox does not exist.
+func (p *ProviderServer) PurgeInbox(inboxID string) error {
+	unlock := p.inboxLocks.lock(inboxID)
+	defer unlock()
+	path, err := p.existingInboxPath(inboxID)
+	if err != nil {
+		return err
+	}
+	messages, err := inboxMessages(path)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err := os.Remove(message.path); err != nil {
+			return err
+		}
+	}
+	p.log.Infof("Purged %v messages from inbox %v", len(messages), inboxID)
+	return nil
+}
+
+func (p *ProviderServer) existingInboxPath(inboxID string) (string, error) {
+	if !validInboxID(inboxID) {
+		return "", ErrInvalidRecipient
+	}
+	path := filepath.Join(p.inboxesDir, inboxID)
+	exists, err := helpers.DirExists(path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrUnknownRecipient
+	}
+	return path, nil
+}
+
+// AdminHandler returns the handler of the admin API, which requires each request to carry the given token
+// in its "Authorization: Bearer" header. It returns ErrAdminTokenRequired if the token is empty.
+// The API consists of:
+//
+//	GET /inboxes/ - lists all the inboxes with the numbers of their messages
+//	GET /inboxes/{id} - returns the number of messages in the inbox
+//	DELETE /inboxes/{id} - purges all the messages from the inbox
+//	GET /config - returns the RuntimeConfig of the provider, with the durations in nanoseconds
+//	PUT /config - reloads the provider with the RuntimeConfig in the body
+//	GET /metrics - returns the Metrics of the provider
+//	DELETE /clients/{id}/token - revokes the token of the client with the given ClientID
+func (p *ProviderServer) AdminHandler(token string) (http.Handler, error) {
+	if token == "" {
+		return nil, ErrAdminTokenRequired
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			p.log.Warnf("Rejected unauthenticated admin request from %v", r.RemoteAddr)
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		p.handleAdminRequest(w, r)
+	}), nil
+}
+
+func (p *ProviderServer) handleAdminRequest(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == adminConfigPath {
+		p.handleAdminConfigRequest(w, r)
+		return
+	}
+	if r.URL.Path == adminMetricsPath {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.Metrics())
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, adminClientsPath) && strings.HasSuffix(r.URL.Path, adminTokenSuffix) {
+		p.handleAdminTokenRequest(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, adminInboxesPath) {
+		http.NotFound(w, r)
+		return
+	}
+	inboxID := strings.TrimPrefix(r.URL.Path, adminInboxesPath)
+
+	switch {
+	case inboxID == "" && r.Method == http.MethodGet:
+		inboxes, err := p.Inboxes()
+		if err != nil {
+			p.writeAdminError(w, err)
+			return
+		}
+		writeJSON(w, inboxes)
+	case inboxID != "" && r.Method == http.MethodGet:
+		count, err := p.InboxMessageCount(inboxID)
+		if err != nil {
+			p.writeAdminError(w, err)
+			return
+		}
+		writeJSON(w, InboxInfo{ID: inboxID, Messages: count})
+	case inboxID != "" && r.Method == http.MethodDelete:
+		if err := p.PurgeInbox(inboxID); err != nil {
+			p.writeAdminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *ProviderServer) handleAdminTokenRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, adminClientsPath), adminTokenSuffix)
+	if err := p.RevokeToken(clientID); err != nil {
+		p.writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *ProviderServer) handleAdminConfigRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, p.RuntimeConfig())
+	case http.MethodPut:
+		var cfg RuntimeConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Reload(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, p.RuntimeConfig())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *ProviderServer) writeAdminError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrUnknownRecipient, ErrUnknownClient:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrInvalidRecipient:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		p.log.Errorf("Admin request failed: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// StartAdminServer starts serving the admin API, protected by the given token, on the given address,
+// such as Config.AdminListenAddress. A warning is logged if the API is reachable beyond the loopback interface.
+// The server is stopped once the provider is shut down.
+func (p *ProviderServer) StartAdminServer(address string, token string) error {
+	handler, err := p.AdminHandler(token)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if !helpers.IsLoopbackHost(listener.Addr().String()) {
+		p.log.Warnf("Admin API is exposed beyond the loopback interface on %v, "+
+			"its metrics and inboxes are reachable by anyone holding the token", listener.Addr())
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-p.haltedCh
+		server.Close()
+	}()
+	go func() {
+		p.log.Infof("Admin API listening on %v", listener.Addr())
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.log.Errorf("Admin API failed: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "foomp"
+
+// createAdminTestProvider creates a test provider, with its inboxes in a temporary directory,
+// and the server of its admin API.
+func createAdminTestProvider(t *testing.T) (*ProviderServer, *httptest.Server, func()) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetInboxesDirectory(dir)
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+
+	handler, err := p.AdminHandler(testAdminToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	return p, server, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func adminRequest(t *testing.T, method, url, token string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestProviderServer_AdminHandler_RequiresToken(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.AdminHandler("")
+	assert.Equal(t, ErrAdminTokenRequired, err)
+
+	assert.Nil(t, DefaultConfig().Validate(), "Admin API should be disabled by default")
+	assert.Equal(t, ErrAdminTokenRequired, DefaultConfig().Overlay(Config{AdminAddress: "localhost:8000"}).Validate())
+}
+
+func TestProviderServer_Admin_RejectsUnauthenticated(t *testing.T) {
+	p, server, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+	assert.Nil(t, p.storeMessage([]byte("foomp"), "Client", "msg"))
+
+	for _, token := range []string{"", "wrong"} {
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			resp := adminRequest(t, method, server.URL+"/inboxes/Client", token)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+	count, err := p.InboxMessageCount("Client")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count, "Unauthenticated request should not have purged the inbox")
+}
+
+func TestProviderServer_Admin_ListCountPurge(t *testing.T) {
+	p, server, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, p.storeMessage([]byte("foomp"), "ClientA", fmt.Sprintf("msg%v", i)))
+	}
+	assert.Nil(t, p.storeMessage([]byte("foomp"), "ClientB", "msg"))
+
+	// list
+	resp := adminRequest(t, http.MethodGet, server.URL+"/inboxes/", testAdminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var inboxes []InboxInfo
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&inboxes))
+	resp.Body.Close()
+	assert.ElementsMatch(t, []InboxInfo{{ID: "ClientA", Messages: 3}, {ID: "ClientB", Messages: 1}}, inboxes)
+
+	// count
+	resp = adminRequest(t, http.MethodGet, server.URL+"/inboxes/ClientA", testAdminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var inbox InboxInfo
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&inbox))
+	resp.Body.Close()
+	assert.Equal(t, InboxInfo{ID: "ClientA", Messages: 3}, inbox)
+
+	// purge
+	resp = adminRequest(t, http.MethodDelete, server.URL+"/inboxes/ClientA", testAdminToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	count, err := p.InboxMessageCount("ClientA")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	count, err = p.InboxMessageCount("ClientB")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count, "Other inboxes should have been left intact")
+
+	// unknown inbox
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		resp = adminRequest(t, method, server.URL+"/inboxes/ClientC", testAdminToken)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
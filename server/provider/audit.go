@@ -0,0 +1,118 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditLogSyslog is the audit log target sending the entries to the local syslog daemon,
+	// as opposed to appending them to a file.
+	AuditLogSyslog = "syslog"
+	// auditLogSyslogTag is the tag the entries are sent to syslog with.
+	auditLogSyslogTag = "nym-mixnet-provider"
+)
+
+// ErrSyslogUnsupported is returned when the audit log is to be sent to syslog on a platform without it.
+var ErrSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
+// AuditEntry records that a message was stored in an inbox. It never carries the content of the message.
+type AuditEntry struct {
+	// ClientID is the id of the inbox of the recipient of the message.
+	ClientID  string `json:"client_id"`
+	MessageID string `json:"message_id"`
+	// Size is the length (in bytes) of the stored message.
+	Size     int       `json:"size"`
+	StoredAt time.Time `json:"stored_at"`
+}
+
+// AuditLog keeps an append-only record of the messages stored by the provider, e.g. for compliance
+// or debugging in test networks. It must be safe for concurrent use.
+type AuditLog interface {
+	Record(entry AuditEntry) error
+}
+
+// JSONAuditLog is the AuditLog writing each entry as a single line of JSON.
+type JSONAuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONAuditLog returns the audit log writing the entries to w.
+func NewJSONAuditLog(w io.Writer) *JSONAuditLog {
+	return &JSONAuditLog{w: w}
+}
+
+// NewFileAuditLog returns the audit log appending the entries to the file at the given path,
+// which is created if it does not exist yet.
+func NewFileAuditLog(path string) (*JSONAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditLog(file), nil
+}
+
+// OpenAuditLog returns the audit log writing to the given target, i.e. either AuditLogSyslog or the path of a file.
+func OpenAuditLog(target string) (*JSONAuditLog, error) {
+	if target == AuditLogSyslog {
+		return NewSyslogAuditLog()
+	}
+	return NewFileAuditLog(target)
+}
+
+// Record is an implementation of the AuditLog interface.
+func (l *JSONAuditLog) Record(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer, if it can be closed.
+func (l *JSONAuditLog) Close() error {
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// auditStored records the stored message in the audit log, if there is one. The message is already stored,
+// so failing to record it is only logged.
+func (p *ProviderServer) auditStored(inboxID string, messageID string, size int) {
+	if p.auditLog == nil {
+		return
+	}
+	entry := AuditEntry{ClientID: inboxID, MessageID: messageID, Size: size, StoredAt: p.clock.Now()}
+	if err := p.auditLog.Record(entry); err != nil {
+		p.log.Errorf("Failed to record message %v in the audit log: %v", messageID, err)
+	}
+}
+
+// SetAuditLog makes the provider record each message it stores in the audit log, without its content.
+// The messages handed over to the DeliverySink are not recorded. The audit log is disabled by default,
+// or if it is nil. It should be called before the provider is started.
+func (p *ProviderServer) SetAuditLog(auditLog AuditLog) {
+	p.auditLog = auditLog
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import "log/syslog"
+
+// NewSyslogAuditLog returns the audit log sending the entries to the local syslog daemon.
+func NewSyslogAuditLog() (*JSONAuditLog, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, auditLogSyslogTag)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditLog(w), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingAuditLog fails to record any entry.
+type failingAuditLog struct{}
+
+func (failingAuditLog) Record(AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestProviderServer_AuditLog(t *testing.T) {
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+	var buf bytes.Buffer
+	p.SetAuditLog(NewJSONAuditLog(&buf))
+
+	address, inboxID := newTestRecipient(t)
+	message := []byte("Very secret message")
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, address, message))
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, address, message))
+
+	// each stored message is recorded, but never its content
+	assert.NotContains(t, buf.String(), string(message))
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry AuditEntry
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Len(t, entries, 2)
+
+	for _, entry := range entries {
+		assert.Equal(t, inboxID, entry.ClientID)
+		// the message is stored under the recorded id
+		_, err := os.Stat(p.messagePath(filepath.Join(p.inboxesDir, inboxID), entry.MessageID))
+		assert.Nil(t, err)
+		assert.Equal(t, len(message), entry.Size)
+		assert.True(t, clk.Now().Equal(entry.StoredAt))
+	}
+	assert.NotEqual(t, entries[0].MessageID, entries[1].MessageID)
+}
+
+func TestProviderServer_AuditLog_Failure(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+	p.SetAuditLog(failingAuditLog{})
+
+	// the message is stored regardless
+	address, inboxID := newTestRecipient(t)
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, address, []byte("Hello world")))
+	count, err := p.InboxMessageCount(inboxID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, DeliveryStats{Stored: 1}, p.Deliveries())
+}
+
+func TestAuditLog_OffByDefault(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	assert.Nil(t, p.auditLog)
+	assert.Equal(t, "", DefaultConfig().AuditLogTarget())
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: "/var/lib/provider", AuditLog: "audit.log"})
+	assert.Equal(t, filepath.Join("/var/lib/provider", "audit.log"), cfg.AuditLogTarget())
+	assert.Equal(t, AuditLogSyslog, cfg.Overlay(Config{AuditLog: AuditLogSyslog}).AuditLogTarget())
+}
+
+func TestFileAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// the entries are appended to the file across reopenings
+	for _, id := range []string{"first", "second"} {
+		auditLog, err := OpenAuditLog(path)
+		assert.Nil(t, err)
+		assert.Nil(t, auditLog.Record(AuditEntry{ClientID: "client", MessageID: id, Size: 42}))
+		assert.Nil(t, auditLog.Close())
+	}
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var entry AuditEntry
+	assert.Nil(t, json.Unmarshal(lines[1], &entry))
+	assert.Equal(t, "second", entry.MessageID)
+
+	_, err = NewFileAuditLog(filepath.Join(dir, "missing", "audit.log"))
+	assert.Error(t, err)
+}
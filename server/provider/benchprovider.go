@@ -17,17 +17,22 @@
 package provider
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
-	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/node"
 )
 
 const (
@@ -39,25 +44,43 @@ type timestampedMessage struct {
 	timestamp time.Time
 }
 
+// BenchStats summarises the results of the benchmark.
+type BenchStats struct {
+	NumMessages int `json:"num_messages"`
+	// TotalDuration is the time between receiving the first packet and processing the last one.
+	TotalDuration time.Duration `json:"total_duration_ns"`
+	// Throughput is the number of processed messages per second.
+	Throughput float64 `json:"throughput"`
+	// LatencyP50, LatencyP90 and LatencyP99 are the percentiles of the per-packet processing latencies,
+	// i.e. the time between receiving the packet and it being fully processed, including the sphinx delay.
+	LatencyP50 time.Duration `json:"latency_p50_ns"`
+	LatencyP90 time.Duration `json:"latency_p90_ns"`
+	LatencyP99 time.Duration `json:"latency_p99_ns"`
+}
+
 type BenchProvider struct {
 	*ProviderServer
 	doneCh                chan struct{}
 	numMessages           int
+	jsonOutput            bool
+	mu                    sync.Mutex
 	receivedMessages      []timestampedMessage
 	receivedMessagesCount int
+	latencies             []time.Duration
+	firstReceived         time.Time
+	lastProcessed         time.Time
 }
 
+// startSendingPresence registers the presence of the provider straight away, as it is only started
+// once the provider listens, and then periodically. When the presence interval changes, the next presence
+// is sent once the new interval elapses.
 func (p *BenchProvider) startSendingPresence() {
-	ticker := time.NewTicker(presenceInterval)
+	p.registerPresence() //nolint: errcheck
 	for {
 		select {
-		case <-ticker.C:
-			if err := helpers.RegisterMixProviderPresence(p.GetPublicKey(),
-				p.convertRecordsToModelData(),
-				net.JoinHostPort(p.host, p.port),
-			); err != nil {
-				p.log.Errorf("Failed to register presence: %v", err)
-			}
+		case <-p.clock.After(p.currentPresenceInterval()):
+			p.registerPresence() //nolint: errcheck
+		case <-p.presenceIntervalChanged:
 		case <-p.haltedCh:
 			return
 		}
@@ -69,9 +92,65 @@ func (p *BenchProvider) startSendingPresence() {
 // if any operation was unsuccessful.
 func (p *BenchProvider) RunBench() error {
 	fmt.Println("Expecting to receive", p.numMessages, "messages")
+	if err := p.listen(); err != nil {
+		return err
+	}
 	p.run()
 
-	return nil
+	return p.printStats(os.Stdout)
+}
+
+// latencyPercentile returns the p-th percentile of the sorted latencies using the nearest-rank method.
+func latencyPercentile(sortedLatencies []time.Duration, p float64) time.Duration {
+	if len(sortedLatencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sortedLatencies))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sortedLatencies[rank-1]
+}
+
+func (p *BenchProvider) computeStats() BenchStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sortedLatencies := make([]time.Duration, len(p.latencies))
+	copy(sortedLatencies, p.latencies)
+	sort.Slice(sortedLatencies, func(i, j int) bool { return sortedLatencies[i] < sortedLatencies[j] })
+
+	stats := BenchStats{
+		NumMessages: p.receivedMessagesCount,
+		LatencyP50:  latencyPercentile(sortedLatencies, 50),
+		LatencyP90:  latencyPercentile(sortedLatencies, 90),
+		LatencyP99:  latencyPercentile(sortedLatencies, 99),
+	}
+	if p.receivedMessagesCount > 0 {
+		stats.TotalDuration = p.lastProcessed.Sub(p.firstReceived)
+		if stats.TotalDuration > 0 {
+			stats.Throughput = float64(p.receivedMessagesCount) / stats.TotalDuration.Seconds()
+		}
+	}
+	return stats
+}
+
+func (p *BenchProvider) printStats(w io.Writer) error {
+	stats := p.computeStats()
+	if p.jsonOutput {
+		return json.NewEncoder(w).Encode(stats)
+	}
+
+	_, err := fmt.Fprintf(w, "Messages: %v\nTotal duration: %v\nThroughput: %.2f msg/s\n"+
+		"Latency p50: %v\nLatency p90: %v\nLatency p99: %v\n",
+		stats.NumMessages,
+		stats.TotalDuration,
+		stats.Throughput,
+		stats.LatencyP50,
+		stats.LatencyP90,
+		stats.LatencyP99,
+	)
+	return err
 }
 
 // Function opens the listener to start listening on provider's host and port
@@ -80,7 +159,7 @@ func (p *BenchProvider) run() {
 	defer p.listener.Close()
 
 	go func() {
-		p.log.Infof("Listening on %s", p.host+":"+p.port)
+		p.log.Infof("Listening on %s", p.listener.Addr())
 		p.listenForIncomingConnections()
 	}()
 	go p.startSendingPresence()
@@ -125,21 +204,32 @@ func (p *BenchProvider) createSummaryDoc() error {
 // Function processes the received sphinx packet, performs the
 // unwrapping operation and checks whether the packet should be
 // forwarded or stored. If the processing was unsuccessful and error is returned.
-func (p *BenchProvider) receivedPacket(packet []byte) error {
+// The time it took to process the packet since it was received is recorded for the benchmark statistics.
+func (p *BenchProvider) receivedPacket(packet []byte, receivedAt time.Time) error {
 	p.log.Info("Received new sphinx packet")
 
-	res := p.ProcessPacket(packet)
-	dePacket := res.PacketData()
-	nextHop := res.NextHop()
-	flag := res.Flag()
-	if err := res.Err(); err != nil {
+	res, err := p.ProcessPacket(packet)
+	if err != nil {
 		return err
 	}
+	dePacket := res.PacketData()
+	nextHop := res.NextHop()
 
-	if flag == flags.LastHopFlag {
+	if res.Kind() == node.StorePacket {
 		if nextHop.Id == "BenchmarkClientRecipient" {
-			msgContent := string(dePacket[38:])
-			p.receivedMessages = append(p.receivedMessages, timestampedMessage{timestamp: time.Now(), content: msgContent})
+			msgContent := string(dePacket)
+			processedAt := p.clock.Now()
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.receivedMessages = append(p.receivedMessages, timestampedMessage{timestamp: processedAt, content: msgContent})
+			p.latencies = append(p.latencies, processedAt.Sub(receivedAt))
+			if p.firstReceived.IsZero() || receivedAt.Before(p.firstReceived) {
+				p.firstReceived = receivedAt
+			}
+			if processedAt.After(p.lastProcessed) {
+				p.lastProcessed = processedAt
+			}
 			p.receivedMessagesCount++
 			if p.receivedMessagesCount == p.numMessages {
 				fmt.Println("Received all expected messages")
@@ -174,6 +264,8 @@ func (p *BenchProvider) listenForIncomingConnections() {
 
 // HandleConnection handles the received packets; it checks the flag of the
 // packet and schedules a corresponding process function and returns an error.
+// The connection either carries a single raw packet or a stream of framed packets,
+// in which case the benchmark is not affected by the cost of establishing a connection for each packet.
 func (p *BenchProvider) handleConnection(conn net.Conn) {
 	defer func() {
 		p.log.Debugf("Closing Connection to %v", conn.RemoteAddr())
@@ -182,22 +274,47 @@ func (p *BenchProvider) handleConnection(conn net.Conn) {
 		}
 	}()
 
+	r := bufio.NewReader(conn)
+	isStream, err := config.IsFrameStream(r)
+	if err != nil {
+		p.log.Errorf("Error while reading from the connection: %v", err)
+		return
+	}
+	if isStream {
+		frames := config.NewFrameReader(r)
+		for {
+			frame, err := frames.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				p.log.Errorf("Error while reading from the connection: %v", err)
+				return
+			}
+			p.handlePacket(frame, p.clock.Now())
+		}
+	}
+
 	buff := make([]byte, 1024)
-	reqLen, err := conn.Read(buff)
+	reqLen, err := r.Read(buff)
 	if err != nil {
 		p.log.Errorf("Error while reading from the connection: %v", err)
 		return
 	}
+	p.handlePacket(buff[:reqLen], p.clock.Now())
+}
 
-	var packet config.GeneralPacket
-	if err = proto.Unmarshal(buff[:reqLen], &packet); err != nil {
+// handlePacket unwraps the packet received at receivedAt and processes it, provided it is a sphinx packet.
+func (p *BenchProvider) handlePacket(packetBytes []byte, receivedAt time.Time) {
+	packet, err := config.UnwrapPacket(packetBytes)
+	if err != nil {
 		p.log.Errorf("Error while unmarshalling received packet: %v", err)
 		return
 	}
 
 	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
 	case flags.CommFlag:
-		if err := p.receivedPacket(packet.Data); err != nil {
+		if err := p.receivedPacket(packet.Data, receivedAt); err != nil {
 			panic(err)
 		}
 
@@ -207,13 +324,19 @@ func (p *BenchProvider) handleConnection(conn net.Conn) {
 	}
 }
 
-func NewBenchProvider(provider *ProviderServer, numMessages int) (*BenchProvider, error) {
+// NewBenchProvider creates a provider expecting to receive numMessages benchmark messages.
+// If jsonOutput is set, the final statistics are printed as JSON, so that they could be easily processed by scripts.
+// The replay protection of the provider is disabled, as the benchmark clients resend identical packets.
+func NewBenchProvider(provider *ProviderServer, numMessages int, jsonOutput bool) (*BenchProvider, error) {
 	bp := &BenchProvider{
 		doneCh:           make(chan struct{}),
 		ProviderServer:   provider,
 		numMessages:      numMessages,
+		jsonOutput:       jsonOutput,
 		receivedMessages: make([]timestampedMessage, 0, numMessages),
+		latencies:        make([]time.Duration, 0, numMessages),
 	}
 	bp.ProviderServer.log.Out = ioutil.Discard
+	bp.ProviderServer.DisableReplayProtection()
 	return bp, nil
 }
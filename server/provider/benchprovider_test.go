@@ -0,0 +1,248 @@
+// Copyright (C) 2019  Jedrzej Stuczynski.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBenchProvider creates a bench provider expecting numMessages messages. Each bench provider
+// gets its own underlying provider, as NewBenchProvider modifies its logger.
+func newTestBenchProvider(t testing.TB, numMessages int, jsonOutput bool) *BenchProvider {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := NewBenchProvider(p, numMessages, jsonOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bp
+}
+
+// createBenchPacket creates a packet, as seen by the egress provider, destined for the benchmark recipient.
+func createBenchPacket(t testing.TB, bp *BenchProvider) []byte {
+	msg := createFinalHopPacket(t, bp.ProviderServer, "BenchmarkClientRecipient", []byte("Hello world"))
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packetBytes
+}
+
+func TestBenchProvider_CollectsStats(t *testing.T) {
+	numMessages := 5
+	bp := newTestBenchProvider(t, numMessages, true)
+
+	for i := 0; i < numMessages; i++ {
+		serverConn, clientConn := net.Pipe()
+		go bp.handleConnection(serverConn)
+		if _, err := clientConn.Write(createBenchPacket(t, bp)); err != nil {
+			t.Fatal(err)
+		}
+		clientConn.Close()
+	}
+
+	select {
+	case <-bp.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("benchmark messages were not processed in time")
+	}
+
+	stats := bp.computeStats()
+	assert.Equal(t, numMessages, stats.NumMessages)
+	assert.True(t, stats.TotalDuration > 0)
+	assert.True(t, stats.Throughput > 0)
+	assert.True(t, stats.LatencyP50 > 0)
+	assert.True(t, stats.LatencyP50 <= stats.LatencyP90)
+	assert.True(t, stats.LatencyP90 <= stats.LatencyP99)
+
+	var out bytes.Buffer
+	assert.Nil(t, bp.printStats(&out))
+	var decoded BenchStats
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, stats, decoded)
+}
+
+func TestBenchProvider_AcceptsReplays(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, p.ReplayProtectionEnabled())
+
+	bp, err := NewBenchProvider(p, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, bp.ReplayProtectionEnabled())
+
+	packet := createBenchPacket(t, bp)
+	for i := 0; i < 2; i++ {
+		serverConn, clientConn := net.Pipe()
+		go bp.handleConnection(serverConn)
+		if _, err := clientConn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+		clientConn.Close()
+	}
+
+	select {
+	case <-bp.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replayed benchmark messages were not processed in time")
+	}
+}
+
+func TestBenchProvider_Reload_PresenceInterval(t *testing.T) {
+	presences := make(chan struct{}, 1)
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	bp, err := NewBenchProvider(provider, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.registrar = &recordingRegistrar{notify: presences}
+	bp.haltedCh = make(chan struct{})
+	defer close(bp.haltedCh)
+	go bp.startSendingPresence()
+	<-presences
+	clk.BlockUntil(1)
+
+	cfg := bp.RuntimeConfig()
+	cfg.PresenceInterval = 10 * time.Second
+	assert.Nil(t, bp.Reload(cfg))
+	// like with the regular provider, the presence is now awaited with the new interval
+	clk.BlockUntil(2)
+
+	clk.Advance(DefaultPresenceInterval)
+	select {
+	case <-presences:
+		t.Fatal("The presence should not have been sent after the previous interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(cfg.PresenceInterval - DefaultPresenceInterval)
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the new interval elapsed")
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+	assert.Equal(t, 50*time.Millisecond, latencyPercentile(latencies, 50))
+	assert.Equal(t, 90*time.Millisecond, latencyPercentile(latencies, 90))
+	assert.Equal(t, 99*time.Millisecond, latencyPercentile(latencies, 99))
+	assert.Equal(t, 1*time.Millisecond, latencyPercentile(latencies, 0))
+	assert.Equal(t, time.Duration(0), latencyPercentile(nil, 50))
+}
+
+func TestBenchProvider_Stream(t *testing.T) {
+	numMessages := 5
+	bp := newTestBenchProvider(t, numMessages, false)
+
+	serverConn, clientConn := net.Pipe()
+	go bp.handleConnection(serverConn)
+	w := bufio.NewWriter(clientConn)
+	for i := 0; i < numMessages; i++ {
+		assert.Nil(t, config.WriteFrame(w, createBenchPacket(t, bp)))
+	}
+	assert.Nil(t, w.Flush())
+	clientConn.Close()
+
+	select {
+	case <-bp.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("benchmark messages were not processed in time")
+	}
+	assert.Equal(t, numMessages, bp.computeStats().NumMessages)
+}
+
+// startBenchListener passes all connections made to the returned TCP address to the bench provider.
+func startBenchListener(b *testing.B, bp *BenchProvider) (string, func()) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go bp.handleConnection(conn)
+		}
+	}()
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func BenchmarkBenchProvider_PerConnection(b *testing.B) {
+	bp := newTestBenchProvider(b, b.N, false)
+	packet := createBenchPacket(b, bp)
+	address, stop := startBenchListener(b, bp)
+	defer stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := conn.Write(packet); err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+	<-bp.doneCh
+}
+
+func BenchmarkBenchProvider_Streamed(b *testing.B) {
+	bp := newTestBenchProvider(b, b.N, false)
+	packet := createBenchPacket(b, bp)
+	address, stop := startBenchListener(b, bp)
+	defer stop()
+
+	b.ResetTimer()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(conn)
+	for i := 0; i < b.N; i++ {
+		if err := config.WriteFrame(w, packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	conn.Close()
+	<-bp.doneCh
+}
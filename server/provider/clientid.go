@@ -0,0 +1,101 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// ClientIDLength is the length of the ids returned by ClientID.
+const ClientIDLength = 52
+
+// clientIDEncoding is the lowercase, unpadded base32 alphabet, which is safe for the file names
+// on any filesystem, including the case-insensitive ones.
+//nolint: gochecknoglobals
+var clientIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ClientID returns the id the provider knows the client with the given public key by. It is the key
+// of the record of the client in the registry and the name of its inbox directory.
+// The id is the lowercase, unpadded base32 encoding of the SHA-256 hash of the key, hence it is always
+// ClientIDLength characters long, consisting only of the letters a-z and the digits 2-7.
+//
+// The clients are still addressed in the packets and the directory server by the base64 encoding of their keys.
+// Prior to the ids being hashed, the inboxes were named by the base64 encoding too; such inboxes are renamed
+// by MigrateLegacyInboxes.
+func ClientID(pubKey []byte) string {
+	hash := sha256.Sum256(pubKey)
+	return clientIDEncoding.EncodeToString(hash[:])
+}
+
+// recipientInboxID returns the id of the inbox of the recipient addressed in a packet by the base64 encoding
+// of its public key. It returns ErrInvalidRecipient if the address is not an encoded public key.
+func recipientInboxID(recipientID string) (string, error) {
+	pubKey, err := base64.URLEncoding.DecodeString(recipientID)
+	if err != nil || len(pubKey) != sphinx.PublicKeySize {
+		return "", ErrInvalidRecipient
+	}
+	return ClientID(pubKey), nil
+}
+
+// legacyInboxKey returns the public key the inbox with the given name belongs to, if it is named
+// by the base64 encoding of the key, as the inboxes used to be. The ClientIDs never decode as such.
+func legacyInboxKey(name string) ([]byte, bool) {
+	pubKey, err := base64.URLEncoding.DecodeString(name)
+	if err != nil || len(pubKey) != sphinx.PublicKeySize {
+		return nil, false
+	}
+	return pubKey, true
+}
+
+// MigrateLegacyInboxes renames the inboxes named by the base64 encoding of the public keys of their clients,
+// as they used to be, to their ClientIDs, so that their messages could still be pulled. The inboxes which
+// already exist under their new names are left intact. It returns the number of renamed inboxes and should
+// be called before the provider is started.
+func (p *ProviderServer) MigrateLegacyInboxes() (int, error) {
+	entries, err := ioutil.ReadDir(p.inboxesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	migrated := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pubKey, ok := legacyInboxKey(entry.Name())
+		if !ok {
+			continue
+		}
+		newPath := filepath.Join(p.inboxesDir, ClientID(pubKey))
+		if _, err := os.Stat(newPath); err == nil {
+			p.log.Warnf("Not migrating inbox %v, as %v already exists", entry.Name(), newPath)
+			continue
+		}
+		if err := os.Rename(filepath.Join(p.inboxesDir, entry.Name()), newPath); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
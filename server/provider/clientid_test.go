@@ -0,0 +1,115 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientID(t *testing.T) {
+	_, pub1, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub2, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := ClientID(pub1.Bytes())
+	assert.Equal(t, id, ClientID(pub1.Bytes()), "The id should be stable")
+	assert.NotEqual(t, id, ClientID(pub2.Bytes()))
+	assert.Len(t, id, ClientIDLength)
+	assert.Regexp(t, regexp.MustCompile("^[a-z2-7]+$"), id)
+	_, isLegacy := legacyInboxKey(id)
+	assert.False(t, isLegacy)
+}
+
+func TestRecipientInboxID(t *testing.T) {
+	address, id := newTestRecipient(t)
+	inboxID, err := recipientInboxID(address)
+	assert.Nil(t, err)
+	assert.Equal(t, id, inboxID)
+
+	for _, invalid := range []string{"", "Alice", "../../etc", base64.URLEncoding.EncodeToString([]byte("foomp"))} {
+		_, err := recipientInboxID(invalid)
+		assert.Equal(t, ErrInvalidRecipient, err)
+	}
+}
+
+func TestProviderServer_MigrateLegacyInboxes(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+
+	legacyAddress, legacyID := newTestRecipient(t)
+	legacyInbox := filepath.Join(p.inboxesDir, legacyAddress)
+	assert.Nil(t, os.MkdirAll(legacyInbox, 0775))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(legacyInbox, "msg"), []byte("Hello world"), 0600))
+
+	// an inbox present under both names is left intact
+	conflictAddress, conflictID := newTestRecipient(t)
+	assert.Nil(t, os.MkdirAll(filepath.Join(p.inboxesDir, conflictAddress), 0775))
+	assert.Nil(t, os.MkdirAll(filepath.Join(p.inboxesDir, conflictID), 0775))
+	// and so are the ones not named after a key
+	assert.Nil(t, os.MkdirAll(filepath.Join(p.inboxesDir, "Alice"), 0775))
+
+	migrated, err := p.MigrateLegacyInboxes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, migrated)
+	assert.FileExists(t, filepath.Join(p.inboxesDir, legacyID, "msg"))
+	_, err = os.Stat(legacyInbox)
+	assert.True(t, os.IsNotExist(err))
+	assert.DirExists(t, filepath.Join(p.inboxesDir, conflictAddress))
+	assert.DirExists(t, filepath.Join(p.inboxesDir, "Alice"))
+
+	migrated, err = p.MigrateLegacyInboxes()
+	assert.Nil(t, err)
+	assert.Zero(t, migrated)
+}
+
+func TestProviderServer_ImportLegacyRegistry(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := []byte("legacy token")
+	legacy, err := json.Marshal(exportedRegistry{
+		Version: legacyRegistryVersion,
+		Clients: []exportedClient{{
+			ID:     base64.URLEncoding.EncodeToString(pub.Bytes()),
+			PubKey: pub.Bytes(),
+			Token:  token,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, p.ImportRegistry(bytes.NewReader(legacy), ReplaceRegistry))
+	assert.Equal(t, []string{ClientID(pub.Bytes())}, sortedClientIDs(p))
+	assert.True(t, p.authenticateUser(pub.Bytes(), token))
+	assert.DirExists(t, filepath.Join(p.inboxesDir, ClientID(pub.Bytes())))
+}
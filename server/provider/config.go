@@ -0,0 +1,440 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+const (
+	// DefaultPort defines the port on which the provider listens unless configured otherwise.
+	DefaultPort = "1789"
+	// DefaultInboxesDir defines the directory in which the inboxes are kept unless configured otherwise.
+	DefaultInboxesDir = "./inboxes"
+	// DefaultAdminHost defines the host the admin API listens on if its address has none, so that the API
+	// is only reachable from the local machine unless it is exposed explicitly.
+	DefaultAdminHost = "127.0.0.1"
+	// DefaultPrivateKeyFile defines the file the private key of the provider is kept in.
+	DefaultPrivateKeyFile = "privateKey.key"
+	// DefaultPublicKeyFile defines the file the public key of the provider is kept in.
+	DefaultPublicKeyFile = "publicKey.key"
+	// FileStorage is the storage backend keeping each message as a separate file in the inbox directory
+	// of its recipient. It is currently the only supported backend.
+	FileStorage = "file"
+	// MaxInboxShardPrefixLength is the longest prefix of the message ids the inboxes can be sharded by,
+	// i.e. each inbox is split into at most 16^MaxInboxShardPrefixLength shards.
+	MaxInboxShardPrefixLength = 4
+
+	// EnvHome is the environment variable overriding the home directory of the provider.
+	EnvHome = "LOOPIX_PROVIDER_HOME"
+	// EnvHost is the environment variable setting the host the provider runs on.
+	EnvHost = "LOOPIX_PROVIDER_HOST"
+	// EnvPort is the environment variable overriding the port of the provider.
+	EnvPort = "LOOPIX_PROVIDER_PORT"
+	// EnvInboxRoot is the environment variable overriding the directory of the inboxes.
+	EnvInboxRoot = "LOOPIX_INBOX_ROOT"
+	// EnvLogLevel is the environment variable overriding the log level of the provider.
+	EnvLogLevel = "LOOPIX_LOG_LEVEL"
+	// EnvAdminAddress is the environment variable setting the address of the admin API.
+	EnvAdminAddress = "LOOPIX_ADMIN_ADDRESS"
+	// EnvAdminExpose is the environment variable allowing the admin API to listen beyond the loopback interface.
+	EnvAdminExpose = "LOOPIX_ADMIN_EXPOSE"
+	// EnvAdminToken is the environment variable setting the token required by the admin API.
+	EnvAdminToken = "LOOPIX_ADMIN_TOKEN"
+	// EnvDirectoryURL is the environment variable overriding the URL of the directory server.
+	EnvDirectoryURL = "LOOPIX_DIRECTORY_URL"
+	// EnvRequireDirectory is the environment variable making the provider fail to start without registering its presence.
+	EnvRequireDirectory = "LOOPIX_REQUIRE_DIRECTORY"
+	// EnvBindAddress is the environment variable setting the address the provider listens on.
+	EnvBindAddress = "LOOPIX_PROVIDER_BIND_ADDRESS"
+	// EnvAdvertiseAddress is the environment variable setting the address the provider advertises.
+	EnvAdvertiseAddress = "LOOPIX_PROVIDER_ADVERTISE_ADDRESS"
+	// EnvDeliveryWebhook is the environment variable setting the webhook the messages are delivered to.
+	EnvDeliveryWebhook = "LOOPIX_DELIVERY_WEBHOOK"
+	// EnvAuditLog is the environment variable setting the audit log of the stored messages.
+	EnvAuditLog = "LOOPIX_AUDIT_LOG"
+)
+
+var (
+	// ErrUnknownStorageBackend is returned when the configured storage backend is not supported.
+	ErrUnknownStorageBackend = errors.New("unknown storage backend")
+	// ErrAdminTokenRequired is returned when the admin API is enabled without configuring its token.
+	ErrAdminTokenRequired = errors.New("admin API requires an admin token")
+	// ErrInvalidAdminAddress is returned when the address of the admin API is not of the form host:port,
+	// with a numeric port.
+	ErrInvalidAdminAddress = errors.New("invalid admin address")
+	// ErrAdminNotExposed is returned when the admin API is to listen beyond the loopback interface,
+	// without AdminExpose being set.
+	ErrAdminNotExposed = errors.New("admin API listening beyond loopback has to be exposed explicitly")
+	// ErrInvalidBindAddress is returned when the bind address is not of the form host:port, with a numeric port.
+	ErrInvalidBindAddress = errors.New("invalid bind address")
+	// ErrInvalidAdvertiseAddress is returned when the advertised address is not of the form host:port,
+	// with a non-empty host and a numeric, non-zero port.
+	ErrInvalidAdvertiseAddress = errors.New("invalid advertise address")
+	// ErrInvalidInboxShardPrefixLength is returned when the inboxes can't be sharded by the given prefix length.
+	ErrInvalidInboxShardPrefixLength = errors.New("invalid inbox shard prefix length")
+)
+
+// Config holds the provider settings which can be supplied by the operator.
+// Empty fields are treated as not set.
+type Config struct {
+	// HomeDir is the directory all the state of the provider is kept under. Any relative paths,
+	// such as InboxesDir, are resolved against it.
+	HomeDir string
+	// Host is the host the provider runs on, as advertised in its presence unless AdvertiseAddress is set.
+	// If empty, the local IP address is detected.
+	Host       string
+	Port       string
+	InboxesDir string
+	LogLevel   string
+	// StorageBackend selects how the inboxes are stored. InboxesDir is the location of the file storage.
+	StorageBackend string
+	// AdminAddress is the address the admin API listens on. The admin API is disabled if it is empty.
+	// An empty host, as in ":8080", stands for DefaultAdminHost unless AdminExpose is set.
+	AdminAddress string
+	// AdminExpose allows the admin API to listen beyond the loopback interface, which otherwise is rejected
+	// by Validate, as the API reveals the internals of the provider to anyone able to reach it.
+	AdminExpose bool
+	// AdminToken is the bearer token each request to the admin API has to carry.
+	AdminToken string
+	// ReplayTagLength is the length (in bytes) of the tags the processed packets are remembered by.
+	ReplayTagLength int
+	// DirectoryURL is the URL of the directory server the presence is registered at. If empty,
+	// the public directory server is used, or the local one if the provider runs on a loopback address.
+	DirectoryURL string
+	// RequireDirectory makes the provider fail to start if its first presence can't be registered at the directory
+	// server. Otherwise it starts unregistered during the outages of the directory server, retrying its presence.
+	RequireDirectory bool
+	// LogFile is the file the logs are written to. If empty, they are written to the standard output.
+	LogFile string
+	// BindAddress is the host:port the provider listens on, independently of the address advertised
+	// in its presence. An empty host, as in ":1789", stands for all the interfaces. If BindAddress is empty,
+	// the provider listens on the advertised host and Port, unless AdvertiseAddress is set.
+	BindAddress string
+	// AdvertiseAddress is the host:port the provider advertises in its presence and MixConfig, i.e. the address
+	// the clients and mixes reach it on, such as the public address of the NAT or load balancer in front of it.
+	// If it is set, the provider listens on its own host and Port unless BindAddress is set as well.
+	AdvertiseAddress string
+	// DeliveryWebhook is the URL the messages reaching the provider on their last hop are posted to,
+	// in place of the inboxes of their recipients. If it is empty, the messages are stored in the inboxes.
+	DeliveryWebhook string
+	// InboxShardPrefixLength is the number of the leading characters of the message ids naming the shards,
+	// i.e. the subdirectories of the inboxes the messages are stored in. The inboxes are not sharded if it is 0.
+	InboxShardPrefixLength int
+	// AuditLog is where each stored message is recorded, without its content: either AuditLogSyslog
+	// or the file the entries are appended to. The messages are not recorded if it is empty.
+	AuditLog string
+}
+
+// DefaultHomeDir returns the home directory of the provider with the given id, under the home of the user,
+// which is used unless configured otherwise. If the home of the user cannot be determined,
+// the directory is relative to the working directory.
+func DefaultHomeDir(id string) string {
+	// the returned home is empty on error
+	userHome, _ := os.UserHomeDir()
+	return filepath.Join(userHome, ".nym", "providers", id)
+}
+
+// ResolvePath returns the path relative to the home directory, or the path itself if it is absolute.
+func (c Config) ResolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.HomeDir, path)
+}
+
+// InboxesPath returns the path of the directory of the inboxes.
+func (c Config) InboxesPath() string {
+	return c.ResolvePath(c.InboxesDir)
+}
+
+// PrivateKeyPath returns the path of the file with the private key of the provider.
+func (c Config) PrivateKeyPath() string {
+	return c.ResolvePath(DefaultPrivateKeyFile)
+}
+
+// PublicKeyPath returns the path of the file with the public key of the provider.
+func (c Config) PublicKeyPath() string {
+	return c.ResolvePath(DefaultPublicKeyFile)
+}
+
+// LogFilePath returns the path of the log file, or an empty string if the logs are written to the standard output.
+func (c Config) LogFilePath() string {
+	if c.LogFile == "" {
+		return ""
+	}
+	return c.ResolvePath(c.LogFile)
+}
+
+// AuditLogTarget returns the target of the audit log, i.e. either AuditLogSyslog or the path of its file,
+// or an empty string if the audit log is disabled.
+func (c Config) AuditLogTarget() string {
+	if c.AuditLog == "" || c.AuditLog == AuditLogSyslog {
+		return c.AuditLog
+	}
+	return c.ResolvePath(c.AuditLog)
+}
+
+// AdvertisedHostPort returns the host and port advertised by the provider running on the given host.
+func (c Config) AdvertisedHostPort(host string) (string, string) {
+	if c.AdvertiseAddress == "" {
+		return host, c.Port
+	}
+	// the address is validated by Validate
+	advertisedHost, advertisedPort, _ := net.SplitHostPort(c.AdvertiseAddress)
+	return advertisedHost, advertisedPort
+}
+
+// AdminListenAddress returns the address the admin API listens on, with DefaultAdminHost in place
+// of an empty host, unless the API is exposed.
+func (c Config) AdminListenAddress() string {
+	// the address is validated by Validate
+	host, port, _ := net.SplitHostPort(c.AdminAddress)
+	if host == "" && !c.AdminExpose {
+		return net.JoinHostPort(DefaultAdminHost, port)
+	}
+	return c.AdminAddress
+}
+
+// ListenAddress returns the address the provider running on the given host listens on.
+func (c Config) ListenAddress(host string) string {
+	if c.BindAddress != "" {
+		return c.BindAddress
+	}
+	return net.JoinHostPort(host, c.Port)
+}
+
+// DefaultConfig returns the configuration used when nothing else is specified.
+func DefaultConfig() Config {
+	return Config{
+		Port:            DefaultPort,
+		InboxesDir:      DefaultInboxesDir,
+		LogLevel:        defaultLogLevel,
+		StorageBackend:  FileStorage,
+		ReplayTagLength: sphinx.DefaultReplayTagLength,
+	}
+}
+
+// ConfigFromEnv reads the configuration from the environment variables using the given lookup function,
+// such as os.LookupEnv. The fields of unset, or empty, variables are left empty.
+func ConfigFromEnv(lookupEnv func(string) (string, bool)) Config {
+	var cfg Config
+	if home, ok := lookupEnv(EnvHome); ok {
+		cfg.HomeDir = home
+	}
+	if host, ok := lookupEnv(EnvHost); ok {
+		cfg.Host = host
+	}
+	if port, ok := lookupEnv(EnvPort); ok {
+		cfg.Port = port
+	}
+	if dir, ok := lookupEnv(EnvInboxRoot); ok {
+		cfg.InboxesDir = dir
+	}
+	if level, ok := lookupEnv(EnvLogLevel); ok {
+		cfg.LogLevel = level
+	}
+	if address, ok := lookupEnv(EnvAdminAddress); ok {
+		cfg.AdminAddress = address
+	}
+	// any value not parsed as true by strconv.ParseBool leaves the admin API unexposed
+	if expose, ok := lookupEnv(EnvAdminExpose); ok {
+		cfg.AdminExpose, _ = strconv.ParseBool(expose)
+	}
+	if token, ok := lookupEnv(EnvAdminToken); ok {
+		cfg.AdminToken = token
+	}
+	if directoryURL, ok := lookupEnv(EnvDirectoryURL); ok {
+		cfg.DirectoryURL = directoryURL
+	}
+	// any value not parsed as true by strconv.ParseBool lets the provider start without the directory server
+	if require, ok := lookupEnv(EnvRequireDirectory); ok {
+		cfg.RequireDirectory, _ = strconv.ParseBool(require)
+	}
+	if address, ok := lookupEnv(EnvBindAddress); ok {
+		cfg.BindAddress = address
+	}
+	if address, ok := lookupEnv(EnvAdvertiseAddress); ok {
+		cfg.AdvertiseAddress = address
+	}
+	if webhook, ok := lookupEnv(EnvDeliveryWebhook); ok {
+		cfg.DeliveryWebhook = webhook
+	}
+	if auditLog, ok := lookupEnv(EnvAuditLog); ok {
+		cfg.AuditLog = auditLog
+	}
+	return cfg
+}
+
+// Overlay returns the configuration with all the fields set in the other one taking precedence.
+// Configs are meant to be overlaid from the least to the most important source, i.e.
+// the defaults, then the environment and finally the command line flags.
+func (c Config) Overlay(other Config) Config {
+	if other.HomeDir != "" {
+		c.HomeDir = other.HomeDir
+	}
+	if other.Host != "" {
+		c.Host = other.Host
+	}
+	if other.Port != "" {
+		c.Port = other.Port
+	}
+	if other.InboxesDir != "" {
+		c.InboxesDir = other.InboxesDir
+	}
+	if other.LogLevel != "" {
+		c.LogLevel = other.LogLevel
+	}
+	if other.StorageBackend != "" {
+		c.StorageBackend = other.StorageBackend
+	}
+	if other.AdminAddress != "" {
+		c.AdminAddress = other.AdminAddress
+	}
+	if other.AdminExpose {
+		c.AdminExpose = true
+	}
+	if other.AdminToken != "" {
+		c.AdminToken = other.AdminToken
+	}
+	if other.ReplayTagLength != 0 {
+		c.ReplayTagLength = other.ReplayTagLength
+	}
+	if other.DirectoryURL != "" {
+		c.DirectoryURL = other.DirectoryURL
+	}
+	if other.RequireDirectory {
+		c.RequireDirectory = true
+	}
+	if other.LogFile != "" {
+		c.LogFile = other.LogFile
+	}
+	if other.BindAddress != "" {
+		c.BindAddress = other.BindAddress
+	}
+	if other.AdvertiseAddress != "" {
+		c.AdvertiseAddress = other.AdvertiseAddress
+	}
+	if other.DeliveryWebhook != "" {
+		c.DeliveryWebhook = other.DeliveryWebhook
+	}
+	if other.InboxShardPrefixLength != 0 {
+		c.InboxShardPrefixLength = other.InboxShardPrefixLength
+	}
+	if other.AuditLog != "" {
+		c.AuditLog = other.AuditLog
+	}
+	return c
+}
+
+// Validate checks whether the configuration can be used to start the provider.
+func (c Config) Validate() error {
+	if c.StorageBackend != FileStorage {
+		return ErrUnknownStorageBackend
+	}
+	if c.AdminAddress != "" {
+		if c.AdminToken == "" {
+			return ErrAdminTokenRequired
+		}
+		if err := ValidateAdminAddress(c.AdminAddress, c.AdminExpose); err != nil {
+			return err
+		}
+	}
+	if c.DirectoryURL != "" {
+		if err := helpers.ValidateDirectoryURL(c.DirectoryURL); err != nil {
+			return err
+		}
+	}
+	if c.BindAddress != "" {
+		if err := ValidateBindAddress(c.BindAddress); err != nil {
+			return err
+		}
+	}
+	if c.AdvertiseAddress != "" {
+		if err := ValidateAdvertiseAddress(c.AdvertiseAddress); err != nil {
+			return err
+		}
+	}
+	if c.DeliveryWebhook != "" {
+		if err := ValidateDeliveryWebhook(c.DeliveryWebhook); err != nil {
+			return err
+		}
+	}
+	if err := ValidateInboxShardPrefixLength(c.InboxShardPrefixLength); err != nil {
+		return err
+	}
+	return sphinx.ValidateReplayTagLength(c.ReplayTagLength)
+}
+
+// ValidateAdminAddress checks whether the admin API can listen on the given address, i.e. whether it is
+// of the form host:port, with a port from 0 to 65535, returning ErrInvalidAdminAddress otherwise.
+// Unless the API is exposed, the host has to be either empty or a loopback one, or ErrAdminNotExposed is returned.
+func ValidateAdminAddress(address string, expose bool) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrInvalidAdminAddress
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || strconv.FormatUint(p, 10) != port {
+		return ErrInvalidAdminAddress
+	}
+	if !expose && host != "" && !helpers.IsLoopbackHost(host) {
+		return ErrAdminNotExposed
+	}
+	return nil
+}
+
+// ValidateBindAddress checks whether the provider can listen on the given address, i.e. whether it is
+// of the form host:port, with an optional host and a port from 0 to 65535. It returns ErrInvalidBindAddress otherwise.
+func ValidateBindAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrInvalidBindAddress
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || strconv.FormatUint(p, 10) != port {
+		return ErrInvalidBindAddress
+	}
+	return nil
+}
+
+// ValidateAdvertiseAddress checks whether the given address can be advertised by the provider, i.e. whether
+// it is of the form host:port, with a non-empty host and a port from 1 to 65535.
+// It returns ErrInvalidAdvertiseAddress otherwise.
+func ValidateAdvertiseAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return ErrInvalidAdvertiseAddress
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 || strconv.FormatUint(p, 10) != port {
+		return ErrInvalidAdvertiseAddress
+	}
+	return nil
+}
+
+// ValidateInboxShardPrefixLength checks whether the inboxes can be sharded by the prefixes of the given length,
+// i.e. whether it is between 0, which disables the sharding, and MaxInboxShardPrefixLength.
+// It returns ErrInvalidInboxShardPrefixLength otherwise.
+func ValidateInboxShardPrefixLength(length int) error {
+	if length < 0 || length > MaxInboxShardPrefixLength {
+		return ErrInvalidInboxShardPrefixLength
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// setEnv sets the environment variable and returns a function restoring its previous state.
+func setEnv(t *testing.T, key, value string) func() {
+	old, wasSet := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if wasSet {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestConfigFromEnv_Unset(t *testing.T) {
+	for _, key := range []string{EnvHome, EnvPort, EnvInboxRoot, EnvLogLevel} {
+		defer setEnv(t, key, "")()
+		os.Unsetenv(key)
+	}
+
+	assert.Equal(t, Config{}, ConfigFromEnv(os.LookupEnv))
+	assert.Equal(t, DefaultConfig(), DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv)))
+}
+
+func TestConfigFromEnv_OverridesDefaults(t *testing.T) {
+	defer setEnv(t, EnvPort, "4242")()
+	defer setEnv(t, EnvLogLevel, "warn")()
+	os.Unsetenv(EnvInboxRoot)
+
+	cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+	assert.Equal(t, "4242", cfg.Port)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	// unset variables fall back to the defaults
+	assert.Equal(t, DefaultInboxesDir, cfg.InboxesDir)
+}
+
+func TestConfig_FlagsOverrideEnv(t *testing.T) {
+	defer setEnv(t, EnvPort, "4242")()
+	defer setEnv(t, EnvInboxRoot, "/var/lib/inboxes")()
+	defer setEnv(t, EnvLogLevel, "warn")()
+
+	flags := Config{Port: "1234", LogLevel: "debug"}
+	cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv)).Overlay(flags)
+	assert.Equal(t, Config{Port: "1234",
+		InboxesDir:      "/var/lib/inboxes",
+		LogLevel:        "debug",
+		StorageBackend:  FileStorage,
+		ReplayTagLength: sphinx.DefaultReplayTagLength,
+	}, cfg)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.Nil(t, DefaultConfig().Validate())
+	assert.Nil(t, DefaultConfig().Overlay(Config{StorageBackend: FileStorage}).Validate())
+
+	for _, backend := range []string{"bolt", "sqlite", "FILE"} {
+		cfg := DefaultConfig().Overlay(Config{StorageBackend: backend})
+		assert.Equal(t, ErrUnknownStorageBackend, cfg.Validate(), "Backend %q should have been rejected", backend)
+	}
+
+	assert.Nil(t, DefaultConfig().Overlay(Config{ReplayTagLength: sphinx.MaxReplayTagLength}).Validate())
+	for _, length := range []int{-1, sphinx.MinReplayTagLength - 1, sphinx.MaxReplayTagLength + 1} {
+		cfg := DefaultConfig().Overlay(Config{ReplayTagLength: length})
+		assert.Equal(t, sphinx.ErrInvalidReplayTagLength, cfg.Validate(), "Length %v should have been rejected", length)
+	}
+
+	assert.Nil(t, DefaultConfig().Overlay(Config{DirectoryURL: "http://localhost:8080"}).Validate())
+	cfg := DefaultConfig().Overlay(Config{DirectoryURL: "localhost:8080"})
+	assert.Equal(t, helpers.ErrInvalidDirectoryURL, cfg.Validate())
+
+	for _, address := range []string{":1789", "127.0.0.1:1789", "[::1]:0", "localhost:65535"} {
+		cfg := DefaultConfig().Overlay(Config{BindAddress: address})
+		assert.Nil(t, cfg.Validate(), "Bind address %q should have been accepted", address)
+	}
+	for _, address := range []string{"1789", "127.0.0.1", "::1:1789", "localhost:65536", "localhost:-1", "localhost:http"} {
+		cfg := DefaultConfig().Overlay(Config{BindAddress: address})
+		assert.Equal(t, ErrInvalidBindAddress, cfg.Validate(), "Bind address %q should have been rejected", address)
+	}
+
+	assert.Nil(t, DefaultConfig().Overlay(Config{InboxShardPrefixLength: MaxInboxShardPrefixLength}).Validate())
+	for _, length := range []int{-1, MaxInboxShardPrefixLength + 1} {
+		cfg := DefaultConfig().Overlay(Config{InboxShardPrefixLength: length})
+		assert.Equal(t, ErrInvalidInboxShardPrefixLength, cfg.Validate(), "Length %v should have been rejected", length)
+	}
+
+	assert.Nil(t, DefaultConfig().Overlay(Config{DeliveryWebhook: "https://example.com/messages"}).Validate())
+	for _, webhook := range []string{"example.com/messages", "ftp://example.com", "http://"} {
+		cfg := DefaultConfig().Overlay(Config{DeliveryWebhook: webhook})
+		assert.Equal(t, ErrInvalidDeliveryWebhook, cfg.Validate(), "Webhook %q should have been rejected", webhook)
+	}
+}
+
+func TestConfigFromEnv_BindAddress(t *testing.T) {
+	defer setEnv(t, EnvBindAddress, "127.0.0.1:4242")()
+
+	cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+	assert.Equal(t, "127.0.0.1:4242", cfg.BindAddress)
+	assert.Equal(t, ":1234", cfg.Overlay(Config{BindAddress: ":1234"}).BindAddress)
+}
+
+func TestConfig_AdvertiseAddress(t *testing.T) {
+	defer setEnv(t, EnvAdvertiseAddress, "203.0.113.7:443")()
+
+	cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+	assert.Equal(t, "203.0.113.7:443", cfg.AdvertiseAddress)
+	assert.Nil(t, cfg.Validate())
+
+	// the provider listens locally, while advertising the public address
+	host, port := cfg.AdvertisedHostPort("10.0.0.5")
+	assert.Equal(t, "203.0.113.7", host)
+	assert.Equal(t, "443", port)
+	assert.Equal(t, "10.0.0.5:"+DefaultPort, cfg.ListenAddress("10.0.0.5"))
+	assert.Equal(t, ":1789", cfg.Overlay(Config{BindAddress: ":1789"}).ListenAddress("10.0.0.5"))
+
+	// without it, the host and port are both advertised and listened on
+	host, port = DefaultConfig().AdvertisedHostPort("10.0.0.5")
+	assert.Equal(t, "10.0.0.5", host)
+	assert.Equal(t, DefaultPort, port)
+	assert.Equal(t, "10.0.0.5:"+DefaultPort, DefaultConfig().ListenAddress("10.0.0.5"))
+
+	for _, address := range []string{"203.0.113.7", ":443", "203.0.113.7:0", "203.0.113.7:https", "[::1]:65536"} {
+		cfg := DefaultConfig().Overlay(Config{AdvertiseAddress: address})
+		assert.Equal(t, ErrInvalidAdvertiseAddress, cfg.Validate(), "Advertise address %q should have been rejected", address)
+	}
+}
+
+func TestConfig_AdminAddress(t *testing.T) {
+	withAdmin := func(address string, expose bool) Config {
+		return DefaultConfig().Overlay(Config{AdminAddress: address, AdminToken: "token", AdminExpose: expose})
+	}
+
+	// the admin API is only reachable locally by default
+	assert.Equal(t, DefaultAdminHost+":8080", withAdmin(":8080", false).AdminListenAddress())
+	for _, address := range []string{":8080", "127.0.0.1:8080", "localhost:8080", "[::1]:0"} {
+		cfg := withAdmin(address, false)
+		assert.Nil(t, cfg.Validate(), "Admin address %q should have been accepted", address)
+		assert.True(t, helpers.IsLoopbackHost(cfg.AdminListenAddress()), "Admin address %q should be loopback", address)
+	}
+
+	// listening beyond it has to be allowed explicitly
+	for _, address := range []string{"0.0.0.0:8080", "[::]:8080", "10.0.0.5:8080", "example.com:8080"} {
+		assert.Equal(t, ErrAdminNotExposed, withAdmin(address, false).Validate(), "Admin address %q should have been rejected", address)
+		assert.Nil(t, withAdmin(address, true).Validate(), "Admin address %q should have been accepted", address)
+		assert.Equal(t, address, withAdmin(address, true).AdminListenAddress())
+	}
+	assert.Equal(t, ":8080", withAdmin(":8080", true).AdminListenAddress())
+
+	for _, address := range []string{"8080", "localhost", "localhost:65536", "localhost:http"} {
+		assert.Equal(t, ErrInvalidAdminAddress, withAdmin(address, true).Validate(), "Admin address %q should have been rejected", address)
+	}
+}
+
+func TestConfigFromEnv_AdminExpose(t *testing.T) {
+	defer setEnv(t, EnvAdminAddress, "0.0.0.0:8080")()
+	defer setEnv(t, EnvAdminToken, "token")()
+
+	for _, value := range []string{"", "0", "false", "yes"} {
+		defer setEnv(t, EnvAdminExpose, value)()
+		cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+		assert.False(t, cfg.AdminExpose, "%q should not have exposed the admin API", value)
+		assert.Equal(t, ErrAdminNotExposed, cfg.Validate())
+	}
+	for _, value := range []string{"1", "true"} {
+		defer setEnv(t, EnvAdminExpose, value)()
+		cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+		assert.True(t, cfg.AdminExpose, "%q should have exposed the admin API", value)
+		assert.Nil(t, cfg.Validate())
+	}
+}
+
+func TestConfigFromEnv_RequireDirectory(t *testing.T) {
+	assert.False(t, DefaultConfig().RequireDirectory)
+	for _, value := range []string{"", "0", "false", "yes"} {
+		defer setEnv(t, EnvRequireDirectory, value)()
+		cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+		assert.False(t, cfg.RequireDirectory, "%q should not have required the directory server", value)
+	}
+	for _, value := range []string{"1", "true"} {
+		defer setEnv(t, EnvRequireDirectory, value)()
+		cfg := DefaultConfig().Overlay(ConfigFromEnv(os.LookupEnv))
+		assert.True(t, cfg.RequireDirectory, "%q should have required the directory server", value)
+	}
+	assert.True(t, DefaultConfig().Overlay(Config{RequireDirectory: true}).RequireDirectory)
+}
+
+func TestConfig_HomeDir(t *testing.T) {
+	defer setEnv(t, EnvHome, "/var/lib/provider")()
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: DefaultHomeDir("Provider")})
+	assert.Equal(t, "Provider", filepath.Base(cfg.HomeDir))
+	assert.Equal(t, "/var/lib/provider", cfg.Overlay(ConfigFromEnv(os.LookupEnv)).HomeDir)
+
+	cfg = DefaultConfig().Overlay(Config{HomeDir: "/home/nym", LogFile: "provider.log"})
+	assert.Equal(t, filepath.Join("/home/nym", DefaultInboxesDir), cfg.InboxesPath())
+	assert.Equal(t, filepath.Join("/home/nym", DefaultPrivateKeyFile), cfg.PrivateKeyPath())
+	assert.Equal(t, filepath.Join("/home/nym", DefaultPublicKeyFile), cfg.PublicKeyPath())
+	assert.Equal(t, "/home/nym/provider.log", cfg.LogFilePath())
+	assert.Equal(t, "", DefaultConfig().LogFilePath())
+
+	// absolute paths are kept as they are
+	cfg = cfg.Overlay(Config{InboxesDir: "/var/lib/inboxes"})
+	assert.Equal(t, "/var/lib/inboxes", cfg.InboxesPath())
+}
+
+func TestProviderServer_SetLogFile(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "provider.log")
+
+	assert.Nil(t, p.SetLogFile(path))
+	p.log.Warn("foomp")
+	dat, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(dat), "foomp")
+
+	assert.NotNil(t, p.SetLogFile(filepath.Join(dir, "nonexistent", "provider.log")))
+}
+
+func TestProviderServer_SetLogLevel(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, p.SetLogLevel("warn"))
+	assert.Equal(t, "warning", p.log.GetLevel().String())
+	assert.NotNil(t, p.SetLogLevel("foomp"))
+}
+
+func TestProviderServer_SetInboxesDirectory(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+
+	assert.Nil(t, p.storeMessage([]byte("foomp"), "Client", "msg"))
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "Client", "msg.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("foomp"), dat)
+}
+
+func TestNewProviderServer_RegistersAtConfiguredDirectory(t *testing.T) {
+	var presences []map[string]interface{}
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, config.MixProviderPresencePath, r.URL.Path)
+		var presence map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&presence))
+		presences = append(presences, presence)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer directory.Close()
+
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderServer("Provider", "localhost", "0", priv, pub, directory.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the presence is only registered once the provider is started
+	assert.Empty(t, presences)
+	assert.Nil(t, p.registerPresence())
+	if assert.Len(t, presences, 1) {
+		for _, presence := range presences {
+			assert.Equal(t, "localhost:0", presence["host"])
+		}
+	}
+}
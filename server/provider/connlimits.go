@@ -0,0 +1,147 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxTrackedSources is the number of sources the connection limits are tracked for, above which the state
+// of the idle sources is forgotten, so that connecting from many distinct addresses could not exhaust the memory.
+const maxTrackedSources = 10000
+
+// sourceState is the state of the connection limits of a single source.
+type sourceState struct {
+	// tokens is the number of connections the source may currently open, refilled at the rate of the limits.
+	tokens float64
+	last   time.Time
+	// active is the number of connections of the source currently being handled.
+	active int
+}
+
+// connectionLimits limits the rate at which each source, identified by its host, may open new connections,
+// and how many of them it may have open at once. The connections exceeding the limits are refused
+// straight away, before anything is read from them. The sources on the allowlist are never limited.
+// The zero value does not limit anything.
+type connectionLimits struct {
+	mu            sync.Mutex
+	rate          float64
+	burst         int
+	maxConcurrent int
+	allowlist     map[string]struct{}
+	sources       map[string]*sourceState
+	refused       uint
+}
+
+// set replaces the limits. A non-positive rate or maxConcurrent disables the respective limit.
+func (l *connectionLimits) set(rate float64, burst, maxConcurrent int, allowlist []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burst < 1 {
+		burst = 1
+	}
+	l.rate = rate
+	l.burst = burst
+	l.maxConcurrent = maxConcurrent
+	l.allowlist = make(map[string]struct{}, len(allowlist))
+	for _, host := range allowlist {
+		l.allowlist[host] = struct{}{}
+	}
+	l.sources = make(map[string]*sourceState)
+}
+
+func (l *connectionLimits) enabled() bool {
+	return l.rate > 0 || l.maxConcurrent > 0
+}
+
+// acquire checks whether the source may open another connection at the given time. If it may,
+// the connection is counted as active until it is released, otherwise it is counted as refused.
+// acquire returns whether the connection should be handled.
+func (l *connectionLimits) acquire(source string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.enabled() {
+		return true
+	}
+	if _, ok := l.allowlist[source]; ok {
+		return true
+	}
+
+	state, ok := l.sources[source]
+	if !ok {
+		if len(l.sources) >= maxTrackedSources {
+			l.forgetIdle(now)
+		}
+		state = &sourceState{tokens: float64(l.burst), last: now}
+		l.sources[source] = state
+	}
+	if elapsed := now.Sub(state.last); elapsed > 0 {
+		state.tokens = math.Min(float64(l.burst), state.tokens+elapsed.Seconds()*l.rate)
+	}
+	state.last = now
+
+	if (l.rate > 0 && state.tokens < 1) || (l.maxConcurrent > 0 && state.active >= l.maxConcurrent) {
+		l.refused++
+		return false
+	}
+	if l.rate > 0 {
+		state.tokens--
+	}
+	state.active++
+	return true
+}
+
+// release ends the connection of the source previously allowed by acquire.
+func (l *connectionLimits) release(source string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state, ok := l.sources[source]; ok && state.active > 0 {
+		state.active--
+	}
+}
+
+// forgetIdle forgets the sources without active connections, whose rate limit has been fully refilled by now,
+// as tracking them any further would not change the outcome of their next connection.
+func (l *connectionLimits) forgetIdle(now time.Time) {
+	for source, state := range l.sources {
+		refilled := l.rate <= 0 || state.tokens+now.Sub(state.last).Seconds()*l.rate >= float64(l.burst)
+		if state.active == 0 && refilled {
+			delete(l.sources, source)
+		}
+	}
+}
+
+func (l *connectionLimits) refusedCount() uint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.refused
+}
+
+// SetConnectionLimits limits each source of the connections, identified by its host, to opening rate connections
+// per second, with bursts of up to burst connections, and to having at most maxConcurrent connections open at once.
+// The connections exceeding the limits are closed as soon as they are accepted, before any of their packets
+// are read, and counted as returned by RefusedConnections. A non-positive rate or maxConcurrent disables
+// the respective limit. The hosts on the allowlist, such as the addresses of the mixes behind a NAT,
+// are never limited. It should be called before the provider is started.
+func (p *ProviderServer) SetConnectionLimits(rate float64, burst, maxConcurrent int, allowlist []string) {
+	p.connLimits.set(rate, burst, maxConcurrent, allowlist)
+}
+
+// RefusedConnections returns the number of connections refused since the provider started,
+// as their sources exceeded the connection limits.
+func (p *ProviderServer) RefusedConnections() uint {
+	return p.connLimits.refusedCount()
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sourceConn is a connection pretending to come from the given address.
+type sourceConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c sourceConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// fakeListener hands out the connections sent on its channel.
+type fakeListener struct {
+	conns chan net.Conn
+}
+
+func (l fakeListener) Accept() (net.Conn, error) {
+	return <-l.conns, nil
+}
+
+func (l fakeListener) Close() error {
+	return nil
+}
+
+func (l fakeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// dialFrom makes a connection to the listener from the given host, which the provider keeps open
+// until the returned end of it is closed.
+func (l fakeListener) dialFrom(host string) net.Conn {
+	serverConn, clientConn := net.Pipe()
+	l.conns <- sourceConn{Conn: serverConn, remote: &net.TCPAddr{IP: net.ParseIP(host), Port: 40000}}
+	return clientConn
+}
+
+func TestConnectionLimits_Disabled(t *testing.T) {
+	var limits connectionLimits
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.True(t, limits.acquire("10.0.0.1", now))
+	}
+	assert.Zero(t, limits.refusedCount())
+}
+
+func TestConnectionLimits_Rate(t *testing.T) {
+	var limits connectionLimits
+	limits.set(1, 3, 0, []string{"10.0.0.9"})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limits.acquire("10.0.0.1", now))
+	}
+	assert.False(t, limits.acquire("10.0.0.1", now))
+	// other sources have their own limits
+	assert.True(t, limits.acquire("10.0.0.2", now))
+	// and the allowlisted ones are not limited at all
+	for i := 0; i < 10; i++ {
+		assert.True(t, limits.acquire("10.0.0.9", now))
+	}
+	assert.Equal(t, uint(1), limits.refusedCount())
+
+	// the limit is refilled over time, even if the connections are still open
+	assert.True(t, limits.acquire("10.0.0.1", now.Add(time.Second)))
+	assert.False(t, limits.acquire("10.0.0.1", now.Add(time.Second)))
+	assert.Equal(t, uint(2), limits.refusedCount())
+}
+
+func TestConnectionLimits_Concurrency(t *testing.T) {
+	var limits connectionLimits
+	limits.set(0, 0, 2, nil)
+	now := time.Now()
+
+	assert.True(t, limits.acquire("10.0.0.1", now))
+	assert.True(t, limits.acquire("10.0.0.1", now))
+	assert.False(t, limits.acquire("10.0.0.1", now))
+	limits.release("10.0.0.1")
+	assert.True(t, limits.acquire("10.0.0.1", now))
+	assert.Equal(t, uint(1), limits.refusedCount())
+}
+
+func TestConnectionLimits_ForgetsIdleSources(t *testing.T) {
+	var limits connectionLimits
+	limits.set(1, 1, 1, nil)
+	now := time.Now()
+
+	assert.True(t, limits.acquire("10.0.0.1", now))
+	assert.True(t, limits.acquire("10.0.0.2", now))
+	limits.release("10.0.0.2")
+	limits.forgetIdle(now.Add(time.Second))
+	// the source with an open connection is still tracked
+	assert.Len(t, limits.sources, 1)
+	assert.False(t, limits.acquire("10.0.0.1", now.Add(time.Second)))
+}
+
+func TestProviderServer_ConnectionLimits(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.SetConnectionLimits(0, 0, 3, []string{"10.0.0.9"})
+	listener := fakeListener{conns: make(chan net.Conn)}
+	p.listener = listener
+	go p.listenForIncomingConnections()
+
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	// a flood of connections from a single source only gets the allowed number of them handled
+	for i := 0; i < 10; i++ {
+		conns = append(conns, listener.dialFrom("10.0.0.1"))
+	}
+	assert.Eventually(t, func() bool { return p.RefusedConnections() == 7 }, time.Second, 10*time.Millisecond)
+
+	// the refused connections are closed straight away
+	buff := make([]byte, 1)
+	_, err := conns[len(conns)-1].Read(buff)
+	assert.Error(t, err)
+
+	// while the other sources are not affected
+	for i := 0; i < 5; i++ {
+		conns = append(conns, listener.dialFrom("10.0.0.2"))
+	}
+	for i := 0; i < 5; i++ {
+		conns = append(conns, listener.dialFrom("10.0.0.9"))
+	}
+	assert.Eventually(t, func() bool { return p.RefusedConnections() == 9 }, time.Second, 10*time.Millisecond)
+
+	// once any of the connections of the source ends, it may open another one
+	conns[0].Close()
+	assert.Eventually(t, func() bool {
+		p.connLimits.mu.Lock()
+		defer p.connLimits.mu.Unlock()
+		return p.connLimits.sources["10.0.0.1"].active == 2
+	}, time.Second, 10*time.Millisecond)
+	conns = append(conns, listener.dialFrom("10.0.0.1"), listener.dialFrom("10.0.0.1"))
+	assert.Eventually(t, func() bool { return p.RefusedConnections() == 10 }, time.Second, 10*time.Millisecond)
+}
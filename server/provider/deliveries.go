@@ -0,0 +1,105 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/node"
+)
+
+// metricsLogInterval defines how often the metrics of the provider are logged.
+const metricsLogInterval = time.Minute
+
+// DeliveryStats counts the packets the provider successfully handled since it started,
+// as opposed to the dropped ones.
+type DeliveryStats struct {
+	// Relayed is the number of packets forwarded to their next hop.
+	Relayed uint `json:"relayed"`
+	// Stored is the number of messages stored in the inboxes of their recipients.
+	Stored uint `json:"stored"`
+	// Sunk is the number of messages handed over to the DeliverySink.
+	Sunk uint `json:"sunk"`
+}
+
+// Metrics is a snapshot of all the counters of the provider.
+type Metrics struct {
+	DeliveryStats
+	Dropped map[node.DropReason]uint `json:"dropped"`
+	// MessageSizes is the distribution of the sizes of the stored messages.
+	MessageSizes SizeHistogram `json:"message_sizes"`
+}
+
+// deliveryCounter counts the successfully handled packets. It is safe for concurrent use
+// and its zero value is ready to use.
+type deliveryCounter struct {
+	sync.Mutex
+	stats DeliveryStats
+}
+
+func (d *deliveryCounter) recordRelayed() {
+	d.Lock()
+	defer d.Unlock()
+	d.stats.Relayed++
+}
+
+func (d *deliveryCounter) recordStored() {
+	d.Lock()
+	defer d.Unlock()
+	d.stats.Stored++
+}
+
+func (d *deliveryCounter) recordSunk() {
+	d.Lock()
+	defer d.Unlock()
+	d.stats.Sunk++
+}
+
+func (d *deliveryCounter) snapshot() DeliveryStats {
+	d.Lock()
+	defer d.Unlock()
+	return d.stats
+}
+
+// Deliveries returns the number of packets relayed, stored and handed over to the sink since the provider started.
+func (p *ProviderServer) Deliveries() DeliveryStats {
+	return p.deliveries.snapshot()
+}
+
+// Metrics returns the number of packets relayed, stored and dropped since the provider started,
+// along with the sizes of the stored messages.
+func (p *ProviderServer) Metrics() Metrics {
+	return Metrics{DeliveryStats: p.Deliveries(), Dropped: p.DroppedPackets(), MessageSizes: p.MessageSizes()}
+}
+
+func (p *ProviderServer) startLoggingMetrics() {
+	ticker := p.clock.NewTicker(metricsLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			metrics := p.Metrics()
+			p.log.Infof("Relayed %v packets, stored %v messages, sunk %v messages, dropped %v",
+				metrics.Relayed,
+				metrics.Stored,
+				metrics.Sunk,
+				metrics.Dropped,
+			)
+		case <-p.haltedCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderServer_Deliveries(t *testing.T) {
+	p, server, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+	assert.Equal(t, DeliveryStats{}, p.Deliveries())
+
+	// the next hop discards everything it receives
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, conn) //nolint: errcheck
+				conn.Close()
+			}()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, mixPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix := config.MixConfig{Id: "DeliveriesMix", Host: host, Port: port, PubKey: mixPub.Bytes(), Layer: 1}
+	path := config.E2EPath{IngressProvider: p.config,
+		Mixes:          []config.MixConfig{mix},
+		EgressProvider: p.config,
+	}
+	sphinxPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayPacket, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processPacketNow(p, "localhost", relayPacket)
+	assert.Equal(t, DeliveryStats{Relayed: 1}, p.Deliveries())
+
+	recipientAddress, _ := newTestRecipient(t)
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, recipientAddress, []byte("Hello world")))
+	assert.Equal(t, DeliveryStats{Relayed: 1, Stored: 1}, p.Deliveries())
+
+	// a dropped packet is not counted as delivered
+	processPacketNow(p, "localhost", relayPacket)
+	assert.Equal(t, DeliveryStats{Relayed: 1, Stored: 1}, p.Deliveries())
+	assert.NotEmpty(t, p.DroppedPackets())
+
+	resp := adminRequest(t, http.MethodGet, server.URL+adminMetricsPath, testAdminToken)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var metrics Metrics
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	assert.Equal(t, p.Metrics(), metrics)
+}
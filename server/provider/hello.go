@@ -0,0 +1,55 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// Capabilities returns what the provider advertises in the hello exchange: the requests it handles
+// and the packets it responds to them with.
+func Capabilities() config.Capabilities {
+	return config.Capabilities{Version: config.ProtocolVersion,
+		Flags: []flags.PacketTypeFlag{flags.AssignFlag,
+			flags.CommFlag,
+			flags.PullFlag,
+			flags.RotateTokenFlag,
+			flags.HelloFlag,
+			flags.StatusFlag,
+			flags.TokenFlag,
+			flags.DummyFlag,
+			flags.ErrorFlag,
+			flags.InboxStatusFlag,
+		},
+	}
+}
+
+// handleHelloRequest handles the hello of the client, responding with the capabilities of the provider.
+// It returns ErrUnsupportedFeature if the client requires any flag the provider does not handle.
+func (p *ProviderServer) handleHelloRequest(helloBytes []byte) ([]byte, error) {
+	clientCapabilities, required, err := config.UnwrapHello(helloBytes)
+	if err != nil {
+		p.log.Warnf("Failed to parse hello: %v", err)
+		return nil, ErrMalformedRequest
+	}
+	p.log.Infof("Processing hello of client speaking protocol version %v", clientCapabilities.Version)
+
+	capabilities := Capabilities()
+	if missing := capabilities.Missing(required); len(missing) > 0 {
+		p.log.Warnf("Client requires unsupported flags %#x", missing)
+		return nil, ErrUnsupportedFeature
+	}
+	return config.WrapHello(capabilities, nil)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func marshalHello(t *testing.T, required ...flags.PacketTypeFlag) []byte {
+	capabilities := config.Capabilities{Version: config.ProtocolVersion, Flags: []flags.PacketTypeFlag{flags.PullFlag}}
+	packetBytes, err := config.WrapHello(capabilities, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := config.UnwrapPacket(packetBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet.Data
+}
+
+func TestProviderServer_InMemory_Hello(t *testing.T) {
+	_, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packets := exchange(t, dial, flags.HelloFlag, marshalHello(t, flags.PullFlag, flags.RotateTokenFlag))
+	if assert.Len(t, packets, 1) {
+		assert.Equal(t, flags.HelloFlag, flags.PacketTypeFlagFromBytes(packets[0].Flag))
+		capabilities, required, err := config.UnwrapHello(packets[0].Data)
+		assert.Nil(t, err)
+		assert.Equal(t, Capabilities(), capabilities)
+		assert.Empty(t, required)
+	}
+
+	// a feature the provider does not know of is refused
+	helloBytes, err := proto.Marshal(&config.Hello{Version: config.ProtocolVersion, Required: []byte{0x01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertErrorResponse(t, config.ErrorCodeUnsupportedFeature, exchange(t, dial, flags.HelloFlag, helloBytes)...)
+
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, exchange(t, dial, flags.HelloFlag, []byte{0xff, 0xff})...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import "sync"
+
+// inboxLocks serialises the operations on each single inbox, such as storing, pulling or removing
+// the messages and removing the inbox itself, while letting the operations on distinct inboxes proceed
+// concurrently. Its zero value is ready to use.
+type inboxLocks struct {
+	sync.Mutex
+	locks map[string]*inboxLock
+}
+
+type inboxLock struct {
+	sync.Mutex
+	// refs counts the holders of and the waiters for the lock, so that it could be discarded once unused.
+	refs int
+}
+
+// lock acquires the lock of the given inbox and returns the function releasing it.
+// The lock must never be held across any network I/O, so that a slow client could not block the inbox.
+func (l *inboxLocks) lock(inboxID string) func() {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*inboxLock)
+	}
+	inbox, ok := l.locks[inboxID]
+	if !ok {
+		inbox = &inboxLock{}
+		l.locks[inboxID] = inbox
+	}
+	inbox.refs++
+	l.Unlock()
+
+	inbox.Lock()
+	return func() {
+		inbox.Unlock()
+		l.Lock()
+		inbox.refs--
+		if inbox.refs == 0 {
+			delete(l.locks, inboxID)
+		}
+		l.Unlock()
+	}
+}
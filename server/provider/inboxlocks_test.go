@@ -0,0 +1,139 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboxLocks(t *testing.T) {
+	var locks inboxLocks
+	unlock := locks.lock("Alice")
+
+	// distinct inboxes are not blocked
+	done := make(chan struct{})
+	go func() {
+		locks.lock("Bob")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lock of a distinct inbox should not have blocked")
+	}
+
+	// while the same inbox is
+	acquired := make(chan struct{})
+	go func() {
+		locks.lock("Alice")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Lock of the same inbox should have blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+
+	// the unused locks are discarded
+	assert.Empty(t, locks.locks)
+}
+
+// countMessages counts the real messages in the pull response.
+func countMessages(t *testing.T, response []byte) int {
+	count := 0
+	frames := config.NewFrameReader(bytes.NewReader(response))
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return count
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := config.UnwrapPacket(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.CommFlag {
+			count++
+		}
+	}
+}
+
+func TestProviderServer_ConcurrentSweepAndPull(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+
+	// the inbox is not registered, so the sweeper removes it whenever it is empty
+	const inboxID = "UnregisteredClient"
+	const messages = 1000
+	const sweepers = 4
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < sweepers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				assert.Nil(t, p.cleanStaleInboxes(0))
+			}
+		}()
+	}
+
+	delivered := 0
+	for i := 0; i < messages; i++ {
+		msgID, err := newMessageID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !assert.Nil(t, p.storeMessage([]byte("foomp"), inboxID, msgID)) {
+			continue
+		}
+		var response bytes.Buffer
+		_, err = p.fetchMessages(inboxID, &response)
+		assert.Nil(t, err)
+		delivered += countMessages(t, response.Bytes())
+	}
+	close(stopCh)
+	wg.Wait()
+
+	assert.Equal(t, messages, delivered)
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nymtech/nym-mixnet/constants"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// DefaultEnvFile defines the file the configuration template of the provider is written to by InitHome.
+const DefaultEnvFile = "provider.env"
+
+var (
+	// ErrAlreadyInitialized is returned by InitHome when the keys or the configuration template
+	// of the provider already exist and are not to be overwritten.
+	ErrAlreadyInitialized = errors.New("provider is already initialized")
+	// ErrIncompleteLegacyKeys is returned by MigrateLegacyKeys when only one of the keys
+	// of the provider is found in the legacy location.
+	ErrIncompleteLegacyKeys = errors.New("only one of the keys of the provider found in the legacy location")
+)
+
+// EnvFilePath returns the path of the file with the configuration template of the provider.
+func (c Config) EnvFilePath() string {
+	return c.ResolvePath(DefaultEnvFile)
+}
+
+// EnvTemplate returns the configuration as the environment variables read by ConfigFromEnv,
+// one assignment per line, which can be loaded by the shell or as an EnvironmentFile of systemd.
+func (c Config) EnvTemplate() string {
+	var b strings.Builder
+	b.WriteString("# Configuration of the nym-mixnet-provider, read from the environment when it starts.\n")
+	b.WriteString("# Load it with e.g. `set -a; . ./" + DefaultEnvFile + "; set +a`. The flags take precedence over it.\n")
+	for _, v := range []struct {
+		comment string
+		key     string
+		value   string
+	}{
+		{"Home directory, all the relative paths are resolved against.", EnvHome, c.HomeDir},
+		{"Host the provider runs on. The local IP address is detected if empty.", EnvHost, c.Host},
+		{"Port the provider listens on.", EnvPort, c.Port},
+		{"Directory of the inboxes.", EnvInboxRoot, c.InboxesDir},
+		{"URL of the directory server. The public one is used if empty.", EnvDirectoryURL, c.DirectoryURL},
+		{"Level of the logs.", EnvLogLevel, c.LogLevel},
+	} {
+		fmt.Fprintf(&b, "\n# %v\n%v=%v\n", v.comment, v.key, strconv.Quote(v.value))
+	}
+	return b.String()
+}
+
+// InitHome prepares the home directory of a new provider: it generates its keypair, which is saved
+// readable only by the owner, and writes the configuration template to be edited before running it.
+// It returns ErrAlreadyInitialized if any of the files exist, unless force is set, in which case
+// they are replaced. The replaced files are only removed once all the new ones have been written,
+// so that the existing keys are kept if the initialisation fails. It returns the public key of the provider.
+func InitHome(cfg Config, force bool) (*sphinx.PublicKey, error) {
+	paths := []string{cfg.PrivateKeyPath(), cfg.PublicKeyPath(), cfg.EnvFilePath()}
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		if err == nil && !force {
+			return nil, ErrAlreadyInitialized
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if err := helpers.EnsureDir(cfg.HomeDir, 0700); err != nil {
+		return nil, err
+	}
+	privP, pubP, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	// the permissions of a file are kept when it is overwritten, so the new files are always created afresh
+	tmpPaths := make([]string, len(paths))
+	for i, path := range paths {
+		tmpPaths[i] = path + ".tmp"
+		if err := os.Remove(tmpPaths[i]); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	defer func() {
+		for _, tmpPath := range tmpPaths {
+			os.Remove(tmpPath) //nolint: errcheck
+		}
+	}()
+	if err := helpers.ToPEMFile(privP, tmpPaths[0], constants.PrivateKeyPEMType); err != nil {
+		return nil, err
+	}
+	if err := helpers.ToPEMFile(pubP, tmpPaths[1], constants.PublicKeyPEMType); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(tmpPaths[2], []byte(cfg.EnvTemplate()), 0600); err != nil {
+		return nil, err
+	}
+	for i, path := range paths {
+		if err := os.Rename(tmpPaths[i], path); err != nil {
+			return nil, err
+		}
+	}
+	return pubP, nil
+}
+
+// MigrateLegacyKeys moves the keys of the provider from the given directory, where they used to be kept
+// before the home directory was introduced (i.e. the working directory of the provider), to the home directory,
+// so that the provider would keep its identity rather than generate a new one. Nothing is moved if the private
+// key already exists in the home directory. It returns whether the keys were moved, or ErrIncompleteLegacyKeys
+// if only one of them was found.
+func MigrateLegacyKeys(cfg Config, legacyDir string) (bool, error) {
+	if _, err := os.Stat(cfg.PrivateKeyPath()); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	legacyPrivateKey := filepath.Join(legacyDir, DefaultPrivateKeyFile)
+	legacyPublicKey := filepath.Join(legacyDir, DefaultPublicKeyFile)
+	found := 0
+	for _, path := range []string{legacyPrivateKey, legacyPublicKey} {
+		if _, err := os.Stat(path); err == nil {
+			found++
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	switch found {
+	case 0:
+		return false, nil
+	case 1:
+		return false, ErrIncompleteLegacyKeys
+	}
+
+	if err := helpers.EnsureDir(cfg.HomeDir, 0700); err != nil {
+		return false, err
+	}
+	if err := os.Rename(legacyPublicKey, cfg.PublicKeyPath()); err != nil {
+		return false, err
+	}
+	if err := os.Rename(legacyPrivateKey, cfg.PrivateKeyPath()); err != nil {
+		// the keys are kept together, so that the migration could be retried
+		if rerr := os.Rename(cfg.PublicKeyPath(), legacyPublicKey); rerr != nil {
+			return false, fmt.Errorf("%v (and failed to restore %v: %v)", err, legacyPublicKey, rerr)
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nymtech/nym-mixnet/constants"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEnvFile parses the assignments of the environment file written by InitHome.
+func readEnvFile(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if !assert.Len(t, parts, 2, "Malformed line %q", line) {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		assert.Nil(t, err)
+		env[parts[0]] = value
+	}
+	return env
+}
+
+func TestInitHome(t *testing.T) {
+	home, err := ioutil.TempDir("", "provider-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: filepath.Join(home, "Provider"),
+		Host:         "10.0.0.1",
+		Port:         "4242",
+		InboxesDir:   "/var/lib/inboxes",
+		DirectoryURL: "http://localhost:8080",
+	})
+	pubP, err := InitHome(cfg, false)
+	assert.Nil(t, err)
+
+	for _, path := range []string{cfg.PrivateKeyPath(), cfg.PublicKeyPath(), cfg.EnvFilePath()} {
+		info, err := os.Stat(path)
+		if assert.Nil(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "%v should only be accessible by the owner", path)
+		}
+	}
+
+	privP := new(sphinx.PrivateKey)
+	assert.Nil(t, helpers.FromPEMFile(privP, cfg.PrivateKeyPath(), constants.PrivateKeyPEMType))
+	savedPubP := new(sphinx.PublicKey)
+	assert.Nil(t, helpers.FromPEMFile(savedPubP, cfg.PublicKeyPath(), constants.PublicKeyPEMType))
+	assert.Equal(t, pubP.Bytes(), savedPubP.Bytes())
+
+	// the template loads back into the same configuration
+	env := readEnvFile(t, cfg.EnvFilePath())
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	assert.Equal(t, cfg, DefaultConfig().Overlay(ConfigFromEnv(lookupEnv)))
+}
+
+func TestInitHome_RefusesToOverwrite(t *testing.T) {
+	home, err := ioutil.TempDir("", "provider-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: home})
+	_, err = InitHome(cfg, false)
+	assert.Nil(t, err)
+	privateKey, err := ioutil.ReadFile(cfg.PrivateKeyPath())
+	assert.Nil(t, err)
+
+	_, err = InitHome(cfg, false)
+	assert.Equal(t, ErrAlreadyInitialized, err)
+	unchanged, err := ioutil.ReadFile(cfg.PrivateKeyPath())
+	assert.Nil(t, err)
+	assert.Equal(t, privateKey, unchanged)
+
+	// a leftover template is not clobbered either
+	assert.Nil(t, os.Remove(cfg.PrivateKeyPath()))
+	assert.Nil(t, os.Remove(cfg.PublicKeyPath()))
+	_, err = InitHome(cfg, false)
+	assert.Equal(t, ErrAlreadyInitialized, err)
+
+	assert.Nil(t, os.Chmod(cfg.EnvFilePath(), 0644))
+	_, err = InitHome(cfg, true)
+	assert.Nil(t, err)
+	replaced, err := ioutil.ReadFile(cfg.PrivateKeyPath())
+	assert.Nil(t, err)
+	assert.NotEqual(t, privateKey, replaced)
+	info, err := os.Stat(cfg.EnvFilePath())
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestInitHome_ForceKeepsKeysOnFailure(t *testing.T) {
+	home, err := ioutil.TempDir("", "provider-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: home})
+	_, err = InitHome(cfg, false)
+	assert.Nil(t, err)
+	privateKey, err := ioutil.ReadFile(cfg.PrivateKeyPath())
+	assert.Nil(t, err)
+
+	// the template can't be written, as its temporary file is blocked by a non-empty directory
+	blocked := cfg.EnvFilePath() + ".tmp"
+	assert.Nil(t, os.MkdirAll(filepath.Join(blocked, "foomp"), 0700))
+	_, err = InitHome(cfg, true)
+	assert.NotNil(t, err)
+	unchanged, err := ioutil.ReadFile(cfg.PrivateKeyPath())
+	assert.Nil(t, err)
+	assert.Equal(t, privateKey, unchanged)
+
+	assert.Nil(t, os.RemoveAll(blocked))
+	_, err = InitHome(cfg, true)
+	assert.Nil(t, err)
+	files, err := ioutil.ReadDir(home)
+	assert.Nil(t, err)
+	assert.Len(t, files, 3, "No temporary files should be left behind")
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	legacyDir, err := ioutil.TempDir("", "provider-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(legacyDir)
+
+	// the keys are generated in the legacy location
+	legacyCfg := DefaultConfig().Overlay(Config{HomeDir: legacyDir})
+	pubP, err := InitHome(legacyCfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: filepath.Join(legacyDir, "home")})
+	moved, err := MigrateLegacyKeys(cfg, legacyDir)
+	assert.Nil(t, err)
+	assert.True(t, moved)
+	savedPubP := new(sphinx.PublicKey)
+	assert.Nil(t, helpers.FromPEMFile(savedPubP, cfg.PublicKeyPath(), constants.PublicKeyPEMType))
+	assert.Equal(t, pubP.Bytes(), savedPubP.Bytes())
+	_, err = os.Stat(legacyCfg.PrivateKeyPath())
+	assert.True(t, os.IsNotExist(err))
+
+	// there is nothing more to move
+	moved, err = MigrateLegacyKeys(cfg, legacyDir)
+	assert.Nil(t, err)
+	assert.False(t, moved)
+
+	// the keys in the home directory are never replaced
+	_, err = InitHome(legacyCfg, true)
+	assert.Nil(t, err)
+	moved, err = MigrateLegacyKeys(cfg, legacyDir)
+	assert.Nil(t, err)
+	assert.False(t, moved)
+	assert.Nil(t, helpers.FromPEMFile(savedPubP, cfg.PublicKeyPath(), constants.PublicKeyPEMType))
+	assert.Equal(t, pubP.Bytes(), savedPubP.Bytes())
+}
+
+func TestMigrateLegacyKeys_Incomplete(t *testing.T) {
+	legacyDir, err := ioutil.TempDir("", "provider-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(legacyDir)
+
+	legacyCfg := DefaultConfig().Overlay(Config{HomeDir: legacyDir})
+	if _, err := InitHome(legacyCfg, false); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, os.Remove(legacyCfg.PublicKeyPath()))
+
+	cfg := DefaultConfig().Overlay(Config{HomeDir: filepath.Join(legacyDir, "home")})
+	moved, err := MigrateLegacyKeys(cfg, legacyDir)
+	assert.Equal(t, ErrIncompleteLegacyKeys, err)
+	assert.False(t, moved)
+	_, err = os.Stat(legacyCfg.PrivateKeyPath())
+	assert.Nil(t, err, "The remaining key should have been kept in place")
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultMessageSizeBuckets defines the upper bounds (in bytes) of the buckets the sizes of the stored messages
+// are counted in unless configured otherwise.
+var DefaultMessageSizeBuckets = []int{256, 512, 1024, 2048, 4096, 8192, 16384, 65536} // nolint: gochecknoglobals
+
+// ErrInvalidMessageSizeBuckets is returned when the bucket bounds are not positive and strictly increasing.
+var ErrInvalidMessageSizeBuckets = errors.New("invalid message size buckets")
+
+// SizeBucket is a single bucket of a SizeHistogram.
+type SizeBucket struct {
+	// UpperBound is the inclusive upper bound of the bucket, in bytes.
+	UpperBound int `json:"le"`
+	// Count is the number of the messages of at most UpperBound bytes, i.e. the counts are cumulative.
+	Count uint64 `json:"count"`
+}
+
+// SizeHistogram is the distribution of the sizes of the stored messages, laid out as a Prometheus histogram:
+// the bucket counts are cumulative, and the messages larger than the last bound only count towards Count.
+type SizeHistogram struct {
+	Buckets []SizeBucket `json:"buckets"`
+	// Count is the number of all the observed messages, and Sum their total size in bytes.
+	Count uint64 `json:"count"`
+	Sum   uint64 `json:"sum"`
+}
+
+// sizeHistogram counts the observed sizes in buckets. It is safe for concurrent use and its zero value
+// is ready to use, with DefaultMessageSizeBuckets.
+type sizeHistogram struct {
+	sync.Mutex
+	bounds []int
+	// counts holds the number of the sizes falling into each of the buckets, i.e. they are not cumulative.
+	counts []uint64
+	count  uint64
+	sum    uint64
+}
+
+// ValidateMessageSizeBuckets checks whether the sizes can be counted in the buckets of the given upper bounds,
+// i.e. whether there is at least one bound and all of them are positive and strictly increasing.
+// It returns ErrInvalidMessageSizeBuckets otherwise.
+func ValidateMessageSizeBuckets(bounds []int) error {
+	if len(bounds) == 0 {
+		return ErrInvalidMessageSizeBuckets
+	}
+	for i, bound := range bounds {
+		if bound <= 0 || (i > 0 && bound <= bounds[i-1]) {
+			return ErrInvalidMessageSizeBuckets
+		}
+	}
+	return nil
+}
+
+// reset discards all the observations and starts counting in the buckets of the given bounds.
+func (h *sizeHistogram) reset(bounds []int) {
+	h.Lock()
+	defer h.Unlock()
+	h.bounds = append([]int(nil), bounds...)
+	h.counts = make([]uint64, len(bounds))
+	h.count = 0
+	h.sum = 0
+}
+
+// init sets up the default buckets if none were set. It has to be called with the lock held.
+func (h *sizeHistogram) init() {
+	if h.bounds == nil {
+		h.bounds = DefaultMessageSizeBuckets
+		h.counts = make([]uint64, len(h.bounds))
+	}
+}
+
+func (h *sizeHistogram) observe(size int) {
+	h.Lock()
+	defer h.Unlock()
+	h.init()
+	for i, bound := range h.bounds {
+		if size <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += uint64(size)
+}
+
+func (h *sizeHistogram) snapshot() SizeHistogram {
+	h.Lock()
+	defer h.Unlock()
+	h.init()
+	histogram := SizeHistogram{Buckets: make([]SizeBucket, len(h.bounds)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		histogram.Buckets[i] = SizeBucket{UpperBound: bound, Count: cumulative}
+	}
+	return histogram
+}
+
+// SetMessageSizeBuckets sets the upper bounds (in bytes) of the buckets the sizes of the stored messages
+// are counted in, discarding the sizes counted so far. It returns ErrInvalidMessageSizeBuckets if the bounds
+// are not positive and strictly increasing.
+func (p *ProviderServer) SetMessageSizeBuckets(bounds []int) error {
+	if err := ValidateMessageSizeBuckets(bounds); err != nil {
+		return err
+	}
+	p.messageSizes.reset(bounds)
+	return nil
+}
+
+// MessageSizes returns the distribution of the sizes of the messages stored since the provider started.
+func (p *ProviderServer) MessageSizes() SizeHistogram {
+	return p.messageSizes.snapshot()
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderServer_MessageSizes(t *testing.T) {
+	p, _, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+
+	histogram := p.MessageSizes()
+	assert.Len(t, histogram.Buckets, len(DefaultMessageSizeBuckets))
+	assert.Zero(t, histogram.Count)
+
+	assert.Nil(t, p.SetMessageSizeBuckets([]int{10, 100, 1000}))
+	sizes := []int{5, 10, 11, 100, 500, 2000}
+	for i, size := range sizes {
+		assert.Nil(t, p.storeMessage(bytes.Repeat([]byte("a"), size), "Client", fmt.Sprintf("msg%v", i)))
+	}
+	// messages which failed to be stored are not observed
+	assert.Equal(t, ErrMessageIDCollision, p.storeMessage([]byte("foomp"), "Client", "msg0"))
+
+	assert.Equal(t, SizeHistogram{
+		Buckets: []SizeBucket{
+			{UpperBound: 10, Count: 2},
+			{UpperBound: 100, Count: 4},
+			{UpperBound: 1000, Count: 5},
+		},
+		Count: 6,
+		Sum:   2626,
+	}, p.MessageSizes())
+	assert.Equal(t, p.MessageSizes(), p.Metrics().MessageSizes)
+
+	// changing the buckets discards the observations
+	assert.Nil(t, p.SetMessageSizeBuckets([]int{4096}))
+	assert.Equal(t, SizeHistogram{Buckets: []SizeBucket{{UpperBound: 4096}}}, p.MessageSizes())
+}
+
+func TestValidateMessageSizeBuckets(t *testing.T) {
+	assert.Nil(t, ValidateMessageSizeBuckets(DefaultMessageSizeBuckets))
+	assert.Nil(t, ValidateMessageSizeBuckets([]int{1}))
+	for _, bounds := range [][]int{nil, {}, {0}, {-1, 10}, {10, 10}, {100, 10}} {
+		assert.Equal(t, ErrInvalidMessageSizeBuckets, ValidateMessageSizeBuckets(bounds), "Bounds %v should have been rejected", bounds)
+	}
+
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrInvalidMessageSizeBuckets, p.SetMessageSizeBuckets([]int{100, 10}))
+	assert.Len(t, p.MessageSizes().Buckets, len(DefaultMessageSizeBuckets))
+}
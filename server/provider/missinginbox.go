@@ -0,0 +1,70 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// MissingInboxPolicy defines how the provider answers the pulls of the clients without an inbox.
+type MissingInboxPolicy int
+
+const (
+	// ReportNoInbox reports every missing inbox with InboxStatusNoInbox, regardless of the registration
+	// of the client, as expected by the clients predating InboxStatusNotRegistered.
+	ReportNoInbox MissingInboxPolicy = iota
+	// ConsultRegistry tells apart the registered clients, whose inbox is recreated and reported as empty,
+	// e.g. after it was cleaned up, from the unregistered ones, which get InboxStatusNotRegistered.
+	// It is only meant for the networks whose clients all understand InboxStatusNotRegistered.
+	ConsultRegistry
+)
+
+// SetMissingInboxPolicy sets how the provider answers the pulls of the clients without an inbox.
+// By default every missing inbox is reported with InboxStatusNoInbox.
+func (p *ProviderServer) SetMissingInboxPolicy(policy MissingInboxPolicy) {
+	p.missingInboxPolicy = policy
+}
+
+// missingInboxStatus answers the pull of the client whose inbox does not exist, following the MissingInboxPolicy
+// of the provider. The recreated inbox of a registered client is empty, hence the response is padded as such.
+func (p *ProviderServer) missingInboxStatus(clientID string, w io.Writer) (config.InboxStatus, error) {
+	if p.missingInboxPolicy == ReportNoInbox {
+		return config.InboxStatusNoInbox, nil
+	}
+
+	// the lock of the inbox also guards the registration of its client
+	unlock := p.inboxLocks.lock(clientID)
+	registered := p.isRegistered(clientID)
+	var err error
+	if registered {
+		err = os.MkdirAll(filepath.Join(p.inboxesDir, clientID), 0775)
+	}
+	unlock()
+	if !registered {
+		return config.InboxStatusNotRegistered, nil
+	}
+	if err != nil {
+		return config.InboxStatusUnknown, err
+	}
+	p.log.Infof("Recreated the missing inbox of %s", clientID)
+
+	if err := p.writeDummyMessages(w, 0, defaultDummyMessageSize); err != nil {
+		return config.InboxStatusUnknown, err
+	}
+	return config.InboxStatusEmpty, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// createMissingInboxTestProvider creates a test provider keeping the inboxes in a temporary directory,
+// along with a registered client, whose inbox is removed, and an unregistered one.
+func createMissingInboxTestProvider(t *testing.T) (*ProviderServer, string, string, func()) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "missing-inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetInboxesDirectory(dir)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: base64.URLEncoding.EncodeToString(pub.Bytes()),
+		PubKey: pub.Bytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.registerNewClient(clientBytes); err != nil {
+		t.Fatal(err)
+	}
+	registered := ClientID(pub.Bytes())
+	if err := os.RemoveAll(filepath.Join(dir, registered)); err != nil {
+		t.Fatal(err)
+	}
+	_, unregistered := newTestRecipient(t)
+	return p, registered, unregistered, func() { os.RemoveAll(dir) }
+}
+
+func TestProviderServer_FetchMessages_MissingInbox(t *testing.T) {
+	p, registered, unregistered, cleanup := createMissingInboxTestProvider(t)
+	defer cleanup()
+	p.SetMissingInboxPolicy(ConsultRegistry)
+	p.SetPullPadding(4)
+
+	// the registered client merely lost its inbox, which is recreated and padded as empty
+	var response bytes.Buffer
+	status, err := p.fetchMessages(registered, &response)
+	assert.Nil(t, err)
+	assert.Equal(t, config.InboxStatusEmpty, status)
+	assert.DirExists(t, filepath.Join(p.inboxesDir, registered))
+	packets := unwrapResponse(t, response.Bytes())
+	if assert.Len(t, packets, 4) {
+		for _, packet := range packets {
+			assert.Equal(t, flags.DummyFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+		}
+	}
+
+	// while the unregistered client has to register first, and gets no inbox until then
+	response.Reset()
+	status, err = p.fetchMessages(unregistered, &response)
+	assert.Nil(t, err)
+	assert.Equal(t, config.InboxStatusNotRegistered, status)
+	assert.Empty(t, response.Bytes())
+	_, err = os.Stat(filepath.Join(p.inboxesDir, unregistered))
+	assert.True(t, os.IsNotExist(err), "Inbox of the unregistered client should not have been created")
+}
+
+func TestProviderServer_FetchMessages_ReportNoInbox(t *testing.T) {
+	p, registered, unregistered, cleanup := createMissingInboxTestProvider(t)
+	defer cleanup()
+
+	// the policy the provider uses by default
+	for _, clientID := range []string{registered, unregistered} {
+		var response bytes.Buffer
+		status, err := p.fetchMessages(clientID, &response)
+		assert.Nil(t, err)
+		assert.Equal(t, config.InboxStatusNoInbox, status)
+		assert.Empty(t, response.Bytes())
+		_, err = os.Stat(filepath.Join(p.inboxesDir, clientID))
+		assert.True(t, os.IsNotExist(err), "Inbox should not have been recreated")
+	}
+}
+
+func TestProviderServer_InMemory_PullUnregistered(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.EnableStatelessTokens(masterKey, time.Hour)
+	p.SetMissingInboxPolicy(ConsultRegistry)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: base64.URLEncoding.EncodeToString(pub.Bytes()),
+		PubKey: pub.Bytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := exchange(t, dial, flags.AssignFlag, clientBytes)
+	if !assert.Len(t, responses, 1) {
+		return
+	}
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: responses[0].Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider lost both the registration and the inbox, e.g. after a restart without the registry,
+	// while the stateless token of the client is still valid
+	p.clientsMu.Lock()
+	delete(p.assignedClients, clientID)
+	p.presenceClients = nil
+	p.clientsMu.Unlock()
+	if err := os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID)); err != nil {
+		t.Fatal(err)
+	}
+
+	responses, status := splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Empty(t, responses)
+	assert.Equal(t, config.InboxStatusNotRegistered, status)
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+)
+
+// PresenceRegistrar registers the presence of the provider, together with the list of its registered clients,
+// so that the clients of the network could find it.
+type PresenceRegistrar interface {
+	// RegisterPresence announces the provider with the given public key, reachable on the given host:port.
+	// The errors should be classified like the ones of helpers.RegisterMixProviderPresence,
+	// as only the transient ones are retried.
+	RegisterPresence(publicKey *sphinx.PublicKey, clients []models.RegisteredClient, host string) error
+}
+
+// directoryRegistrar registers the presence at the directory server with the given URL,
+// or the default directory server if the URL is empty.
+type directoryRegistrar string
+
+func (d directoryRegistrar) RegisterPresence(publicKey *sphinx.PublicKey,
+	clients []models.RegisteredClient,
+	host string,
+) error {
+	return helpers.RegisterMixProviderPresence(string(d), publicKey, clients, host)
+}
+
+// noopRegistrar does not register the presence anywhere, which keeps the test providers off the directory server.
+type noopRegistrar struct{}
+
+func (noopRegistrar) RegisterPresence(*sphinx.PublicKey, []models.RegisteredClient, string) error {
+	return nil
+}
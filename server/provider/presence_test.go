@@ -0,0 +1,336 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+type registeredPresence struct {
+	publicKey *sphinx.PublicKey
+	clients   []models.RegisteredClient
+	host      string
+}
+
+// recordingRegistrar records all the presences registered with it, failing with err if it is set.
+// If the notify channel is set, it is also sent to on each registration.
+type recordingRegistrar struct {
+	mu        sync.Mutex
+	presences []registeredPresence
+	err       error
+	notify    chan struct{}
+}
+
+func (r *recordingRegistrar) RegisterPresence(publicKey *sphinx.PublicKey,
+	clients []models.RegisteredClient,
+	host string,
+) error {
+	r.mu.Lock()
+	r.presences = append(r.presences, registeredPresence{publicKey: publicKey, clients: clients, host: host})
+	r.mu.Unlock()
+	if r.notify != nil {
+		r.notify <- struct{}{}
+	}
+	return r.err
+}
+
+func (r *recordingRegistrar) registered() []registeredPresence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]registeredPresence(nil), r.presences...)
+}
+
+func TestNewProviderServerWithRegistrar(t *testing.T) {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrar := &recordingRegistrar{}
+	p, err := NewProviderServerWithRegistrar("Provider", "1.2.3.4", "1789", priv, pub, registrar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the presence is not registered before the provider is started
+	assert.Empty(t, registrar.registered())
+
+	assert.Nil(t, p.registerPresence())
+	presences := registrar.registered()
+	if assert.Len(t, presences, 1) {
+		assert.Equal(t, pub.Bytes(), presences[0].publicKey.Bytes())
+		assert.Equal(t, "1.2.3.4:1789", presences[0].host)
+		assert.Empty(t, presences[0].clients)
+	}
+
+	// and later on with the clients registered in the meantime
+	p.assignedClients["foomp"] = ClientRecord{id: "foomp", host: "localhost", port: "1111", pubKey: []byte("foomp")}
+	p.clientsChanged()
+	assert.Nil(t, p.registerPresence())
+	presences = registrar.registered()
+	if assert.Len(t, presences, 2) {
+		assert.Equal(t, pub.Bytes(), presences[1].publicKey.Bytes())
+		assert.Equal(t, "1.2.3.4:1789", presences[1].host)
+		assert.Len(t, presences[1].clients, 1)
+	}
+}
+
+func TestProviderServer_AdvertisesPublicAddress(t *testing.T) {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig().Overlay(Config{AdvertiseAddress: "203.0.113.7:443"})
+	host, port := cfg.AdvertisedHostPort("127.0.0.1")
+	registrar := &recordingRegistrar{}
+	p, err := NewProviderServerWithRegistrar("Provider", host, port, priv, pub, registrar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, p.SetBindAddress(cfg.Overlay(Config{Port: "0"}).ListenAddress("127.0.0.1")))
+	if err := p.listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.listener.Close()
+	assert.Nil(t, p.registerPresence())
+
+	listenHost, listenPort, err := net.SplitHostPort(p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "127.0.0.1", listenHost)
+	assert.NotEqual(t, "443", listenPort)
+	assert.Equal(t, "203.0.113.7", p.GetConfig().Host)
+	assert.Equal(t, "443", p.GetConfig().Port)
+	for _, presence := range registrar.registered() {
+		assert.Equal(t, "203.0.113.7:443", presence.host)
+	}
+}
+
+func TestProviderServer_RegisterPresence_Rejected(t *testing.T) {
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrar := &recordingRegistrar{err: &helpers.PresenceError{Transient: false, Err: errors.New("foomp")}}
+	p, err := NewProviderServerWithRegistrar("Provider", "1.2.3.4", "1789", priv, pub, registrar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rejected presence is not retried
+	assert.Equal(t, registrar.err, p.registerPresence())
+	assert.Len(t, registrar.registered(), 1)
+}
+
+// closedChannel returns a channel which is already closed.
+func closedChannel() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func TestProviderServer_PresenceWarmUp(t *testing.T) {
+	presences := make(chan struct{}, 1)
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.registrar = &recordingRegistrar{notify: presences}
+	p.haltedCh = make(chan struct{})
+	defer close(p.haltedCh)
+	p.SetPresenceWarmUp(time.Minute)
+
+	accepting := make(chan struct{})
+	go p.startSendingPresence(accepting)
+	select {
+	case <-presences:
+		t.Fatal("The presence should not have been sent before the provider accepted connections")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(accepting)
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute - time.Second)
+	select {
+	case <-presences:
+		t.Fatal("The presence should not have been sent before the warm-up elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the warm-up elapsed")
+	}
+}
+
+func TestProviderServer_Start_PresenceOnceListening(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	presences := make(chan struct{}, 1)
+	p.registrar = &recordingRegistrar{notify: presences}
+	p.haltedCh = make(chan struct{})
+	assert.Nil(t, p.SetBindAddress("127.0.0.1:0"))
+	const warmUp = 100 * time.Millisecond
+	p.SetPresenceWarmUp(warmUp)
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- p.Start() }()
+	defer func() {
+		p.Shutdown()
+		assert.Nil(t, <-done)
+	}()
+
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the provider started")
+	}
+	assert.True(t, time.Since(started) >= warmUp, "The presence should not have been sent before the warm-up elapsed")
+	// by the time the presence is sent, the provider has to accept the connections of the clients
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if assert.Nil(t, err) {
+		conn.Close()
+	}
+}
+
+// createUnreachableDirectoryProvider creates a provider listening on a random local port,
+// registering its presence at a directory server which is no longer running.
+func createUnreachableDirectoryProvider(t *testing.T) (*ProviderServer, func()) {
+	directory := httptest.NewServer(http.NotFoundHandler())
+	directory.Close()
+
+	priv, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProviderServer("Provider", "127.0.0.1", "0", priv, pub, directory.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetInboxesDirectory(dir)
+	assert.Nil(t, p.SetBindAddress("127.0.0.1:0"))
+	return p, func() { os.RemoveAll(dir) }
+}
+
+func TestProviderServer_Start_DirectoryUnreachable(t *testing.T) {
+	p, cleanup := createUnreachableDirectoryProvider(t)
+	defer cleanup()
+
+	done := make(chan error, 1)
+	go func() { done <- p.Start() }()
+	defer func() {
+		p.Shutdown()
+		assert.Nil(t, <-done)
+	}()
+
+	// by default the provider keeps running once its first presence failed, retrying it later on
+	select {
+	case err := <-done:
+		t.Fatalf("The provider should have kept running, but it stopped with: %v", err)
+	case <-time.After(maxPresenceAttempts * presenceRetryDelay * 2):
+	}
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if assert.Nil(t, err) {
+		conn.Close()
+	}
+}
+
+func TestProviderServer_Start_DirectoryRequired(t *testing.T) {
+	p, cleanup := createUnreachableDirectoryProvider(t)
+	defer cleanup()
+	p.SetRequireInitialPresence(true)
+
+	done := make(chan error, 1)
+	go func() { done <- p.Start() }()
+	select {
+	case err := <-done:
+		assert.True(t, helpers.IsTransientPresenceError(err), "Unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		p.Shutdown()
+		t.Fatal("The provider should have failed to start without registering its presence")
+	}
+}
+
+func TestProviderServer_Start_DirectoryRequired_Registered(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	presences := make(chan struct{}, 1)
+	p.registrar = &recordingRegistrar{notify: presences}
+	p.haltedCh = make(chan struct{})
+	assert.Nil(t, p.SetBindAddress("127.0.0.1:0"))
+	p.SetRequireInitialPresence(true)
+
+	done := make(chan error, 1)
+	go func() { done <- p.Start() }()
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the provider started")
+	}
+	p.Shutdown()
+	assert.Nil(t, <-done)
+}
+
+func TestProviderServer_PresenceClientsCache(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	pubKeys, _ := registerTestClients(t, p, 1)
+
+	// the unchanged registry is not converted again on every tick
+	first := p.convertRecordsToModelData()
+	assert.Len(t, first, 1)
+	assert.True(t, &first[0] == &p.convertRecordsToModelData()[0], "Snapshot should have been reused")
+
+	registerTestClients(t, p, 1)
+	second := p.convertRecordsToModelData()
+	assert.Len(t, second, 2)
+	assert.False(t, &first[0] == &second[0], "Snapshot should have been rebuilt after the registration")
+	assert.True(t, &second[0] == &p.convertRecordsToModelData()[0], "Snapshot should have been reused")
+
+	assert.Nil(t, p.RevokeToken(ClientID(pubKeys[0])))
+	assert.False(t, &second[0] == &p.convertRecordsToModelData()[0], "Snapshot should have been rebuilt after the revocation")
+}
@@ -16,22 +16,29 @@
 package provider
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-directory/models"
+	"github.com/nymtech/nym-mixnet/clock"
 	"github.com/nymtech/nym-mixnet/config"
 	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/helpers/topology"
 	"github.com/nymtech/nym-mixnet/logger"
 	"github.com/nymtech/nym-mixnet/networker"
 	"github.com/nymtech/nym-mixnet/node"
@@ -40,7 +47,45 @@ import (
 )
 
 const (
-	presenceInterval = 2 * time.Second
+	// DefaultPresenceInterval defines how often the provider registers its presence at the directory server,
+	// unless configured otherwise.
+	DefaultPresenceInterval = 2 * time.Second
+	// maxPresenceAttempts defines how many times the presence is sent if it fails with a transient error.
+	maxPresenceAttempts = 3
+	presenceRetryDelay  = 200 * time.Millisecond
+
+	// DefaultInboxCleanupInterval defines how often the provider looks for stale inboxes,
+	// unless configured otherwise.
+	DefaultInboxCleanupInterval = 10 * time.Minute
+	// DefaultSweepConcurrency defines how many inboxes are swept at once, unless configured otherwise.
+	DefaultSweepConcurrency = 4
+	// staleInboxThreshold defines for how long an empty inbox of an unregistered client
+	// has to remain untouched before it is removed.
+	staleInboxThreshold = 24 * time.Hour
+	// messageIDLength defines the number of random bytes in the id of each stored message.
+	messageIDLength = 16
+	// DefaultMaxPullMessages defines the maximum number of messages returned in a single pull response,
+	// unless configured otherwise. The remaining messages are left in the inbox for the subsequent pulls.
+	DefaultMaxPullMessages = 500
+	// DefaultMaxPullBytes defines the maximum size of a single pull response as sent to the client,
+	// unless configured otherwise.
+	DefaultMaxPullBytes = 8 * 1024 * 1024
+	// DefaultMaxConcurrentPulls defines how many pulls each client may have in progress at once,
+	// unless configured otherwise.
+	DefaultMaxConcurrentPulls = 1
+	// DefaultListenAttempts defines how many times the provider tries to bind to its address if it is in use,
+	// unless configured otherwise.
+	DefaultListenAttempts = 5
+	// DefaultListenBackoff defines how long the provider waits before retrying to bind to its address,
+	// unless configured otherwise. The wait is doubled after each failed attempt.
+	DefaultListenBackoff = 500 * time.Millisecond
+	// defaultDummyMessageSize defines the size of the dummy messages padding pull responses
+	// if there are no real messages whose size they could match.
+	defaultDummyMessageSize = 1024
+	// connectionIDLength defines the number of random bytes in the correlation id of each connection.
+	connectionIDLength = 8
+	// ConnectionIDField is the name of the log field holding the correlation id of the connection being handled.
+	ConnectionIDField = "conn"
 
 	// Below should be moved to a config file once we have it
 	// logFileLocation can either point to some valid file to which all log data should be written
@@ -50,6 +95,75 @@ const (
 	defaultLogLevel = "trace"
 )
 
+// UnknownRecipientPolicy defines how the provider handles the messages for the clients without an inbox.
+type UnknownRecipientPolicy int
+
+const (
+	// RejectUnknownRecipients drops the messages for the clients without an inbox.
+	RejectUnknownRecipients UnknownRecipientPolicy = iota
+	// CreateInboxOnDemand creates the inbox of the client upon receiving the first message for it.
+	CreateInboxOnDemand
+	// RequireRegistration drops the messages for the clients which are not registered with the provider,
+	// even if their inbox exists, e.g. because it was left behind by a previous run of the provider.
+	RequireRegistration
+)
+
+// MessageOrder defines the order the messages are sent in to the clients pulling them.
+type MessageOrder int
+
+const (
+	// OldestFirst sends the messages in the order they were received in by the provider.
+	OldestFirst MessageOrder = iota
+	// NewestFirst sends the most recently received messages first.
+	NewestFirst
+	// StorageOrder sends the messages in the order they are listed by the file system, which is effectively arbitrary.
+	StorageOrder
+)
+
+// ErrUnknownMessageOrder is returned when parsing a name which does not belong to any MessageOrder.
+var ErrUnknownMessageOrder = errors.New("unknown message order")
+
+//nolint: gochecknoglobals
+var messageOrderNames = map[string]MessageOrder{
+	"oldest":  OldestFirst,
+	"newest":  NewestFirst,
+	"storage": StorageOrder,
+}
+
+// ParseMessageOrder returns the order with the given name, i.e. one of "oldest", "newest" or "storage".
+func ParseMessageOrder(name string) (MessageOrder, error) {
+	order, ok := messageOrderNames[name]
+	if !ok {
+		return OldestFirst, ErrUnknownMessageOrder
+	}
+	return order, nil
+}
+
+var (
+	// ErrUnknownRecipient is returned when a message is received for a client without an inbox
+	// and the provider is not allowed to create it.
+	ErrUnknownRecipient = errors.New("recipient does not have an inbox")
+	// ErrInvalidRecipient is returned when the id of the recipient can't be used as the name of its inbox.
+	ErrInvalidRecipient = errors.New("invalid recipient id")
+	// ErrMessageIDCollision is returned when the inbox already contains a message with the given id.
+	ErrMessageIDCollision = errors.New("message with the given id already exists")
+	// ErrTooManyPulls is returned when the client already has the maximum number of pulls in progress.
+	ErrTooManyPulls = errors.New("too many concurrent pulls")
+	// ErrTooManyClients is returned when a new client tries to register with the provider
+	// which already has the maximum number of registered clients.
+	ErrTooManyClients = errors.New("too many registered clients")
+	// ErrInvalidCleanupInterval is returned when the interval between the inbox cleanups is not positive.
+	ErrInvalidCleanupInterval = errors.New("inbox cleanup interval has to be positive")
+	// ErrAuthenticationFailed is returned when the token sent with the request of the client is not accepted.
+	ErrAuthenticationFailed = errors.New("authentication failed")
+	// ErrUnknownClient is returned when the client is not registered with the provider.
+	ErrUnknownClient = errors.New("client is not registered")
+	// ErrUnsupportedFeature is returned when the client requires a packet type flag the provider does not handle.
+	ErrUnsupportedFeature = errors.New("required feature is not supported")
+	// ErrMalformedRequest is returned when the request of the client can't be parsed.
+	ErrMalformedRequest = errors.New("malformed request")
+)
+
 // ProviderIt is the interface of a given Provider mix server
 type ProviderIt interface {
 	networker.NetworkServer
@@ -65,11 +179,88 @@ type ProviderServer struct {
 	host            string
 	port            string
 	listener        net.Listener
+	inboxesDir      string
+	inboxLocks      inboxLocks
+	registrar       PresenceRegistrar
 	assignedClients map[string]ClientRecord
+	clientsMu       sync.RWMutex
+	// presenceClients caches the registered clients as carried in the presence, so that they would not
+	// be converted on every presence tick. It is guarded by clientsMu and nil whenever it has to be rebuilt.
+	presenceClients []models.RegisteredClient
+	drops           node.DropCounter
+	deliveries      deliveryCounter
+	messageSizes    sizeHistogram
+	tokens          *tokenIssuer
+	tokenLength     int
+	recipientPolicy UnknownRecipientPolicy
+	messageOrder    MessageOrder
 	config          config.MixConfig
 	haltedCh        chan struct{}
 	haltOnce        sync.Once
+	clock           clock.Clock
 	log             *logrus.Logger
+
+	// inboxShardPrefixLength is the length of the prefix of the message ids naming the shards of the inboxes.
+	// If 0, the messages are stored directly in the inboxes.
+	inboxShardPrefixLength int
+
+	// pullPaddingBucket is the bucket size the number of messages in pull responses is padded to.
+	// If 0, the responses are not padded.
+	pullPaddingBucket int
+	// maxPullMessages caps the number of the messages in each pull response and maxPullBytes its size
+	// as sent to the client. If 0, the respective cap is disabled.
+	maxPullMessages int
+	maxPullBytes    int
+	// missingInboxPolicy defines how the pulls of the clients without an inbox are answered.
+	missingInboxPolicy MissingInboxPolicy
+	// maxConcurrentPulls is the number of pulls each client may have in progress at once. If 0, it is unlimited.
+	maxConcurrentPulls int
+	pulls              pullSlots
+	// cleanupInterval is how often the stale inboxes are looked for.
+	cleanupInterval time.Duration
+	// sweepConcurrency is the number of inboxes swept at once.
+	sweepConcurrency int
+	// maxClients is the number of clients that may be registered with the provider. If 0, it is unlimited.
+	maxClients int
+	// reachabilityPolicy defines whether the addresses advertised by the new clients are checked,
+	// by connecting to them within reachabilityTimeout.
+	reachabilityPolicy  ReachabilityPolicy
+	reachabilityTimeout time.Duration
+	reachabilityLimiter reachabilityLimiter
+	// allowLocalReachability lets the reachability checks connect to any address. It is only ever meant to be set
+	// by the tests, which can only listen on the loopback interface.
+	allowLocalReachability bool
+	// listenAttempts and listenBackoff control how binding to the provider's address is retried
+	// if it is in use on start.
+	listenAttempts int
+	listenBackoff  time.Duration
+	// bindAddress is the address the provider listens on. If empty, it listens on its host and port.
+	bindAddress string
+	// presenceInterval is guarded by presenceMu, and its changes are signalled on presenceIntervalChanged.
+	presenceInterval        time.Duration
+	presenceMu              sync.RWMutex
+	presenceIntervalChanged chan struct{}
+	// presenceWarmUp is how long after the provider starts accepting connections its first presence is sent.
+	presenceWarmUp time.Duration
+	// requireInitialPresence makes the provider stop if its first presence can't be registered,
+	// with the failure recorded in initialPresenceErr, guarded by presenceMu, and returned by Start.
+	requireInitialPresence bool
+	initialPresenceErr     error
+
+	// unknownFlagPolicy defines the reaction to the packets with unrecognised flags, which are tracked
+	// in unknownFlags. The peers are banned for unknownFlagBanDuration after every unknownFlagBanThreshold of them.
+	unknownFlagPolicy       UnknownFlagPolicy
+	unknownFlagBanThreshold int
+	unknownFlagBanDuration  time.Duration
+	unknownFlags            unknownFlagTracker
+
+	// connLimits limits the connections of each source before anything is read from them.
+	connLimits connectionLimits
+
+	// sink receives the messages on their last hop in place of the inboxes. If nil, all the messages are stored.
+	sink DeliverySink
+	// auditLog records the stored messages. If nil, they are not recorded.
+	auditLog AuditLog
 }
 
 // ClientRecord holds identity and network data for clients.
@@ -79,6 +270,37 @@ type ClientRecord struct {
 	port   string
 	pubKey []byte
 	token  []byte
+	// tokenIssuedAt is when the current token of the client was issued.
+	tokenIssuedAt time.Time
+	// tokenExpiry is the expiry of the stateless token issued to the client, if any.
+	tokenExpiry time.Time
+	// tokensRevokedUntil is the latest expiry of the stateless tokens of the client which were rotated
+	// or revoked. The tokens expiring no later than that are rejected.
+	tokensRevokedUntil time.Time
+}
+
+// ID returns the id of the client, i.e. the ClientID of its public key.
+func (r ClientRecord) ID() string {
+	return r.id
+}
+
+// ClientConfig returns the public configuration of the client, which is addressed by the base64 encoding
+// of its public key.
+func (r ClientRecord) ClientConfig() config.ClientConfig {
+	return config.ClientConfig{Id: base64.URLEncoding.EncodeToString(r.pubKey),
+		Host:   r.host,
+		Port:   r.port,
+		PubKey: r.pubKey,
+	}
+}
+
+// copy returns a deep copy of the record, so that it could be safely handed out.
+func (r ClientRecord) copy() ClientRecord {
+	r.pubKey = append([]byte(nil), r.pubKey...)
+	if r.token != nil {
+		r.token = append([]byte(nil), r.token...)
+	}
+	return r
 }
 
 // Wait waits till the provider is terminated for any reason.
@@ -102,11 +324,17 @@ func (p *ProviderServer) halt() {
 
 // Start creates loggers for capturing info and error logs
 // and starts the listening server. Returns an error
-// if any operation was unsuccessful.
+// if any operation was unsuccessful, including the registration of the first presence
+// if it is required by SetRequireInitialPresence.
 func (p *ProviderServer) Start() error {
+	if err := p.listen(); err != nil {
+		return err
+	}
 	p.run()
 
-	return nil
+	p.presenceMu.RLock()
+	defer p.presenceMu.RUnlock()
+	return p.initialPresenceErr
 }
 
 // GetConfig returns the config.MixConfig for this ProviderServer
@@ -114,41 +342,178 @@ func (p *ProviderServer) GetConfig() config.MixConfig {
 	return p.config
 }
 
+// listen binds the provider to its bind address, or its host and port if it is not set.
+// If the address is momentarily in use, binding is retried as configured with SetListenRetry.
+func (p *ProviderServer) listen() error {
+	address := p.bindAddress
+	if address == "" {
+		address = net.JoinHostPort(p.host, p.port)
+	}
+	listener, err := helpers.ListenWithRetry(p.clock, address, p.listenAttempts, p.listenBackoff)
+	if err != nil {
+		p.log.Errorf("Failed to listen on %v: %v", address, err)
+		return err
+	}
+	p.listener = listener
+	return nil
+}
+
 // Function opens the listener to start listening on provider's host and port
 func (p *ProviderServer) run() {
 
 	defer p.listener.Close()
 
+	accepting := make(chan struct{})
 	go func() {
-		p.log.Infof("Listening on %s", p.host+":"+p.port)
+		p.log.Infof("Listening on %s", p.listener.Addr())
+		close(accepting)
 		p.listenForIncomingConnections()
 	}()
 
-	go p.startSendingPresence()
+	go p.startSendingPresence(accepting)
+	go p.startCleaningInboxes()
+	go p.startLoggingMetrics()
 
 	p.Wait()
 }
 
+// Clients returns a snapshot of all the clients currently registered with the provider.
+// The returned records are copies, so they are not affected by any subsequent registrations.
+func (p *ProviderServer) Clients() []ClientRecord {
+	p.clientsMu.RLock()
+	defer p.clientsMu.RUnlock()
+	clients := make([]ClientRecord, 0, len(p.assignedClients))
+	for _, record := range p.assignedClients {
+		clients = append(clients, record.copy())
+	}
+	return clients
+}
+
+// convertRecordsToModelData returns the registered clients as carried in the presence. The conversion is cached
+// until the registry changes, hence the returned slice is shared and must not be modified.
 func (p *ProviderServer) convertRecordsToModelData() []models.RegisteredClient {
-	registeredClients := make([]models.RegisteredClient, 0, len(p.assignedClients))
-	for _, entry := range p.assignedClients {
-		registeredClients = append(registeredClients, models.RegisteredClient{
-			PubKey: base64.URLEncoding.EncodeToString(entry.pubKey),
-		})
+	p.clientsMu.RLock()
+	registeredClients := p.presenceClients
+	p.clientsMu.RUnlock()
+	if registeredClients != nil {
+		return registeredClients
+	}
+
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	if p.presenceClients == nil {
+		p.presenceClients = make([]models.RegisteredClient, 0, len(p.assignedClients))
+		for _, record := range p.assignedClients {
+			p.presenceClients = append(p.presenceClients, topology.ClientConfigToModel(record.ClientConfig()))
+		}
+	}
+	return p.presenceClients
+}
+
+// clientsChanged invalidates everything derived from the registry. It has to be called
+// with clientsMu held for writing whenever the registry is modified.
+func (p *ProviderServer) clientsChanged() {
+	p.presenceClients = nil
+}
+
+// startSendingPresence periodically registers the presence of the provider at the directory server.
+// Each presence carries the full list of the registered clients: the directory server replaces
+// the previous presence of the provider with it and forgets any presence older than a few seconds,
+// so neither incremental updates nor heartbeats without the client list can be sent.
+// The first presence is only sent once the accepting channel is closed and the warm-up set by SetPresenceWarmUp
+// has elapsed, so that the clients would not be routed to a provider which can't accept their connections yet.
+// If the first presence fails, the provider keeps running unregistered until one of the subsequent ones succeeds,
+// unless it is required by SetRequireInitialPresence, in which case it is shut down.
+// When the presence interval changes, the next presence is sent once the new interval elapses.
+func (p *ProviderServer) startSendingPresence(accepting <-chan struct{}) {
+	select {
+	case <-accepting:
+	case <-p.haltedCh:
+		return
+	}
+	if p.presenceWarmUp > 0 {
+		p.log.Infof("Waiting %v before registering the presence", p.presenceWarmUp)
+		select {
+		case <-p.clock.After(p.presenceWarmUp):
+		case <-p.haltedCh:
+			return
+		}
+	}
+	if err := p.registerPresence(); err != nil {
+		if p.requireInitialPresence {
+			p.log.Errorf("Shutting down, as the presence is required to be registered on start")
+			p.presenceMu.Lock()
+			p.initialPresenceErr = err
+			p.presenceMu.Unlock()
+			p.Shutdown()
+			return
+		}
+		p.log.Warnf("Running without a registered presence, it is going to be retried in %v", p.currentPresenceInterval())
+	}
+
+	for {
+		select {
+		case <-p.clock.After(p.currentPresenceInterval()):
+			// the failures are logged, and the presence is retried once the interval elapses again
+			p.registerPresence() //nolint: errcheck
+		case <-p.presenceIntervalChanged:
+		case <-p.haltedCh:
+			return
+		}
+	}
+}
+
+func (p *ProviderServer) currentPresenceInterval() time.Duration {
+	p.presenceMu.RLock()
+	defer p.presenceMu.RUnlock()
+	return p.presenceInterval
+}
+
+// setPresenceInterval changes how often the presence is registered, which can be done while the provider is running.
+func (p *ProviderServer) setPresenceInterval(interval time.Duration) {
+	p.presenceMu.Lock()
+	p.presenceInterval = interval
+	p.presenceMu.Unlock()
+	// the sending loop only needs to be woken up once, however many changes there were
+	select {
+	case p.presenceIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// registerPresence registers the presence of the provider at the directory server.
+// Transient failures are retried a few times, while the rejections of the presence are reported straight away,
+// as retrying them would not help. It returns the last failure if the presence could not be registered.
+func (p *ProviderServer) registerPresence() error {
+	for attempt := 1; ; attempt++ {
+		err := p.registrar.RegisterPresence(p.GetPublicKey(),
+			p.convertRecordsToModelData(),
+			net.JoinHostPort(p.host, p.port),
+		)
+		if err == nil {
+			return nil
+		}
+		if !helpers.IsTransientPresenceError(err) {
+			p.log.Errorf("Directory server rejected the presence, check the provider configuration: %v", err)
+			return err
+		}
+		if attempt == maxPresenceAttempts {
+			p.log.Errorf("Failed to register presence after %v attempts: %v", attempt, err)
+			return err
+		}
+		p.log.Warnf("Failed to register presence, retrying: %v", err)
+		clock.Sleep(p.clock, presenceRetryDelay)
 	}
-	return registeredClients
 }
 
-func (p *ProviderServer) startSendingPresence() {
-	ticker := time.NewTicker(presenceInterval)
+func (p *ProviderServer) startCleaningInboxes() {
+	ticker := p.clock.NewTicker(p.cleanupInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			if err := helpers.RegisterMixProviderPresence(p.GetPublicKey(),
-				p.convertRecordsToModelData(),
-				net.JoinHostPort(p.host, p.port),
-			); err != nil {
-				p.log.Errorf("Failed to register presence: %v", err)
+		case <-ticker.C():
+			if err := p.cleanStaleInboxes(staleInboxThreshold); err != nil {
+				p.log.Errorf("Failed to clean stale inboxes: %v", err)
 			}
 		case <-p.haltedCh:
 			return
@@ -156,47 +521,159 @@ func (p *ProviderServer) startSendingPresence() {
 	}
 }
 
+// cleanStaleInboxes removes inbox directories of clients that are not present in the registry,
+// whose inboxes are empty and have not been modified for longer than the given threshold.
+// Inboxes of registered clients and inboxes containing any messages are never removed.
+func (p *ProviderServer) cleanStaleInboxes(threshold time.Duration) error {
+	inboxes, err := ioutil.ReadDir(p.inboxesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cutoff := p.clock.Now().Add(-threshold)
+	var stale []string
+	for _, inbox := range inboxes {
+		if inbox.IsDir() && !inbox.ModTime().After(cutoff) {
+			stale = append(stale, inbox.Name())
+		}
+	}
+	p.sweepInboxes(stale, p.removeStaleInbox)
+	return nil
+}
+
+// removeStaleInbox removes the inbox of the given client, unless the client is registered
+// or the inbox contains any messages. The lock of the inbox must be held.
+func (p *ProviderServer) removeStaleInbox(inboxID string) {
+	// the registration is checked under the lock, so that an inbox created by a concurrent registration survives
+	if p.isRegistered(inboxID) {
+		return
+	}
+	path := filepath.Join(p.inboxesDir, inboxID)
+	messages, err := inboxMessages(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			p.log.Warnf("Failed to read inbox %v: %v", path, err)
+		}
+		return
+	}
+	if len(messages) > 0 {
+		return
+	}
+	// the inbox can still contain empty shards
+	if err := os.RemoveAll(path); err != nil {
+		p.log.Warnf("Failed to remove stale inbox %v: %v", path, err)
+		return
+	}
+	p.log.Infof("Removed stale inbox %v", path)
+}
+
+func (p *ProviderServer) isRegistered(clientID string) bool {
+	p.clientsMu.RLock()
+	defer p.clientsMu.RUnlock()
+	_, ok := p.assignedClients[clientID]
+	return ok
+}
+
 // Function processes the received sphinx packet, performs the
 // unwrapping operation and checks whether the packet should be
 // forwarded or stored. If the processing was unsuccessful and error is returned.
-func (p *ProviderServer) receivedPacket(packet []byte) error {
+func (p *ProviderServer) receivedPacket(peer string, packet []byte) error {
 	p.log.Infof("%s: Received new sphinx packet", p.id)
+	defer recoverFromPacketPanic(p.log)
 
-	// process in goroutine so we wouldn't block while executing the required delay
-	go func(packet []byte) {
-		res := p.ProcessPacket(packet)
-		dePacket := res.PacketData()
-		nextHop := res.NextHop()
-		flag := res.Flag()
-		if err := res.Err(); err != nil {
-			p.log.Errorf("error while processing packet: %v", err)
-		}
+	// rather than blocking for the required delay, the packet is held by the bounded scheduler of the node
+	p.ScheduleProcessingFrom(peer, packet, func(res *node.PacketProcessingResult, err error) {
+		p.handleProcessedPacket(peer, res, err)
+	})
 
-		switch flag {
-		case flags.RelayFlag:
-			if err := p.forwardPacket(dePacket, nextHop.Address); err != nil {
-				p.log.Errorf("error while forwarding packet: %v", err)
-			}
-		case flags.LastHopFlag:
-			tmpMsgID := fmt.Sprintf("TMP_MESSAGE_%v", helpers.RandomString(8))
-			if err := p.storeMessage(dePacket, nextHop.Id, tmpMsgID); err != nil {
-				p.log.Errorf("error while storing packet: %v", err)
+	return nil
+}
+
+func recoverFromPacketPanic(log logrus.FieldLogger) {
+	if r := recover(); r != nil {
+		log.Errorf("Recovered from panic while processing sphinx packet: %v", r)
+	}
+}
+
+// handleProcessedPacket either forwards or stores the processed packet, received from the given peer,
+// depending on its kind, or drops it if its processing failed with the given error. It is called in a goroutine
+// of its own once the delay of the packet has elapsed, so any panic caused by the packet is recovered from,
+// as otherwise it would crash the entire provider.
+func (p *ProviderServer) handleProcessedPacket(peer string, res *node.PacketProcessingResult, err error) {
+	defer recoverFromPacketPanic(p.log)
+	if err != nil {
+		p.dropPacket(node.ProcessingDropReason(err), err)
+		return
+	}
+	dePacket := res.PacketData()
+	nextHop := res.NextHop()
+
+	switch res.Kind() {
+	case node.RelayPacket:
+		if err := p.forwardPacket(dePacket, nextHop.Address); err != nil {
+			p.dropPacket(node.ForwardDropReason(err), err)
+			return
+		}
+		p.deliveries.recordRelayed()
+	case node.StorePacket:
+		msgID, err := newMessageID()
+		if err != nil {
+			p.dropPacket(node.DropStoreError, fmt.Errorf("failed to generate message id: %v", err))
+			return
+		}
+		inboxID, err := recipientInboxID(nextHop.Id)
+		if err == nil && p.deliverToSink(inboxID, dePacket) {
+			p.deliveries.recordSunk()
+			return
+		}
+		if err == nil {
+			err = p.storeMessage(dePacket, inboxID, msgID)
+		}
+		if err != nil {
+			if err == ErrUnknownRecipient || err == ErrInvalidRecipient {
+				p.dropPacket(node.DropUnknownRecipient, fmt.Errorf("message for %q: %v", nextHop.Id, err))
+				return
 			}
-		default:
-			p.log.Info("Sphinx packet flag not recognised")
+			p.dropPacket(node.DropStoreError, err)
+			return
 		}
-	}(packet)
+		p.deliveries.recordStored()
+		p.auditStored(inboxID, msgID, len(dePacket))
+	default:
+		// the sphinx flag is chosen by the sender of the packet rather than the peer, which might have only relayed it,
+		// so the policy for the unrecognised flags is not applied to the peer
+		p.dropPacket(node.DropUnknownFlag, fmt.Errorf("sphinx flag %v relayed by %v not recognised", res.Flag(), peer))
+	}
+}
 
-	return nil
+// dropPacket records that a packet was dropped for the given reason.
+func (p *ProviderServer) dropPacket(reason node.DropReason, err error) {
+	p.drops.Record(reason)
+	p.log.Warnf("Dropped packet (%v): %v", reason, err)
+}
+
+// DroppedPackets returns the number of packets dropped since the provider started,
+// by the reason they were dropped for.
+func (p *ProviderServer) DroppedPackets() map[node.DropReason]uint {
+	return p.drops.Snapshot()
 }
 
+// forwardPacket sends the sphinx packet to the next hop. The address of the next hop comes from the packet,
+// hence it is validated and resolved before dialling, and a node.NextHopError is returned if it is bad.
 func (p *ProviderServer) forwardPacket(sphinxPacket []byte, address string) error {
+	resolvedAddress, err := node.ResolveNextHop(address, node.DefaultNextHopResolveTimeout)
+	if err != nil {
+		return err
+	}
 	packetBytes, err := config.WrapWithFlag(flags.CommFlag, sphinxPacket)
 	if err != nil {
 		return err
 	}
 	p.log.Infof("%s: Going to forward the sphinx packet", p.id)
-	err = p.send(packetBytes, address)
+	err = p.send(packetBytes, resolvedAddress)
 	if err != nil {
 		return err
 	}
@@ -208,160 +685,335 @@ func (p *ProviderServer) forwardPacket(sphinxPacket []byte, address string) erro
 // and send the passed packet. If connection failed or
 // the packet could not be send, an error is returned
 func (p *ProviderServer) send(packet []byte, address string) error {
-	p.log.Debugf("%s: Dialling", p.id)
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	p.log.Debugf("%s: Writing", p.id)
-
-	if _, err := conn.Write(packet); err != nil {
-		return err
-	}
-	p.log.Debugf("%s: Returning", p.id)
-
-	return nil
+	p.log.Debugf("%s: Sending to %v", p.id, address)
+	return p.SendPacket(packet, address)
 }
 
 // Function responsible for running the listening process of the server;
 // The providers listener accepts incoming connections and
 // passes the incoming packets to the packet handler.
 // If the connection could not be accepted an error
-// is logged into the log files, but the function is not stopped
+// is logged into the log files, but the function is not stopped until the listener is closed.
+// The connections of the sources exceeding the connection limits are closed straight away.
 func (p *ProviderServer) listenForIncomingConnections() {
 	for {
 		conn, err := p.listener.Accept()
 		if err != nil {
+			// the listener is closed once the provider shuts down
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			p.log.Errorf("Error when listening for incoming connection: %v", err)
-		} else {
-			p.log.Infof("Received connection from %s", conn.RemoteAddr())
-			go func(conn net.Conn) {
-				p.handleConnection(conn)
-			}(conn)
+			continue
 		}
+		source := helpers.PeerHost(conn.RemoteAddr())
+		if !p.connLimits.acquire(source, p.clock.Now()) {
+			p.log.Debugf("Refusing connection from %v exceeding the connection limits", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func(conn net.Conn) {
+			defer p.connLimits.release(source)
+			p.handleConnection(conn)
+		}(conn)
 	}
 }
 
-func (p *ProviderServer) replyToClient(data []byte, conn net.Conn) {
+// replyToClient sends each of the marshalled packets back to the client in its own frame.
+func (p *ProviderServer) replyToClient(conn net.Conn, marshalledPackets ...[]byte) {
 	p.log.Infof("Replying back to the client (%v)", conn.RemoteAddr())
-	if _, err := conn.Write(data); err != nil {
+	w := bufio.NewWriter(conn)
+	for _, packet := range marshalledPackets {
+		if err := config.WriteFrame(w, packet); err != nil {
+			p.log.Errorf("Couldn't reply to the client. Connection write error: %v", err)
+			return
+		}
+	}
+	if err := w.Flush(); err != nil {
 		p.log.Errorf("Couldn't reply to the client. Connection write error: %v", err)
 	}
 }
 
-func (p *ProviderServer) createClientResponse(marshalledPackets ...[]byte) ([]byte, error) {
-	response := &config.ProviderResponse{
-		NumberOfPackets: uint64(len(marshalledPackets)),
-		Packets:         marshalledPackets,
+// errorResponse classifies the error of handling a request of the client for the error response sent back to it.
+// Only the errors caused by the request itself are described, while the details of any other failures,
+// as well as the reason the token was rejected for, are not disclosed to the client.
+func errorResponse(err error) (config.ErrorCode, string) {
+	switch err {
+	case ErrAuthenticationFailed:
+		return config.ErrorCodeAuthenticationFailed, ErrAuthenticationFailed.Error()
+	case ErrTooManyPulls:
+		return config.ErrorCodeRateLimited, ErrTooManyPulls.Error()
+	case ErrMalformedRequest:
+		return config.ErrorCodeMalformedRequest, ErrMalformedRequest.Error()
+	case ErrUnsupportedFeature:
+		return config.ErrorCodeUnsupportedFeature, ErrUnsupportedFeature.Error()
+	default:
+		return config.ErrorCodeInternal, "internal error"
 	}
-	mBytes, err := proto.Marshal(response)
+}
+
+// replyWithError writes the error response describing why the request of the client failed in its own frame.
+func (p *ProviderServer) replyWithError(w io.Writer, err error) {
+	code, message := errorResponse(err)
+	packet, err := config.WrapError(code, message)
 	if err != nil {
-		return nil, err
+		p.log.Errorf("Failed to create error response: %v", err)
+		return
+	}
+	if err := config.WriteFrame(w, packet); err != nil {
+		p.log.Warnf("Couldn't send error response to the client: %v", err)
 	}
-	return mBytes, nil
 }
 
 // HandleConnection handles the received packets; it checks the flag of the
 // packet and schedules a corresponding process function and returns an error.
+// The connection either carries a single raw packet or a stream of framed sphinx packets.
+// Any panic occurring while handling the connection is recovered from and the connection is closed.
+// The logs of handling the connection itself carry its correlation id, which is not passed on to the processing
+// of the requests and packets received over it.
 func (p *ProviderServer) handleConnection(conn net.Conn) {
+	connID, err := newConnectionID()
+	if err != nil {
+		p.log.Errorf("Failed to generate correlation id for connection from %v: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	log := p.log.WithField(ConnectionIDField, connID)
+	log.Infof("Received connection from %s", conn.RemoteAddr())
+
+	peer := helpers.PeerHost(conn.RemoteAddr())
+	if p.peerBanned(peer) {
+		log.Infof("Refusing connection from banned %v", peer)
+		conn.Close()
+		return
+	}
+
+	packetFlag := flags.InvalidPacketTypeFlag
 	defer func() {
-		p.log.Debugf("Closing Connection to %v", conn.RemoteAddr())
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic while handling connection from %v (packet flag: %#x): %v",
+				conn.RemoteAddr(),
+				byte(packetFlag),
+				r,
+			)
+		}
+		log.Debugf("Closing Connection to %v", conn.RemoteAddr())
 		if err := conn.Close(); err != nil {
-			p.log.Warnf("error when closing connection from %s: %v", conn.RemoteAddr(), err)
+			log.Warnf("error when closing connection from %s: %v", conn.RemoteAddr(), err)
 		}
 	}()
 
+	r := bufio.NewReader(conn)
+	isStream, err := config.IsFrameStream(r)
+	if err != nil {
+		log.Errorf("Error while reading from the connection: %v", err)
+		return
+	}
+	if isStream {
+		p.handleStream(conn, peer, r, &packetFlag)
+		return
+	}
+
 	buff := make([]byte, 2048)
-	reqLen, err := conn.Read(buff)
+	reqLen, err := r.Read(buff)
 	if err != nil {
-		p.log.Errorf("Error while reading from the connection: %v", err)
+		log.Errorf("Error while reading from the connection: %v", err)
 		return
 	}
 
-	var packet config.GeneralPacket
-	if err = proto.Unmarshal(buff[:reqLen], &packet); err != nil {
-		p.log.Errorf("Error while unmarshalling received packet: %v", err)
+	// truncated or garbage input is not something a well-behaved peer would ever send
+	packet, err := config.UnwrapPacket(buff[:reqLen])
+	if err != nil {
+		p.dropPacket(node.DropMalformed, fmt.Errorf("packet from %v: %v", conn.RemoteAddr(), err))
+		p.replyWithError(conn, ErrMalformedRequest)
 		return
 	}
 
-	switch flags.PacketTypeFlagFromBytes(packet.Flag) {
+	packetFlag = flags.PacketTypeFlagFromBytes(packet.Flag)
+	switch packetFlag {
 	case flags.AssignFlag:
 		tokenBytes, err := p.handleAssignRequest(packet.Data)
 		if err != nil {
-			p.log.Errorf("Error while handling token request: %v", err)
+			log.Errorf("Error while handling token request: %v", err)
+			p.replyWithError(conn, err)
 			return
 		}
-		clientResponse, err := p.createClientResponse(tokenBytes)
-		if err != nil {
-			p.log.Errorf("Error while creating client response for token: %v", err)
+		p.replyToClient(conn, tokenBytes)
+
+	case flags.CommFlag:
+		if err := p.receivedPacket(peer, packet.Data); err != nil {
+			log.Errorf("Error while handling received packet: %v", err)
 			return
 		}
-		p.replyToClient(clientResponse, conn)
 
-	case flags.CommFlag:
-		if err := p.receivedPacket(packet.Data); err != nil {
-			p.log.Errorf("Error while handling received packet: %v", err)
+	case flags.RotateTokenFlag:
+		tokenBytes, err := p.handleRotateTokenRequest(packet.Data)
+		if err != nil {
+			log.Errorf("Error while handling token rotation request: %v", err)
+			p.replyWithError(conn, err)
 			return
 		}
+		p.replyToClient(conn, tokenBytes)
 
-	case flags.PullFlag:
-		messagesBytes, err := p.handlePullRequest(packet.Data)
+	case flags.HelloFlag:
+		helloBytes, err := p.handleHelloRequest(packet.Data)
 		if err != nil {
-			p.log.Errorf("Error while handling pull request: %v", err)
+			log.Errorf("Error while handling hello: %v", err)
+			p.replyWithError(conn, err)
 			return
 		}
+		p.replyToClient(conn, helloBytes)
 
-		clientResponse, err := p.createClientResponse(messagesBytes...)
+	case flags.StatusFlag:
+		statusBytes, err := p.handleStatusRequest(packet.Data)
 		if err != nil {
-			p.log.Errorf("Error while creating client response for pull request: %v", err)
+			log.Errorf("Error while handling status request: %v", err)
+			p.replyWithError(conn, err)
 			return
 		}
-		p.replyToClient(clientResponse, conn)
+		p.replyToClient(conn, statusBytes)
+
+	case flags.PullFlag:
+		// messages are streamed to the client as they are read from the inbox,
+		// so that the memory use would not depend on the size of the inbox
+		w := bufio.NewWriter(conn)
+		if err := p.handlePullRequest(packet.Data, w); err != nil {
+			log.Errorf("Error while handling pull request: %v", err)
+			// any messages written before the failure are still sent ahead of the error
+			p.replyWithError(w, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Errorf("Couldn't reply to the client. Connection write error: %v", err)
+		}
 
 	default:
-		p.log.Info(packet.Flag)
-		p.log.Info("Packet flag not recognised. Packet dropped")
+		err := fmt.Errorf("packet flag %#x from %v not recognised", packet.Flag, conn.RemoteAddr())
+		if !p.unknownFlag(peer, err) {
+			p.replyWithError(conn, ErrMalformedRequest)
+		}
+	}
+}
 
+// handleStream handles a stream of framed packets sent over a single connection, so that the sender would not need
+// to establish a new connection for each of them. As no replies can be sent over the stream, only the sphinx packets
+// are accepted. The flag of the packet currently being handled is set in packetFlag.
+func (p *ProviderServer) handleStream(conn net.Conn, peer string, r io.Reader, packetFlag *flags.PacketTypeFlag) {
+	frames := config.NewFrameReader(r)
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			p.dropPacket(node.DropMalformed, fmt.Errorf("stream from %v: %v", conn.RemoteAddr(), err))
+			return
+		}
+
+		packet, err := config.UnwrapPacket(frame)
+		if err != nil {
+			p.dropPacket(node.DropMalformed, fmt.Errorf("packet from %v: %v", conn.RemoteAddr(), err))
+			continue
+		}
+
+		*packetFlag = flags.PacketTypeFlagFromBytes(packet.Flag)
+		if *packetFlag != flags.CommFlag {
+			if p.unknownFlag(peer,
+				fmt.Errorf("packet flag %#x from %v not supported in a stream", packet.Flag, conn.RemoteAddr()),
+			) {
+				return
+			}
+			continue
+		}
+		if err := p.receivedPacket(peer, packet.Data); err != nil {
+			p.log.Errorf("Error while handling received packet: %v", err)
+		}
 	}
 }
 
 // RegisterNewClient generates a fresh authentication token and
 // saves it together with client's public configuration data
-// in the list of all registered clients. After the client is registered the function creates an inbox directory
-// for the client's inbox, in which clients messages will be stored.
+// in the list of all registered clients. If stateless tokens are enabled, only the expiry of the token is saved.
+// After the client is registered the function creates an inbox directory
+// for the client's inbox, in which clients messages will be stored. Registering the same client multiple
+// times is idempotent - the existing record is kept and its current token is returned, while the existing inbox
+// and its messages are left intact. Registrations of the same client are serialised, so that concurrent requests
+// result in a single record and token.
 func (p *ProviderServer) registerNewClient(clientBytes []byte) ([]byte, error) {
 	var clientConf config.ClientConfig
-	err := proto.Unmarshal(clientBytes, &clientConf)
-	if err != nil {
-		return nil, err
+	if err := proto.Unmarshal(clientBytes, &clientConf); err != nil {
+		return nil, ErrMalformedRequest
+	}
+	clientID := ClientID(clientConf.PubKey)
+
+	// connecting to the client can take a while, so its address is checked before taking any lock
+	host, port := clientConf.Host, clientConf.Port
+	if !p.isRegistered(clientID) {
+		var err error
+		if host, port, err = p.checkReachability(clientID, host, port); err != nil {
+			return nil, err
+		}
 	}
-	clientID := base64.URLEncoding.EncodeToString(clientConf.PubKey)
 
-	token, err := helpers.SHA256([]byte("TMP_Token" + clientID))
+	// the lock of the inbox also guards the registration of its client
+	unlock := p.inboxLocks.lock(clientID)
+	defer unlock()
+
+	p.clientsMu.RLock()
+	record, registered := p.assignedClients[clientID]
+	p.clientsMu.RUnlock()
+	if !registered {
+		record = ClientRecord{id: clientID,
+			host:   host,
+			port:   port,
+			pubKey: clientConf.PubKey,
+		}
+	}
+
+	token, err := p.currentToken(&record)
 	if err != nil {
 		return nil, err
 	}
-	record := ClientRecord{id: clientID,
-		host:   clientConf.Host,
-		port:   clientConf.Port,
-		pubKey: clientConf.PubKey,
-		token:  token,
+	p.clientsMu.Lock()
+	if !registered && p.maxClients > 0 && len(p.assignedClients) >= p.maxClients {
+		p.clientsMu.Unlock()
+		return nil, ErrTooManyClients
 	}
 	p.assignedClients[clientID] = record
+	p.clientsChanged()
+	p.clientsMu.Unlock()
 
-	path := fmt.Sprintf("./inboxes/%s", clientID)
-	exists, err := helpers.DirExists(path)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Join(p.inboxesDir, clientID), 0775); err != nil {
 		return nil, err
 	}
-	if !exists {
-		if err := os.MkdirAll(path, 0775); err != nil {
-			return nil, err
+
+	return token, nil
+}
+
+// currentToken returns the token of the client, which is only generated if the client does not have one yet,
+// or if its stateless token has expired. The record is updated accordingly.
+func (p *ProviderServer) currentToken(record *ClientRecord) ([]byte, error) {
+	if p.tokens == nil {
+		if record.token == nil {
+			token, err := generateToken(p.tokenLength)
+			if err != nil {
+				return nil, err
+			}
+			record.token = token
+			record.tokenIssuedAt = p.clock.Now()
 		}
+		return record.token, nil
 	}
 
-	return token, nil
+	// reissuing the token with the same expiry yields exactly the same token, hence a fresh token
+	// has to expire after all the revoked ones
+	if record.tokenExpiry.IsZero() || p.tokens.expired(record.tokenExpiry) {
+		record.tokenExpiry = p.tokens.nextExpiry()
+		if record.tokenExpiry.Unix() <= record.tokensRevokedUntil.Unix() {
+			record.tokenExpiry = record.tokensRevokedUntil.Add(time.Second)
+		}
+		record.tokenIssuedAt = p.clock.Now()
+	}
+	return p.tokens.issueWithExpiry(record.id, record.tokenExpiry), nil
 }
 
 // Function is responsible for handling the registration request from the client.
@@ -382,122 +1034,566 @@ func (p *ProviderServer) handleAssignRequest(packet []byte) ([]byte, error) {
 // It first authenticates the client, by checking if the received token is valid.
 // If yes, the function triggers the function for checking client's inbox
 // and sending buffered messages. Otherwise, an error is returned.
-func (p *ProviderServer) handlePullRequest(rqsBytes []byte) ([][]byte, error) {
+func (p *ProviderServer) handlePullRequest(rqsBytes []byte, w io.Writer) error {
 	var request config.PullRequest
 	err := proto.Unmarshal(rqsBytes, &request)
 	if err != nil {
-		return nil, err
+		p.log.Warnf("Failed to parse pull request: %v", err)
+		return ErrMalformedRequest
 	}
-	clientID := base64.URLEncoding.EncodeToString(request.ClientPublicKey)
+	clientID := ClientID(request.ClientPublicKey)
 
-	p.log.Infof("Processing pull request: %s %s", clientID, string(request.Token))
+	p.log.Infof("Processing pull request: %s", clientID)
 	if p.authenticateUser(request.ClientPublicKey, request.Token) {
-		signal, messagesBytes, err := p.fetchMessages(clientID)
+		release, ok := p.pulls.acquire(clientID, p.maxConcurrentPulls)
+		if !ok {
+			return ErrTooManyPulls
+		}
+		defer release()
+		status, err := p.fetchMessages(clientID, w)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		switch signal {
-		case "NI":
+		switch status {
+		case config.InboxStatusNoInbox:
 			p.log.Info("Inbox does not exist. Sending signal to client.")
-		case "EI":
+		case config.InboxStatusNotRegistered:
+			p.log.Info("Client is not registered. Sending signal to client.")
+		case config.InboxStatusEmpty:
 			p.log.Info("Inbox is empty. Sending info to the client.")
-		case "SI":
-			p.log.Info("All messages from the inbox successfully sent to the client.")
+		case config.InboxStatusDelivered:
+			p.log.Info("Messages from the inbox successfully sent to the client.")
+		}
+		// the status concludes the response, so that the client could tell the outcomes of the pull apart
+		statusBytes, err := config.WrapInboxStatus(status)
+		if err != nil {
+			return err
 		}
-		return messagesBytes, nil
+		return config.WriteFrame(w, statusBytes)
 	} else {
 		p.log.Warn("Authentication went wrong")
-		return nil, errors.New("authentication went wrong")
+		return ErrAuthenticationFailed
 	}
 }
 
 // AuthenticateUser compares the authentication token received from the client with
-// the one stored by the provider. If tokens are the same, it returns true
-// and false otherwise.
+// the one stored by the provider in constant time. If tokens are the same, it returns true
+// and false otherwise. If stateless tokens are enabled, the token is instead validated
+// by recomputing its HMAC and checking its expiry, and that it was not revoked.
 func (p *ProviderServer) authenticateUser(clientKey, clientToken []byte) bool {
 
-	clientID := base64.URLEncoding.EncodeToString(clientKey)
-	if bytes.Equal(p.assignedClients[clientID].token, clientToken) &&
-		bytes.Equal(p.assignedClients[clientID].pubKey, clientKey) {
+	clientID := ClientID(clientKey)
+	if p.tokens != nil {
+		if err := p.tokens.validate(clientID, clientToken); err != nil {
+			p.log.Warnf("Rejected token of %v: %v", clientID, err)
+			return false
+		}
+		p.clientsMu.RLock()
+		record := p.assignedClients[clientID]
+		p.clientsMu.RUnlock()
+		if tokenExpiry(clientToken).Unix() <= record.tokensRevokedUntil.Unix() {
+			p.log.Warnf("Rejected revoked token of %v", clientID)
+			return false
+		}
+		return true
+	}
+
+	p.clientsMu.RLock()
+	record := p.assignedClients[clientID]
+	p.clientsMu.RUnlock()
+	if tokensEqual(record.token, clientToken) &&
+		bytes.Equal(record.pubKey, clientKey) {
 		// && signature check on message to make sure client actually owns this ID
 		return true
 	}
-	p.log.Warnf("Non matching token: %s, %s", p.assignedClients[clientID].token, clientToken)
+	p.log.Warnf("Non matching token of %v", clientID)
 	return false
 }
 
 // FetchMessages fetches messages from the requested inbox.
 // FetchMessages checks whether an inbox exists and if it contains
-// stored messages. If inbox contains any stored messages, all of them
-// are send to the client one by one. FetchMessages returns a code
-// signalling whether (NI) inbox does not exist, (EI) inbox is empty,
-// (SI) messages were send to the client; and an error.
-func (p *ProviderServer) fetchMessages(clientID string) (string, [][]byte, error) {
-
-	path := fmt.Sprintf("./inboxes/%s", clientID)
-	exist, err := helpers.DirExists(path)
+// stored messages. If inbox contains any stored messages, they
+// are written to w one by one, each in its own frame, without buffering the entire inbox
+// in memory. The messages are written in the MessageOrder of the provider. At most maxPullMessages messages
+// are written, and only as many as fit in maxPullBytes together with the padding and the status concluding
+// the response, though at least a single message is always written, so that no message could get stuck
+// in the inbox. The remaining messages are left for the subsequent pulls. If pull padding is enabled,
+// the messages are followed by dummy ones.
+// FetchMessages returns the status of the inbox, i.e. whether the inbox does not exist, as answered
+// following the MissingInboxPolicy, is empty, or the messages were sent to the client; and an error.
+// The lock of the inbox is only held while accessing the inbox, never while writing to w.
+func (p *ProviderServer) fetchMessages(clientID string, w io.Writer) (config.InboxStatus, error) {
+
+	path := filepath.Join(p.inboxesDir, clientID)
+	unlock := p.inboxLocks.lock(clientID)
+	messages, err := inboxMessages(path)
+	unlock()
 	if err != nil {
-		return "", nil, err
+		if os.IsNotExist(err) {
+			return p.missingInboxStatus(clientID, w)
+		}
+		return config.InboxStatusUnknown, err
 	}
-	if !exist {
-		return "NI", nil, nil
+	sortMessages(messages, p.messageOrder)
+	if len(messages) == 0 {
+		if err := p.writeDummyMessages(w, 0, defaultDummyMessageSize); err != nil {
+			return config.InboxStatusUnknown, err
+		}
+		return config.InboxStatusEmpty, nil
 	}
-	files, err := ioutil.ReadDir(path)
+
+	statusSize, err := inboxStatusFrameSize()
 	if err != nil {
-		return "", nil, err
+		return config.InboxStatusUnknown, err
 	}
-	if len(files) == 0 {
-		return "EI", nil, nil
-	}
-
-	messagesBytes := make([][]byte, len(files))
-	for i, f := range files {
-		fullPath := filepath.Join(path, f.Name())
+	dummySize := defaultDummyMessageSize
+	sent, sentBytes := 0, 0
+	for _, message := range messages {
+		if p.maxPullMessages > 0 && sent >= p.maxPullMessages {
+			break
+		}
+		fullPath := message.path
+		unlock := p.inboxLocks.lock(clientID)
 		dat, err := ioutil.ReadFile(fullPath)
+		unlock()
 		if err != nil {
-			return "", nil, err
+			// the message might have been removed in the meantime, e.g. by a concurrent pull
+			if os.IsNotExist(err) {
+				continue
+			}
+			return config.InboxStatusUnknown, err
 		}
 
 		p.log.Infof("Found stored message for %s", clientID)
 		p.log.Infof("Messages data: %v", string(dat))
 		msgBytes, err := config.WrapWithFlag(flags.CommFlag, dat)
 		if err != nil {
-			return "", nil, err
+			return config.InboxStatusUnknown, err
+		}
+		// the dummies padding the response are as large as the last message, hence they are accounted for
+		// as if this message was the last one; the message is left in the inbox if the response would not fit
+		frameSize := config.FrameSize(msgBytes)
+		dummies := paddedCount(sent+1, p.pullPaddingBucket) - (sent + 1)
+		if p.maxPullBytes > 0 && sent > 0 && sentBytes+(1+dummies)*frameSize+statusSize > p.maxPullBytes {
+			break
+		}
+		if err := config.WriteFrame(w, msgBytes); err != nil {
+			return config.InboxStatusUnknown, err
 		}
-		messagesBytes[i] = msgBytes
 
-		if err := os.Remove(fullPath); err != nil {
-			p.log.Errorf("Failed to remove %v: %v", f, err)
+		unlock = p.inboxLocks.lock(clientID)
+		err = os.Remove(fullPath)
+		unlock()
+		if err != nil && !os.IsNotExist(err) {
+			p.log.Errorf("Failed to remove %v: %v", fullPath, err)
 		}
 		p.log.Infof("Removed %v", fullPath)
+		dummySize = len(dat)
+		sent++
+		sentBytes += frameSize
+	}
+	if err := p.writeDummyMessages(w, sent, dummySize); err != nil {
+		return config.InboxStatusUnknown, err
+	}
+	// all the messages might have been removed in the meantime, e.g. by a concurrent pull
+	if sent == 0 {
+		return config.InboxStatusEmpty, nil
+	}
+	return config.InboxStatusDelivered, nil
+}
+
+// inboxStatusFrameSize returns the number of bytes taken by the status concluding each pull response.
+func inboxStatusFrameSize() (int, error) {
+	statusBytes, err := config.WrapInboxStatus(config.InboxStatusDelivered)
+	if err != nil {
+		return 0, err
 	}
-	return "SI", messagesBytes, nil
+	return config.FrameSize(statusBytes), nil
+}
+
+// paddedCount returns the number of messages in a pull response padded to the given bucket size,
+// i.e. the count rounded up to the nearest multiple of the bucket, but at least a single bucket.
+func paddedCount(count, bucket int) int {
+	if bucket <= 0 {
+		return count
+	}
+	if count == 0 {
+		return bucket
+	}
+	return (count + bucket - 1) / bucket * bucket
+}
+
+// writeDummyMessages pads the pull response, which already contains the given number of real messages,
+// with random dummy messages of the given size, so that the number of the frames only reveals their bucket.
+// The dummies are marked with DummyFlag for the client to discard them, which leaves them distinguishable
+// for anyone able to read the response, as described at SetPullPadding.
+func (p *ProviderServer) writeDummyMessages(w io.Writer, realCount int, size int) error {
+	for i := realCount; i < paddedCount(realCount, p.pullPaddingBucket); i++ {
+		dummy := make([]byte, size)
+		if _, err := rand.Read(dummy); err != nil {
+			return err
+		}
+		msgBytes, err := config.WrapWithFlag(flags.DummyFlag, dummy)
+		if err != nil {
+			return err
+		}
+		if err := config.WriteFrame(w, msgBytes); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // StoreMessage saves the given message in the inbox defined by the given id.
-// If the inbox address does not exist or writing into the inbox was unsuccessful
-// the function returns an error
+// If the inbox does not exist, it is either created or ErrUnknownRecipient is returned,
+// depending on the UnknownRecipientPolicy of the provider. With RequireRegistration, ErrUnknownRecipient
+// is also returned if the recipient is not registered.
+// If the inbox already contains a message with the given id, ErrMessageIDCollision is returned.
+// If writing into the inbox was unsuccessful the function returns an error
 func (p *ProviderServer) storeMessage(message []byte, inboxID string, messageID string) error {
-	path := fmt.Sprintf("./inboxes/%s", inboxID)
-	fileName := path + "/" + messageID + ".txt"
+	// the id comes from the packet, so it must not be allowed to point outside the inboxes directory
+	if !validInboxID(inboxID) {
+		return ErrInvalidRecipient
+	}
+	unlock := p.inboxLocks.lock(inboxID)
+	defer unlock()
+	// the lock of the inbox also guards the registration of its client
+	if p.recipientPolicy == RequireRegistration && !p.isRegistered(inboxID) {
+		return ErrUnknownRecipient
+	}
+	inboxPath := filepath.Join(p.inboxesDir, inboxID)
+	exists, err := helpers.DirExists(inboxPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if p.recipientPolicy != CreateInboxOnDemand {
+			return ErrUnknownRecipient
+		}
+		if err := os.MkdirAll(inboxPath, 0775); err != nil {
+			return err
+		}
+		p.log.Infof("Created inbox for %s", inboxID)
+	}
 
-	file, err := os.Create(fileName)
+	fileName := p.messagePath(inboxPath, messageID)
+	if dir := filepath.Dir(fileName); dir != inboxPath {
+		if err := os.MkdirAll(dir, 0775); err != nil {
+			return err
+		}
+	}
+
+	// never overwrite an existing message, even if the ids happened to collide
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
+		if os.IsExist(err) {
+			return ErrMessageIDCollision
+		}
 		return err
 	}
 	defer file.Close()
 
+	// the message which could not be fully stored is removed, so that it would never be delivered
 	_, err = file.Write(message)
 	if err != nil {
+		os.Remove(fileName) //nolint: errcheck
+		return err
+	}
+	// the modification time of the file is the time the message was received at, by the clock of the provider
+	receivedAt := p.clock.Now()
+	if err := os.Chtimes(fileName, receivedAt, receivedAt); err != nil {
+		os.Remove(fileName) //nolint: errcheck
 		return err
 	}
 
+	p.messageSizes.observe(len(message))
+
 	p.log.Infof("Stored message for %s", inboxID)
 	p.log.Infof("Stored message content: %v", string(message))
 	return nil
 }
 
-// NewProviderServer constructs a new provider object.
+// validInboxID checks whether the id can be safely used as the name of an inbox directory.
+func validInboxID(inboxID string) bool {
+	return inboxID != "" && inboxID != "." && inboxID != ".." && filepath.Base(inboxID) == inboxID
+}
+
+// messagePath returns the path of the message with the given id in the inbox at the given path.
+// If the inboxes are sharded, the message is stored in the shard named by the prefix of its id,
+// unless the id is too short to have one.
+func (p *ProviderServer) messagePath(inboxPath, messageID string) string {
+	if n := p.inboxShardPrefixLength; n > 0 && len(messageID) > n {
+		return filepath.Join(inboxPath, messageID[:n], messageID+".txt")
+	}
+	return filepath.Join(inboxPath, messageID+".txt")
+}
+
+// storedMessage is a single message stored in an inbox.
+type storedMessage struct {
+	path       string
+	size       int64
+	receivedAt time.Time
+}
+
+// sortMessages sorts the messages in the given order. The messages received at the same time
+// are ordered by their paths, so that the order would not depend on the file system.
+func sortMessages(messages []storedMessage, order MessageOrder) {
+	if order == StorageOrder {
+		return
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if order == NewestFirst {
+			a, b = b, a
+		}
+		if !a.receivedAt.Equal(b.receivedAt) {
+			return a.receivedAt.Before(b.receivedAt)
+		}
+		return a.path < b.path
+	})
+}
+
+// inboxMessages lists the messages stored in the inbox at the given path, both directly in it and in its shards,
+// so that the messages are found regardless of whether the inbox was sharded when they were stored.
+// The lock of the inbox must be held.
+func inboxMessages(path string) ([]storedMessage, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var messages []storedMessage
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if !entry.IsDir() {
+			messages = append(messages, storedMessage{path: entryPath, size: entry.Size(), receivedAt: entry.ModTime()})
+			continue
+		}
+		shard, err := ioutil.ReadDir(entryPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range shard {
+			if !f.IsDir() {
+				messages = append(messages, storedMessage{path: filepath.Join(entryPath, f.Name()),
+					size:       f.Size(),
+					receivedAt: f.ModTime(),
+				})
+			}
+		}
+	}
+	return messages, nil
+}
+
+// newMessageID generates a random, hex encoded, id for a stored message.
+// It has enough entropy for the collisions to be practically impossible.
+func newMessageID() (string, error) {
+	id := make([]byte, messageIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// newConnectionID generates a random correlation id for an incoming connection.
+func newConnectionID() (string, error) {
+	id := make([]byte, connectionIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// EnableStatelessTokens makes the provider issue tokens computed as HMAC(masterKey, clientID || expiry),
+// which are valid for the given duration. Such tokens are validated without any per-client state,
+// however, the tokens issued before calling EnableStatelessTokens are no longer accepted.
+func (p *ProviderServer) EnableStatelessTokens(masterKey *TokenMasterKey, validity time.Duration) {
+	p.tokens = newTokenIssuer(masterKey, validity, p.clock)
+}
+
+// SetClock sets the clock used by all the time-dependent behaviour of the provider, such as the expiry
+// of the packets and tokens, the processing rate limit and the periodic presence and inbox cleaning.
+// It should be called before the provider is started.
+func (p *ProviderServer) SetClock(c clock.Clock) {
+	p.clock = c
+	p.Mix.SetClock(c)
+	if p.tokens != nil {
+		p.tokens.clock = c
+	}
+}
+
+// SetInboxesDirectory sets the directory in which the inboxes of the clients are kept.
+// It should be called before the provider is started.
+func (p *ProviderServer) SetInboxesDirectory(dir string) {
+	p.inboxesDir = dir
+}
+
+// SetInboxSharding splits the inboxes into shards, i.e. subdirectories named by the first prefixLength characters
+// of the ids of the messages stored in them, so that the number of entries in each directory stays bounded
+// even for clients receiving many messages. The sharding is disabled if the length is 0. The messages stored
+// with a different setting are still found by the pulls. It returns ErrInvalidInboxShardPrefixLength
+// if the length is outside of the allowed range. It should be called before the provider is started.
+func (p *ProviderServer) SetInboxSharding(prefixLength int) error {
+	if err := ValidateInboxShardPrefixLength(prefixLength); err != nil {
+		return err
+	}
+	p.inboxShardPrefixLength = prefixLength
+	return nil
+}
+
+// SetTokenLength sets the length (in bytes) of the random tokens issued to the clients unless stateless tokens
+// are enabled. The tokens issued before keep their length. It returns ErrInvalidTokenLength if the length is
+// outside of the allowed range. It should be called before the provider is started.
+func (p *ProviderServer) SetTokenLength(length int) error {
+	if err := ValidateTokenLength(length); err != nil {
+		return err
+	}
+	p.tokenLength = length
+	return nil
+}
+
+// SetLogLevel changes the level of the provider's logger. It returns an error if the level is not recognised.
+func (p *ProviderServer) SetLogLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	p.log.SetLevel(lvl)
+	return nil
+}
+
+// SetLogFile makes the provider write its logs to the given file, which is appended to if it already exists.
+func (p *ProviderServer) SetLogFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	p.log.SetOutput(f)
+	return nil
+}
+
+// SetPullPadding makes the provider pad each pull response with dummy messages, so that the number of
+// the messages it contains is a multiple of the given bucket size. A non-positive bucket size disables the padding.
+// Note that the padding does not hide the number of the messages from a passive observer of the connection:
+// the responses are not encrypted, and the dummies are marked with the cleartext DummyFlag and are as large
+// as the last real message, so they are easily told apart. The padding only quantizes the count for
+// the observers which can't read the responses, e.g. if the connection is tunnelled over an encrypted transport,
+// though even then the total size of the response is revealed.
+func (p *ProviderServer) SetPullPadding(bucket int) {
+	if bucket < 0 {
+		bucket = 0
+	}
+	p.pullPaddingBucket = bucket
+}
+
+// SetPullLimits caps the number of the messages returned in each pull response and the size (in bytes)
+// of the response as sent to the client, including the framing, the padding and the concluding status,
+// so that a client with a large inbox could neither monopolise the provider nor receive more than it can handle.
+// The remaining messages are returned by the subsequent pulls. A non-positive value disables the respective cap.
+func (p *ProviderServer) SetPullLimits(maxMessages, maxBytes int) {
+	if maxMessages < 0 {
+		maxMessages = 0
+	}
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	p.maxPullMessages = maxMessages
+	p.maxPullBytes = maxBytes
+}
+
+// SetMaxConcurrentPulls sets how many pulls each client may have in progress at once. Any pulls above
+// the limit are rejected with ErrTooManyPulls. A non-positive limit removes it.
+func (p *ProviderServer) SetMaxConcurrentPulls(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	p.maxConcurrentPulls = limit
+}
+
+// SetMaxRegisteredClients sets how many clients may be registered with the provider, so that registering
+// arbitrarily many keys could not exhaust its memory and the inodes used by the inboxes. The registrations
+// of new clients above the limit are rejected with ErrTooManyClients, while the clients registered before
+// can still renew their registration. A non-positive limit removes it.
+func (p *ProviderServer) SetMaxRegisteredClients(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	p.maxClients = limit
+}
+
+// SetInboxCleanup sets how often the provider looks for the stale inboxes and how many inboxes
+// it sweeps at once. A non-positive concurrency makes the inboxes be swept one at a time.
+// It returns ErrInvalidCleanupInterval if the interval is not positive.
+// It should be called before the provider is started.
+func (p *ProviderServer) SetInboxCleanup(interval time.Duration, concurrency int) error {
+	if interval <= 0 {
+		return ErrInvalidCleanupInterval
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p.cleanupInterval = interval
+	p.sweepConcurrency = concurrency
+	return nil
+}
+
+// SetBindAddress sets the host:port the provider listens on, independently of the host and port advertised
+// in its presence. An empty host, as in ":1789", makes the provider listen on all the interfaces.
+// By default, or if the address is empty, the provider listens on the advertised host and port.
+// SetBindAddress returns ErrInvalidBindAddress if the address is malformed.
+func (p *ProviderServer) SetBindAddress(address string) error {
+	if address != "" {
+		if err := ValidateBindAddress(address); err != nil {
+			return err
+		}
+	}
+	p.bindAddress = address
+	return nil
+}
+
+// SetListenRetry sets how many times in total the provider tries to bind to its address on start
+// if the address is in use, and how long it initially waits between the attempts.
+// Other failures to bind are never retried.
+func (p *ProviderServer) SetListenRetry(attempts int, backoff time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	p.listenAttempts = attempts
+	p.listenBackoff = backoff
+}
+
+// SetPresenceWarmUp sets how long the provider waits, once it accepts connections, before it registers
+// its first presence, e.g. to let it load its inboxes before any client is routed to it. By default
+// the presence is registered as soon as the provider accepts connections.
+// It should be called before the provider is started.
+func (p *ProviderServer) SetPresenceWarmUp(warmUp time.Duration) {
+	if warmUp < 0 {
+		warmUp = 0
+	}
+	p.presenceWarmUp = warmUp
+}
+
+// SetRequireInitialPresence makes Start fail if the first presence of the provider can't be registered
+// at the directory server, e.g. because it is unreachable. By default the provider keeps running and retries
+// registering its presence every presence interval, so that it survives the outages of the directory server.
+// It should be called before the provider is started.
+func (p *ProviderServer) SetRequireInitialPresence(require bool) {
+	p.requireInitialPresence = require
+}
+
+// SetMessageOrder sets the order the messages are sent in to the clients pulling them.
+// By default the oldest messages are sent first. It should be called before the provider is started.
+func (p *ProviderServer) SetMessageOrder(order MessageOrder) {
+	p.messageOrder = order
+}
+
+// SetUnknownRecipientPolicy sets how the provider handles the messages for the clients without an inbox.
+// By default such messages are rejected.
+func (p *ProviderServer) SetUnknownRecipientPolicy(policy UnknownRecipientPolicy) {
+	p.recipientPolicy = policy
+}
+
+// NewProviderServer constructs a new provider object, which registers its presence at the directory server
+// with the given URL once it is started. If the URL is empty, the default directory server is used.
 // NewProviderServer returns a new provider object and an error.
 // TODO: same case as 'NewClient'
 func NewProviderServer(id string,
@@ -505,6 +1601,19 @@ func NewProviderServer(id string,
 	port string,
 	prvKey *sphinx.PrivateKey,
 	pubKey *sphinx.PublicKey,
+	directoryURL string,
+) (*ProviderServer, error) {
+	return NewProviderServerWithRegistrar(id, host, port, prvKey, pubKey, directoryRegistrar(directoryURL))
+}
+
+// NewProviderServerWithRegistrar works like NewProviderServer, but registers the presence of the provider
+// with the given registrar.
+func NewProviderServerWithRegistrar(id string,
+	host string,
+	port string,
+	prvKey *sphinx.PrivateKey,
+	pubKey *sphinx.PublicKey,
+	registrar PresenceRegistrar,
 ) (*ProviderServer, error) {
 	baseLogger, err := logger.New(defaultLogFileLocation, defaultLogLevel, false)
 	if err != nil {
@@ -515,31 +1624,38 @@ func NewProviderServer(id string,
 
 	node := node.NewMix(prvKey, pubKey)
 	providerServer := ProviderServer{id: id,
-		host:     host,
-		port:     port,
-		Mix:      node,
-		listener: nil,
-		haltedCh: make(chan struct{}),
-		log:      log,
+		host:       host,
+		port:       port,
+		Mix:        node,
+		listener:   nil,
+		inboxesDir: DefaultInboxesDir,
+		haltedCh:   make(chan struct{}),
+		clock:      clock.New(),
+		log:        log,
+
+		tokenLength: DefaultTokenLength,
+
+		maxPullMessages:    DefaultMaxPullMessages,
+		maxPullBytes:       DefaultMaxPullBytes,
+		maxConcurrentPulls: DefaultMaxConcurrentPulls,
+		listenAttempts:     DefaultListenAttempts,
+		listenBackoff:      DefaultListenBackoff,
+
+		cleanupInterval:  DefaultInboxCleanupInterval,
+		sweepConcurrency: DefaultSweepConcurrency,
+
+		presenceInterval:        DefaultPresenceInterval,
+		presenceIntervalChanged: make(chan struct{}, 1),
+
+		unknownFlagBanThreshold: DefaultUnknownFlagBanThreshold,
+		unknownFlagBanDuration:  DefaultUnknownFlagBanDuration,
 	}
 	providerServer.config = config.MixConfig{Id: providerServer.id,
 		Host:   providerServer.host,
 		Port:   providerServer.port,
 		PubKey: providerServer.GetPublicKey().Bytes()}
 	providerServer.assignedClients = make(map[string]ClientRecord)
-
-	if err := helpers.RegisterMixProviderPresence(providerServer.GetPublicKey(),
-		providerServer.convertRecordsToModelData(),
-		net.JoinHostPort(host, port),
-	); err != nil {
-		return nil, err
-	}
-
-	providerServer.listener, err = net.Listen("tcp", net.JoinHostPort(host, port))
-
-	if err != nil {
-		return nil, err
-	}
+	providerServer.registrar = registrar
 
 	return &providerServer, nil
 }
@@ -557,7 +1673,31 @@ func CreateTestProvider() (*ProviderServer, error) {
 	disabledLog := baseDisabledLogger.GetLogger("test")
 
 	node := node.NewMix(priv, pub)
-	provider := ProviderServer{host: "localhost", port: "9999", Mix: node, log: disabledLog}
+	provider := ProviderServer{host: "localhost",
+		port:       "9999",
+		Mix:        node,
+		inboxesDir: DefaultInboxesDir,
+		clock:      clock.New(),
+		log:        disabledLog,
+		registrar:  noopRegistrar{},
+
+		tokenLength: DefaultTokenLength,
+
+		maxPullMessages:    DefaultMaxPullMessages,
+		maxPullBytes:       DefaultMaxPullBytes,
+		maxConcurrentPulls: DefaultMaxConcurrentPulls,
+		listenAttempts:     DefaultListenAttempts,
+		listenBackoff:      DefaultListenBackoff,
+
+		cleanupInterval:  DefaultInboxCleanupInterval,
+		sweepConcurrency: DefaultSweepConcurrency,
+
+		presenceInterval:        DefaultPresenceInterval,
+		presenceIntervalChanged: make(chan struct{}, 1),
+
+		unknownFlagBanThreshold: DefaultUnknownFlagBanThreshold,
+		unknownFlagBanDuration:  DefaultUnknownFlagBanDuration,
+	}
 	provider.config = config.MixConfig{Id: provider.id,
 		Host:   provider.host,
 		Port:   provider.port,
@@ -566,3 +1706,24 @@ func CreateTestProvider() (*ProviderServer, error) {
 	provider.assignedClients = make(map[string]ClientRecord)
 	return &provider, nil
 }
+
+// TestDialer opens a new in-memory connection to the provider. Each connection is handled
+// exactly as if it was accepted by the provider's listener.
+type TestDialer func() net.Conn
+
+// CreateInMemoryTestProvider constructs a test provider, like CreateTestProvider, together with a TestDialer,
+// which allows to drive the entire connection handling of the provider, i.e. submitting packets
+// and reading back the responses, without using real TCP.
+func CreateInMemoryTestProvider() (*ProviderServer, TestDialer, error) {
+	provider, err := CreateTestProvider()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dial := func() net.Conn {
+		serverConn, clientConn := net.Pipe()
+		go provider.handleConnection(serverConn)
+		return clientConn
+	}
+	return provider, dial, nil
+}
@@ -15,19 +15,30 @@
 package provider
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/clock"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/nymtech/nym-mixnet/helpers"
+	"github.com/nymtech/nym-mixnet/node"
 	"github.com/nymtech/nym-mixnet/server/mixnode"
 	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -77,8 +88,7 @@ func TestProviderServer_AuthenticateUser_Pass(t *testing.T) {
 	key := []byte{1, 2, 3, 4, 5}
 	testToken := []byte("AuthenticationToken")
 	record := ClientRecord{id: "Alice", host: "localhost", port: "1111", pubKey: key, token: testToken}
-	b64Key := base64.URLEncoding.EncodeToString(key)
-	providerServer.assignedClients[b64Key] = record
+	providerServer.assignedClients[ClientID(key)] = record
 	assert.True(t,
 		providerServer.authenticateUser(key, []byte("AuthenticationToken")),
 		" Authentication should be successful",
@@ -88,8 +98,7 @@ func TestProviderServer_AuthenticateUser_Pass(t *testing.T) {
 func TestProviderServer_AuthenticateUser_Fail(t *testing.T) {
 	key := []byte{1, 2, 3, 4, 5}
 	record := ClientRecord{id: "Alice", host: "localhost", port: "1111", pubKey: key, token: []byte("AuthenticationToken")}
-	b64Key := base64.URLEncoding.EncodeToString(key)
-	providerServer.assignedClients[b64Key] = record
+	providerServer.assignedClients[ClientID(key)] = record
 	assert.False(t,
 		providerServer.authenticateUser(key, []byte("WrongAuthToken")),
 		" Authentication should not be successful",
@@ -173,8 +182,1582 @@ func TestProviderServer_ReceivedPacket(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = providerServer.receivedPacket(bSphinxPacket)
+	err = providerServer.receivedPacket("localhost", bSphinxPacket)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestProviderServer_ForwardsToIPv6Mix(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	defer listener.Close()
+	_, mixPort, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mixPriv, mixPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, egressPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix := config.MixConfig{Id: "IPv6Mix", Host: "::1", Port: mixPort, PubKey: mixPub.Bytes(), Layer: 1}
+	egress := config.MixConfig{Id: "IPv6Provider", Host: "::1", Port: "1789", PubKey: egressPub.Bytes()}
+	path := config.E2EPath{IngressProvider: providerServer.config,
+		Mixes:          []config.MixConfig{mix},
+		EgressProvider: egress,
+	}
+	sphinxPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bSphinxPacket, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := config.ReadFrame(conn)
+		received <- b
+	}()
+
+	processPacketNow(providerServer, "localhost", bSphinxPacket)
+
+	var forwarded []byte
+	select {
+	case forwarded = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the packet was not forwarded to the IPv6 mix")
+	}
+	packet, err := config.UnwrapPacket(forwarded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop, _, _, err := sphinx.ProcessSphinxPacket(packet.Data, mixPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "[::1]:1789", hop.Address)
+}
+
+func TestProviderServer_RegisterNewClient_Idempotent(t *testing.T) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Alice", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := providerServer.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientID := ClientID(pub.Bytes())
+	createTestMessage(clientID, t)
+
+	token2, err := providerServer.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, token, token2)
+
+	_, err = os.Stat(filepath.Join(DefaultInboxesDir, clientID, "TestMessage.txt"))
+	assert.Nil(t, err, "Re-registration should not affect the existing inbox")
+}
+
+func makeInboxStale(id string, t *testing.T) {
+	staleTime := time.Now().Add(-2 * staleInboxThreshold)
+	if err := os.Chtimes(filepath.Join(DefaultInboxesDir, id), staleTime, staleTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderServer_CleanStaleInboxes(t *testing.T) {
+	activeKey := []byte("ActiveClient")
+	activeID := base64.URLEncoding.EncodeToString(activeKey)
+	providerServer.assignedClients[activeID] = ClientRecord{id: activeID, pubKey: activeKey}
+	createInbox(activeID, t)
+	makeInboxStale(activeID, t)
+
+	staleEmptyID := "StaleEmptyInbox"
+	createInbox(staleEmptyID, t)
+	makeInboxStale(staleEmptyID, t)
+
+	staleNonEmptyID := "StaleNonEmptyInbox"
+	createInbox(staleNonEmptyID, t)
+	createTestMessage(staleNonEmptyID, t)
+	makeInboxStale(staleNonEmptyID, t)
+
+	freshEmptyID := "FreshEmptyInbox"
+	createInbox(freshEmptyID, t)
+
+	if err := providerServer.cleanStaleInboxes(staleInboxThreshold); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{activeID, staleNonEmptyID, freshEmptyID} {
+		exists, err := helpers.DirExists(filepath.Join(DefaultInboxesDir, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, exists, "Inbox %v should not have been removed", id)
+	}
+
+	exists, err := helpers.DirExists(filepath.Join(DefaultInboxesDir, staleEmptyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, exists, "Stale empty inbox should have been removed")
+}
+
+// panickingConn simulates a handler path blowing up while serving the connection.
+type panickingConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *panickingConn) Read(b []byte) (int, error) {
+	panic("injected panic")
+}
+
+func (c *panickingConn) Close() error {
+	c.closed = true
+	return c.Conn.Close()
+}
+
+func TestProviderServer_HandleConnection_RecoversFromPanic(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	conn := &panickingConn{Conn: serverConn}
+	assert.NotPanics(t, func() { providerServer.handleConnection(conn) })
+	assert.True(t, conn.closed, "Connection should have been closed after recovering from the panic")
+
+	// and the provider should still be able to serve subsequent requests
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Bob", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignPacket, err := config.WrapWithFlag(flags.AssignFlag, clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn = net.Pipe()
+	defer clientConn.Close()
+	go providerServer.handleConnection(serverConn)
+
+	if _, err := clientConn.Write(assignPacket); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := config.ReadFrame(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := config.UnwrapPacket(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, flags.TokenFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+}
+
+// processPacketNow processes the packet exactly as receivedPacket does, but blocking for its delay.
+func processPacketNow(p *ProviderServer, peer string, packet []byte) {
+	res, err := p.ProcessPacket(packet)
+	p.handleProcessedPacket(peer, res, err)
+}
+
+func TestProviderServer_ProcessPacket_RecoversFromPanic(t *testing.T) {
+	// a sphinx packet with a missing header
+	packetBytes, err := proto.Marshal(&sphinx.SphinxPacket{Pld: []byte("foomp")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotPanics(t, func() { assert.Nil(t, providerServer.receivedPacket("localhost", packetBytes)) })
+	// the packets are handled in goroutines of their own once their delays elapse
+	assert.NotPanics(t, func() { providerServer.handleProcessedPacket("localhost", nil, nil) })
+}
+
+// peakHeapWriter discards everything written to it while keeping track of the peak heap size.
+// The heap is measured after a garbage collection, every gcInterval writes, so that the measurement
+// reflects the live data rather than how far behind the collector is.
+type peakHeapWriter struct {
+	peakHeap uint64
+	writes   int
+}
+
+const gcInterval = 64
+
+func (w *peakHeapWriter) Write(b []byte) (int, error) {
+	w.writes++
+	if w.writes%gcInterval != 1 {
+		return len(b), nil
+	}
+	runtime.GC()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapAlloc > w.peakHeap {
+		w.peakHeap = memStats.HeapAlloc
+	}
+	return len(b), nil
+}
+
+func TestProviderServer_HandlePullRequest_BoundedMemory(t *testing.T) {
+	const (
+		numMessages = 1024
+		messageSize = 16 * 1024
+	)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Carol", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := providerServer.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientID := ClientID(pub.Bytes())
+	message := make([]byte, messageSize)
+	for i := 0; i < numMessages; i++ {
+		messagePath := filepath.Join(DefaultInboxesDir, clientID, fmt.Sprintf("TestMessage%v.txt", i))
+		if err := ioutil.WriteFile(messagePath, message, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pullRqsBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.GC()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	baseHeap := memStats.HeapAlloc
+
+	// the whole inbox is pulled at once
+	providerServer.SetPullLimits(0, 0)
+	defer providerServer.SetPullLimits(DefaultMaxPullMessages, DefaultMaxPullBytes)
+
+	w := &peakHeapWriter{}
+	bw := bufio.NewWriter(w)
+	assert.Nil(t, providerServer.handlePullRequest(pullRqsBytes, bw))
+	assert.Nil(t, bw.Flush())
+
+	// if the whole inbox was buffered in memory, the heap would have grown by at least its size
+	assert.True(t, w.peakHeap < baseHeap+numMessages*messageSize/4,
+		"Heap grew by %v bytes while streaming %v bytes inbox",
+		w.peakHeap-baseHeap,
+		numMessages*messageSize,
+	)
+
+	files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, clientID))
+	assert.Nil(t, err)
+	assert.Len(t, files, 0, "All messages should have been removed from the inbox")
+}
+
+// sendToHandler passes the given bytes to handleConnection and waits until the connection is handled.
+// It returns everything the provider sent back before closing the connection.
+func sendToHandler(t *testing.T, data []byte) []byte {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		providerServer.handleConnection(serverConn)
+		close(done)
+	}()
+
+	if _, err := clientConn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	response, err := ioutil.ReadAll(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return response
+}
+
+// assertErrorResponse checks that the response consists of a single error response with the given code.
+func assertErrorResponse(t *testing.T, code config.ErrorCode, packets ...config.GeneralPacket) {
+	if !assert.Len(t, packets, 1) {
+		return
+	}
+	if !assert.Equal(t, flags.ErrorFlag, flags.PacketTypeFlagFromBytes(packets[0].Flag)) {
+		return
+	}
+	providerErr, err := config.UnwrapError(packets[0].Data)
+	if assert.Nil(t, err) {
+		assert.Equal(t, code, providerErr.Code)
+		assert.True(t, config.IsProviderError(providerErr, code))
+	}
+}
+
+// unwrapResponse parses the frames of the response sent back by the provider.
+func unwrapResponse(t *testing.T, response []byte) []config.GeneralPacket {
+	var packets []config.GeneralPacket
+	frames := config.NewFrameReader(bytes.NewReader(response))
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := config.UnwrapPacket(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+}
+
+// splitPullResponse splits the response to a pull request into the messages and the status of the inbox
+// concluding it.
+func splitPullResponse(t *testing.T, responses []config.GeneralPacket) ([]config.GeneralPacket, config.InboxStatus) {
+	if len(responses) == 0 || flags.PacketTypeFlagFromBytes(responses[len(responses)-1].Flag) != flags.InboxStatusFlag {
+		t.Fatal("The pull response is not concluded by the status of the inbox")
+	}
+	status, err := config.UnwrapInboxStatus(responses[len(responses)-1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return responses[:len(responses)-1], status
+}
+
+func TestProviderServer_HandleConnection_RejectsMalformedPackets(t *testing.T) {
+	packetBytes, err := config.WrapWithFlag(flags.CommFlag, []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	malformedPackets := [][]byte{
+		packetBytes[:len(packetBytes)-2],
+		append([]byte(config.PacketMagic), config.PacketVersion, 0xff, 0xff, 0xff),
+		[]byte("garbage"),
+	}
+
+	malformedBefore, unknownBefore := providerServer.drops.Count(node.DropMalformed), providerServer.drops.Count(node.DropUnknownFlag)
+
+	for _, packet := range malformedPackets {
+		assertErrorResponse(t, config.ErrorCodeMalformedRequest, unwrapResponse(t, sendToHandler(t, packet))...)
+	}
+
+	assert.Equal(t, malformedBefore+uint(len(malformedPackets)), providerServer.drops.Count(node.DropMalformed))
+	assert.Equal(t, unknownBefore, providerServer.drops.Count(node.DropUnknownFlag))
+}
+
+func TestProviderServer_HandleConnection_RejectsUnknownFlag(t *testing.T) {
+	packetBytes, err := config.WrapWithFlag(flags.PacketTypeFlag(0x42), []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	malformedBefore, unknownBefore := providerServer.drops.Count(node.DropMalformed), providerServer.drops.Count(node.DropUnknownFlag)
+
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, unwrapResponse(t, sendToHandler(t, packetBytes))...)
+
+	assert.Equal(t, malformedBefore, providerServer.drops.Count(node.DropMalformed))
+	assert.Equal(t, unknownBefore+1, providerServer.drops.Count(node.DropUnknownFlag))
+}
+
+// newTestRecipient generates a key of a recipient and returns its address, as used in the packets,
+// and the id of its inbox.
+func newTestRecipient(t testing.TB) (string, string) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.URLEncoding.EncodeToString(pub.Bytes()), ClientID(pub.Bytes())
+}
+
+// createFinalHopPacket creates a sphinx packet for the given recipient, as seen by p acting as the egress provider.
+func createFinalHopPacket(t testing.TB, p *ProviderServer, recipientID string, message []byte) []byte {
+	return createExpiringFinalHopPacket(t, p, recipientID, message, time.Time{})
+}
+
+// createExpiringFinalHopPacket works like createFinalHopPacket, but the packet expires at the given time.
+func createExpiringFinalHopPacket(t testing.TB,
+	p *ProviderServer,
+	recipientID string,
+	message []byte,
+	expiry time.Time,
+) []byte {
+	path := config.E2EPath{IngressProvider: p.config,
+		EgressProvider: p.config,
+		Recipient:      config.ClientConfig{Id: recipientID},
+	}
+	sphinxPacket, err := sphinx.PackForwardMessageWithExpiry(path, []float64{0.0, 0.0}, message, sphinx.DefaultMaxDelay, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bSphinxPacket, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// strip the ingress layer, so that the packet would not need to be forwarded over the network
+	res, err := p.ProcessPacket(bSphinxPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.PacketData()
+}
+
+// exchange submits the packet through a fresh in-memory connection and returns all packets sent back
+// by the provider before it closed the connection.
+func exchange(t *testing.T, dial TestDialer, flag flags.PacketTypeFlag, data []byte) []config.GeneralPacket {
+	packetBytes, err := config.WrapWithFlag(flag, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dial()
+	defer conn.Close()
+	if _, err := conn.Write(packetBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []config.GeneralPacket
+	frames := config.NewFrameReader(bufio.NewReader(conn))
+	for {
+		frame, err := frames.Next()
+		if err == io.EOF {
+			return responses
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := config.UnwrapPacket(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, packet)
+	}
+}
+
+func TestProviderServer_InMemory_AssignStorePull(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+
+	// assign
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := exchange(t, dial, flags.AssignFlag, clientBytes)
+	if !assert.Len(t, responses, 1) {
+		return
+	}
+	assert.Equal(t, flags.TokenFlag, flags.PacketTypeFlagFromBytes(responses[0].Flag))
+	token := responses[0].Data
+	assert.True(t, p.isRegistered(clientID))
+
+	// store
+	msg := createFinalHopPacket(t, p, address, []byte("Hello world"))
+	assert.Empty(t, exchange(t, dial, flags.CommFlag, msg))
+	// the packet is processed in the background, after its delay
+	if !assert.Eventually(t, func() bool {
+		files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, clientID))
+		return err == nil && len(files) == 1
+	}, 5*time.Second, 10*time.Millisecond) {
+		return
+	}
+
+	// pull
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses, status := splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Equal(t, config.InboxStatusDelivered, status)
+	if !assert.Len(t, responses, 1) {
+		return
+	}
+	assert.Equal(t, flags.CommFlag, flags.PacketTypeFlagFromBytes(responses[0].Flag))
+	// the message is stored as it was unwrapped at the last hop
+	assert.Equal(t, []byte("Hello world"), responses[0].Data)
+
+	// the inbox was emptied by the previous pull
+	responses, status = splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Empty(t, responses)
+	assert.Equal(t, config.InboxStatusEmpty, status)
+}
+
+func TestProviderServer_InMemory_PullWithoutInbox(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := exchange(t, dial, flags.AssignFlag, clientBytes)
+	if !assert.Len(t, responses, 1) {
+		return
+	}
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: responses[0].Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the inbox is lost, while the client is still authenticated
+	if err := os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID)); err != nil {
+		t.Fatal(err)
+	}
+	responses, status := splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Empty(t, responses)
+	assert.Equal(t, config.InboxStatusNoInbox, status)
+
+	// unless the provider consults its registry, in which case the inbox of the registered client is recreated
+	p.SetMissingInboxPolicy(ConsultRegistry)
+	responses, status = splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Empty(t, responses)
+	assert.Equal(t, config.InboxStatusEmpty, status)
+	assert.DirExists(t, filepath.Join(DefaultInboxesDir, clientID))
+}
+
+func TestProviderServer_InMemory_PullWithInvalidToken(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, exchange(t, dial, flags.AssignFlag, clientBytes), 1)
+	msg := createFinalHopPacket(t, p, address, []byte("Hello world"))
+	if err := p.storeMessage(msg, clientID, "msg"); err != nil {
+		t.Fatal(err)
+	}
+
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: []byte("foomp")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client is told why the pull failed rather than the connection being closed silently
+	assertErrorResponse(t, config.ErrorCodeAuthenticationFailed, exchange(t, dial, flags.PullFlag, pullBytes)...)
+
+	// the message is still in the inbox
+	files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, clientID))
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestProviderServer_InMemory_MalformedRequests(t *testing.T) {
+	_, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, exchange(t, dial, flags.AssignFlag, []byte("foomp"))...)
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, exchange(t, dial, flags.PullFlag, []byte("foomp"))...)
+}
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		err  error
+		code config.ErrorCode
+	}{
+		{ErrAuthenticationFailed, config.ErrorCodeAuthenticationFailed},
+		{ErrTooManyPulls, config.ErrorCodeRateLimited},
+		{ErrMalformedRequest, config.ErrorCodeMalformedRequest},
+		{ErrInvalidToken, config.ErrorCodeInternal},
+		{errors.New("open /var/lib/inboxes/foomp: permission denied"), config.ErrorCodeInternal},
+	}
+	for _, test := range tests {
+		code, message := errorResponse(test.err)
+		assert.Equal(t, test.code, code, "Wrong code for %v", test.err)
+		if code == config.ErrorCodeInternal {
+			assert.NotContains(t, message, test.err.Error(), "The details of internal errors should not be disclosed")
+		}
+	}
+}
+
+func TestProviderServer_InMemory_DropsExpiredPacket(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	createInbox(clientID, t)
+
+	// the ingress layer is stripped while the provider still tolerates the expired packet
+	p.SetClockSkewTolerance(3 * time.Minute)
+	msg := createExpiringFinalHopPacket(t, p, address, []byte("Hello world"), time.Now().Add(-2*time.Minute))
+	p.SetClockSkewTolerance(0)
+
+	assert.Empty(t, exchange(t, dial, flags.CommFlag, msg))
+	assert.Eventually(t, func() bool {
+		return p.drops.Count(node.DropExpired) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, clientID))
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestProviderServer_InMemory_Stream(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	createInbox(clientID, t)
+
+	const numMessages = 5
+	conn := dial()
+	w := bufio.NewWriter(conn)
+	for i := 0; i < numMessages; i++ {
+		msg := createFinalHopPacket(t, p, address, []byte(fmt.Sprintf("Hello world %v", i)))
+		packetBytes, err := config.WrapWithFlag(flags.CommFlag, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, config.WriteFrame(w, packetBytes))
+	}
+	// other packets can't be sent in a stream
+	assignPacket, err := config.WrapWithFlag(flags.AssignFlag, []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, config.WriteFrame(w, assignPacket))
+	assert.Nil(t, w.Flush())
+	assert.Nil(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, clientID))
+		return err == nil && len(files) == numMessages
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return p.drops.Count(node.DropUnknownFlag) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProviderServer_InMemory_UnknownRecipient(t *testing.T) {
+	for _, policy := range []UnknownRecipientPolicy{RejectUnknownRecipients, CreateInboxOnDemand} {
+		p, dial, err := CreateInMemoryTestProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetUnknownRecipientPolicy(policy)
+		recipientAddress, recipientID := newTestRecipient(t)
+		inboxPath := filepath.Join(DefaultInboxesDir, recipientID)
+		defer os.RemoveAll(inboxPath)
+
+		msg := createFinalHopPacket(t, p, recipientAddress, []byte("Hello world"))
+		assert.Empty(t, exchange(t, dial, flags.CommFlag, msg))
+
+		switch policy {
+		case RejectUnknownRecipients:
+			assert.Eventually(t, func() bool {
+				return p.drops.Count(node.DropUnknownRecipient) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			exists, err := helpers.DirExists(inboxPath)
+			assert.Nil(t, err)
+			assert.False(t, exists, "Inbox of an unknown recipient should not have been created")
+
+		case CreateInboxOnDemand:
+			assert.Eventually(t, func() bool {
+				files, err := ioutil.ReadDir(inboxPath)
+				return err == nil && len(files) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, uint(0), p.drops.Count(node.DropUnknownRecipient))
+		}
+	}
+}
+
+func TestProviderServer_InMemory_RequireRegistration(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetUnknownRecipientPolicy(RequireRegistration)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registeredAddress := base64.URLEncoding.EncodeToString(pub.Bytes())
+	registeredID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, registeredID))
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: registeredAddress, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, exchange(t, dial, flags.AssignFlag, clientBytes), 1)
+
+	// the inbox of the unregistered recipient exists, e.g. left behind by a previous run of the provider
+	unregisteredAddress, unregisteredID := newTestRecipient(t)
+	unregisteredInbox := filepath.Join(DefaultInboxesDir, unregisteredID)
+	if err := os.MkdirAll(unregisteredInbox, 0775); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(unregisteredInbox)
+
+	assert.Empty(t, exchange(t, dial, flags.CommFlag, createFinalHopPacket(t, p, unregisteredAddress, []byte("Hello world"))))
+	assert.Eventually(t, func() bool {
+		return p.drops.Count(node.DropUnknownRecipient) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	files, err := ioutil.ReadDir(unregisteredInbox)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+
+	assert.Empty(t, exchange(t, dial, flags.CommFlag, createFinalHopPacket(t, p, registeredAddress, []byte("Hello world"))))
+	assert.Eventually(t, func() bool {
+		files, err := ioutil.ReadDir(filepath.Join(DefaultInboxesDir, registeredID))
+		return err == nil && len(files) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint(1), p.drops.Count(node.DropUnknownRecipient))
+}
+
+func TestProviderServer_StoreMessage_InvalidRecipient(t *testing.T) {
+	p, _, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+
+	for _, recipientID := range []string{"", ".", "..", "../foo", "foo/bar"} {
+		assert.Equal(t, ErrInvalidRecipient, p.storeMessage([]byte("foomp"), recipientID, "msg"))
+	}
+}
+
+func TestProviderServer_MessageOrder(t *testing.T) {
+	// the ids of the messages sort in the opposite order to the one they are received in
+	received := []struct {
+		id      string
+		content string
+	}{
+		{"c", "first"},
+		{"b", "second"},
+		{"a", "third"},
+	}
+	orders := map[MessageOrder][]string{
+		OldestFirst: {"first", "second", "third"},
+		NewestFirst: {"third", "second", "first"},
+	}
+	for order, expected := range orders {
+		p, err := CreateTestProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inboxesDir, err := ioutil.TempDir("", "provider-order")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(inboxesDir)
+		p.SetInboxesDirectory(inboxesDir)
+		p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+		clk := clock.NewMock(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC))
+		p.SetClock(clk)
+		p.SetMessageOrder(order)
+
+		const inboxID = "OrderedInbox"
+		for _, message := range received {
+			if err := p.storeMessage([]byte(message.content), inboxID, message.id); err != nil {
+				t.Fatal(err)
+			}
+			clk.Advance(time.Second)
+		}
+
+		// the order is kept across the pulls limited to fewer messages than there are in the inbox
+		p.SetPullLimits(2, 0)
+		var fetched []string
+		for i := 0; i < 2; i++ {
+			var response bytes.Buffer
+			status, err := p.fetchMessages(inboxID, &response)
+			assert.Nil(t, err)
+			assert.Equal(t, config.InboxStatusDelivered, status)
+			for _, packet := range unwrapResponse(t, response.Bytes()) {
+				fetched = append(fetched, string(packet.Data))
+			}
+		}
+		assert.Equal(t, expected, fetched, "Wrong order of the messages fetched with order %v", order)
+	}
+}
+
+func TestParseMessageOrder(t *testing.T) {
+	for name, expected := range messageOrderNames {
+		order, err := ParseMessageOrder(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, order)
+	}
+	_, err := ParseMessageOrder("foomp")
+	assert.Equal(t, ErrUnknownMessageOrder, err)
+}
+
+func TestProviderServer_ShardedInbox(t *testing.T) {
+	const numMessages = 200
+
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxesDir, err := ioutil.TempDir("", "provider-sharding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(inboxesDir)
+	p.SetInboxesDirectory(inboxesDir)
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+	p.SetPullLimits(0, 0)
+
+	assert.Equal(t, ErrInvalidInboxShardPrefixLength, p.SetInboxSharding(-1))
+	assert.Equal(t, ErrInvalidInboxShardPrefixLength, p.SetInboxSharding(MaxInboxShardPrefixLength+1))
+
+	// a message stored before the sharding was enabled is still retrieved
+	inboxID := "ShardedInbox"
+	if err := p.storeMessage([]byte("unsharded"), inboxID, "unsharded"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, p.SetInboxSharding(1))
+	for i := 0; i < numMessages; i++ {
+		msgID, err := newMessageID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.storeMessage([]byte(msgID), inboxID, msgID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(inboxesDir, inboxID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, sharded := 0, 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		assert.Len(t, entry.Name(), 1)
+		files, err := ioutil.ReadDir(filepath.Join(inboxesDir, inboxID, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, len(files) < numMessages, "The messages should have been distributed across the shards")
+		shards++
+		sharded += len(files)
+	}
+	assert.Equal(t, numMessages, sharded)
+	assert.True(t, shards > 1 && shards <= 16, "Unexpected number of shards %v", shards)
+	count, err := p.InboxMessageCount(inboxID)
+	assert.Nil(t, err)
+	assert.Equal(t, numMessages+1, count)
+
+	var response bytes.Buffer
+	status, err := p.fetchMessages(inboxID, &response)
+	assert.Nil(t, err)
+	assert.Equal(t, config.InboxStatusDelivered, status)
+	assert.Len(t, unwrapResponse(t, response.Bytes()), numMessages+1)
+
+	count, err = p.InboxMessageCount(inboxID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count, "All messages should have been removed from the shards")
+
+	// an inbox left with just the empty shards is stale
+	unlock := p.inboxLocks.lock(inboxID)
+	p.removeStaleInbox(inboxID)
+	unlock()
+	_, err = os.Stat(filepath.Join(inboxesDir, inboxID))
+	assert.True(t, os.IsNotExist(err), "The stale inbox should have been removed")
+}
+
+func TestNewMessageID(t *testing.T) {
+	const numIDs = 10000
+	ids := make(map[string]struct{}, numIDs)
+	for i := 0; i < numIDs; i++ {
+		id, err := newMessageID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, id, 2*messageIDLength)
+		assert.Equal(t, id, filepath.Base(id), "The id must be usable as a file name")
+		ids[id] = struct{}{}
+	}
+	assert.Len(t, ids, numIDs, "The generated ids should be unique")
+}
+
+func TestProviderServer_StoreMessage_IDCollision(t *testing.T) {
+	p, _, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxID := "CollisionInbox"
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, inboxID))
+	createInbox(inboxID, t)
+
+	original := []byte("original message")
+	assert.Nil(t, p.storeMessage(original, inboxID, "msg"))
+	assert.Equal(t, ErrMessageIDCollision, p.storeMessage([]byte("foomp"), inboxID, "msg"))
+
+	dat, err := ioutil.ReadFile(filepath.Join(DefaultInboxesDir, inboxID, "msg.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, original, dat, "The original message should not have been overwritten")
+}
+
+func TestProviderServer_Clients_ConcurrentRegistration(t *testing.T) {
+	p, _, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numClients = 20
+	clientIDs := make(map[string]struct{}, numClients)
+	clientsBytes := make([][]byte, numClients)
+	for i := range clientsBytes {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		address := base64.URLEncoding.EncodeToString(pub.Bytes())
+		clientID := ClientID(pub.Bytes())
+		clientIDs[clientID] = struct{}{}
+		defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+		clientsBytes[i], err = proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, clientBytes := range clientsBytes {
+		wg.Add(2)
+		go func(clientBytes []byte) {
+			defer wg.Done()
+			_, err := p.registerNewClient(clientBytes)
+			assert.Nil(t, err)
+		}(clientBytes)
+		go func() {
+			defer wg.Done()
+			for _, record := range p.Clients() {
+				_, ok := clientIDs[record.ID()]
+				assert.True(t, ok, "Snapshot should contain only the registered clients")
+			}
+			assert.True(t, len(p.convertRecordsToModelData()) <= numClients)
+		}()
+	}
+	wg.Wait()
+
+	clients := p.Clients()
+	assert.Len(t, clients, numClients)
+	for _, record := range clients {
+		_, ok := clientIDs[record.ID()]
+		assert.True(t, ok)
+		assert.Equal(t, record.ID(), ClientID(record.ClientConfig().PubKey))
+		assert.Equal(t, record.ClientConfig().Id, base64.URLEncoding.EncodeToString(record.ClientConfig().PubKey))
+	}
+
+	// modifying the snapshot must not affect the registry
+	clients[0].pubKey[0] ^= 0xff
+	for _, record := range p.Clients() {
+		_, ok := clientIDs[record.ID()]
+		assert.True(t, ok)
+		assert.Equal(t, record.ID(), ClientID(record.pubKey))
+	}
+}
+
+func TestProviderServer_MaxRegisteredClients(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxesDir, err := ioutil.TempDir("", "max-clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(inboxesDir)
+	p.SetInboxesDirectory(inboxesDir)
+
+	const limit = 3
+	p.SetMaxRegisteredClients(limit)
+
+	clientsBytes := make([][]byte, limit+1)
+	for i := range clientsBytes {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientsBytes[i], err = proto.Marshal(&config.ClientConfig{Id: fmt.Sprintf("Client%v", i), PubKey: pub.Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens := make([][]byte, limit)
+	for i := range tokens {
+		tokens[i], err = p.registerNewClient(clientsBytes[i])
+		assert.Nil(t, err)
+	}
+	_, err = p.registerNewClient(clientsBytes[limit])
+	assert.Equal(t, ErrTooManyClients, err)
+	assert.Len(t, p.Clients(), limit)
+	files, err := ioutil.ReadDir(inboxesDir)
+	assert.Nil(t, err)
+	assert.Len(t, files, limit, "No inbox should have been created for the rejected client")
+
+	// the registered clients can still renew their registration
+	token, err := p.registerNewClient(clientsBytes[0])
+	assert.Nil(t, err)
+	assert.Equal(t, tokens[0], token)
+
+	p.SetMaxRegisteredClients(0)
+	_, err = p.registerNewClient(clientsBytes[limit])
+	assert.Nil(t, err)
+}
+
+func TestProviderServer_RegisterNewClient_ConcurrentSameClient(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		provider, clk, cleanup := createMockClockProvider(t)
+		defer cleanup()
+		if stateless {
+			masterKey, err := GenerateTokenMasterKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider.EnableStatelessTokens(masterKey, time.Hour)
+		}
+
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Frank", PubKey: pub.Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const numRequests = 20
+		tokens := make([][]byte, numRequests)
+		var wg sync.WaitGroup
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				token, err := provider.registerNewClient(clientBytes)
+				assert.Nil(t, err)
+				tokens[i] = token
+				// the time passing between the requests must not affect the token either
+				clk.Advance(time.Second)
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Len(t, provider.Clients(), 1)
+		for _, token := range tokens {
+			assert.Equal(t, tokens[0], token, "All the registrations should have returned the same token")
+		}
+		assert.True(t, provider.authenticateUser(pub.Bytes(), tokens[0]))
+
+		if stateless {
+			// once the token expires, a new one is issued
+			clk.Advance(time.Hour)
+			token, err := provider.registerNewClient(clientBytes)
+			assert.Nil(t, err)
+			assert.NotEqual(t, tokens[0], token)
+			assert.True(t, provider.authenticateUser(pub.Bytes(), token))
+		}
+	}
+}
+
+func TestPaddedCount(t *testing.T) {
+	assert.Equal(t, 3, paddedCount(3, 0))
+	assert.Equal(t, 4, paddedCount(0, 4))
+	assert.Equal(t, 4, paddedCount(1, 4))
+	assert.Equal(t, 4, paddedCount(3, 4))
+	assert.Equal(t, 4, paddedCount(4, 4))
+	assert.Equal(t, 8, paddedCount(5, 4))
+}
+
+func TestProviderServer_InMemory_PaddedPull(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bucket = 4
+	p.SetPullPadding(bucket)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numMessages = 3
+	for i := 0; i < numMessages; i++ {
+		msg := createFinalHopPacket(t, p, address, []byte(fmt.Sprintf("Hello world %v", i)))
+		if err := p.storeMessage(msg, clientID, fmt.Sprintf("msg%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses, _ := splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Len(t, responses, bucket)
+	realCount, dummies := 0, 0
+	for _, response := range responses {
+		switch flags.PacketTypeFlagFromBytes(response.Flag) {
+		case flags.CommFlag:
+			realCount++
+		case flags.DummyFlag:
+			dummies++
+			assert.Len(t, response.Data, len(responses[0].Data), "Dummies should match the size of the real messages")
+		}
+	}
+	assert.Equal(t, numMessages, realCount)
+	assert.Equal(t, bucket-numMessages, dummies)
+
+	// the now empty inbox is padded to a full bucket as well
+	responses, _ = splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Len(t, responses, bucket)
+	for _, response := range responses {
+		assert.Equal(t, flags.DummyFlag, flags.PacketTypeFlagFromBytes(response.Flag))
+	}
+}
+
+func createMockClockProvider(t *testing.T) (*ProviderServer, *clock.Mock, func()) {
+	provider, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.SetInboxesDirectory(dir)
+	clk := clock.NewMock(time.Now())
+	provider.SetClock(clk)
+	return provider, clk, func() { os.RemoveAll(dir) }
+}
+
+func TestProviderServer_MockClock_TokenExpiry(t *testing.T) {
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.EnableStatelessTokens(masterKey, time.Hour)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Erin", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, provider.authenticateUser(pub.Bytes(), token))
+	clk.Advance(time.Hour)
+	assert.False(t, provider.authenticateUser(pub.Bytes(), token), "The token should have expired")
+}
+
+func TestProviderServer_MockClock_CleanStaleInboxes(t *testing.T) {
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	inboxPath := filepath.Join(provider.inboxesDir, "UnregisteredClient")
+	if err := os.Mkdir(inboxPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, provider.cleanStaleInboxes(staleInboxThreshold))
+	assert.DirExists(t, inboxPath, "Fresh inbox should not have been removed")
+
+	clk.Advance(staleInboxThreshold + time.Minute)
+	assert.Nil(t, provider.cleanStaleInboxes(staleInboxThreshold))
+	_, err := os.Stat(inboxPath)
+	assert.True(t, os.IsNotExist(err), "Stale inbox should have been removed")
+}
+
+func TestProviderServer_MockClock_Presence(t *testing.T) {
+	presences := make(chan struct{}, 1)
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	provider.registrar = &recordingRegistrar{notify: presences}
+	provider.haltedCh = make(chan struct{})
+	defer close(provider.haltedCh)
+	go provider.startSendingPresence(closedChannel())
+
+	// the first presence is sent straight away, as the provider already accepts connections
+	<-presences
+	clk.BlockUntil(1)
+	select {
+	case <-presences:
+		t.Fatal("The presence should not have been sent before the interval elapsed")
+	default:
+	}
+
+	clk.Advance(DefaultPresenceInterval)
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the interval elapsed")
+	}
+}
+
+func TestProviderServer_ListenRetriesWhileAddressInUse(t *testing.T) {
+	occupied, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(occupied.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.host = "localhost"
+	p.port = port
+
+	p.SetListenRetry(1, 0)
+	assert.True(t, helpers.IsTransientListenError(p.listen()))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		occupied.Close()
+	}()
+	p.SetListenRetry(10, 20*time.Millisecond)
+	if assert.Nil(t, p.listen()) {
+		p.listener.Close()
+	}
+}
+
+func TestProviderServer_ListenFailsFastOnPermanentError(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.port = "99999"
+	p.SetListenRetry(5, time.Hour)
+
+	err = p.listen()
+	assert.NotNil(t, err)
+	assert.False(t, helpers.IsTransientListenError(err))
+}
+
+// entryRecorder is a log hook recording all the entries logged at any level.
+type entryRecorder struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (r *entryRecorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *entryRecorder) Fire(entry *logrus.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+// takeEntries returns the entries recorded so far and forgets them.
+func (r *entryRecorder) takeEntries() []*logrus.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := r.entries
+	r.entries = nil
+	return entries
+}
+
+func TestProviderServer_InMemory_ConnectionCorrelationIDs(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &entryRecorder{}
+	p.log = logrus.New()
+	p.log.SetOutput(ioutil.Discard)
+	p.log.SetLevel(logrus.TraceLevel)
+	p.log.AddHook(recorder)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+
+	// assign
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := exchange(t, dial, flags.AssignFlag, clientBytes)
+	if !assert.Len(t, responses, 1) {
+		return
+	}
+	assignEntries := recorder.takeEntries()
+
+	if err := p.storeMessage([]byte("foomp"), clientID, "msg"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.takeEntries()
+
+	// pull
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: responses[0].Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulled, _ := splitPullResponse(t, exchange(t, dial, flags.PullFlag, pullBytes))
+	assert.Len(t, pulled, 1)
+	pullEntries := recorder.takeEntries()
+
+	// only the entries logged by handleConnection itself carry the id
+	connectionID := func(entries []*logrus.Entry) string {
+		id := ""
+		for _, entry := range entries {
+			entryID, ok := entry.Data[ConnectionIDField].(string)
+			if !ok {
+				continue
+			}
+			if id == "" {
+				id = entryID
+			}
+			assert.Equal(t, id, entryID, "The entry %q carries a different correlation id", entry.Message)
+		}
+		return id
+	}
+	assignID := connectionID(assignEntries)
+	pullID := connectionID(pullEntries)
+	assert.NotEmpty(t, assignID)
+	assert.NotEqual(t, assignID, pullID)
+}
+
+// createBenchmarkProvider creates a test provider storing the messages in a temporary inbox directory,
+// which is removed by the returned function.
+func createBenchmarkProvider(b *testing.B) (*ProviderServer, func()) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		b.Fatal(err)
+	}
+	inboxesDir, err := ioutil.TempDir("", "provider-benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	p.SetInboxesDirectory(inboxesDir)
+	return p, func() { os.RemoveAll(inboxesDir) }
+}
+
+// assertNoDrops fails the benchmark if any of the processed packets was dropped,
+// in which case it would not have measured the intended path.
+func assertNoDrops(b *testing.B, p *ProviderServer) {
+	if drops := p.DroppedPackets(); len(drops) > 0 {
+		b.Fatalf("Packets were dropped: %v", drops)
+	}
+}
+
+// BenchmarkReceiveAndStore measures the processing and storing of last-hop packets.
+// Each packet can only be processed once, as it would be dropped as a replay otherwise,
+// hence a distinct packet is packed for every iteration before the timer starts.
+func BenchmarkReceiveAndStore(b *testing.B) {
+	p, cleanup := createBenchmarkProvider(b)
+	defer cleanup()
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+
+	recipientAddress, _ := newTestRecipient(b)
+	packets := make([][]byte, b.N)
+	for i := range packets {
+		packets[i] = createFinalHopPacket(b, p, recipientAddress, []byte("Hello world"))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, packet := range packets {
+		processPacketNow(p, "localhost", packet)
+	}
+	b.StopTimer()
+	assertNoDrops(b, p)
+}
+
+// BenchmarkReceiveAndForward measures the processing and forwarding of packets to the next mix.
+func BenchmarkReceiveAndForward(b *testing.B) {
+	p, cleanup := createBenchmarkProvider(b)
+	defer cleanup()
+
+	// the next hop discards everything it receives
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, conn) //nolint: errcheck
+				conn.Close()
+			}()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	_, mixPub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	mix := config.MixConfig{Id: "BenchmarkMix", Host: host, Port: port, PubKey: mixPub.Bytes(), Layer: 1}
+	path := config.E2EPath{IngressProvider: p.config,
+		Mixes:          []config.MixConfig{mix},
+		EgressProvider: p.config,
+	}
+	packets := make([][]byte, b.N)
+	for i := range packets {
+		sphinxPacket, err := sphinx.PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if packets[i], err = proto.Marshal(&sphinxPacket); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, packet := range packets {
+		processPacketNow(p, "localhost", packet)
+	}
+	b.StopTimer()
+	assertNoDrops(b, p)
+}
+
+func TestProviderServer_ListensOnBindAddress(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrInvalidBindAddress, p.SetBindAddress("127.0.0.1"))
+
+	assert.Nil(t, p.SetBindAddress("127.0.0.1:0"))
+	if err := p.listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.listener.Close()
+	_, port, err := net.SplitHostPort(p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the presence still advertises the configured host and port
+	assert.Equal(t, "localhost", p.GetConfig().Host)
+	assert.Equal(t, "9999", p.GetConfig().Port)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+	if assert.Nil(t, err) {
+		conn.Close()
+	}
+
+	// the provider is not reachable on any other address of the host
+	otherAddresses := []string{"::1"}
+	if runtime.GOOS == "linux" {
+		otherAddresses = append(otherAddresses, "127.0.0.2")
+	}
+	if ip, err := helpers.GetLocalIP(); err == nil && ip != "127.0.0.1" {
+		otherAddresses = append(otherAddresses, ip)
+	}
+	for _, host := range otherAddresses {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+		if !assert.NotNil(t, err, "The provider should not be reachable on %v", host) {
+			conn.Close()
+		}
+	}
+}
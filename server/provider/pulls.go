@@ -0,0 +1,46 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import "sync"
+
+// pullSlots limits the number of pulls each client may have in progress at once, so that a single client
+// could not tie up the provider by pulling its inbox over many connections. Its zero value is ready to use.
+type pullSlots struct {
+	sync.Mutex
+	active map[string]int
+}
+
+// acquire takes a pull slot of the given client and returns the function releasing it, unless the client
+// already has limit pulls in progress. A non-positive limit means the pulls are not limited.
+func (s *pullSlots) acquire(clientID string, limit int) (func(), bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.active == nil {
+		s.active = make(map[string]int)
+	}
+	if limit > 0 && s.active[clientID] >= limit {
+		return nil, false
+	}
+	s.active[clientID]++
+	return func() {
+		s.Lock()
+		defer s.Unlock()
+		s.active[clientID]--
+		if s.active[clientID] == 0 {
+			delete(s.active, clientID)
+		}
+	}, true
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullSlots(t *testing.T) {
+	var slots pullSlots
+	release, ok := slots.acquire("Alice", 1)
+	assert.True(t, ok)
+	_, ok = slots.acquire("Alice", 1)
+	assert.False(t, ok, "Pulls above the limit should have been rejected")
+
+	// other clients are not affected
+	releaseBob, ok := slots.acquire("Bob", 1)
+	assert.True(t, ok)
+	releaseBob()
+
+	release()
+	release, ok = slots.acquire("Alice", 1)
+	assert.True(t, ok, "Released slot should have been reusable")
+	release()
+	assert.Empty(t, slots.active)
+
+	// non-positive limit means unlimited pulls
+	for i := 0; i < 10; i++ {
+		_, ok := slots.acquire("Alice", 0)
+		assert.True(t, ok)
+	}
+}
+
+// registerPullingClient registers a fresh client with the provider, fills its inbox with the given number
+// of messages of the given size and returns the marshalled pull request of the client.
+func registerPullingClient(t *testing.T, p *ProviderServer, numMessages, messageSize int) (string, []byte) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := make([]byte, messageSize)
+	for i := 0; i < numMessages; i++ {
+		if err := p.storeMessage(message, clientID, fmt.Sprintf("msg%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pullBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pub.Bytes(), Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientID, pullBytes
+}
+
+func inboxSize(t *testing.T, p *ProviderServer, clientID string) int {
+	files, err := ioutil.ReadDir(filepath.Join(p.inboxesDir, clientID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestProviderServer_PullLimits(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	clientID, pullBytes := registerPullingClient(t, p, 10, 1024)
+
+	p.SetPullLimits(4, 0)
+	var response bytes.Buffer
+	assert.Nil(t, p.handlePullRequest(pullBytes, &response))
+	assert.Equal(t, 4, countMessages(t, response.Bytes()))
+	assert.Equal(t, 6, inboxSize(t, p, clientID), "Messages above the limit should have been left in the inbox")
+
+	p.SetPullLimits(0, 2500)
+	response.Reset()
+	assert.Nil(t, p.handlePullRequest(pullBytes, &response))
+	assert.Equal(t, 2, countMessages(t, response.Bytes()))
+
+	// a message larger than the cap is still delivered on its own
+	p.SetPullLimits(0, 10)
+	response.Reset()
+	assert.Nil(t, p.handlePullRequest(pullBytes, &response))
+	assert.Equal(t, 1, countMessages(t, response.Bytes()))
+
+	p.SetPullLimits(0, 0)
+	response.Reset()
+	assert.Nil(t, p.handlePullRequest(pullBytes, &response))
+	assert.Equal(t, 3, countMessages(t, response.Bytes()))
+	assert.Equal(t, 0, inboxSize(t, p, clientID))
+}
+
+func TestProviderServer_PullLimits_ResponseSize(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	const numMessages, maxResponseSize = 20, 5000
+	clientID, pullBytes := registerPullingClient(t, p, 0, 0)
+	for i := 0; i < numMessages; i++ {
+		message := bytes.Repeat([]byte{byte(i)}, 1000+10*i)
+		if err := p.storeMessage(message, clientID, fmt.Sprintf("msg%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.SetPullLimits(0, maxResponseSize)
+	p.SetPullPadding(3)
+
+	received := make(map[byte]int)
+	for pulls := 0; inboxSize(t, p, clientID) > 0; pulls++ {
+		if pulls > numMessages {
+			t.Fatal("Messages should have been pulled by now")
+		}
+		var response bytes.Buffer
+		assert.Nil(t, p.handlePullRequest(pullBytes, &response))
+		assert.True(t, response.Len() <= maxResponseSize, "Response of %v bytes exceeds the limit", response.Len())
+		assert.True(t, countMessages(t, response.Bytes()) > 0)
+
+		frames := config.NewFrameReader(&response)
+		for {
+			frame, err := frames.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, err := config.UnwrapPacket(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flags.PacketTypeFlagFromBytes(packet.Flag) == flags.CommFlag {
+				received[packet.Data[0]]++
+			}
+		}
+	}
+	assert.Len(t, received, numMessages, "No message should have been lost")
+	for i, count := range received {
+		assert.Equal(t, 1, count, "Message %v should have been delivered exactly once", i)
+	}
+}
+
+func TestProviderServer_InMemory_FairPulls(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	const pullLimit = 10
+	p.SetPullLimits(pullLimit, 0)
+	p.SetMaxConcurrentPulls(1)
+
+	bigClientID, bigPullBytes := registerPullingClient(t, p, 100, 1024)
+	_, smallPullBytes := registerPullingClient(t, p, 3, 1024)
+
+	// the client with the large inbox starts pulling, but does not read the response,
+	// so that the provider is stuck writing it
+	packetBytes, err := config.WrapWithFlag(flags.PullFlag, bigPullBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigConn := dial()
+	defer bigConn.Close()
+	if _, err := bigConn.Write(packetBytes); err != nil {
+		t.Fatal(err)
+	}
+	bigFrames := config.NewFrameReader(bufio.NewReader(bigConn))
+	if _, err := bigFrames.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other client is not starved in the meantime
+	responses, _ := splitPullResponse(t, exchange(t, dial, flags.PullFlag, smallPullBytes))
+	assert.Len(t, responses, 3)
+
+	// while the client with the large inbox can't open any more pulls
+	responses = exchange(t, dial, flags.PullFlag, bigPullBytes)
+	assertErrorResponse(t, config.ErrorCodeRateLimited, responses...)
+
+	received := 1
+	for {
+		_, err := bigFrames.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received++
+	}
+	// the messages are followed by the status of the inbox
+	assert.Equal(t, pullLimit+1, received)
+	assert.Equal(t, 100-pullLimit, inboxSize(t, p, bigClientID))
+}
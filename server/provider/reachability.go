@@ -0,0 +1,175 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ReachabilityPolicy defines whether the provider checks that the host and port advertised by a new client
+// are reachable before registering it, so that bogus addresses would not end up in the registry.
+// The clients advertising no host or port, such as the pull-only ones, are never checked.
+type ReachabilityPolicy int
+
+const (
+	// SkipReachabilityCheck registers the clients with whatever address they advertise.
+	SkipReachabilityCheck ReachabilityPolicy = iota
+	// LenientReachabilityCheck registers the clients advertising an unreachable address,
+	// but without storing that address.
+	LenientReachabilityCheck
+	// StrictReachabilityCheck rejects the clients advertising an unreachable address.
+	StrictReachabilityCheck
+)
+
+const (
+	// DefaultReachabilityTimeout is the default timeout of connecting to the address advertised by a client.
+	DefaultReachabilityTimeout = 2 * time.Second
+
+	// reachabilityCheckRate and reachabilityCheckBurst limit the rate of the reachability checks of all the clients.
+	reachabilityCheckRate  = 5
+	reachabilityCheckBurst = 10
+)
+
+var (
+	// ErrUnknownReachabilityPolicy is returned when parsing a name which does not belong to any ReachabilityPolicy.
+	ErrUnknownReachabilityPolicy = errors.New("unknown reachability policy")
+	// ErrUnreachableClient is returned when a new client advertises an address the provider could not verify
+	// under the StrictReachabilityCheck policy. It is not disclosed to the client.
+	ErrUnreachableClient = errors.New("advertised client address is unreachable")
+
+	errNotPublicAddress        = errors.New("address is not public")
+	errReachabilityRateLimited = errors.New("too many reachability checks")
+)
+
+// reachabilityPolicyNames maps the names used in the configuration to the policies.
+//nolint: gochecknoglobals
+var reachabilityPolicyNames = map[string]ReachabilityPolicy{
+	"off":     SkipReachabilityCheck,
+	"lenient": LenientReachabilityCheck,
+	"strict":  StrictReachabilityCheck,
+}
+
+// ParseReachabilityPolicy returns the policy with the given name, i.e. one of "off", "lenient" or "strict".
+func ParseReachabilityPolicy(name string) (ReachabilityPolicy, error) {
+	policy, ok := reachabilityPolicyNames[name]
+	if !ok {
+		return SkipReachabilityCheck, ErrUnknownReachabilityPolicy
+	}
+	return policy, nil
+}
+
+// SetReachabilityCheck sets whether the addresses advertised by the new clients are checked, by connecting
+// to them within the given timeout. Only the public addresses are ever connected to, so the clients advertising
+// any other address are treated as unreachable. A non-positive timeout is replaced with DefaultReachabilityTimeout.
+// By default the addresses are not checked. It should be called before the provider is started.
+func (p *ProviderServer) SetReachabilityCheck(policy ReachabilityPolicy, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultReachabilityTimeout
+	}
+	p.reachabilityPolicy = policy
+	p.reachabilityTimeout = timeout
+}
+
+// reachabilityLimiter bounds the rate of the reachability checks with a token bucket, so that the clients could not
+// make the provider connect to arbitrary addresses en masse.
+type reachabilityLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket, refilled up to reachabilityCheckBurst at reachabilityCheckRate per second,
+// if there is one at the given time.
+func (l *reachabilityLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last.IsZero() {
+		l.tokens = reachabilityCheckBurst
+	} else if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(reachabilityCheckBurst, l.tokens+elapsed.Seconds()*reachabilityCheckRate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// publicIP checks whether the IP address belongs to the public internet, as opposed to the provider itself
+// or the networks it is part of.
+func publicIP(ip net.IP) bool {
+	return ip != nil &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// dialPublic connects to the address advertised by a client, refusing to connect to any address which is not
+// public, as resolved when dialling, so that the clients could not probe the provider or its networks.
+func (p *ProviderServer) dialPublic(address string) error {
+	dialer := net.Dialer{Timeout: p.reachabilityTimeout,
+		Control: func(network, resolved string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(resolved)
+			if err != nil {
+				return err
+			}
+			if !p.allowLocalReachability && !publicIP(net.ParseIP(host)) {
+				return errNotPublicAddress
+			}
+			return nil
+		},
+	}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkReachability applies the reachability policy to the address advertised by a new client, returning the host
+// and port to register the client with. Under the lenient policy the address is cleared if it can't be verified,
+// while under the strict one ErrUnreachableClient is returned. The address can't be verified if it is not public,
+// if it can't be connected to, or if the reachability checks exceed their rate limit, and the reason is never
+// disclosed to the client, so that the checks could not be used to probe the network.
+// It may block for up to the reachability timeout, hence it must not be called with any lock held.
+func (p *ProviderServer) checkReachability(clientID string, host string, port string) (string, string, error) {
+	if p.reachabilityPolicy == SkipReachabilityCheck || host == "" || port == "" {
+		return host, port, nil
+	}
+	address := net.JoinHostPort(host, port)
+	err := errReachabilityRateLimited
+	if p.reachabilityLimiter.allow(p.clock.Now()) {
+		err = p.dialPublic(address)
+	}
+	if err == nil {
+		return host, port, nil
+	}
+
+	if p.reachabilityPolicy == StrictReachabilityCheck {
+		p.log.Warnf("Rejecting client %v advertising unverified address %v: %v", clientID, address, err)
+		return "", "", ErrUnreachableClient
+	}
+	p.log.Warnf("Registering client %v without its unverified address %v: %v", clientID, address, err)
+	return "", "", nil
+}
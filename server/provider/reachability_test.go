@@ -0,0 +1,192 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReachabilityPolicy(t *testing.T) {
+	for name, expected := range map[string]ReachabilityPolicy{
+		"off":     SkipReachabilityCheck,
+		"lenient": LenientReachabilityCheck,
+		"strict":  StrictReachabilityCheck,
+	} {
+		policy, err := ParseReachabilityPolicy(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, policy)
+	}
+	for _, invalid := range []string{"", "Strict", "foomp"} {
+		_, err := ParseReachabilityPolicy(invalid)
+		assert.Equal(t, ErrUnknownReachabilityPolicy, err, "Policy %q should have been rejected", invalid)
+	}
+}
+
+// createAdvertisingClient returns the marshalled config of a new client advertising the given address.
+func createAdvertisingClient(t *testing.T, host, port string) ([]byte, string) {
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Client", Host: host, Port: port, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientBytes, ClientID(pub.Bytes())
+}
+
+// unusedPort returns a local port nothing listens on.
+func unusedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	return port
+}
+
+func TestProviderServer_ReachabilityCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, reachablePort, _ := net.SplitHostPort(listener.Addr().String())
+	unreachablePort := unusedPort(t)
+
+	for _, policy := range []ReachabilityPolicy{SkipReachabilityCheck, LenientReachabilityCheck, StrictReachabilityCheck} {
+		p, err := CreateTestProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inboxesDir, err := ioutil.TempDir("", "reachability")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(inboxesDir)
+		p.SetInboxesDirectory(inboxesDir)
+		p.SetReachabilityCheck(policy, time.Second)
+		p.allowLocalReachability = true
+
+		reachable, reachableID := createAdvertisingClient(t, "127.0.0.1", reachablePort)
+		_, err = p.registerNewClient(reachable)
+		assert.Nil(t, err, "Policy %v", policy)
+
+		pullOnly, pullOnlyID := createAdvertisingClient(t, "", "")
+		_, err = p.registerNewClient(pullOnly)
+		assert.Nil(t, err, "Policy %v", policy)
+
+		unreachable, unreachableID := createAdvertisingClient(t, "127.0.0.1", unreachablePort)
+		_, err = p.registerNewClient(unreachable)
+
+		records := make(map[string]ClientRecord)
+		for _, record := range p.Clients() {
+			records[record.ID()] = record
+		}
+		assert.Equal(t, reachablePort, records[reachableID].port, "Policy %v", policy)
+		assert.Contains(t, records, pullOnlyID, "Policy %v", policy)
+
+		switch policy {
+		case SkipReachabilityCheck:
+			assert.Nil(t, err)
+			assert.Equal(t, unreachablePort, records[unreachableID].port)
+		case LenientReachabilityCheck:
+			assert.Nil(t, err)
+			assert.Contains(t, records, unreachableID)
+			assert.Empty(t, records[unreachableID].host, "The unreachable address should not have been stored")
+			assert.Empty(t, records[unreachableID].port, "The unreachable address should not have been stored")
+		case StrictReachabilityCheck:
+			assert.Equal(t, ErrUnreachableClient, err)
+			assert.NotContains(t, records, unreachableID)
+			_, err := os.Stat(filepath.Join(inboxesDir, unreachableID))
+			assert.True(t, os.IsNotExist(err), "No inbox should have been created for the rejected client")
+		}
+	}
+}
+
+func TestProviderServer_ReachabilityCheck_OnlyPublicAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connected := make(chan struct{}, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+			connected <- struct{}{}
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxesDir, err := ioutil.TempDir("", "reachability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(inboxesDir)
+	p.SetInboxesDirectory(inboxesDir)
+	p.SetReachabilityCheck(StrictReachabilityCheck, time.Second)
+
+	// the provider itself is never connected to, even though it is reachable
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		client, _ := createAdvertisingClient(t, host, port)
+		_, err = p.registerNewClient(client)
+		assert.Equal(t, ErrUnreachableClient, err, "Host %v", host)
+	}
+	select {
+	case <-connected:
+		t.Fatal("The provider should not have connected to a loopback address")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, ip := range []string{"127.0.0.1", "::1", "10.0.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "0.0.0.0", "224.0.0.1"} {
+		assert.False(t, publicIP(net.ParseIP(ip)), "IP %v should not have been public", ip)
+	}
+	for _, ip := range []string{"1.1.1.1", "203.0.113.7", "2001:4860:4860::8888"} {
+		assert.True(t, publicIP(net.ParseIP(ip)), "IP %v should have been public", ip)
+	}
+}
+
+func TestReachabilityLimiter(t *testing.T) {
+	var limiter reachabilityLimiter
+	now := time.Unix(1000, 0)
+	for i := 0; i < reachabilityCheckBurst; i++ {
+		assert.True(t, limiter.allow(now))
+	}
+	assert.False(t, limiter.allow(now))
+	assert.True(t, limiter.allow(now.Add(time.Second/reachabilityCheckRate)))
+	assert.False(t, limiter.allow(now.Add(time.Second/reachabilityCheckRate)))
+}
+
+func TestErrorResponse_UnreachableClient(t *testing.T) {
+	// the outcome of the reachability check is not disclosed to the client
+	code, message := errorResponse(ErrUnreachableClient)
+	assert.Equal(t, config.ErrorCodeInternal, code)
+	assert.NotContains(t, message, "unreachable")
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// registryVersion is the version of the format the registry is exported in. In the version 1 the clients
+// were identified by the base64 encoding of their public keys, rather than their ClientIDs.
+const (
+	registryVersion       = 2
+	legacyRegistryVersion = 1
+)
+
+var (
+	// ErrUnsupportedRegistryVersion is returned when the imported registry was exported in an unknown format.
+	ErrUnsupportedRegistryVersion = errors.New("unsupported registry version")
+	// ErrInvalidRegistry is returned when the imported registry contains an invalid client record.
+	ErrInvalidRegistry = errors.New("invalid registry")
+)
+
+// RegistryImportMode defines how the imported registry is combined with the clients already registered.
+type RegistryImportMode int
+
+const (
+	// MergeRegistry adds the imported clients to the registry. The imported records take precedence
+	// over the existing records of the same clients.
+	MergeRegistry RegistryImportMode = iota
+	// ReplaceRegistry discards all the existing records and keeps only the imported ones.
+	ReplaceRegistry
+)
+
+// exportedRegistry is the versioned serialisation of the client registry.
+type exportedRegistry struct {
+	Version int              `json:"version"`
+	Clients []exportedClient `json:"clients"`
+}
+
+type exportedClient struct {
+	ID     string `json:"id"`
+	Host   string `json:"host,omitempty"`
+	Port   string `json:"port,omitempty"`
+	PubKey []byte `json:"pubKey"`
+	Token  []byte `json:"token,omitempty"`
+	// TokenIssuedAt is when the current token of the client was issued as a unix timestamp, or 0 if unknown.
+	TokenIssuedAt int64 `json:"tokenIssuedAt,omitempty"`
+	// TokenExpiry is the expiry of the stateless token of the client as a unix timestamp, or 0 if there is none.
+	TokenExpiry int64 `json:"tokenExpiry,omitempty"`
+	// TokensRevokedUntil is the latest expiry of the revoked stateless tokens of the client as a unix timestamp,
+	// or 0 if none were revoked.
+	TokensRevokedUntil int64 `json:"tokensRevokedUntil,omitempty"`
+}
+
+// ExportRegistry writes all the registered clients, including their tokens, to w, so that they could be
+// imported by another provider with ImportRegistry. Stateless tokens are only valid after the import if
+// the other provider uses the same token master key.
+func (p *ProviderServer) ExportRegistry(w io.Writer) error {
+	clients := p.Clients()
+	registry := exportedRegistry{Version: registryVersion, Clients: make([]exportedClient, len(clients))}
+	for i, record := range clients {
+		registry.Clients[i] = exportedClient{ID: record.id,
+			Host:   record.host,
+			Port:   record.port,
+			PubKey: record.pubKey,
+			Token:  record.token,
+		}
+		if !record.tokenIssuedAt.IsZero() {
+			registry.Clients[i].TokenIssuedAt = record.tokenIssuedAt.Unix()
+		}
+		if !record.tokenExpiry.IsZero() {
+			registry.Clients[i].TokenExpiry = record.tokenExpiry.Unix()
+		}
+		if !record.tokensRevokedUntil.IsZero() {
+			registry.Clients[i].TokensRevokedUntil = record.tokensRevokedUntil.Unix()
+		}
+	}
+	return json.NewEncoder(w).Encode(registry)
+}
+
+// ImportRegistry reads the clients exported by ExportRegistry from r and registers them according to the mode.
+// The inboxes of the imported clients are created if they do not exist yet. The registry is left intact
+// if the import fails. The registries exported before the clients were identified by their ClientIDs
+// are imported as well, though the inboxes of their clients have to be migrated with MigrateLegacyInboxes,
+// and their stateless tokens are only valid again once they register anew.
+func (p *ProviderServer) ImportRegistry(r io.Reader, mode RegistryImportMode) error {
+	var registry exportedRegistry
+	if err := json.NewDecoder(r).Decode(&registry); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidRegistry, err)
+	}
+	if registry.Version != registryVersion && registry.Version != legacyRegistryVersion {
+		return ErrUnsupportedRegistryVersion
+	}
+
+	records := make(map[string]ClientRecord, len(registry.Clients))
+	for _, client := range registry.Clients {
+		expectedID := ClientID(client.PubKey)
+		if registry.Version == legacyRegistryVersion {
+			expectedID = base64.URLEncoding.EncodeToString(client.PubKey)
+		}
+		if client.ID != expectedID {
+			return ErrInvalidRegistry
+		}
+		client.ID = ClientID(client.PubKey)
+		record := ClientRecord{id: client.ID,
+			host:   client.Host,
+			port:   client.Port,
+			pubKey: client.PubKey,
+			token:  client.Token,
+		}
+		if client.TokenIssuedAt != 0 {
+			record.tokenIssuedAt = time.Unix(client.TokenIssuedAt, 0)
+		}
+		if client.TokenExpiry != 0 {
+			record.tokenExpiry = time.Unix(client.TokenExpiry, 0)
+		}
+		if client.TokensRevokedUntil != 0 {
+			record.tokensRevokedUntil = time.Unix(client.TokensRevokedUntil, 0)
+		}
+		records[client.ID] = record
+	}
+
+	for id := range records {
+		unlock := p.inboxLocks.lock(id)
+		err := os.MkdirAll(filepath.Join(p.inboxesDir, id), 0775)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	if mode == ReplaceRegistry {
+		p.assignedClients = records
+		p.clientsChanged()
+		return nil
+	}
+	for id, record := range records {
+		p.assignedClients[id] = record
+	}
+	p.clientsChanged()
+	return nil
+}
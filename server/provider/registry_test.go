@@ -0,0 +1,160 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// registerTestClients registers the given number of fresh clients and returns their public keys and tokens.
+func registerTestClients(t *testing.T, p *ProviderServer, n int) ([][]byte, [][]byte) {
+	pubKeys := make([][]byte, n)
+	tokens := make([][]byte, n)
+	for i := range pubKeys {
+		_, pub, err := sphinx.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys[i] = pub.Bytes()
+		clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Client",
+			Host:   "localhost",
+			Port:   "9998",
+			PubKey: pubKeys[i],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[i], err = p.registerNewClient(clientBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pubKeys, tokens
+}
+
+func sortedClientIDs(p *ProviderServer) []string {
+	var ids []string
+	for _, record := range p.Clients() {
+		ids = append(ids, record.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestProviderServer_ExportImportRegistry(t *testing.T) {
+	source, _, cleanupSource := createMockClockProvider(t)
+	defer cleanupSource()
+	pubKeys, tokens := registerTestClients(t, source, 3)
+
+	var exported bytes.Buffer
+	assert.Nil(t, source.ExportRegistry(&exported))
+
+	target, _, cleanupTarget := createMockClockProvider(t)
+	defer cleanupTarget()
+	assert.Nil(t, target.ImportRegistry(&exported, MergeRegistry))
+
+	assert.Equal(t, sortedClientIDs(source), sortedClientIDs(target))
+	for _, record := range target.Clients() {
+		assert.Equal(t, "localhost", record.host)
+		assert.Equal(t, "9998", record.port)
+	}
+	for i, pubKey := range pubKeys {
+		assert.True(t, target.authenticateUser(pubKey, tokens[i]), "Tokens should have been valid after the import")
+		assert.DirExists(t, filepath.Join(target.inboxesDir, ClientID(pubKey)))
+	}
+}
+
+func TestProviderServer_ExportImportRegistry_StatelessTokens(t *testing.T) {
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _, cleanupSource := createMockClockProvider(t)
+	defer cleanupSource()
+	source.EnableStatelessTokens(masterKey, time.Hour)
+	pubKeys, tokens := registerTestClients(t, source, 2)
+
+	var exported bytes.Buffer
+	assert.Nil(t, source.ExportRegistry(&exported))
+
+	target, clk, cleanupTarget := createMockClockProvider(t)
+	defer cleanupTarget()
+	target.EnableStatelessTokens(masterKey, time.Hour)
+	assert.Nil(t, target.ImportRegistry(&exported, MergeRegistry))
+	assert.True(t, target.authenticateUser(pubKeys[0], tokens[0]))
+
+	// the expiry of the token is preserved, so re-registering yields the very same token
+	clk.Advance(time.Minute)
+	clientBytes, err := proto.Marshal(&config.ClientConfig{PubKey: pubKeys[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := target.registerNewClient(clientBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, tokens[1], token)
+}
+
+func TestProviderServer_ImportRegistry_Modes(t *testing.T) {
+	source, _, cleanupSource := createMockClockProvider(t)
+	defer cleanupSource()
+	registerTestClients(t, source, 2)
+	var exported bytes.Buffer
+	assert.Nil(t, source.ExportRegistry(&exported))
+
+	target, _, cleanupTarget := createMockClockProvider(t)
+	defer cleanupTarget()
+	existingKeys, _ := registerTestClients(t, target, 1)
+	existingID := ClientID(existingKeys[0])
+
+	assert.Nil(t, target.ImportRegistry(bytes.NewReader(exported.Bytes()), MergeRegistry))
+	assert.Len(t, target.Clients(), 3)
+	assert.True(t, target.isRegistered(existingID), "Merge should have kept the existing clients")
+
+	assert.Nil(t, target.ImportRegistry(bytes.NewReader(exported.Bytes()), ReplaceRegistry))
+	assert.Equal(t, sortedClientIDs(source), sortedClientIDs(target))
+	assert.False(t, target.isRegistered(existingID), "Replace should have discarded the existing clients")
+}
+
+func TestProviderServer_ImportRegistry_Invalid(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	registerTestClients(t, p, 1)
+	before := sortedClientIDs(p)
+
+	assert.Equal(t, ErrUnsupportedRegistryVersion,
+		p.ImportRegistry(strings.NewReader(`{"version": 3, "clients": []}`), ReplaceRegistry),
+	)
+	assert.Equal(t, ErrInvalidRegistry,
+		p.ImportRegistry(strings.NewReader(`{"version": 2, "clients": [{"id": "Mallory", "pubKey": "Zm9vbXA="}]}`),
+			ReplaceRegistry,
+		),
+	)
+	assert.Error(t, p.ImportRegistry(strings.NewReader("foomp"), ReplaceRegistry))
+	assert.Equal(t, before, sortedClientIDs(p), "Failed import should have left the registry intact")
+
+	_, err := os.Stat(filepath.Join(p.inboxesDir, "Mallory"))
+	assert.True(t, os.IsNotExist(err))
+}
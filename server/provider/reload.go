@@ -0,0 +1,107 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrImmutableSetting is returned when reloading the configuration would change a setting
+	// which can't be changed while the provider is running.
+	ErrImmutableSetting = errors.New("setting can't be changed without a restart")
+	// ErrInvalidPresenceInterval is returned when the presence interval is not positive.
+	ErrInvalidPresenceInterval = errors.New("presence interval has to be positive")
+	// ErrInvalidTokenValidity is returned when the validity of the stateless tokens is not positive.
+	ErrInvalidTokenValidity = errors.New("token validity has to be positive")
+)
+
+// RuntimeConfig holds the settings of a running provider. All but BindAddress and PublicKey can be changed
+// with Reload, while those two are only included, so that any attempt to change them could be rejected.
+type RuntimeConfig struct {
+	BindAddress string `json:"bindAddress"`
+	PublicKey   []byte `json:"publicKey"`
+
+	LogLevel string `json:"logLevel"`
+	// TokenValidity is the validity of the issued stateless tokens. It is zero if stateless tokens are disabled,
+	// in which case it can't be changed.
+	TokenValidity time.Duration `json:"tokenValidity"`
+	// MaxRate and Burst limit the number of packets processed per second. A zero MaxRate means no limit.
+	MaxRate          float64       `json:"maxRate"`
+	Burst            int           `json:"burst"`
+	PresenceInterval time.Duration `json:"presenceInterval"`
+}
+
+// RuntimeConfig returns the current settings of the provider.
+func (p *ProviderServer) RuntimeConfig() RuntimeConfig {
+	cfg := RuntimeConfig{BindAddress: p.bindAddress,
+		PublicKey:        p.GetPublicKey().Bytes(),
+		LogLevel:         p.log.GetLevel().String(),
+		PresenceInterval: p.currentPresenceInterval(),
+	}
+	if p.tokens != nil {
+		cfg.TokenValidity = p.tokens.currentValidity()
+	}
+	cfg.MaxRate, cfg.Burst = p.MaxProcessingRate()
+	return cfg
+}
+
+// Reload applies the settings of the given config to the running provider, without dropping any connections.
+// The config is expected to be derived from the one returned by RuntimeConfig. Reload returns
+// ErrImmutableSetting if the config changes either BindAddress, PublicKey or, if stateless tokens are disabled,
+// TokenValidity. If any of the settings is rejected, none of them are applied.
+// Changing the token validity only affects the tokens issued afterwards.
+func (p *ProviderServer) Reload(cfg RuntimeConfig) error {
+	current := p.RuntimeConfig()
+	if cfg.BindAddress != current.BindAddress || !bytes.Equal(cfg.PublicKey, current.PublicKey) {
+		return ErrImmutableSetting
+	}
+	if p.tokens == nil && cfg.TokenValidity != 0 {
+		return ErrImmutableSetting
+	}
+	if p.tokens != nil && cfg.TokenValidity <= 0 {
+		return ErrInvalidTokenValidity
+	}
+	if cfg.PresenceInterval <= 0 {
+		return ErrInvalidPresenceInterval
+	}
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	p.log.SetLevel(level)
+	if p.tokens != nil {
+		p.tokens.setValidity(cfg.TokenValidity)
+	}
+	if cfg.MaxRate != current.MaxRate || cfg.Burst != current.Burst {
+		p.SetMaxProcessingRate(cfg.MaxRate, cfg.Burst)
+	}
+	if cfg.PresenceInterval != current.PresenceInterval {
+		p.setPresenceInterval(cfg.PresenceInterval)
+	}
+	p.log.Infof("Reloaded the configuration: log level %v, token validity %v, max rate %v (burst %v), presence interval %v",
+		cfg.LogLevel,
+		cfg.TokenValidity,
+		cfg.MaxRate,
+		cfg.Burst,
+		cfg.PresenceInterval,
+	)
+	return nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderServer_Reload_LogLevelAndTokenValidity(t *testing.T) {
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	// the logger of the test providers is shared, so it must not be modified
+	provider.log = logrus.New()
+	provider.log.SetOutput(ioutil.Discard)
+	provider.log.SetLevel(logrus.InfoLevel)
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.EnableStatelessTokens(masterKey, time.Hour)
+
+	cfg := provider.RuntimeConfig()
+	assert.Equal(t, "info", cfg.LogLevel)
+	assert.Equal(t, time.Hour, cfg.TokenValidity)
+	cfg.LogLevel = "warning"
+	cfg.TokenValidity = time.Minute
+	assert.Nil(t, provider.Reload(cfg))
+	assert.Equal(t, cfg, provider.RuntimeConfig())
+	assert.Equal(t, logrus.WarnLevel, provider.log.GetLevel())
+
+	// the tokens issued from now on expire after the new validity
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Erin", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, provider.authenticateUser(pub.Bytes(), token))
+	clk.Advance(time.Minute)
+	assert.False(t, provider.authenticateUser(pub.Bytes(), token), "The token should have expired")
+}
+
+func TestProviderServer_Reload_MaxProcessingRate(t *testing.T) {
+	provider, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := provider.RuntimeConfig()
+	assert.Equal(t, float64(0), cfg.MaxRate)
+	cfg.MaxRate = 100
+	cfg.Burst = 10
+	assert.Nil(t, provider.Reload(cfg))
+	rate, burst := provider.MaxProcessingRate()
+	assert.Equal(t, float64(100), rate)
+	assert.Equal(t, 10, burst)
+
+	cfg.MaxRate = 0
+	assert.Nil(t, provider.Reload(cfg))
+	rate, _ = provider.MaxProcessingRate()
+	assert.Equal(t, float64(0), rate)
+}
+
+func TestProviderServer_Reload_RejectsImmutableSettings(t *testing.T) {
+	provider, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := provider.RuntimeConfig()
+
+	invalid := []struct {
+		modify func(*RuntimeConfig)
+		err    error
+	}{
+		{func(cfg *RuntimeConfig) { cfg.BindAddress = "127.0.0.1:1789" }, ErrImmutableSetting},
+		{func(cfg *RuntimeConfig) { cfg.PublicKey = bytes.Repeat([]byte{1}, len(cfg.PublicKey)) }, ErrImmutableSetting},
+		// stateless tokens are disabled
+		{func(cfg *RuntimeConfig) { cfg.TokenValidity = time.Hour }, ErrImmutableSetting},
+		{func(cfg *RuntimeConfig) { cfg.PresenceInterval = 0 }, ErrInvalidPresenceInterval},
+	}
+	for _, test := range invalid {
+		cfg := original
+		cfg.LogLevel = "error"
+		cfg.MaxRate = 100
+		test.modify(&cfg)
+		assert.Equal(t, test.err, provider.Reload(cfg))
+	}
+
+	cfg := original
+	cfg.LogLevel = "foomp"
+	assert.NotNil(t, provider.Reload(cfg))
+
+	// none of the valid changes were applied either
+	assert.Equal(t, original, provider.RuntimeConfig())
+}
+
+func TestProviderServer_Reload_PresenceInterval(t *testing.T) {
+	presences := make(chan struct{}, 1)
+	provider, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	provider.registrar = &recordingRegistrar{notify: presences}
+	provider.haltedCh = make(chan struct{})
+	defer close(provider.haltedCh)
+	go provider.startSendingPresence(closedChannel())
+	<-presences
+	clk.BlockUntil(1)
+
+	cfg := provider.RuntimeConfig()
+	cfg.PresenceInterval = 10 * time.Second
+	assert.Nil(t, provider.Reload(cfg))
+	// the presence is now awaited with the new interval
+	clk.BlockUntil(2)
+
+	clk.Advance(DefaultPresenceInterval)
+	select {
+	case <-presences:
+		t.Fatal("The presence should not have been sent after the previous interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(cfg.PresenceInterval - DefaultPresenceInterval)
+	select {
+	case <-presences:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The presence should have been sent once the new interval elapsed")
+	}
+}
+
+func TestProviderServer_Admin_Reload(t *testing.T) {
+	provider, server, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+
+	resp := adminRequest(t, http.MethodGet, server.URL+"/config", testAdminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var cfg RuntimeConfig
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&cfg))
+	resp.Body.Close()
+	assert.Equal(t, provider.RuntimeConfig(), cfg)
+
+	put := func(cfg RuntimeConfig) *http.Response {
+		body, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/config", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	cfg.MaxRate = 42
+	cfg.Burst = 4
+	assert.Equal(t, http.StatusOK, put(cfg).StatusCode)
+	rate, burst := provider.MaxProcessingRate()
+	assert.Equal(t, float64(42), rate)
+	assert.Equal(t, 4, burst)
+
+	cfg.BindAddress = ":1789"
+	assert.Equal(t, http.StatusBadRequest, put(cfg).StatusCode)
+}
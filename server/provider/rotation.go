@@ -0,0 +1,111 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// handleRotateTokenRequest handles the request of the client to replace its token, which carries the same
+// fields as a pull request, and wraps the fresh token with the TokenFlag.
+func (p *ProviderServer) handleRotateTokenRequest(rqsBytes []byte) ([]byte, error) {
+	var request config.PullRequest
+	if err := proto.Unmarshal(rqsBytes, &request); err != nil {
+		p.log.Warnf("Failed to parse token rotation request: %v", err)
+		return nil, ErrMalformedRequest
+	}
+	p.log.Infof("Processing token rotation request: %s", ClientID(request.ClientPublicKey))
+
+	token, err := p.rotateToken(request.ClientPublicKey, request.Token)
+	if err != nil {
+		return nil, err
+	}
+	return config.WrapWithFlag(flags.TokenFlag, token)
+}
+
+// rotateToken issues a fresh token to the client authenticated with its current token, which is no longer
+// accepted afterwards. Only the registered clients can rotate their tokens, as otherwise the revocation
+// of the stateless tokens could not be recorded.
+func (p *ProviderServer) rotateToken(clientKey, clientToken []byte) ([]byte, error) {
+	clientID := ClientID(clientKey)
+	// the lock of the inbox also guards the registration of its client
+	unlock := p.inboxLocks.lock(clientID)
+	defer unlock()
+
+	if !p.authenticateUser(clientKey, clientToken) {
+		return nil, ErrAuthenticationFailed
+	}
+	p.clientsMu.RLock()
+	record, registered := p.assignedClients[clientID]
+	p.clientsMu.RUnlock()
+	if !registered {
+		return nil, ErrAuthenticationFailed
+	}
+
+	if p.tokens != nil {
+		revokeStatelessToken(&record, tokenExpiry(clientToken))
+	}
+	record.token = nil
+	token, err := p.currentToken(&record)
+	if err != nil {
+		return nil, err
+	}
+
+	p.clientsMu.Lock()
+	p.assignedClients[clientID] = record
+	p.clientsChanged()
+	p.clientsMu.Unlock()
+	p.log.Infof("Rotated token of %v", clientID)
+	return token, nil
+}
+
+// RevokeToken revokes the token of the registered client with the given ClientID, so that its requests
+// are rejected until it registers again and obtains a fresh token. It returns ErrUnknownClient
+// if the client is not registered.
+func (p *ProviderServer) RevokeToken(clientID string) error {
+	unlock := p.inboxLocks.lock(clientID)
+	defer unlock()
+
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	record, registered := p.assignedClients[clientID]
+	if !registered {
+		return ErrUnknownClient
+	}
+	if p.tokens != nil {
+		// any token issued so far expires by the time a token issued now would
+		revokeStatelessToken(&record, p.tokens.nextExpiry())
+	}
+	record.token = nil
+	p.assignedClients[clientID] = record
+	p.clientsChanged()
+	p.log.Infof("Revoked token of %v", clientID)
+	return nil
+}
+
+// revokeStatelessToken rejects the stateless tokens of the client expiring no later than the given expiry
+// and the latest token issued to it, which is then issued anew.
+func revokeStatelessToken(record *ClientRecord, expiry time.Time) {
+	if record.tokenExpiry.After(expiry) {
+		expiry = record.tokenExpiry
+	}
+	if expiry.After(record.tokensRevokedUntil) {
+		record.tokensRevokedUntil = expiry
+	}
+	record.tokenExpiry = time.Time{}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func marshalPullRequest(t *testing.T, pubKey, token []byte) []byte {
+	rqsBytes, err := proto.Marshal(&config.PullRequest{ClientPublicKey: pubKey, Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rqsBytes
+}
+
+func TestProviderServer_InMemory_RotateToken(t *testing.T) {
+	_, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, ClientID(pub.Bytes())))
+
+	clientBytes, err := proto.Marshal(&config.ClientConfig{PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := exchange(t, dial, flags.AssignFlag, clientBytes)
+	assert.Len(t, responses, 1)
+	oldToken := responses[0].Data
+
+	responses = exchange(t, dial, flags.RotateTokenFlag, marshalPullRequest(t, pub.Bytes(), oldToken))
+	assert.Len(t, responses, 1)
+	assert.Equal(t, flags.TokenFlag, flags.PacketTypeFlagFromBytes(responses[0].Flag))
+	newToken := responses[0].Data
+	assert.NotEqual(t, oldToken, newToken)
+
+	assertErrorResponse(t, config.ErrorCodeAuthenticationFailed,
+		exchange(t, dial, flags.PullFlag, marshalPullRequest(t, pub.Bytes(), oldToken))...,
+	)
+	_, status := splitPullResponse(t, exchange(t, dial, flags.PullFlag, marshalPullRequest(t, pub.Bytes(), newToken)))
+	assert.Equal(t, config.InboxStatusEmpty, status)
+
+	// the old token can't be used to rotate the token either
+	assertErrorResponse(t, config.ErrorCodeAuthenticationFailed,
+		exchange(t, dial, flags.RotateTokenFlag, marshalPullRequest(t, pub.Bytes(), oldToken))...,
+	)
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, exchange(t, dial, flags.RotateTokenFlag, []byte("foomp"))...)
+}
+
+func TestProviderServer_RevokeToken(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	pubKeys, tokens := registerTestClients(t, p, 2)
+
+	assert.Nil(t, p.RevokeToken(ClientID(pubKeys[0])))
+	assert.False(t, p.authenticateUser(pubKeys[0], tokens[0]))
+	assert.True(t, p.authenticateUser(pubKeys[1], tokens[1]), "Other clients should not have been affected")
+	_, err := p.rotateToken(pubKeys[0], tokens[0])
+	assert.Equal(t, ErrAuthenticationFailed, err)
+	assert.Equal(t, ErrUnknownClient, p.RevokeToken(ClientID([]byte("foomp"))))
+
+	// registering again issues a fresh token
+	clientBytes, err := proto.Marshal(&config.ClientConfig{PubKey: pubKeys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.registerNewClient(clientBytes)
+	assert.Nil(t, err)
+	assert.NotEqual(t, tokens[0], token)
+	assert.True(t, p.authenticateUser(pubKeys[0], token))
+	assert.False(t, p.authenticateUser(pubKeys[0], tokens[0]))
+}
+
+func TestProviderServer_StatelessTokens_RotateAndRevoke(t *testing.T) {
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.EnableStatelessTokens(masterKey, time.Hour)
+	pubKeys, tokens := registerTestClients(t, p, 1)
+	clientBytes, err := proto.Marshal(&config.ClientConfig{PubKey: pubKeys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fresh token differs even if it was issued within the same second
+	rotated, err := p.rotateToken(pubKeys[0], tokens[0])
+	assert.Nil(t, err)
+	assert.NotEqual(t, tokens[0], rotated)
+	assert.False(t, p.authenticateUser(pubKeys[0], tokens[0]))
+	assert.True(t, p.authenticateUser(pubKeys[0], rotated))
+	token, err := p.registerNewClient(clientBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, rotated, token, "Registering again should have returned the rotated token")
+
+	clk.Advance(time.Minute)
+	assert.Nil(t, p.RevokeToken(ClientID(pubKeys[0])))
+	assert.False(t, p.authenticateUser(pubKeys[0], rotated))
+	token, err = p.registerNewClient(clientBytes)
+	assert.Nil(t, err)
+	assert.True(t, p.authenticateUser(pubKeys[0], token))
+	assert.False(t, p.authenticateUser(pubKeys[0], rotated))
+}
+
+func TestProviderServer_AdminRevokeToken(t *testing.T) {
+	p, server, cleanup := createAdminTestProvider(t)
+	defer cleanup()
+	pubKeys, tokens := registerTestClients(t, p, 1)
+
+	resp := adminRequest(t, http.MethodDelete, server.URL+adminClientsPath+ClientID(pubKeys[0])+adminTokenSuffix, testAdminToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.False(t, p.authenticateUser(pubKeys[0], tokens[0]))
+
+	resp = adminRequest(t, http.MethodDelete, server.URL+adminClientsPath+"Mallory"+adminTokenSuffix, testAdminToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = adminRequest(t, http.MethodGet, server.URL+adminClientsPath+ClientID(pubKeys[0])+adminTokenSuffix, testAdminToken)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultWebhookTimeout defines for how long delivering a message to the webhook may take
+	// before it is stored in the inbox instead.
+	DefaultWebhookTimeout = 5 * time.Second
+	// WebhookClientIDHeader is the header carrying the ClientID of the recipient of the messages
+	// posted to the webhook.
+	WebhookClientIDHeader = "X-Nym-Client-Id"
+)
+
+// ErrInvalidDeliveryWebhook is returned when the URL of the delivery webhook is not an absolute http(s) URL.
+var ErrInvalidDeliveryWebhook = errors.New("invalid delivery webhook URL")
+
+// DeliverySink receives the messages reaching the provider on their last hop, in place of the inboxes
+// of their recipients, e.g. to push them to an external service. It must be safe for concurrent use.
+type DeliverySink interface {
+	// Deliver hands over the message for the client with the given ClientID. If it returns an error,
+	// the message is stored in the inbox of the client instead.
+	Deliver(clientID string, msg []byte) error
+}
+
+// WebhookSink is the DeliverySink posting each message as the body of an HTTP request to a webhook,
+// with the ClientID of its recipient in the WebhookClientIDHeader. Any response other than 2xx is a failure.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns the sink posting the messages to the given URL, giving up after the timeout.
+// It returns ErrInvalidDeliveryWebhook if the URL is not an absolute http(s) URL.
+func NewWebhookSink(rawURL string, timeout time.Duration) (*WebhookSink, error) {
+	if err := ValidateDeliveryWebhook(rawURL); err != nil {
+		return nil, err
+	}
+	return &WebhookSink{url: rawURL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Deliver is an implementation of the DeliverySink interface.
+func (s *WebhookSink) Deliver(clientID string, msg []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(WebhookClientIDHeader, clientID)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// the body is drained, so that the connection could be reused
+	io.Copy(ioutil.Discard, resp.Body) //nolint: errcheck
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// ValidateDeliveryWebhook checks whether the messages can be posted to the given URL, i.e. whether it is
+// an absolute http(s) URL. It returns ErrInvalidDeliveryWebhook otherwise.
+func ValidateDeliveryWebhook(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidDeliveryWebhook
+	}
+	return nil
+}
+
+// deliverToSink hands the message over to the delivery sink, if there is one, and returns whether it was
+// accepted. The messages for the unregistered clients are never handed over if registration is required.
+func (p *ProviderServer) deliverToSink(inboxID string, message []byte) bool {
+	if p.sink == nil {
+		return false
+	}
+	if p.recipientPolicy == RequireRegistration && !p.isRegistered(inboxID) {
+		return false
+	}
+	if err := p.sink.Deliver(inboxID, message); err != nil {
+		p.log.Warnf("Delivery sink failed to accept message for %v, storing it in the inbox: %v", inboxID, err)
+		return false
+	}
+	p.log.Infof("Delivered message for %v to the sink", inboxID)
+	return true
+}
+
+// SetDeliverySink makes the provider hand the messages reaching it on their last hop over to the sink,
+// rather than storing them in the inboxes of their recipients. The messages the sink fails to accept are
+// stored as before, subject to the UnknownRecipientPolicy. A nil sink restores the default of storing
+// all the messages. It should be called before the provider is started.
+func (p *ProviderServer) SetDeliverySink(sink DeliverySink) {
+	p.sink = sink
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the messages delivered to it, or fails to accept any if it has an error set.
+type recordingSink struct {
+	mu        sync.Mutex
+	err       error
+	delivered map[string][][]byte
+}
+
+func (s *recordingSink) Deliver(clientID string, msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.delivered == nil {
+		s.delivered = make(map[string][][]byte)
+	}
+	s.delivered[clientID] = append(s.delivered[clientID], msg)
+	return nil
+}
+
+func TestProviderServer_DeliverySink(t *testing.T) {
+	p, _, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.SetUnknownRecipientPolicy(CreateInboxOnDemand)
+	sink := &recordingSink{}
+	p.SetDeliverySink(sink)
+
+	address, inboxID := newTestRecipient(t)
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, address, []byte("Hello world")))
+	assert.Len(t, sink.delivered[inboxID], 1)
+	assert.NotEmpty(t, sink.delivered[inboxID][0])
+	// the message is not stored in the inbox
+	_, err := p.InboxMessageCount(inboxID)
+	assert.Equal(t, ErrUnknownRecipient, err)
+	assert.Equal(t, DeliveryStats{Sunk: 1}, p.Deliveries())
+
+	// the messages the sink fails to accept are stored in the inbox instead
+	sink.err = errors.New("sink unavailable")
+	processPacketNow(p, "localhost", createFinalHopPacket(t, p, address, []byte("Hello again")))
+	count, err := p.InboxMessageCount(inboxID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, sink.delivered[inboxID], 1)
+	assert.Equal(t, DeliveryStats{Stored: 1, Sunk: 1}, p.Deliveries())
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var clientIDs []string
+	var bodies [][]byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		clientIDs = append(clientIDs, r.Header.Get(WebhookClientIDHeader))
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookSink(server.URL, time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Deliver("client", []byte("Hello world")))
+	assert.Equal(t, []string{"client"}, clientIDs)
+	assert.Equal(t, [][]byte{[]byte("Hello world")}, bodies)
+
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	assert.Error(t, sink.Deliver("client", []byte("Hello again")))
+
+	server.Close()
+	assert.Error(t, sink.Deliver("client", []byte("Hello again")))
+
+	_, err = NewWebhookSink("localhost:8080", time.Second)
+	assert.Equal(t, ErrInvalidDeliveryWebhook, err)
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+)
+
+// handleStatusRequest handles the request of the client to obtain its status, which carries the same
+// fields as a pull request, and wraps the status with the StatusFlag.
+func (p *ProviderServer) handleStatusRequest(rqsBytes []byte) ([]byte, error) {
+	var request config.PullRequest
+	if err := proto.Unmarshal(rqsBytes, &request); err != nil {
+		p.log.Warnf("Failed to parse status request: %v", err)
+		return nil, ErrMalformedRequest
+	}
+	p.log.Infof("Processing status request: %s", ClientID(request.ClientPublicKey))
+
+	status, err := p.clientStatus(request.ClientPublicKey, request.Token)
+	if err != nil {
+		return nil, err
+	}
+	return config.WrapClientStatus(status)
+}
+
+// clientStatus returns the status of the client, i.e. when its current token was issued and how many messages
+// await it, without ever disclosing the token itself. A client which is not registered is told so regardless
+// of its token, as the registered clients are published in the presence of the provider anyway, so that it could
+// tell a lost registration apart from a rejected token. A registered client has to authenticate with its token.
+func (p *ProviderServer) clientStatus(clientKey, clientToken []byte) (config.ClientStatus, error) {
+	clientID := ClientID(clientKey)
+	p.clientsMu.RLock()
+	record, registered := p.assignedClients[clientID]
+	p.clientsMu.RUnlock()
+	if !registered {
+		return config.ClientStatus{Registration: config.RegistrationStatusNotRegistered}, nil
+	}
+	if !p.authenticateUser(clientKey, clientToken) {
+		return config.ClientStatus{}, ErrAuthenticationFailed
+	}
+
+	buffered, err := p.InboxMessageCount(clientID)
+	if err != nil && err != ErrUnknownRecipient {
+		return config.ClientStatus{}, err
+	}
+	return config.ClientStatus{Registration: config.RegistrationStatusRegistered,
+		TokenIssuedAt:    record.tokenIssuedAt,
+		BufferedMessages: uint64(buffered),
+	}, nil
+}
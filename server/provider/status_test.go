@@ -0,0 +1,114 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestStatus(t *testing.T, p *ProviderServer, pubKey, token []byte) (config.ClientStatus, error) {
+	statusBytes, err := p.handleStatusRequest(marshalPullRequest(t, pubKey, token))
+	if err != nil {
+		return config.ClientStatus{}, err
+	}
+	packet, err := config.UnwrapPacket(statusBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, flags.StatusFlag, flags.PacketTypeFlagFromBytes(packet.Flag))
+	status, err := config.UnwrapClientStatus(packet.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, nil
+}
+
+func TestProviderServer_ClientStatus_Registered(t *testing.T) {
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	issuedAt := clk.Now()
+	pubKeys, tokens := registerTestClients(t, p, 1)
+	clientID := ClientID(pubKeys[0])
+
+	clk.Advance(time.Hour)
+	for _, messageID := range []string{"msg1", "msg2"} {
+		assert.Nil(t, p.storeMessage([]byte("foomp"), clientID, messageID))
+	}
+
+	status, err := requestStatus(t, p, pubKeys[0], tokens[0])
+	assert.Nil(t, err)
+	assert.Equal(t, config.RegistrationStatusRegistered, status.Registration)
+	assert.Equal(t, issuedAt.Unix(), status.TokenIssuedAt.Unix())
+	assert.Equal(t, uint64(2), status.BufferedMessages)
+
+	// the time of the issuance is kept when the same token is handed out again
+	clientBytes, err := proto.Marshal(&config.ClientConfig{PubKey: pubKeys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tokens[0], token)
+	status, err = requestStatus(t, p, pubKeys[0], token)
+	assert.Nil(t, err)
+	assert.Equal(t, issuedAt.Unix(), status.TokenIssuedAt.Unix())
+
+	_, err = requestStatus(t, p, pubKeys[0], []byte("foomp"))
+	assert.Equal(t, ErrAuthenticationFailed, err)
+}
+
+func TestProviderServer_ClientStatus_RotatedToken(t *testing.T) {
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	pubKeys, tokens := registerTestClients(t, p, 1)
+
+	clk.Advance(time.Hour)
+	token, err := p.rotateToken(pubKeys[0], tokens[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := requestStatus(t, p, pubKeys[0], token)
+	assert.Nil(t, err)
+	assert.Equal(t, clk.Now().Unix(), status.TokenIssuedAt.Unix())
+	assert.Zero(t, status.BufferedMessages)
+}
+
+func TestProviderServer_InMemory_ClientStatus_NotRegistered(t *testing.T) {
+	_, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := exchange(t, dial, flags.StatusFlag, marshalPullRequest(t, pub.Bytes(), []byte("foomp")))
+	assert.Len(t, responses, 1)
+	assert.Equal(t, flags.StatusFlag, flags.PacketTypeFlagFromBytes(responses[0].Flag))
+	status, err := config.UnwrapClientStatus(responses[0].Data)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ClientStatus{Registration: config.RegistrationStatusNotRegistered}, status)
+
+	assertErrorResponse(t, config.ErrorCodeMalformedRequest, exchange(t, dial, flags.StatusFlag, []byte("foomp"))...)
+}
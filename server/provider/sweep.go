@@ -0,0 +1,50 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import "sync"
+
+// sweepInboxes calls sweep for each of the given inboxes while holding its lock, so that the sweep
+// does not race the stores and pulls of the inbox. The inboxes are swept by at most p.sweepConcurrency
+// workers at once, so that a slow inbox does not hold up the others, without letting a sweep over
+// many inboxes starve the rest of the provider. sweepInboxes returns once all the inboxes were swept.
+func (p *ProviderServer) sweepInboxes(inboxIDs []string, sweep func(inboxID string)) {
+	workers := p.sweepConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(inboxIDs) {
+		workers = len(inboxIDs)
+	}
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for inboxID := range queue {
+				unlock := p.inboxLocks.lock(inboxID)
+				sweep(inboxID)
+				unlock()
+			}
+		}()
+	}
+	for _, inboxID := range inboxIDs {
+		queue <- inboxID
+	}
+	close(queue)
+	wg.Wait()
+}
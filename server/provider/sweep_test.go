@@ -0,0 +1,124 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderServer_SweepInboxes_BoundedConcurrency(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const concurrency = 4
+	assert.Nil(t, p.SetInboxCleanup(time.Minute, concurrency))
+
+	inboxIDs := make([]string, 100)
+	for i := range inboxIDs {
+		inboxIDs[i] = fmt.Sprintf("Inbox%v", i)
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	swept := make(map[string]bool)
+	p.sweepInboxes(inboxIDs, func(inboxID string) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		swept[inboxID] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	assert.Len(t, swept, len(inboxIDs))
+	assert.True(t, maxRunning > 1, "Inboxes should have been swept concurrently")
+	assert.True(t, maxRunning <= concurrency, "At most %v inboxes should have been swept at once", concurrency)
+}
+
+func TestProviderServer_CleanStaleInboxes_ManyInboxes(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.SetInboxesDirectory(dir)
+	assert.Nil(t, p.SetInboxCleanup(time.Minute, 8))
+
+	const inboxes = 500
+	for i := 0; i < inboxes; i++ {
+		if err := os.Mkdir(filepath.Join(dir, fmt.Sprintf("Inbox%v", i)), 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the sweep waits for the inbox in use, without removing it from under its holder
+	const lockedInbox = "Inbox0"
+	unlock := p.inboxLocks.lock(lockedInbox)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.cleanStaleInboxes(0)
+	}()
+	select {
+	case <-done:
+		t.Fatal("The sweep should have waited for the locked inbox")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = os.Stat(filepath.Join(dir, lockedInbox))
+	assert.Nil(t, err, "The locked inbox should not have been removed")
+	unlock()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("The sweep should have completed once the inbox was unlocked")
+	}
+	remaining, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, remaining)
+}
+
+func TestProviderServer_SetInboxCleanup(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrInvalidCleanupInterval, p.SetInboxCleanup(0, 1))
+	assert.Equal(t, DefaultInboxCleanupInterval, p.cleanupInterval)
+
+	assert.Nil(t, p.SetInboxCleanup(time.Second, 0))
+	assert.Equal(t, time.Second, p.cleanupInterval)
+	assert.Equal(t, 1, p.sweepConcurrency)
+}
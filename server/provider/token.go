@@ -0,0 +1,191 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/clock"
+)
+
+const (
+	// TokenMasterKeySize defines the length of the master key used to issue stateless tokens.
+	TokenMasterKeySize = 32
+	// DefaultTokenValidity defines for how long the issued stateless tokens remain valid.
+	DefaultTokenValidity = 24 * time.Hour
+	// DefaultTokenLength defines the length (in bytes) of the random tokens issued unless stateless tokens are enabled.
+	DefaultTokenLength = 32
+	// MinTokenLength defines the shortest allowed random token, which still can't be guessed.
+	MinTokenLength = 16
+	// MaxTokenLength defines the longest allowed random token.
+	MaxTokenLength = 64
+
+	tokenExpiryLength = 8
+	tokenLength       = tokenExpiryLength + sha256.Size
+)
+
+var (
+	// ErrInvalidToken is returned when the token was not issued by the provider for the particular client.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned when the token was issued by the provider, but is no longer valid.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrInvalidTokenLength is returned when the configured length of the random tokens is outside of the allowed range.
+	ErrInvalidTokenLength = errors.New("invalid token length")
+)
+
+// ValidateTokenLength checks whether the random tokens of the given length can be issued.
+// It returns ErrInvalidTokenLength if the length is outside of the [MinTokenLength, MaxTokenLength] range.
+func ValidateTokenLength(length int) error {
+	if length < MinTokenLength || length > MaxTokenLength {
+		return ErrInvalidTokenLength
+	}
+	return nil
+}
+
+// generateToken returns a fresh random token of the given length. The tokens are opaque to the clients,
+// which only send them back to the provider with their pull requests.
+func generateToken(length int) ([]byte, error) {
+	token := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// tokensEqual compares the token sent by the client with the expected one in constant time, so that
+// the expected token could not be recovered byte by byte from the timing of the responses. Empty tokens,
+// such as the missing token of an unregistered client, never match.
+func tokensEqual(expected, received []byte) bool {
+	return len(expected) > 0 && hmac.Equal(expected, received)
+}
+
+// TokenMasterKey is the secret key the provider uses to issue and validate stateless tokens.
+type TokenMasterKey struct {
+	bytes [TokenMasterKeySize]byte
+}
+
+// MarshalBinary is an implementation of a method on the
+// BinaryMarshaler interface defined in https://golang.org/pkg/encoding/
+func (k *TokenMasterKey) MarshalBinary() ([]byte, error) {
+	return k.bytes[:], nil
+}
+
+// UnmarshalBinary is an implementation of a method on the
+// BinaryUnmarshaler interface defined in https://golang.org/pkg/encoding/
+func (k *TokenMasterKey) UnmarshalBinary(data []byte) error {
+	if len(data) != TokenMasterKeySize {
+		return errors.New("invalid token master key data")
+	}
+	copy(k.bytes[:], data)
+	return nil
+}
+
+// GenerateTokenMasterKey returns a fresh random token master key, or an error.
+func GenerateTokenMasterKey() (*TokenMasterKey, error) {
+	key := new(TokenMasterKey)
+	if _, err := io.ReadFull(rand.Reader, key.bytes[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// tokenIssuer issues tokens of the form expiry || HMAC(masterKey, clientID || expiry).
+// Such tokens can be validated by simply recomputing the HMAC, hence the provider does not need
+// to store them.
+type tokenIssuer struct {
+	masterKey *TokenMasterKey
+	// validity is guarded by validityMu, so that it could be changed while the tokens are being issued.
+	validity   time.Duration
+	validityMu sync.RWMutex
+	clock      clock.Clock
+}
+
+func newTokenIssuer(masterKey *TokenMasterKey, validity time.Duration, clk clock.Clock) *tokenIssuer {
+	return &tokenIssuer{
+		masterKey: masterKey,
+		validity:  validity,
+		clock:     clk,
+	}
+}
+
+func (ti *tokenIssuer) computeMac(clientID string, expiryBytes []byte) []byte {
+	mac := hmac.New(sha256.New, ti.masterKey.bytes[:])
+	// writes to hash.Hash never return an error
+	mac.Write([]byte(clientID))
+	mac.Write(expiryBytes)
+	return mac.Sum(nil)
+}
+
+// issue returns a token for the given client valid for the validity period of the issuer.
+func (ti *tokenIssuer) issue(clientID string) []byte {
+	return ti.issueWithExpiry(clientID, ti.nextExpiry())
+}
+
+// nextExpiry returns the expiry of the tokens issued now.
+func (ti *tokenIssuer) nextExpiry() time.Time {
+	return ti.clock.Now().Add(ti.currentValidity())
+}
+
+func (ti *tokenIssuer) currentValidity() time.Duration {
+	ti.validityMu.RLock()
+	defer ti.validityMu.RUnlock()
+	return ti.validity
+}
+
+// setValidity changes the validity period of the subsequently issued tokens.
+// The tokens issued before keep their expiry.
+func (ti *tokenIssuer) setValidity(validity time.Duration) {
+	ti.validityMu.Lock()
+	defer ti.validityMu.Unlock()
+	ti.validity = validity
+}
+
+// expired checks whether the tokens with the given expiry are no longer valid.
+func (ti *tokenIssuer) expired(expiry time.Time) bool {
+	return ti.clock.Now().Unix() >= expiry.Unix()
+}
+
+func (ti *tokenIssuer) issueWithExpiry(clientID string, expiry time.Time) []byte {
+	token := make([]byte, tokenExpiryLength, tokenLength)
+	binary.BigEndian.PutUint64(token, uint64(expiry.Unix()))
+	return append(token, ti.computeMac(clientID, token)...)
+}
+
+// tokenExpiry returns the expiry of the stateless token, which has to be of the valid length.
+func tokenExpiry(token []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(token[:tokenExpiryLength])), 0)
+}
+
+// validate checks whether the token was issued for the given client and whether it has not yet expired.
+func (ti *tokenIssuer) validate(clientID string, token []byte) error {
+	if len(token) != tokenLength {
+		return ErrInvalidToken
+	}
+	expiryBytes, mac := token[:tokenExpiryLength], token[tokenExpiryLength:]
+	if !tokensEqual(ti.computeMac(clientID, expiryBytes), mac) {
+		return ErrInvalidToken
+	}
+	if ti.expired(tokenExpiry(token)) {
+		return ErrTokenExpired
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/clock"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestTokenIssuer(t *testing.T) *tokenIssuer {
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTokenIssuer(masterKey, DefaultTokenValidity, clock.New())
+}
+
+func TestTokenIssuer_ValidToken(t *testing.T) {
+	issuer := createTestTokenIssuer(t)
+	token := issuer.issue("Alice")
+	assert.Len(t, token, tokenLength)
+	assert.Nil(t, issuer.validate("Alice", token))
+}
+
+func TestTokenIssuer_ExpiredToken(t *testing.T) {
+	issuer := createTestTokenIssuer(t)
+	token := issuer.issueWithExpiry("Alice", time.Now().Add(-time.Second))
+	assert.Equal(t, ErrTokenExpired, issuer.validate("Alice", token))
+}
+
+func TestTokenIssuer_ExpiresWithClock(t *testing.T) {
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewMock(time.Now())
+	issuer := newTokenIssuer(masterKey, time.Hour, clk)
+	token := issuer.issue("Alice")
+
+	clk.Advance(time.Hour - time.Second)
+	assert.Nil(t, issuer.validate("Alice", token))
+	clk.Advance(time.Second)
+	assert.Equal(t, ErrTokenExpired, issuer.validate("Alice", token))
+}
+
+func TestTokenIssuer_ForgedToken(t *testing.T) {
+	issuer := createTestTokenIssuer(t)
+	token := issuer.issue("Alice")
+
+	// token issued for somebody else
+	assert.Equal(t, ErrInvalidToken, issuer.validate("Bob", token))
+
+	// token issued with a different master key
+	otherIssuer := createTestTokenIssuer(t)
+	assert.Equal(t, ErrInvalidToken, otherIssuer.validate("Alice", token))
+
+	// expired token with extended expiry
+	expiredToken := issuer.issueWithExpiry("Alice", time.Now().Add(-time.Second))
+	copy(expiredToken, token[:tokenExpiryLength])
+	assert.Equal(t, ErrInvalidToken, issuer.validate("Alice", expiredToken))
+
+	// truncated token
+	assert.Equal(t, ErrInvalidToken, issuer.validate("Alice", token[:len(token)-1]))
+	assert.Equal(t, ErrInvalidToken, issuer.validate("Alice", nil))
+}
+
+func TestTokenMasterKey_MarshalBinary(t *testing.T) {
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := masterKey.MarshalBinary()
+	assert.Nil(t, err)
+
+	unmarshalledKey := new(TokenMasterKey)
+	assert.Nil(t, unmarshalledKey.UnmarshalBinary(b))
+	assert.Equal(t, masterKey, unmarshalledKey)
+
+	assert.Error(t, unmarshalledKey.UnmarshalBinary(b[1:]))
+}
+
+func TestProviderServer_StatelessTokens(t *testing.T) {
+	masterKey, err := GenerateTokenMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerServer.EnableStatelessTokens(masterKey, DefaultTokenValidity)
+	defer func() {
+		providerServer.tokens = nil
+	}()
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: "Dave", PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := providerServer.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientID := ClientID(pub.Bytes())
+	providerServer.clientsMu.RLock()
+	assert.Nil(t, providerServer.assignedClients[clientID].token, "Stateless token should not have been stored")
+	providerServer.clientsMu.RUnlock()
+
+	assert.True(t, providerServer.authenticateUser(pub.Bytes(), token))
+	token[len(token)-1] ^= 0xff
+	assert.False(t, providerServer.authenticateUser(pub.Bytes(), token))
+}
+
+func TestValidateTokenLength(t *testing.T) {
+	for _, valid := range []int{MinTokenLength, DefaultTokenLength, MaxTokenLength} {
+		assert.Nil(t, ValidateTokenLength(valid))
+	}
+	for _, invalid := range []int{-1, 0, MinTokenLength - 1, MaxTokenLength + 1} {
+		assert.Equal(t, ErrInvalidTokenLength, ValidateTokenLength(invalid))
+	}
+}
+
+func TestTokensEqual(t *testing.T) {
+	token, err := generateToken(DefaultTokenLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, tokensEqual(token, append([]byte(nil), token...)))
+
+	modified := append([]byte(nil), token...)
+	modified[len(modified)-1] ^= 0xff
+	assert.False(t, tokensEqual(token, modified))
+	assert.False(t, tokensEqual(token, token[:len(token)-1]))
+	assert.False(t, tokensEqual(token, append(token, 0)))
+
+	// the missing token of an unregistered client is never matched
+	assert.False(t, tokensEqual(nil, nil))
+	assert.False(t, tokensEqual(nil, []byte{}))
+}
+
+func TestProviderServer_TokenLength(t *testing.T) {
+	p, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrInvalidTokenLength, p.SetTokenLength(MinTokenLength-1))
+	assert.Nil(t, p.SetTokenLength(MinTokenLength))
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	clientBytes, err := proto.Marshal(&config.ClientConfig{Id: address, PubKey: pub.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, token, MinTokenLength)
+	assert.True(t, p.authenticateUser(pub.Bytes(), token))
+
+	// the tokens are random rather than derived from the client id
+	otherProvider, err := CreateTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := otherProvider.registerNewClient(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, otherToken, DefaultTokenLength)
+	assert.False(t, p.authenticateUser(pub.Bytes(), otherToken))
+
+	// an unregistered client without a key nor a token
+	assert.False(t, p.authenticateUser(nil, nil))
+}
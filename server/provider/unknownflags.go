@@ -0,0 +1,182 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/node"
+)
+
+// UnknownFlagPolicy defines how the provider reacts to the packets with unrecognised flags. No well-behaved peer
+// sends them, so a peer sending them repeatedly is likely scanning or attacking the provider.
+// Each policy includes all the reactions of the preceding ones.
+type UnknownFlagPolicy int
+
+const (
+	// LogUnknownFlags drops and logs the packets with unrecognised flags, replying with an error where possible.
+	LogUnknownFlags UnknownFlagPolicy = iota
+	// CountUnknownFlags additionally counts such packets per peer, as returned by UnknownFlagCounts.
+	CountUnknownFlags
+	// DisconnectOnUnknownFlags additionally closes the connection such packet was received on straight away,
+	// without replying, which also ends any stream of packets.
+	DisconnectOnUnknownFlags
+	// BanOnUnknownFlags additionally refuses all the connections of a peer for a while, each time it sends
+	// a given number of such packets, as set by SetUnknownFlagBan.
+	BanOnUnknownFlags
+)
+
+const (
+	// DefaultUnknownFlagBanThreshold is the default number of packets with unrecognised flags
+	// a peer is banned after.
+	DefaultUnknownFlagBanThreshold = 10
+	// DefaultUnknownFlagBanDuration is the default duration of the bans.
+	DefaultUnknownFlagBanDuration = 10 * time.Minute
+)
+
+// ErrUnknownFlagPolicy is returned when parsing a name which does not belong to any UnknownFlagPolicy.
+var ErrUnknownFlagPolicy = errors.New("unknown policy for unrecognised flags")
+
+// unknownFlagPolicyNames maps the names used in the configuration to the policies.
+//nolint: gochecknoglobals
+var unknownFlagPolicyNames = map[string]UnknownFlagPolicy{
+	"log":        LogUnknownFlags,
+	"count":      CountUnknownFlags,
+	"disconnect": DisconnectOnUnknownFlags,
+	"ban":        BanOnUnknownFlags,
+}
+
+// ParseUnknownFlagPolicy returns the policy with the given name, i.e. one of "log", "count", "disconnect" or "ban".
+func ParseUnknownFlagPolicy(name string) (UnknownFlagPolicy, error) {
+	policy, ok := unknownFlagPolicyNames[name]
+	if !ok {
+		return LogUnknownFlags, ErrUnknownFlagPolicy
+	}
+	return policy, nil
+}
+
+// unknownFlagTracker keeps the counts of the packets with unrecognised flags and the bans of the peers sending them.
+// The peers are identified by their hosts, as each of their connections has a different port. Once maxTrackedSources
+// peers are tracked, the counts of the peers which are not banned are forgotten, so that sending such packets
+// from many distinct addresses could not exhaust the memory.
+type unknownFlagTracker struct {
+	mu          sync.Mutex
+	counts      map[string]uint
+	bannedUntil map[string]time.Time
+}
+
+// record counts another packet with an unrecognised flag from the peer at the given time. If banThreshold
+// is positive, the peer is banned for banDuration each time its count reaches a multiple of it.
+// record returns whether the peer got banned.
+func (t *unknownFlagTracker) record(peer string, banThreshold int, now time.Time, banDuration time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]uint)
+		t.bannedUntil = make(map[string]time.Time)
+	}
+	if _, ok := t.counts[peer]; !ok && len(t.counts) >= maxTrackedSources {
+		t.forgetUnbanned(now)
+	}
+	t.counts[peer]++
+	if banThreshold > 0 && t.counts[peer]%uint(banThreshold) == 0 {
+		t.bannedUntil[peer] = now.Add(banDuration)
+		return true
+	}
+	return false
+}
+
+// forgetUnbanned forgets the counts of the peers which are not banned at the given time, along with their expired bans.
+func (t *unknownFlagTracker) forgetUnbanned(now time.Time) {
+	for peer := range t.counts {
+		if until, ok := t.bannedUntil[peer]; !ok || !now.Before(until) {
+			delete(t.counts, peer)
+			delete(t.bannedUntil, peer)
+		}
+	}
+}
+
+// banned checks whether the peer is banned at the given time, forgetting its ban if it has already expired.
+func (t *unknownFlagTracker) banned(peer string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.bannedUntil[peer]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(t.bannedUntil, peer)
+		return false
+	}
+	return true
+}
+
+func (t *unknownFlagTracker) snapshot() map[string]uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]uint, len(t.counts))
+	for peer, count := range t.counts {
+		counts[peer] = count
+	}
+	return counts
+}
+
+// SetUnknownFlagPolicy sets how the provider reacts to the packets with unrecognised flags.
+// By default they are only dropped and logged. It should be called before the provider is started.
+func (p *ProviderServer) SetUnknownFlagPolicy(policy UnknownFlagPolicy) {
+	p.unknownFlagPolicy = policy
+}
+
+// SetUnknownFlagBan sets after how many packets with unrecognised flags a peer is banned, and for how long,
+// if the BanOnUnknownFlags policy is used. It should be called before the provider is started.
+func (p *ProviderServer) SetUnknownFlagBan(threshold int, duration time.Duration) {
+	if threshold < 1 {
+		threshold = 1
+	}
+	p.unknownFlagBanThreshold = threshold
+	p.unknownFlagBanDuration = duration
+}
+
+// UnknownFlagCounts returns the number of packets with unrecognised flags received from each peer
+// since the provider started, unless the peer has been forgotten to bound the memory used for the counts.
+// The packets are only counted if the policy is at least CountUnknownFlags. Only the packet type flags are counted,
+// as the unrecognised sphinx flags are chosen by the senders of the packets rather than the peers relaying them.
+func (p *ProviderServer) UnknownFlagCounts() map[string]uint {
+	return p.unknownFlags.snapshot()
+}
+
+// unknownFlag drops the packet with an unrecognised flag, received from the given peer, and applies the policy
+// of the provider to the peer. It returns whether the connection to the peer should be closed without replying.
+func (p *ProviderServer) unknownFlag(peer string, err error) bool {
+	p.dropPacket(node.DropUnknownFlag, err)
+	if p.unknownFlagPolicy < CountUnknownFlags {
+		return false
+	}
+
+	banThreshold := 0
+	if p.unknownFlagPolicy >= BanOnUnknownFlags {
+		banThreshold = p.unknownFlagBanThreshold
+	}
+	if p.unknownFlags.record(peer, banThreshold, p.clock.Now(), p.unknownFlagBanDuration) {
+		p.log.Warnf("Banned %v for %v after repeated packets with unrecognised flags", peer, p.unknownFlagBanDuration)
+	}
+	return p.unknownFlagPolicy >= DisconnectOnUnknownFlags
+}
+
+// peerBanned checks whether the peer is currently banned for sending packets with unrecognised flags.
+func (p *ProviderServer) peerBanned(peer string) bool {
+	return p.unknownFlagPolicy >= BanOnUnknownFlags && p.unknownFlags.banned(peer, p.clock.Now())
+}
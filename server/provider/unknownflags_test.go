@@ -0,0 +1,182 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package provider
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/nymtech/nym-mixnet/node"
+	"github.com/nymtech/nym-mixnet/sphinx"
+	"github.com/stretchr/testify/assert"
+)
+
+// unknownPacketFlag is not recognised by the provider.
+const unknownPacketFlag = flags.PacketTypeFlag('\x42')
+
+func TestParseUnknownFlagPolicy(t *testing.T) {
+	for name, expected := range map[string]UnknownFlagPolicy{
+		"log":        LogUnknownFlags,
+		"count":      CountUnknownFlags,
+		"disconnect": DisconnectOnUnknownFlags,
+		"ban":        BanOnUnknownFlags,
+	} {
+		policy, err := ParseUnknownFlagPolicy(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, policy)
+	}
+	for _, invalid := range []string{"", "Log", "foomp"} {
+		_, err := ParseUnknownFlagPolicy(invalid)
+		assert.Equal(t, ErrUnknownFlagPolicy, err, "Policy %q should have been rejected", invalid)
+	}
+}
+
+func TestProviderServer_UnknownFlagPolicy(t *testing.T) {
+	for _, policy := range []UnknownFlagPolicy{LogUnknownFlags, CountUnknownFlags, DisconnectOnUnknownFlags} {
+		p, dial, err := CreateInMemoryTestProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetUnknownFlagPolicy(policy)
+
+		for i := 0; i < 3; i++ {
+			responses := exchange(t, dial, unknownPacketFlag, []byte("foomp"))
+			if policy < DisconnectOnUnknownFlags {
+				assertErrorResponse(t, config.ErrorCodeMalformedRequest, responses...)
+			} else {
+				assert.Empty(t, responses, "Policy %v should have disconnected without a reply", policy)
+			}
+		}
+		assert.Equal(t, uint(3), p.drops.Count(node.DropUnknownFlag))
+
+		if policy < CountUnknownFlags {
+			assert.Empty(t, p.UnknownFlagCounts())
+		} else {
+			// the in-memory connections have no real address
+			assert.Equal(t, map[string]uint{"pipe": 3}, p.UnknownFlagCounts())
+		}
+	}
+}
+
+func TestProviderServer_UnknownFlagPolicy_DisconnectsStream(t *testing.T) {
+	p, dial, err := CreateInMemoryTestProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetUnknownFlagPolicy(DisconnectOnUnknownFlags)
+
+	_, pub, err := sphinx.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := base64.URLEncoding.EncodeToString(pub.Bytes())
+	clientID := ClientID(pub.Bytes())
+	defer os.RemoveAll(filepath.Join(DefaultInboxesDir, clientID))
+	createInbox(clientID, t)
+
+	unknownPacket, err := config.WrapWithFlag(unknownPacketFlag, []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commPacket, err := config.WrapWithFlag(flags.CommFlag, createFinalHopPacket(t, p, address, []byte("Hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dial()
+	w := bufio.NewWriter(conn)
+	assert.Nil(t, config.WriteFrame(w, unknownPacket))
+	assert.Nil(t, config.WriteFrame(w, commPacket))
+	// the provider may stop reading before the whole stream is written
+	w.Flush() //nolint: errcheck
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return p.drops.Count(node.DropUnknownFlag) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	// the packet following the unknown flag in the stream is never handled
+	time.Sleep(100 * time.Millisecond)
+	count, err := p.InboxMessageCount(clientID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestProviderServer_UnknownFlagPolicy_Ban(t *testing.T) {
+	p, clk, cleanup := createMockClockProvider(t)
+	defer cleanup()
+	p.SetUnknownFlagPolicy(BanOnUnknownFlags)
+	p.SetUnknownFlagBan(2, time.Minute)
+	dial := func() net.Conn {
+		serverConn, clientConn := net.Pipe()
+		go p.handleConnection(serverConn)
+		return clientConn
+	}
+	assignPacket, err := config.WrapWithFlag(flags.AssignFlag, []byte("foomp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a request from a peer which is not banned gets a reply, even if it is malformed,
+	// while the connections of the banned ones are closed straight away
+	notBanned := func() bool {
+		conn := dial()
+		defer conn.Close()
+		if _, err := conn.Write(assignPacket); err != nil {
+			return false
+		}
+		response, err := ioutil.ReadAll(conn)
+		return err == nil && len(response) > 0
+	}
+
+	assert.Empty(t, exchange(t, dial, unknownPacketFlag, []byte("foomp")))
+	assert.True(t, notBanned())
+	assert.Empty(t, exchange(t, dial, unknownPacketFlag, []byte("foomp")))
+	assert.False(t, notBanned())
+
+	clk.Advance(time.Minute - time.Second)
+	assert.False(t, notBanned())
+	clk.Advance(time.Second)
+	assert.True(t, notBanned())
+
+	// the peer is banned again after another two packets with unknown flags
+	assert.Empty(t, exchange(t, dial, unknownPacketFlag, []byte("foomp")))
+	assert.True(t, notBanned())
+	assert.Empty(t, exchange(t, dial, unknownPacketFlag, []byte("foomp")))
+	assert.False(t, notBanned())
+	assert.Equal(t, map[string]uint{"pipe": 4}, p.UnknownFlagCounts())
+}
+
+func TestUnknownFlagTracker_Bounded(t *testing.T) {
+	var tracker unknownFlagTracker
+	now := time.Unix(1000, 0)
+
+	assert.True(t, tracker.record("banned", 1, now, time.Minute))
+	for i := 1; i < maxTrackedSources; i++ {
+		assert.False(t, tracker.record(fmt.Sprintf("peer%v", i), 0, now, time.Minute))
+	}
+	assert.Len(t, tracker.snapshot(), maxTrackedSources)
+
+	// once full, the peers which are not banned are forgotten, while the bans are kept
+	assert.False(t, tracker.record("another", 0, now, time.Minute))
+	assert.Equal(t, map[string]uint{"banned": 1, "another": 1}, tracker.snapshot())
+	assert.True(t, tracker.banned("banned", now))
+}
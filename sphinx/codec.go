@@ -0,0 +1,118 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec defines the wire format of the sphinx packets. The sender of a packet and all the nodes on its path
+// have to use the same codec, as a packet encoded by one codec can't be decoded by another.
+type Codec int
+
+const (
+	// ProtobufCodec encodes the packets as the SphinxPacket protobuf message. It is the default codec.
+	ProtobufCodec Codec = iota
+	// CompactCodec encodes the packets as alpha || len(beta) || beta || mac || payload, where alpha and mac
+	// have fixed lengths and len(beta) is a 4 byte big endian integer. Unlike protobuf, it has no field tags,
+	// so the packets are smaller and cheaper to parse. It does not carry the crypto suite of the packets,
+	// so it can only encode the packets of the DefaultSuite.
+	CompactCodec
+)
+
+const (
+	compactMacLength      = 32
+	compactBetaLenLength  = 4
+	compactMinPacketBytes = FieldElementSize + compactBetaLenLength + compactMacLength
+)
+
+// ErrUnknownCodec is returned when parsing a name which does not belong to any Codec.
+var ErrUnknownCodec = errors.New("unknown packet codec")
+
+// nolint: gochecknoglobals
+var codecNames = map[string]Codec{
+	"protobuf": ProtobufCodec,
+	"compact":  CompactCodec,
+}
+
+// ParseCodec returns the codec with the given name, i.e. either "protobuf" or "compact".
+func ParseCodec(name string) (Codec, error) {
+	codec, ok := codecNames[name]
+	if !ok {
+		return ProtobufCodec, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+// Marshal encodes the packet. The compact codec returns ErrMalformedPacket if the packet has no header,
+// or its alpha or mac do not have the fixed lengths, and ErrUnsupportedSuite if it is not of the DefaultSuite.
+func (c Codec) Marshal(packet *SphinxPacket) ([]byte, error) {
+	if c != CompactCodec {
+		return proto.Marshal(packet)
+	}
+	hdr := packet.Hdr
+	if hdr == nil || len(hdr.Alpha) != FieldElementSize || len(hdr.Mac) != compactMacLength {
+		return nil, ErrMalformedPacket
+	}
+	if SuiteID(hdr.Suite) != DefaultSuite {
+		return nil, ErrUnsupportedSuite
+	}
+	b := make([]byte, 0, compactMinPacketBytes+len(hdr.Beta)+len(packet.Pld))
+	b = append(b, hdr.Alpha...)
+	var betaLen [compactBetaLenLength]byte
+	binary.BigEndian.PutUint32(betaLen[:], uint32(len(hdr.Beta)))
+	b = append(b, betaLen[:]...)
+	b = append(b, hdr.Beta...)
+	b = append(b, hdr.Mac...)
+	return append(b, packet.Pld...), nil
+}
+
+// Unmarshal decodes the packet. It returns ErrMalformedPacket if the packet has no header
+// or, with the compact codec, if it is truncated.
+func (c Codec) Unmarshal(b []byte) (*SphinxPacket, error) {
+	if c != CompactCodec {
+		var packet SphinxPacket
+		if err := proto.Unmarshal(b, &packet); err != nil {
+			return nil, err
+		}
+		if packet.Hdr == nil {
+			return nil, ErrMalformedPacket
+		}
+		return &packet, nil
+	}
+	if len(b) < compactMinPacketBytes {
+		return nil, ErrMalformedPacket
+	}
+	alpha, rest := b[:FieldElementSize], b[FieldElementSize:]
+	betaLen := binary.BigEndian.Uint32(rest[:compactBetaLenLength])
+	rest = rest[compactBetaLenLength:]
+	if uint64(betaLen)+compactMacLength > uint64(len(rest)) {
+		return nil, ErrMalformedPacket
+	}
+	beta, rest := rest[:betaLen], rest[betaLen:]
+	mac, payload := rest[:compactMacLength], rest[compactMacLength:]
+	return &SphinxPacket{Hdr: &Header{Alpha: copyBytes(alpha), Beta: copyBytes(beta), Mac: copyBytes(mac)},
+		Pld: copyBytes(payload),
+	}, nil
+}
+
+// copyBytes returns a copy of b, so that the decoded packet would not alias the buffer it was decoded from,
+// like it does not with protobuf.
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	path, _ := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	assert.Nil(t, err)
+
+	for _, codec := range []Codec{ProtobufCodec, CompactCodec} {
+		packetBytes, err := codec.Marshal(&packet)
+		assert.Nil(t, err)
+		decoded, err := codec.Unmarshal(packetBytes)
+		assert.Nil(t, err)
+		assert.True(t, proto.Equal(&packet, decoded), "Codec %v did not round-trip the packet", codec)
+	}
+}
+
+func TestCodec_CompactIsSmaller(t *testing.T) {
+	path, _ := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	assert.Nil(t, err)
+
+	protobufBytes, err := ProtobufCodec.Marshal(&packet)
+	assert.Nil(t, err)
+	compactBytes, err := CompactCodec.Marshal(&packet)
+	assert.Nil(t, err)
+	assert.True(t, len(compactBytes) < len(protobufBytes),
+		"Compact packet (%v bytes) should have been smaller than the protobuf one (%v bytes)",
+		len(compactBytes),
+		len(protobufBytes),
+	)
+}
+
+func TestCodec_CompactMalformed(t *testing.T) {
+	path, _ := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	assert.Nil(t, err)
+	packetBytes, err := CompactCodec.Marshal(&packet)
+	assert.Nil(t, err)
+
+	for _, truncated := range [][]byte{nil, packetBytes[:compactMinPacketBytes-1], packetBytes[:len(packet.Hdr.Beta)]} {
+		_, err := CompactCodec.Unmarshal(truncated)
+		assert.Equal(t, ErrMalformedPacket, err)
+	}
+
+	_, err = CompactCodec.Marshal(&SphinxPacket{Pld: []byte("foomp")})
+	assert.Equal(t, ErrMalformedPacket, err)
+	_, err = CompactCodec.Marshal(&SphinxPacket{Hdr: &Header{Alpha: []byte("foomp")}})
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestProcessSphinxPacketWithCodec_Compact(t *testing.T) {
+	path, priv := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.0, 0.0, 0.0}, []byte("Hello world"))
+	assert.Nil(t, err)
+	packetBytes, err := CompactCodec.Marshal(&packet)
+	assert.Nil(t, err)
+
+	// the protobuf codec can't parse the compact packet
+	_, _, _, err = ProcessSphinxPacket(packetBytes, priv)
+	assert.Error(t, err)
+
+	hop, _, newPacketBytes, err := ProcessSphinxPacketWithCodec(packetBytes, priv, CompactCodec)
+	assert.Nil(t, err)
+	assert.Equal(t, "Node1", hop.Id)
+	// the forwarded packet is encoded with the same codec
+	_, err = CompactCodec.Unmarshal(newPacketBytes)
+	assert.Nil(t, err)
+
+	tag, err := ComputeReplayTagWithCodec(packetBytes, priv, DefaultReplayTagLength, CompactCodec)
+	assert.Nil(t, err)
+	protobufBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	protobufTag, err := ComputeReplayTag(protobufBytes, priv, DefaultReplayTagLength)
+	assert.Nil(t, err)
+	assert.Equal(t, protobufTag, tag, "The replay tag should not depend on the codec")
+}
+
+func TestParseCodec(t *testing.T) {
+	for name, expected := range map[string]Codec{"protobuf": ProtobufCodec, "compact": CompactCodec} {
+		codec, err := ParseCodec(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, codec)
+	}
+	_, err := ParseCodec("foomp")
+	assert.Equal(t, ErrUnknownCodec, err)
+}
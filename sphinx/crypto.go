@@ -21,27 +21,52 @@ import (
 	"crypto/sha256"
 )
 
-// AesCtr returns AES XOR ciphertext in counter mode for the given key and plaintext
+// AesCtr returns AES XOR ciphertext in counter mode for the given key and plaintext.
+// It uses a fixed IV, which is only safe as each key is derived from the secret shared with a single hop
+// of a single packet and encrypts a single routing information with it. Any other data encrypted
+// with the same key must use a distinct IV, see AesCtrWithIV.
 func AesCtr(key, plaintext []byte) ([]byte, error) {
+	return AesCtrWithIV(key, []byte("0000000000000000"), plaintext)
+}
 
-	ciphertext := make([]byte, len(plaintext))
-
-	iv := []byte("0000000000000000")
-	//if _, err := io.ReadFull(crand.Reader, iv); err != nil {
-	//	panic(err)
-	//}
+// AesCtrWithIV returns AES XOR ciphertext in counter mode for the given key, IV and plaintext.
+func AesCtrWithIV(key, iv, plaintext []byte) ([]byte, error) {
+	if len(iv) != aes.BlockSize {
+		return nil, ErrInvalidIV
+	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
+	ciphertext := make([]byte, len(plaintext))
 	stream := cipher.NewCTR(block, iv)
 	stream.XORKeyStream(ciphertext, plaintext)
 
 	return ciphertext, nil
 }
 
+// deriveIV derives the IV for encrypting the data of the given domain with the given key, so that the data
+// of distinct domains, e.g. the routing information and the payload processed by the same hop,
+// would never be encrypted with the same keystream.
+func deriveIV(key []byte, domain string) ([]byte, error) {
+	mac, err := Hmac(key, []byte(domain))
+	if err != nil {
+		return nil, err
+	}
+	return mac[:aes.BlockSize], nil
+}
+
+// aesCtrInDomain works like AesCtrWithIV, but uses the IV derived for the given domain.
+func aesCtrInDomain(key []byte, domain string, plaintext []byte) ([]byte, error) {
+	iv, err := deriveIV(key, domain)
+	if err != nil {
+		return nil, err
+	}
+	return AesCtrWithIV(key, iv, plaintext)
+}
+
 func hash(arg []byte) ([]byte, error) {
 	h := sha256.New()
 	if _, err := h.Write(arg); err != nil {
@@ -2,6 +2,7 @@ package sphinx
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"errors"
 	"io"
@@ -20,6 +21,9 @@ type CryptoElement interface {
 	Bytes() []byte
 }
 
+// FieldElement represents either a Curve25519 scalar or the u-coordinate of a curve point
+// in its 32 byte little-endian encoding. None of the operations defined on it branch on or index
+// memory by its value, so they are safe to use on secret data derived from attacker-chosen packets.
 type FieldElement struct {
 	bytes [FieldElementSize]byte
 }
@@ -78,6 +82,18 @@ func (fe *FieldElement) Bytes() []byte {
 	return fe.bytes[:]
 }
 
+// Equal checks in constant time whether the two field elements are identical.
+func (fe *FieldElement) Equal(other *FieldElement) bool {
+	return subtle.ConstantTimeCompare(fe.bytes[:], other.bytes[:]) == 1
+}
+
+// IsZero checks in constant time whether the field element is the zero element.
+// This is the case, for example, for the result of multiplying a low-order point by any scalar.
+func (fe *FieldElement) IsZero() bool {
+	var zero [FieldElementSize]byte
+	return subtle.ConstantTimeCompare(fe.bytes[:], zero[:]) == 1
+}
+
 func (fe *FieldElement) el() *[FieldElementSize]byte {
 	return &fe.bytes
 }
@@ -127,6 +143,21 @@ func GenerateKeyPair() (*PrivateKey, *PublicKey, error) {
 	return priv, pub, nil
 }
 
+// GenerateDeterministicKeyPair derives Curve25519 keypair from the provided seed, so that the same seed
+// always results in the same keypair. It is meant to be used ONLY for reproducible tests and benchmarks
+// and must NOT be used in production, as the resulting private key is only as secret as the seed.
+func GenerateDeterministicKeyPair(seed []byte) (*PrivateKey, *PublicKey, error) {
+	if len(seed) == 0 {
+		return nil, nil, errors.New("empty seed provided")
+	}
+	priv := &PrivateKey{bytes: sha256.Sum256(seed)}
+	pub := new(PublicKey)
+	curve25519.ScalarBaseMult(&pub.bytes, &priv.bytes)
+	return priv, pub, nil
+}
+
+// CompareElements checks in constant time whether the two elements have identical byte representation.
+// Note that the comparison returns early if the lengths differ, but the lengths are not secret.
 func CompareElements(e1, e2 CryptoElement) bool {
 	return subtle.ConstantTimeCompare(e1.Bytes(), e2.Bytes()) == 1
 }
@@ -141,6 +172,9 @@ func RandomElement() (*FieldElement, error) {
 	}, nil
 }
 
+// expo computes base^(exp[0] * exp[1] * ... * exp[n]) by successive scalar multiplications.
+// curve25519.ScalarMult uses a constant-time Montgomery ladder, hence the running time
+// only depends on the (public) number of exponents.
 func expo(base *FieldElement, exp []*FieldElement) *FieldElement {
 	x := exp[0]
 	res := new(FieldElement)
@@ -153,6 +187,8 @@ func expo(base *FieldElement, exp []*FieldElement) *FieldElement {
 	return res
 }
 
+// expoGroupBase computes g^(exp[0] * exp[1] * ... * exp[n]) for the Curve25519 base point g.
+// Similarly to expo, its running time only depends on the number of exponents.
 func expoGroupBase(exp []*FieldElement) *FieldElement {
 	x := exp[0]
 	res := new(FieldElement)
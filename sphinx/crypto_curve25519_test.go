@@ -1,12 +1,26 @@
 package sphinx
 
 import (
+	"encoding/hex"
+	"flag"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/curve25519"
 )
 
+//nolint:gochecknoglobals
+var timingTests = flag.Bool("timing", false, "run (inherently noisy) timing-oriented constant-time tests")
+
+func fieldElementFromHex(t *testing.T, s string) *FieldElement {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return BytesToFieldElement(b)
+}
+
 func TestGenerateKey(t *testing.T) {
 	priv, pub, err := GenerateKeyPair()
 	assert.Nil(t, err)
@@ -46,3 +60,100 @@ func TestCommutativity(t *testing.T) {
 
 	assert.Equal(t, res1, res2)
 }
+
+// Known-answer vectors from RFC 7748, section 5.2
+func TestExpoKnownAnswer(t *testing.T) {
+	scalar := fieldElementFromHex(t, "a546e36bf0527c9d3b16154b82465edd62144c0ac1fc5a18506a2244ba449ac4")
+	base := fieldElementFromHex(t, "e6db6867583030db3594c1a424b15f7c726624ec26b3353b10a903a6d0ab1c4c")
+	expected := fieldElementFromHex(t, "c3da55379de9c6908e94ea4df28d084f32eccf03491c71f754b4075577a28552")
+
+	assert.True(t, expo(base, []*FieldElement{scalar}).Equal(expected))
+}
+
+// Known-answer vectors from RFC 7748, section 6.1
+func TestExpoGroupBaseKnownAnswer(t *testing.T) {
+	alicePriv := fieldElementFromHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	alicePub := fieldElementFromHex(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	bobPriv := fieldElementFromHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb")
+	bobPub := fieldElementFromHex(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+	sharedSecret := fieldElementFromHex(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+
+	assert.True(t, expoGroupBase([]*FieldElement{alicePriv}).Equal(alicePub))
+	assert.True(t, expoGroupBase([]*FieldElement{bobPriv}).Equal(bobPub))
+	assert.True(t, expo(bobPub, []*FieldElement{alicePriv}).Equal(sharedSecret))
+	assert.True(t, expo(alicePub, []*FieldElement{bobPriv}).Equal(sharedSecret))
+	assert.True(t, expoGroupBase([]*FieldElement{alicePriv, bobPriv}).Equal(sharedSecret))
+}
+
+func TestFieldElementEqual(t *testing.T) {
+	fe1, err := RandomElement()
+	assert.Nil(t, err)
+	fe2 := BytesToFieldElement(fe1.Bytes())
+
+	assert.True(t, fe1.Equal(fe2))
+	fe2.bytes[FieldElementSize-1] ^= 1
+	assert.False(t, fe1.Equal(fe2))
+}
+
+func TestFieldElementIsZero(t *testing.T) {
+	assert.True(t, new(FieldElement).IsZero())
+	assert.False(t, BytesToFieldElement([]byte{0, 0, 1}).IsZero())
+
+	// multiplying the zero point by any scalar should result in zero element
+	scalar, err := RandomElement()
+	assert.Nil(t, err)
+	assert.True(t, expo(new(FieldElement), []*FieldElement{scalar}).IsZero())
+}
+
+func timeEqual(fe1, fe2 *FieldElement, iterations int) time.Duration {
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		fe1.Equal(fe2)
+	}
+	return time.Since(start)
+}
+
+func TestFieldElementEqualTiming(t *testing.T) {
+	if !*timingTests {
+		t.Skip("timing tests are disabled; run with -timing to enable them")
+	}
+	const iterations = 1000000
+
+	fe1, err := RandomElement()
+	assert.Nil(t, err)
+	earlyMismatch := BytesToFieldElement(fe1.Bytes())
+	earlyMismatch.bytes[0] ^= 1
+	lateMismatch := BytesToFieldElement(fe1.Bytes())
+	lateMismatch.bytes[FieldElementSize-1] ^= 1
+
+	// warm up
+	timeEqual(fe1, earlyMismatch, iterations)
+
+	early := timeEqual(fe1, earlyMismatch, iterations)
+	late := timeEqual(fe1, lateMismatch, iterations)
+	ratio := float64(early) / float64(late)
+	assert.InDelta(t, 1.0, ratio, 0.25, "comparison time should not depend on position of the mismatch")
+}
+
+func TestGenerateDeterministicKeyPair(t *testing.T) {
+	priv1, pub1, err := GenerateDeterministicKeyPair([]byte("foomp"))
+	assert.Nil(t, err)
+	priv2, pub2, err := GenerateDeterministicKeyPair([]byte("foomp"))
+	assert.Nil(t, err)
+	assert.True(t, CompareElements(priv1, priv2))
+	assert.True(t, CompareElements(pub1, pub2))
+
+	var pubBytes [PublicKeySize]byte
+	curve25519.ScalarBaseMult(&pubBytes, &priv1.bytes)
+	assert.True(t, CompareElements(pub1, &PublicKey{bytes: pubBytes}))
+
+	priv3, pub3, err := GenerateDeterministicKeyPair([]byte("foomp2"))
+	assert.Nil(t, err)
+	assert.False(t, CompareElements(priv1, priv3))
+	assert.False(t, CompareElements(pub1, pub3))
+}
+
+func TestGenerateDeterministicKeyPair_EmptySeed(t *testing.T) {
+	_, _, err := GenerateDeterministicKeyPair(nil)
+	assert.Error(t, err)
+}
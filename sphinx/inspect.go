@@ -0,0 +1,109 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nymtech/nym-mixnet/flags"
+)
+
+// PacketInfo describes the structure of a sphinx packet for debugging purposes. It never contains
+// any secret material, such as the shared secrets, the payload or the auxiliary data of the hop.
+type PacketInfo struct {
+	AlphaLength   int
+	BetaLength    int
+	MacLength     int
+	PayloadLength int
+
+	// Verified is set if the packet was inspected with a private key, in which case MACValid tells whether
+	// the packet is addressed to the node with that key. The remaining fields are only set if the MAC is valid.
+	Verified bool
+	MACValid bool
+	NextHop  Hop
+	Flag     flags.SphinxFlag
+	Delay    float64
+	Expiry   int64
+}
+
+// InspectPacket parses the sphinx packet encoded with the given codec and describes its structure. If the private key is not nil,
+// it also checks whether the MAC of the header is valid for it and, if so, decodes the routing information
+// meant for its node. The payload is never decrypted. InspectPacket returns an error if the packet can't be
+// parsed, including ErrMalformedPacket if its header is missing or malformed.
+func InspectPacket(packetBytes []byte, codec Codec, privKey *PrivateKey) (PacketInfo, error) {
+	packet, err := codec.Unmarshal(packetBytes)
+	if err == ErrMalformedPacket {
+		return PacketInfo{}, err
+	}
+	if err != nil {
+		return PacketInfo{}, fmt.Errorf("unmarshal of packet failed: %v", err)
+	}
+	info := PacketInfo{AlphaLength: len(packet.Hdr.Alpha),
+		BetaLength:    len(packet.Hdr.Beta),
+		MacLength:     len(packet.Hdr.Mac),
+		PayloadLength: len(packet.Pld),
+	}
+	if privKey == nil {
+		return info, nil
+	}
+
+	info.Verified = true
+	hop, commands, _, err := ProcessSphinxHeader(*packet.Hdr, privKey)
+	if err == ErrInvalidMAC {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	info.MACValid = true
+	info.NextHop = hop
+	info.Flag = flags.SphinxFlagFromBytes(commands.Flag)
+	info.Delay = commands.Delay
+	info.Expiry = commands.Expiry
+	return info, nil
+}
+
+// String returns the human-readable description of the packet, one field per line.
+func (i PacketInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "alpha length: %v\n", i.AlphaLength)
+	fmt.Fprintf(&b, "beta length: %v\n", i.BetaLength)
+	fmt.Fprintf(&b, "mac length: %v\n", i.MacLength)
+	fmt.Fprintf(&b, "payload length: %v\n", i.PayloadLength)
+	if !i.Verified {
+		return b.String()
+	}
+	fmt.Fprintf(&b, "mac valid: %v\n", i.MACValid)
+	if !i.MACValid {
+		return b.String()
+	}
+	switch i.Flag {
+	case flags.LastHopFlag:
+		b.WriteString("flag: last hop\n")
+	case flags.RelayFlag:
+		b.WriteString("flag: relay\n")
+	default:
+		fmt.Fprintf(&b, "flag: unknown (%#x)\n", byte(i.Flag))
+	}
+	fmt.Fprintf(&b, "next hop id: %v\n", i.NextHop.Id)
+	fmt.Fprintf(&b, "next hop address: %v\n", i.NextHop.Address)
+	fmt.Fprintf(&b, "delay: %v\n", i.Delay)
+	if i.Expiry != 0 {
+		fmt.Fprintf(&b, "expiry: %v\n", time.Unix(i.Expiry, 0).UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectPacket(t *testing.T) {
+	path, priv := createTestPath(t)
+	message := []byte("Secret message")
+	expiry := time.Unix(1600000000, 0)
+	packet, err := PackForwardMessageWithExpiry(path, []float64{1.5, 0.0, 0.0}, message, DefaultMaxDelay, expiry)
+	assert.Nil(t, err)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+
+	info, err := InspectPacket(packetBytes, ProtobufCodec, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, PacketInfo{AlphaLength: len(packet.Hdr.Alpha),
+		BetaLength:    len(packet.Hdr.Beta),
+		MacLength:     len(packet.Hdr.Mac),
+		PayloadLength: len(packet.Pld),
+	}, info)
+	assert.NotContains(t, info.String(), "mac valid")
+
+	info, err = InspectPacket(packetBytes, ProtobufCodec, priv)
+	assert.Nil(t, err)
+	assert.True(t, info.MACValid)
+	assert.Equal(t, flags.RelayFlag, info.Flag)
+	assert.Equal(t, 1.5, info.Delay)
+	description := info.String()
+	for _, expected := range []string{"alpha length: 32\n",
+		fmt.Sprintf("payload length: %v\n", len(packet.Pld)),
+		"mac valid: true\n",
+		"flag: relay\n",
+		"next hop id: Node1\n",
+		"next hop address: localhost:3332\n",
+		"delay: 1.5\n",
+		"expiry: 2020-09-13T12:27:00Z\n", // rounded up to the end of the epoch,
+	} {
+		assert.Contains(t, description, expected)
+	}
+	assert.NotContains(t, description, string(message))
+
+	// the packet is not addressed to the node with another key
+	otherPriv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	info, err = InspectPacket(packetBytes, ProtobufCodec, otherPriv)
+	assert.Nil(t, err)
+	assert.True(t, info.Verified)
+	assert.False(t, info.MACValid)
+	assert.True(t, strings.HasSuffix(info.String(), "mac valid: false\n"))
+}
+
+func TestInspectPacket_Malformed(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	_, err = InspectPacket([]byte{0xff, 0xff, 0xff, 0xff}, ProtobufCodec, priv)
+	assert.Error(t, err)
+
+	packetBytes, err := proto.Marshal(&SphinxPacket{Pld: []byte("foomp")})
+	assert.Nil(t, err)
+	_, err = InspectPacket(packetBytes, ProtobufCodec, nil)
+	assert.Equal(t, ErrMalformedPacket, err)
+
+	packetBytes, err = proto.Marshal(&SphinxPacket{Hdr: &Header{Alpha: []byte("foomp")}})
+	assert.Nil(t, err)
+	_, err = InspectPacket(packetBytes, ProtobufCodec, priv)
+	assert.Equal(t, ErrMalformedPacket, err)
+}
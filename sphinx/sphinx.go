@@ -20,12 +20,16 @@
 package sphinx
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
@@ -37,6 +41,79 @@ const (
 	// K TODO: document padding-related Sphinx parameter
 	K            = 16
 	headerLength = 192
+
+	// DefaultMaxDelay defines the default maximum delay (in seconds) a single hop can be asked to hold a packet for.
+	DefaultMaxDelay = 10.0
+
+	// MaxHops defines the maximum number of nodes, including both providers, a single packet can traverse.
+	// The size of the header grows with each hop, hence the limit ensures the packets fit in the buffers
+	// of the receiving nodes.
+	MaxHops = 7
+
+	// DefaultReplayTagLength defines the default length (in bytes) of the tags identifying the processed packets.
+	DefaultReplayTagLength = 16
+	// MinReplayTagLength defines the shortest allowed replay tag. Shorter tags would make the collisions
+	// between distinct packets, which get them wrongly rejected as replays, too likely.
+	MinReplayTagLength = 8
+	// MaxReplayTagLength defines the longest possible replay tag, i.e. the size of the underlying hash.
+	MaxReplayTagLength = 32
+
+	replayTagDomain = "replay-tag"
+
+	// payloadTagLength defines the length of the tag binding the payload to the header of the packet.
+	payloadTagLength = 32
+	payloadTagDomain = "payload-tag"
+	// payloadIVDomain separates the keystream encrypting the payload at each hop from the one encrypting
+	// the routing information, as both are encrypted with the same key.
+	payloadIVDomain = "payload-iv"
+
+	// MaxPayloadLength defines the maximum length (in bytes) of the packet payload, including the tag
+	// binding it to the header, so that any packet would fit in a single frame along with its header.
+	// The length of the payload does not change along the path, hence the same limit applies at every hop.
+	MaxPayloadLength = 32 * 1024
+
+	// ExpiryEpoch defines the granularity of the packet expiry. The expiry is rounded up to a multiple
+	// of it, so the identical value carried by every hop does not single out the packets of a sender.
+	ExpiryEpoch = time.Minute
+
+	// MaxAuxDataLength defines the maximum length (in bytes) of the auxiliary data passed to a single hop.
+	// The data is carried in the header, so, like MaxHops, the limit bounds the size of the packets.
+	MaxAuxDataLength = 32
+)
+
+var (
+	// ErrNegativeDelay is returned when a negative delay was requested for any of the hops.
+	ErrNegativeDelay = errors.New("delays can't be negative")
+	// ErrMalformedPacket is returned when any of the required fields of the sphinx packet
+	// are either missing or have invalid length.
+	ErrMalformedPacket = errors.New("malformed sphinx packet")
+	// ErrTooManyHops is returned when the path of the packet consists of more than MaxHops nodes.
+	ErrTooManyHops = errors.New("too many hops in the path")
+	// ErrPacketExpired is returned when the packet was processed after the expiry set by its sender.
+	ErrPacketExpired = errors.New("packet has expired")
+	// ErrInvalidMAC is returned when the MAC of the header does not match the recomputed one.
+	ErrInvalidMAC = errors.New("packet processing error: MACs are not matching")
+	// ErrInvalidReplayTagLength is returned when the requested replay tag length
+	// is outside of the range from MinReplayTagLength to MaxReplayTagLength.
+	ErrInvalidReplayTagLength = errors.New("invalid replay tag length")
+	// ErrInvalidHost is returned when the host of any node on the path is neither an IP address nor a valid hostname.
+	ErrInvalidHost = errors.New("invalid host")
+	// ErrInvalidPort is returned when the port of any node on the path is not a number from 1 to 65535.
+	ErrInvalidPort = errors.New("invalid port")
+	// ErrInvalidPayload is returned when the payload reaching its final hop is not bound to the header
+	// it was received with, e.g. because it was grafted from a different packet.
+	ErrInvalidPayload = errors.New("payload does not match the header")
+	// ErrInvalidAuxData is returned when the auxiliary data was not given for every hop of the path
+	// or when the data of any hop is longer than MaxAuxDataLength.
+	ErrInvalidAuxData = errors.New("invalid auxiliary data")
+	// ErrInvalidPayloadLength is returned when the payload is either too short to carry the tag binding it
+	// to the header, or longer than MaxPayloadLength.
+	ErrInvalidPayloadLength = errors.New("invalid payload length")
+	// ErrInvalidIV is returned when the IV of the AES-CTR encryption is not a single AES block.
+	ErrInvalidIV = errors.New("invalid IV length")
+	// ErrMisalignedLayers is returned when the header is to be built from a different number of delays,
+	// commands or shared secrets than there are hops on the path, as each layer of the header needs one of each.
+	ErrMisalignedLayers = errors.New("header layers are not aligned with the hops of the path")
 )
 
 // PackForwardMessage encapsulates the given message into the cryptographic Sphinx packet format.
@@ -46,19 +123,191 @@ const (
 // In order to encapsulate the message PackForwardMessage computes two parts of the packet - the header and
 // the encrypted payload. If creating of any of the packet block failed, an error is returned. Otherwise,
 // a Sphinx packet format is returned.
+// Delays larger than DefaultMaxDelay are clamped to it.
 func PackForwardMessage(path config.E2EPath, delays []float64, message []byte) (SphinxPacket, error) {
+	return PackForwardMessageWithMaxDelay(path, delays, message, DefaultMaxDelay)
+}
+
+// PackForwardMessageWithMaxDelay works like PackForwardMessage, but clamps the per-hop delays
+// to the provided maximum instead of DefaultMaxDelay.
+func PackForwardMessageWithMaxDelay(path config.E2EPath,
+	delays []float64,
+	message []byte,
+	maxDelay float64,
+) (SphinxPacket, error) {
+	return PackForwardMessageWithExpiry(path, delays, message, maxDelay, time.Time{})
+}
+
+// PackForwardMessageWithExpiry works like PackForwardMessageWithMaxDelay, but additionally instructs
+// each hop to drop the packet if it is processed after the given expiry. A zero expiry means
+// the packet never expires. Note that the expiry is rounded up to a multiple of ExpiryEpoch.
+func PackForwardMessageWithExpiry(path config.E2EPath,
+	delays []float64,
+	message []byte,
+	maxDelay float64,
+	expiry time.Time,
+) (SphinxPacket, error) {
+	return PackForwardMessageWithAuxData(path, delays, message, maxDelay, expiry, nil)
+}
+
+// PackForwardMessageWithAuxData works like PackForwardMessageWithExpiry, but additionally passes
+// the given auxiliary data to the respective hops, i.e. auxData[i] is only readable by the i-th node
+// on the path, starting with the ingress provider. The auxiliary data can either be nil, for none at all,
+// or contain an entry, possibly empty, for every hop. No entry can be longer than MaxAuxDataLength.
+func PackForwardMessageWithAuxData(path config.E2EPath,
+	delays []float64,
+	message []byte,
+	maxDelay float64,
+	expiry time.Time,
+	auxData [][]byte,
+) (SphinxPacket, error) {
+	x, err := RandomElement()
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackForwardMessage - Random failed: %v", err)
+		return SphinxPacket{}, errMsg
+	}
+	return packForwardMessage(path, delays, message, maxDelay, expiry, auxData, x)
+}
+
+// packForwardMessage works like PackForwardMessageWithAuxData, but uses the given initial secret element x
+// instead of a fresh random one. Fixing x is only meant for deriving test vectors, as the packets sharing it
+// would be linkable.
+func packForwardMessage(path config.E2EPath,
+	delays []float64,
+	message []byte,
+	maxDelay float64,
+	expiry time.Time,
+	auxData [][]byte,
+	x *FieldElement,
+) (SphinxPacket, error) {
+	packer, err := NewPacker(path, maxDelay, expiry, auxData)
+	if err != nil {
+		return SphinxPacket{}, err
+	}
+	return packer.pack(delays, message, x)
+}
+
+// Packer packs any number of messages into sphinx packets travelling along the same path, e.g. the fragments
+// of a larger message or cover traffic. The path and the routing commands are validated only once,
+// when the Packer is created, while each packet still gets its own fresh initial secret element,
+// so that the packets could not be linked with each other by the nodes on the path.
+// A Packer can be used concurrently.
+type Packer struct {
+	nodes              []config.MixConfig
+	addresses          []string
+	destination        config.ClientConfig
+	destinationAddress string
+	maxDelay           float64
+	// commands holds the routing commands of all the hops, apart from the delays, which are set per packet.
+	commands []Commands
+	// suite is the id of the crypto suite the packets are encrypted and authenticated with.
+	suite  SuiteID
+	crypto CryptoSuite
+}
+
+// NewPacker validates the path, with the expiry and the auxiliary data of the packets,
+// exactly like PackForwardMessageWithAuxData, and returns a Packer for it.
+func NewPacker(path config.E2EPath, maxDelay float64, expiry time.Time, auxData [][]byte) (*Packer, error) {
+	return NewPackerWithSuite(path, maxDelay, expiry, auxData, DefaultSuite)
+}
+
+// NewPackerWithSuite works like NewPacker, but the packets are encrypted and authenticated with the crypto suite
+// registered under the given id, which all the nodes on the path have to support.
+// It returns ErrUnsupportedSuite if the suite is not registered.
+func NewPackerWithSuite(path config.E2EPath,
+	maxDelay float64,
+	expiry time.Time,
+	auxData [][]byte,
+	suite SuiteID,
+) (*Packer, error) {
+	crypto, err := LookupSuite(suite)
+	if err != nil {
+		return nil, err
+	}
+
 	nodes := []config.MixConfig{path.IngressProvider}
 	nodes = append(nodes, path.Mixes...)
 	nodes = append(nodes, path.EgressProvider)
-	dest := path.Recipient
 
-	headerInitials, header, err := createHeader(nodes, delays, dest)
+	if len(nodes) > MaxHops {
+		return nil, ErrTooManyHops
+	}
+
+	var expiryUnix int64
+	if !expiry.IsZero() {
+		epoch := int64(ExpiryEpoch / time.Second)
+		expiryUnix = (expiry.Unix() + epoch - 1) / epoch * epoch
+	}
+
+	if auxData != nil && len(auxData) != len(nodes) {
+		return nil, ErrInvalidAuxData
+	}
+	for _, data := range auxData {
+		if len(data) > MaxAuxDataLength {
+			return nil, ErrInvalidAuxData
+		}
+	}
+
+	addresses, destinationAddress, err := validateAddresses(nodes, path.Recipient)
 	if err != nil {
-		errMsg := fmt.Errorf("error in PackForwardMessage - createHeader failed: %v", err)
+		errMsg := fmt.Errorf("error in PackForwardMessage - invalid path: %v", err)
+		return nil, errMsg
+	}
+
+	commands := make([]Commands, len(nodes))
+	for i := range nodes {
+		c := Commands{Flag: flags.RelayFlag.Bytes(), Expiry: expiryUnix}
+		if i == len(nodes)-1 {
+			c.Flag = flags.LastHopFlag.Bytes()
+		}
+		if auxData != nil {
+			c.AuxData = auxData[i]
+		}
+		commands[i] = c
+	}
+
+	return &Packer{nodes: nodes,
+		addresses:          addresses,
+		destination:        path.Recipient,
+		destinationAddress: destinationAddress,
+		maxDelay:           maxDelay,
+		commands:           commands,
+		suite:              suite,
+		crypto:             crypto,
+	}, nil
+}
+
+// Pack encapsulates the message into a sphinx packet with a fresh initial secret element,
+// instructing the hops to delay it by the given delays, which are clamped to the maximum delay of the Packer.
+func (p *Packer) Pack(delays []float64, message []byte) (SphinxPacket, error) {
+	x, err := RandomElement()
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackForwardMessage - Random failed: %v", err)
 		return SphinxPacket{}, errMsg
 	}
+	return p.pack(delays, message, x)
+}
 
-	payload, err := encapsulateContent(headerInitials, message)
+// pack works like Pack, but uses the given initial secret element x.
+func (p *Packer) pack(delays []float64, message []byte, x *FieldElement) (SphinxPacket, error) {
+	if len(message) > PayloadSize() {
+		return SphinxPacket{}, ErrInvalidPayloadLength
+	}
+
+	headerInitials, header, err := p.createHeader(delays, x)
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackForwardMessage - createHeader failed: %w", err)
+		return SphinxPacket{}, errMsg
+	}
+
+	// the final hop verifies the tag, so that the payload could not be combined with any other header
+	tag, err := computePayloadTag(p.crypto, headerInitials[len(headerInitials)-1].SecretHash, message)
+	if err != nil {
+		errMsg := fmt.Errorf("error in PackForwardMessage - computePayloadTag failed: %v", err)
+		return SphinxPacket{}, errMsg
+	}
+
+	payload, err := encapsulateContent(p.crypto, headerInitials, append(tag, message...))
 	if err != nil {
 		errMsg := fmt.Errorf("error in PackForwardMessage - encapsulateContent failed: %v", err)
 		return SphinxPacket{}, errMsg
@@ -68,65 +317,165 @@ func PackForwardMessage(path config.E2EPath, delays []float64, message []byte) (
 
 // createHeader builds the Sphinx packet header, consisting of three parts: the public element,
 // the encapsulated routing information and the message authentication code.
-// createHeader layer encapsulates the routing information for each given node. The routing information
+// createHeader layer encapsulates the routing information for each node of the path. The routing information
 // contains information where the packet should be forwarded next, how long it should be delayed by the node,
 // and if relevant additional auxiliary information. The message authentication code allows to detect tagging attacks.
 // createHeader computes the secret shared key between sender and the nodes and destination,
 // which are used as keys for encryption.
 // createHeader returns the header and a list of the initial elements, used for creating the header.
+// Any negative delay results in an error, while delays larger than the maximum delay are clamped to it.
+// The shared secrets are derived from the initial secret element x.
 // If any operation was unsuccessful createHeader returns an error.
-func createHeader(nodes []config.MixConfig,
-	delays []float64,
-	dest config.ClientConfig,
-) ([]HeaderInitials, Header, error) {
-	x, err := RandomElement()
+func (p *Packer) createHeader(delays []float64, x *FieldElement) ([]HeaderInitials, Header, error) {
+	// the paths of the clients count the recipient as well, whose delay is not carried by the header
+	if len(delays) < len(p.nodes) {
+		return nil, Header{}, fmt.Errorf("%w: got %v delays for %v hops", ErrMisalignedLayers, len(delays), len(p.nodes))
+	}
+	clampedDelays, err := clampDelays(delays, p.maxDelay)
 	if err != nil {
-		errMsg := fmt.Errorf("error in createHeader - Random failed: %v", err)
+		errMsg := fmt.Errorf("error in createHeader - invalid delays: %v", err)
 		return nil, Header{}, errMsg
 	}
 
-	headerInitials, err := getSharedSecrets(nodes, x)
+	headerInitials, err := getSharedSecrets(p.nodes, x)
 	if err != nil {
 		errMsg := fmt.Errorf("error in createHeader - getSharedSecrets failed: %v", err)
 		return nil, Header{}, errMsg
 	}
 
-	if len(headerInitials) != len(nodes) {
-		errMsg := fmt.Errorf("error in createHeader - wrong number of shared secrets failed: %v", err)
-		return nil, Header{}, errMsg
+	// the commands of the Packer are shared by all the packets, so the delays are set on a copy
+	commands := make([]Commands, len(p.commands))
+	for i, c := range p.commands {
+		commands[i] = Commands{Delay: clampedDelays[i], Flag: c.Flag, Expiry: c.Expiry, AuxData: c.AuxData}
 	}
 
-	commands := make([]Commands, len(nodes))
-	for i := range nodes {
-		var c Commands
-		if i == len(nodes)-1 {
-			c = Commands{Delay: delays[i], Flag: flags.LastHopFlag.Bytes()}
-		} else {
-			c = Commands{Delay: delays[i], Flag: flags.RelayFlag.Bytes()}
+	header, err := encapsulateRouting(p.crypto,
+		headerInitials,
+		p.nodes,
+		p.addresses,
+		commands,
+		p.destination,
+		p.destinationAddress,
+	)
+	if err != nil {
+		return nil, Header{}, fmt.Errorf("error in createHeader - encapsulateHeader failed: %w", err)
+	}
+	header.Suite = uint32(p.suite)
+	return headerInitials, header, nil
+
+}
+
+// joinAddress validates the host and the port and combines them into an address, bracketing the IPv6 hosts.
+// It returns ErrInvalidHost or ErrInvalidPort if either of them is malformed.
+func joinAddress(host, port string) (string, error) {
+	if net.ParseIP(host) == nil && !validHostname(host) {
+		return "", ErrInvalidHost
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > math.MaxUint16 {
+		return "", ErrInvalidPort
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// validHostname checks whether the host consists of dot separated labels of letters, digits, hyphens
+// and underscores, which do not start or end with a hyphen.
+func validHostname(host string) bool {
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
 		}
-		commands[i] = c
 	}
+	return true
+}
 
-	header, err := encapsulateHeader(headerInitials, nodes, commands, dest)
-	if err != nil {
-		errMsg := fmt.Errorf("error in createHeader - encapsulateHeader failed: %v", err)
-		return nil, Header{}, errMsg
+// Expired checks whether the packet with the given commands should be dropped if it is processed at the given time,
+// allowing for up to tolerance of clock skew between the sender and the processing node.
+// Commands without the expiry never expire.
+func (c *Commands) Expired(now time.Time, tolerance time.Duration) bool {
+	if c.Expiry == 0 {
+		return false
 	}
-	return headerInitials, header, nil
+	return now.After(time.Unix(c.Expiry, 0).Add(tolerance))
+}
 
+// clampDelays returns a copy of the given delays with each value larger than maxDelay replaced by maxDelay.
+// If any of the delays is negative (or not a number), an error is returned instead.
+func clampDelays(delays []float64, maxDelay float64) ([]float64, error) {
+	clamped := make([]float64, len(delays))
+	for i, delay := range delays {
+		// written this way so that NaN would also get rejected
+		if !(delay >= 0) {
+			return nil, ErrNegativeDelay
+		}
+		clamped[i] = math.Min(delay, maxDelay)
+	}
+	return clamped, nil
 }
 
 // encapsulateHeader layer encrypts the meta-data of the packet, containing information about the
 // sequence of nodes the packet should traverse before reaching the destination, and message authentication codes,
 // given the pre-computed shared keys which are used for encryption.
+// The addresses of all the nodes and of the destination are validated beforehand, so that the malformed ones
+// would not only be detected when the packet is forwarded. As the clients fetch their messages from
+// their providers, the address of the destination can be omitted altogether.
 // encapsulateHeader returns the Header, or an error if any internal cryptographic of parsing operation failed.
 func encapsulateHeader(headerInitials []HeaderInitials,
 	nodes []config.MixConfig,
 	commands []Commands,
 	destination config.ClientConfig,
 ) (Header, error) {
+	addresses, destinationAddress, err := validateAddresses(nodes, destination)
+	if err != nil {
+		return Header{}, err
+	}
+	return encapsulateRouting(aesCtrSuite{}, headerInitials, nodes, addresses, commands, destination, destinationAddress)
+}
+
+// validateAddresses validates the addresses of all the nodes and of the destination, if it has any,
+// and returns them as they are put in the header.
+func validateAddresses(nodes []config.MixConfig, destination config.ClientConfig) ([]string, string, error) {
+	addresses := make([]string, len(nodes))
+	for i, node := range nodes {
+		address, err := joinAddress(node.Host, node.Port)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid address of node %q: %v", node.Id, err)
+		}
+		addresses[i] = address
+	}
+	var destinationAddress string
+	if destination.Host != "" || destination.Port != "" {
+		address, err := joinAddress(destination.Host, destination.Port)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid address of destination %q: %v", destination.Id, err)
+		}
+		destinationAddress = address
+	}
+	return addresses, destinationAddress, nil
+}
+
+// encapsulateRouting works like encapsulateHeader, but takes the already validated addresses of the nodes
+// and of the destination, and encrypts and authenticates the routing information with the given crypto suite.
+func encapsulateRouting(crypto CryptoSuite,
+	headerInitials []HeaderInitials,
+	nodes []config.MixConfig,
+	addresses []string,
+	commands []Commands,
+	destination config.ClientConfig,
+	destinationAddress string,
+) (Header, error) {
+	if err := checkLayers(headerInitials, nodes, addresses, commands); err != nil {
+		return Header{}, err
+	}
 	finalHop := RoutingInfo{NextHop: &Hop{Id: destination.Id,
-		Address: destination.Host + ":" + destination.Port,
+		Address: destinationAddress,
 		PubKey:  []byte{},
 	}, RoutingCommands: &commands[len(commands)-1],
 		NextHopMetaData: []byte{},
@@ -143,13 +492,13 @@ func encapsulateHeader(headerInitials []HeaderInitials,
 		return Header{}, err
 	}
 
-	encFinalHop, err := AesCtr(kdfRes, finalHopBytes)
+	encFinalHop, err := crypto.Encrypt(kdfRes, "", finalHopBytes)
 	if err != nil {
 		errMsg := fmt.Errorf("error in encapsulateHeader - AES_CTR encryption failed: %v", err)
 		return Header{}, errMsg
 	}
 
-	mac, err := computeMac(kdfRes, encFinalHop)
+	mac, err := crypto.MAC(kdfRes, encFinalHop)
 	if err != nil {
 		return Header{}, err
 	}
@@ -160,7 +509,7 @@ func encapsulateHeader(headerInitials []HeaderInitials,
 	for i := len(nodes) - 2; i >= 0; i-- {
 		nextNode := nodes[i+1]
 		routing := RoutingInfo{NextHop: &Hop{Id: nextNode.Id,
-			Address: nextNode.Host + ":" + nextNode.Port,
+			Address: addresses[i+1],
 			PubKey:  nodes[i+1].PubKey,
 		}, RoutingCommands: &commands[i],
 			NextHopMetaData: routingCommands[len(routingCommands)-1],
@@ -177,7 +526,7 @@ func encapsulateHeader(headerInitials []HeaderInitials,
 			return Header{}, err
 		}
 
-		encRouting, err = AesCtr(encKey, routingBytes)
+		encRouting, err = crypto.Encrypt(encKey, "", routingBytes)
 		if err != nil {
 			return Header{}, err
 		}
@@ -185,9 +534,9 @@ func encapsulateHeader(headerInitials []HeaderInitials,
 		routingCommands = append(routingCommands, encRouting)
 		kdfResL, err := KDF(headerInitials[i].SecretHash)
 		if err != nil {
-			return Header{}, nil
+			return Header{}, err
 		}
-		mac, err = computeMac(kdfResL, encRouting)
+		mac, err = crypto.MAC(kdfResL, encRouting)
 		if err != nil {
 			return Header{}, err
 		}
@@ -197,11 +546,27 @@ func encapsulateHeader(headerInitials []HeaderInitials,
 
 }
 
+// checkLayers returns ErrMisalignedLayers, describing the mismatch, unless there is a shared secret, an address
+// and a set of commands for each of the nodes on the path, which has at least one of them.
+func checkLayers(headerInitials []HeaderInitials, nodes []config.MixConfig, addresses []string, commands []Commands) error {
+	switch {
+	case len(nodes) == 0:
+		return fmt.Errorf("%w: the path has no hops", ErrMisalignedLayers)
+	case len(headerInitials) != len(nodes):
+		return fmt.Errorf("%w: got %v shared secrets for %v hops", ErrMisalignedLayers, len(headerInitials), len(nodes))
+	case len(addresses) != len(nodes):
+		return fmt.Errorf("%w: got %v addresses for %v hops", ErrMisalignedLayers, len(addresses), len(nodes))
+	case len(commands) != len(nodes):
+		return fmt.Errorf("%w: got %v commands for %v hops", ErrMisalignedLayers, len(commands), len(nodes))
+	}
+	return nil
+}
+
 // encapsulateContent layer encrypts the given messages using a set of shared keys
-// and the AES_CTR encryption.
-// encapsulateContent returns the encrypted payload in byte representation. If the AES_CTR
+// and the encryption of the given crypto suite.
+// encapsulateContent returns the encrypted payload in byte representation. If the
 // encryption failed encapsulateContent returns an error.
-func encapsulateContent(headerInitials []HeaderInitials, message []byte) ([]byte, error) {
+func encapsulateContent(crypto CryptoSuite, headerInitials []HeaderInitials, message []byte) ([]byte, error) {
 
 	enc := message
 
@@ -210,7 +575,7 @@ func encapsulateContent(headerInitials []HeaderInitials, message []byte) ([]byte
 		if err != nil {
 			return nil, err
 		}
-		enc, err = AesCtr(sharedKey, enc)
+		enc, err = crypto.Encrypt(sharedKey, payloadIVDomain, enc)
 		if err != nil {
 			errMsg := fmt.Errorf("error in encapsulateContent - AES_CTR encryption failed: %v", err)
 			return nil, errMsg
@@ -312,6 +677,8 @@ func computeFillers(nodes []config.MixConfig, tuples []HeaderInitials) (string,
 // computeBlindingFactor computes the blinding factor extracted from the
 // shared secrets. Blinding factors allow both the sender and intermediate nodes
 // recompute the shared keys used at each hop of the message processing.
+// The fixed IV does not encrypt any data, it only separates the blinding factor from the other values
+// derived from the same key.
 // computeBlindingFactor returns a value of a blinding factor or an error.
 func computeBlindingFactor(key []byte) (*FieldElement, error) {
 	iv := []byte("initialvector000")
@@ -347,97 +714,202 @@ func computeSharedSecretHash(key []byte, iv []byte) ([]byte, error) {
 // ProcessSphinxPacket processes the sphinx packet using the given private key.
 // ProcessSphinxPacket unwraps one layer of both the header and the payload encryption.
 // ProcessSphinxPacket returns a new packet and the routing information which should
-// be used by the processing node. If any cryptographic or parsing operation failed ProcessSphinxPacket
-// returns an error.
+// be used by the processing node. If the packet has reached its last hop, there is no new packet
+// to forward, so the message carried by the packet is returned instead. If any cryptographic
+// or parsing operation failed ProcessSphinxPacket returns an error.
 func ProcessSphinxPacket(packetBytes []byte, privKey *PrivateKey) (Hop, Commands, []byte, error) {
+	return ProcessSphinxPacketWithCodec(packetBytes, privKey, ProtobufCodec)
+}
 
-	var packet SphinxPacket
-	err := proto.Unmarshal(packetBytes, &packet)
-
+// ProcessSphinxPacketWithCodec works like ProcessSphinxPacket, but both the received and the returned packet
+// are encoded with the given codec.
+func ProcessSphinxPacketWithCodec(packetBytes []byte, privKey *PrivateKey, codec Codec) (Hop, Commands, []byte, error) {
+	packet, err := codec.Unmarshal(packetBytes)
+	if err == ErrMalformedPacket {
+		return Hop{}, Commands{}, nil, err
+	}
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxPacket - unmarshal of packet failed: %v", err)
 		return Hop{}, Commands{}, nil, errMsg
 	}
+	hop, commands, newPacket, _, err := processSphinxPacket(packet, privKey, codec)
+	return hop, commands, newPacket, err
+}
+
+// ProcessSphinxPacketWithReplayTag works like ProcessSphinxPacketWithCodec, but additionally returns the tag
+// of the given length identifying the packet, exactly as computed by ComputeReplayTag. The tag is derived
+// from the secret shared with the sender which the processing recomputes anyway, hence it comes at no extra cost.
+// It returns ErrInvalidReplayTagLength if the length is invalid, and ErrMalformedPacket if the packet could not be parsed.
+func ProcessSphinxPacketWithReplayTag(packetBytes []byte,
+	privKey *PrivateKey,
+	codec Codec,
+	tagLength int,
+) (Hop, Commands, []byte, []byte, error) {
+	if err := ValidateReplayTagLength(tagLength); err != nil {
+		return Hop{}, Commands{}, nil, nil, err
+	}
+	packet, err := codec.Unmarshal(packetBytes)
+	if err != nil {
+		return Hop{}, Commands{}, nil, nil, ErrMalformedPacket
+	}
+	hop, commands, newPacket, aesS, err := processSphinxPacket(packet, privKey, codec)
+	if err != nil {
+		return Hop{}, Commands{}, nil, nil, err
+	}
+	tag, err := replayTag(aesS, tagLength)
+	if err != nil {
+		return Hop{}, Commands{}, nil, nil, err
+	}
+	return hop, commands, newPacket, tag, nil
+}
+
+// processSphinxPacket implements ProcessSphinxPacketWithCodec for the unmarshalled packet, additionally returning
+// the hash of the secret shared between the sender and the node.
+func processSphinxPacket(packet *SphinxPacket, privKey *PrivateKey, codec Codec) (Hop, Commands, []byte, []byte, error) {
+	// the payload is checked first, as it is much cheaper to do than processing the header
+	if err := validatePayloadLength(packet.Pld); err != nil {
+		return Hop{}, Commands{}, nil, nil, err
+	}
+
+	crypto, err := LookupSuite(SuiteID(packet.Hdr.Suite))
+	if err != nil {
+		return Hop{}, Commands{}, nil, nil, err
+	}
 
-	hop, commands, newHeader, err := ProcessSphinxHeader(*packet.Hdr, privKey)
+	hop, commands, newHeader, aesS, err := processSphinxHeader(crypto, *packet.Hdr, privKey)
+	// the well-defined errors are returned as they are, so that the callers could tell them apart
+	if err == ErrMalformedPacket || err == ErrInvalidMAC || err == ErrUnsupportedSuite {
+		return Hop{}, Commands{}, nil, nil, err
+	}
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxHeader failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		return Hop{}, Commands{}, nil, nil, errMsg
 	}
 
-	newPayload, err := ProcessSphinxPayload(packet.Hdr.Alpha, packet.Pld, privKey)
+	// the secret recomputed for the header is reused, so that the packet is processed with a single ECDH
+	newPayload, err := processPayload(crypto, aesS, packet.Pld)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxPacket - ProcessSphinxPayload failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		return Hop{}, Commands{}, nil, nil, errMsg
+	}
+
+	if flags.SphinxFlagFromBytes(commands.Flag) == flags.LastHopFlag {
+		message, err := verifyPayloadTag(crypto, aesS, newPayload)
+		if err != nil {
+			return Hop{}, Commands{}, nil, nil, err
+		}
+		return hop, commands, message, aesS, nil
 	}
 
 	newPacket := SphinxPacket{Hdr: &newHeader, Pld: newPayload}
-	newPacketBytes, err := proto.Marshal(&newPacket)
+	newPacketBytes, err := codec.Marshal(&newPacket)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxPacket - marshal of packet failed: %v", err)
-		return Hop{}, Commands{}, nil, errMsg
+		return Hop{}, Commands{}, nil, nil, errMsg
 	}
 
-	return hop, commands, newPacketBytes, nil
+	return hop, commands, newPacketBytes, aesS, nil
 }
 
 // ProcessSphinxHeader unwraps one layer of encryption from the header of a sphinx packet.
 // ProcessSphinxHeader recomputes the shared key and checks whether the message authentication code is valid.
 // If not, the packet is dropped and error is returned. If MAC checking was passed successfully ProcessSphinxHeader
-// performs the AES_CTR decryption, recomputes the blinding factor and updates the init public element from the header.
+// performs the decryption, recomputes the blinding factor and updates the init public element from the header.
 // Next, ProcessSphinxHeader extracts the routing information from the decrypted packet and returns it,
 // together with the updated init public element.
+// The header is processed with the crypto suite it names, and ErrUnsupportedSuite is returned if it is not registered.
 // If any crypto or parsing operation failed ProcessSphinxHeader returns an error.
 func ProcessSphinxHeader(packet Header, privKey *PrivateKey) (Hop, Commands, Header, error) {
-	alpha := BytesToFieldElement(packet.Alpha)
-	beta := packet.Beta
-	mac := packet.Mac
-
-	sharedSecret := new(FieldElement)
-	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), alpha.el())
-
-	aesS, err := KDF(sharedSecret.Bytes())
-	if err != nil {
-		return Hop{}, Commands{}, Header{}, err
-	}
-	encKey, err := KDF(aesS)
+	crypto, err := LookupSuite(SuiteID(packet.Suite))
 	if err != nil {
 		return Hop{}, Commands{}, Header{}, err
 	}
+	hop, commands, header, _, err := processSphinxHeader(crypto, packet, privKey)
+	return hop, commands, header, err
+}
 
-	recomputedMac, err := computeMac(encKey, beta)
+// processSphinxHeader implements ProcessSphinxHeader for the header of the given crypto suite,
+// additionally returning the hash of the secret shared between the sender and the node.
+func processSphinxHeader(crypto CryptoSuite, packet Header, privKey *PrivateKey) (Hop, Commands, Header, []byte, error) {
+	alpha, aesS, encKey, err := verifyHeaderMac(crypto, packet, privKey)
 	if err != nil {
-		return Hop{}, Commands{}, Header{}, err
-	}
-
-	if !bytes.Equal(recomputedMac, mac) {
-		return Hop{}, Commands{}, Header{}, errors.New("packet processing error: MACs are not matching")
+		return Hop{}, Commands{}, Header{}, nil, err
 	}
+	beta := packet.Beta
 
 	blinder, err := computeBlindingFactor(aesS)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxHeader - computeBlindingFactor failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+		return Hop{}, Commands{}, Header{}, nil, errMsg
 	}
 
 	newAlpha := new(FieldElement)
 	curve25519.ScalarMult(newAlpha.el(), blinder.el(), alpha.el())
 
-	decBeta, err := AesCtr(encKey, beta)
+	decBeta, err := crypto.Encrypt(encKey, "", beta)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxHeader - AES_CTR failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+		return Hop{}, Commands{}, Header{}, nil, errMsg
 	}
 
 	var routingInfo RoutingInfo
 	err = proto.Unmarshal(decBeta, &routingInfo)
 	if err != nil {
 		errMsg := fmt.Errorf("error in ProcessSphinxHeader - unmarshal of beta failed: %v", err)
-		return Hop{}, Commands{}, Header{}, errMsg
+		return Hop{}, Commands{}, Header{}, nil, errMsg
+	}
+	if routingInfo.NextHop == nil || routingInfo.RoutingCommands == nil {
+		return Hop{}, Commands{}, Header{}, nil, ErrMalformedPacket
 	}
 	nextHop, commands, nextBeta, nextMac := readBeta(routingInfo)
 
-	return nextHop, commands, Header{Alpha: newAlpha.Bytes(), Beta: nextBeta, Mac: nextMac}, nil
+	return nextHop, commands, Header{Alpha: newAlpha.Bytes(), Beta: nextBeta, Mac: nextMac, Suite: packet.Suite}, aesS, nil
+}
+
+// VerifySphinxHeader checks whether the header of a sphinx packet is well-formed and its message authentication code
+// is valid for the node with the given private key, without unwrapping the header. It returns ErrMalformedPacket,
+// ErrInvalidMAC or ErrUnsupportedSuite respectively, exactly like ProcessSphinxHeader would. Note that a header
+// passing the verification can still be rejected by ProcessSphinxHeader if its sender authenticated malformed
+// routing information.
+func VerifySphinxHeader(packet Header, privKey *PrivateKey) error {
+	crypto, err := LookupSuite(SuiteID(packet.Suite))
+	if err != nil {
+		return err
+	}
+	_, _, _, err = verifyHeaderMac(crypto, packet, privKey)
+	return err
+}
+
+// verifyHeaderMac recomputes the secrets the node shares with the sender of the header and checks
+// the MAC of the header with them. It returns the init public element of the header and the shared secrets.
+func verifyHeaderMac(crypto CryptoSuite, packet Header, privKey *PrivateKey) (*FieldElement, []byte, []byte, error) {
+	if len(packet.Alpha) != FieldElementSize {
+		return nil, nil, nil, ErrMalformedPacket
+	}
+	alpha := BytesToFieldElement(packet.Alpha)
+
+	sharedSecret := new(FieldElement)
+	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), alpha.el())
+
+	aesS, err := KDF(sharedSecret.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encKey, err := KDF(aesS)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	recomputedMac, err := crypto.MAC(encKey, packet.Beta)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// the MAC has to be compared in constant time, otherwise the timing would leak how much of a forged MAC is valid
+	if !hmac.Equal(recomputedMac, packet.Mac) {
+		return nil, nil, nil, ErrInvalidMAC
+	}
+	return alpha, aesS, encKey, nil
 }
 
 // readBeta extracts all the fields from the RoutingInfo structure
@@ -453,7 +925,14 @@ func readBeta(beta RoutingInfo) (Hop, Commands, []byte, []byte) {
 // ProcessSphinxPayload unwraps a single layer of the encryption from the sphinx packet payload.
 // ProcessSphinxPayload first recomputes the shared secret which is used to perform the AES_CTR decryption.
 // ProcessSphinxPayload returns the new packet payload or an error if the decryption failed.
+// Payloads which could not have been created by PackForwardMessage, as they are either too short
+// to carry the payload tag or longer than MaxPayloadLength, are rejected with ErrInvalidPayloadLength
+// without being decrypted. The payload has to be encrypted with the DefaultSuite.
 func ProcessSphinxPayload(alpha []byte, payload []byte, privKey *PrivateKey) ([]byte, error) {
+	if err := validatePayloadLength(payload); err != nil {
+		return nil, err
+	}
+
 	sharedSecret := new(FieldElement)
 	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), BytesToFieldElement(alpha).el())
 
@@ -461,17 +940,139 @@ func ProcessSphinxPayload(alpha []byte, payload []byte, privKey *PrivateKey) ([]
 	if err != nil {
 		return nil, err
 	}
+	return processPayload(aesCtrSuite{}, aesS, payload)
+}
+
+// processPayload works like ProcessSphinxPayload for the payload encrypted with the given crypto suite,
+// taking the hash of the secret shared between the sender and the node, already recomputed for the header,
+// instead of the init public element.
+func processPayload(crypto CryptoSuite, aesS []byte, payload []byte) ([]byte, error) {
+	if err := validatePayloadLength(payload); err != nil {
+		return nil, err
+	}
 
 	decKey, err := KDF(aesS)
 	if err != nil {
 		return nil, err
 	}
 
-	decPayload, err := AesCtr(decKey, payload)
+	decPayload, err := crypto.Encrypt(decKey, payloadIVDomain, payload)
 	if err != nil {
-		errMsg := fmt.Errorf("error in ProcessSphinxPayload - AES_CTR decryption failed: %v", err)
+		errMsg := fmt.Errorf("error in ProcessSphinxPayload - decryption failed: %v", err)
 		return nil, errMsg
 	}
 
 	return decPayload, nil
 }
+
+// validatePayloadLength checks whether the payload is long enough to carry the payload tag
+// and not longer than MaxPayloadLength.
+func validatePayloadLength(payload []byte) error {
+	if len(payload) < payloadTagLength || len(payload) > MaxPayloadLength {
+		return ErrInvalidPayloadLength
+	}
+	return nil
+}
+
+// computePayloadTag computes the tag binding the message to the header, given the hash of the secret
+// shared between the sender and the final hop. As the secret is derived from the initial element of the header,
+// the tag is unique to the header.
+func computePayloadTag(crypto CryptoSuite, secretHash []byte, message []byte) ([]byte, error) {
+	// the domain separation ensures the tag key differs from the keys used for the encryption
+	key, err := KDF(append([]byte(payloadTagDomain), secretHash...))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MAC(key, message)
+}
+
+// verifyPayloadTag checks whether the fully decrypted payload, received at the final hop with the given hash
+// of the secret shared with the sender, carries the tag binding it to the header. It returns the message
+// with the tag removed, or ErrInvalidPayload if the tag does not match.
+func verifyPayloadTag(crypto CryptoSuite, aesS []byte, payload []byte) ([]byte, error) {
+	if len(payload) < payloadTagLength {
+		return nil, ErrInvalidPayload
+	}
+	tag, message := payload[:payloadTagLength], payload[payloadTagLength:]
+	expectedTag, err := computePayloadTag(crypto, aesS, message)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, expectedTag) {
+		return nil, ErrInvalidPayload
+	}
+	return message, nil
+}
+
+// ValidateReplayTagLength checks whether tags of the given length can be computed and are long enough
+// for collisions between distinct packets to be negligible.
+func ValidateReplayTagLength(length int) error {
+	if length < MinReplayTagLength || length > MaxReplayTagLength {
+		return ErrInvalidReplayTagLength
+	}
+	return nil
+}
+
+// ComputeReplayTag computes the tag identifying the given sphinx packet at the node with the given private key.
+// The tag is derived from the secret shared between the sender and the node, which is unique for each packet,
+// so a replayed packet always results in the same tag. The tag is truncated to the given length.
+// ComputeReplayTag returns an error if the packet could not be parsed or the length is invalid.
+func ComputeReplayTag(packetBytes []byte, privKey *PrivateKey, length int) ([]byte, error) {
+	return ComputeReplayTagWithCodec(packetBytes, privKey, length, ProtobufCodec)
+}
+
+// ComputeReplayTagWithCodec works like ComputeReplayTag for the packet encoded with the given codec.
+func ComputeReplayTagWithCodec(packetBytes []byte, privKey *PrivateKey, length int, codec Codec) ([]byte, error) {
+	if err := ValidateReplayTagLength(length); err != nil {
+		return nil, err
+	}
+	packet, err := codec.Unmarshal(packetBytes)
+	if err != nil {
+		return nil, ErrMalformedPacket
+	}
+	if len(packet.Hdr.Alpha) != FieldElementSize {
+		return nil, ErrMalformedPacket
+	}
+
+	sharedSecret := new(FieldElement)
+	curve25519.ScalarMult(sharedSecret.el(), privKey.ToFieldElement().el(), BytesToFieldElement(packet.Hdr.Alpha).el())
+
+	aesS, err := KDF(sharedSecret.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return replayTag(aesS, length)
+}
+
+// replayTag derives the replay tag of the given length from the hash of the secret shared with the sender.
+func replayTag(aesS []byte, length int) ([]byte, error) {
+	// the domain separation ensures the tag reveals nothing about the keys derived from the same secret
+	tag, err := hash(append([]byte(replayTagDomain), aesS...))
+	if err != nil {
+		return nil, err
+	}
+	return tag[:length], nil
+}
+
+// Params returns the parameters of the sphinx packets of this implementation, which the nodes advertise in the PKI,
+// so that the clients could tell whether the nodes can process their packets.
+func Params() *config.SphinxParams {
+	return &config.SphinxParams{K: K, MaxHops: MaxHops}
+}
+
+// PayloadSize returns the length (in bytes) of the longest message a single packet can carry,
+// i.e. MaxPayloadLength less the tag binding the payload to the header. Any splitting or padding
+// of the messages into packets has to be done with respect to it.
+func PayloadSize() int {
+	return MaxPayloadLength - payloadTagLength
+}
+
+// ParamsCompatible checks whether a node advertising the given parameters can process the packets
+// of this implementation traversing the given number of hops. The nodes which do not advertise
+// any parameters are assumed to use the ones of this implementation.
+func ParamsCompatible(params *config.SphinxParams, hops int) bool {
+	if params == nil {
+		params = Params()
+	}
+	return params.K == K && hops <= int(params.MaxHops)
+}
@@ -68,9 +68,11 @@ func (m *SphinxPacket) GetPld() []byte {
 }
 
 type Header struct {
-	Alpha                []byte   `protobuf:"bytes,1,opt,name=Alpha,json=alpha,proto3" json:"Alpha,omitempty"`
-	Beta                 []byte   `protobuf:"bytes,2,opt,name=Beta,json=beta,proto3" json:"Beta,omitempty"`
-	Mac                  []byte   `protobuf:"bytes,3,opt,name=Mac,json=mac,proto3" json:"Mac,omitempty"`
+	Alpha []byte `protobuf:"bytes,1,opt,name=Alpha,json=alpha,proto3" json:"Alpha,omitempty"`
+	Beta  []byte `protobuf:"bytes,2,opt,name=Beta,json=beta,proto3" json:"Beta,omitempty"`
+	Mac   []byte `protobuf:"bytes,3,opt,name=Mac,json=mac,proto3" json:"Mac,omitempty"`
+	// Suite identifies the crypto suite the packet is encrypted and authenticated with. 0 is the AES-CTR suite.
+	Suite                uint32   `protobuf:"varint,4,opt,name=Suite,json=suite,proto3" json:"Suite,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -122,6 +124,13 @@ func (m *Header) GetMac() []byte {
 	return nil
 }
 
+func (m *Header) GetSuite() uint32 {
+	if m != nil {
+		return m.Suite
+	}
+	return 0
+}
+
 type Hop struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
 	Address              string   `protobuf:"bytes,2,opt,name=Address,json=address,proto3" json:"Address,omitempty"`
@@ -241,8 +250,12 @@ func (m *RoutingInfo) GetMac() []byte {
 }
 
 type Commands struct {
-	Delay                float64  `protobuf:"fixed64,1,opt,name=Delay,json=delay,proto3" json:"Delay,omitempty"`
-	Flag                 []byte   `protobuf:"bytes,2,opt,name=Flag,json=flag,proto3" json:"Flag,omitempty"`
+	Delay float64 `protobuf:"fixed64,1,opt,name=Delay,json=delay,proto3" json:"Delay,omitempty"`
+	Flag  []byte  `protobuf:"bytes,2,opt,name=Flag,json=flag,proto3" json:"Flag,omitempty"`
+	// Expiry is the unix time (in seconds) after which the packet should be dropped. 0 means no expiry.
+	Expiry int64 `protobuf:"varint,3,opt,name=Expiry,json=expiry,proto3" json:"Expiry,omitempty"`
+	// AuxData is the optional data the sender wants to pass to this particular hop, e.g. a cover traffic hint.
+	AuxData              []byte   `protobuf:"bytes,4,opt,name=AuxData,json=auxData,proto3" json:"AuxData,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -287,6 +300,20 @@ func (m *Commands) GetFlag() []byte {
 	return nil
 }
 
+func (m *Commands) GetExpiry() int64 {
+	if m != nil {
+		return m.Expiry
+	}
+	return 0
+}
+
+func (m *Commands) GetAuxData() []byte {
+	if m != nil {
+		return m.AuxData
+	}
+	return nil
+}
+
 type HeaderInitials struct {
 	Alpha                []byte   `protobuf:"bytes,1,opt,name=Alpha,json=alpha,proto3" json:"Alpha,omitempty"`
 	Secret               []byte   `protobuf:"bytes,2,opt,name=Secret,json=secret,proto3" json:"Secret,omitempty"`
@@ -362,29 +389,31 @@ func init() {
 func init() { proto.RegisterFile("sphinx/sphinx_structs.proto", fileDescriptor_278563119aefb899) }
 
 var fileDescriptor_278563119aefb899 = []byte{
-	// 378 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0xd1, 0x6a, 0xdb, 0x30,
-	0x14, 0xc5, 0x71, 0x62, 0xaf, 0x37, 0x21, 0x29, 0xa2, 0x14, 0xc3, 0x60, 0x04, 0xc3, 0x20, 0x4f,
-	0x19, 0x74, 0x7b, 0xda, 0x5b, 0xb3, 0xb0, 0x39, 0x8c, 0x8e, 0xa0, 0x7e, 0xc0, 0xb8, 0xb6, 0xd4,
-	0xd8, 0x4c, 0x91, 0x84, 0xa4, 0x40, 0xfa, 0x4f, 0xfb, 0xc8, 0x61, 0x49, 0xce, 0xc8, 0x43, 0x9f,
-	0xec, 0x73, 0x7d, 0xcf, 0xb9, 0xe7, 0x1c, 0x0c, 0xef, 0xad, 0x6e, 0x3b, 0x79, 0xfe, 0x14, 0x1e,
-	0xbf, 0xad, 0x33, 0xa7, 0xc6, 0xd9, 0xb5, 0x36, 0xca, 0x29, 0x92, 0x85, 0x69, 0xb9, 0x81, 0xd9,
-	0xb3, 0x7f, 0xdb, 0x63, 0xf3, 0x87, 0x3b, 0xb2, 0x84, 0xb4, 0x62, 0xa6, 0x48, 0x96, 0xc9, 0x6a,
-	0xfa, 0x30, 0x5f, 0x87, 0xad, 0x75, 0xc5, 0x91, 0x71, 0x43, 0xd3, 0x96, 0x19, 0x72, 0x0b, 0xe9,
-	0x5e, 0xb0, 0x62, 0xb4, 0x4c, 0x56, 0x33, 0x9a, 0x6a, 0xc1, 0xca, 0x2d, 0x64, 0x61, 0x81, 0xdc,
-	0xc1, 0xe4, 0x51, 0xe8, 0x16, 0x3d, 0x7f, 0x46, 0x27, 0xd8, 0x03, 0x42, 0x60, 0xbc, 0xe1, 0x0e,
-	0x23, 0x65, 0x5c, 0x73, 0x87, 0xbd, 0xca, 0x13, 0x36, 0x45, 0x1a, 0x54, 0x8e, 0xd8, 0x94, 0x3f,
-	0x20, 0xad, 0x94, 0x26, 0x73, 0x18, 0xed, 0x98, 0xe7, 0xdf, 0xd0, 0x51, 0xc7, 0x48, 0x01, 0xf9,
-	0x23, 0x63, 0x86, 0x5b, 0xeb, 0xf9, 0x37, 0x34, 0xc7, 0x00, 0xc9, 0x3d, 0x64, 0xfb, 0x53, 0xfd,
-	0x93, 0xbf, 0x46, 0x95, 0x4c, 0x7b, 0x54, 0xfe, 0x4d, 0x60, 0x4a, 0xd5, 0xc9, 0x75, 0xf2, 0xb0,
-	0x93, 0x2f, 0x8a, 0x7c, 0x84, 0xfc, 0x17, 0x3f, 0xbb, 0x4a, 0xe9, 0x18, 0x6b, 0x7a, 0x89, 0xa5,
-	0x34, 0xcd, 0x65, 0xf8, 0x46, 0xbe, 0xc2, 0x22, 0xb2, 0xbe, 0xa9, 0xe3, 0x11, 0x25, 0x0b, 0x07,
-	0xa7, 0x0f, 0xb7, 0xc3, 0xfa, 0x30, 0xa7, 0x0b, 0x73, 0xbd, 0x48, 0x56, 0xb0, 0x88, 0x27, 0x9e,
-	0xb8, 0xc3, 0x2d, 0x3a, 0x8c, 0x9e, 0x16, 0xf2, 0x7a, 0x3c, 0xe4, 0x1e, 0xff, 0xcf, 0xfd, 0x05,
-	0xde, 0x5d, 0x74, 0xee, 0x60, 0xb2, 0xe5, 0x02, 0x5f, 0xbd, 0xd1, 0x84, 0x4e, 0x58, 0x0f, 0xfa,
-	0xfe, 0xbe, 0x0b, 0x3c, 0x0c, 0xfd, 0xbd, 0x08, 0x3c, 0x94, 0x67, 0x98, 0x87, 0xce, 0x77, 0xb2,
-	0x73, 0x1d, 0x0a, 0xfb, 0x46, 0xf7, 0xf7, 0x90, 0x3d, 0xf3, 0xc6, 0x70, 0x17, 0xd9, 0x99, 0xf5,
-	0xa8, 0xaf, 0x75, 0x23, 0x3a, 0xc9, 0xb8, 0x89, 0x4e, 0xf3, 0x3a, 0x40, 0xf2, 0x01, 0x20, 0x30,
-	0x2a, 0xb4, 0x6d, 0x34, 0x0a, 0xf6, 0x32, 0xa9, 0x33, 0xff, 0x03, 0x7d, 0xfe, 0x17, 0x00, 0x00,
-	0xff, 0xff, 0x89, 0xde, 0xc9, 0x39, 0x5f, 0x02, 0x00, 0x00,
+	// 412 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0x4d, 0x8b, 0xdb, 0x30,
+	0x14, 0xc4, 0x71, 0x6c, 0x77, 0x5f, 0xd2, 0x64, 0x11, 0xcb, 0x62, 0x28, 0x94, 0x60, 0x28, 0xe4,
+	0x94, 0xc2, 0xf6, 0xd6, 0xdb, 0xa6, 0xdb, 0xd6, 0xa1, 0x6c, 0x09, 0xca, 0xad, 0x97, 0xf2, 0x62,
+	0x29, 0x89, 0xa9, 0x23, 0x09, 0x49, 0x06, 0xef, 0x7f, 0xea, 0x8f, 0x2c, 0xfa, 0x48, 0x60, 0x0f,
+	0x3d, 0xd9, 0xf3, 0xfc, 0x66, 0x46, 0x33, 0x32, 0xbc, 0x33, 0xea, 0xd4, 0x8a, 0xe1, 0x63, 0x78,
+	0xfc, 0x36, 0x56, 0xf7, 0x8d, 0x35, 0x2b, 0xa5, 0xa5, 0x95, 0x24, 0x0f, 0xd3, 0x6a, 0x0d, 0xd3,
+	0x9d, 0x7f, 0xdb, 0x62, 0xf3, 0x87, 0x5b, 0xb2, 0x80, 0xb4, 0x66, 0xba, 0x4c, 0x16, 0xc9, 0x72,
+	0xf2, 0x30, 0x5b, 0x85, 0xad, 0x55, 0xcd, 0x91, 0x71, 0x4d, 0xd3, 0x13, 0xd3, 0xe4, 0x16, 0xd2,
+	0x6d, 0xc7, 0xca, 0xd1, 0x22, 0x59, 0x4e, 0x69, 0xaa, 0x3a, 0x56, 0xfd, 0x82, 0x3c, 0x2c, 0x90,
+	0x3b, 0xc8, 0x1e, 0x3b, 0x75, 0x42, 0xcf, 0x9f, 0xd2, 0x0c, 0x1d, 0x20, 0x04, 0xc6, 0x6b, 0x6e,
+	0x31, 0x52, 0xc6, 0x7b, 0x6e, 0xd1, 0xa9, 0x3c, 0x63, 0x53, 0xa6, 0x41, 0xe5, 0x8c, 0x8d, 0xe3,
+	0xee, 0xfa, 0xd6, 0xf2, 0x72, 0xbc, 0x48, 0x96, 0x6f, 0x69, 0x66, 0x1c, 0xa8, 0xbe, 0x43, 0x5a,
+	0x4b, 0x45, 0x66, 0x30, 0xda, 0x30, 0xaf, 0x7a, 0x43, 0x47, 0x2d, 0x23, 0x25, 0x14, 0x8f, 0x8c,
+	0x69, 0x6e, 0x8c, 0x57, 0xbd, 0xa1, 0x05, 0x06, 0x48, 0xee, 0x21, 0xdf, 0xf6, 0xfb, 0x1f, 0xfc,
+	0x25, 0x6a, 0xe7, 0xca, 0xa3, 0xea, 0x6f, 0x02, 0x13, 0x2a, 0x7b, 0xdb, 0x8a, 0xe3, 0x46, 0x1c,
+	0x24, 0xf9, 0x00, 0xc5, 0x4f, 0x3e, 0xd8, 0x5a, 0xaa, 0x18, 0x76, 0x72, 0x0d, 0x2b, 0x15, 0x2d,
+	0x44, 0xf8, 0x46, 0x3e, 0xc3, 0x3c, 0xb2, 0xbe, 0xc8, 0xf3, 0x19, 0x05, 0x0b, 0x86, 0x93, 0x87,
+	0xdb, 0xcb, 0xfa, 0x65, 0x4e, 0xe7, 0xfa, 0xf5, 0x22, 0x59, 0xc2, 0x3c, 0x5a, 0x3c, 0x73, 0x8b,
+	0x4f, 0x68, 0x31, 0x9e, 0x69, 0x2e, 0x5e, 0x8f, 0x2f, 0x6d, 0x8c, 0xaf, 0x6d, 0x54, 0x07, 0x78,
+	0x73, 0xd5, 0xb9, 0x83, 0xec, 0x89, 0x77, 0xf8, 0xe2, 0x0f, 0x9a, 0xd0, 0x8c, 0x39, 0xe0, 0x5a,
+	0xfd, 0xd6, 0xe1, 0xf1, 0xd2, 0xea, 0xa1, 0xc3, 0xa3, 0x0b, 0xff, 0x75, 0x50, 0xad, 0x0e, 0xe1,
+	0x53, 0x9a, 0x73, 0x8f, 0x7c, 0x5d, 0xfd, 0xe0, 0x4f, 0x10, 0x3c, 0x0a, 0x0c, 0xb0, 0x1a, 0x60,
+	0x16, 0xee, 0x6e, 0x23, 0x5a, 0xdb, 0x62, 0x67, 0xfe, 0x73, 0x87, 0xf7, 0x90, 0xef, 0x78, 0xa3,
+	0xb9, 0x8d, 0x7e, 0xb9, 0xf1, 0xc8, 0x29, 0xaf, 0xbb, 0x56, 0x30, 0xae, 0x63, 0xb6, 0x62, 0x1f,
+	0x20, 0x79, 0x0f, 0x10, 0x18, 0x35, 0x9a, 0x53, 0xb4, 0x05, 0x73, 0x9d, 0xec, 0x73, 0xff, 0x23,
+	0x7e, 0xfa, 0x37, 0x00, 0xac, 0x55, 0x53, 0xbb, 0xa7, 0x02, 0x00, 0x00,
 }
@@ -15,13 +15,17 @@
 package sphinx
 
 import (
+	"bytes"
 	"crypto/aes"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/nymtech/nym-mixnet/config"
+	"github.com/nymtech/nym-mixnet/flags"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/curve25519"
 )
@@ -106,6 +110,10 @@ func TestComputeBlindingFactor(t *testing.T) {
 	assert.Equal(t, expected, b.bytes)
 }
 
+func TestPublicKeySize_MatchesConfig(t *testing.T) {
+	assert.Equal(t, config.PublicKeySize, PublicKeySize)
+}
+
 func TestGetSharedSecrets(t *testing.T) {
 	_, pub1, err := GenerateKeyPair()
 	assert.Nil(t, err)
@@ -424,7 +432,8 @@ func TestProcessSphinxHeader(t *testing.T) {
 
 func TestProcessSphinxPayload(t *testing.T) {
 
-	message := []byte("Plaintext message")
+	// every payload carries the tag binding it to the header
+	message := append(make([]byte, payloadTagLength), "Plaintext message"...)
 
 	priv1, pub1, err := GenerateKeyPair()
 	assert.Nil(t, err)
@@ -446,7 +455,7 @@ func TestProcessSphinxPayload(t *testing.T) {
 	headerInitials, err := getSharedSecrets(nodes, x)
 	assert.Nil(t, err)
 
-	encMsg, err := encapsulateContent(headerInitials, message)
+	encMsg, err := encapsulateContent(aesCtrSuite{}, headerInitials, message)
 	assert.Nil(t, err)
 
 	decMsg := encMsg
@@ -459,3 +468,650 @@ func TestProcessSphinxPayload(t *testing.T) {
 	}
 	assert.Equal(t, []byte(message), decMsg)
 }
+
+func createTestPath(t *testing.T) (config.E2EPath, *PrivateKey) {
+	priv1, pub1, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	_, pub2, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	_, pub3, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	path := config.E2EPath{
+		IngressProvider: config.NewMixConfig("Provider1", "localhost", "3331", pub1.Bytes(), config.ProviderLayer),
+		Mixes:           []config.MixConfig{config.NewMixConfig("Node1", "localhost", "3332", pub2.Bytes(), 1)},
+		EgressProvider:  config.NewMixConfig("Provider2", "localhost", "3333", pub3.Bytes(), config.ProviderLayer),
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	return path, priv1
+}
+
+func TestAesCtrWithIV(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, K)
+	iv := bytes.Repeat([]byte{2}, aes.BlockSize)
+	plaintext := []byte("Plaintext message")
+
+	ciphertext, err := AesCtrWithIV(key, iv, plaintext)
+	assert.Nil(t, err)
+	decrypted, err := AesCtrWithIV(key, iv, ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	_, err = AesCtrWithIV(key, iv[1:], plaintext)
+	assert.Equal(t, ErrInvalidIV, err)
+}
+
+func TestEncapsulateContent_DistinctKeystreams(t *testing.T) {
+	path, _ := createTestPath(t)
+	nodes := append([]config.MixConfig{path.IngressProvider}, path.Mixes...)
+	nodes = append(nodes, path.EgressProvider)
+	zeros := make([]byte, 256)
+
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	headerInitials, err := getSharedSecrets(nodes, x)
+	assert.Nil(t, err)
+
+	// the payload and the routing information processed by the same hop are encrypted with the same key,
+	// but with distinct keystreams, so that xoring the two would not reveal anything
+	key, err := KDF(headerInitials[0].SecretHash)
+	assert.Nil(t, err)
+	routingKeystream, err := AesCtr(key, zeros)
+	assert.Nil(t, err)
+	payloadKeystream, err := aesCtrInDomain(key, payloadIVDomain, zeros)
+	assert.Nil(t, err)
+	assert.NotEqual(t, routingKeystream, payloadKeystream)
+
+	// the distinct packets use distinct keystreams, even for the same message along the same path
+	otherX, err := RandomElement()
+	assert.Nil(t, err)
+	otherHeaderInitials, err := getSharedSecrets(nodes, otherX)
+	assert.Nil(t, err)
+	payload, err := encapsulateContent(aesCtrSuite{}, headerInitials, zeros)
+	assert.Nil(t, err)
+	otherPayload, err := encapsulateContent(aesCtrSuite{}, otherHeaderInitials, zeros)
+	assert.Nil(t, err)
+	assert.NotEqual(t, payload, otherPayload)
+}
+
+func TestPackForwardMessage_NegativeDelay(t *testing.T) {
+	path, _ := createTestPath(t)
+	_, err := PackForwardMessage(path, []float64{0.1, -0.2, 0.3}, []byte("Hello world"))
+	assert.Error(t, err)
+}
+
+func TestPackForwardMessage_MisalignedDelays(t *testing.T) {
+	path, _ := createTestPath(t)
+	for _, delays := range [][]float64{nil, {0.1, 0.2}} {
+		_, err := PackForwardMessage(path, delays, []byte("Hello world"))
+		assert.True(t, errors.Is(err, ErrMisalignedLayers), "%v delays should have been rejected", len(delays))
+		assert.Contains(t, err.Error(), fmt.Sprintf("got %v delays for 3 hops", len(delays)))
+	}
+	// the delay of the recipient, which is counted in the length of the path, is ignored
+	_, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3, 0.4}, []byte("Hello world"))
+	assert.Nil(t, err)
+}
+
+func TestEncapsulateHeader_MisalignedLayers(t *testing.T) {
+	path, _ := createTestPath(t)
+	nodes := append([]config.MixConfig{path.IngressProvider}, path.Mixes...)
+	nodes = append(nodes, path.EgressProvider)
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	sharedSecrets, err := getSharedSecrets(nodes, x)
+	assert.Nil(t, err)
+	commands := []Commands{{Delay: 0.1}, {Delay: 0.2}, {Delay: 0.3}}
+	destination := config.ClientConfig{Id: "DestinationId"}
+
+	_, err = encapsulateHeader(sharedSecrets, nodes, commands, destination)
+	assert.Nil(t, err)
+
+	// each mismatch is reported rather than causing a panic or a corrupt header
+	_, err = encapsulateHeader(sharedSecrets, nodes, commands[:2], destination)
+	assert.True(t, errors.Is(err, ErrMisalignedLayers))
+	assert.Contains(t, err.Error(), "got 2 commands for 3 hops")
+
+	_, err = encapsulateHeader(sharedSecrets, nodes, append(commands, Commands{}), destination)
+	assert.True(t, errors.Is(err, ErrMisalignedLayers))
+	assert.Contains(t, err.Error(), "got 4 commands for 3 hops")
+
+	_, err = encapsulateHeader(sharedSecrets[1:], nodes, commands, destination)
+	assert.True(t, errors.Is(err, ErrMisalignedLayers))
+	assert.Contains(t, err.Error(), "got 2 shared secrets for 3 hops")
+
+	_, err = encapsulateHeader(nil, nil, nil, destination)
+	assert.True(t, errors.Is(err, ErrMisalignedLayers))
+}
+
+func TestPackForwardMessage_ClampedDelay(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	maxDelay := 2.0
+	packet, err := PackForwardMessageWithMaxDelay(path, []float64{1000.0, 0.2, 0.3}, []byte("Hello world"), maxDelay)
+	assert.Nil(t, err)
+
+	_, commands, _, err := ProcessSphinxHeader(*packet.Hdr, priv1)
+	assert.Nil(t, err)
+	assert.Equal(t, maxDelay, commands.Delay)
+}
+
+func TestProcessSphinxPacket_MissingHeader(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	packetBytes, err := proto.Marshal(&SphinxPacket{Pld: []byte("foomp")})
+	assert.Nil(t, err)
+
+	_, _, _, err = ProcessSphinxPacket(packetBytes, priv)
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestProcessSphinxPacket_Garbage(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	_, _, _, err = ProcessSphinxPacket([]byte{0xff, 0xff, 0xff, 0xff}, priv)
+	assert.Error(t, err)
+}
+
+func TestProcessSphinxHeader_InvalidAlpha(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	for _, alpha := range [][]byte{nil, make([]byte, FieldElementSize-1), make([]byte, FieldElementSize+1)} {
+		_, _, _, err = ProcessSphinxHeader(Header{Alpha: alpha, Beta: []byte("foo"), Mac: []byte("bar")}, priv)
+		assert.Equal(t, ErrMalformedPacket, err)
+	}
+}
+
+func TestProcessSphinxHeader_MissingRoutingInfo(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	// create a header with valid MAC, but with the routing information lacking the next hop and commands
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	alpha := expoGroupBase([]*FieldElement{x})
+	sharedSecret := expo(pub.ToFieldElement(), []*FieldElement{x})
+	aesS, err := KDF(sharedSecret.Bytes())
+	assert.Nil(t, err)
+	encKey, err := KDF(aesS)
+	assert.Nil(t, err)
+
+	routingInfoBytes, err := proto.Marshal(&RoutingInfo{NextHopMetaData: []byte("foomp")})
+	assert.Nil(t, err)
+	beta, err := AesCtr(encKey, routingInfoBytes)
+	assert.Nil(t, err)
+	mac, err := computeMac(encKey, beta)
+	assert.Nil(t, err)
+
+	_, _, _, err = ProcessSphinxHeader(Header{Alpha: alpha.Bytes(), Beta: beta, Mac: mac}, priv)
+	assert.Equal(t, ErrMalformedPacket, err)
+
+	// the routing information is authenticated, so the verification alone can't tell
+	assert.Nil(t, VerifySphinxHeader(Header{Alpha: alpha.Bytes(), Beta: beta, Mac: mac}, priv))
+}
+
+func TestVerifySphinxHeader(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	header := *packet.Hdr
+	original := proto.Clone(&header)
+
+	// the verdict always matches the one of ProcessSphinxHeader
+	verdictsMatch := func(header Header, priv *PrivateKey) error {
+		err := VerifySphinxHeader(header, priv)
+		_, _, _, processErr := ProcessSphinxHeader(header, priv)
+		assert.Equal(t, processErr, err)
+		return err
+	}
+
+	assert.Nil(t, verdictsMatch(header, priv1))
+	assert.True(t, proto.Equal(original, &header), "Verification should not have modified the header")
+
+	tamperedBeta := header
+	tamperedBeta.Beta = append([]byte(nil), header.Beta...)
+	tamperedBeta.Beta[0] ^= 0xff
+	assert.Equal(t, ErrInvalidMAC, verdictsMatch(tamperedBeta, priv1))
+
+	tamperedMac := header
+	tamperedMac.Mac = append([]byte(nil), header.Mac...)
+	tamperedMac.Mac[0] ^= 0xff
+	assert.Equal(t, ErrInvalidMAC, verdictsMatch(tamperedMac, priv1))
+
+	otherPriv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidMAC, verdictsMatch(header, otherPriv))
+
+	assert.Equal(t, ErrMalformedPacket, verdictsMatch(Header{Alpha: header.Alpha[1:], Beta: header.Beta, Mac: header.Mac}, priv1))
+}
+
+func TestPackForwardMessage_TooManyHops(t *testing.T) {
+	path, _ := createTestPath(t)
+	for len(path.Mixes)+2 <= MaxHops {
+		path.Mixes = append(path.Mixes, path.Mixes[0])
+	}
+	delays := make([]float64, len(path.Mixes)+2)
+	_, err := PackForwardMessage(path, delays, []byte("Hello world"))
+	assert.Equal(t, ErrTooManyHops, err)
+}
+
+func TestPackForwardMessage_Expiry(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	expiry := time.Now().Add(time.Hour)
+	packet, err := PackForwardMessageWithExpiry(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"), DefaultMaxDelay, expiry)
+	assert.Nil(t, err)
+
+	_, commands, _, err := ProcessSphinxHeader(*packet.Hdr, priv1)
+	assert.Nil(t, err)
+	assert.Zero(t, commands.Expiry%int64(ExpiryEpoch/time.Second))
+	assert.True(t, commands.Expiry >= expiry.Unix())
+	assert.True(t, commands.Expiry < expiry.Add(ExpiryEpoch).Unix())
+
+	// and by default the packets do not expire
+	packet, err = PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	_, commands, _, err = ProcessSphinxHeader(*packet.Hdr, priv1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), commands.Expiry)
+}
+
+func TestPackForwardMessage_AuxData(t *testing.T) {
+	var privs []*PrivateKey
+	var nodes []config.MixConfig
+	for i := 0; i < 4; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		nodes = append(nodes, config.NewMixConfig(fmt.Sprintf("Node%v", i), "localhost", "3330", pub.Bytes(), 1))
+	}
+	path := config.E2EPath{
+		IngressProvider: nodes[0],
+		Mixes:           nodes[1:3],
+		EgressProvider:  nodes[3],
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	delays := []float64{0.1, 0.2, 0.3, 0.4}
+	auxData := [][]byte{[]byte("first"), nil, bytes.Repeat([]byte{0x42}, MaxAuxDataLength), []byte("last")}
+
+	packet, err := PackForwardMessageWithAuxData(path, delays, []byte("Hello world"), DefaultMaxDelay, time.Time{}, auxData)
+	assert.Nil(t, err)
+
+	// each hop reads its own data, and only its own, as the data of the following hops remains encrypted
+	header := *packet.Hdr
+	for i, priv := range privs {
+		for j := i + 1; j < len(auxData); j++ {
+			if len(auxData[j]) > 0 {
+				assert.False(t, bytes.Contains(header.Beta, auxData[j]), "Hop %v can read the data of hop %v", i, j)
+			}
+		}
+		var commands Commands
+		_, commands, header, err = ProcessSphinxHeader(header, priv)
+		assert.Nil(t, err)
+		assert.Equal(t, string(auxData[i]), string(commands.AuxData))
+	}
+
+	// by default there is no auxiliary data at all
+	packet, err = PackForwardMessage(path, delays, []byte("Hello world"))
+	assert.Nil(t, err)
+	_, commands, _, err := ProcessSphinxHeader(*packet.Hdr, privs[0])
+	assert.Nil(t, err)
+	assert.Empty(t, commands.AuxData)
+
+	invalid := [][][]byte{
+		{[]byte("too"), []byte("few")},
+		{nil, nil, nil, nil, nil},
+		{nil, make([]byte, MaxAuxDataLength+1), nil, nil},
+	}
+	for _, auxData := range invalid {
+		_, err = PackForwardMessageWithAuxData(path, delays, []byte("Hello world"), DefaultMaxDelay, time.Time{}, auxData)
+		assert.Equal(t, ErrInvalidAuxData, err)
+	}
+}
+
+func TestPacker(t *testing.T) {
+	var privs []*PrivateKey
+	var nodes []config.MixConfig
+	for i := 0; i < 3; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		nodes = append(nodes, config.NewMixConfig(fmt.Sprintf("Node%v", i), "localhost", "3330", pub.Bytes(), 1))
+	}
+	path := config.E2EPath{
+		IngressProvider: nodes[0],
+		Mixes:           nodes[1:2],
+		EgressProvider:  nodes[2],
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	packer, err := NewPacker(path, DefaultMaxDelay, time.Time{}, nil)
+	assert.Nil(t, err)
+
+	// the path is only validated once, so the packer is not affected by any later changes to it
+	path.Mixes[0].Host = "not a valid host"
+	_, err = NewPacker(path, DefaultMaxDelay, time.Time{}, nil)
+	assert.NotNil(t, err)
+
+	alphas := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		message := []byte(fmt.Sprintf("Hello world %v", i))
+		packet, err := packer.Pack([]float64{0.1, 0.2, 0.3}, message)
+		assert.Nil(t, err)
+		alphas[string(packet.Hdr.Alpha)] = struct{}{}
+
+		packetBytes, err := proto.Marshal(&packet)
+		assert.Nil(t, err)
+		for _, priv := range privs {
+			_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, priv)
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, message, packetBytes)
+	}
+	// each packet has its own initial secret element
+	assert.Len(t, alphas, 100)
+
+	_, err = packer.Pack([]float64{0.1, 0.2}, []byte("Hello world"))
+	assert.NotNil(t, err)
+	_, err = packer.Pack([]float64{0.1, -0.2, 0.3}, []byte("Hello world"))
+	assert.NotNil(t, err)
+}
+
+func TestNewPacker_Invalid(t *testing.T) {
+	path, _ := createTestPath(t)
+	_, err := NewPacker(path, DefaultMaxDelay, time.Time{}, [][]byte{nil})
+	assert.Equal(t, ErrInvalidAuxData, err)
+
+	for len(path.Mixes)+2 <= MaxHops {
+		path.Mixes = append(path.Mixes, path.Mixes[0])
+	}
+	_, err = NewPacker(path, DefaultMaxDelay, time.Time{}, nil)
+	assert.Equal(t, ErrTooManyHops, err)
+}
+
+func TestCommands_Expired(t *testing.T) {
+	now := time.Now()
+	expiry := now.Add(-time.Minute)
+	commands := Commands{Expiry: expiry.Unix()}
+
+	assert.True(t, commands.Expired(now, 0))
+	assert.True(t, commands.Expired(now, 30*time.Second))
+	assert.False(t, commands.Expired(now, 2*time.Minute))
+	assert.False(t, commands.Expired(expiry.Add(-time.Second), 0))
+
+	// commands without expiry never expire
+	assert.False(t, (&Commands{}).Expired(now.Add(100*365*24*time.Hour), 0))
+}
+
+func TestComputeReplayTag(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	createPacket := func() []byte {
+		packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+		assert.Nil(t, err)
+		packetBytes, err := proto.Marshal(&packet)
+		assert.Nil(t, err)
+		return packetBytes
+	}
+
+	// the tag of the same packet is always the same, but distinct packets never collide
+	packet := createPacket()
+	tag, err := ComputeReplayTag(packet, priv1, DefaultReplayTagLength)
+	assert.Nil(t, err)
+	assert.Len(t, tag, DefaultReplayTagLength)
+	tagAgain, err := ComputeReplayTag(packet, priv1, DefaultReplayTagLength)
+	assert.Nil(t, err)
+	assert.Equal(t, tag, tagAgain)
+
+	tags := map[string]struct{}{string(tag): {}}
+	for i := 0; i < 1000; i++ {
+		tag, err := ComputeReplayTag(createPacket(), priv1, DefaultReplayTagLength)
+		assert.Nil(t, err)
+		tags[string(tag)] = struct{}{}
+	}
+	assert.Len(t, tags, 1001)
+
+	// the configured length is honoured and shorter tags are prefixes of the longer ones
+	for _, length := range []int{MinReplayTagLength, 24, MaxReplayTagLength} {
+		tagOfLength, err := ComputeReplayTag(packet, priv1, length)
+		assert.Nil(t, err)
+		assert.Len(t, tagOfLength, length)
+		assert.Equal(t, tagOfLength[:MinReplayTagLength], tag[:MinReplayTagLength])
+	}
+}
+
+func TestComputeReplayTag_Invalid(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+
+	for _, length := range []int{-1, 0, MinReplayTagLength - 1, MaxReplayTagLength + 1} {
+		_, err := ComputeReplayTag(packetBytes, priv1, length)
+		assert.Equal(t, ErrInvalidReplayTagLength, err)
+		assert.Equal(t, ErrInvalidReplayTagLength, ValidateReplayTagLength(length))
+	}
+
+	_, err = ComputeReplayTag([]byte("foomp"), priv1, DefaultReplayTagLength)
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestProcessSphinxPacketWithReplayTag(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+
+	// the packet is processed exactly as without the tag, which is the same as the one computed separately
+	hop, commands, newPacket, tag, err := ProcessSphinxPacketWithReplayTag(packetBytes, priv1, ProtobufCodec, DefaultReplayTagLength)
+	assert.Nil(t, err)
+	expectedHop, expectedCommands, expectedPacket, err := ProcessSphinxPacket(packetBytes, priv1)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedHop, hop)
+	assert.Equal(t, expectedCommands, commands)
+	assert.Equal(t, len(expectedPacket), len(newPacket))
+	expectedTag, err := ComputeReplayTag(packetBytes, priv1, DefaultReplayTagLength)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedTag, tag)
+
+	_, _, _, _, err = ProcessSphinxPacketWithReplayTag(packetBytes, priv1, ProtobufCodec, MinReplayTagLength-1)
+	assert.Equal(t, ErrInvalidReplayTagLength, err)
+	_, _, _, _, err = ProcessSphinxPacketWithReplayTag([]byte("foomp"), priv1, ProtobufCodec, DefaultReplayTagLength)
+	assert.Equal(t, ErrMalformedPacket, err)
+}
+
+func TestProcessSphinxPacket_PayloadBinding(t *testing.T) {
+	var privs []*PrivateKey
+	var nodes []config.MixConfig
+	for i := 0; i < 3; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		nodes = append(nodes, config.NewMixConfig(fmt.Sprintf("Node%v", i), "localhost", "3330", pub.Bytes(), 1))
+	}
+	path := config.E2EPath{
+		IngressProvider: nodes[0],
+		Mixes:           nodes[1:2],
+		EgressProvider:  nodes[2],
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	delays := []float64{0.1, 0.2, 0.3}
+
+	// processes the packet through all the hops, returning the error of the first one failing
+	processPath := func(packet SphinxPacket) ([]byte, error) {
+		packetBytes, err := proto.Marshal(&packet)
+		assert.Nil(t, err)
+		for _, priv := range privs {
+			_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, priv)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return packetBytes, nil
+	}
+
+	packet1, err := PackForwardMessage(path, delays, []byte("Hello world"))
+	assert.Nil(t, err)
+	packet2, err := PackForwardMessage(path, delays, []byte("Hello world"))
+	assert.Nil(t, err)
+
+	// the tag is removed once the payload is verified at the final hop
+	message, err := processPath(packet1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello world"), message)
+
+	// even an identical message can't be grafted onto a different header
+	packet1.Pld, packet2.Pld = packet2.Pld, packet1.Pld
+	_, err = processPath(packet1)
+	assert.Equal(t, ErrInvalidPayload, err)
+	_, err = processPath(packet2)
+	assert.Equal(t, ErrInvalidPayload, err)
+
+	packet3, err := PackForwardMessage(path, delays, []byte("Hello world"))
+	assert.Nil(t, err)
+	packet3.Pld = packet3.Pld[:payloadTagLength-1]
+	_, err = processPath(packet3)
+	assert.Equal(t, ErrInvalidPayloadLength, err)
+}
+
+func TestProcessSphinxPayload_Length(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	x, err := RandomElement()
+	assert.Nil(t, err)
+	alpha := x.Bytes()
+
+	for _, length := range []int{0, 1, payloadTagLength - 1, MaxPayloadLength + 1, 2 * MaxPayloadLength} {
+		_, err := ProcessSphinxPayload(alpha, make([]byte, length), priv)
+		assert.Equal(t, ErrInvalidPayloadLength, err, "Payload of length %v should have been rejected", length)
+	}
+	for _, length := range []int{payloadTagLength, payloadTagLength + 100, MaxPayloadLength} {
+		decrypted, err := ProcessSphinxPayload(alpha, make([]byte, length), priv)
+		assert.Nil(t, err, "Payload of length %v should have been accepted", length)
+		assert.Len(t, decrypted, length)
+	}
+}
+
+func TestPackForwardMessage_PayloadLength(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	delays := []float64{0.1, 0.2, 0.3}
+
+	_, err := PackForwardMessage(path, delays, make([]byte, PayloadSize()+1))
+	assert.Equal(t, ErrInvalidPayloadLength, err)
+
+	// the largest message fits exactly and the packets carrying it are processed normally
+	packet, err := PackForwardMessage(path, delays, make([]byte, PayloadSize()))
+	assert.Nil(t, err)
+	assert.Len(t, packet.Pld, MaxPayloadLength)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	_, _, _, err = ProcessSphinxPacket(packetBytes, priv1)
+	assert.Nil(t, err)
+
+	// while the oversized payloads are rejected before the header is even processed
+	packet.Pld = append(packet.Pld, 0)
+	packetBytes, err = proto.Marshal(&packet)
+	assert.Nil(t, err)
+	_, _, _, err = ProcessSphinxPacket(packetBytes, priv1)
+	assert.Equal(t, ErrInvalidPayloadLength, err)
+}
+
+func TestProcessSphinxPacket_LastHop(t *testing.T) {
+	var privs []*PrivateKey
+	var nodes []config.MixConfig
+	for i := 0; i < 3; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		nodes = append(nodes, config.NewMixConfig(fmt.Sprintf("Node%v", i), "localhost", "3330", pub.Bytes(), 1))
+	}
+	path := config.E2EPath{
+		IngressProvider: nodes[0],
+		Mixes:           nodes[1:2],
+		EgressProvider:  nodes[2],
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+
+	// the relays still get a complete packet to forward
+	for _, priv := range privs[:2] {
+		_, commands, newPacketBytes, err := ProcessSphinxPacket(packetBytes, priv)
+		assert.Nil(t, err)
+		assert.Equal(t, flags.RelayFlag, flags.SphinxFlagFromBytes(commands.Flag))
+
+		var newPacket SphinxPacket
+		assert.Nil(t, proto.Unmarshal(newPacketBytes, &newPacket))
+		assert.NotNil(t, newPacket.Hdr)
+		packetBytes = newPacketBytes
+	}
+
+	// while the last hop gets just the message
+	_, commands, message, err := ProcessSphinxPacket(packetBytes, privs[2])
+	assert.Nil(t, err)
+	assert.Equal(t, flags.LastHopFlag, flags.SphinxFlagFromBytes(commands.Flag))
+	assert.Equal(t, []byte("Hello world"), message)
+}
+
+func TestParamsCompatible(t *testing.T) {
+	assert.True(t, ParamsCompatible(Params(), MaxHops))
+	// the nodes not advertising their parameters use the defaults
+	assert.True(t, ParamsCompatible(nil, MaxHops))
+	assert.False(t, ParamsCompatible(nil, MaxHops+1))
+
+	assert.True(t, ParamsCompatible(&config.SphinxParams{K: K, MaxHops: 5}, 5))
+	assert.False(t, ParamsCompatible(&config.SphinxParams{K: K, MaxHops: 4}, 5))
+	assert.False(t, ParamsCompatible(&config.SphinxParams{K: 2 * K, MaxHops: MaxHops}, 5))
+}
+
+func TestJoinAddress(t *testing.T) {
+	for host, address := range map[string]string{
+		"localhost":       "localhost:1789",
+		"mix.nymtech.net": "mix.nymtech.net:1789",
+		"127.0.0.1":       "127.0.0.1:1789",
+		"::1":             "[::1]:1789",
+		"2001:db8::68":    "[2001:db8::68]:1789",
+	} {
+		joined, err := joinAddress(host, "1789")
+		assert.Nil(t, err)
+		assert.Equal(t, address, joined)
+	}
+
+	for _, host := range []string{"", "[::1]", "local host", "localhost:1789", "-mix.net", "mix..net", "1.2.3.4.5:"} {
+		_, err := joinAddress(host, "1789")
+		assert.Equal(t, ErrInvalidHost, err, "Host %q should have been rejected", host)
+	}
+	for _, port := range []string{"", "0", "-1", "65536", "http", "17 89", "0x10"} {
+		_, err := joinAddress("localhost", port)
+		assert.Equal(t, ErrInvalidPort, err, "Port %q should have been rejected", port)
+	}
+}
+
+func TestPackForwardMessage_Addresses(t *testing.T) {
+	path, priv1 := createTestPath(t)
+	path.Mixes[0].Host = "::1"
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	hop, _, _, err := ProcessSphinxHeader(*packet.Hdr, priv1)
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:3332", hop.Address)
+
+	// the address of the destination can be omitted, but not malformed
+	path.Recipient.Host, path.Recipient.Port = "", ""
+	_, err = PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	path.Recipient.Host, path.Recipient.Port = "localhost", "99999"
+	_, err = PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid address of destination "Recipient": invalid port`)
+	}
+
+	path, _ = createTestPath(t)
+	path.EgressProvider.Port = "provider"
+	_, err = PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid address of node "Provider2": invalid port`)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"errors"
+	"sync"
+)
+
+// SuiteID identifies a crypto suite. It is carried in the header of each packet, so that the nodes
+// could process the packets of any suite they support, and the network could migrate to new primitives
+// without all the nodes and clients having to switch at once.
+type SuiteID uint32
+
+// DefaultSuite is the suite encrypting the packets with AES-CTR and authenticating them with HMAC-SHA256.
+// It is the only suite registered by default.
+const DefaultSuite SuiteID = 0
+
+var (
+	// ErrUnsupportedSuite is returned when a packet uses a crypto suite which is not registered.
+	ErrUnsupportedSuite = errors.New("unsupported crypto suite")
+	// ErrSuiteRegistered is returned when registering a crypto suite under an id which is already taken.
+	ErrSuiteRegistered = errors.New("crypto suite already registered")
+)
+
+// CryptoSuite provides the symmetric primitives the packets are encrypted and authenticated with.
+// The keys are derived from the secrets shared between the sender and each hop, which are not affected by the suite.
+type CryptoSuite interface {
+	// Encrypt encrypts the data of the given domain with the given key. It has to be a stream cipher,
+	// i.e. decrypting is the same operation and the length of the data is preserved, and the keystreams
+	// of distinct domains, such as the routing information, whose domain is empty, and the payload,
+	// have to be independent, as they are encrypted with the same key.
+	Encrypt(key []byte, domain string, data []byte) ([]byte, error)
+	// MAC computes the message authentication code of the data with the given key. The codes have to be
+	// 32 bytes long, as the payload carries one of them in a field of a fixed length.
+	MAC(key, data []byte) ([]byte, error)
+}
+
+// aesCtrSuite is the DefaultSuite.
+type aesCtrSuite struct{}
+
+func (aesCtrSuite) Encrypt(key []byte, domain string, data []byte) ([]byte, error) {
+	if domain == "" {
+		return AesCtr(key, data)
+	}
+	return aesCtrInDomain(key, domain, data)
+}
+
+func (aesCtrSuite) MAC(key, data []byte) ([]byte, error) {
+	return Hmac(key, data)
+}
+
+// nolint: gochecknoglobals
+var (
+	suitesMu sync.RWMutex
+	suites   = map[SuiteID]CryptoSuite{DefaultSuite: aesCtrSuite{}}
+)
+
+// RegisterSuite registers the crypto suite under the given id, so that the packets using it could be created
+// and processed. All the nodes on the path of a packet have to register its suite under the same id.
+// It returns ErrSuiteRegistered if the id is already taken.
+func RegisterSuite(id SuiteID, suite CryptoSuite) error {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	if _, ok := suites[id]; ok {
+		return ErrSuiteRegistered
+	}
+	suites[id] = suite
+	return nil
+}
+
+// LookupSuite returns the crypto suite registered under the given id, or ErrUnsupportedSuite if there is none.
+func LookupSuite(id SuiteID) (CryptoSuite, error) {
+	suitesMu.RLock()
+	defer suitesMu.RUnlock()
+	suite, ok := suites[id]
+	if !ok {
+		return nil, ErrUnsupportedSuite
+	}
+	return suite, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSuite SuiteID = 1
+
+// hashSuite is a crypto suite for the tests, encrypting with a SHA-256 keystream
+// and authenticating with truncated HMAC-SHA512.
+type hashSuite struct{}
+
+func (hashSuite) Encrypt(key []byte, domain string, data []byte) ([]byte, error) {
+	enc := make([]byte, len(data))
+	var block []byte
+	for i := range data {
+		if i%sha256.Size == 0 {
+			var counter [8]byte
+			binary.BigEndian.PutUint64(counter[:], uint64(i/sha256.Size))
+			h := sha256.New()
+			h.Write(key)
+			h.Write([]byte(domain))
+			h.Write(counter[:])
+			block = h.Sum(nil)
+		}
+		enc[i] = data[i] ^ block[i%sha256.Size]
+	}
+	return enc, nil
+}
+
+func (hashSuite) MAC(key, data []byte) ([]byte, error) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:32], nil
+}
+
+// nolint: gochecknoglobals
+var registerTestSuite sync.Once
+
+func createSuiteTestPath(t *testing.T) (config.E2EPath, []*PrivateKey) {
+	registerTestSuite.Do(func() {
+		assert.Nil(t, RegisterSuite(testSuite, hashSuite{}))
+	})
+
+	var privs []*PrivateKey
+	var nodes []config.MixConfig
+	for i := 0; i < 3; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		privs = append(privs, priv)
+		nodes = append(nodes, config.NewMixConfig(fmt.Sprintf("Node%v", i), "localhost", "3330", pub.Bytes(), 1))
+	}
+	path := config.E2EPath{
+		IngressProvider: nodes[0],
+		Mixes:           nodes[1:2],
+		EgressProvider:  nodes[2],
+		Recipient:       config.ClientConfig{Id: "Recipient", Host: "localhost", Port: "3334"},
+	}
+	return path, privs
+}
+
+func TestRegisterSuite(t *testing.T) {
+	createSuiteTestPath(t)
+
+	assert.Equal(t, ErrSuiteRegistered, RegisterSuite(DefaultSuite, hashSuite{}))
+	assert.Equal(t, ErrSuiteRegistered, RegisterSuite(testSuite, hashSuite{}))
+
+	suite, err := LookupSuite(testSuite)
+	assert.Nil(t, err)
+	assert.Equal(t, hashSuite{}, suite)
+	_, err = LookupSuite(42)
+	assert.Equal(t, ErrUnsupportedSuite, err)
+}
+
+func TestPacker_Suite(t *testing.T) {
+	path, privs := createSuiteTestPath(t)
+	packer, err := NewPackerWithSuite(path, DefaultMaxDelay, time.Time{}, nil, testSuite)
+	assert.Nil(t, err)
+
+	message := []byte("Hello world")
+	packet, err := packer.Pack([]float64{0.1, 0.2, 0.3}, message)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(testSuite), packet.Hdr.Suite)
+
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	for _, priv := range privs[:2] {
+		_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, priv)
+		assert.Nil(t, err)
+
+		// the suite is kept for the next hops
+		var newPacket SphinxPacket
+		assert.Nil(t, proto.Unmarshal(packetBytes, &newPacket))
+		assert.Equal(t, uint32(testSuite), newPacket.Hdr.Suite)
+	}
+	_, _, packetBytes, err = ProcessSphinxPacket(packetBytes, privs[2])
+	assert.Nil(t, err)
+	assert.Equal(t, message, packetBytes)
+}
+
+func TestPacker_SuitesAreDistinct(t *testing.T) {
+	path, privs := createSuiteTestPath(t)
+	packer, err := NewPackerWithSuite(path, DefaultMaxDelay, time.Time{}, nil, testSuite)
+	assert.Nil(t, err)
+	packet, err := packer.Pack([]float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+
+	// the packet can't be processed with any other suite than the one it was created with
+	packet.Hdr.Suite = uint32(DefaultSuite)
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	_, _, _, err = ProcessSphinxPacket(packetBytes, privs[0])
+	assert.Equal(t, ErrInvalidMAC, err)
+}
+
+func TestProcessSphinxPacket_UnsupportedSuite(t *testing.T) {
+	path, privs := createSuiteTestPath(t)
+	packet, err := PackForwardMessage(path, []float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(DefaultSuite), packet.Hdr.Suite)
+
+	packet.Hdr.Suite = 42
+	packetBytes, err := proto.Marshal(&packet)
+	assert.Nil(t, err)
+	_, _, _, err = ProcessSphinxPacket(packetBytes, privs[0])
+	assert.Equal(t, ErrUnsupportedSuite, err)
+	assert.Equal(t, ErrUnsupportedSuite, VerifySphinxHeader(*packet.Hdr, privs[0]))
+
+	_, err = NewPackerWithSuite(path, DefaultMaxDelay, time.Time{}, nil, 42)
+	assert.Equal(t, ErrUnsupportedSuite, err)
+}
+
+func TestCodec_CompactUnsupportedSuite(t *testing.T) {
+	path, _ := createSuiteTestPath(t)
+	packer, err := NewPackerWithSuite(path, DefaultMaxDelay, time.Time{}, nil, testSuite)
+	assert.Nil(t, err)
+	packet, err := packer.Pack([]float64{0.1, 0.2, 0.3}, []byte("Hello world"))
+	assert.Nil(t, err)
+
+	_, err = CompactCodec.Marshal(&packet)
+	assert.Equal(t, ErrUnsupportedSuite, err)
+	_, err = ProtobufCodec.Marshal(&packet)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,347 @@
+// Copyright 2019 The Nym Mixnet Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sphinx
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nymtech/nym-mixnet/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// vectorsFile holds the known-answer vectors of the sphinx primitives and of a complete packet.
+// Any change of their outputs breaks the compatibility with the packets created by other implementations,
+// hence the vectors should only ever be regenerated, with -update-vectors, when that is intended.
+const vectorsFile = "testdata/vectors.json"
+
+//nolint: gochecknoglobals
+var updateVectors = flag.Bool("update-vectors", false, "regenerate the known-answer vectors in "+vectorsFile)
+
+// hexBytes is encoded in JSON as a hex string, rather than base64, so that the vectors are easier to compare
+// with the ones of other implementations.
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+type primitiveVector struct {
+	Key    hexBytes `json:"key"`
+	Input  hexBytes `json:"input,omitempty"`
+	Output hexBytes `json:"output"`
+}
+
+type nodeVector struct {
+	ID         string   `json:"id"`
+	Host       string   `json:"host"`
+	Port       string   `json:"port"`
+	PrivateKey hexBytes `json:"private_key"`
+	PublicKey  hexBytes `json:"public_key"`
+}
+
+type sharedSecretVector struct {
+	Alpha      hexBytes `json:"alpha"`
+	Secret     hexBytes `json:"secret"`
+	Blinder    hexBytes `json:"blinder"`
+	SecretHash hexBytes `json:"secret_hash"`
+}
+
+type processedHopVector struct {
+	NextHopID      string   `json:"next_hop_id"`
+	NextHopAddress string   `json:"next_hop_address"`
+	Delay          float64  `json:"delay"`
+	Flag           hexBytes `json:"flag"`
+	Expiry         int64    `json:"expiry"`
+	Packet         hexBytes `json:"packet"`
+}
+
+type packetVector struct {
+	InitialElement hexBytes             `json:"initial_element"`
+	Nodes          []nodeVector         `json:"nodes"`
+	RecipientID    string               `json:"recipient_id"`
+	Delays         []float64            `json:"delays"`
+	Expiry         int64                `json:"expiry"`
+	Message        hexBytes             `json:"message"`
+	SharedSecrets  []sharedSecretVector `json:"shared_secrets"`
+	Packet         hexBytes             `json:"packet"`
+	Hops           []processedHopVector `json:"hops"`
+}
+
+type testVectors struct {
+	KDF            []primitiveVector `json:"kdf"`
+	MAC            []primitiveVector `json:"mac"`
+	AesCtr         []primitiveVector `json:"aes_ctr"`
+	BlindingFactor []primitiveVector `json:"blinding_factor"`
+	Packet         packetVector      `json:"packet"`
+}
+
+// vectorBytes derives deterministic bytes of the given length from the label.
+func vectorBytes(label string, length int) []byte {
+	var b []byte
+	for i := 0; len(b) < length; i++ {
+		h, _ := hash([]byte(fmt.Sprintf("%v-%v", label, i)))
+		b = append(b, h...)
+	}
+	return b[:length]
+}
+
+// vectorInputs returns the inputs the vectors in vectorsFile were generated from.
+func vectorInputs() (testVectors, error) {
+	var inputs testVectors
+	for i, length := range []int{0, 1, FieldElementSize, 100} {
+		inputs.KDF = append(inputs.KDF, primitiveVector{Key: vectorBytes(fmt.Sprintf("kdf-%v", i), length)})
+		inputs.MAC = append(inputs.MAC, primitiveVector{
+			Key:   vectorBytes(fmt.Sprintf("mac-key-%v", i), K),
+			Input: vectorBytes(fmt.Sprintf("mac-data-%v", i), length),
+		})
+		inputs.AesCtr = append(inputs.AesCtr, primitiveVector{
+			Key:   vectorBytes(fmt.Sprintf("aes-key-%v", i), K),
+			Input: vectorBytes(fmt.Sprintf("aes-plaintext-%v", i), length),
+		})
+		inputs.BlindingFactor = append(inputs.BlindingFactor, primitiveVector{
+			Key: vectorBytes(fmt.Sprintf("blinding-key-%v", i), K),
+		})
+	}
+
+	packet := packetVector{
+		InitialElement: vectorBytes("initial-element", FieldElementSize),
+		RecipientID:    "Recipient",
+		Delays:         []float64{0.5, 1.25, 2, 0},
+		Expiry:         1600000000,
+		Message:        []byte("Hello world"),
+	}
+	for i, id := range []string{"IngressProvider", "Mix", "EgressProvider"} {
+		priv, pub, err := GenerateDeterministicKeyPair(vectorBytes(id, FieldElementSize))
+		if err != nil {
+			return testVectors{}, err
+		}
+		packet.Nodes = append(packet.Nodes, nodeVector{ID: id,
+			Host:       "127.0.0.1",
+			Port:       fmt.Sprintf("%v", 1789+i),
+			PrivateKey: priv.Bytes(),
+			PublicKey:  pub.Bytes(),
+		})
+	}
+	inputs.Packet = packet
+	return inputs, nil
+}
+
+// deriveVectors computes the outputs of the vectors from their inputs.
+func deriveVectors(inputs testVectors) (testVectors, error) {
+	derived := inputs
+	derived.KDF, derived.MAC, derived.AesCtr, derived.BlindingFactor = nil, nil, nil, nil
+	for _, v := range inputs.KDF {
+		out, err := KDF(v.Key)
+		if err != nil {
+			return testVectors{}, err
+		}
+		derived.KDF = append(derived.KDF, primitiveVector{Key: v.Key, Output: out})
+	}
+	for _, v := range inputs.MAC {
+		out, err := computeMac(v.Key, v.Input)
+		if err != nil {
+			return testVectors{}, err
+		}
+		derived.MAC = append(derived.MAC, primitiveVector{Key: v.Key, Input: v.Input, Output: out})
+	}
+	for _, v := range inputs.AesCtr {
+		out, err := AesCtr(v.Key, v.Input)
+		if err != nil {
+			return testVectors{}, err
+		}
+		derived.AesCtr = append(derived.AesCtr, primitiveVector{Key: v.Key, Input: v.Input, Output: out})
+	}
+	for _, v := range inputs.BlindingFactor {
+		out, err := computeBlindingFactor(v.Key)
+		if err != nil {
+			return testVectors{}, err
+		}
+		derived.BlindingFactor = append(derived.BlindingFactor, primitiveVector{Key: v.Key, Output: out.Bytes()})
+	}
+
+	packet, err := derivePacketVector(inputs.Packet)
+	if err != nil {
+		return testVectors{}, err
+	}
+	derived.Packet = packet
+	return derived, nil
+}
+
+// derivePacketVector packs the message over the path of the vector and then processes the packet
+// by each of the nodes in turn, recording all the intermediate results.
+func derivePacketVector(inputs packetVector) (packetVector, error) {
+	derived := inputs
+	derived.SharedSecrets, derived.Hops = nil, nil
+
+	nodes := make([]config.MixConfig, len(inputs.Nodes))
+	for i, n := range inputs.Nodes {
+		nodes[i] = config.MixConfig{Id: n.ID, Host: n.Host, Port: n.Port, PubKey: n.PublicKey}
+	}
+	path := config.E2EPath{IngressProvider: nodes[0],
+		Mixes:          nodes[1 : len(nodes)-1],
+		EgressProvider: nodes[len(nodes)-1],
+		Recipient:      config.ClientConfig{Id: inputs.RecipientID},
+	}
+	x := BytesToFieldElement(inputs.InitialElement)
+
+	sharedSecrets, err := getSharedSecrets(nodes, x)
+	if err != nil {
+		return packetVector{}, err
+	}
+	for _, s := range sharedSecrets {
+		derived.SharedSecrets = append(derived.SharedSecrets, sharedSecretVector{Alpha: s.Alpha,
+			Secret:     s.Secret,
+			Blinder:    s.Blinder,
+			SecretHash: s.SecretHash,
+		})
+	}
+
+	sphinxPacket, err := packForwardMessage(path,
+		inputs.Delays,
+		inputs.Message,
+		DefaultMaxDelay,
+		time.Unix(inputs.Expiry, 0),
+		nil,
+		x,
+	)
+	if err != nil {
+		return packetVector{}, err
+	}
+	packetBytes, err := proto.Marshal(&sphinxPacket)
+	if err != nil {
+		return packetVector{}, err
+	}
+	derived.Packet = packetBytes
+
+	for _, n := range inputs.Nodes {
+		hop, commands, processed, err := ProcessSphinxPacket(packetBytes, BytesToPrivateKey(n.PrivateKey))
+		if err != nil {
+			return packetVector{}, fmt.Errorf("processing by %v failed: %v", n.ID, err)
+		}
+		derived.Hops = append(derived.Hops, processedHopVector{NextHopID: hop.Id,
+			NextHopAddress: hop.Address,
+			Delay:          commands.Delay,
+			Flag:           commands.Flag,
+			Expiry:         commands.Expiry,
+			Packet:         processed,
+		})
+		packetBytes = processed
+	}
+	return derived, nil
+}
+
+func TestKnownAnswerVectors(t *testing.T) {
+	if *updateVectors {
+		inputs, err := vectorInputs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vectors, err := deriveVectors(inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(vectorsFile, append(encoded, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encoded, err := ioutil.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected testVectors
+	if err := json.Unmarshal(encoded, &expected); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := deriveVectors(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected.KDF, actual.KDF, "KDF outputs have changed")
+	assert.Equal(t, expected.MAC, actual.MAC, "MAC outputs have changed")
+	assert.Equal(t, expected.AesCtr, actual.AesCtr, "AES-CTR outputs have changed")
+	assert.Equal(t, expected.BlindingFactor, actual.BlindingFactor, "Blinding factors have changed")
+	assert.Equal(t, expected.Packet.SharedSecrets, actual.Packet.SharedSecrets, "Shared secrets have changed")
+	assert.Equal(t, expected.Packet.Packet, actual.Packet.Packet, "Packed packet has changed")
+	assert.Equal(t, expected.Packet.Hops, actual.Packet.Hops, "Processing of the packet has changed")
+}
+
+func TestKnownAnswerVectors_Packet(t *testing.T) {
+	encoded, err := ioutil.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors testVectors
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorded packet, rather than a freshly packed one, is processed, as other implementations would
+	packet := vectors.Packet
+	if !assert.Len(t, packet.Hops, len(packet.Nodes)) {
+		return
+	}
+	packetBytes := []byte(packet.Packet)
+	for i, n := range packet.Nodes {
+		priv := BytesToPrivateKey(n.PrivateKey)
+		var sphinxPacket SphinxPacket
+		if err := proto.Unmarshal(packetBytes, &sphinxPacket); err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, VerifySphinxHeader(*sphinxPacket.Hdr, priv), "MAC of the header for %v is invalid", n.ID)
+
+		hop, commands, processed, err := ProcessSphinxPacket(packetBytes, priv)
+		if !assert.Nil(t, err) {
+			return
+		}
+		expected := packet.Hops[i]
+		assert.Equal(t, expected.NextHopID, hop.Id)
+		assert.Equal(t, expected.NextHopAddress, hop.Address)
+		assert.Equal(t, expected.Delay, commands.Delay)
+		assert.Equal(t, expected.Expiry, commands.Expiry)
+		assert.Equal(t, []byte(expected.Flag), commands.Flag)
+		assert.Equal(t, []byte(expected.Packet), processed)
+		packetBytes = processed
+	}
+
+	// the last hop yields the message itself
+	assert.Equal(t, []byte(packet.Message), packetBytes)
+	assert.Equal(t, packet.RecipientID, packet.Hops[len(packet.Hops)-1].NextHopID)
+}